@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"fmt"
+	"os"
+
+	"github.com/aspect-build/jingui/internal/server"
+	"github.com/spf13/cobra"
+)
+
+func newAuditCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "audit",
+		Short: "Verify the audit log",
+		Long: `Verify the audit log.
+
+Every audit event carries the SHA-256 hash of its contents chained to the hash
+of the event before it, and the server signs the newest hash every
+JINGUI_AUDIT_SIGN_INTERVAL with a key derived from the master key. Deleting,
+inserting or editing events breaks the chain, and rewriting the whole chain
+does not match the signed heads.`,
+		Args: cobra.NoArgs,
+	}
+
+	var trustedKeys []string
+	verifyCmd := &cobra.Command{
+		Use:   "verify",
+		Short: "Check the audit log for gaps and modifications",
+		Long: `Check the audit log for gaps and modifications.
+
+Signed heads are trusted when they are signed by the key of the active or a
+previous master key, or by a key passed with --trusted-key. Exits non-zero
+when any problem is found.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return verifyAudit(cmd.Context(), trustedKeys)
+		},
+	}
+	verifyCmd.Flags().StringArrayVar(&trustedKeys, "trusted-key", nil, "Additional trusted signing public key, 64 hex chars (repeatable)")
+	cmd.AddCommand(verifyCmd)
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "public-key",
+		Short: "Print the public key that signs audit heads",
+		Long: `Print the public key that signs audit heads. Keep a copy outside the
+server to check signed heads with 'jingui-server audit verify --trusted-key'
+after the master key is rotated.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := server.LoadStoreConfig()
+			if err != nil {
+				return fmt.Errorf("load config: %w", err)
+			}
+			key, _, err := server.LoadAuditKeys(cmd.Context(), cfg)
+			if err != nil {
+				return err
+			}
+			fmt.Println(hex.EncodeToString(key.Public().(ed25519.PublicKey)))
+			return nil
+		},
+	})
+
+	return cmd
+}
+
+func verifyAudit(ctx context.Context, trustedKeys []string) error {
+	cfg, err := server.LoadStoreConfig()
+	if err != nil {
+		return fmt.Errorf("load config: %w", err)
+	}
+	_, trusted, err := server.LoadAuditKeys(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	for _, v := range trustedKeys {
+		key, err := hex.DecodeString(v)
+		if err != nil || len(key) != ed25519.PublicKeySize {
+			return fmt.Errorf("--trusted-key %q must be 64 hex chars", v)
+		}
+		trusted = append(trusted, ed25519.PublicKey(key))
+	}
+
+	store, err := server.OpenSQLStore(ctx, cfg)
+	if err != nil {
+		return fmt.Errorf("open database: %w", err)
+	}
+	defer store.Close()
+
+	report, err := server.VerifyAuditLog(store, trusted)
+	if err != nil {
+		return err
+	}
+	if report.Events == 0 {
+		fmt.Fprintln(os.Stderr, "audit log is empty")
+	} else {
+		fmt.Fprintf(os.Stderr, "events:           %d (%d to %d)\n", report.Events, report.FirstID, report.LastID)
+		fmt.Fprintf(os.Stderr, "signed heads:     %d\n", report.Signatures)
+		if report.LastSigned == 0 {
+			fmt.Fprintln(os.Stderr, "last signed head: none")
+		} else {
+			fmt.Fprintf(os.Stderr, "last signed head: %d (%d events after it)\n", report.LastSigned, report.LastID-report.LastSigned)
+		}
+	}
+	for _, p := range report.Problems {
+		fmt.Println(p)
+	}
+	if !report.OK() {
+		return fmt.Errorf("audit log verification failed: %d problem(s)", len(report.Problems))
+	}
+	fmt.Fprintln(os.Stderr, "audit log is intact")
+	return nil
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/aspect-build/jingui/internal/server"
+	"github.com/aspect-build/jingui/internal/server/backup"
+	"github.com/spf13/cobra"
+)
+
+func newBackupCmd() *cobra.Command {
+	var (
+		output    string
+		publicKey string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "backup",
+		Short: "Write an encrypted backup of the database",
+		Long: `Write an encrypted backup of the database.
+
+The backup is a consistent snapshot of vaults, fields and their history,
+instances, grants and debug policies, taken in a single read transaction, so
+it is safe to run against a live server. Values are decrypted with the master
+key and the whole snapshot is encrypted to the backup public key; only the
+matching private key can restore it. Create a key pair with
+'jingui-server backup keygen' and keep the private key offline.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return writeBackup(cmd.Context(), output, publicKey)
+		},
+	}
+
+	cmd.Flags().StringVarP(&output, "output", "o", "", `Backup file to write, or "-" for stdout (default: jingui-<time>.backup)`)
+	cmd.Flags().StringVar(&publicKey, "public-key", "", "Backup public key, 64 hex chars (default: JINGUI_BACKUP_PUBLIC_KEY)")
+
+	var privateKeyFile string
+	keygenCmd := &cobra.Command{
+		Use:   "keygen",
+		Short: "Generate a backup key pair",
+		Long: `Generate a backup key pair. The private key is written to --private-key-file
+and the public key is printed; set it as JINGUI_BACKUP_PUBLIC_KEY.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			priv, pub, err := backup.GenerateKey()
+			if err != nil {
+				return err
+			}
+			f, err := os.OpenFile(privateKeyFile, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+			if err != nil {
+				return fmt.Errorf("write private key: %w", err)
+			}
+			if _, err := fmt.Fprintln(f, hex.EncodeToString(priv[:])); err != nil {
+				f.Close()
+				return fmt.Errorf("write private key: %w", err)
+			}
+			if err := f.Close(); err != nil {
+				return fmt.Errorf("write private key: %w", err)
+			}
+			fmt.Println(hex.EncodeToString(pub[:]))
+			return nil
+		},
+	}
+	keygenCmd.Flags().StringVar(&privateKeyFile, "private-key-file", "", "File to write the private key to (must not exist)")
+	keygenCmd.MarkFlagRequired("private-key-file")
+	cmd.AddCommand(keygenCmd)
+
+	return cmd
+}
+
+func writeBackup(ctx context.Context, output, publicKey string) error {
+	if publicKey == "" {
+		publicKey = os.Getenv("JINGUI_BACKUP_PUBLIC_KEY")
+	}
+	if publicKey == "" {
+		return fmt.Errorf("no backup public key: pass --public-key or set JINGUI_BACKUP_PUBLIC_KEY")
+	}
+	recipient, err := backup.ParseKey(publicKey)
+	if err != nil {
+		return err
+	}
+
+	cfg, err := server.LoadStoreConfig()
+	if err != nil {
+		return fmt.Errorf("load config: %w", err)
+	}
+	store, err := server.OpenSQLStore(ctx, cfg)
+	if err != nil {
+		return fmt.Errorf("open database: %w", err)
+	}
+	defer store.Close()
+
+	snap, err := store.Snapshot()
+	if err != nil {
+		return err
+	}
+	data, manifest, err := backup.Seal(snap, recipient)
+	if err != nil {
+		return err
+	}
+
+	if output == "" {
+		output = "jingui-" + manifest.CreatedAt.Format("20060102-150405") + ".backup"
+	}
+	if output == "-" {
+		if _, err := os.Stdout.Write(data); err != nil {
+			return fmt.Errorf("write backup: %w", err)
+		}
+	} else if err := os.WriteFile(output, data, 0o600); err != nil {
+		return fmt.Errorf("write backup: %w", err)
+	}
+
+	printManifest(os.Stderr, manifest)
+	if output != "-" {
+		fmt.Fprintf(os.Stderr, "wrote %s\n", output)
+	}
+	return nil
+}
+
+func newRestoreCmd() *cobra.Command {
+	var (
+		privateKeyFile string
+		checkOnly      bool
+		force          bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "restore <backup-file>",
+		Short: "Restore the database from an encrypted backup",
+		Long: `Restore the database from an encrypted backup.
+
+The backup is decrypted and fully verified first: its checksum, its manifest
+and the consistency of the snapshot are checked, and backups written by a
+newer schema than this release are refused. Only then is the current content
+of the database replaced, in a single transaction. Values are re-encrypted
+under the active master key, which need not be the key the backup was taken
+with. Restoring over a database that already holds vaults or instances
+requires --force.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return restoreBackup(cmd.Context(), args[0], privateKeyFile, checkOnly, force)
+		},
+	}
+
+	cmd.Flags().StringVar(&privateKeyFile, "private-key-file", "", "File holding the backup private key")
+	cmd.Flags().BoolVar(&checkOnly, "check", false, "Verify the backup without restoring it")
+	cmd.Flags().BoolVar(&force, "force", false, "Replace a database that is not empty")
+	cmd.MarkFlagRequired("private-key-file")
+
+	return cmd
+}
+
+func restoreBackup(ctx context.Context, path, privateKeyFile string, checkOnly, force bool) error {
+	rawKey, err := os.ReadFile(privateKeyFile)
+	if err != nil {
+		return fmt.Errorf("read private key: %w", err)
+	}
+	privateKey, err := backup.ParseKey(string(rawKey))
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read backup: %w", err)
+	}
+
+	manifest, snap, err := backup.Open(data, privateKey)
+	if err != nil {
+		return err
+	}
+	printManifest(os.Stderr, manifest)
+	if checkOnly {
+		fmt.Fprintln(os.Stderr, "backup is valid")
+		return nil
+	}
+
+	cfg, err := server.LoadStoreConfig()
+	if err != nil {
+		return fmt.Errorf("load config: %w", err)
+	}
+	store, err := server.OpenSQLStore(ctx, cfg)
+	if err != nil {
+		return fmt.Errorf("open database: %w", err)
+	}
+	defer store.Close()
+
+	if !force {
+		vaults, err := store.ListVaults()
+		if err != nil {
+			return err
+		}
+		instances, err := store.ListInstances()
+		if err != nil {
+			return err
+		}
+		if len(vaults) > 0 || len(instances) > 0 {
+			return fmt.Errorf("database holds %d vault(s) and %d instance(s); pass --force to replace them", len(vaults), len(instances))
+		}
+	}
+
+	if err := store.Restore(snap); err != nil {
+		return fmt.Errorf("restore: %w", err)
+	}
+	fmt.Fprintln(os.Stderr, "restore complete")
+	return nil
+}
+
+func printManifest(w io.Writer, m *backup.Manifest) {
+	fmt.Fprintf(w, "backup taken %s, schema version %d, sha256 %s\n",
+		m.CreatedAt.UTC().Format(time.DateTime), m.SchemaVersion, m.SHA256)
+	fmt.Fprintf(w, "  %d vault(s), %d field(s), %d version(s), %d expiry date(s), %d instance(s), %d instance alias(es), %d grant(s), %d field grant(s), %d app grant(s), %d debug policies, %d enrollment policies, %d enrollment token(s), %d measurement policies\n",
+		m.Counts.Vaults, m.Counts.Fields, m.Counts.Versions, m.Counts.Expiries, m.Counts.Instances, m.Counts.InstanceAliases, m.Counts.Grants, m.Counts.FieldGrants, m.Counts.AppGrants, m.Counts.DebugPolicies, m.Counts.EnrollmentPolicies, m.Counts.EnrollmentTokens, m.Counts.MeasurementPolicies)
+}
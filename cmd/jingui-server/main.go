@@ -1,62 +1,131 @@
 package main
 
 import (
-	"flag"
+	"context"
 	"fmt"
 	"log"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/aspect-build/jingui/internal/logx"
 	"github.com/aspect-build/jingui/internal/server"
 	"github.com/aspect-build/jingui/internal/server/db"
+	"github.com/aspect-build/jingui/internal/server/eventsink"
 	"github.com/aspect-build/jingui/internal/version"
+	"github.com/spf13/cobra"
 )
 
+const envHelp = `Environment variables:
+  JINGUI_ADMIN_TOKEN             Admin Bearer token for management APIs (min 16 chars, required)
+  JINGUI_MASTER_KEY              At-rest master key, 64 hex chars
+  JINGUI_MASTER_KEY_FILE         File containing the master key, as an alternative to JINGUI_MASTER_KEY
+  JINGUI_MASTER_KEY_PROVIDER     Master key source: env|file|dstack (default: inferred from the two above)
+  JINGUI_MASTER_KEY_DSTACK_PATH  dstack GetKey path for the dstack provider (default: jingui-server/master-key)
+  JINGUI_MASTER_KEY_PREVIOUS     Comma-separated previous master keys, only needed during a key rotation
+  JINGUI_DB_PATH                 SQLite database path, or :memory: for a throwaway in-memory store (default: jingui.db)
+  JINGUI_DB_URL                  PostgreSQL connection URL; overrides JINGUI_DB_PATH when set
+  JINGUI_LISTEN_ADDR             Listen address (default: :8080)
+  JINGUI_RATLS_STRICT            Enforce strict RA-TLS mode for secret fetch flow (default: true)
+  JINGUI_BACKUP_PUBLIC_KEY       X25519 public key backups are encrypted to, 64 hex chars (enables GET /v1/backup)
+  JINGUI_TRASH_RETENTION         How long deleted vaults, sections and instances can be restored (default: 720h)
+  JINGUI_AUDIT_SIGN_INTERVAL     How often the head of the audit log is signed (default: 10m)
+  JINGUI_EVENT_SINKS             Comma-separated event sink URLs: syslog+udp://, syslog+tcp://, file://, http(s)://
+  JINGUI_EVENT_BUFFER            Events buffered per sink before publishing blocks and drops (default: 1000)
+  JINGUI_LOG_LEVEL               Log level for server logs: debug|info|warn|error (default: info)`
+
 func main() {
-	showVersion := flag.Bool("version", false, "Print version and exit")
-	verbose := flag.Bool("verbose", false, "Enable verbose debug logs (same as --log-level debug)")
-	logLevel := flag.String("log-level", "", "Log level: debug|info|warn|error (or JINGUI_LOG_LEVEL)")
-	flag.BoolVar(showVersion, "v", false, "Print version and exit")
-	flag.Usage = func() {
-		fmt.Fprintf(os.Stderr, "%s\n\n", version.String("jingui-server"))
-		fmt.Fprintf(os.Stderr, "Jingui server stores vault secrets and serves encrypted values to TEE instances.\n\n")
-		fmt.Fprintf(os.Stderr, "Environment variables:\n")
-		fmt.Fprintf(os.Stderr, "  JINGUI_ADMIN_TOKEN  Admin Bearer token for management APIs (min 16 chars, required)\n")
-		fmt.Fprintf(os.Stderr, "  JINGUI_DB_PATH      SQLite database path (default: jingui.db)\n")
-		fmt.Fprintf(os.Stderr, "  JINGUI_LISTEN_ADDR  Listen address (default: :8080)\n")
-		fmt.Fprintf(os.Stderr, "  JINGUI_RATLS_STRICT Enforce strict RA-TLS mode for secret fetch flow (default: true)\n")
-		fmt.Fprintf(os.Stderr, "  JINGUI_LOG_LEVEL   Log level for server logs: debug|info|warn|error (default: info)\n")
-		fmt.Fprintf(os.Stderr, "\nFlags:\n")
-		flag.PrintDefaults()
-	}
-	flag.Parse()
+	var (
+		verbose  bool
+		logLevel string
+	)
 
-	if *showVersion {
-		fmt.Println(version.String("jingui-server"))
-		os.Exit(0)
+	rootCmd := &cobra.Command{
+		Use:   "jingui-server",
+		Short: "Jingui server stores vault secrets and serves encrypted values to TEE instances",
+		Long: `Jingui server stores vault secrets and serves encrypted values to TEE instances.
+Run without a subcommand to start the HTTP server.
+
+` + envHelp,
+		Version:      version.Version,
+		SilenceUsage: true,
+		Args:         cobra.NoArgs,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			if cmd.Name() == "completion" {
+				return nil
+			}
+			return logx.Configure(logLevel, verbose)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runServer(cmd.Context())
+		},
 	}
+	rootCmd.SetVersionTemplate(version.String("jingui-server") + "\n")
+	rootCmd.PersistentFlags().BoolVar(&verbose, "verbose", false, "Enable verbose debug logs (same as --log-level debug)")
+	rootCmd.PersistentFlags().StringVar(&logLevel, "log-level", "", "Log level: debug|info|warn|error (or JINGUI_LOG_LEVEL)")
+
+	rootCmd.AddCommand(newRotateMasterKeyCmd())
+	rootCmd.AddCommand(newMigrateCmd())
+	rootCmd.AddCommand(newBackupCmd())
+	rootCmd.AddCommand(newRestoreCmd())
+	rootCmd.AddCommand(newAuditCmd())
 
-	if err := logx.Configure(*logLevel, *verbose); err != nil {
-		log.Fatalf("configure logging: %v", err)
+	if err := rootCmd.Execute(); err != nil {
+		os.Exit(1)
 	}
+}
 
+func runServer(ctx context.Context) error {
 	cfg, err := server.LoadConfig()
 	if err != nil {
-		log.Fatalf("load config: %v", err)
+		return fmt.Errorf("load config: %w", err)
 	}
 
-	store, err := db.NewStore(cfg.DBPath)
+	store, err := server.OpenStore(ctx, &cfg.StoreConfig)
 	if err != nil {
-		log.Fatalf("open database: %v", err)
+		return fmt.Errorf("open database: %w", err)
 	}
 	defer store.Close()
 
-	r := server.NewRouter(store, cfg)
+	if sqlStore, ok := store.(*db.SQLStore); !ok {
+		log.Printf("WARNING: JINGUI_DB_PATH=%s keeps all data in memory; it is lost when the server stops", server.MemoryDBPath)
+	} else if pending, err := sqlStore.PendingRewrap(); err != nil {
+		return err
+	} else if pending > 0 {
+		log.Printf("WARNING: %d values are still wrapped by a previous master key; run 'jingui-server rotate-master-key'", pending)
+	}
+
+	auditKey, _, err := server.LoadAuditKeys(ctx, &cfg.StoreConfig)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go server.PurgeTrashLoop(ctx, store, cfg.TrashRetention)
+	go server.SignAuditHeadLoop(ctx, store, auditKey, cfg.AuditSignInterval)
+
+	sinks, err := eventsink.New(cfg.EventSinks, cfg.EventBuffer)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		sinks.Close(ctx)
+	}()
+
+	r := server.NewRouter(store, cfg, sinks)
 	log.Print(version.String("jingui-server"))
-	logx.Infof("server config: ratls_strict=%v", cfg.RATLSStrict)
+	logx.Infof("server config: ratls_strict=%v master_key=%s trash_retention=%s audit_sign_interval=%s", cfg.RATLSStrict, cfg.KeyProvider.Name(), cfg.TrashRetention, cfg.AuditSignInterval)
+
+	if names := sinks.Sinks(); len(names) > 0 {
+		logx.Infof("event sinks: %s", strings.Join(names, ", "))
+	}
 
 	log.Printf("jingui-server listening on %s", cfg.ListenAddr)
 	if err := r.Run(cfg.ListenAddr); err != nil {
-		log.Fatalf("server error: %v", err)
+		return fmt.Errorf("server error: %w", err)
 	}
+	return nil
 }
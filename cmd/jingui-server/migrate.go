@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/aspect-build/jingui/internal/server"
+	"github.com/aspect-build/jingui/internal/server/db"
+	"github.com/spf13/cobra"
+)
+
+func newMigrateCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "migrate",
+		Short: "Inspect or change the database schema version",
+		Long: `Inspect or change the database schema version.
+
+The server applies pending migrations automatically on start and refuses to
+start when the database was migrated by a newer release. Use these commands
+to check the state of a database, migrate it ahead of a deployment, or revert
+migrations before rolling back to an older release. Each migration runs in
+its own transaction.`,
+		Args: cobra.NoArgs,
+	}
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "status",
+		Short: "Show applied and pending migrations",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return withMigrationStore(cmd.Context(), printMigrationStatus)
+		},
+	})
+
+	var upTo int
+	upCmd := &cobra.Command{
+		Use:   "up",
+		Short: "Apply pending migrations",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return withMigrationStore(cmd.Context(), func(store *db.SQLStore) error {
+				target := upTo
+				if target == 0 {
+					target = db.LatestSchemaVersion()
+				}
+				n, err := store.MigrateUp(target)
+				fmt.Fprintf(os.Stderr, "applied %d migration(s)\n", n)
+				if err != nil {
+					return err
+				}
+				return printMigrationStatus(store)
+			})
+		},
+	}
+	upCmd.Flags().IntVar(&upTo, "to", 0, "Target schema version (default: latest)")
+	cmd.AddCommand(upCmd)
+
+	var downTo int
+	downCmd := &cobra.Command{
+		Use:   "down",
+		Short: "Revert applied migrations",
+		Long: `Revert applied migrations, newest first. Without --to only the most recent
+migration is reverted. Stop the server before running this: a running server
+would migrate the database up again on its next start.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return withMigrationStore(cmd.Context(), func(store *db.SQLStore) error {
+				target := downTo
+				if !cmd.Flags().Changed("to") {
+					current, err := store.SchemaVersion()
+					if err != nil {
+						return err
+					}
+					target = current - 1
+				}
+				n, err := store.MigrateDown(target)
+				fmt.Fprintf(os.Stderr, "reverted %d migration(s)\n", n)
+				if err != nil {
+					return err
+				}
+				return printMigrationStatus(store)
+			})
+		},
+	}
+	downCmd.Flags().IntVar(&downTo, "to", 0, "Target schema version (default: one before the current version)")
+	cmd.AddCommand(downCmd)
+
+	return cmd
+}
+
+func withMigrationStore(ctx context.Context, fn func(*db.SQLStore) error) error {
+	cfg, err := server.LoadStoreConfig()
+	if err != nil {
+		return fmt.Errorf("load config: %w", err)
+	}
+
+	store, err := server.OpenStoreForMigration(ctx, cfg)
+	if err != nil {
+		return fmt.Errorf("open database: %w", err)
+	}
+	defer store.Close()
+
+	return fn(store)
+}
+
+func printMigrationStatus(store *db.SQLStore) error {
+	migrations, err := store.MigrationStatus()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%-8s %-20s %-8s  %s\n", "VERSION", "NAME", "STATE", "APPLIED")
+	for _, m := range migrations {
+		name, state, applied := m.Name, "pending", ""
+		if name == "" {
+			name = "(unknown)"
+		}
+		if m.AppliedAt != nil {
+			state = "applied"
+			applied = m.AppliedAt.UTC().Format("2006-01-02 15:04:05")
+		}
+		fmt.Printf("%-8d %-20s %-8s  %s\n", m.Version, name, state, applied)
+	}
+	return nil
+}
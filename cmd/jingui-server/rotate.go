@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/aspect-build/jingui/internal/server"
+	"github.com/spf13/cobra"
+)
+
+func newRotateMasterKeyCmd() *cobra.Command {
+	var (
+		batchSize  int
+		statusOnly bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "rotate-master-key",
+		Short: "Re-wrap stored data keys with the active master key",
+		Long: `Re-wrap every data key that is still wrapped by a previous master key so
+that it is wrapped by the active one.
+
+To rotate, restart the server with the new key in JINGUI_MASTER_KEY and the
+old key in JINGUI_MASTER_KEY_PREVIOUS, then run this command with the same
+environment. Rows are processed in batches that commit independently, so the
+command can be interrupted and re-run safely. Once it reports that no rows
+remain, the previous key is retired and can be removed from the configuration.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return rotateMasterKey(cmd.Context(), batchSize, statusOnly)
+		},
+	}
+
+	cmd.Flags().IntVar(&batchSize, "batch-size", 500, "Number of rows to re-wrap per transaction")
+	cmd.Flags().BoolVar(&statusOnly, "status", false, "Show keyring status without re-wrapping")
+
+	return cmd
+}
+
+func rotateMasterKey(ctx context.Context, batchSize int, statusOnly bool) error {
+	if batchSize <= 0 {
+		return fmt.Errorf("--batch-size must be positive")
+	}
+
+	cfg, err := server.LoadStoreConfig()
+	if err != nil {
+		return fmt.Errorf("load config: %w", err)
+	}
+
+	store, err := server.OpenSQLStore(ctx, cfg)
+	if err != nil {
+		return fmt.Errorf("open database: %w", err)
+	}
+	defer store.Close()
+
+	if !statusOnly {
+		pending, err := store.PendingRewrap()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "active master key %s, %d rows to re-wrap\n", store.ActiveMasterKeyID(), pending)
+
+		done := 0
+		for {
+			n, err := store.RewrapBatch(batchSize)
+			if err != nil {
+				return fmt.Errorf("re-wrap after %d rows: %w", done, err)
+			}
+			if n == 0 {
+				break
+			}
+			done += n
+			fmt.Fprintf(os.Stderr, "re-wrapped %d/%d rows\n", done, pending)
+		}
+
+		retired, err := store.RetireMasterKeys()
+		if err != nil {
+			return err
+		}
+		for _, id := range retired {
+			fmt.Fprintf(os.Stderr, "retired master key %s\n", id)
+		}
+	}
+
+	keys, err := store.ListMasterKeys()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%-18s %-8s %8s  %s\n", "KEY ID", "STATE", "ROWS", "CREATED")
+	for _, k := range keys {
+		state := "previous"
+		switch {
+		case k.Active:
+			state = "active"
+		case k.RetiredAt != nil:
+			state = "retired"
+		}
+		fmt.Printf("%-18s %-8s %8d  %s\n", k.ID, state, k.Rows, k.CreatedAt.UTC().Format("2006-01-02 15:04:05"))
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/aspect-build/jingui/internal/client"
+	"github.com/aspect-build/jingui/internal/importer"
+	"github.com/spf13/cobra"
+)
+
+// resolveAdminToken returns the admin token from the flag or JINGUI_ADMIN_TOKEN.
+func resolveAdminToken(flagValue string) (string, error) {
+	if flagValue != "" {
+		return flagValue, nil
+	}
+	if v := os.Getenv("JINGUI_ADMIN_TOKEN"); v != "" {
+		return v, nil
+	}
+	return "", fmt.Errorf("admin token required: use --admin-token flag or set JINGUI_ADMIN_TOKEN")
+}
+
+// importResult is the response of POST /v1/vaults/:id/import.
+type importResult struct {
+	Changes []importer.Change `json:"changes"`
+	Summary map[string]int    `json:"summary"`
+}
+
+func newImportCmd() *cobra.Command {
+	var (
+		serverURL  string
+		adminToken string
+		actor      string
+		insecure   bool
+		vault      string
+		item       string
+		section    string
+		format     string
+		onConflict string
+		dryRun     bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "import <file>",
+		Short: "Import secrets from a dotenv, JSON or 1Password export into a vault",
+		Long: `Import secrets from a dotenv, JSON or 1Password export into a vault.
+
+Formats (detected from the file unless --format is given):
+  dotenv     KEY=VALUE lines, imported as fields of --item (and --section)
+  json       {"<item>": {"<field>": "v", "<section>": {"<field>": "v"}}},
+             or a flat {"<field>": "v"} body with --item
+  1password  'op item get --format json' output, or a .1pux export
+
+The import is applied in a single transaction. Fields that already exist with
+a different value are a conflict: by default the import is refused, or they
+can be skipped or overwritten with --on-conflict. Use --dry-run to see what
+would change. Values already written as jingui:// references are left out.
+
+Runs outside the TEE with the admin token.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			resolved, err := resolveServerURL(cmd, serverURL)
+			if err != nil {
+				return err
+			}
+			token, err := resolveAdminToken(adminToken)
+			if err != nil {
+				return err
+			}
+			admin := &client.AdminClient{ServerURL: resolved, Token: token, Actor: actor, AllowInsecure: insecure}
+			return importFile(admin, args[0], vault, item, section, format, onConflict, dryRun)
+		},
+	}
+
+	cmd.Flags().StringVar(&serverURL, "server", "", "Jingui server URL (or set JINGUI_SERVER_URL)")
+	cmd.Flags().StringVar(&adminToken, "admin-token", "", "Admin token (or set JINGUI_ADMIN_TOKEN)")
+	cmd.Flags().StringVar(&actor, "actor", "", "Name recorded as the author of imported versions")
+	cmd.Flags().BoolVar(&insecure, "insecure", false, "Allow plaintext HTTP connection to server")
+	cmd.Flags().StringVar(&vault, "vault", "", "Vault to import into")
+	cmd.Flags().StringVar(&item, "item", "", "Item to import into (required for dotenv)")
+	cmd.Flags().StringVar(&section, "section", "", "Section to import into (default section if empty)")
+	cmd.Flags().StringVar(&format, "format", "auto", "Input format: auto|dotenv|json|1password")
+	cmd.Flags().StringVar(&onConflict, "on-conflict", importer.ConflictFail, "What to do with fields that already differ: fail|skip|overwrite")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Show what would change without writing")
+	cmd.MarkFlagRequired("vault")
+
+	return cmd
+}
+
+func importFile(admin *client.AdminClient, path, vault, item, section, format, onConflict string, dryRun bool) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read import file: %w", err)
+	}
+	if format == "auto" {
+		format = importer.DetectFormat(path, data)
+	}
+	if strings.EqualFold(filepath.Ext(path), ".1pux") {
+		if data, err = read1PuxExport(data); err != nil {
+			return err
+		}
+	}
+
+	req := map[string]any{
+		"format":      format,
+		"content":     string(data),
+		"item":        item,
+		"section":     section,
+		"on_conflict": onConflict,
+		"dry_run":     dryRun,
+	}
+	var result importResult
+	err = admin.Do(http.MethodPost, "/v1/vaults/"+url.PathEscape(vault)+"/import", req, &result)
+	var adminErr *client.AdminError
+	if errors.As(err, &adminErr) && adminErr.StatusCode == http.StatusConflict {
+		if json.Unmarshal(adminErr.Body, &result) == nil {
+			printImportChanges(os.Stdout, result)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("import: %w", err)
+	}
+
+	printImportChanges(os.Stdout, result)
+	if dryRun {
+		fmt.Fprintln(os.Stderr, "dry run: nothing was written")
+	}
+	return nil
+}
+
+// read1PuxExport returns the export.data document from a .1pux archive.
+func read1PuxExport(data []byte) ([]byte, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("open .1pux archive: %w", err)
+	}
+	f, err := zr.Open("export.data")
+	if err != nil {
+		return nil, fmt.Errorf("open .1pux archive: %w", err)
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+func printImportChanges(w io.Writer, result importResult) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ACTION\tITEM\tSECTION\tFIELD")
+	for _, c := range result.Changes {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", c.Action, c.Item, c.Section, c.Field)
+	}
+	tw.Flush()
+
+	var parts []string
+	for _, action := range []string{importer.ActionCreate, importer.ActionUpdate, importer.ActionUnchanged, importer.ActionSkip, importer.ActionConflict} {
+		if n := result.Summary[action]; n > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", n, action))
+		}
+	}
+	fmt.Fprintf(w, "\n%s\n", strings.Join(parts, ", "))
+}
@@ -7,10 +7,12 @@ import (
 	"fmt"
 	"os"
 	"runtime"
+	"strings"
 
 	"github.com/aspect-build/jingui/internal/attestation"
 	"github.com/aspect-build/jingui/internal/client"
 	"github.com/aspect-build/jingui/internal/crypto"
+	"github.com/aspect-build/jingui/internal/dotenv"
 	"github.com/aspect-build/jingui/internal/logx"
 	"github.com/aspect-build/jingui/internal/refparser"
 	"github.com/aspect-build/jingui/internal/version"
@@ -65,7 +67,12 @@ func main() {
 	rootCmd.AddCommand(newRunCmd())
 	rootCmd.AddCommand(newReadCmd())
 	rootCmd.AddCommand(newStatusCmd())
+	rootCmd.AddCommand(newEnrollCmd())
+	rootCmd.AddCommand(newRegisterCmd())
+	rootCmd.AddCommand(newRekeyCmd())
 	rootCmd.AddCommand(newExecCmd())
+	rootCmd.AddCommand(newImportCmd())
+	rootCmd.AddCommand(newMigrateEnvCmd())
 
 	if err := rootCmd.Execute(); err != nil {
 		os.Exit(1)
@@ -164,6 +171,117 @@ func newStatusCmd() *cobra.Command {
 	return cmd
 }
 
+func newEnrollCmd() *cobra.Command {
+	var (
+		serverURL   string
+		appkeysPath string
+		insecure    bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "enroll",
+		Short: "Register this instance with the server by attestation",
+		Long: `Register this instance's public key with the jingui server without the
+admin token. The key is bound to a dstack RA-TLS attestation; the server
+accepts it if an admin has created an enrollment policy for the attested
+dstack app, and applies the policy's label and vault grants.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			resolved, err := resolveServerURL(cmd, serverURL)
+			if err != nil {
+				return err
+			}
+			return enrollInstance(resolved, appkeysPath, insecure)
+		},
+	}
+
+	cmd.Flags().StringVar(&serverURL, "server", "", "Jingui server URL (or set JINGUI_SERVER_URL)")
+	cmd.Flags().StringVar(&appkeysPath, "appkeys", defaultAppkeysPath, "Path to appkeys file")
+	cmd.Flags().BoolVar(&insecure, "insecure", false, "Allow plaintext HTTP connection to server")
+
+	return cmd
+}
+
+func newRegisterCmd() *cobra.Command {
+	var (
+		serverURL   string
+		appkeysPath string
+		token       string
+		dstackAppID string
+		insecure    bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "register",
+		Short: "Register this instance with the server using an enrollment token",
+		Long: `Register this instance's public key with the jingui server using an
+enrollment token minted by an admin (POST /v1/enrollment-tokens), for
+environments where attestation-based enrollment is not available. The token
+fixes the dstack app, label and vault grants of the instance.
+
+Prefer JINGUI_ENROLLMENT_TOKEN over --token in CI, so the token does not show
+up in process listings.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			resolved, err := resolveServerURL(cmd, serverURL)
+			if err != nil {
+				return err
+			}
+			if token == "" {
+				token = os.Getenv("JINGUI_ENROLLMENT_TOKEN")
+			}
+			if token == "" {
+				return fmt.Errorf("enrollment token required: use --token flag or set JINGUI_ENROLLMENT_TOKEN")
+			}
+			return registerInstance(resolved, appkeysPath, token, dstackAppID, insecure)
+		},
+	}
+
+	cmd.Flags().StringVar(&serverURL, "server", "", "Jingui server URL (or set JINGUI_SERVER_URL)")
+	cmd.Flags().StringVar(&appkeysPath, "appkeys", defaultAppkeysPath, "Path to appkeys file")
+	cmd.Flags().StringVar(&token, "token", "", "Enrollment token (or set JINGUI_ENROLLMENT_TOKEN)")
+	cmd.Flags().StringVar(&dstackAppID, "dstack-app-id", "", "Expected dstack app ID; rejects a token minted for another app")
+	cmd.Flags().BoolVar(&insecure, "insecure", false, "Allow plaintext HTTP connection to server")
+
+	return cmd
+}
+
+func newRekeyCmd() *cobra.Command {
+	var (
+		serverURL      string
+		appkeysPath    string
+		newAppkeysPath string
+		insecure       bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "rekey",
+		Short: "Replace this instance's key with a new one",
+		Long: `Replace the public key registered for this instance with the one of
+--new-appkeys. The current key (--appkeys) answers a challenge to prove
+possession; the instance keeps its vault access, field grants and debug
+policies under the new FID. The old FID stays in the audit history but can no
+longer fetch secrets.
+
+Point --appkeys at the new file once the command succeeds.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			resolved, err := resolveServerURL(cmd, serverURL)
+			if err != nil {
+				return err
+			}
+			if newAppkeysPath == "" {
+				return fmt.Errorf("--new-appkeys is required")
+			}
+			return rekeyInstance(resolved, appkeysPath, newAppkeysPath, insecure)
+		},
+	}
+
+	cmd.Flags().StringVar(&serverURL, "server", "", "Jingui server URL (or set JINGUI_SERVER_URL)")
+	cmd.Flags().StringVar(&appkeysPath, "appkeys", defaultAppkeysPath, "Path to the current appkeys file")
+	cmd.Flags().StringVar(&newAppkeysPath, "new-appkeys", "", "Path to the appkeys file with the new key")
+	cmd.Flags().BoolVar(&insecure, "insecure", false, "Allow plaintext HTTP connection to server")
+
+	return cmd
+}
+
 // newExecCmd creates the hidden _exec subcommand used by the runner to apply
 // seccomp/PR_SET_DUMPABLE before execve into the target binary.
 func newExecCmd() *cobra.Command {
@@ -197,8 +315,8 @@ func runCommand(envFile string, envFileExplicit bool, serverURL, appkeysPath str
 
 	// Parse env file — if --env-file was not explicitly set and the default
 	// doesn't exist, just scan the current process environment.
-	var entries []client.EnvEntry
-	entries, err = client.ParseEnvFile(envFile)
+	var entries []dotenv.Entry
+	entries, err = dotenv.ParseFile(envFile)
 	if err != nil {
 		if !envFileExplicit && errors.Is(err, os.ErrNotExist) {
 			// Default .env not found → proceed without it
@@ -315,6 +433,66 @@ func showStatus(serverURL, appkeysPath string, insecure bool) error {
 	return nil
 }
 
+func enrollInstance(serverURL, appkeysPath string, insecure bool) error {
+	privKey, err := client.LoadPrivateKey(appkeysPath)
+	if err != nil {
+		return fmt.Errorf("load private key: %w", err)
+	}
+
+	result, err := client.Enroll(serverURL, privKey, attestation.NewDstackBinder(""), insecure)
+	if err != nil {
+		return err
+	}
+	printEnrollResult(result)
+	return nil
+}
+
+func registerInstance(serverURL, appkeysPath, token, dstackAppID string, insecure bool) error {
+	privKey, err := client.LoadPrivateKey(appkeysPath)
+	if err != nil {
+		return fmt.Errorf("load private key: %w", err)
+	}
+
+	result, err := client.RegisterWithToken(serverURL, privKey, token, dstackAppID, insecure)
+	if err != nil {
+		return err
+	}
+	printEnrollResult(result)
+	return nil
+}
+
+func rekeyInstance(serverURL, appkeysPath, newAppkeysPath string, insecure bool) error {
+	oldKey, err := client.LoadPrivateKey(appkeysPath)
+	if err != nil {
+		return fmt.Errorf("load private key: %w", err)
+	}
+	newKey, err := client.LoadPrivateKey(newAppkeysPath)
+	if err != nil {
+		return fmt.Errorf("load new private key: %w", err)
+	}
+
+	result, err := client.Rekey(serverURL, oldKey, newKey, insecure)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("fid=%s\n", result.FID)
+	fmt.Printf("previous_fid=%s\n", result.PreviousFID)
+	fmt.Printf("status=%s\n", result.Status)
+	return nil
+}
+
+// printEnrollResult prints the outcome of enroll or register as key=value
+// lines.
+func printEnrollResult(result *client.EnrollResult) {
+	fmt.Printf("fid=%s\n", result.FID)
+	fmt.Printf("status=%s\n", result.Status)
+	fmt.Printf("dstack_app_id=%s\n", result.DstackAppID)
+	fmt.Printf("label=%s\n", result.Label)
+	if result.Vaults != nil {
+		fmt.Printf("vaults=%s\n", strings.Join(result.Vaults, ","))
+	}
+}
+
 func readSecret(serverURL, appkeysPath string, insecure, showMeta bool, secretRef string) error {
 	if _, err := refparser.Parse(secretRef); err != nil {
 		return fmt.Errorf("invalid secret reference: %w", err)
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"text/tabwriter"
+
+	"github.com/aspect-build/jingui/internal/client"
+	"github.com/aspect-build/jingui/internal/dotenv"
+	"github.com/aspect-build/jingui/internal/importer"
+	"github.com/aspect-build/jingui/internal/refparser"
+	"github.com/spf13/cobra"
+)
+
+func newMigrateEnvCmd() *cobra.Command {
+	var (
+		serverURL  string
+		adminToken string
+		actor      string
+		insecure   bool
+		envFile    string
+		vault      string
+		item       string
+		section    string
+		include    []string
+		exclude    []string
+		overwrite  bool
+		dryRun     bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "migrate-env",
+		Short: "Move plaintext secrets in a .env file into a vault and reference them",
+		Long: `Move plaintext secrets in a .env file into a vault and reference them.
+
+Each entry is classified as a secret or as configuration. Secrets are uploaded
+to --vault as fields of --item (and --section), named after their keys, and
+the file is rewritten so that they read KEY=jingui://<vault>/<item>/KEY.
+Configuration such as DATABASE_URL=postgres://localhost/mydb stays as it is.
+
+An entry counts as a secret when its name contains a word such as TOKEN,
+SECRET or PASSWORD or ends in KEY, when its value is a URL with a password, a
+well-known token format or a long random-looking string. --include and
+--exclude take key patterns (e.g. 'STRIPE_*') that override this; --exclude
+wins when both match. Run with --dry-run first to review the decisions.
+
+If a field already holds a different value the migration stops, unless
+--overwrite is given. Runs outside the TEE with the admin token.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			resolved, err := resolveServerURL(cmd, serverURL)
+			if err != nil {
+				return err
+			}
+			token, err := resolveAdminToken(adminToken)
+			if err != nil {
+				return err
+			}
+			admin := &client.AdminClient{ServerURL: resolved, Token: token, Actor: actor, AllowInsecure: insecure}
+			classifier := &client.EnvClassifier{Include: include, Exclude: exclude}
+			return migrateEnv(admin, classifier, envFile, vault, item, section, overwrite, dryRun)
+		},
+	}
+
+	cmd.Flags().StringVar(&serverURL, "server", "", "Jingui server URL (or set JINGUI_SERVER_URL)")
+	cmd.Flags().StringVar(&adminToken, "admin-token", "", "Admin token (or set JINGUI_ADMIN_TOKEN)")
+	cmd.Flags().StringVar(&actor, "actor", "", "Name recorded as the author of uploaded versions")
+	cmd.Flags().BoolVar(&insecure, "insecure", false, "Allow plaintext HTTP connection to server")
+	cmd.Flags().StringVar(&envFile, "env-file", ".env", "Path to .env file to migrate")
+	cmd.Flags().StringVar(&vault, "vault", "", "Vault to upload secrets to")
+	cmd.Flags().StringVar(&item, "item", "", "Item to upload secrets to")
+	cmd.Flags().StringVar(&section, "section", "", "Section to upload secrets to (default section if empty)")
+	cmd.Flags().StringSliceVar(&include, "include", nil, "Key patterns always treated as secrets (repeatable)")
+	cmd.Flags().StringSliceVar(&exclude, "exclude", nil, "Key patterns never treated as secrets (repeatable)")
+	cmd.Flags().BoolVar(&overwrite, "overwrite", false, "Overwrite fields that already hold a different value")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Show what would change without uploading or rewriting")
+	cmd.MarkFlagRequired("vault")
+	cmd.MarkFlagRequired("item")
+
+	return cmd
+}
+
+func migrateEnv(admin *client.AdminClient, classifier *client.EnvClassifier, envFile, vault, item, section string, overwrite, dryRun bool) error {
+	entries, err := dotenv.ParseFile(envFile)
+	if err != nil {
+		return err
+	}
+
+	// A key repeated later in the file wins, as in a shell.
+	values := map[string]string{}
+	var keys []string
+	for _, e := range entries {
+		if _, ok := values[e.Key]; !ok {
+			keys = append(keys, e.Key)
+		}
+		values[e.Key] = e.Value
+	}
+
+	secrets := map[string]string{}
+	refs := map[string]string{}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "KEY\tACTION\tREASON")
+	for _, key := range keys {
+		secret, reason := classifier.Classify(key, values[key])
+		action := "keep"
+		if secret {
+			ref, err := refparser.Format(vault, item, section, key)
+			if err != nil {
+				tw.Flush()
+				return fmt.Errorf("key %q: %w", key, err)
+			}
+			action = "migrate"
+			secrets[key] = values[key]
+			refs[key] = ref
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", key, action, reason)
+	}
+	tw.Flush()
+
+	if len(secrets) == 0 {
+		fmt.Fprintf(os.Stderr, "nothing to migrate in %s\n", envFile)
+		return nil
+	}
+
+	content, err := json.Marshal(secrets)
+	if err != nil {
+		return fmt.Errorf("encode secrets: %w", err)
+	}
+	onConflict := importer.ConflictFail
+	if overwrite {
+		onConflict = importer.ConflictOverwrite
+	}
+	req := map[string]any{
+		"format":      importer.FormatJSON,
+		"content":     string(content),
+		"item":        item,
+		"section":     section,
+		"on_conflict": onConflict,
+		"dry_run":     dryRun,
+	}
+	var result importResult
+	err = admin.Do(http.MethodPost, "/v1/vaults/"+url.PathEscape(vault)+"/import", req, &result)
+	var adminErr *client.AdminError
+	if errors.As(err, &adminErr) && adminErr.StatusCode == http.StatusConflict {
+		if json.Unmarshal(adminErr.Body, &result) == nil {
+			fmt.Println()
+			printImportChanges(os.Stdout, result)
+		}
+		return fmt.Errorf("%d field(s) in %s already hold different values; pass --overwrite to replace them", result.Summary[importer.ActionConflict], vault)
+	}
+	if err != nil {
+		return fmt.Errorf("upload secrets: %w", err)
+	}
+
+	fmt.Println()
+	printImportChanges(os.Stdout, result)
+	if dryRun {
+		fmt.Fprintf(os.Stderr, "dry run: nothing was uploaded and %s was not changed\n", envFile)
+		return nil
+	}
+
+	if err := client.ReplaceEnvValues(envFile, refs); err != nil {
+		return fmt.Errorf("secrets were uploaded but %s was not rewritten: %w", envFile, err)
+	}
+	fmt.Fprintf(os.Stderr, "rewrote %s: %d value(s) replaced with jingui:// references\n", envFile, len(refs))
+	return nil
+}
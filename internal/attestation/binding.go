@@ -0,0 +1,121 @@
+package attestation
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"strings"
+
+	dstacksdk "github.com/Dstack-TEE/dstack/sdk/go/dstack"
+	"github.com/aspect-build/jingui/internal/logx"
+)
+
+// A binding ties caller-chosen data, such as an instance public key, to an
+// attestation. dstack does not let the app choose the report data of the
+// quote embedded in an RA-TLS certificate: the quote commits to the
+// certificate's own key instead. So the binder asks for a fresh RA-TLS key
+// and signs a digest of the data with it. A verifier that accepts the
+// certificate and the signature knows the data came from inside the attested
+// CVM.
+
+// EnrollmentDigest is the digest an instance signs to enroll pubKey.
+func EnrollmentDigest(pubKey []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte("jingui-enroll:v1:"))
+	h.Write(pubKey)
+	return h.Sum(nil)
+}
+
+// VerifyBinding checks that sig is a signature of digest by the key of the
+// first certificate in b.AppCert. The certificate itself must be verified
+// separately, with a Verifier.
+func VerifyBinding(b Bundle, digest, sig []byte) error {
+	cert, err := parseFirstPEMCertificate(b.AppCert)
+	if err != nil {
+		return err
+	}
+	// dstack issues ECDSA P-256 RA-TLS keys.
+	pub, ok := cert.PublicKey.(*ecdsa.PublicKey)
+	if !ok {
+		return fmt.Errorf("unsupported attested key type %T", cert.PublicKey)
+	}
+	if !ecdsa.VerifyASN1(pub, digest, sig) {
+		return fmt.Errorf("binding signature does not match the attested key")
+	}
+	return nil
+}
+
+// DstackBinder binds data to the local CVM with a fresh RA-TLS key from the
+// dstack guest-agent GetTlsKey() API.
+type DstackBinder struct {
+	client *dstacksdk.DstackClient
+}
+
+func NewDstackBinder(endpoint string) *DstackBinder {
+	opts := []dstacksdk.DstackClientOption{}
+	if endpoint != "" {
+		opts = append(opts, dstacksdk.WithEndpoint(endpoint))
+	}
+	return &DstackBinder{client: dstacksdk.NewDstackClient(opts...)}
+}
+
+func (d *DstackBinder) Bind(ctx context.Context, digest []byte) (Bundle, []byte, error) {
+	info, err := d.client.Info(ctx)
+	if err != nil {
+		return Bundle{}, nil, fmt.Errorf("dstack info: %w", err)
+	}
+	resp, err := d.client.GetTlsKey(ctx,
+		dstacksdk.WithSubject("jingui-enroll"),
+		dstacksdk.WithUsageRaTls(true),
+		dstacksdk.WithAppInfo(true),
+	)
+	if err != nil {
+		return Bundle{}, nil, fmt.Errorf("dstack get tls key: %w", err)
+	}
+	signer, err := parsePEMSigner(resp.Key)
+	if err != nil {
+		return Bundle{}, nil, err
+	}
+	sig, err := signer.Sign(rand.Reader, digest, crypto.SHA256)
+	if err != nil {
+		return Bundle{}, nil, fmt.Errorf("sign binding: %w", err)
+	}
+	logx.Debugf("ratls.bind app_id=%q instance_id=%q cert_chain_len=%d", info.AppID, info.InstanceID, len(resp.CertificateChain))
+	return Bundle{
+		AppCert:  strings.Join(resp.CertificateChain, "\n"),
+		TCBInfo:  info.TcbInfo,
+		AppID:    info.AppID,
+		Instance: info.InstanceID,
+		DeviceID: info.DeviceID,
+	}, sig, nil
+}
+
+func parsePEMSigner(keyPEM string) (crypto.Signer, error) {
+	block, _ := pem.Decode([]byte(keyPEM))
+	if block == nil {
+		return nil, fmt.Errorf("failed to decode TLS key PEM")
+	}
+	var (
+		key any
+		err error
+	)
+	switch block.Type {
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse TLS key: %w", err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported TLS key type %T", key)
+	}
+	return signer, nil
+}
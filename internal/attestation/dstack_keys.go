@@ -0,0 +1,40 @@
+package attestation
+
+import (
+	"context"
+	"fmt"
+
+	dstacksdk "github.com/Dstack-TEE/dstack/sdk/go/dstack"
+	"github.com/aspect-build/jingui/internal/logx"
+)
+
+// DstackKeyDeriver derives deterministic keys from the dstack guest-agent
+// GetKey() API. The guest agent derives them from the app's KMS root key, so
+// the same path yields the same key only inside a CVM running the same app.
+type DstackKeyDeriver struct {
+	client *dstacksdk.DstackClient
+}
+
+func NewDstackKeyDeriver(endpoint string) *DstackKeyDeriver {
+	opts := []dstacksdk.DstackClientOption{}
+	if endpoint != "" {
+		opts = append(opts, dstacksdk.WithEndpoint(endpoint))
+	}
+	return &DstackKeyDeriver{client: dstacksdk.NewDstackClient(opts...)}
+}
+
+func (d *DstackKeyDeriver) DeriveKey(ctx context.Context, path, purpose string) ([]byte, error) {
+	resp, err := d.client.GetKey(ctx, path, purpose, "secp256k1")
+	if err != nil {
+		return nil, fmt.Errorf("dstack get key: %w", err)
+	}
+	key, err := resp.DecodeKey()
+	if err != nil {
+		return nil, fmt.Errorf("decode dstack key: %w", err)
+	}
+	if len(key) == 0 {
+		return nil, fmt.Errorf("dstack returned an empty key")
+	}
+	logx.Debugf("dstack.derive_key path=%q purpose=%q key_len=%d signature_chain_len=%d", path, purpose, len(key), len(resp.SignatureChain))
+	return key, nil
+}
@@ -44,8 +44,10 @@ func (v *RATLSVerifier) Verify(_ context.Context, b Bundle) (VerifiedIdentity, e
 		return VerifiedIdentity{}, fmt.Errorf("attestation app_id mismatch between certificate (%q) and bundle (%q)", certAppID, strings.TrimSpace(b.AppID))
 	}
 
+	var measurements Measurements
 	if result != nil {
 		logRATLSMeasurements(result)
+		measurements = ratlsMeasurements(result)
 	}
 	logx.Debugf("ratls.identity cert_app_id=%q bundle_app_id=%q instance_id=%q device_id=%q", certAppID, strings.TrimSpace(b.AppID), b.Instance, b.DeviceID)
 
@@ -56,8 +58,9 @@ func (v *RATLSVerifier) Verify(_ context.Context, b Bundle) (VerifiedIdentity, e
 		// verified attestation certificate and should be treated as
 		// unverified claims. They are included here for logging/diagnostics
 		// only and MUST NOT be used for authorization decisions.
-		InstanceID: b.Instance,
-		DeviceID:   b.DeviceID,
+		InstanceID:   b.Instance,
+		DeviceID:     b.DeviceID,
+		Measurements: measurements,
 	}, nil
 }
 
@@ -87,6 +90,22 @@ func logRATLSMeasurements(result *dstackratls.VerifyResult) {
 	logx.Debugf("ratls.measurements rtmr0=%s rtmr1=%s rtmr2=%s rtmr3=%s tee_tcb_svn=%s td_attributes=%s", fmtHex(qr.RTMR0), fmtHex(qr.RTMR1), fmtHex(qr.RTMR2), fmtHex(qr.RTMR3), fmtHex(qr.TeeTCBSVN), fmtHex(qr.TdAttributes))
 }
 
+func ratlsMeasurements(result *dstackratls.VerifyResult) Measurements {
+	if result.Report == nil {
+		return Measurements{}
+	}
+	qr := result.Report.Report
+	return Measurements{
+		MRTD:         hex.EncodeToString(qr.MrTD),
+		RTMR0:        hex.EncodeToString(qr.RTMR0),
+		RTMR1:        hex.EncodeToString(qr.RTMR1),
+		RTMR2:        hex.EncodeToString(qr.RTMR2),
+		RTMR3:        hex.EncodeToString(qr.RTMR3),
+		TCBSVN:       hex.EncodeToString(qr.TeeTCBSVN),
+		TDAttributes: hex.EncodeToString(qr.TdAttributes),
+	}
+}
+
 func fmtHex(b []byte) string {
 	if len(b) == 0 {
 		return ""
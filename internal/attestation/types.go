@@ -24,4 +24,19 @@ type VerifiedIdentity struct {
 	// DeviceID is self-reported by the peer (NOT verified against the
 	// certificate). Use for logging/diagnostics only.
 	DeviceID string
+	// Measurements are taken from the verified TDX quote. Zero if the
+	// verifier did not return a quote report.
+	Measurements Measurements
+}
+
+// Measurements are the measurement registers and TCB fields of a verified
+// TDX quote, each lowercase hex.
+type Measurements struct {
+	MRTD         string `json:"mrtd"`
+	RTMR0        string `json:"rtmr0"`
+	RTMR1        string `json:"rtmr1"`
+	RTMR2        string `json:"rtmr2"`
+	RTMR3        string `json:"rtmr3"`
+	TCBSVN       string `json:"tcb_svn"`
+	TDAttributes string `json:"td_attributes"`
 }
@@ -16,3 +16,22 @@ type Verifier interface {
 type Collector interface {
 	Collect(ctx context.Context) (Bundle, error)
 }
+
+// Binder ties a digest to the local TEE identity: it returns an attestation
+// bundle and a signature of digest by the attested key, to be checked with
+// VerifyBinding.
+//
+// Concrete implementation uses dstack go SDK GetTlsKey() against
+// /var/run/dstack.sock.
+type Binder interface {
+	Bind(ctx context.Context, digest []byte) (Bundle, []byte, error)
+}
+
+// KeyDeriver derives deterministic key material bound to the local TEE
+// identity.
+//
+// Concrete implementation uses dstack go SDK GetKey() against
+// /var/run/dstack.sock.
+type KeyDeriver interface {
+	DeriveKey(ctx context.Context, path, purpose string) ([]byte, error)
+}
@@ -28,7 +28,7 @@ import (
 // bddContext holds per-scenario state.
 type bddContext struct {
 	ts    *httptest.Server
-	store *db.Store
+	store db.Store
 
 	// TEE instance state
 	teePriv [32]byte
@@ -63,16 +63,13 @@ func (b *bddContext) theServerIsRunning() error {
 		return nil // already running
 	}
 
-	store, err := db.NewStore(":memory:")
-	if err != nil {
-		return fmt.Errorf("NewStore: %w", err)
-	}
+	store := db.NewMemoryStore()
 
 	cfg := &server.Config{
 		AdminToken: testAdminToken,
 	}
 
-	router := server.NewRouter(store, cfg)
+	router := server.NewRouter(store, cfg, nil)
 	ts := httptest.NewServer(router)
 
 	b.ts = ts
@@ -100,7 +97,7 @@ func (b *bddContext) aVaultExistsWithItems(vaultID, section string, table *godog
 	for _, row := range table.Rows[1:] { // skip header
 		fields[row.Cells[0].Value] = row.Cells[1].Value
 	}
-	if err := b.store.SetItemFields(vaultID, section, fields); err != nil {
+	if err := b.store.SetItemFields(vaultID, section, "", fields); err != nil {
 		return fmt.Errorf("set item fields: %w", err)
 	}
 	return nil
@@ -0,0 +1,96 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// AdminClient calls the server's admin API with the admin bearer token. It is
+// used by operator commands that run outside the TEE, such as import.
+type AdminClient struct {
+	ServerURL     string
+	Token         string
+	Actor         string // sent as X-Jingui-Actor to attribute changes
+	AllowInsecure bool
+}
+
+// AdminError is returned by AdminClient.Do for non-2xx responses.
+type AdminError struct {
+	StatusCode int
+	Message    string
+	Hint       string
+	Body       []byte // raw response body, for endpoints that return details
+}
+
+func (e *AdminError) Error() string {
+	msg := fmt.Sprintf("server returned %d: %s", e.StatusCode, e.Message)
+	if e.Hint != "" {
+		msg += " (" + e.Hint + ")"
+	}
+	return msg
+}
+
+// Do sends a JSON request to path (e.g. "/v1/vaults") and decodes a JSON
+// response into out, which may be nil.
+func (a *AdminClient) Do(method, path string, body, out any) error {
+	serverURL := normalizeServerURL(a.ServerURL)
+	if !strings.HasPrefix(serverURL, "https://") {
+		if !a.AllowInsecure {
+			return fmt.Errorf("server URL %q is not HTTPS; use --insecure to allow plaintext HTTP", serverURL)
+		}
+		fmt.Fprintf(os.Stderr, "jingui: WARNING: sending the admin token over plaintext HTTP (%s)\n", serverURL)
+	}
+
+	var reqBody io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("marshal request: %w", err)
+		}
+		reqBody = bytes.NewReader(encoded)
+	}
+	req, err := http.NewRequest(method, serverURL+path, reqBody)
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+a.Token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if a.Actor != "" {
+		req.Header.Set("X-Jingui-Actor", a.Actor)
+	}
+
+	resp, err := httpClient().Do(req)
+	if err != nil {
+		return fmt.Errorf("%s %s: %w", method, path, err)
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("read response: %w", err)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		adminErr := &AdminError{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(respBody)), Body: respBody}
+		var parsed struct {
+			Error string `json:"error"`
+			Hint  string `json:"hint"`
+		}
+		if json.Unmarshal(respBody, &parsed) == nil && parsed.Error != "" {
+			adminErr.Message, adminErr.Hint = parsed.Error, parsed.Hint
+		}
+		return adminErr
+	}
+	if out != nil {
+		if err := json.Unmarshal(respBody, out); err != nil {
+			return fmt.Errorf("decode response: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/aspect-build/jingui/internal/attestation"
+)
+
+type enrollRequest struct {
+	PublicKey   string             `json:"public_key"`
+	Attestation attestation.Bundle `json:"attestation"`
+	Signature   string             `json:"signature"`
+}
+
+// EnrollResult is the server's answer to an enrollment.
+type EnrollResult struct {
+	FID         string   `json:"fid"`
+	Status      string   `json:"status"` // "enrolled", "registered" or "already_registered"
+	DstackAppID string   `json:"dstack_app_id"`
+	Label       string   `json:"label"`
+	Vaults      []string `json:"vaults"`
+}
+
+// Enroll registers the instance holding privateKey with the server, without
+// the admin token. binder attests the public key; the server accepts it if an
+// enrollment policy exists for the attested dstack app.
+func Enroll(serverURL string, privateKey [32]byte, binder attestation.Binder, allowInsecure bool) (*EnrollResult, error) {
+	serverURL, err := checkEnrollmentURL(serverURL, allowInsecure)
+	if err != nil {
+		return nil, err
+	}
+	pub, err := DerivePublicKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	bundle, sig, err := binder.Bind(context.Background(), attestation.EnrollmentDigest(pub))
+	if err != nil {
+		return nil, fmt.Errorf("attest public key: %w", err)
+	}
+
+	return postEnrollment(serverURL, "/v1/enroll", enrollRequest{
+		PublicKey:   hex.EncodeToString(pub),
+		Attestation: bundle,
+		Signature:   base64.StdEncoding.EncodeToString(sig),
+	})
+}
+
+type registerWithTokenRequest struct {
+	Token       string `json:"token"`
+	PublicKey   string `json:"public_key"`
+	DstackAppID string `json:"dstack_app_id,omitempty"`
+}
+
+// RegisterWithToken registers the instance holding privateKey with the server
+// using an enrollment token minted by an admin. dstackAppID is optional; if
+// set, the server rejects a token minted for another app.
+func RegisterWithToken(serverURL string, privateKey [32]byte, token, dstackAppID string, allowInsecure bool) (*EnrollResult, error) {
+	serverURL, err := checkEnrollmentURL(serverURL, allowInsecure)
+	if err != nil {
+		return nil, err
+	}
+	pub, err := DerivePublicKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	return postEnrollment(serverURL, "/v1/register", registerWithTokenRequest{
+		Token:       token,
+		PublicKey:   hex.EncodeToString(pub),
+		DstackAppID: dstackAppID,
+	})
+}
+
+// checkEnrollmentURL normalizes serverURL and refuses plaintext HTTP unless
+// allowInsecure is set.
+func checkEnrollmentURL(serverURL string, allowInsecure bool) (string, error) {
+	serverURL = normalizeServerURL(serverURL)
+	if !strings.HasPrefix(serverURL, "https://") {
+		if !allowInsecure {
+			return "", fmt.Errorf("server URL %q is not HTTPS; use --insecure to allow plaintext HTTP", serverURL)
+		}
+		fmt.Fprintf(os.Stderr, "jingui: WARNING: communicating over plaintext HTTP (%s)\n", serverURL)
+	}
+	return serverURL, nil
+}
+
+// postEnrollment sends a registration request to path and decodes the result.
+func postEnrollment(serverURL, path string, payload any) (*EnrollResult, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("marshal enroll request: %w", err)
+	}
+	req, err := http.NewRequest(http.MethodPost, serverURL+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("create enroll request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := httpClient().Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("enroll: %w", err)
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read enroll response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		return nil, fmt.Errorf("%s returned %d: %s", path, resp.StatusCode, string(respBody))
+	}
+
+	var result EnrollResult
+	if err := json.Unmarshal(respBody, &result); err != nil {
+		return nil, fmt.Errorf("unmarshal enroll response: %w", err)
+	}
+	return &result, nil
+}
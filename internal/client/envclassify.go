@@ -0,0 +1,142 @@
+package client
+
+import (
+	"math"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/aspect-build/jingui/internal/refparser"
+)
+
+// EnvClassifier decides which .env entries hold secrets worth moving into a
+// vault. Include and Exclude are glob patterns (as in path.Match) on key
+// names that override the heuristic; Exclude wins when both match.
+type EnvClassifier struct {
+	Include []string
+	Exclude []string
+}
+
+// Reasons reported by EnvClassifier.Classify.
+const (
+	ReasonReference  = "already a reference"
+	ReasonEmpty      = "empty value"
+	ReasonExcluded   = "excluded"
+	ReasonIncluded   = "included"
+	ReasonKeyName    = "secret-like name"
+	ReasonURLAuth    = "URL with password"
+	ReasonKnownToken = "known token format"
+	ReasonEntropy    = "random-looking value"
+	ReasonPlain      = "looks like configuration"
+)
+
+// secretWords mark a key as secret wherever they appear among its
+// _-separated words.
+var secretWords = map[string]bool{
+	"SECRET": true, "SECRETS": true, "TOKEN": true, "TOKENS": true,
+	"PASSWORD": true, "PASSWD": true, "PASS": true, "PWD": true,
+	"APIKEY": true, "PRIVATE": true, "CREDENTIAL": true, "CREDENTIALS": true,
+	"AUTH": true, "SESSION": true, "COOKIE": true, "SALT": true,
+	"SIGNING": true, "DSN": true, "PASSPHRASE": true,
+}
+
+// tokenPrefixes are prefixes of well-known credential formats.
+var tokenPrefixes = []string{
+	"-----BEGIN ", "ghp_", "gho_", "ghs_", "github_pat_", "glpat-",
+	"sk-", "sk_live_", "rk_live_", "xoxb-", "xoxp-", "AKIA", "AIza",
+}
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// Classify reports whether the entry looks like a secret, and why.
+func (c *EnvClassifier) Classify(key, value string) (bool, string) {
+	switch {
+	case refparser.IsRef(value):
+		return false, ReasonReference
+	case matchAny(c.Exclude, key):
+		return false, ReasonExcluded
+	case matchAny(c.Include, key):
+		return true, ReasonIncluded
+	case value == "":
+		return false, ReasonEmpty
+	case secretKeyName(key):
+		return true, ReasonKeyName
+	case urlHasPassword(value):
+		return true, ReasonURLAuth
+	case hasTokenPrefix(value):
+		return true, ReasonKnownToken
+	case looksRandom(value):
+		return true, ReasonEntropy
+	}
+	return false, ReasonPlain
+}
+
+func matchAny(patterns []string, key string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, key); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// secretKeyName matches names such as DB_PASSWORD, GITHUB_TOKEN or API_KEY.
+// A trailing KEY counts unless the name is about a public key.
+func secretKeyName(key string) bool {
+	words := strings.FieldsFunc(strings.ToUpper(key), func(r rune) bool {
+		return r == '_' || r == '-' || r == '.'
+	})
+	public := false
+	for _, w := range words {
+		if secretWords[w] {
+			return true
+		}
+		if w == "PUBLIC" || w == "PUB" {
+			public = true
+		}
+	}
+	return len(words) > 1 && words[len(words)-1] == "KEY" && !public
+}
+
+// urlHasPassword matches URLs with credentials, e.g. postgres://u:p@host/db.
+func urlHasPassword(value string) bool {
+	if !strings.Contains(value, "://") {
+		return false
+	}
+	u, err := url.Parse(value)
+	if err != nil || u.User == nil {
+		return false
+	}
+	_, ok := u.User.Password()
+	return ok
+}
+
+func hasTokenPrefix(value string) bool {
+	for _, p := range tokenPrefixes {
+		if strings.HasPrefix(value, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// looksRandom matches long single-word values with high character entropy,
+// such as generated keys. URLs, paths and UUIDs are not counted.
+func looksRandom(value string) bool {
+	if len(value) < 24 || strings.ContainsAny(value, " \t") ||
+		strings.Contains(value, "://") || strings.HasPrefix(value, "/") || uuidPattern.MatchString(value) {
+		return false
+	}
+	counts := map[rune]int{}
+	for _, r := range value {
+		counts[r]++
+	}
+	entropy := 0.0
+	n := float64(len(value))
+	for _, c := range counts {
+		p := float64(c) / n
+		entropy -= p * math.Log2(p)
+	}
+	return entropy >= 3.5
+}
@@ -0,0 +1,37 @@
+package client
+
+import "testing"
+
+func TestEnvClassifier(t *testing.T) {
+	c := &EnvClassifier{Include: []string{"LICENSE_*"}, Exclude: []string{"PUBLIC_*", "SESSION_TTL"}}
+
+	tests := []struct {
+		key, value string
+		secret     bool
+		reason     string
+	}{
+		{"DATABASE_URL", "postgres://localhost/mydb", false, ReasonPlain},
+		{"DATABASE_URL", "postgres://app:hunter2@db/mydb", true, ReasonURLAuth},
+		{"GITHUB_TOKEN", "x", true, ReasonKeyName},
+		{"DB_PASSWORD", "hunter2", true, ReasonKeyName},
+		{"STRIPE_API_KEY", "abc", true, ReasonKeyName},
+		{"SSH_PUBLIC_KEY", "ssh-ed25519 AAAA", false, ReasonPlain},
+		{"KEYBOARD_LAYOUT", "us", false, ReasonPlain},
+		{"WEBHOOK", "xoxb-123-456", true, ReasonKnownToken},
+		{"SIGNER", "q8Zr2pLx7VbN4mKd9TsW1yHc6", true, ReasonEntropy},
+		{"APP_ID", "123e4567-e89b-12d3-a456-426614174000", false, ReasonPlain},
+		{"LOG_LEVEL", "debug", false, ReasonPlain},
+		{"UPLOAD_DIR", "/var/lib/app/uploads/2024/incoming", false, ReasonPlain},
+		{"API_TOKEN", "", false, ReasonEmpty},
+		{"API_TOKEN", "jingui://v/app/API_TOKEN", false, ReasonReference},
+		{"LICENSE_ID", "42", true, ReasonIncluded},
+		{"PUBLIC_SECRET_PATH", "x", false, ReasonExcluded},
+		{"SESSION_TTL", "3600", false, ReasonExcluded},
+	}
+	for _, tc := range tests {
+		secret, reason := c.Classify(tc.key, tc.value)
+		if secret != tc.secret || reason != tc.reason {
+			t.Errorf("Classify(%q, %q) = %v, %q; want %v, %q", tc.key, tc.value, secret, reason, tc.secret, tc.reason)
+		}
+	}
+}
@@ -1,62 +1,61 @@
 package client
 
 import (
-	"bufio"
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
 )
 
-// EnvEntry represents a single KEY=VALUE pair.
-type EnvEntry struct {
-	Key   string
-	Value string
-}
-
-// ParseEnvFile parses a .env file into key-value entries.
-// Supports KEY=VALUE, KEY="VALUE", KEY='VALUE', # comments, and empty lines.
-func ParseEnvFile(path string) ([]EnvEntry, error) {
-	f, err := os.Open(path)
+// ReplaceEnvValues rewrites the .env file at path, replacing the values of
+// the keys in values and leaving every other line, including comments and
+// blank lines, as it was. The file is replaced atomically and keeps its mode.
+func ReplaceEnvValues(path string, values map[string]string) error {
+	data, err := os.ReadFile(path)
 	if err != nil {
-		return nil, fmt.Errorf("open env file: %w", err)
+		return fmt.Errorf("read env file: %w", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("stat env file: %w", err)
 	}
-	defer f.Close()
-
-	var entries []EnvEntry
-	scanner := bufio.NewScanner(f)
-	lineNum := 0
-
-	for scanner.Scan() {
-		lineNum++
-		line := strings.TrimSpace(scanner.Text())
 
-		// Skip empty lines and comments
-		if line == "" || strings.HasPrefix(line, "#") {
+	lines := strings.SplitAfter(string(data), "\n")
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
 			continue
 		}
-
 		idx := strings.IndexByte(line, '=')
 		if idx < 0 {
-			return nil, fmt.Errorf("line %d: missing '='", lineNum)
+			continue
 		}
-
-		key := strings.TrimSpace(line[:idx])
-		value := strings.TrimSpace(line[idx+1:])
-
-		// Strip surrounding quotes
-		if len(value) >= 2 {
-			if (value[0] == '"' && value[len(value)-1] == '"') ||
-				(value[0] == '\'' && value[len(value)-1] == '\'') {
-				value = value[1 : len(value)-1]
-			}
+		value, ok := values[strings.TrimSpace(line[:idx])]
+		if !ok {
+			continue
 		}
-
-		entries = append(entries, EnvEntry{Key: key, Value: value})
+		eol := line[len(strings.TrimRight(line, "\r\n")):]
+		lines[i] = line[:idx+1] + value + eol
 	}
 
-	if err := scanner.Err(); err != nil {
-		return nil, fmt.Errorf("read env file: %w", err)
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("write env file: %w", err)
 	}
-
-	return entries, nil
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(strings.Join(lines, "")); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write env file: %w", err)
+	}
+	if err := tmp.Chmod(info.Mode().Perm()); err != nil {
+		tmp.Close()
+		return fmt.Errorf("write env file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("write env file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("write env file: %w", err)
+	}
+	return nil
 }
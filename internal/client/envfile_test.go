@@ -6,51 +6,27 @@ import (
 	"testing"
 )
 
-func TestParseEnvFile(t *testing.T) {
+func TestReplaceEnvValues(t *testing.T) {
 	dir := t.TempDir()
 	path := filepath.Join(dir, ".env")
-	content := `# Comment
-FOO=bar
-BAZ="quoted value"
-SINGLE='single quoted'
-EMPTY=
-
-# Another comment
-REF=jingui://app/user/field
-`
-	os.WriteFile(path, []byte(content), 0600)
-
-	entries, err := ParseEnvFile(path)
+	content := "# keep me\nAPI_KEY=\"secret\"\nDATABASE_URL=postgres://localhost/mydb\r\n\nTOKEN = abc\n"
+	os.WriteFile(path, []byte(content), 0640)
+
+	err := ReplaceEnvValues(path, map[string]string{
+		"API_KEY": "jingui://v/app/API_KEY",
+		"TOKEN":   "jingui://v/app/TOKEN",
+		"MISSING": "jingui://v/app/MISSING",
+	})
 	if err != nil {
-		t.Fatalf("ParseEnvFile: %v", err)
-	}
-
-	expected := []EnvEntry{
-		{Key: "FOO", Value: "bar"},
-		{Key: "BAZ", Value: "quoted value"},
-		{Key: "SINGLE", Value: "single quoted"},
-		{Key: "EMPTY", Value: ""},
-		{Key: "REF", Value: "jingui://app/user/field"},
-	}
-
-	if len(entries) != len(expected) {
-		t.Fatalf("got %d entries, want %d", len(entries), len(expected))
+		t.Fatalf("ReplaceEnvValues: %v", err)
 	}
 
-	for i, e := range entries {
-		if e.Key != expected[i].Key || e.Value != expected[i].Value {
-			t.Errorf("entry[%d] = {%q, %q}, want {%q, %q}", i, e.Key, e.Value, expected[i].Key, expected[i].Value)
-		}
+	got, _ := os.ReadFile(path)
+	want := "# keep me\nAPI_KEY=jingui://v/app/API_KEY\nDATABASE_URL=postgres://localhost/mydb\r\n\nTOKEN =jingui://v/app/TOKEN\n"
+	if string(got) != want {
+		t.Errorf("rewritten file:\n%q\nwant\n%q", got, want)
 	}
-}
-
-func TestParseEnvFile_MissingEquals(t *testing.T) {
-	dir := t.TempDir()
-	path := filepath.Join(dir, ".env")
-	os.WriteFile(path, []byte("BADLINE\n"), 0600)
-
-	_, err := ParseEnvFile(path)
-	if err == nil {
-		t.Fatal("expected error for missing '='")
+	if info, _ := os.Stat(path); info.Mode().Perm() != 0640 {
+		t.Errorf("mode = %v, want 0640", info.Mode().Perm())
 	}
 }
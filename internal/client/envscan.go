@@ -4,6 +4,7 @@ import (
 	"os"
 	"strings"
 
+	"github.com/aspect-build/jingui/internal/dotenv"
 	"github.com/aspect-build/jingui/internal/refparser"
 )
 
@@ -18,7 +19,7 @@ type ScanResult struct {
 // MergeEnvFileWithProcess merges .env file entries with the current process environment.
 // .env entries take precedence over process env.
 // Returns separated plain values and jingui:// references.
-func MergeEnvFileWithProcess(entries []EnvEntry) ScanResult {
+func MergeEnvFileWithProcess(entries []dotenv.Entry) ScanResult {
 	// Start with process env as a map
 	envMap := make(map[string]string)
 	// Track insertion order
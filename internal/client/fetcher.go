@@ -7,6 +7,7 @@ import (
 	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -20,16 +21,30 @@ import (
 	"golang.org/x/crypto/curve25519"
 )
 
+// ErrSecretExpired is returned by Fetch when a requested secret is past its
+// expiry date and the server refused to serve it.
+var ErrSecretExpired = errors.New("secret expired")
+
 // ComputeFID derives the public key from the private key and returns hex(SHA1(pubkey)).
 func ComputeFID(privateKey [32]byte) (string, error) {
-	pub, err := curve25519.X25519(privateKey[:], curve25519.Basepoint)
+	pub, err := DerivePublicKey(privateKey)
 	if err != nil {
-		return "", fmt.Errorf("derive public key: %w", err)
+		return "", err
 	}
 	h := sha1.Sum(pub)
 	return hex.EncodeToString(h[:]), nil
 }
 
+// DerivePublicKey returns the X25519 public key of the private key, the key
+// an instance is registered with.
+func DerivePublicKey(privateKey [32]byte) ([]byte, error) {
+	pub, err := curve25519.X25519(privateKey[:], curve25519.Basepoint)
+	if err != nil {
+		return nil, fmt.Errorf("derive public key: %w", err)
+	}
+	return pub, nil
+}
+
 type fetchRequest struct {
 	FID               string   `json:"fid"`
 	SecretReferences  []string `json:"secret_references"`
@@ -87,46 +102,16 @@ func Fetch(serverURL string, privateKey [32]byte, fid string, refs []string, all
 		fmt.Fprintf(os.Stderr, "jingui: WARNING: communicating over plaintext HTTP (%s)\n", serverURL)
 	}
 
-	strict := ratlsStrictEnabled()
-	var clientAtt *attestation.Bundle
-	if strict {
-		bundle, err := collectLocalAttestation()
-		if err != nil {
-			return nil, fmt.Errorf("collect local attestation: %w", err)
-		}
-		clientAtt = &bundle
-		logx.Debugf("ratls.client.challenge peer=client app_id=%q instance_id=%q device_id=%q", bundle.AppID, bundle.Instance, bundle.DeviceID)
-	}
-
-	challenge, err := requestChallenge(serverURL, fid, allowInsecure, clientAtt)
+	challengeID, challengeResponse, err := solveChallenge(serverURL, privateKey, fid, allowInsecure)
 	if err != nil {
 		return nil, err
 	}
 
-	if strict {
-		if challenge.ServerAttestation == nil {
-			return nil, fmt.Errorf("challenge response missing server_attestation in strict RA-TLS mode")
-		}
-		logx.Debugf("ratls.client.challenge peer=server received app_id=%q instance_id=%q device_id=%q", challenge.ServerAttestation.AppID, challenge.ServerAttestation.Instance, challenge.ServerAttestation.DeviceID)
-		if err := verifyServerAttestation(*challenge.ServerAttestation); err != nil {
-			return nil, fmt.Errorf("verify server attestation: %w", err)
-		}
-	}
-
-	challengeBlob, err := base64.StdEncoding.DecodeString(challenge.Challenge)
-	if err != nil {
-		return nil, fmt.Errorf("decode challenge blob: %w", err)
-	}
-	challengePlain, err := crypto.Decrypt(privateKey, challengeBlob)
-	if err != nil {
-		return nil, fmt.Errorf("decrypt challenge: %w", err)
-	}
-
 	reqBody := fetchRequest{
 		FID:               fid,
 		SecretReferences:  refs,
-		ChallengeID:       challenge.ChallengeID,
-		ChallengeResponse: base64.StdEncoding.EncodeToString(challengePlain),
+		ChallengeID:       challengeID,
+		ChallengeResponse: challengeResponse,
 	}
 
 	body, err := json.Marshal(reqBody)
@@ -154,6 +139,14 @@ func Fetch(serverURL string, privateKey [32]byte, fid string, refs []string, all
 		return nil, fmt.Errorf("read response: %w", err)
 	}
 
+	if resp.StatusCode == http.StatusGone {
+		var body struct {
+			Reference string    `json:"reference"`
+			ExpiredAt time.Time `json:"expired_at"`
+		}
+		json.Unmarshal(respBody, &body)
+		return nil, fmt.Errorf("%w: %s (expired %s)", ErrSecretExpired, body.Reference, body.ExpiredAt.Format(time.RFC3339))
+	}
 	if resp.StatusCode != http.StatusOK {
 		return nil, fmt.Errorf("server returned %d: %s", resp.StatusCode, string(respBody))
 	}
@@ -175,6 +168,47 @@ func Fetch(serverURL string, privateKey [32]byte, fid string, refs []string, all
 	return blobs, nil
 }
 
+// solveChallenge proves possession of privateKey for fid: it requests a
+// challenge, verifying the server's attestation in strict RA-TLS mode, and
+// returns the challenge ID with the base64 decrypted challenge.
+func solveChallenge(serverURL string, privateKey [32]byte, fid string, allowInsecure bool) (string, string, error) {
+	strict := ratlsStrictEnabled()
+	var clientAtt *attestation.Bundle
+	if strict {
+		bundle, err := collectLocalAttestation()
+		if err != nil {
+			return "", "", fmt.Errorf("collect local attestation: %w", err)
+		}
+		clientAtt = &bundle
+		logx.Debugf("ratls.client.challenge peer=client app_id=%q instance_id=%q device_id=%q", bundle.AppID, bundle.Instance, bundle.DeviceID)
+	}
+
+	challenge, err := requestChallenge(serverURL, fid, allowInsecure, clientAtt)
+	if err != nil {
+		return "", "", err
+	}
+
+	if strict {
+		if challenge.ServerAttestation == nil {
+			return "", "", fmt.Errorf("challenge response missing server_attestation in strict RA-TLS mode")
+		}
+		logx.Debugf("ratls.client.challenge peer=server received app_id=%q instance_id=%q device_id=%q", challenge.ServerAttestation.AppID, challenge.ServerAttestation.Instance, challenge.ServerAttestation.DeviceID)
+		if err := verifyServerAttestation(*challenge.ServerAttestation); err != nil {
+			return "", "", fmt.Errorf("verify server attestation: %w", err)
+		}
+	}
+
+	challengeBlob, err := base64.StdEncoding.DecodeString(challenge.Challenge)
+	if err != nil {
+		return "", "", fmt.Errorf("decode challenge blob: %w", err)
+	}
+	challengePlain, err := crypto.Decrypt(privateKey, challengeBlob)
+	if err != nil {
+		return "", "", fmt.Errorf("decrypt challenge: %w", err)
+	}
+	return challenge.ChallengeID, base64.StdEncoding.EncodeToString(challengePlain), nil
+}
+
 func collectLocalAttestation() (attestation.Bundle, error) {
 	collector := attestation.NewDstackInfoCollector("")
 	return collector.Collect(context.Background())
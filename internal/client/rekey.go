@@ -0,0 +1,80 @@
+package client
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+type rekeyRequest struct {
+	FID               string `json:"fid"`
+	ChallengeID       string `json:"challenge_id"`
+	ChallengeResponse string `json:"challenge_response"`
+	NewPublicKey      string `json:"new_public_key"`
+}
+
+// RekeyResult is the server's answer to a key rotation.
+type RekeyResult struct {
+	FID         string `json:"fid"`
+	PreviousFID string `json:"previous_fid"`
+	Status      string `json:"status"`
+}
+
+// Rekey replaces the public key of the instance holding oldKey with the one
+// of newKey. It answers a challenge with oldKey to prove possession; the
+// instance keeps its grants and debug policies under the new FID.
+func Rekey(serverURL string, oldKey, newKey [32]byte, allowInsecure bool) (*RekeyResult, error) {
+	serverURL, err := checkEnrollmentURL(serverURL, allowInsecure)
+	if err != nil {
+		return nil, err
+	}
+	fid, err := ComputeFID(oldKey)
+	if err != nil {
+		return nil, err
+	}
+	newPub, err := DerivePublicKey(newKey)
+	if err != nil {
+		return nil, err
+	}
+	challengeID, challengeResponse, err := solveChallenge(serverURL, oldKey, fid, allowInsecure)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := json.Marshal(rekeyRequest{
+		FID:               fid,
+		ChallengeID:       challengeID,
+		ChallengeResponse: challengeResponse,
+		NewPublicKey:      hex.EncodeToString(newPub),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("marshal rekey request: %w", err)
+	}
+	req, err := http.NewRequest(http.MethodPost, serverURL+"/v1/rekey", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("create rekey request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := httpClient().Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("rekey: %w", err)
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read rekey response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("/v1/rekey returned %d: %s", resp.StatusCode, string(respBody))
+	}
+
+	var result RekeyResult
+	if err := json.Unmarshal(respBody, &result); err != nil {
+		return nil, fmt.Errorf("unmarshal rekey response: %w", err)
+	}
+	return &result, nil
+}
@@ -0,0 +1,69 @@
+// Package dotenv parses .env files. It is shared by the client, which reads
+// them at run time, and the server's importer, which imports them as fields.
+package dotenv
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Entry represents a single KEY=VALUE pair.
+type Entry struct {
+	Key   string
+	Value string
+}
+
+// ParseFile parses a .env file into key-value entries.
+// Supports KEY=VALUE, KEY="VALUE", KEY='VALUE', # comments, and empty lines.
+func ParseFile(path string) ([]Entry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open env file: %w", err)
+	}
+	defer f.Close()
+	return Parse(f)
+}
+
+// Parse parses .env content from r, with the same syntax as ParseFile.
+func Parse(r io.Reader) ([]Entry, error) {
+	var entries []Entry
+	scanner := bufio.NewScanner(r)
+	lineNum := 0
+
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+
+		// Skip empty lines and comments
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		idx := strings.IndexByte(line, '=')
+		if idx < 0 {
+			return nil, fmt.Errorf("line %d: missing '='", lineNum)
+		}
+
+		key := strings.TrimSpace(line[:idx])
+		value := strings.TrimSpace(line[idx+1:])
+
+		// Strip surrounding quotes
+		if len(value) >= 2 {
+			if (value[0] == '"' && value[len(value)-1] == '"') ||
+				(value[0] == '\'' && value[len(value)-1] == '\'') {
+				value = value[1 : len(value)-1]
+			}
+		}
+
+		entries = append(entries, Entry{Key: key, Value: value})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read env file: %w", err)
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,439 @@
+// Package importer turns plaintext secret exports into vault fields.
+//
+// Three formats are understood:
+//
+//   - dotenv: KEY=VALUE lines, imported as fields of one item and section.
+//   - json: a nested document {"<item>": {"<field>": "v", "<section>":
+//     {"<field>": "v"}}}, or a single item's body when an item is given.
+//   - 1password: the JSON printed by `op item get --format json` (one item or
+//     an array of them), or the export.data file of a .1pux export.
+//
+// Plan compares the parsed fields with what the vault already holds and
+// decides what to write under a conflict policy. The package does not touch
+// the store, so the client can use it too.
+package importer
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/aspect-build/jingui/internal/dotenv"
+	"github.com/aspect-build/jingui/internal/refparser"
+)
+
+// Import formats.
+const (
+	FormatDotenv    = "dotenv"
+	FormatJSON      = "json"
+	Format1Password = "1password"
+)
+
+// Conflict policies for fields that already exist with a different value.
+const (
+	ConflictFail      = "fail"
+	ConflictSkip      = "skip"
+	ConflictOverwrite = "overwrite"
+)
+
+// Actions reported for each imported field.
+const (
+	ActionCreate    = "create"
+	ActionUpdate    = "update"
+	ActionUnchanged = "unchanged"
+	ActionSkip      = "skip"
+	ActionConflict  = "conflict"
+)
+
+// ErrConflict is returned by Plan under ConflictFail when any imported field
+// already exists with a different value.
+var ErrConflict = errors.New("import conflicts with existing fields")
+
+// Key addresses a field within a vault.
+type Key struct {
+	Item    string
+	Section string
+	Name    string
+}
+
+// Field is one imported value.
+type Field struct {
+	Key
+	Value string
+}
+
+// Change describes what an import does to one field. Values are never
+// included.
+type Change struct {
+	Item    string `json:"item"`
+	Section string `json:"section"`
+	Field   string `json:"field"`
+	Action  string `json:"action"`
+}
+
+// Parse decodes data in the given format. item and section name the target
+// of formats that do not carry them: they are required for dotenv, and for
+// json a non-empty item means data is that item's body. Fields are returned
+// sorted by item, section and name.
+func Parse(format string, data []byte, item, section string) ([]Field, error) {
+	var (
+		fields []Field
+		err    error
+	)
+	switch format {
+	case FormatDotenv:
+		fields, err = parseDotenv(data, item, section)
+	case FormatJSON:
+		fields, err = parseJSON(data, item, section)
+	case Format1Password:
+		fields, err = parse1Password(data)
+	default:
+		return nil, fmt.Errorf("unknown format %q (want %s, %s or %s)", format, FormatDotenv, FormatJSON, Format1Password)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, f := range fields {
+		if err := checkName("item", f.Item, false); err != nil {
+			return nil, err
+		}
+		if err := checkName("section", f.Section, true); err != nil {
+			return nil, err
+		}
+		if err := checkName("field", f.Name, false); err != nil {
+			return nil, err
+		}
+	}
+	sort.Slice(fields, func(i, j int) bool { return fields[i].Key.less(fields[j].Key) })
+	for i := 1; i < len(fields); i++ {
+		if fields[i].Key == fields[i-1].Key {
+			k := fields[i].Key
+			return nil, fmt.Errorf("field %q appears twice in item %q section %q", k.Name, k.Item, k.Section)
+		}
+	}
+	return fields, nil
+}
+
+// DetectFormat guesses the format of a file from its name and content:
+// .1pux files and JSON that looks like 1Password items are 1password, other
+// JSON is json, and anything else is dotenv.
+func DetectFormat(name string, data []byte) string {
+	if strings.EqualFold(filepath.Ext(name), ".1pux") {
+		return Format1Password
+	}
+	trimmed := bytes.TrimSpace(data)
+	switch {
+	case bytes.HasPrefix(trimmed, []byte("[")):
+		return Format1Password
+	case !bytes.HasPrefix(trimmed, []byte("{")):
+		return FormatDotenv
+	}
+
+	var probe struct {
+		Accounts json.RawMessage `json:"accounts"`
+		Title    *string         `json:"title"`
+		Fields   json.RawMessage `json:"fields"`
+	}
+	if json.Unmarshal(trimmed, &probe) == nil && (probe.Accounts != nil || (probe.Title != nil && probe.Fields != nil)) {
+		return Format1Password
+	}
+	return FormatJSON
+}
+
+func (k Key) less(o Key) bool {
+	switch {
+	case k.Item != o.Item:
+		return k.Item < o.Item
+	case k.Section != o.Section:
+		return k.Section < o.Section
+	}
+	return k.Name < o.Name
+}
+
+// checkName rejects names that cannot be addressed by a secret reference.
+func checkName(kind, name string, allowEmpty bool) error {
+	if name == "" && !allowEmpty {
+		return fmt.Errorf("empty %s name", kind)
+	}
+	if strings.Contains(name, "/") {
+		return fmt.Errorf("%s name %q contains '/'", kind, name)
+	}
+	return nil
+}
+
+// parseDotenv imports every KEY=VALUE line as a field. Values that are
+// already secret references are left out; a key repeated later in the file
+// wins, as in a shell.
+func parseDotenv(data []byte, item, section string) ([]Field, error) {
+	if item == "" {
+		return nil, fmt.Errorf("an item is required to import a dotenv file")
+	}
+	entries, err := dotenv.Parse(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	values := map[string]string{}
+	for _, e := range entries {
+		if refparser.IsRef(e.Value) {
+			delete(values, e.Key)
+			continue
+		}
+		values[e.Key] = e.Value
+	}
+	fields := make([]Field, 0, len(values))
+	for name, value := range values {
+		fields = append(fields, Field{Key{item, section, name}, value})
+	}
+	return fields, nil
+}
+
+// parseJSON imports a nested document. Scalars are fields; objects directly
+// inside an item are sections.
+func parseJSON(data []byte, item, section string) ([]Field, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var doc map[string]any
+	if err := dec.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("decode JSON: %w", err)
+	}
+
+	var fields []Field
+	addItem := func(item string, body map[string]any) error {
+		for name, v := range body {
+			if sub, ok := v.(map[string]any); ok {
+				if section != "" {
+					return fmt.Errorf("item %q: %q is nested below section %q", item, name, section)
+				}
+				for field, fv := range sub {
+					value, err := jsonScalar(fv)
+					if err != nil {
+						return fmt.Errorf("item %q section %q field %q: %w", item, name, field, err)
+					}
+					fields = append(fields, Field{Key{item, name, field}, value})
+				}
+				continue
+			}
+			value, err := jsonScalar(v)
+			if err != nil {
+				return fmt.Errorf("item %q field %q: %w", item, name, err)
+			}
+			fields = append(fields, Field{Key{item, section, name}, value})
+		}
+		return nil
+	}
+
+	if item != "" {
+		if err := addItem(item, doc); err != nil {
+			return nil, err
+		}
+		return fields, nil
+	}
+	for name, v := range doc {
+		body, ok := v.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("top-level %q must be an object of fields (or pass an item to import a flat document)", name)
+		}
+		if err := addItem(name, body); err != nil {
+			return nil, err
+		}
+	}
+	return fields, nil
+}
+
+// jsonScalar converts a JSON string, number or boolean to a field value.
+func jsonScalar(v any) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case bool:
+		return fmt.Sprint(v), nil
+	case map[string]any:
+		return "", fmt.Errorf("nested too deeply (items hold sections, sections hold fields)")
+	}
+	return "", fmt.Errorf("unsupported value %v", v)
+}
+
+// opItem is an item as printed by `op item get --format json`.
+type opItem struct {
+	Title  string `json:"title"`
+	Fields []struct {
+		ID      string `json:"id"`
+		Label   string `json:"label"`
+		Value   string `json:"value"`
+		Section *struct {
+			Label string `json:"label"`
+		} `json:"section"`
+	} `json:"fields"`
+}
+
+// opExport is the export.data document of a .1pux export.
+type opExport struct {
+	Accounts []struct {
+		Vaults []struct {
+			Items []struct {
+				State    string `json:"state"`
+				Overview struct {
+					Title string `json:"title"`
+				} `json:"overview"`
+				Details struct {
+					LoginFields []struct {
+						Name        string `json:"name"`
+						Designation string `json:"designation"`
+						Value       string `json:"value"`
+					} `json:"loginFields"`
+					Password   string `json:"password"`
+					NotesPlain string `json:"notesPlain"`
+					Sections   []struct {
+						Title  string `json:"title"`
+						Fields []struct {
+							ID    string                     `json:"id"`
+							Title string                     `json:"title"`
+							Value map[string]json.RawMessage `json:"value"`
+						} `json:"fields"`
+					} `json:"sections"`
+				} `json:"details"`
+			} `json:"items"`
+		} `json:"vaults"`
+	} `json:"accounts"`
+}
+
+// parse1Password imports 1Password items under their titles, with fields in
+// the section they have in 1Password. Empty fields and archived items are
+// left out.
+func parse1Password(data []byte) ([]Field, error) {
+	trimmed := bytes.TrimSpace(data)
+	var fields []Field
+	add := func(item, section, name, value string) {
+		if value != "" {
+			fields = append(fields, Field{Key{item, section, name}, value})
+		}
+	}
+
+	if bytes.HasPrefix(trimmed, []byte("{")) {
+		var export opExport
+		if err := json.Unmarshal(trimmed, &export); err != nil {
+			return nil, fmt.Errorf("decode 1Password export: %w", err)
+		}
+		if len(export.Accounts) > 0 {
+			for _, acct := range export.Accounts {
+				for _, vault := range acct.Vaults {
+					for _, it := range vault.Items {
+						if it.State != "" && it.State != "active" {
+							continue
+						}
+						title, d := it.Overview.Title, it.Details
+						for _, f := range d.LoginFields {
+							name := f.Designation
+							if name == "" {
+								name = f.Name
+							}
+							add(title, "", name, f.Value)
+						}
+						add(title, "", "password", d.Password)
+						add(title, "", "notes", d.NotesPlain)
+						for _, sec := range d.Sections {
+							for _, f := range sec.Fields {
+								name := f.Title
+								if name == "" {
+									name = f.ID
+								}
+								add(title, sec.Title, name, opExportValue(f.Value))
+							}
+						}
+					}
+				}
+			}
+			return fields, nil
+		}
+		trimmed = append(append([]byte("["), trimmed...), ']')
+	}
+
+	var items []opItem
+	if err := json.Unmarshal(trimmed, &items); err != nil {
+		return nil, fmt.Errorf("decode 1Password items: %w", err)
+	}
+	for _, it := range items {
+		for _, f := range it.Fields {
+			section := ""
+			if f.Section != nil {
+				section = f.Section.Label
+			}
+			name := f.Label
+			if name == "" {
+				name = f.ID
+			}
+			add(it.Title, section, name, f.Value)
+		}
+	}
+	return fields, nil
+}
+
+// opExportValue returns the text of a .1pux field value, which is an object
+// keyed by the field type, e.g. {"concealed": "..."}. Values that are not
+// text, such as dates, are left out.
+func opExportValue(v map[string]json.RawMessage) string {
+	for _, raw := range v {
+		var s string
+		if json.Unmarshal(raw, &s) == nil && s != "" {
+			return s
+		}
+	}
+	return ""
+}
+
+// Plan compares fields with the vault's current values and returns the change
+// for every field plus the fields that need writing. A field that exists with
+// a different value is overwritten, skipped or reported as a conflict
+// depending on policy; under ConflictFail any conflict makes Plan return
+// ErrConflict along with the full list of changes.
+func Plan(fields []Field, current map[Key]string, policy string) ([]Change, []Field, error) {
+	switch policy {
+	case ConflictFail, ConflictSkip, ConflictOverwrite:
+	default:
+		return nil, nil, fmt.Errorf("unknown conflict policy %q (want %s, %s or %s)", policy, ConflictFail, ConflictSkip, ConflictOverwrite)
+	}
+
+	changes := make([]Change, 0, len(fields))
+	var writes []Field
+	conflicts := 0
+	for _, f := range fields {
+		action := ActionCreate
+		if existing, ok := current[f.Key]; ok {
+			switch {
+			case existing == f.Value:
+				action = ActionUnchanged
+			case policy == ConflictOverwrite:
+				action = ActionUpdate
+			case policy == ConflictSkip:
+				action = ActionSkip
+			default:
+				action = ActionConflict
+				conflicts++
+			}
+		}
+		changes = append(changes, Change{Item: f.Item, Section: f.Section, Field: f.Name, Action: action})
+		if action == ActionCreate || action == ActionUpdate {
+			writes = append(writes, f)
+		}
+	}
+	if conflicts > 0 {
+		return changes, nil, fmt.Errorf("%w: %d field(s)", ErrConflict, conflicts)
+	}
+	return changes, writes, nil
+}
+
+// Summarize counts changes by action.
+func Summarize(changes []Change) map[string]int {
+	summary := map[string]int{}
+	for _, c := range changes {
+		summary[c.Action]++
+	}
+	return summary
+}
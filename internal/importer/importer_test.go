@@ -0,0 +1,177 @@
+package importer
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParseDotenv(t *testing.T) {
+	data := []byte(`# comment
+API_KEY=one
+DB_URL="postgres://db"
+REF=jingui://v1/alice/token
+API_KEY=two
+`)
+	fields, err := Parse(FormatDotenv, data, "app", "prod")
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	want := []Field{
+		{Key{"app", "prod", "API_KEY"}, "two"},
+		{Key{"app", "prod", "DB_URL"}, "postgres://db"},
+	}
+	if !reflect.DeepEqual(fields, want) {
+		t.Errorf("fields = %+v, want %+v", fields, want)
+	}
+
+	if _, err := Parse(FormatDotenv, data, "", ""); err == nil {
+		t.Error("expected an item to be required for dotenv")
+	}
+}
+
+func TestParseJSON(t *testing.T) {
+	data := []byte(`{
+		"alice": {"token": "t", "port": 5432, "oauth": {"refresh": "r"}},
+		"bob": {"enabled": true}
+	}`)
+	fields, err := Parse(FormatJSON, data, "", "")
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	want := []Field{
+		{Key{"alice", "", "port"}, "5432"},
+		{Key{"alice", "", "token"}, "t"},
+		{Key{"alice", "oauth", "refresh"}, "r"},
+		{Key{"bob", "", "enabled"}, "true"},
+	}
+	if !reflect.DeepEqual(fields, want) {
+		t.Errorf("fields = %+v, want %+v", fields, want)
+	}
+
+	flat, err := Parse(FormatJSON, []byte(`{"token": "t"}`), "alice", "oauth")
+	if err != nil || len(flat) != 1 || flat[0].Key != (Key{"alice", "oauth", "token"}) {
+		t.Errorf("flat import = %+v, %v", flat, err)
+	}
+
+	for name, bad := range map[string]string{
+		"scalar item":   `{"alice": "t"}`,
+		"too deep":      `{"alice": {"oauth": {"token": {"x": "y"}}}}`,
+		"slash in name": `{"alice": {"a/b": "t"}}`,
+		"null value":    `{"alice": {"token": null}}`,
+	} {
+		if _, err := Parse(FormatJSON, []byte(bad), "", ""); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestParse1Password(t *testing.T) {
+	item := []byte(`{
+		"title": "github",
+		"fields": [
+			{"id": "username", "label": "username", "value": "octocat"},
+			{"id": "password", "label": "password", "value": "s3cret"},
+			{"id": "notesPlain", "label": "notesPlain", "value": ""},
+			{"id": "abc", "label": "token", "value": "ghp_x", "section": {"id": "s1", "label": "api"}}
+		]
+	}`)
+	if got := DetectFormat("item.json", item); got != Format1Password {
+		t.Errorf("DetectFormat = %q, want %q", got, Format1Password)
+	}
+	fields, err := Parse(Format1Password, item, "", "")
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	want := []Field{
+		{Key{"github", "", "password"}, "s3cret"},
+		{Key{"github", "", "username"}, "octocat"},
+		{Key{"github", "api", "token"}, "ghp_x"},
+	}
+	if !reflect.DeepEqual(fields, want) {
+		t.Errorf("fields = %+v, want %+v", fields, want)
+	}
+
+	export := []byte(`{"accounts": [{"vaults": [{"items": [
+		{"state": "active", "overview": {"title": "db"}, "details": {
+			"loginFields": [{"name": "user", "designation": "username", "value": "admin"}],
+			"password": "pw",
+			"sections": [{"title": "extra", "fields": [{"id": "k", "title": "api key", "value": {"concealed": "key"}}]}]
+		}},
+		{"state": "archived", "overview": {"title": "old"}, "details": {"password": "gone"}}
+	]}]}]}`)
+	if got := DetectFormat("export.data", export); got != Format1Password {
+		t.Errorf("DetectFormat(export) = %q, want %q", got, Format1Password)
+	}
+	fields, err = Parse(Format1Password, export, "", "")
+	if err != nil {
+		t.Fatalf("Parse export: %v", err)
+	}
+	want = []Field{
+		{Key{"db", "", "password"}, "pw"},
+		{Key{"db", "", "username"}, "admin"},
+		{Key{"db", "extra", "api key"}, "key"},
+	}
+	if !reflect.DeepEqual(fields, want) {
+		t.Errorf("export fields = %+v, want %+v", fields, want)
+	}
+}
+
+func TestDetectFormat(t *testing.T) {
+	for _, tc := range []struct {
+		name, data, want string
+	}{
+		{".env", "A=1\n", FormatDotenv},
+		{"secrets.json", `{"alice": {"token": "t"}}`, FormatJSON},
+		{"items.json", `[{"title": "x", "fields": []}]`, Format1Password},
+		{"export.1pux", "PK\x03\x04", Format1Password},
+	} {
+		if got := DetectFormat(tc.name, []byte(tc.data)); got != tc.want {
+			t.Errorf("DetectFormat(%q) = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestPlan(t *testing.T) {
+	fields := []Field{
+		{Key{"alice", "", "new"}, "n"},
+		{Key{"alice", "", "same"}, "s"},
+		{Key{"alice", "", "diff"}, "incoming"},
+		{Key{"bob", "oauth", "token"}, "t"},
+	}
+	current := map[Key]string{
+		{"alice", "", "same"}: "s",
+		{"alice", "", "diff"}: "existing",
+	}
+
+	changes, writes, err := Plan(fields, current, ConflictFail)
+	if !errors.Is(err, ErrConflict) || writes != nil {
+		t.Fatalf("fail policy: err = %v, writes = %v", err, writes)
+	}
+	if changes[2].Action != ActionConflict {
+		t.Errorf("diff action = %q, want conflict", changes[2].Action)
+	}
+
+	changes, writes, err = Plan(fields, current, ConflictSkip)
+	if err != nil {
+		t.Fatalf("skip policy: %v", err)
+	}
+	if got := Summarize(changes); !reflect.DeepEqual(got, map[string]int{ActionCreate: 2, ActionUnchanged: 1, ActionSkip: 1}) {
+		t.Errorf("skip summary = %v", got)
+	}
+	if !reflect.DeepEqual(writes, []Field{fields[0], fields[3]}) {
+		t.Errorf("skip writes = %+v", writes)
+	}
+
+	changes, writes, err = Plan(fields, current, ConflictOverwrite)
+	if err != nil {
+		t.Fatalf("overwrite policy: %v", err)
+	}
+	if changes[2].Action != ActionUpdate || !reflect.DeepEqual(writes, []Field{fields[0], fields[2], fields[3]}) {
+		t.Errorf("overwrite: changes = %+v, writes = %+v", changes, writes)
+	}
+
+	if _, _, err := Plan(fields, current, "merge"); err == nil {
+		t.Error("expected an unknown policy to be rejected")
+	}
+}
@@ -2,39 +2,44 @@ package internal
 
 import (
 	"bytes"
+	"context"
 	"crypto/rand"
 	"crypto/sha1"
 	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/aspect-build/jingui/internal/client"
 	"github.com/aspect-build/jingui/internal/crypto"
 	"github.com/aspect-build/jingui/internal/server"
 	"github.com/aspect-build/jingui/internal/server/db"
+	"github.com/aspect-build/jingui/internal/server/eventsink"
 	"golang.org/x/crypto/curve25519"
 )
 
 const testAdminToken = "test-admin-token-1234567890"
 
-func setupTestServer(t *testing.T) (*httptest.Server, *db.Store) {
+func setupTestServer(t *testing.T) (*httptest.Server, db.Store) {
 	t.Helper()
 
-	store, err := db.NewStore(":memory:")
-	if err != nil {
-		t.Fatalf("NewStore: %v", err)
-	}
-	t.Cleanup(func() { store.Close() })
+	store := db.NewMemoryStore()
 
 	cfg := &server.Config{
 		AdminToken: testAdminToken,
 	}
 
-	router := server.NewRouter(store, cfg)
+	router := server.NewRouter(store, cfg, nil)
 	ts := httptest.NewServer(router)
 	t.Cleanup(ts.Close)
 
@@ -106,7 +111,7 @@ func TestEndToEnd(t *testing.T) {
 	resp.Body.Close()
 
 	// Step 2: Insert vault items (plaintext fields)
-	err = store.SetItemFields("gmail-vault", "alice@gmail.com", map[string]string{
+	err = store.SetItemFields("gmail-vault", "alice@gmail.com", "", map[string]string{
 		"password":      "test-password-value",
 		"refresh_token": "test-refresh-token-value",
 		"api_key":       "test-api-key-value",
@@ -260,6 +265,766 @@ func TestEndToEnd(t *testing.T) {
 	fmt.Println("Integration test: all secrets resolved, encrypted, decrypted, and access control verified")
 }
 
+// registerTestInstance registers a fresh TEE instance with access to vaultID.
+func registerTestInstance(t *testing.T, store db.Store, vaultID string) (string, [32]byte) {
+	t.Helper()
+
+	var teePriv [32]byte
+	rand.Read(teePriv[:])
+	teePub, _ := curve25519.X25519(teePriv[:], curve25519.Basepoint)
+	h := sha1.Sum(teePub)
+	fid := hex.EncodeToString(h[:])
+
+	if err := store.RegisterInstance(&db.TEEInstance{FID: fid, PublicKey: teePub, DstackAppID: "dstack-app-1"}); err != nil {
+		t.Fatalf("RegisterInstance: %v", err)
+	}
+	if err := store.GrantVaultAccess(vaultID, fid, nil, nil); err != nil {
+		t.Fatalf("GrantVaultAccess: %v", err)
+	}
+	return fid, teePriv
+}
+
+// fetchSecrets solves a challenge and fetches refs. On success it returns the
+// decrypted values keyed by reference; otherwise the HTTP status.
+func fetchSecrets(t *testing.T, serverURL, fid string, teePriv [32]byte, refs ...string) (map[string]string, int) {
+	t.Helper()
+
+	challengeID, challengeResponse := solveFetchChallenge(t, serverURL, fid, teePriv)
+	body, _ := json.Marshal(map[string]interface{}{
+		"fid":                fid,
+		"secret_references":  refs,
+		"challenge_id":       challengeID,
+		"challenge_response": challengeResponse,
+	})
+	resp, err := http.Post(serverURL+"/v1/secrets/fetch", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("POST /v1/secrets/fetch: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, resp.StatusCode
+	}
+
+	var fetchResp struct {
+		Secrets map[string]string `json:"secrets"`
+	}
+	json.NewDecoder(resp.Body).Decode(&fetchResp)
+	out := make(map[string]string, len(fetchResp.Secrets))
+	for ref, b64 := range fetchResp.Secrets {
+		blob, _ := base64.StdEncoding.DecodeString(b64)
+		plain, err := crypto.Decrypt(teePriv, blob)
+		if err != nil {
+			t.Fatalf("Decrypt %s: %v", ref, err)
+		}
+		out[ref] = string(plain)
+	}
+	return out, resp.StatusCode
+}
+
+func TestFetchPinnedVersion(t *testing.T) {
+	ts, store := setupTestServer(t)
+	store.CreateVault(&db.Vault{ID: "v1", Name: "V1"})
+	store.UpsertField("v1", "alice", "", "token", "old-token")
+	store.UpsertField("v1", "alice", "", "token", "rotated-token")
+	fid, priv := registerTestInstance(t, store, "v1")
+
+	secrets, status := fetchSecrets(t, ts.URL, fid, priv,
+		"jingui://v1/alice/token",
+		"jingui://v1/alice/token@1",
+		"jingui://v1/alice/token?version=2",
+	)
+	if status != http.StatusOK {
+		t.Fatalf("fetch: status %d", status)
+	}
+	want := map[string]string{
+		"jingui://v1/alice/token":           "rotated-token",
+		"jingui://v1/alice/token@1":         "old-token",
+		"jingui://v1/alice/token?version=2": "rotated-token",
+	}
+	for ref, v := range want {
+		if secrets[ref] != v {
+			t.Errorf("%s = %q, want %q", ref, secrets[ref], v)
+		}
+	}
+
+	if _, status := fetchSecrets(t, ts.URL, fid, priv, "jingui://v1/alice/token@7"); status != http.StatusNotFound {
+		t.Errorf("missing version: expected 404, got %d", status)
+	}
+	if _, status := fetchSecrets(t, ts.URL, fid, priv, "jingui://v1/alice/token?rev=1"); status != http.StatusBadRequest {
+		t.Errorf("bad selector: expected 400, got %d", status)
+	}
+}
+
+func TestFetchSectionRefs(t *testing.T) {
+	ts, store := setupTestServer(t)
+	store.CreateVault(&db.Vault{ID: "v1", Name: "V1"})
+	fid, priv := registerTestInstance(t, store, "v1")
+
+	for section, value := range map[string]string{"": "default-token", "oauth": "oauth-token", "other": "other-token"} {
+		body, _ := json.Marshal(map[string]interface{}{"fields": map[string]string{"token": value}})
+		resp, err := adminRequest("PUT", ts.URL+"/v1/vaults/v1/items/alice?section="+section, body)
+		if err != nil {
+			t.Fatalf("PUT section %q: %v", section, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("PUT section %q: expected 200, got %d", section, resp.StatusCode)
+		}
+	}
+
+	secrets, status := fetchSecrets(t, ts.URL, fid, priv,
+		"jingui://v1/alice/token",
+		"jingui://v1/alice/oauth/token",
+		"jingui://v1/alice/other/token",
+	)
+	if status != http.StatusOK {
+		t.Fatalf("fetch: status %d", status)
+	}
+	want := map[string]string{
+		"jingui://v1/alice/token":       "default-token",
+		"jingui://v1/alice/oauth/token": "oauth-token",
+		"jingui://v1/alice/other/token": "other-token",
+	}
+	for ref, v := range want {
+		if secrets[ref] != v {
+			t.Errorf("%s = %q, want %q", ref, secrets[ref], v)
+		}
+	}
+	if _, status := fetchSecrets(t, ts.URL, fid, priv, "jingui://v1/alice/missing/token"); status != http.StatusNotFound {
+		t.Errorf("unknown section: expected 404, got %d", status)
+	}
+
+	resp, _ := adminRequest("GET", ts.URL+"/v1/vaults/v1/items/alice?section=oauth", nil)
+	var item struct {
+		Item     string   `json:"item"`
+		Section  string   `json:"section"`
+		Keys     []string `json:"keys"`
+		Sections []string `json:"sections"`
+	}
+	json.NewDecoder(resp.Body).Decode(&item)
+	resp.Body.Close()
+	if item.Item != "alice" || item.Section != "oauth" || len(item.Keys) != 1 || len(item.Sections) != 3 {
+		t.Errorf("unexpected item response: %+v", item)
+	}
+
+	resp, _ = adminRequest("DELETE", ts.URL+"/v1/vaults/v1/items/alice?section=oauth", nil)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("DELETE section: expected 200, got %d", resp.StatusCode)
+	}
+	if v, err := store.GetFieldValue("v1", "alice", "other", "token"); err != nil || v != "other-token" {
+		t.Errorf("other section after deleting oauth = %q, %v", v, err)
+	}
+}
+
+func TestFetchExpiredSecret(t *testing.T) {
+	ts, store := setupTestServer(t)
+	store.CreateVault(&db.Vault{ID: "v1", Name: "V1"})
+	store.UpsertField("v1", "stripe", "", "api_key", "sk_live_old")
+	store.UpsertField("v1", "stripe", "", "webhook", "whsec")
+	store.UpsertField("v1", "stripe", "test", "api_key", "sk_test")
+	fid, priv := registerTestInstance(t, store, "v1")
+
+	putExpiry := func(query string, at time.Time) int {
+		body, _ := json.Marshal(map[string]string{"expires_at": at.Format(time.RFC3339)})
+		resp, err := adminRequest("PUT", ts.URL+"/v1/vaults/v1/items/stripe/expiry"+query, body)
+		if err != nil {
+			t.Fatalf("PUT expiry: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if status := putExpiry("?field=api_key", time.Now().Add(-time.Hour)); status != http.StatusOK {
+		t.Fatalf("PUT field expiry: expected 200, got %d", status)
+	}
+	if status := putExpiry("?section=test", time.Now().Add(10*24*time.Hour)); status != http.StatusOK {
+		t.Fatalf("PUT section expiry: expected 200, got %d", status)
+	}
+	if status := putExpiry("?field=missing", time.Now()); status != http.StatusNotFound {
+		t.Errorf("PUT expiry of missing field: expected 404, got %d", status)
+	}
+
+	for _, ref := range []string{"jingui://v1/stripe/api_key", "jingui://v1/stripe/api_key@1"} {
+		if _, status := fetchSecrets(t, ts.URL, fid, priv, ref); status != http.StatusGone {
+			t.Errorf("fetch %s: expected 410, got %d", ref, status)
+		}
+	}
+	secrets, status := fetchSecrets(t, ts.URL, fid, priv, "jingui://v1/stripe/webhook", "jingui://v1/stripe/test/api_key")
+	if status != http.StatusOK || secrets["jingui://v1/stripe/test/api_key"] != "sk_test" {
+		t.Errorf("fetch unexpired secrets: %d %v", status, secrets)
+	}
+
+	listExpiring := func(query string) []map[string]interface{} {
+		resp, _ := adminRequest("GET", ts.URL+"/v1/vaults/v1/expiring"+query, nil)
+		defer resp.Body.Close()
+		var out []map[string]interface{}
+		json.NewDecoder(resp.Body).Decode(&out)
+		return out
+	}
+	if got := listExpiring(""); len(got) != 2 || got[0]["field_name"] != "api_key" || got[0]["expired"] != true || got[1]["section"] != "test" || got[1]["expired"] != false {
+		t.Errorf("expiring within 30 days = %v", got)
+	}
+	if got := listExpiring("?within_days=1"); len(got) != 1 {
+		t.Errorf("expiring within 1 day = %v, want only the expired field", got)
+	}
+
+	resp, _ := adminRequest("DELETE", ts.URL+"/v1/vaults/v1/items/stripe/expiry?field=api_key", nil)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("DELETE expiry: expected 200, got %d", resp.StatusCode)
+	}
+	if secrets, status := fetchSecrets(t, ts.URL, fid, priv, "jingui://v1/stripe/api_key"); status != http.StatusOK || secrets["jingui://v1/stripe/api_key"] != "sk_live_old" {
+		t.Errorf("fetch after clearing expiry: %d %v", status, secrets)
+	}
+}
+
+func TestFetchFieldGrants(t *testing.T) {
+	ts, store := setupTestServer(t)
+	store.CreateVault(&db.Vault{ID: "v1", Name: "V1"})
+	store.UpsertField("v1", "db", "prod", "password", "prod-pass")
+	store.UpsertField("v1", "db", "prod", "root_password", "prod-root")
+	store.UpsertField("v1", "db", "staging", "password", "staging-pass")
+	store.UpsertField("v1", "stripe", "", "api_key", "sk_live")
+	fid, priv := registerTestInstance(t, store, "v1")
+	store.RevokeVaultAccess("v1", fid)
+
+	putGrant := func(grant map[string]string) int {
+		grant["fid"] = fid
+		body, _ := json.Marshal(grant)
+		resp, err := adminRequest("PUT", ts.URL+"/v1/vaults/v1/grants", body)
+		if err != nil {
+			t.Fatalf("PUT grant: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if status := putGrant(map[string]string{"item": "db", "section": "prod"}); status != http.StatusOK {
+		t.Fatalf("PUT section grant: expected 200, got %d", status)
+	}
+	if status := putGrant(map[string]string{"item": "db", "section": "prod", "field": "root_*", "effect": "deny"}); status != http.StatusOK {
+		t.Fatalf("PUT deny grant: expected 200, got %d", status)
+	}
+	if status := putGrant(map[string]string{"item": "db", "field": "[", "effect": "allow"}); status != http.StatusBadRequest {
+		t.Errorf("PUT malformed grant: expected 400, got %d", status)
+	}
+	if status := putGrant(map[string]string{"item": "db", "effect": "maybe"}); status != http.StatusBadRequest {
+		t.Errorf("PUT grant with unknown effect: expected 400, got %d", status)
+	}
+	body, _ := json.Marshal(map[string]string{"fid": "missing", "item": "db"})
+	resp, _ := adminRequest("PUT", ts.URL+"/v1/vaults/v1/grants", body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("PUT grant for a missing instance: expected 404, got %d", resp.StatusCode)
+	}
+
+	secrets, status := fetchSecrets(t, ts.URL, fid, priv, "jingui://v1/db/prod/password")
+	if status != http.StatusOK || secrets["jingui://v1/db/prod/password"] != "prod-pass" {
+		t.Errorf("fetch granted field: %d %v", status, secrets)
+	}
+	for _, ref := range []string{"jingui://v1/db/prod/root_password", "jingui://v1/db/staging/password", "jingui://v1/stripe/api_key"} {
+		if _, status := fetchSecrets(t, ts.URL, fid, priv, ref); status != http.StatusForbidden {
+			t.Errorf("fetch %s: expected 403, got %d", ref, status)
+		}
+	}
+
+	resp, _ = adminRequest("GET", ts.URL+"/v1/vaults/v1/grants?fid="+fid, nil)
+	var grants []db.FieldGrant
+	json.NewDecoder(resp.Body).Decode(&grants)
+	resp.Body.Close()
+	if len(grants) != 2 || grants[0].FieldName != "*" || grants[1].FieldName != "root_*" || grants[1].Effect != db.GrantDeny {
+		t.Errorf("GET grants = %+v, want the section grant and the deny", grants)
+	}
+
+	resp, _ = adminRequest("DELETE", ts.URL+"/v1/vaults/v1/grants?fid="+fid+"&item=db&section=prod", nil)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("DELETE grant: expected 200, got %d", resp.StatusCode)
+	}
+	if _, status := fetchSecrets(t, ts.URL, fid, priv, "jingui://v1/db/prod/password"); status != http.StatusForbidden {
+		t.Errorf("fetch after deleting the grant: expected 403, got %d", status)
+	}
+	resp, _ = adminRequest("DELETE", ts.URL+"/v1/vaults/v1/grants?fid="+fid+"&item=db&section=prod", nil)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("DELETE missing grant: expected 404, got %d", resp.StatusCode)
+	}
+}
+
+func TestGrantWindow_HTTP(t *testing.T) {
+	ts, store := setupTestServer(t)
+	store.CreateVault(&db.Vault{ID: "v1", Name: "V1"})
+	store.UpsertField("v1", "batch", "", "token", "batch-token")
+	fid, priv := registerTestInstance(t, store, "v1")
+
+	grant := func(window map[string]time.Time) int {
+		body, _ := json.Marshal(window)
+		resp, err := adminRequest("POST", ts.URL+"/v1/vaults/v1/instances/"+fid, body)
+		if err != nil {
+			t.Fatalf("POST grant: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	grantStatus := func() string {
+		resp, _ := adminRequest("GET", ts.URL+"/v1/vaults/v1/instances", nil)
+		defer resp.Body.Close()
+		var instances []struct {
+			FID         string `json:"fid"`
+			GrantStatus string `json:"grant_status"`
+		}
+		json.NewDecoder(resp.Body).Decode(&instances)
+		if len(instances) != 1 || instances[0].FID != fid {
+			t.Fatalf("GET vault instances = %+v, want the batch instance", instances)
+		}
+		return instances[0].GrantStatus
+	}
+
+	now := time.Now()
+	if status := grant(map[string]time.Time{"expires_at": now.Add(-time.Minute)}); status != http.StatusBadRequest {
+		t.Errorf("grant expiring in the past: expected 400, got %d", status)
+	}
+	if status := grant(map[string]time.Time{"not_before": now.Add(time.Hour), "expires_at": now.Add(time.Minute)}); status != http.StatusBadRequest {
+		t.Errorf("grant ending before it starts: expected 400, got %d", status)
+	}
+
+	if status := grant(map[string]time.Time{"not_before": now.Add(time.Hour)}); status != http.StatusOK {
+		t.Fatalf("pending grant: expected 200, got %d", status)
+	}
+	if grantStatus() != db.GrantPending {
+		t.Errorf("grant_status = %q, want pending", grantStatus())
+	}
+	if _, status := fetchSecrets(t, ts.URL, fid, priv, "jingui://v1/batch/token"); status != http.StatusForbidden {
+		t.Errorf("fetch before not_before: expected 403, got %d", status)
+	}
+
+	if status := grant(map[string]time.Time{"expires_at": now.Add(time.Hour)}); status != http.StatusOK {
+		t.Fatalf("day-long grant: expected 200, got %d", status)
+	}
+	if secrets, status := fetchSecrets(t, ts.URL, fid, priv, "jingui://v1/batch/token"); status != http.StatusOK || secrets["jingui://v1/batch/token"] != "batch-token" {
+		t.Errorf("fetch inside the window: %d %v", status, secrets)
+	}
+
+	// The API refuses past expiries, so let the grant lapse in the store.
+	expired := now.Add(-time.Minute)
+	store.GrantVaultAccess("v1", fid, nil, &expired)
+	if grantStatus() != db.GrantExpired {
+		t.Errorf("grant_status = %q, want expired", grantStatus())
+	}
+	if _, status := fetchSecrets(t, ts.URL, fid, priv, "jingui://v1/batch/token"); status != http.StatusForbidden {
+		t.Errorf("fetch after expires_at: expected 403, got %d", status)
+	}
+
+	// Posting without a body makes the grant permanent again.
+	resp, _ := adminRequest("POST", ts.URL+"/v1/vaults/v1/instances/"+fid, nil)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || grantStatus() != db.GrantActive {
+		t.Errorf("permanent re-grant: %d, grant_status %q", resp.StatusCode, grantStatus())
+	}
+}
+
+func TestAppGrants_HTTP(t *testing.T) {
+	ts, store := setupTestServer(t)
+	store.CreateVault(&db.Vault{ID: "v1", Name: "V1"})
+	store.UpsertField("v1", "db", "", "password", "hunter2")
+	fid, priv := registerTestInstance(t, store, "v1")
+	store.RevokeVaultAccess("v1", fid)
+
+	resp, _ := adminRequest("POST", ts.URL+"/v1/vaults/missing/apps/dstack-app-1", nil)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("grant on a missing vault: expected 404, got %d", resp.StatusCode)
+	}
+	resp, _ = adminRequest("POST", ts.URL+"/v1/vaults/v1/apps/dstack-app-1", nil)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("grant app: expected 200, got %d", resp.StatusCode)
+	}
+
+	resp, _ = adminRequest("GET", ts.URL+"/v1/vaults/v1/apps", nil)
+	var apps []struct {
+		DstackAppID string `json:"dstack_app_id"`
+		GrantStatus string `json:"grant_status"`
+	}
+	json.NewDecoder(resp.Body).Decode(&apps)
+	resp.Body.Close()
+	if len(apps) != 1 || apps[0].DstackAppID != "dstack-app-1" || apps[0].GrantStatus != db.GrantActive {
+		t.Errorf("GET apps = %+v, want the active dstack-app-1 grant", apps)
+	}
+
+	// The test server runs without strict RA-TLS, so the instance's app ID
+	// is never verified and app grants do not apply to it.
+	if _, status := fetchSecrets(t, ts.URL, fid, priv, "jingui://v1/db/password"); status != http.StatusForbidden {
+		t.Errorf("fetch with an unverified app ID: expected 403, got %d", status)
+	}
+
+	resp, _ = adminRequest("DELETE", ts.URL+"/v1/vaults/v1/apps/dstack-app-1", nil)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("revoke app: expected 200, got %d", resp.StatusCode)
+	}
+	resp, _ = adminRequest("DELETE", ts.URL+"/v1/vaults/v1/apps/dstack-app-1", nil)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("revoke missing app grant: expected 404, got %d", resp.StatusCode)
+	}
+}
+
+func TestEnrollmentPolicies_HTTP(t *testing.T) {
+	ts, store := setupTestServer(t)
+	store.CreateVault(&db.Vault{ID: "v1", Name: "V1"})
+
+	body, _ := json.Marshal(map[string]any{"label": "web-{instance_id}", "vaults": []string{"missing"}})
+	resp, _ := adminRequest("PUT", ts.URL+"/v1/enrollment-policies/dstack-app-1", body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("policy granting a missing vault: expected 404, got %d", resp.StatusCode)
+	}
+	body, _ = json.Marshal(map[string]any{"label": "web-{instance_id}", "vaults": []string{"v1"}})
+	resp, _ = adminRequest("PUT", ts.URL+"/v1/enrollment-policies/dstack-app-1", body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("put policy: expected 200, got %d", resp.StatusCode)
+	}
+
+	resp, _ = adminRequest("GET", ts.URL+"/v1/enrollment-policies", nil)
+	var policies []struct {
+		DstackAppID string   `json:"dstack_app_id"`
+		Label       string   `json:"label"`
+		Vaults      []string `json:"vaults"`
+	}
+	json.NewDecoder(resp.Body).Decode(&policies)
+	resp.Body.Close()
+	if len(policies) != 1 || policies[0].DstackAppID != "dstack-app-1" || policies[0].Label != "web-{instance_id}" || len(policies[0].Vaults) != 1 {
+		t.Errorf("GET enrollment policies = %+v", policies)
+	}
+
+	// Enrollment needs a verifiable attestation, not the admin token.
+	body, _ = json.Marshal(map[string]any{
+		"public_key":  strings.Repeat("ab", 32),
+		"attestation": map[string]string{"app_id": "dstack-app-1", "app_cert": "not a certificate"},
+		"signature":   "c2ln",
+	})
+	resp, _ = http.Post(ts.URL+"/v1/enroll", "application/json", bytes.NewReader(body))
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("enroll with a bogus attestation: expected 401, got %d", resp.StatusCode)
+	}
+	if insts, _ := store.ListInstances(); len(insts) != 0 {
+		t.Errorf("instances registered by a bogus enrollment: %+v", insts)
+	}
+
+	resp, _ = adminRequest("DELETE", ts.URL+"/v1/enrollment-policies/dstack-app-1", nil)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("delete policy: expected 200, got %d", resp.StatusCode)
+	}
+	resp, _ = adminRequest("DELETE", ts.URL+"/v1/enrollment-policies/dstack-app-1", nil)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("delete missing policy: expected 404, got %d", resp.StatusCode)
+	}
+}
+
+func TestEnrollmentTokens_HTTP(t *testing.T) {
+	ts, store := setupTestServer(t)
+	store.CreateVault(&db.Vault{ID: "v1", Name: "V1"})
+	store.UpsertField("v1", "db", "", "password", "hunter2")
+
+	body, _ := json.Marshal(map[string]any{
+		"dstack_app_id": "dstack-app-1",
+		"label":         "ci-{fid}",
+		"vaults":        []string{"v1"},
+		"expires_at":    time.Now().Add(time.Hour).UTC().Format(time.RFC3339),
+	})
+	resp, _ := adminRequest("POST", ts.URL+"/v1/enrollment-tokens", body)
+	var created struct {
+		ID    string `json:"id"`
+		Token string `json:"token"`
+	}
+	json.NewDecoder(resp.Body).Decode(&created)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated || created.Token == "" {
+		t.Fatalf("create token: status %d, %+v", resp.StatusCode, created)
+	}
+
+	// The token registers one instance, which can then fetch its secrets.
+	var teePriv [32]byte
+	rand.Read(teePriv[:])
+	result, err := client.RegisterWithToken(ts.URL, teePriv, created.Token, "dstack-app-1", true)
+	if err != nil {
+		t.Fatalf("RegisterWithToken: %v", err)
+	}
+	fid, _ := client.ComputeFID(teePriv)
+	if result.FID != fid || result.Status != "registered" || result.Label != "ci-"+fid {
+		t.Errorf("RegisterWithToken = %+v", result)
+	}
+	secrets, status := fetchSecrets(t, ts.URL, fid, teePriv, "jingui://v1/db/password")
+	if status != http.StatusOK || secrets["jingui://v1/db/password"] != "hunter2" {
+		t.Errorf("fetch after registering with a token: status %d, %v", status, secrets)
+	}
+
+	var otherPriv [32]byte
+	rand.Read(otherPriv[:])
+	if _, err := client.RegisterWithToken(ts.URL, otherPriv, created.Token, "", true); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("register with a used-up token: err = %v, want 401", err)
+	}
+
+	resp, _ = adminRequest("DELETE", ts.URL+"/v1/enrollment-tokens/"+created.ID, nil)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("delete token: expected 200, got %d", resp.StatusCode)
+	}
+	events, _ := store.ListAuditEvents(db.AuditQuery{Action: "enrollment_token.delete"})
+	if len(events) != 1 || events[0].VaultID != "" {
+		t.Errorf("enrollment_token.delete audit events = %+v", events)
+	}
+}
+
+func TestRekeyInstance_HTTP(t *testing.T) {
+	ts, store := setupTestServer(t)
+	store.CreateVault(&db.Vault{ID: "v1", Name: "V1"})
+	store.UpsertField("v1", "db", "", "password", "hunter2")
+	oldFID, oldPriv := registerTestInstance(t, store, "v1")
+	t.Setenv("JINGUI_RATLS_STRICT", "false")
+
+	var newPriv [32]byte
+	rand.Read(newPriv[:])
+	result, err := client.Rekey(ts.URL, oldPriv, newPriv, true)
+	if err != nil {
+		t.Fatalf("Rekey: %v", err)
+	}
+	newFID, _ := client.ComputeFID(newPriv)
+	if result.FID != newFID || result.PreviousFID != oldFID || result.Status != "rekeyed" {
+		t.Errorf("Rekey = %+v", result)
+	}
+
+	// The new key inherits the grant; the old one no longer gets a challenge.
+	secrets, status := fetchSecrets(t, ts.URL, newFID, newPriv, "jingui://v1/db/password")
+	if status != http.StatusOK || secrets["jingui://v1/db/password"] != "hunter2" {
+		t.Errorf("fetch with the new key: status %d, %v", status, secrets)
+	}
+	if _, err := client.Rekey(ts.URL, oldPriv, newPriv, true); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("rekey with the retired key: err = %v, want 404", err)
+	}
+
+	// The old FID finds the instance and its history.
+	resp, _ := adminRequest("GET", ts.URL+"/v1/instances/"+oldFID, nil)
+	var inst struct {
+		FID          string   `json:"fid"`
+		PreviousFIDs []string `json:"previous_fids"`
+	}
+	json.NewDecoder(resp.Body).Decode(&inst)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || inst.FID != newFID || len(inst.PreviousFIDs) != 1 || inst.PreviousFIDs[0] != oldFID {
+		t.Errorf("GET old FID: status %d, %+v", resp.StatusCode, inst)
+	}
+	events, _ := store.ListAuditEvents(db.AuditQuery{FID: newFID})
+	var actions []string
+	for _, ev := range events {
+		actions = append(actions, ev.Action)
+	}
+	if !slices.Contains(actions, "instance.rekey") || !slices.Contains(actions, "secrets.fetch") {
+		t.Errorf("audit history of the new FID = %v, want the rekey and the fetch", actions)
+	}
+
+	// The retired key cannot be registered again.
+	teePub, _ := curve25519.X25519(oldPriv[:], curve25519.Basepoint)
+	body, _ := json.Marshal(map[string]string{"public_key": hex.EncodeToString(teePub), "dstack_app_id": "dstack-app-1"})
+	resp, _ = adminRequest("POST", ts.URL+"/v1/instances", body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusConflict {
+		t.Errorf("register retired key: expected 409, got %d", resp.StatusCode)
+	}
+}
+
+func TestInstanceStatus_HTTP(t *testing.T) {
+	ts, store := setupTestServer(t)
+	store.CreateVault(&db.Vault{ID: "v1", Name: "V1"})
+	store.UpsertField("v1", "db", "", "password", "hunter2")
+	fid, teePriv := registerTestInstance(t, store, "v1")
+
+	body, _ := json.Marshal(map[string]string{"status": "disabled", "reason": "decommissioned"})
+	resp, _ := adminRequest("PUT", ts.URL+"/v1/instances/"+fid+"/status", body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("disable: expected 200, got %d", resp.StatusCode)
+	}
+
+	chBody, _ := json.Marshal(map[string]string{"fid": fid})
+	resp, _ = http.Post(ts.URL+"/v1/secrets/challenge", "application/json", bytes.NewReader(chBody))
+	var denied struct {
+		Error string `json:"error"`
+	}
+	json.NewDecoder(resp.Body).Decode(&denied)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden || denied.Error != "instance is disabled" {
+		t.Errorf("challenge for a disabled instance: status %d, %+v", resp.StatusCode, denied)
+	}
+
+	body, _ = json.Marshal(map[string]string{"status": "active"})
+	resp, _ = adminRequest("PUT", ts.URL+"/v1/instances/"+fid+"/status", body)
+	resp.Body.Close()
+	secrets, status := fetchSecrets(t, ts.URL, fid, teePriv, "jingui://v1/db/password")
+	if status != http.StatusOK || secrets["jingui://v1/db/password"] != "hunter2" {
+		t.Errorf("fetch after re-enabling: status %d, %v", status, secrets)
+	}
+
+	events, _ := store.ListAuditEvents(db.AuditQuery{FID: fid})
+	var actions []string
+	for _, ev := range events {
+		actions = append(actions, ev.Action+"/"+ev.Outcome)
+	}
+	want := []string{"secrets.fetch/success", "instance.status/success", "secrets.challenge/denied", "instance.status/success"}
+	if !slices.Equal(actions, want) {
+		t.Errorf("audit history = %v, want %v", actions, want)
+	}
+}
+
+func TestAuditLog_HTTP(t *testing.T) {
+	ts, store := setupTestServer(t)
+	store.CreateVault(&db.Vault{ID: "v2", Name: "V2"})
+	store.UpsertField("v2", "db", "", "password", "hunter2")
+
+	vaultReq, _ := json.Marshal(map[string]string{"id": "v1", "name": "V1"})
+	req, _ := http.NewRequest("POST", ts.URL+"/v1/vaults", bytes.NewReader(vaultReq))
+	req.Header.Set("Authorization", "Bearer "+testAdminToken)
+	req.Header.Set("X-Jingui-Actor", "alice")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("POST /v1/vaults: %v", err)
+	}
+	resp.Body.Close()
+	putReq, _ := json.Marshal(map[string]interface{}{"fields": map[string]string{"token": "s3cr3t-value"}})
+	resp, _ = adminRequest("PUT", ts.URL+"/v1/vaults/v1/items/alice", putReq)
+	resp.Body.Close()
+
+	fid, priv := registerTestInstance(t, store, "v1")
+	if _, status := fetchSecrets(t, ts.URL, fid, priv, "jingui://v1/alice/token"); status != http.StatusOK {
+		t.Fatalf("fetch: status %d", status)
+	}
+	if _, status := fetchSecrets(t, ts.URL, fid, priv, "jingui://v1/alice/token", "jingui://v2/db/password"); status != http.StatusForbidden {
+		t.Fatalf("fetch from ungranted vault: expected 403, got %d", status)
+	}
+	challengeReq, _ := json.Marshal(map[string]string{"fid": "unknown"})
+	resp, _ = http.Post(ts.URL+"/v1/secrets/challenge", "application/json", bytes.NewReader(challengeReq))
+	resp.Body.Close()
+
+	type auditPage struct {
+		Events     []db.AuditEvent `json:"events"`
+		NextBefore int64           `json:"next_before"`
+	}
+	list := func(query string) auditPage {
+		t.Helper()
+		resp, err := adminRequest("GET", ts.URL+"/v1/audit"+query, nil)
+		if err != nil {
+			t.Fatalf("GET /v1/audit: %v", err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("GET /v1/audit%s: %d %s", query, resp.StatusCode, body)
+		}
+		if bytes.Contains(body, []byte("s3cr3t-value")) || bytes.Contains(body, []byte("hunter2")) {
+			t.Fatalf("audit log contains a secret value: %s", body)
+		}
+		var page auditPage
+		json.Unmarshal(body, &page)
+		return page
+	}
+
+	// Newest first: the failed challenge, the denied fetch split by vault,
+	// the successful fetch, then the two admin writes.
+	all := list("")
+	if len(all.Events) != 6 || all.NextBefore != 0 {
+		t.Fatalf("audit events = %+v", all)
+	}
+	if ev := all.Events[0]; ev.Action != "secrets.challenge" || ev.Outcome != db.AuditError || ev.FID != "unknown" || ev.Error != "instance not found" {
+		t.Errorf("challenge event = %+v", ev)
+	}
+	if ev := all.Events[5]; ev.Action != "vault.create" || ev.Actor != "alice" || ev.VaultID != "v1" || ev.Status != http.StatusCreated || ev.Outcome != db.AuditSuccess {
+		t.Errorf("create event = %+v", ev)
+	}
+	if ev := all.Events[4]; ev.Action != "item.put" || ev.Actor != "admin" || ev.Target != "/v1/vaults/v1/items/alice" {
+		t.Errorf("put event = %+v", ev)
+	}
+	if ev := all.Events[3]; ev.Action != "secrets.fetch" || ev.FID != fid || ev.VaultID != "v1" || ev.Outcome != db.AuditSuccess || len(ev.Refs) != 1 || ev.SourceIP == "" {
+		t.Errorf("fetch event = %+v", ev)
+	}
+
+	denied := list("?outcome=denied&vault=v2")
+	if len(denied.Events) != 1 || denied.Events[0].Refs[0] != "jingui://v2/db/password" || denied.Events[0].Status != http.StatusForbidden {
+		t.Errorf("denied fetches from v2 = %+v", denied)
+	}
+	if got := list("?fid=" + fid + "&action=secrets.fetch"); len(got.Events) != 3 {
+		t.Errorf("fetches by fid = %+v, want 3", got)
+	}
+
+	page := list("?limit=4")
+	if len(page.Events) != 4 || page.NextBefore != page.Events[3].ID {
+		t.Fatalf("first page = %+v", page)
+	}
+	if rest := list(fmt.Sprintf("?limit=4&before=%d", page.NextBefore)); len(rest.Events) != 2 || rest.Events[1].Action != "vault.create" {
+		t.Errorf("second page = %+v", rest)
+	}
+	if got := list("?since=" + time.Now().Add(time.Hour).UTC().Format(time.RFC3339)); len(got.Events) != 0 {
+		t.Errorf("events in the future = %+v", got)
+	}
+
+	resp, _ = adminRequest("GET", ts.URL+"/v1/audit?since=yesterday", nil)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("bad since: expected 400, got %d", resp.StatusCode)
+	}
+}
+
+func TestEventSinks_HTTP(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	sinks, err := eventsink.New([]string{"file://" + path}, 0)
+	if err != nil {
+		t.Fatalf("eventsink.New: %v", err)
+	}
+	store := db.NewMemoryStore()
+	ts := httptest.NewServer(server.NewRouter(store, &server.Config{AdminToken: testAdminToken}, sinks))
+	defer ts.Close()
+
+	resp, _ := adminRequest("POST", ts.URL+"/v1/vaults", []byte(`{"id":"v1","name":"V1"}`))
+	resp.Body.Close()
+	fid, priv := registerTestInstance(t, store, "v1")
+	if _, status := fetchSecrets(t, ts.URL, fid, priv, "jingui://v1/missing/field"); status != http.StatusNotFound {
+		t.Fatalf("fetch of a missing field: expected 404, got %d", status)
+	}
+	fetchReq, _ := json.Marshal(map[string]interface{}{
+		"fid": fid, "secret_references": []string{"jingui://v1/a/b"}, "challenge_id": "forged", "challenge_response": "AAAA",
+	})
+	resp, _ = http.Post(ts.URL+"/v1/secrets/fetch", "application/json", bytes.NewReader(fetchReq))
+	resp.Body.Close()
+
+	if err := sinks.Close(context.Background()); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read sink file: %v", err)
+	}
+	var got []string
+	for _, line := range bytes.Split(bytes.TrimSpace(data), []byte("\n")) {
+		var ev eventsink.Event
+		if err := json.Unmarshal(line, &ev); err != nil {
+			t.Fatalf("line %q: %v", line, err)
+		}
+		got = append(got, ev.Kind+":"+ev.Action+":"+ev.Outcome)
+		if ev.Kind == eventsink.KindAudit && ev.Hash == "" {
+			t.Errorf("audit event %+v has no chain hash", ev)
+		}
+	}
+	want := "audit:vault.create:success audit:secrets.fetch:error security:ratls.fetch:denied audit:secrets.fetch:denied"
+	if strings.Join(got, " ") != want {
+		t.Errorf("sink received %v, want %s", got, want)
+	}
+}
+
 // --- Admin CRUD endpoint tests ---
 
 func TestListVaults_HTTP(t *testing.T) {
@@ -335,7 +1100,7 @@ func TestDeleteVault_HTTP(t *testing.T) {
 	resp, _ = adminRequest("POST", ts.URL+"/v1/vaults", vaultReq)
 	resp.Body.Close()
 
-	store.SetItemFields("v1", "item1", map[string]string{"k": "v"})
+	store.SetItemFields("v1", "item1", "", map[string]string{"k": "v"})
 
 	resp, _ = adminRequest("DELETE", ts.URL+"/v1/vaults/v1", nil)
 	if resp.StatusCode != http.StatusConflict {
@@ -452,6 +1217,127 @@ func TestVaultItems_HTTP(t *testing.T) {
 	resp.Body.Close()
 }
 
+func TestFieldVersions_HTTP(t *testing.T) {
+	ts, store := setupTestServer(t)
+
+	vaultReq, _ := json.Marshal(map[string]string{"id": "v1", "name": "V1"})
+	resp, _ := adminRequest("POST", ts.URL+"/v1/vaults", vaultReq)
+	resp.Body.Close()
+
+	for _, token := range []string{"good-token", "bad-paste"} {
+		putReq, _ := json.Marshal(map[string]interface{}{
+			"fields": map[string]string{"refresh_token": token},
+		})
+		req, _ := http.NewRequest("PUT", ts.URL+"/v1/vaults/v1/items/alice", bytes.NewReader(putReq))
+		req.Header.Set("Authorization", "Bearer "+testAdminToken)
+		req.Header.Set("X-Jingui-Actor", "ops@example.com")
+		resp, _ = http.DefaultClient.Do(req)
+		resp.Body.Close()
+	}
+
+	resp, _ = adminRequest("GET", ts.URL+"/v1/vaults/v1/items/alice/refresh_token/versions", nil)
+	var versions []db.FieldVersion
+	json.NewDecoder(resp.Body).Decode(&versions)
+	resp.Body.Close()
+	if len(versions) != 2 || versions[0].Version != 2 || !versions[0].Current || versions[0].Author != "ops@example.com" {
+		t.Fatalf("unexpected versions: %+v", versions)
+	}
+
+	rollbackReq, _ := json.Marshal(map[string]int{"version": 1})
+	resp, _ = adminRequest("POST", ts.URL+"/v1/vaults/v1/items/alice/refresh_token/versions", rollbackReq)
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		t.Fatalf("rollback: expected 200, got %d: %s", resp.StatusCode, body)
+	}
+	resp.Body.Close()
+
+	val, err := store.GetFieldValue("v1", "alice", "", "refresh_token")
+	if err != nil || val != "good-token" {
+		t.Fatalf("after rollback: %q, %v", val, err)
+	}
+
+	rollbackReq, _ = json.Marshal(map[string]int{"version": 9})
+	resp, _ = adminRequest("POST", ts.URL+"/v1/vaults/v1/items/alice/refresh_token/versions", rollbackReq)
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("rollback to missing version: expected 404, got %d", resp.StatusCode)
+	}
+	resp.Body.Close()
+
+	resp, _ = adminRequest("GET", ts.URL+"/v1/vaults/v1/items/alice/missing/versions", nil)
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("versions of missing field: expected 404, got %d", resp.StatusCode)
+	}
+	resp.Body.Close()
+}
+
+func TestImport_HTTP(t *testing.T) {
+	ts, store := setupTestServer(t)
+
+	vaultReq, _ := json.Marshal(map[string]string{"id": "v1", "name": "V1"})
+	resp, _ := adminRequest("POST", ts.URL+"/v1/vaults", vaultReq)
+	resp.Body.Close()
+	store.UpsertField("v1", "app", "", "DB_URL", "postgres://old")
+
+	importReq := func(onConflict string, dryRun bool) (int, map[string]interface{}) {
+		body, _ := json.Marshal(map[string]interface{}{
+			"format":      "dotenv",
+			"content":     "API_KEY=k1\nDB_URL=postgres://new\nREF=jingui://v1/app/other\n",
+			"item":        "app",
+			"on_conflict": onConflict,
+			"dry_run":     dryRun,
+		})
+		resp, err := adminRequest("POST", ts.URL+"/v1/vaults/v1/import", body)
+		if err != nil {
+			t.Fatalf("POST import: %v", err)
+		}
+		defer resp.Body.Close()
+		var out map[string]interface{}
+		json.NewDecoder(resp.Body).Decode(&out)
+		return resp.StatusCode, out
+	}
+
+	// The default policy refuses to change DB_URL and writes nothing.
+	status, out := importReq("", false)
+	if status != http.StatusConflict {
+		t.Fatalf("expected 409, got %d: %v", status, out)
+	}
+	if _, err := store.GetFieldValue("v1", "app", "", "API_KEY"); !errors.Is(err, db.ErrFieldNotFound) {
+		t.Errorf("conflicting import wrote API_KEY: %v", err)
+	}
+
+	status, out = importReq("overwrite", true)
+	if status != http.StatusOK || out["dry_run"] != true {
+		t.Fatalf("dry run: %d %v", status, out)
+	}
+	if summary := out["summary"].(map[string]interface{}); summary["create"] != 1.0 || summary["update"] != 1.0 {
+		t.Errorf("dry run summary = %v", summary)
+	}
+	if val, _ := store.GetFieldValue("v1", "app", "", "DB_URL"); val != "postgres://old" {
+		t.Errorf("dry run changed DB_URL to %q", val)
+	}
+
+	status, out = importReq("skip", false)
+	if status != http.StatusOK {
+		t.Fatalf("skip import: %d %v", status, out)
+	}
+	if val, _ := store.GetFieldValue("v1", "app", "", "API_KEY"); val != "k1" {
+		t.Errorf("API_KEY = %q, want k1", val)
+	}
+	if val, _ := store.GetFieldValue("v1", "app", "", "DB_URL"); val != "postgres://old" {
+		t.Errorf("skip import changed DB_URL to %q", val)
+	}
+	if _, err := store.GetFieldValue("v1", "app", "", "REF"); !errors.Is(err, db.ErrFieldNotFound) {
+		t.Errorf("expected references to be left out, got %v", err)
+	}
+
+	body, _ := json.Marshal(map[string]string{"format": "dotenv", "content": "A=1", "item": "app"})
+	resp, _ = adminRequest("POST", ts.URL+"/v1/vaults/missing/import", body)
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("import into missing vault: expected 404, got %d", resp.StatusCode)
+	}
+	resp.Body.Close()
+}
+
 func TestListInstances_HTTP(t *testing.T) {
 	ts, _ := setupTestServer(t)
 
@@ -573,6 +1459,92 @@ func TestDeleteInstance_HTTP(t *testing.T) {
 	resp.Body.Close()
 }
 
+func TestTrash_HTTP(t *testing.T) {
+	ts, store := setupTestServer(t)
+	store.CreateVault(&db.Vault{ID: "v1", Name: "V1"})
+	store.UpsertField("v1", "alice", "", "token", "t0k3n")
+	store.UpsertField("v1", "alice", "oauth", "token", "oauth-t0k3n")
+	fid, priv := registerTestInstance(t, store, "v1")
+
+	do := func(method, path string, body []byte) int {
+		resp, err := adminRequest(method, ts.URL+path, body)
+		if err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// A trashed vault denies fetches and blocks reuse of its ID.
+	if status := do("DELETE", "/v1/vaults/v1?cascade=true", nil); status != http.StatusOK {
+		t.Fatalf("DELETE vault: expected 200, got %d", status)
+	}
+	if _, status := fetchSecrets(t, ts.URL, fid, priv, "jingui://v1/alice/token"); status != http.StatusForbidden {
+		t.Errorf("fetch from trashed vault: expected 403, got %d", status)
+	}
+	vaultReq, _ := json.Marshal(map[string]string{"id": "v1", "name": "V1 again"})
+	if status := do("POST", "/v1/vaults", vaultReq); status != http.StatusConflict {
+		t.Errorf("recreate trashed vault: expected 409, got %d", status)
+	}
+
+	resp, _ := adminRequest("GET", ts.URL+"/v1/trash", nil)
+	var trash []map[string]interface{}
+	json.NewDecoder(resp.Body).Decode(&trash)
+	resp.Body.Close()
+	if len(trash) != 1 || trash[0]["kind"] != "vault" || trash[0]["vault_id"] != "v1" || trash[0]["purge_at"] == nil {
+		t.Fatalf("trash = %v", trash)
+	}
+
+	if status := do("POST", "/v1/trash/vaults/v1/restore", nil); status != http.StatusOK {
+		t.Fatalf("restore vault: expected 200, got %d", status)
+	}
+	if status := do("POST", "/v1/trash/vaults/v1/restore", nil); status != http.StatusNotFound {
+		t.Errorf("restore live vault: expected 404, got %d", status)
+	}
+	if secrets, status := fetchSecrets(t, ts.URL, fid, priv, "jingui://v1/alice/token"); status != http.StatusOK || secrets["jingui://v1/alice/token"] != "t0k3n" {
+		t.Errorf("fetch after restore: %d %v", status, secrets)
+	}
+
+	// A trashed section is not found and cannot be written to.
+	if status := do("DELETE", "/v1/vaults/v1/items/alice?section=oauth", nil); status != http.StatusOK {
+		t.Fatalf("DELETE section: expected 200, got %d", status)
+	}
+	if _, status := fetchSecrets(t, ts.URL, fid, priv, "jingui://v1/alice/oauth/token"); status != http.StatusNotFound {
+		t.Errorf("fetch from trashed section: expected 404, got %d", status)
+	}
+	putReq, _ := json.Marshal(map[string]interface{}{"fields": map[string]string{"token": "new"}})
+	if status := do("PUT", "/v1/vaults/v1/items/alice?section=oauth", putReq); status != http.StatusConflict {
+		t.Errorf("PUT into trashed section: expected 409, got %d", status)
+	}
+	if status := do("POST", "/v1/trash/vaults/v1/items/alice/restore?section=oauth", nil); status != http.StatusOK {
+		t.Fatalf("restore section: expected 200, got %d", status)
+	}
+	if secrets, status := fetchSecrets(t, ts.URL, fid, priv, "jingui://v1/alice/oauth/token"); status != http.StatusOK || secrets["jingui://v1/alice/oauth/token"] != "oauth-t0k3n" {
+		t.Errorf("fetch after section restore: %d %v", status, secrets)
+	}
+
+	// A trashed instance cannot fetch; purging it is final.
+	if status := do("DELETE", "/v1/instances/"+fid, nil); status != http.StatusOK {
+		t.Fatalf("DELETE instance: expected 200, got %d", status)
+	}
+	if status := do("POST", "/v1/trash/instances/"+fid+"/restore", nil); status != http.StatusOK {
+		t.Fatalf("restore instance: expected 200, got %d", status)
+	}
+	if _, status := fetchSecrets(t, ts.URL, fid, priv, "jingui://v1/alice/token"); status != http.StatusOK {
+		t.Errorf("fetch after instance restore: expected 200, got %d", status)
+	}
+	do("DELETE", "/v1/instances/"+fid, nil)
+	if status := do("DELETE", "/v1/trash/instances/"+fid, nil); status != http.StatusOK {
+		t.Fatalf("purge instance: expected 200, got %d", status)
+	}
+	if status := do("DELETE", "/v1/trash/instances/"+fid, nil); status != http.StatusNotFound {
+		t.Errorf("purge instance twice: expected 404, got %d", status)
+	}
+	if inst, _ := store.GetInstance(fid); inst != nil {
+		t.Errorf("instance still present after purge: %+v", inst)
+	}
+}
+
 func TestVaultInstanceAccess_HTTP(t *testing.T) {
 	ts, _ := setupTestServer(t)
 
@@ -2,6 +2,8 @@ package refparser
 
 import (
 	"fmt"
+	"net/url"
+	"strconv"
 	"strings"
 )
 
@@ -15,11 +17,15 @@ var refPrefixes = []string{"jingui://", "op://"}
 //	jingui://<vault>/<item>/<section>/<field_name>
 //	op://<vault>/<item>/<field_name>
 //	op://<vault>/<item>/<section>/<field_name>
+//
+// Any of these may be pinned to a field version with a trailing @<n> on the
+// field name or a ?version=<n> query, e.g. jingui://<vault>/<item>/<field_name>@3.
 type SecretRef struct {
 	Vault     string
 	Item      string
 	Section   string // optional, empty if 3-segment URI
 	FieldName string
+	Version   int // pinned version, 0 for the current one
 	Raw       string
 }
 
@@ -52,31 +58,125 @@ func Parse(ref string) (SecretRef, error) {
 	}
 
 	body := strings.TrimPrefix(ref, prefix)
+
+	queryVersion := 0
+	if i := strings.LastIndex(body, "?"); i >= 0 {
+		v, err := parseVersionQuery(body[i+1:])
+		if err != nil {
+			return SecretRef{}, fmt.Errorf("invalid reference %q: %w", ref, err)
+		}
+		body, queryVersion = body[:i], v
+	}
+
 	parts := strings.Split(body, "/")
+	last := len(parts) - 1
+	field, suffixVersion, err := splitVersionSuffix(parts[last])
+	if err != nil {
+		return SecretRef{}, fmt.Errorf("invalid reference %q: %w", ref, err)
+	}
+	parts[last] = field
+	if suffixVersion != 0 && queryVersion != 0 {
+		return SecretRef{}, fmt.Errorf("invalid reference %q: version given both as @%d and ?version=%d", ref, suffixVersion, queryVersion)
+	}
 
+	var out SecretRef
 	switch len(parts) {
 	case 3:
 		if parts[0] == "" || parts[1] == "" || parts[2] == "" {
 			return SecretRef{}, fmt.Errorf("invalid reference %q: expected %s<vault>/<item>/<field_name>", ref, prefix)
 		}
-		return SecretRef{
+		out = SecretRef{
 			Vault:     parts[0],
 			Item:      parts[1],
 			FieldName: parts[2],
-			Raw:       ref,
-		}, nil
+		}
 	case 4:
 		if parts[0] == "" || parts[1] == "" || parts[2] == "" || parts[3] == "" {
 			return SecretRef{}, fmt.Errorf("invalid reference %q: expected %s<vault>/<item>/<section>/<field_name>", ref, prefix)
 		}
-		return SecretRef{
+		out = SecretRef{
 			Vault:     parts[0],
 			Item:      parts[1],
 			Section:   parts[2],
 			FieldName: parts[3],
-			Raw:       ref,
-		}, nil
+		}
 	default:
 		return SecretRef{}, fmt.Errorf("invalid reference %q: expected 3 or 4 path segments", ref)
 	}
+	out.Version = suffixVersion + queryVersion
+	out.Raw = ref
+	return out, nil
+}
+
+// Format builds the jingui:// reference to a field, using the 3-segment form
+// when section is empty. It fails if the result would not parse back to the
+// same field.
+func Format(vault, item, section, field string) (string, error) {
+	parts := []string{vault, item}
+	if section != "" {
+		parts = append(parts, section)
+	}
+	ref := "jingui://" + strings.Join(append(parts, field), "/")
+	parsed, err := Parse(ref)
+	if err != nil {
+		return "", err
+	}
+	if parsed.Vault != vault || parsed.Item != item || parsed.Section != section || parsed.FieldName != field || parsed.Version != 0 {
+		return "", fmt.Errorf("field %q cannot be addressed by a reference", fieldPath(vault, item, section, field))
+	}
+	return ref, nil
+}
+
+func fieldPath(vault, item, section, field string) string {
+	if section == "" {
+		return vault + "/" + item + "/" + field
+	}
+	return vault + "/" + item + "/" + section + "/" + field
+}
+
+// splitVersionSuffix splits a trailing @<n> version selector off a field
+// name. Only an all-digit suffix is treated as a version, so names such as
+// "alice@example.com" are left intact.
+func splitVersionSuffix(field string) (string, int, error) {
+	i := strings.LastIndex(field, "@")
+	if i < 0 || i == len(field)-1 {
+		return field, 0, nil
+	}
+	suffix := field[i+1:]
+	for _, c := range suffix {
+		if c < '0' || c > '9' {
+			return field, 0, nil
+		}
+	}
+	v, err := parseVersion(suffix)
+	if err != nil {
+		return "", 0, err
+	}
+	return field[:i], v, nil
+}
+
+// parseVersionQuery parses the query part of a reference. Only version is
+// accepted.
+func parseVersionQuery(rawQuery string) (int, error) {
+	q, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return 0, fmt.Errorf("malformed query: %w", err)
+	}
+	for k := range q {
+		if k != "version" {
+			return 0, fmt.Errorf("unsupported query parameter %q", k)
+		}
+	}
+	if len(q["version"]) != 1 {
+		return 0, fmt.Errorf("expected exactly one version parameter")
+	}
+	return parseVersion(q.Get("version"))
+}
+
+func parseVersion(s string) (int, error) {
+	v, err := strconv.Atoi(s)
+	if err != nil || v < 1 {
+		return 0, fmt.Errorf("version must be a positive integer, got %q", s)
+	}
+	return v, nil
 }
@@ -127,6 +127,12 @@ func TestParse_Invalid(t *testing.T) {
 		"op://app//field",
 		"op://app/name/",
 		"op://a/b/c/d/e", // 5 segments — invalid
+		"jingui://app/name/@3",
+		"jingui://app/name/field@0",
+		"jingui://app/name/field?version=abc",
+		"jingui://app/name/field?version=0",
+		"jingui://app/name/field?rev=3",
+		"jingui://app/name/field@2?version=3",
 	}
 	for _, ref := range invalids {
 		_, err := Parse(ref)
@@ -136,6 +142,39 @@ func TestParse_Invalid(t *testing.T) {
 	}
 }
 
+func TestParse_Version(t *testing.T) {
+	tests := []struct {
+		ref     string
+		item    string
+		section string
+		field   string
+		version int
+	}{
+		{"jingui://gmail/work/token", "work", "", "token", 0},
+		{"jingui://gmail/work/token@3", "work", "", "token", 3},
+		{"jingui://gmail/work/token?version=3", "work", "", "token", 3},
+		{"jingui://gmail/alice@example.com/oauth/token@12", "alice@example.com", "oauth", "token", 12},
+		{"op://gmail/work/oauth/token?version=2", "work", "oauth", "token", 2},
+		// Non-numeric suffixes are part of the field name.
+		{"jingui://gmail/work/alice@example.com", "work", "", "alice@example.com", 0},
+		{"jingui://gmail/work/token@v2", "work", "", "token@v2", 0},
+	}
+	for _, tt := range tests {
+		r, err := Parse(tt.ref)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.ref, err)
+			continue
+		}
+		if r.Item != tt.item || r.Section != tt.section || r.FieldName != tt.field || r.Version != tt.version {
+			t.Errorf("Parse(%q) = item %q section %q field %q version %d, want %q %q %q %d",
+				tt.ref, r.Item, r.Section, r.FieldName, r.Version, tt.item, tt.section, tt.field, tt.version)
+		}
+		if r.Raw != tt.ref {
+			t.Errorf("Raw = %q, want %q", r.Raw, tt.ref)
+		}
+	}
+}
+
 func TestIsRef(t *testing.T) {
 	if !IsRef("jingui://foo/bar/baz") {
 		t.Error("expected true for jingui:// prefix")
@@ -150,3 +189,19 @@ func TestIsRef(t *testing.T) {
 		t.Error("expected false for empty string")
 	}
 }
+
+func TestFormat(t *testing.T) {
+	ref, err := Format("v", "alice@example.com", "", "API_KEY")
+	if err != nil || ref != "jingui://v/alice@example.com/API_KEY" {
+		t.Errorf("Format = %q, %v", ref, err)
+	}
+	ref, err = Format("v", "app", "prod", "API_KEY")
+	if err != nil || ref != "jingui://v/app/prod/API_KEY" {
+		t.Errorf("Format with section = %q, %v", ref, err)
+	}
+	for _, field := range []string{"a/b", "token@2", "q?x=1", ""} {
+		if ref, err := Format("v", "app", "", field); err == nil {
+			t.Errorf("Format(field=%q) = %q, expected an error", field, ref)
+		}
+	}
+}
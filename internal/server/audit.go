@@ -0,0 +1,217 @@
+package server
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/hkdf"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aspect-build/jingui/internal/logx"
+	"github.com/aspect-build/jingui/internal/server/db"
+)
+
+// DefaultAuditSignInterval is how often the audit head is signed when
+// JINGUI_AUDIT_SIGN_INTERVAL is not set.
+const DefaultAuditSignInterval = 10 * time.Minute
+
+// auditVerifyPage is how many events VerifyAuditLog reads at a time.
+const auditVerifyPage = 1000
+
+// AuditSigningKey derives the Ed25519 key that signs audit heads from a
+// master key. Someone with database access but not the master key cannot
+// sign a rewritten log.
+func AuditSigningKey(masterKey []byte) (ed25519.PrivateKey, error) {
+	seed, err := hkdf.Key(sha256.New, masterKey, nil, "jingui audit signing key", ed25519.SeedSize)
+	if err != nil {
+		return nil, fmt.Errorf("derive audit signing key: %w", err)
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+// LoadAuditKeys returns the audit signing key of the active master key, and
+// the public keys of the active and previous master keys that signatures are
+// verified against.
+func LoadAuditKeys(ctx context.Context, cfg *StoreConfig) (ed25519.PrivateKey, []ed25519.PublicKey, error) {
+	masterKey, err := cfg.KeyProvider.MasterKey(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("master key from %s: %w", cfg.KeyProvider.Name(), err)
+	}
+	key, err := AuditSigningKey(masterKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	trusted := []ed25519.PublicKey{key.Public().(ed25519.PublicKey)}
+	for _, prev := range cfg.PreviousMasterKeys {
+		k, err := AuditSigningKey(prev)
+		if err != nil {
+			return nil, nil, err
+		}
+		trusted = append(trusted, k.Public().(ed25519.PublicKey))
+	}
+	return key, trusted, nil
+}
+
+// auditHeadMessage is the message signed for an audit head.
+func auditHeadMessage(eventID int64, hash string, signedAt time.Time) []byte {
+	return []byte("jingui audit head\n" + strconv.FormatInt(eventID, 10) + "\n" + hash + "\n" + signedAt.UTC().Format(time.RFC3339))
+}
+
+// SignAuditHead signs the newest audit event and stores the signature. It
+// returns nil if the log is empty.
+func SignAuditHead(store db.Store, key ed25519.PrivateKey) (*db.AuditSignature, error) {
+	head, err := store.ListAuditEvents(db.AuditQuery{Limit: 1})
+	if err != nil {
+		return nil, err
+	}
+	if len(head) == 0 {
+		return nil, nil
+	}
+	sig := &db.AuditSignature{
+		EventID:   head[0].ID,
+		Hash:      head[0].Hash,
+		PublicKey: hex.EncodeToString(key.Public().(ed25519.PublicKey)),
+		SignedAt:  time.Now().UTC().Truncate(time.Second),
+	}
+	sig.Signature = hex.EncodeToString(ed25519.Sign(key, auditHeadMessage(sig.EventID, sig.Hash, sig.SignedAt)))
+	if err := store.RecordAuditSignature(sig); err != nil {
+		return nil, err
+	}
+	return sig, nil
+}
+
+// SignAuditHeadLoop signs the audit head at startup and then every interval
+// while new events arrive, until ctx is done. Each signed head is also written
+// to the server log, which keeps a copy outside the database.
+func SignAuditHeadLoop(ctx context.Context, store db.Store, key ed25519.PrivateKey, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var signed int64
+	for {
+		if head, err := store.ListAuditEvents(db.AuditQuery{Limit: 1}); err != nil {
+			logx.Errorf("read audit head: %v", err)
+		} else if len(head) > 0 && head[0].ID != signed {
+			if sig, err := SignAuditHead(store, key); err != nil {
+				logx.Errorf("sign audit head: %v", err)
+			} else if sig != nil {
+				signed = sig.EventID
+				logx.Infof("signed audit head event=%d hash=%s signature=%s", sig.EventID, sig.Hash, sig.Signature)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// AuditReport is the outcome of VerifyAuditLog.
+type AuditReport struct {
+	Events  int
+	FirstID int64
+	LastID  int64
+	// Signatures counts the signed heads that verified; LastSigned is the
+	// newest of them, or 0.
+	Signatures int
+	LastSigned int64
+	// Problems describes every gap, modification or bad signature found.
+	Problems []string
+}
+
+// OK reports whether the log verified without problems.
+func (r *AuditReport) OK() bool {
+	return len(r.Problems) == 0
+}
+
+// VerifyAuditLog walks the audit log from the newest event to the oldest,
+// recomputing every hash and link, and checks each signed head against the
+// trusted public keys and the event it names.
+func VerifyAuditLog(store db.Store, trusted []ed25519.PublicKey) (*AuditReport, error) {
+	r := &AuditReport{}
+	problem := func(format string, args ...any) {
+		r.Problems = append(r.Problems, fmt.Sprintf(format, args...))
+	}
+
+	keys := map[string]bool{}
+	for _, k := range trusted {
+		keys[hex.EncodeToString(k)] = true
+	}
+	sigs, err := store.ListAuditSignatures()
+	if err != nil {
+		return nil, err
+	}
+	heads := map[int64]db.AuditSignature{}
+	for _, sig := range sigs {
+		pub, _ := hex.DecodeString(sig.PublicKey)
+		sigBytes, _ := hex.DecodeString(sig.Signature)
+		switch {
+		case !keys[sig.PublicKey]:
+			problem("signed head %d: signed by untrusted key %s", sig.EventID, sig.PublicKey)
+		case len(pub) != ed25519.PublicKeySize || !ed25519.Verify(pub, auditHeadMessage(sig.EventID, sig.Hash, sig.SignedAt), sigBytes):
+			problem("signed head %d: invalid signature", sig.EventID)
+		default:
+			heads[sig.EventID] = sig
+		}
+	}
+
+	var (
+		newer  *db.AuditEvent
+		before int64
+	)
+	for {
+		page, err := store.ListAuditEvents(db.AuditQuery{BeforeID: before, Limit: auditVerifyPage})
+		if err != nil {
+			return nil, err
+		}
+		for i := range page {
+			ev := &page[i]
+			if r.Events == 0 {
+				r.LastID = ev.ID
+			}
+			r.Events++
+			r.FirstID = ev.ID
+
+			if db.AuditEventHash(ev) != ev.Hash {
+				problem("event %d: contents do not match its hash; it was modified", ev.ID)
+			}
+			if newer != nil {
+				if newer.ID != ev.ID+1 {
+					problem("events %d to %d are missing", ev.ID+1, newer.ID-1)
+				} else if newer.PrevHash != ev.Hash {
+					problem("event %d: previous hash does not match event %d", newer.ID, ev.ID)
+				}
+			}
+			if sig, ok := heads[ev.ID]; ok {
+				if sig.Hash != ev.Hash {
+					problem("signed head %d: hash does not match the log; the chain was rewritten", ev.ID)
+				} else {
+					r.Signatures++
+					r.LastSigned = max(r.LastSigned, ev.ID)
+				}
+				delete(heads, ev.ID)
+			}
+			newer = ev
+		}
+		if len(page) < auditVerifyPage {
+			break
+		}
+		before = page[len(page)-1].ID
+	}
+
+	if newer != nil && (newer.ID != 1 || newer.PrevHash != "") {
+		problem("events before %d are missing", newer.ID)
+	}
+	for id := range heads {
+		if id > r.LastID {
+			problem("signed head %d: event is missing; the log was truncated after event %d", id, r.LastID)
+		} else {
+			problem("signed head %d: event is missing", id)
+		}
+	}
+	return r, nil
+}
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"crypto/ed25519"
+	"strings"
+	"testing"
+
+	"github.com/aspect-build/jingui/internal/server/db"
+)
+
+// tamperedStore serves an audit log edited by tamper, as someone with write
+// access to the database could leave it.
+type tamperedStore struct {
+	db.Store
+	tamper func([]db.AuditEvent) []db.AuditEvent
+}
+
+func (s *tamperedStore) ListAuditEvents(q db.AuditQuery) ([]db.AuditEvent, error) {
+	events, err := s.Store.ListAuditEvents(db.AuditQuery{})
+	if err != nil {
+		return nil, err
+	}
+	events = s.tamper(events)
+	var out []db.AuditEvent
+	for _, ev := range events {
+		if q.BeforeID > 0 && ev.ID >= q.BeforeID {
+			continue
+		}
+		out = append(out, ev)
+		if q.Limit > 0 && len(out) == q.Limit {
+			break
+		}
+	}
+	return out, nil
+}
+
+func newAuditTestLog(t *testing.T, n int) (*db.MemoryStore, ed25519.PrivateKey) {
+	t.Helper()
+	key, err := AuditSigningKey(make([]byte, 32))
+	if err != nil {
+		t.Fatalf("AuditSigningKey: %v", err)
+	}
+	store := db.NewMemoryStore()
+	for i := 0; i < n; i++ {
+		if err := store.RecordAuditEvent(&db.AuditEvent{Action: "item.put", Outcome: db.AuditSuccess, Status: 200, Actor: "alice"}); err != nil {
+			t.Fatalf("RecordAuditEvent: %v", err)
+		}
+	}
+	return store, key
+}
+
+func TestVerifyAuditLog_Intact(t *testing.T) {
+	store, key := newAuditTestLog(t, 3)
+	if _, err := SignAuditHead(store, key); err != nil {
+		t.Fatalf("SignAuditHead: %v", err)
+	}
+	store.RecordAuditEvent(&db.AuditEvent{Action: "vault.delete", Outcome: db.AuditSuccess, Status: 200})
+
+	r, err := VerifyAuditLog(store, []ed25519.PublicKey{key.Public().(ed25519.PublicKey)})
+	if err != nil {
+		t.Fatalf("VerifyAuditLog: %v", err)
+	}
+	if !r.OK() || r.Events != 4 || r.FirstID != 1 || r.LastID != 4 || r.Signatures != 1 || r.LastSigned != 3 {
+		t.Errorf("report = %+v", r)
+	}
+}
+
+func TestVerifyAuditLog_Problems(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		tamper func([]db.AuditEvent) []db.AuditEvent // newest first
+		want   string
+	}{
+		{"modified", func(evs []db.AuditEvent) []db.AuditEvent {
+			evs[2].Actor = "mallory"
+			return evs
+		}, "event 3: contents do not match"},
+		{"deleted", func(evs []db.AuditEvent) []db.AuditEvent {
+			return append(evs[:2:2], evs[3:]...)
+		}, "events 3 to 3 are missing"},
+		{"deleted oldest", func(evs []db.AuditEvent) []db.AuditEvent {
+			return evs[:len(evs)-1]
+		}, "events before 2 are missing"},
+		{"truncated", func(evs []db.AuditEvent) []db.AuditEvent {
+			return evs[2:]
+		}, "the log was truncated after event 3"},
+		{"rewritten", func(evs []db.AuditEvent) []db.AuditEvent {
+			// Rechain the whole log after editing an event.
+			evs[4].Actor = "mallory"
+			prev := ""
+			for i := len(evs) - 1; i >= 0; i-- {
+				evs[i].PrevHash = prev
+				evs[i].Hash = db.AuditEventHash(&evs[i])
+				prev = evs[i].Hash
+			}
+			return evs
+		}, "signed head 5: hash does not match the log"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			store, key := newAuditTestLog(t, 5)
+			if _, err := SignAuditHead(store, key); err != nil {
+				t.Fatalf("SignAuditHead: %v", err)
+			}
+			r, err := VerifyAuditLog(&tamperedStore{Store: store, tamper: tc.tamper}, []ed25519.PublicKey{key.Public().(ed25519.PublicKey)})
+			if err != nil {
+				t.Fatalf("VerifyAuditLog: %v", err)
+			}
+			if r.OK() || !strings.Contains(strings.Join(r.Problems, "\n"), tc.want) {
+				t.Errorf("problems = %q, want one containing %q", r.Problems, tc.want)
+			}
+		})
+	}
+}
+
+func TestVerifyAuditLog_UntrustedKey(t *testing.T) {
+	store, key := newAuditTestLog(t, 2)
+	if _, err := SignAuditHead(store, key); err != nil {
+		t.Fatalf("SignAuditHead: %v", err)
+	}
+	other, _ := AuditSigningKey([]byte("another master key, 32 bytes...."))
+
+	r, err := VerifyAuditLog(store, []ed25519.PublicKey{other.Public().(ed25519.PublicKey)})
+	if err != nil {
+		t.Fatalf("VerifyAuditLog: %v", err)
+	}
+	if r.OK() || r.Signatures != 0 || !strings.Contains(r.Problems[0], "untrusted key") {
+		t.Errorf("report = %+v, want the head rejected as untrusted", r)
+	}
+}
@@ -0,0 +1,186 @@
+// Package backup encodes store snapshots as encrypted backup files.
+//
+// A backup file is the line "jingui-backup/1" followed by an ECIES blob
+// (see internal/crypto) encrypted to an X25519 backup public key. The blob
+// decrypts to a JSON document holding a manifest and the snapshot. The
+// manifest records the schema version, row counts and the SHA-256 of the
+// snapshot bytes, all of which are checked before a backup is restored.
+package backup
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aspect-build/jingui/internal/crypto"
+	"github.com/aspect-build/jingui/internal/server/db"
+)
+
+// Format is the version of the backup file layout written by Seal.
+const Format = 1
+
+var magic = []byte("jingui-backup/1\n")
+
+var (
+	// ErrNotBackup is returned by Open for data that is not a backup file.
+	ErrNotBackup = errors.New("not a jingui backup file")
+	// ErrCorrupt is returned by Open when a decrypted backup fails its
+	// checksum or does not match its manifest.
+	ErrCorrupt = errors.New("backup is corrupt")
+)
+
+// Manifest describes the snapshot inside a backup.
+type Manifest struct {
+	Format        int       `json:"format"`
+	SchemaVersion int       `json:"schema_version"`
+	CreatedAt     time.Time `json:"created_at"`
+	Counts        Counts    `json:"counts"`
+	// SHA256 is the hex-encoded SHA-256 of the encoded snapshot.
+	SHA256 string `json:"sha256"`
+}
+
+// Counts is the number of records of each kind in a snapshot.
+type Counts struct {
+	Vaults              int `json:"vaults"`
+	Fields              int `json:"fields"`
+	Versions            int `json:"versions"`
+	Expiries            int `json:"expiries"`
+	Instances           int `json:"instances"`
+	InstanceAliases     int `json:"instance_aliases"`
+	Grants              int `json:"grants"`
+	FieldGrants         int `json:"field_grants"`
+	AppGrants           int `json:"app_grants"`
+	DebugPolicies       int `json:"debug_policies"`
+	EnrollmentPolicies  int `json:"enrollment_policies"`
+	EnrollmentTokens    int `json:"enrollment_tokens"`
+	MeasurementPolicies int `json:"measurement_policies"`
+}
+
+func countsOf(snap *db.Snapshot) Counts {
+	return Counts{
+		Vaults:              len(snap.Vaults),
+		Fields:              len(snap.Fields),
+		Versions:            len(snap.Versions),
+		Expiries:            len(snap.Expiries),
+		Instances:           len(snap.Instances),
+		InstanceAliases:     len(snap.InstanceAliases),
+		Grants:              len(snap.Grants),
+		FieldGrants:         len(snap.FieldGrants),
+		AppGrants:           len(snap.AppGrants),
+		DebugPolicies:       len(snap.DebugPolicies),
+		EnrollmentPolicies:  len(snap.EnrollmentPolicies),
+		EnrollmentTokens:    len(snap.EnrollmentTokens),
+		MeasurementPolicies: len(snap.MeasurementPolicies),
+	}
+}
+
+// document is the plaintext of a backup.
+type document struct {
+	Manifest Manifest        `json:"manifest"`
+	Snapshot json.RawMessage `json:"snapshot"`
+}
+
+// Seal encodes snap as a backup file encrypted to recipient.
+func Seal(snap *db.Snapshot, recipient [32]byte) ([]byte, *Manifest, error) {
+	encoded, err := json.Marshal(snap)
+	if err != nil {
+		return nil, nil, fmt.Errorf("encode snapshot: %w", err)
+	}
+	sum := sha256.Sum256(encoded)
+	doc := document{
+		Manifest: Manifest{
+			Format:        Format,
+			SchemaVersion: snap.SchemaVersion,
+			CreatedAt:     time.Now().UTC().Truncate(time.Second),
+			Counts:        countsOf(snap),
+			SHA256:        hex.EncodeToString(sum[:]),
+		},
+		Snapshot: encoded,
+	}
+	plaintext, err := json.Marshal(doc)
+	if err != nil {
+		return nil, nil, fmt.Errorf("encode backup: %w", err)
+	}
+
+	blob, err := crypto.Encrypt(recipient, plaintext)
+	if err != nil {
+		return nil, nil, fmt.Errorf("encrypt backup: %w", err)
+	}
+	return append(append([]byte(nil), magic...), blob...), &doc.Manifest, nil
+}
+
+// Open decrypts a backup file with the backup private key and verifies it:
+// the format must be known, the snapshot must match the manifest checksum
+// and counts, its schema must not be newer than this binary's, and it must
+// pass db.Snapshot.Validate.
+func Open(data []byte, privateKey [32]byte) (*Manifest, *db.Snapshot, error) {
+	if !bytes.HasPrefix(data, magic) {
+		return nil, nil, ErrNotBackup
+	}
+	plaintext, err := crypto.Decrypt(privateKey, data[len(magic):])
+	if err != nil {
+		return nil, nil, fmt.Errorf("decrypt backup (wrong private key?): %w", err)
+	}
+
+	var doc document
+	if err := json.Unmarshal(plaintext, &doc); err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrCorrupt, err)
+	}
+	m := &doc.Manifest
+	if m.Format != Format {
+		return nil, nil, fmt.Errorf("unsupported backup format %d", m.Format)
+	}
+	sum := sha256.Sum256(doc.Snapshot)
+	if hex.EncodeToString(sum[:]) != m.SHA256 {
+		return nil, nil, fmt.Errorf("%w: checksum mismatch", ErrCorrupt)
+	}
+
+	var snap db.Snapshot
+	if err := json.Unmarshal(doc.Snapshot, &snap); err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrCorrupt, err)
+	}
+	if snap.SchemaVersion != m.SchemaVersion {
+		return nil, nil, fmt.Errorf("%w: snapshot schema version %d, manifest says %d", ErrCorrupt, snap.SchemaVersion, m.SchemaVersion)
+	}
+	if got := countsOf(&snap); got != m.Counts {
+		return nil, nil, fmt.Errorf("%w: snapshot counts %+v, manifest says %+v", ErrCorrupt, got, m.Counts)
+	}
+	if snap.SchemaVersion > db.LatestSchemaVersion() {
+		return nil, nil, fmt.Errorf("%w: backup schema version %d, latest known %d",
+			db.ErrSchemaTooNew, snap.SchemaVersion, db.LatestSchemaVersion())
+	}
+	if err := snap.Validate(); err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrCorrupt, err)
+	}
+	return m, &snap, nil
+}
+
+// GenerateKey returns a new X25519 backup key pair.
+func GenerateKey() (privateKey, publicKey [32]byte, err error) {
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return privateKey, publicKey, fmt.Errorf("generate backup key: %w", err)
+	}
+	copy(privateKey[:], key.Bytes())
+	copy(publicKey[:], key.PublicKey().Bytes())
+	return privateKey, publicKey, nil
+}
+
+// ParseKey decodes a hex-encoded 32-byte backup key. Surrounding whitespace
+// is ignored, so keys can be read straight from files.
+func ParseKey(s string) ([32]byte, error) {
+	var key [32]byte
+	raw, err := hex.DecodeString(strings.TrimSpace(s))
+	if err != nil || len(raw) != len(key) {
+		return key, fmt.Errorf("backup key must be %d hex characters (%d bytes)", len(key)*2, len(key))
+	}
+	copy(key[:], raw)
+	return key, nil
+}
@@ -0,0 +1,89 @@
+package backup
+
+import (
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	"github.com/aspect-build/jingui/internal/server/db"
+)
+
+func testSnapshot() *db.Snapshot {
+	return &db.Snapshot{
+		SchemaVersion: db.LatestSchemaVersion(),
+		Vaults:        []db.Vault{{ID: "v1", Name: "V1"}},
+		Fields:        []db.SnapshotField{{VaultID: "v1", Item: "alice", FieldName: "token", Version: 1, Value: "s3cret"}},
+		Versions:      []db.SnapshotVersion{{VaultID: "v1", Item: "alice", FieldName: "token", Version: 1, Value: "s3cret"}},
+		Instances:     []db.TEEInstance{{FID: "fid1", PublicKey: []byte("pubkey"), DstackAppID: "app1"}},
+		Grants:        []db.VaultAccess{{VaultID: "v1", FID: "fid1"}},
+	}
+}
+
+func TestSealOpen(t *testing.T) {
+	priv, pub, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, manifest, err := Seal(testSnapshot(), pub)
+	if err != nil {
+		t.Fatalf("Seal: %v", err)
+	}
+	if manifest.Counts.Fields != 1 || manifest.SchemaVersion != db.LatestSchemaVersion() {
+		t.Errorf("unexpected manifest %+v", manifest)
+	}
+
+	got, snap, err := Open(data, priv)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if got.SHA256 != manifest.SHA256 {
+		t.Errorf("checksum = %s, want %s", got.SHA256, manifest.SHA256)
+	}
+	if len(snap.Fields) != 1 || snap.Fields[0].Value != "s3cret" {
+		t.Errorf("unexpected fields %+v", snap.Fields)
+	}
+
+	otherPriv, _, _ := GenerateKey()
+	if _, _, err := Open(data, otherPriv); err == nil {
+		t.Error("expected Open with the wrong private key to fail")
+	}
+	if _, _, err := Open([]byte("SQLite format 3\x00"), priv); !errors.Is(err, ErrNotBackup) {
+		t.Errorf("expected ErrNotBackup, got %v", err)
+	}
+	data[len(data)-1] ^= 1
+	if _, _, err := Open(data, priv); err == nil {
+		t.Error("expected a tampered backup to fail")
+	}
+}
+
+func TestOpen_Rejected(t *testing.T) {
+	priv, pub, _ := GenerateKey()
+
+	tooNew := testSnapshot()
+	tooNew.SchemaVersion = db.LatestSchemaVersion() + 1
+	data, _, err := Seal(tooNew, pub)
+	if err != nil {
+		t.Fatalf("Seal: %v", err)
+	}
+	if _, _, err := Open(data, priv); !errors.Is(err, db.ErrSchemaTooNew) {
+		t.Errorf("expected ErrSchemaTooNew, got %v", err)
+	}
+
+	dangling := testSnapshot()
+	dangling.Grants = append(dangling.Grants, db.VaultAccess{VaultID: "v1", FID: "missing"})
+	data, _, _ = Seal(dangling, pub)
+	if _, _, err := Open(data, priv); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("expected ErrCorrupt, got %v", err)
+	}
+}
+
+func TestParseKey(t *testing.T) {
+	_, pub, _ := GenerateKey()
+	key, err := ParseKey("  " + hex.EncodeToString(pub[:]) + "\n")
+	if err != nil || key != pub {
+		t.Errorf("ParseKey = %x, %v; want %x", key, err, pub)
+	}
+	if _, err := ParseKey("abcd"); err == nil {
+		t.Error("expected a short key to be rejected")
+	}
+}
@@ -1,18 +1,129 @@
 package server
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
+	"time"
+
+	"github.com/aspect-build/jingui/internal/server/backup"
+	"github.com/aspect-build/jingui/internal/server/db"
+	"github.com/aspect-build/jingui/internal/server/eventsink"
 )
 
+// StoreConfig holds the settings needed to open the database. It is shared by
+// the server and by maintenance subcommands that do not serve HTTP.
+type StoreConfig struct {
+	DBPath string
+	// DBURL is a PostgreSQL connection string. When set it takes precedence
+	// over DBPath.
+	DBURL              string
+	KeyProvider        KeyProvider
+	PreviousMasterKeys [][]byte
+}
+
 // Config holds server configuration loaded from environment variables.
 type Config struct {
+	StoreConfig
 	AdminToken  string
-	DBPath      string
 	ListenAddr  string
 	RATLSStrict bool
 	CORSOrigins []string
+	// BackupPublicKey is the X25519 key GET /v1/backup encrypts to; nil
+	// disables the endpoint.
+	BackupPublicKey *[32]byte
+	// TrashRetention is how long deleted vaults, sections and instances can
+	// be restored before they are purged.
+	TrashRetention time.Duration
+	// AuditSignInterval is how often the head of the audit log is signed.
+	AuditSignInterval time.Duration
+	// EventSinks are the URLs audit and security events are forwarded to;
+	// see eventsink.Open.
+	EventSinks []string
+	// EventBuffer is how many events each sink buffers.
+	EventBuffer int
+}
+
+// LoadStoreConfig loads database settings from environment variables.
+func LoadStoreConfig() (*StoreConfig, error) {
+	keyProvider, err := loadKeyProvider()
+	if err != nil {
+		return nil, err
+	}
+
+	previousKeys, err := loadPreviousMasterKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	dbPath := os.Getenv("JINGUI_DB_PATH")
+	if dbPath == "" {
+		dbPath = "jingui.db"
+	}
+
+	return &StoreConfig{
+		DBPath:             dbPath,
+		DBURL:              os.Getenv("JINGUI_DB_URL"),
+		KeyProvider:        keyProvider,
+		PreviousMasterKeys: previousKeys,
+	}, nil
+}
+
+// MemoryDBPath is the JINGUI_DB_PATH value that keeps all data in process
+// memory, for throwaway servers. Nothing survives a restart.
+const MemoryDBPath = ":memory:"
+
+// errNoDatabase is returned by maintenance entry points for the in-memory
+// store, which has no database to maintain.
+var errNoDatabase = fmt.Errorf("JINGUI_DB_PATH=%s keeps no database to maintain", MemoryDBPath)
+
+// inMemory reports whether cfg selects the in-memory store.
+func (cfg *StoreConfig) inMemory() bool {
+	return cfg.DBURL == "" && cfg.DBPath == MemoryDBPath
+}
+
+// OpenStore opens the store described by cfg: PostgreSQL if DBURL is set,
+// the in-memory store if DBPath is MemoryDBPath, and SQLite otherwise.
+func OpenStore(ctx context.Context, cfg *StoreConfig) (db.Store, error) {
+	if cfg.inMemory() {
+		return db.NewMemoryStore(), nil
+	}
+	return OpenSQLStore(ctx, cfg)
+}
+
+// OpenSQLStore obtains the master key from the configured provider and opens
+// the SQL database described by cfg, for subcommands that maintain the
+// database itself.
+func OpenSQLStore(ctx context.Context, cfg *StoreConfig) (*db.SQLStore, error) {
+	if cfg.inMemory() {
+		return nil, errNoDatabase
+	}
+	masterKey, err := cfg.KeyProvider.MasterKey(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("master key from %s: %w", cfg.KeyProvider.Name(), err)
+	}
+	if cfg.DBURL != "" {
+		return db.NewPostgresStore(cfg.DBURL, masterKey, cfg.PreviousMasterKeys...)
+	}
+	return db.NewStore(cfg.DBPath, masterKey, cfg.PreviousMasterKeys...)
+}
+
+// OpenStoreForMigration is like OpenSQLStore but leaves the schema version
+// untouched, for the migrate subcommand.
+func OpenStoreForMigration(ctx context.Context, cfg *StoreConfig) (*db.SQLStore, error) {
+	if cfg.inMemory() {
+		return nil, errNoDatabase
+	}
+	masterKey, err := cfg.KeyProvider.MasterKey(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("master key from %s: %w", cfg.KeyProvider.Name(), err)
+	}
+	if cfg.DBURL != "" {
+		return db.OpenPostgresForMigration(cfg.DBURL, masterKey, cfg.PreviousMasterKeys...)
+	}
+	return db.OpenForMigration(cfg.DBPath, masterKey, cfg.PreviousMasterKeys...)
 }
 
 // LoadConfig loads server configuration from environment variables.
@@ -25,9 +136,9 @@ func LoadConfig() (*Config, error) {
 		return nil, fmt.Errorf("JINGUI_ADMIN_TOKEN must be at least 16 characters")
 	}
 
-	dbPath := os.Getenv("JINGUI_DB_PATH")
-	if dbPath == "" {
-		dbPath = "jingui.db"
+	storeCfg, err := LoadStoreConfig()
+	if err != nil {
+		return nil, err
 	}
 
 	listenAddr := os.Getenv("JINGUI_LISTEN_ADDR")
@@ -57,11 +168,61 @@ func LoadConfig() (*Config, error) {
 		}
 	}
 
+	var backupKey *[32]byte
+	if v := os.Getenv("JINGUI_BACKUP_PUBLIC_KEY"); v != "" {
+		key, err := backup.ParseKey(v)
+		if err != nil {
+			return nil, fmt.Errorf("JINGUI_BACKUP_PUBLIC_KEY: %w", err)
+		}
+		backupKey = &key
+	}
+
+	trashRetention := DefaultTrashRetention
+	if v := os.Getenv("JINGUI_TRASH_RETENTION"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("JINGUI_TRASH_RETENTION must be a positive duration such as 720h")
+		}
+		trashRetention = d
+	}
+
+	auditSignInterval := DefaultAuditSignInterval
+	if v := os.Getenv("JINGUI_AUDIT_SIGN_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("JINGUI_AUDIT_SIGN_INTERVAL must be a positive duration such as 10m")
+		}
+		auditSignInterval = d
+	}
+
+	var eventSinks []string
+	if v := os.Getenv("JINGUI_EVENT_SINKS"); v != "" {
+		for _, u := range strings.Split(v, ",") {
+			u = strings.TrimSpace(u)
+			if u != "" {
+				eventSinks = append(eventSinks, u)
+			}
+		}
+	}
+	eventBuffer := eventsink.DefaultBuffer
+	if v := os.Getenv("JINGUI_EVENT_BUFFER"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("JINGUI_EVENT_BUFFER must be a positive number of events")
+		}
+		eventBuffer = n
+	}
+
 	return &Config{
-		AdminToken:  adminToken,
-		DBPath:      dbPath,
-		ListenAddr:  listenAddr,
-		RATLSStrict: ratlsStrict,
-		CORSOrigins: corsOrigins,
+		StoreConfig:       *storeCfg,
+		AdminToken:        adminToken,
+		ListenAddr:        listenAddr,
+		RATLSStrict:       ratlsStrict,
+		CORSOrigins:       corsOrigins,
+		BackupPublicKey:   backupKey,
+		TrashRetention:    trashRetention,
+		AuditSignInterval: auditSignInterval,
+		EventSinks:        eventSinks,
+		EventBuffer:       eventBuffer,
 	}, nil
 }
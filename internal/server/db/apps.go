@@ -0,0 +1,87 @@
+package db
+
+import (
+	"fmt"
+	"time"
+)
+
+// App grants give a vault to every instance of a dstack app rather than to
+// one instance. Instances get a fresh key, and so a fresh FID, on every
+// redeploy, while the app ID stays the same. The app ID only counts once the
+// instance's attestation has proven it (strict RA-TLS mode), so HasFieldAccess
+// takes it from the caller instead of from the instance record, which is
+// admin-supplied.
+
+// GrantAppAccess grants every attested instance of a dstack app access to a
+// vault, valid from notBefore until expiresAt when they are set. Granting
+// again replaces the window.
+func (s *SQLStore) GrantAppAccess(vaultID, appID string, notBefore, expiresAt *time.Time) error {
+	_, err := s.db.Exec(
+		`INSERT INTO vault_app_access (vault_id, dstack_app_id, not_before, expires_at) VALUES (?, ?, ?, ?)
+		 ON CONFLICT(vault_id, dstack_app_id) DO UPDATE SET not_before = excluded.not_before, expires_at = excluded.expires_at`,
+		vaultID, appID, s.dialect.nullTimestamp(notBefore), s.dialect.nullTimestamp(expiresAt),
+	)
+	if err != nil {
+		return fmt.Errorf("grant app access: %w", err)
+	}
+	return nil
+}
+
+// RevokeAppAccess removes a dstack app's access to a vault.
+func (s *SQLStore) RevokeAppAccess(vaultID, appID string) (bool, error) {
+	res, err := s.db.Exec(
+		`DELETE FROM vault_app_access WHERE vault_id = ? AND dstack_app_id = ?`,
+		vaultID, appID,
+	)
+	if err != nil {
+		return false, fmt.Errorf("revoke app access: %w", err)
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// ListVaultApps returns the dstack apps granted access to a vault, including
+// grants outside their validity window. Nothing is listed while the vault is
+// in the trash.
+func (s *SQLStore) ListVaultApps(vaultID string) ([]AppAccess, error) {
+	rows, err := s.db.Query(
+		`SELECT vault_id, dstack_app_id, not_before, expires_at, created_at
+		 FROM vault_app_access
+		 WHERE vault_id = ? AND `+liveVaultID+`
+		 ORDER BY dstack_app_id`, vaultID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("list vault apps: %w", err)
+	}
+	defer rows.Close()
+
+	var apps []AppAccess
+	for rows.Next() {
+		var a AppAccess
+		if err := rows.Scan(&a.VaultID, &a.DstackAppID, &a.NotBefore, &a.ExpiresAt, &a.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan app grant: %w", err)
+		}
+		apps = append(apps, a)
+	}
+	return apps, rows.Err()
+}
+
+// hasAppAccess checks if instance fid, attested as appID, has access to a
+// vault through an app grant. Grants are void while the vault or the instance
+// is in the trash and outside their validity window.
+func (s *SQLStore) hasAppAccess(vaultID, fid, appID string) (bool, error) {
+	now := s.dialect.timestamp(time.Now())
+	var count int
+	err := s.db.QueryRow(
+		`SELECT COUNT(*) FROM vault_app_access
+		 WHERE vault_id = ? AND dstack_app_id = ?
+		   AND `+inGrantWindow+`
+		   AND `+liveVaultID+`
+		   AND EXISTS (SELECT 1 FROM tee_instances WHERE fid = ? AND deleted_at IS NULL)`,
+		vaultID, appID, now, now, fid,
+	).Scan(&count)
+	if err != nil {
+		return false, fmt.Errorf("check app access: %w", err)
+	}
+	return count > 0, nil
+}
@@ -0,0 +1,79 @@
+package db
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestAppGrants(t *testing.T) {
+	s := newTestStore(t)
+	s.CreateVault(&Vault{ID: "v1", Name: "V1"})
+	s.RegisterInstance(&TEEInstance{FID: "f1", PublicKey: []byte("pk1"), DstackAppID: "app1"})
+
+	check := func(appID string, want bool) {
+		t.Helper()
+		got, err := s.HasFieldAccess("v1", "f1", appID, "db", "", "password")
+		if err != nil {
+			t.Fatalf("HasFieldAccess: %v", err)
+		}
+		if got != want {
+			t.Errorf("HasFieldAccess(f1 as %q) = %v, want %v", appID, got, want)
+		}
+	}
+
+	if err := s.GrantAppAccess("missing", "app1", nil, nil); err == nil {
+		t.Error("expected GrantAppAccess on a missing vault to fail")
+	}
+	if err := s.GrantAppAccess("v1", "app1", nil, nil); err != nil {
+		t.Fatalf("GrantAppAccess: %v", err)
+	}
+	check("app1", true)
+	check("app2", false)
+	// Without a verified app ID only FID grants count.
+	check("", false)
+	if ok, _ := s.HasVaultAccess("v1", "f1"); ok {
+		t.Error("an app grant should not show up as an FID grant")
+	}
+
+	// Field grants on the instance still carve fields out.
+	s.PutFieldGrant(&FieldGrant{VaultID: "v1", FID: "f1", Item: "db", FieldName: "password", Effect: GrantDeny})
+	check("app1", false)
+	s.DeleteFieldGrant("v1", "f1", "db", "", "password")
+
+	hourAgo := time.Now().Add(-time.Hour)
+	s.GrantAppAccess("v1", "app1", nil, &hourAgo)
+	check("app1", false)
+	apps, err := s.ListVaultApps("v1")
+	if err != nil || len(apps) != 1 || apps[0].DstackAppID != "app1" || apps[0].State(time.Now()) != GrantExpired {
+		t.Errorf("ListVaultApps = %+v, %v; want the expired app1 grant", apps, err)
+	}
+	s.GrantAppAccess("v1", "app1", nil, nil)
+
+	if _, err := s.DeleteVault("v1"); !errors.Is(err, ErrVaultHasDependents) {
+		t.Errorf("DeleteVault with an app grant = %v, want ErrVaultHasDependents", err)
+	}
+	s.DeleteInstance("f1")
+	check("app1", false)
+	s.RestoreInstance("f1")
+	check("app1", true)
+
+	if ok, err := s.RevokeAppAccess("v1", "app1"); err != nil || !ok {
+		t.Errorf("RevokeAppAccess = %v, %v", ok, err)
+	}
+	if ok, _ := s.RevokeAppAccess("v1", "app1"); ok {
+		t.Error("expected a second revoke to report no grant")
+	}
+	check("app1", false)
+
+	s.GrantAppAccess("v1", "app1", nil, nil)
+	s.DeleteVaultCascade("v1")
+	if apps, _ := s.ListVaultApps("v1"); len(apps) != 0 {
+		t.Errorf("ListVaultApps on a trashed vault = %+v", apps)
+	}
+	s.PurgeVault("v1")
+	s.CreateVault(&Vault{ID: "v1", Name: "V1"})
+	if apps, _ := s.ListVaultApps("v1"); len(apps) != 0 {
+		t.Errorf("app grants survived purging the vault: %+v", apps)
+	}
+}
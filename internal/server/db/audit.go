@@ -0,0 +1,238 @@
+package db
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// auditColumns are the audit_events columns scanned by scanAuditEvents.
+const auditColumns = `id, created_at, action, outcome, status, actor, fid, vault_id, target, refs, command, source_ip, app_id, error, prev_hash, hash`
+
+// The audit log is a hash chain: every event stores the hash of the event
+// before it and its own hash over both, so editing or deleting an event
+// breaks the chain from that point on. Event IDs are assigned without gaps
+// for the same reason. Signed heads (audit_signatures) pin the chain so it
+// cannot be rewritten from scratch or truncated unnoticed.
+
+// AuditEventHash returns the chain hash of ev: SHA-256 over its PrevHash and
+// every recorded field, each length-prefixed, hex-encoded.
+func AuditEventHash(ev *AuditEvent) string {
+	refs, _ := encodeRefs(ev.Refs) // marshalling strings cannot fail
+	h := sha256.New()
+	for _, f := range []string{
+		ev.PrevHash, strconv.FormatInt(ev.ID, 10), ev.Time.UTC().Format(time.RFC3339),
+		ev.Action, ev.Outcome, strconv.Itoa(ev.Status), ev.Actor, ev.FID, ev.VaultID,
+		ev.Target, refs, ev.Command, ev.SourceIP, ev.AppID, ev.Error,
+	} {
+		var n [4]byte
+		binary.BigEndian.PutUint32(n[:], uint32(len(f)))
+		h.Write(n[:])
+		h.Write([]byte(f))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// RecordAuditEvent appends an event to the audit log, setting its ID, time
+// (if unset) and chain hashes.
+func (s *SQLStore) RecordAuditEvent(ev *AuditEvent) error {
+	if ev.Time.IsZero() {
+		ev.Time = now()
+	}
+	ev.Time = ev.Time.UTC().Truncate(time.Second)
+	refs, err := encodeRefs(ev.Refs)
+	if err != nil {
+		return err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	if s.dialect.lockAuditLog != "" {
+		if _, err := tx.Exec(s.dialect.lockAuditLog); err != nil {
+			return fmt.Errorf("lock audit log: %w", err)
+		}
+	}
+	var (
+		lastID   int64
+		lastHash string
+	)
+	err = tx.QueryRow(`SELECT id, hash FROM audit_events ORDER BY id DESC LIMIT 1`).Scan(&lastID, &lastHash)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("read audit head: %w", err)
+	}
+	ev.ID, ev.PrevHash = lastID+1, lastHash
+	ev.Hash = AuditEventHash(ev)
+
+	if _, err := tx.Exec(
+		`INSERT INTO audit_events (id, created_at, action, outcome, status, actor, fid, vault_id, target, refs, command, source_ip, app_id, error, prev_hash, hash)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		ev.ID, s.dialect.timestamp(ev.Time), ev.Action, ev.Outcome, ev.Status, ev.Actor, ev.FID, ev.VaultID,
+		ev.Target, refs, ev.Command, ev.SourceIP, ev.AppID, ev.Error, ev.PrevHash, ev.Hash,
+	); err != nil {
+		return fmt.Errorf("record audit event: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit tx: %w", err)
+	}
+	return nil
+}
+
+// ListAuditEvents returns the audit events matching q, newest first.
+func (s *SQLStore) ListAuditEvents(q AuditQuery) ([]AuditEvent, error) {
+	var (
+		conds []string
+		args  []any
+	)
+	for _, f := range []struct{ column, value string }{
+		{"action", q.Action}, {"outcome", q.Outcome}, {"actor", q.Actor}, {"vault_id", q.VaultID},
+	} {
+		if f.value != "" {
+			conds = append(conds, f.column+` = ?`)
+			args = append(args, f.value)
+		}
+	}
+	if q.FID != "" {
+		// An instance's events include those recorded under the FIDs it was
+		// re-keyed away from.
+		conds = append(conds, `(fid = ? OR fid IN (SELECT alias_fid FROM instance_aliases WHERE fid = ?))`)
+		args = append(args, q.FID, q.FID)
+	}
+	if !q.Since.IsZero() {
+		conds = append(conds, `created_at >= ?`)
+		args = append(args, s.dialect.timestamp(q.Since))
+	}
+	if !q.Until.IsZero() {
+		conds = append(conds, `created_at < ?`)
+		args = append(args, s.dialect.timestamp(q.Until))
+	}
+	if q.BeforeID > 0 {
+		conds = append(conds, `id < ?`)
+		args = append(args, q.BeforeID)
+	}
+
+	query := `SELECT ` + auditColumns + ` FROM audit_events`
+	if len(conds) > 0 {
+		query += ` WHERE ` + strings.Join(conds, ` AND `)
+	}
+	query += ` ORDER BY id DESC`
+	if q.Limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, q.Limit)
+	}
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("list audit events: %w", err)
+	}
+	return scanAuditEvents(rows)
+}
+
+// scanAuditEvents reads and closes rows of auditColumns.
+func scanAuditEvents(rows *sql.Rows) ([]AuditEvent, error) {
+	defer rows.Close()
+
+	var events []AuditEvent
+	for rows.Next() {
+		var (
+			ev   AuditEvent
+			refs string
+			err  error
+		)
+		if err := rows.Scan(&ev.ID, &ev.Time, &ev.Action, &ev.Outcome, &ev.Status, &ev.Actor, &ev.FID, &ev.VaultID,
+			&ev.Target, &refs, &ev.Command, &ev.SourceIP, &ev.AppID, &ev.Error, &ev.PrevHash, &ev.Hash); err != nil {
+			return nil, fmt.Errorf("scan audit event: %w", err)
+		}
+		if ev.Refs, err = decodeRefs(refs); err != nil {
+			return nil, err
+		}
+		ev.Time = ev.Time.UTC()
+		events = append(events, ev)
+	}
+	return events, rows.Err()
+}
+
+// RecordAuditSignature stores a signed audit head. A head already signed,
+// e.g. by another server sharing the database, is left as it is.
+func (s *SQLStore) RecordAuditSignature(sig *AuditSignature) error {
+	if _, err := s.db.Exec(
+		`INSERT INTO audit_signatures (event_id, hash, public_key, signature, signed_at)
+		 VALUES (?, ?, ?, ?, ?)
+		 ON CONFLICT(event_id) DO NOTHING`,
+		sig.EventID, sig.Hash, sig.PublicKey, sig.Signature, s.dialect.timestamp(sig.SignedAt),
+	); err != nil {
+		return fmt.Errorf("record audit signature: %w", err)
+	}
+	return nil
+}
+
+// ListAuditSignatures returns every signed audit head, oldest first.
+func (s *SQLStore) ListAuditSignatures() ([]AuditSignature, error) {
+	rows, err := s.db.Query(`SELECT event_id, hash, public_key, signature, signed_at FROM audit_signatures ORDER BY event_id`)
+	if err != nil {
+		return nil, fmt.Errorf("list audit signatures: %w", err)
+	}
+	defer rows.Close()
+
+	var sigs []AuditSignature
+	for rows.Next() {
+		var sig AuditSignature
+		if err := rows.Scan(&sig.EventID, &sig.Hash, &sig.PublicKey, &sig.Signature, &sig.SignedAt); err != nil {
+			return nil, fmt.Errorf("scan audit signature: %w", err)
+		}
+		sig.SignedAt = sig.SignedAt.UTC()
+		sigs = append(sigs, sig)
+	}
+	return sigs, rows.Err()
+}
+
+// encodeRefs stores secret references as a JSON array, or an empty string
+// for none.
+func encodeRefs(refs []string) (string, error) {
+	if len(refs) == 0 {
+		return "", nil
+	}
+	b, err := json.Marshal(refs)
+	if err != nil {
+		return "", fmt.Errorf("encode refs: %w", err)
+	}
+	return string(b), nil
+}
+
+func decodeRefs(s string) ([]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var refs []string
+	if err := json.Unmarshal([]byte(s), &refs); err != nil {
+		return nil, fmt.Errorf("decode refs: %w", err)
+	}
+	return refs, nil
+}
+
+// auditMatches reports whether ev is selected by q, for stores that filter in
+// memory.
+func auditMatches(ev *AuditEvent, q AuditQuery) bool {
+	switch {
+	case q.Action != "" && ev.Action != q.Action,
+		q.Outcome != "" && ev.Outcome != q.Outcome,
+		q.Actor != "" && ev.Actor != q.Actor,
+		q.FID != "" && ev.FID != q.FID,
+		q.VaultID != "" && ev.VaultID != q.VaultID,
+		!q.Since.IsZero() && ev.Time.Before(q.Since.Truncate(time.Second)),
+		!q.Until.IsZero() && !ev.Time.Before(q.Until.Truncate(time.Second)),
+		q.BeforeID > 0 && ev.ID >= q.BeforeID:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,163 @@
+package db
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAuditEvents(t *testing.T) {
+	s := newTestStore(t)
+
+	base := time.Date(2030, 1, 1, 12, 0, 0, 0, time.UTC)
+	events := []AuditEvent{
+		{Time: base, Action: "vault.create", Outcome: AuditSuccess, Status: 201, Actor: "alice", VaultID: "v1", Target: "/v1/vaults"},
+		{Time: base.Add(time.Minute), Action: "secrets.fetch", Outcome: AuditSuccess, Status: 200, FID: "f1", VaultID: "v1",
+			Refs: []string{"jingui://v1/db/password", "jingui://v1/db/user"}, Command: "run", SourceIP: "10.0.0.1", AppID: "app1"},
+		{Time: base.Add(2 * time.Minute), Action: "secrets.fetch", Outcome: AuditDenied, Status: 403, FID: "f2", VaultID: "v2",
+			Refs: []string{"jingui://v2/db/password"}, Error: "vault mismatch for reference: jingui://v2/db/password"},
+		{Time: base.Add(3 * time.Minute), Action: "vault.delete", Outcome: AuditSuccess, Status: 200, Actor: "bob", VaultID: "v2"},
+	}
+	for i := range events {
+		if err := s.RecordAuditEvent(&events[i]); err != nil {
+			t.Fatalf("RecordAuditEvent: %v", err)
+		}
+		if i > 0 && events[i].ID <= events[i-1].ID {
+			t.Fatalf("event IDs not increasing: %d after %d", events[i].ID, events[i-1].ID)
+		}
+	}
+
+	all, err := s.ListAuditEvents(AuditQuery{})
+	if err != nil {
+		t.Fatalf("ListAuditEvents: %v", err)
+	}
+	if len(all) != 4 || all[0].Action != "vault.delete" || all[3].Action != "vault.create" {
+		t.Fatalf("ListAuditEvents = %+v, want 4 events newest first", all)
+	}
+	fetch := all[2]
+	if fetch.FID != "f1" || len(fetch.Refs) != 2 || fetch.Refs[1] != "jingui://v1/db/user" || fetch.Command != "run" ||
+		fetch.SourceIP != "10.0.0.1" || fetch.AppID != "app1" || !fetch.Time.Equal(base.Add(time.Minute)) {
+		t.Errorf("fetch event = %+v", fetch)
+	}
+	if all[0].Refs != nil {
+		t.Errorf("admin event refs = %v, want none", all[0].Refs)
+	}
+
+	for _, tc := range []struct {
+		name string
+		q    AuditQuery
+		want []string
+	}{
+		{"action", AuditQuery{Action: "secrets.fetch"}, []string{"f2", "f1"}},
+		{"outcome", AuditQuery{Outcome: AuditDenied}, []string{"f2"}},
+		{"fid", AuditQuery{FID: "f1"}, []string{"f1"}},
+		{"vault", AuditQuery{VaultID: "v2"}, []string{"bob", "f2"}},
+		{"actor", AuditQuery{Actor: "alice"}, []string{"alice"}},
+		{"since", AuditQuery{Since: base.Add(2 * time.Minute)}, []string{"bob", "f2"}},
+		{"until", AuditQuery{Until: base.Add(time.Minute)}, []string{"alice"}},
+		{"limit", AuditQuery{Limit: 1}, []string{"bob"}},
+		{"page", AuditQuery{BeforeID: all[1].ID, Limit: 1}, []string{"f1"}},
+	} {
+		got, err := s.ListAuditEvents(tc.q)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		var who []string
+		for _, ev := range got {
+			if ev.Actor != "" {
+				who = append(who, ev.Actor)
+			} else {
+				who = append(who, ev.FID)
+			}
+		}
+		if len(who) != len(tc.want) {
+			t.Errorf("%s: got %v, want %v", tc.name, who, tc.want)
+			continue
+		}
+		for i := range who {
+			if who[i] != tc.want[i] {
+				t.Errorf("%s: got %v, want %v", tc.name, who, tc.want)
+				break
+			}
+		}
+	}
+}
+
+func TestAuditChain(t *testing.T) {
+	s := newTestStore(t)
+
+	for _, action := range []string{"vault.create", "item.put", "vault.delete"} {
+		if err := s.RecordAuditEvent(&AuditEvent{Action: action, Outcome: AuditSuccess, Status: 200}); err != nil {
+			t.Fatalf("RecordAuditEvent: %v", err)
+		}
+	}
+	events, err := s.ListAuditEvents(AuditQuery{})
+	if err != nil {
+		t.Fatalf("ListAuditEvents: %v", err)
+	}
+	prev := ""
+	for i := len(events) - 1; i >= 0; i-- {
+		ev := events[i]
+		if want := int64(len(events) - i); ev.ID != want {
+			t.Errorf("event %q has ID %d, want %d", ev.Action, ev.ID, want)
+		}
+		if ev.PrevHash != prev {
+			t.Errorf("event %d prev_hash = %q, want %q", ev.ID, ev.PrevHash, prev)
+		}
+		if ev.Hash == "" || AuditEventHash(&ev) != ev.Hash {
+			t.Errorf("event %d hash = %q, want %q", ev.ID, ev.Hash, AuditEventHash(&ev))
+		}
+		prev = ev.Hash
+	}
+
+	tampered := events[1]
+	tampered.Actor = "mallory"
+	if AuditEventHash(&tampered) == tampered.Hash {
+		t.Error("changing an event should change its hash")
+	}
+
+	signedAt := time.Date(2030, 1, 1, 12, 0, 0, 0, time.UTC)
+	for _, sig := range []AuditSignature{
+		{EventID: 3, Hash: events[0].Hash, PublicKey: "pk", Signature: "sig3", SignedAt: signedAt},
+		{EventID: 1, Hash: events[2].Hash, PublicKey: "pk", Signature: "sig1", SignedAt: signedAt},
+		{EventID: 3, Hash: "other", PublicKey: "pk", Signature: "again", SignedAt: signedAt},
+	} {
+		if err := s.RecordAuditSignature(&sig); err != nil {
+			t.Fatalf("RecordAuditSignature: %v", err)
+		}
+	}
+	sigs, err := s.ListAuditSignatures()
+	if err != nil {
+		t.Fatalf("ListAuditSignatures: %v", err)
+	}
+	if len(sigs) != 2 || sigs[0].EventID != 1 || sigs[1].Signature != "sig3" || !sigs[1].SignedAt.Equal(signedAt) {
+		t.Errorf("ListAuditSignatures = %+v, want heads 1 and 3 with the first signature kept", sigs)
+	}
+}
+
+func TestAuditChain_MigrationChainsExistingEvents(t *testing.T) {
+	s := newSQLTestStore(t)
+	for _, action := range []string{"vault.create", "item.put"} {
+		if err := s.RecordAuditEvent(&AuditEvent{Action: action, Outcome: AuditSuccess, Status: 200}); err != nil {
+			t.Fatalf("RecordAuditEvent: %v", err)
+		}
+	}
+	want, _ := s.ListAuditEvents(AuditQuery{})
+
+	if _, err := s.MigrateDown(5); err != nil {
+		t.Fatalf("MigrateDown: %v", err)
+	}
+	if has, _ := s.dialect.columnExists(s.db, "audit_events", "hash"); has {
+		t.Fatal("audit_events.hash should be dropped")
+	}
+	if _, err := s.MigrateUp(LatestSchemaVersion()); err != nil {
+		t.Fatalf("MigrateUp: %v", err)
+	}
+
+	got, err := s.ListAuditEvents(AuditQuery{})
+	if err != nil {
+		t.Fatalf("ListAuditEvents: %v", err)
+	}
+	if len(got) != 2 || got[0].Hash != want[0].Hash || got[1].Hash != want[1].Hash || got[0].PrevHash != got[1].Hash {
+		t.Errorf("events after migration = %+v, want the chain %+v", got, want)
+	}
+}
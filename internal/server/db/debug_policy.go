@@ -6,7 +6,7 @@ import (
 )
 
 // UpsertDebugPolicy inserts or updates a debug policy for a vault+instance pair.
-func (s *Store) UpsertDebugPolicy(vaultID, fid string, allow bool) error {
+func (s *SQLStore) UpsertDebugPolicy(vaultID, fid string, allow bool) error {
 	allowInt := 0
 	if allow {
 		allowInt = 1
@@ -26,7 +26,7 @@ func (s *Store) UpsertDebugPolicy(vaultID, fid string, allow bool) error {
 }
 
 // GetDebugPolicy retrieves a debug policy. Returns nil if no policy exists.
-func (s *Store) GetDebugPolicy(vaultID, fid string) (*DebugPolicy, error) {
+func (s *SQLStore) GetDebugPolicy(vaultID, fid string) (*DebugPolicy, error) {
 	p := &DebugPolicy{}
 	var allowInt int
 	err := s.db.QueryRow(
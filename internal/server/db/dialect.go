@@ -0,0 +1,134 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A dialect captures what differs between the SQL databases SQLStore runs on.
+// Queries are written once with ? placeholders and rewritten for drivers that
+// use numbered parameters; schema changes live in per-dialect migration lists
+// that share version numbers and names.
+type dialect struct {
+	name string
+	// numbered is true for drivers that expect $1, $2, ... placeholders.
+	numbered bool
+	// migrations lists the schema steps; versions and names must match the
+	// SQLite list so schema_migrations means the same on every backend.
+	migrations []migration
+	// migrationsTable is the DDL for the schema_migrations table.
+	migrationsTable string
+	// beginMigration starts the transaction a migration step runs in. The
+	// returned function releases any resources held for it.
+	beginMigration func(s *SQLStore) (*dialectTx, func(), error)
+	// checkMigration validates the schema before a migration step commits.
+	checkMigration func(tx *dialectTx) error
+	// adoptUntracked stamps databases created before migrations were tracked.
+	adoptUntracked func(s *SQLStore) error
+	// columnExists reports whether table has the given column.
+	columnExists func(e dbtx, table, column string) (bool, error)
+	// constraint classifies constraint violations reported by the driver.
+	constraint func(err error) constraintKind
+	// snapshotTx are the options for a transaction that reads a consistent
+	// view of the whole database.
+	snapshotTx *sql.TxOptions
+	// timestamp converts a time to the value stored in a timestamp column.
+	timestamp func(t time.Time) any
+	// lockAuditLog starts a transaction that appends to the audit log, so
+	// events are chained one at a time. Empty where transactions are already
+	// serialized.
+	lockAuditLog string
+}
+
+// constraintKind is the kind of constraint a failed statement violated.
+type constraintKind int
+
+const (
+	constraintNone constraintKind = iota
+	constraintPrimaryKey
+	constraintUnique
+	constraintForeignKey
+)
+
+// nullTimestamp is timestamp for nullable columns: nil stays NULL.
+func (d *dialect) nullTimestamp(t *time.Time) any {
+	if t == nil {
+		return nil
+	}
+	return d.timestamp(*t)
+}
+
+// rebind rewrites ? placeholders outside of string literals for dialects with
+// numbered parameters.
+func (d *dialect) rebind(query string) string {
+	if !d.numbered || !strings.Contains(query, "?") {
+		return query
+	}
+	var b strings.Builder
+	b.Grow(len(query) + 8)
+	n, quoted := 0, false
+	for _, r := range query {
+		switch {
+		case r == '\'':
+			quoted = !quoted
+		case r == '?' && !quoted:
+			n++
+			b.WriteByte('$')
+			b.WriteString(strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// dialectDB is a *sql.DB whose queries are rebound for its dialect.
+type dialectDB struct {
+	*sql.DB
+	d *dialect
+}
+
+func (db *dialectDB) Exec(query string, args ...any) (sql.Result, error) {
+	return db.DB.Exec(db.d.rebind(query), args...)
+}
+
+func (db *dialectDB) Query(query string, args ...any) (*sql.Rows, error) {
+	return db.DB.Query(db.d.rebind(query), args...)
+}
+
+func (db *dialectDB) QueryRow(query string, args ...any) *sql.Row {
+	return db.DB.QueryRow(db.d.rebind(query), args...)
+}
+
+func (db *dialectDB) Begin() (*dialectTx, error) {
+	return db.BeginTx(context.Background(), nil)
+}
+
+func (db *dialectDB) BeginTx(ctx context.Context, opts *sql.TxOptions) (*dialectTx, error) {
+	tx, err := db.DB.BeginTx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &dialectTx{Tx: tx, d: db.d}, nil
+}
+
+// dialectTx is a *sql.Tx whose queries are rebound for its dialect.
+type dialectTx struct {
+	*sql.Tx
+	d *dialect
+}
+
+func (tx *dialectTx) Exec(query string, args ...any) (sql.Result, error) {
+	return tx.Tx.Exec(tx.d.rebind(query), args...)
+}
+
+func (tx *dialectTx) Query(query string, args ...any) (*sql.Rows, error) {
+	return tx.Tx.Query(tx.d.rebind(query), args...)
+}
+
+func (tx *dialectTx) QueryRow(query string, args ...any) *sql.Row {
+	return tx.Tx.QueryRow(tx.d.rebind(query), args...)
+}
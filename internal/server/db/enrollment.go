@@ -0,0 +1,387 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Enrollment policies let TEE instances register themselves: an instance
+// whose attestation proves it runs the policy's dstack app is registered
+// without the admin token and granted the policy's vaults. The handler
+// verifies the attestation; the store only records the policy and applies it.
+//
+// Enrollment tokens do the same for instances that cannot attest: whoever
+// holds a token's secret may register a limited number of instances before
+// it expires.
+
+// ErrEnrollmentTokenSpent is returned when redeeming an enrollment token that
+// has expired, been used up or been deleted.
+var ErrEnrollmentTokenSpent = errors.New("enrollment token is expired, used up or revoked")
+
+// PutEnrollmentPolicy creates or replaces the enrollment policy of a dstack
+// app. Every vault in p.Vaults must exist.
+func (s *SQLStore) PutEnrollmentPolicy(p *EnrollmentPolicy) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(
+		`INSERT INTO enrollment_policies (dstack_app_id, label) VALUES (?, ?)
+		 ON CONFLICT(dstack_app_id) DO UPDATE SET label = excluded.label, updated_at = CURRENT_TIMESTAMP`,
+		p.DstackAppID, p.Label,
+	); err != nil {
+		return fmt.Errorf("put enrollment policy: %w", err)
+	}
+	if _, err := tx.Exec(`DELETE FROM enrollment_policy_vaults WHERE dstack_app_id = ?`, p.DstackAppID); err != nil {
+		return fmt.Errorf("clear enrollment policy vaults: %w", err)
+	}
+	for _, vaultID := range p.Vaults {
+		if _, err := tx.Exec(
+			`INSERT INTO enrollment_policy_vaults (dstack_app_id, vault_id) VALUES (?, ?) ON CONFLICT DO NOTHING`,
+			p.DstackAppID, vaultID,
+		); err != nil {
+			return fmt.Errorf("add enrollment policy vault %q: %w", vaultID, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit tx: %w", err)
+	}
+	return nil
+}
+
+// GetEnrollmentPolicy retrieves the enrollment policy of a dstack app. Vaults
+// in the trash are left out of the policy until they are restored.
+func (s *SQLStore) GetEnrollmentPolicy(appID string) (*EnrollmentPolicy, error) {
+	p := &EnrollmentPolicy{}
+	err := s.db.QueryRow(
+		`SELECT dstack_app_id, label, created_at, updated_at FROM enrollment_policies WHERE dstack_app_id = ?`, appID,
+	).Scan(&p.DstackAppID, &p.Label, &p.CreatedAt, &p.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get enrollment policy: %w", err)
+	}
+	vaults, err := s.enrollmentVaults(`dstack_app_id = ?`, appID)
+	if err != nil {
+		return nil, err
+	}
+	p.Vaults = vaults[appID]
+	return p, nil
+}
+
+// ListEnrollmentPolicies returns all enrollment policies, ordered by app ID.
+func (s *SQLStore) ListEnrollmentPolicies() ([]EnrollmentPolicy, error) {
+	rows, err := s.db.Query(
+		`SELECT dstack_app_id, label, created_at, updated_at FROM enrollment_policies ORDER BY dstack_app_id`,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("list enrollment policies: %w", err)
+	}
+	defer rows.Close()
+
+	var policies []EnrollmentPolicy
+	for rows.Next() {
+		var p EnrollmentPolicy
+		if err := rows.Scan(&p.DstackAppID, &p.Label, &p.CreatedAt, &p.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("scan enrollment policy: %w", err)
+		}
+		policies = append(policies, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	vaults, err := s.enrollmentVaults(`1 = 1`)
+	if err != nil {
+		return nil, err
+	}
+	for i := range policies {
+		policies[i].Vaults = vaults[policies[i].DstackAppID]
+	}
+	return policies, nil
+}
+
+// enrollmentVaults returns the live vaults of the enrollment policies
+// matching where, keyed by app ID.
+func (s *SQLStore) enrollmentVaults(where string, args ...any) (map[string][]string, error) {
+	rows, err := s.db.Query(
+		`SELECT dstack_app_id, vault_id FROM enrollment_policy_vaults
+		 WHERE `+where+` AND `+liveVaultID+`
+		 ORDER BY dstack_app_id, vault_id`, args...,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("list enrollment policy vaults: %w", err)
+	}
+	defer rows.Close()
+
+	vaults := map[string][]string{}
+	for rows.Next() {
+		var app, vaultID string
+		if err := rows.Scan(&app, &vaultID); err != nil {
+			return nil, fmt.Errorf("scan enrollment policy vault: %w", err)
+		}
+		vaults[app] = append(vaults[app], vaultID)
+	}
+	return vaults, rows.Err()
+}
+
+// DeleteEnrollmentPolicy removes the enrollment policy of a dstack app.
+// Instances it enrolled keep their registration and grants.
+func (s *SQLStore) DeleteEnrollmentPolicy(appID string) (bool, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return false, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM enrollment_policy_vaults WHERE dstack_app_id = ?`, appID); err != nil {
+		return false, fmt.Errorf("delete enrollment policy vaults: %w", err)
+	}
+	res, err := tx.Exec(`DELETE FROM enrollment_policies WHERE dstack_app_id = ?`, appID)
+	if err != nil {
+		return false, fmt.Errorf("delete enrollment policy: %w", err)
+	}
+	n, _ := res.RowsAffected()
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("commit tx: %w", err)
+	}
+	return n > 0, nil
+}
+
+// EnrollInstance registers inst and grants it permanent access to vaultIDs in
+// one transaction. It fails like RegisterInstance if the FID or public key is
+// taken.
+func (s *SQLStore) EnrollInstance(inst *TEEInstance, vaultIDs []string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := insertInstance(tx, inst); err != nil {
+		// A failed statement aborts a PostgreSQL transaction, so the
+		// conflict is looked up outside it.
+		tx.Rollback()
+		return s.registerError(inst, err)
+	}
+	for _, vaultID := range vaultIDs {
+		if _, err := tx.Exec(
+			`INSERT INTO vault_instance_access (vault_id, fid) VALUES (?, ?) ON CONFLICT DO NOTHING`,
+			vaultID, inst.FID,
+		); err != nil {
+			return fmt.Errorf("grant vault %q: %w", vaultID, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit tx: %w", err)
+	}
+	return nil
+}
+
+// CreateEnrollmentToken stores a new enrollment token. Every vault in t.Vaults
+// must exist.
+func (s *SQLStore) CreateEnrollmentToken(t *EnrollmentToken) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(
+		`INSERT INTO enrollment_tokens (id, token_hash, dstack_app_id, label, max_uses, expires_at) VALUES (?, ?, ?, ?, ?, ?)`,
+		t.ID, t.TokenHash, t.DstackAppID, t.Label, t.MaxUses, s.dialect.timestamp(t.ExpiresAt),
+	); err != nil {
+		return fmt.Errorf("create enrollment token: %w", err)
+	}
+	for _, vaultID := range t.Vaults {
+		if _, err := tx.Exec(
+			`INSERT INTO enrollment_token_vaults (token_id, vault_id) VALUES (?, ?) ON CONFLICT DO NOTHING`,
+			t.ID, vaultID,
+		); err != nil {
+			return fmt.Errorf("add enrollment token vault %q: %w", vaultID, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit tx: %w", err)
+	}
+	return nil
+}
+
+// enrollmentTokenColumns are the enrollment_tokens columns scanned by
+// scanEnrollmentToken.
+const enrollmentTokenColumns = `id, token_hash, dstack_app_id, label, max_uses, uses, expires_at, created_at`
+
+func scanEnrollmentToken(row interface{ Scan(...any) error }, t *EnrollmentToken) error {
+	return row.Scan(&t.ID, &t.TokenHash, &t.DstackAppID, &t.Label, &t.MaxUses, &t.Uses, &t.ExpiresAt, &t.CreatedAt)
+}
+
+// GetEnrollmentTokenByHash retrieves the enrollment token whose secret hashes
+// to tokenHash, whether or not it can still be redeemed.
+func (s *SQLStore) GetEnrollmentTokenByHash(tokenHash string) (*EnrollmentToken, error) {
+	t := &EnrollmentToken{}
+	err := scanEnrollmentToken(s.db.QueryRow(
+		`SELECT `+enrollmentTokenColumns+` FROM enrollment_tokens WHERE token_hash = ?`, tokenHash,
+	), t)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get enrollment token: %w", err)
+	}
+	vaults, err := s.enrollmentTokenVaults(`token_id = ?`, t.ID)
+	if err != nil {
+		return nil, err
+	}
+	t.Vaults = vaults[t.ID]
+	return t, nil
+}
+
+// ListEnrollmentTokens returns all enrollment tokens, oldest first, including
+// spent ones.
+func (s *SQLStore) ListEnrollmentTokens() ([]EnrollmentToken, error) {
+	rows, err := s.db.Query(`SELECT ` + enrollmentTokenColumns + ` FROM enrollment_tokens ORDER BY created_at, id`)
+	if err != nil {
+		return nil, fmt.Errorf("list enrollment tokens: %w", err)
+	}
+	defer rows.Close()
+
+	var tokens []EnrollmentToken
+	for rows.Next() {
+		var t EnrollmentToken
+		if err := scanEnrollmentToken(rows, &t); err != nil {
+			return nil, fmt.Errorf("scan enrollment token: %w", err)
+		}
+		tokens = append(tokens, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	vaults, err := s.enrollmentTokenVaults(`1 = 1`)
+	if err != nil {
+		return nil, err
+	}
+	for i := range tokens {
+		tokens[i].Vaults = vaults[tokens[i].ID]
+	}
+	return tokens, nil
+}
+
+// enrollmentTokenVaults returns the live vaults of the enrollment tokens
+// matching where, keyed by token ID.
+func (s *SQLStore) enrollmentTokenVaults(where string, args ...any) (map[string][]string, error) {
+	rows, err := s.db.Query(
+		`SELECT token_id, vault_id FROM enrollment_token_vaults
+		 WHERE `+where+` AND `+liveVaultID+`
+		 ORDER BY token_id, vault_id`, args...,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("list enrollment token vaults: %w", err)
+	}
+	defer rows.Close()
+
+	vaults := map[string][]string{}
+	for rows.Next() {
+		var id, vaultID string
+		if err := rows.Scan(&id, &vaultID); err != nil {
+			return nil, fmt.Errorf("scan enrollment token vault: %w", err)
+		}
+		vaults[id] = append(vaults[id], vaultID)
+	}
+	return vaults, rows.Err()
+}
+
+// DeleteEnrollmentToken revokes an enrollment token. Instances registered
+// with it keep their registration and grants.
+func (s *SQLStore) DeleteEnrollmentToken(id string) (bool, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return false, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM enrollment_token_vaults WHERE token_id = ?`, id); err != nil {
+		return false, fmt.Errorf("delete enrollment token vaults: %w", err)
+	}
+	res, err := tx.Exec(`DELETE FROM enrollment_tokens WHERE id = ?`, id)
+	if err != nil {
+		return false, fmt.Errorf("delete enrollment token: %w", err)
+	}
+	n, _ := res.RowsAffected()
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("commit tx: %w", err)
+	}
+	return n > 0, nil
+}
+
+// RedeemEnrollmentToken uses up one use of the token, registers inst and
+// grants it permanent access to the token's live vaults, all in one
+// transaction. It returns ErrEnrollmentTokenSpent if the token cannot be
+// redeemed, and fails like RegisterInstance, without using the token, if the
+// FID or public key is taken.
+func (s *SQLStore) RedeemEnrollmentToken(id string, inst *TEEInstance) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	// The conditional update makes concurrent redemptions of the last use
+	// race for the row instead of both passing a prior check.
+	res, err := tx.Exec(
+		`UPDATE enrollment_tokens SET uses = uses + 1 WHERE id = ? AND uses < max_uses AND expires_at > ?`,
+		id, s.dialect.timestamp(time.Now()),
+	)
+	if err != nil {
+		return fmt.Errorf("redeem enrollment token: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrEnrollmentTokenSpent
+	}
+
+	rows, err := tx.Query(`SELECT vault_id FROM enrollment_token_vaults WHERE token_id = ? AND `+liveVaultID, id)
+	if err != nil {
+		return fmt.Errorf("list enrollment token vaults: %w", err)
+	}
+	var vaultIDs []string
+	for rows.Next() {
+		var vaultID string
+		if err := rows.Scan(&vaultID); err != nil {
+			rows.Close()
+			return fmt.Errorf("scan enrollment token vault: %w", err)
+		}
+		vaultIDs = append(vaultIDs, vaultID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if err := insertInstance(tx, inst); err != nil {
+		tx.Rollback()
+		return s.registerError(inst, err)
+	}
+	for _, vaultID := range vaultIDs {
+		if _, err := tx.Exec(
+			`INSERT INTO vault_instance_access (vault_id, fid) VALUES (?, ?) ON CONFLICT DO NOTHING`,
+			vaultID, inst.FID,
+		); err != nil {
+			return fmt.Errorf("grant vault %q: %w", vaultID, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit tx: %w", err)
+	}
+	return nil
+}
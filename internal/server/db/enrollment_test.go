@@ -0,0 +1,176 @@
+package db
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestEnrollmentPolicies(t *testing.T) {
+	s := newTestStore(t)
+	s.CreateVault(&Vault{ID: "v1", Name: "V1"})
+	s.CreateVault(&Vault{ID: "v2", Name: "V2"})
+
+	if p, err := s.GetEnrollmentPolicy("app1"); err != nil || p != nil {
+		t.Fatalf("GetEnrollmentPolicy before put = %+v, %v; want nil", p, err)
+	}
+	if err := s.PutEnrollmentPolicy(&EnrollmentPolicy{DstackAppID: "app1", Vaults: []string{"missing"}}); err == nil {
+		t.Error("expected a policy granting a missing vault to fail")
+	}
+	if err := s.PutEnrollmentPolicy(&EnrollmentPolicy{DstackAppID: "app1", Label: "web-{fid}", Vaults: []string{"v2", "v1"}}); err != nil {
+		t.Fatalf("PutEnrollmentPolicy: %v", err)
+	}
+	p, err := s.GetEnrollmentPolicy("app1")
+	if err != nil || p == nil || p.Label != "web-{fid}" || !reflect.DeepEqual(p.Vaults, []string{"v1", "v2"}) {
+		t.Fatalf("GetEnrollmentPolicy = %+v, %v; want web-{fid} granting v1 and v2", p, err)
+	}
+
+	// Putting again replaces the label and the vaults.
+	s.PutEnrollmentPolicy(&EnrollmentPolicy{DstackAppID: "app1", Label: "web", Vaults: []string{"v2"}})
+	s.PutEnrollmentPolicy(&EnrollmentPolicy{DstackAppID: "app0"})
+	policies, err := s.ListEnrollmentPolicies()
+	if err != nil || len(policies) != 2 || policies[0].DstackAppID != "app0" || len(policies[0].Vaults) != 0 ||
+		policies[1].Label != "web" || !reflect.DeepEqual(policies[1].Vaults, []string{"v2"}) {
+		t.Fatalf("ListEnrollmentPolicies = %+v, %v", policies, err)
+	}
+
+	// A policy keeps its vault from being deleted; a trashed vault drops out
+	// of the policy until it is restored.
+	if _, err := s.DeleteVault("v2"); !errors.Is(err, ErrVaultHasDependents) {
+		t.Errorf("DeleteVault used by a policy = %v, want ErrVaultHasDependents", err)
+	}
+	s.DeleteVaultCascade("v2")
+	if p, _ := s.GetEnrollmentPolicy("app1"); p == nil || len(p.Vaults) != 0 {
+		t.Errorf("policy with a trashed vault = %+v, want no vaults", p)
+	}
+	s.RestoreVault("v2")
+	if p, _ := s.GetEnrollmentPolicy("app1"); p == nil || len(p.Vaults) != 1 {
+		t.Errorf("policy after restoring its vault = %+v, want v2", p)
+	}
+
+	if ok, err := s.DeleteEnrollmentPolicy("app1"); err != nil || !ok {
+		t.Errorf("DeleteEnrollmentPolicy = %v, %v", ok, err)
+	}
+	if ok, _ := s.DeleteEnrollmentPolicy("app1"); ok {
+		t.Error("expected a second delete to report no policy")
+	}
+	if _, err := s.DeleteVault("v2"); err != nil {
+		t.Errorf("DeleteVault after deleting its policy: %v", err)
+	}
+}
+
+func TestEnrollInstance(t *testing.T) {
+	s := newTestStore(t)
+	s.CreateVault(&Vault{ID: "v1", Name: "V1"})
+	s.CreateVault(&Vault{ID: "v2", Name: "V2"})
+
+	inst := &TEEInstance{FID: "f1", PublicKey: []byte("pk1"), DstackAppID: "app1", Label: "web-f1"}
+	if err := s.EnrollInstance(inst, []string{"v1", "v2"}); err != nil {
+		t.Fatalf("EnrollInstance: %v", err)
+	}
+	got, err := s.GetInstance("f1")
+	if err != nil || got == nil || got.Label != "web-f1" || got.DstackAppID != "app1" {
+		t.Fatalf("GetInstance = %+v, %v", got, err)
+	}
+	for _, vaultID := range []string{"v1", "v2"} {
+		if ok, _ := s.HasVaultAccess(vaultID, "f1"); !ok {
+			t.Errorf("expected enrolled instance to have access to %s", vaultID)
+		}
+	}
+
+	if err := s.EnrollInstance(&TEEInstance{FID: "f1", PublicKey: []byte("pk9")}, nil); err != ErrInstanceDuplicateFID {
+		t.Errorf("enrolling a taken FID = %v, want ErrInstanceDuplicateFID", err)
+	}
+	// A failed grant rolls the registration back.
+	if err := s.EnrollInstance(&TEEInstance{FID: "f2", PublicKey: []byte("pk2")}, []string{"missing"}); err == nil {
+		t.Error("expected enrolling with a missing vault to fail")
+	}
+	if got, _ := s.GetInstance("f2"); got != nil {
+		t.Errorf("instance registered despite the failed grant: %+v", got)
+	}
+
+	s.DeleteInstance("f1")
+	if err := s.EnrollInstance(inst, nil); !errors.Is(err, ErrInTrash) {
+		t.Errorf("enrolling a trashed instance = %v, want ErrInTrash", err)
+	}
+}
+
+func TestEnrollmentTokens(t *testing.T) {
+	s := newTestStore(t)
+	s.CreateVault(&Vault{ID: "v1", Name: "V1"})
+	s.CreateVault(&Vault{ID: "v2", Name: "V2"})
+	expires := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+
+	if err := s.CreateEnrollmentToken(&EnrollmentToken{ID: "t0", TokenHash: "h0", DstackAppID: "app1", MaxUses: 1, ExpiresAt: expires, Vaults: []string{"missing"}}); err == nil {
+		t.Error("expected a token granting a missing vault to fail")
+	}
+	tok := &EnrollmentToken{ID: "t1", TokenHash: "h1", DstackAppID: "app1", Label: "ci-{fid}", MaxUses: 2, ExpiresAt: expires, Vaults: []string{"v2", "v1"}}
+	if err := s.CreateEnrollmentToken(tok); err != nil {
+		t.Fatalf("CreateEnrollmentToken: %v", err)
+	}
+	if got, err := s.GetEnrollmentTokenByHash("nope"); err != nil || got != nil {
+		t.Errorf("GetEnrollmentTokenByHash(unknown) = %+v, %v; want nil", got, err)
+	}
+	got, err := s.GetEnrollmentTokenByHash("h1")
+	if err != nil || got == nil || got.ID != "t1" || got.Label != "ci-{fid}" || got.MaxUses != 2 || got.Uses != 0 ||
+		!got.ExpiresAt.Equal(expires) || !reflect.DeepEqual(got.Vaults, []string{"v1", "v2"}) {
+		t.Fatalf("GetEnrollmentTokenByHash = %+v, %v", got, err)
+	}
+
+	s.CreateEnrollmentToken(&EnrollmentToken{ID: "t2", TokenHash: "h2", DstackAppID: "app2", MaxUses: 1, ExpiresAt: expires})
+	tokens, err := s.ListEnrollmentTokens()
+	if err != nil || len(tokens) != 2 || tokens[0].ID != "t1" || tokens[1].ID != "t2" || len(tokens[1].Vaults) != 0 {
+		t.Fatalf("ListEnrollmentTokens = %+v, %v", tokens, err)
+	}
+
+	// A token keeps its vault from being deleted, like a grant.
+	if _, err := s.DeleteVault("v2"); !errors.Is(err, ErrVaultHasDependents) {
+		t.Errorf("DeleteVault used by a token = %v, want ErrVaultHasDependents", err)
+	}
+
+	if ok, err := s.DeleteEnrollmentToken("t1"); err != nil || !ok {
+		t.Errorf("DeleteEnrollmentToken = %v, %v", ok, err)
+	}
+	if ok, _ := s.DeleteEnrollmentToken("t1"); ok {
+		t.Error("expected a second delete to report no token")
+	}
+	if got, _ := s.GetEnrollmentTokenByHash("h1"); got != nil {
+		t.Errorf("deleted token still found: %+v", got)
+	}
+}
+
+func TestRedeemEnrollmentToken(t *testing.T) {
+	s := newTestStore(t)
+	s.CreateVault(&Vault{ID: "v1", Name: "V1"})
+	s.CreateEnrollmentToken(&EnrollmentToken{ID: "t1", TokenHash: "h1", DstackAppID: "app1", MaxUses: 2, ExpiresAt: time.Now().Add(time.Hour), Vaults: []string{"v1"}})
+	s.CreateEnrollmentToken(&EnrollmentToken{ID: "old", TokenHash: "h2", DstackAppID: "app1", MaxUses: 1, ExpiresAt: time.Now().Add(-time.Minute)})
+
+	if err := s.RedeemEnrollmentToken("old", &TEEInstance{FID: "f0", PublicKey: []byte("pk0"), DstackAppID: "app1"}); err != ErrEnrollmentTokenSpent {
+		t.Errorf("redeeming an expired token = %v, want ErrEnrollmentTokenSpent", err)
+	}
+	if err := s.RedeemEnrollmentToken("t1", &TEEInstance{FID: "f1", PublicKey: []byte("pk1"), DstackAppID: "app1"}); err != nil {
+		t.Fatalf("RedeemEnrollmentToken: %v", err)
+	}
+	if ok, _ := s.HasVaultAccess("v1", "f1"); !ok {
+		t.Error("expected the token's vault to be granted")
+	}
+
+	// A taken key does not use up the token.
+	if err := s.RedeemEnrollmentToken("t1", &TEEInstance{FID: "f1", PublicKey: []byte("pk9"), DstackAppID: "app1"}); err != ErrInstanceDuplicateFID {
+		t.Errorf("redeeming for a taken FID = %v, want ErrInstanceDuplicateFID", err)
+	}
+	if got, _ := s.GetEnrollmentTokenByHash("h1"); got == nil || got.Uses != 1 {
+		t.Fatalf("token after one redemption = %+v, want 1 use", got)
+	}
+
+	if err := s.RedeemEnrollmentToken("t1", &TEEInstance{FID: "f2", PublicKey: []byte("pk2"), DstackAppID: "app1"}); err != nil {
+		t.Fatalf("second RedeemEnrollmentToken: %v", err)
+	}
+	if err := s.RedeemEnrollmentToken("t1", &TEEInstance{FID: "f3", PublicKey: []byte("pk3"), DstackAppID: "app1"}); err != ErrEnrollmentTokenSpent {
+		t.Errorf("redeeming a used-up token = %v, want ErrEnrollmentTokenSpent", err)
+	}
+	if got, _ := s.GetInstance("f3"); got != nil {
+		t.Errorf("instance registered with a used-up token: %+v", got)
+	}
+}
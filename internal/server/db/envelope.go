@@ -0,0 +1,197 @@
+package db
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+// Field values are protected with envelope encryption. Every row gets a fresh
+// 256-bit data key that encrypts the value with AES-256-GCM, and the data key
+// is in turn wrapped with the server master key. Both layers bind the row's
+// vault/item/section/field coordinates as associated data, so a ciphertext
+// copied into another row fails authentication.
+
+// MasterKeyLen is the required length of the server master key in bytes.
+const MasterKeyLen = 32
+
+const dataKeyLen = 32
+
+// ErrMasterKeyMismatch is returned by NewStore when the database contains
+// values wrapped with a master key that is not in the configured keyring.
+var ErrMasterKeyMismatch = errors.New("database values are encrypted with a master key that is not configured")
+
+// sealer encrypts and decrypts field values under a single master key.
+type sealer struct {
+	masterKey []byte
+	keyID     string
+}
+
+func newSealer(masterKey []byte) (*sealer, error) {
+	if len(masterKey) != MasterKeyLen {
+		return nil, fmt.Errorf("master key must be %d bytes, got %d", MasterKeyLen, len(masterKey))
+	}
+	key := make([]byte, MasterKeyLen)
+	copy(key, masterKey)
+	return &sealer{masterKey: key, keyID: MasterKeyID(key)}, nil
+}
+
+// MasterKeyID returns a short, non-secret identifier for a master key. It is
+// stored next to each wrapped data key so the store can tell which master key
+// a row was encrypted with.
+func MasterKeyID(masterKey []byte) string {
+	mac := hmac.New(sha256.New, masterKey)
+	mac.Write([]byte("jingui master key id"))
+	return hex.EncodeToString(mac.Sum(nil)[:8])
+}
+
+// seal encrypts plaintext under a fresh data key and wraps the data key with
+// the master key.
+func (s *sealer) seal(aad []byte, plaintext string) (ciphertext, wrappedKey []byte, err error) {
+	dataKey := make([]byte, dataKeyLen)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, nil, fmt.Errorf("generate data key: %w", err)
+	}
+	ciphertext, err = gcmSeal(dataKey, []byte(plaintext), aad)
+	if err != nil {
+		return nil, nil, fmt.Errorf("encrypt value: %w", err)
+	}
+	wrappedKey, err = s.wrap(aad, dataKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	return ciphertext, wrappedKey, nil
+}
+
+// open unwraps the data key and decrypts the value.
+func (s *sealer) open(aad, ciphertext, wrappedKey []byte) (string, error) {
+	dataKey, err := s.unwrap(aad, wrappedKey)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := gcmOpen(dataKey, ciphertext, aad)
+	if err != nil {
+		return "", fmt.Errorf("decrypt value: %w", err)
+	}
+	return string(plaintext), nil
+}
+
+func (s *sealer) wrap(aad, dataKey []byte) ([]byte, error) {
+	wrapped, err := gcmSeal(s.masterKey, dataKey, aad)
+	if err != nil {
+		return nil, fmt.Errorf("wrap data key: %w", err)
+	}
+	return wrapped, nil
+}
+
+func (s *sealer) unwrap(aad, wrappedKey []byte) ([]byte, error) {
+	dataKey, err := gcmOpen(s.masterKey, wrappedKey, aad)
+	if err != nil {
+		return nil, fmt.Errorf("unwrap data key: %w", err)
+	}
+	return dataKey, nil
+}
+
+// keyring holds the active master key, used for all new writes, plus any
+// previous master keys that may still wrap data keys in the database.
+type keyring struct {
+	active *sealer
+	byID   map[string]*sealer
+}
+
+func newKeyring(active []byte, previous ...[]byte) (*keyring, error) {
+	a, err := newSealer(active)
+	if err != nil {
+		return nil, fmt.Errorf("active master key: %w", err)
+	}
+	k := &keyring{active: a, byID: map[string]*sealer{a.keyID: a}}
+	for i, p := range previous {
+		sl, err := newSealer(p)
+		if err != nil {
+			return nil, fmt.Errorf("previous master key %d: %w", i+1, err)
+		}
+		if _, dup := k.byID[sl.keyID]; !dup {
+			k.byID[sl.keyID] = sl
+		}
+	}
+	return k, nil
+}
+
+// lookup returns the sealer for keyID or ErrMasterKeyMismatch.
+func (k *keyring) lookup(keyID string) (*sealer, error) {
+	sl, ok := k.byID[keyID]
+	if !ok {
+		return nil, fmt.Errorf("%w: key_id %s", ErrMasterKeyMismatch, keyID)
+	}
+	return sl, nil
+}
+
+// open decrypts a value wrapped by the master key identified by keyID.
+func (k *keyring) open(keyID string, aad, ciphertext, wrappedKey []byte) (string, error) {
+	sl, err := k.lookup(keyID)
+	if err != nil {
+		return "", err
+	}
+	return sl.open(aad, ciphertext, wrappedKey)
+}
+
+// fieldAAD encodes the coordinates of a field as length-prefixed components
+// so that ("a/b", "c") and ("a", "b/c") never collide. The section is only
+// appended when non-empty, which keeps values written before items had
+// sections decryptable without re-encrypting them.
+func fieldAAD(vaultID, item, section, field string) []byte {
+	parts := []string{vaultID, item, field}
+	if section != "" {
+		parts = append(parts, section)
+	}
+	var out []byte
+	for _, part := range parts {
+		out = binary.BigEndian.AppendUint32(out, uint32(len(part)))
+		out = append(out, part...)
+	}
+	return out
+}
+
+// gcmSeal encrypts with AES-256-GCM. Output format: nonce(12) || ciphertext+tag
+func gcmSeal(key, plaintext, aad []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize(), gcm.NonceSize()+len(plaintext)+gcm.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("generate nonce: %w", err)
+	}
+	return gcm.Seal(nonce, nonce, plaintext, aad), nil
+}
+
+// gcmOpen decrypts the output of gcmSeal.
+func gcmOpen(key, data, aad []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < gcm.NonceSize()+gcm.Overhead() {
+		return nil, errors.New("ciphertext too short")
+	}
+	nonce, ct := data[:gcm.NonceSize()], data[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ct, aad)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("create AES cipher: %w", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("create GCM: %w", err)
+	}
+	return gcm, nil
+}
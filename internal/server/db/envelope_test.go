@@ -0,0 +1,167 @@
+package db
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValuesEncryptedAtRest(t *testing.T) {
+	s := newSQLTestStore(t)
+	s.CreateVault(&Vault{ID: "v1", Name: "V1"})
+
+	if err := s.UpsertField("v1", "alice", "", "token", "super-secret-token"); err != nil {
+		t.Fatalf("UpsertField: %v", err)
+	}
+
+	var ct, wk []byte
+	var keyID string
+	if err := s.db.QueryRow(
+		`SELECT ciphertext, wrapped_key, key_id FROM vault_items WHERE vault_id = 'v1'`,
+	).Scan(&ct, &wk, &keyID); err != nil {
+		t.Fatalf("select raw row: %v", err)
+	}
+	if bytes.Contains(ct, []byte("super-secret-token")) {
+		t.Fatal("ciphertext contains the plaintext value")
+	}
+	if len(wk) == 0 {
+		t.Fatal("expected a wrapped data key")
+	}
+	if keyID != MasterKeyID(testMasterKey) {
+		t.Errorf("key_id = %q, want %q", keyID, MasterKeyID(testMasterKey))
+	}
+
+	val, err := s.GetFieldValue("v1", "alice", "", "token")
+	if err != nil {
+		t.Fatalf("GetFieldValue: %v", err)
+	}
+	if val != "super-secret-token" {
+		t.Errorf("expected super-secret-token, got %q", val)
+	}
+}
+
+func TestEncryptedValueBoundToRow(t *testing.T) {
+	s := newSQLTestStore(t)
+	s.CreateVault(&Vault{ID: "v1", Name: "V1"})
+	s.SetItemFields("v1", "alice", "", map[string]string{"a": "value-a", "b": "value-b"})
+
+	// Copy row a's ciphertext into row b: AAD must make this undecryptable.
+	if _, err := s.db.Exec(
+		`UPDATE vault_items SET
+		   ciphertext = (SELECT ciphertext FROM vault_items WHERE field_name = 'a'),
+		   wrapped_key = (SELECT wrapped_key FROM vault_items WHERE field_name = 'a')
+		 WHERE field_name = 'b'`,
+	); err != nil {
+		t.Fatalf("swap ciphertext: %v", err)
+	}
+
+	if _, err := s.GetFieldValue("v1", "alice", "", "b"); err == nil {
+		t.Fatal("expected decryption failure for ciphertext moved between rows")
+	}
+}
+
+func TestUpgradeEncryptsPlaintextValues(t *testing.T) {
+	requireSQLite(t)
+	path := filepath.Join(t.TempDir(), "legacy.db")
+
+	legacy, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatalf("open legacy db: %v", err)
+	}
+	for _, stmt := range []string{
+		`CREATE TABLE vaults (
+			id TEXT PRIMARY KEY,
+			name TEXT NOT NULL,
+			created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE vault_items (
+			rowid INTEGER PRIMARY KEY AUTOINCREMENT,
+			vault_id TEXT NOT NULL,
+			item_name TEXT NOT NULL,
+			section TEXT NOT NULL DEFAULT '',
+			value TEXT NOT NULL DEFAULT '',
+			created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE(vault_id, section, item_name),
+			FOREIGN KEY (vault_id) REFERENCES vaults(id)
+		)`,
+		`INSERT INTO vaults (id, name) VALUES ('v1', 'V1')`,
+		`INSERT INTO vault_items (vault_id, section, item_name, value) VALUES ('v1', 'alice', 'token', 'legacy-plaintext')`,
+		// Spills into more overflow pages than later migrations reuse once
+		// DROP COLUMN frees them.
+		`INSERT INTO vault_items (vault_id, section, item_name, value) VALUES ('v1', 'alice', 'cert', '` + strings.Repeat("legacy-overflow ", 20000) + `')`,
+	} {
+		if _, err := legacy.Exec(stmt); err != nil {
+			t.Fatalf("seed legacy db: %v", err)
+		}
+	}
+	legacy.Close()
+
+	s, err := NewStore(path, testMasterKey)
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+	defer s.Close()
+
+	has, err := columnExists(s.db, "vault_items", "value")
+	if err != nil {
+		t.Fatalf("columnExists: %v", err)
+	}
+	if has {
+		t.Fatal("plaintext value column should be dropped after upgrade")
+	}
+
+	val, err := s.GetFieldValue("v1", "alice", "", "token")
+	if err != nil {
+		t.Fatalf("GetFieldValue: %v", err)
+	}
+	if val != "legacy-plaintext" {
+		t.Errorf("expected legacy-plaintext, got %q", val)
+	}
+
+	versions, err := s.ListFieldVersions("v1", "alice", "", "token")
+	if err != nil {
+		t.Fatalf("ListFieldVersions: %v", err)
+	}
+	if len(versions) != 1 || versions[0].Version != 1 || !versions[0].Current {
+		t.Errorf("expected existing value backfilled as current version 1, got %+v", versions)
+	}
+
+	// Dropping the column must not leave the plaintext in free pages or the WAL.
+	s.Close()
+	for _, name := range []string{path, path + "-wal"} {
+		data, err := os.ReadFile(name)
+		if err != nil && !os.IsNotExist(err) {
+			t.Fatalf("read %s: %v", name, err)
+		}
+		if bytes.Contains(data, []byte("legacy-plaintext")) || bytes.Contains(data, []byte("legacy-overflow")) {
+			t.Errorf("%s still contains the plaintext value", filepath.Base(name))
+		}
+	}
+}
+
+func TestNewStore_RejectsDifferentMasterKey(t *testing.T) {
+	openStore := newTestDatabase(t)
+
+	s, err := openStore(testMasterKey)
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+	s.CreateVault(&Vault{ID: "v1", Name: "V1"})
+	s.UpsertField("v1", "alice", "", "token", "secret")
+	s.Close()
+
+	otherKey := bytes.Repeat([]byte{0x24}, MasterKeyLen)
+	_, err = openStore(otherKey)
+	if !errors.Is(err, ErrMasterKeyMismatch) {
+		t.Fatalf("expected ErrMasterKeyMismatch, got: %v", err)
+	}
+
+	if _, err := openStore([]byte("short")); err == nil {
+		t.Fatal("expected error for short master key")
+	}
+}
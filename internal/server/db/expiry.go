@@ -0,0 +1,146 @@
+package db
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Fields can carry an expiry date, set on the field itself or on its whole
+// section (stored with an empty field_name). A field expires at the earlier
+// of the two. Expiry rows live and die with the fields they cover: they go to
+// the trash and come back with them, and are removed when the fields are
+// deleted for good.
+
+// SetExpiry sets the expiry of a field, or of every field in a section when
+// field is empty. Returns false if no such field or section exists.
+func (s *SQLStore) SetExpiry(vaultID, item, section, field string, expiresAt time.Time) (bool, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return false, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	var count int
+	if err := tx.QueryRow(
+		`SELECT COUNT(*) FROM vault_items
+		 WHERE vault_id = ? AND item = ? AND section = ? AND (field_name = ? OR ? = '') AND `+liveField,
+		vaultID, item, section, field, field,
+	).Scan(&count); err != nil {
+		return false, fmt.Errorf("check field: %w", err)
+	}
+	if count == 0 {
+		return false, nil
+	}
+
+	if _, err := tx.Exec(
+		`INSERT INTO field_expiries (vault_id, item, section, field_name, expires_at)
+		 VALUES (?, ?, ?, ?, ?)
+		 ON CONFLICT(vault_id, item, section, field_name) DO UPDATE SET
+		   expires_at = excluded.expires_at`,
+		vaultID, item, section, field, s.dialect.timestamp(expiresAt),
+	); err != nil {
+		return false, fmt.Errorf("set expiry: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("commit tx: %w", err)
+	}
+	return true, nil
+}
+
+// ClearExpiry removes the expiry set on a field, or on a section when field
+// is empty. Returns true if an expiry was removed.
+func (s *SQLStore) ClearExpiry(vaultID, item, section, field string) (bool, error) {
+	res, err := s.db.Exec(
+		`DELETE FROM field_expiries WHERE vault_id = ? AND item = ? AND section = ? AND field_name = ?`,
+		vaultID, item, section, field,
+	)
+	if err != nil {
+		return false, fmt.Errorf("clear expiry: %w", err)
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// GetFieldExpiry returns when a field expires, taking its section's expiry
+// into account, or nil if it never does or is in the trash.
+func (s *SQLStore) GetFieldExpiry(vaultID, item, section, field string) (*time.Time, error) {
+	rows, err := s.db.Query(
+		`SELECT expires_at FROM field_expiries
+		 WHERE vault_id = ? AND item = ? AND section = ? AND (field_name = ? OR field_name = '')
+		   AND NOT `+inTrashedSection("field_expiries")+` AND `+liveVaultID,
+		vaultID, item, section, field,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("get field expiry: %w", err)
+	}
+	defer rows.Close()
+
+	var earliest *time.Time
+	for rows.Next() {
+		var t time.Time
+		if err := rows.Scan(&t); err != nil {
+			return nil, fmt.Errorf("scan field expiry: %w", err)
+		}
+		if earliest == nil || t.Before(*earliest) {
+			earliest = &t
+		}
+	}
+	return earliest, rows.Err()
+}
+
+// ListExpiringFields returns the fields of a vault that expire at or before
+// the given time, including those already expired, soonest first.
+func (s *SQLStore) ListExpiringFields(vaultID string, before time.Time) ([]FieldExpiry, error) {
+	rows, err := s.db.Query(
+		`SELECT i.item, i.section, i.field_name, e.expires_at
+		 FROM vault_items i
+		 JOIN field_expiries e
+		   ON e.vault_id = i.vault_id AND e.item = i.item AND e.section = i.section
+		  AND (e.field_name = i.field_name OR e.field_name = '')
+		 WHERE i.vault_id = ? AND i.deleted_at IS NULL
+		   AND i.vault_id IN (SELECT id FROM vaults WHERE deleted_at IS NULL)`,
+		vaultID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("list expiring fields: %w", err)
+	}
+	defer rows.Close()
+
+	earliest := map[fieldKey]time.Time{}
+	for rows.Next() {
+		k := fieldKey{vaultID: vaultID}
+		var t time.Time
+		if err := rows.Scan(&k.item, &k.section, &k.field, &t); err != nil {
+			return nil, fmt.Errorf("scan field expiry: %w", err)
+		}
+		if prev, ok := earliest[k]; !ok || t.Before(prev) {
+			earliest[k] = t
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return expiringFields(earliest, before), nil
+}
+
+// expiringFields turns the effective expiry of each field into a list of the
+// ones due at or before the given time, soonest first.
+func expiringFields(earliest map[fieldKey]time.Time, before time.Time) []FieldExpiry {
+	var out []FieldExpiry
+	for k, t := range earliest {
+		if t.After(before) {
+			continue
+		}
+		out = append(out, FieldExpiry{VaultID: k.vaultID, Item: k.item, Section: k.section, FieldName: k.field, ExpiresAt: t})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if !out[i].ExpiresAt.Equal(out[j].ExpiresAt) {
+			return out[i].ExpiresAt.Before(out[j].ExpiresAt)
+		}
+		a := fieldKey{out[i].VaultID, out[i].Item, out[i].Section, out[i].FieldName}
+		return a.less(fieldKey{out[j].VaultID, out[j].Item, out[j].Section, out[j].FieldName})
+	})
+	return out
+}
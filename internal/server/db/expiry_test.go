@@ -0,0 +1,97 @@
+package db
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFieldExpiry(t *testing.T) {
+	s := newTestStore(t)
+	s.CreateVault(&Vault{ID: "v1", Name: "V1"})
+	s.UpsertField("v1", "stripe", "", "api_key", "sk_live_1")
+	s.UpsertField("v1", "stripe", "", "webhook", "whsec_1")
+	s.UpsertField("v1", "stripe", "test", "api_key", "sk_test_1")
+
+	fieldAt := time.Date(2030, 6, 1, 0, 0, 0, 0, time.UTC)
+	sectionAt := time.Date(2030, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	if ok, err := s.SetExpiry("v1", "stripe", "", "missing", fieldAt); err != nil || ok {
+		t.Errorf("SetExpiry on a missing field = %v, %v; want false", ok, err)
+	}
+	if ok, err := s.SetExpiry("v1", "stripe", "missing", "", fieldAt); err != nil || ok {
+		t.Errorf("SetExpiry on a missing section = %v, %v; want false", ok, err)
+	}
+	if exp, err := s.GetFieldExpiry("v1", "stripe", "", "api_key"); err != nil || exp != nil {
+		t.Errorf("GetFieldExpiry before any expiry = %v, %v; want nil", exp, err)
+	}
+
+	if ok, err := s.SetExpiry("v1", "stripe", "", "api_key", fieldAt); err != nil || !ok {
+		t.Fatalf("SetExpiry(field) = %v, %v", ok, err)
+	}
+	if exp, _ := s.GetFieldExpiry("v1", "stripe", "", "api_key"); exp == nil || !exp.Equal(fieldAt) {
+		t.Errorf("field expiry = %v, want %v", exp, fieldAt)
+	}
+	if exp, _ := s.GetFieldExpiry("v1", "stripe", "", "webhook"); exp != nil {
+		t.Errorf("webhook expiry = %v, want none", exp)
+	}
+
+	// A section expiry covers every field in it; the earlier date wins.
+	if ok, err := s.SetExpiry("v1", "stripe", "", "", sectionAt); err != nil || !ok {
+		t.Fatalf("SetExpiry(section) = %v, %v", ok, err)
+	}
+	for _, field := range []string{"api_key", "webhook"} {
+		if exp, _ := s.GetFieldExpiry("v1", "stripe", "", field); exp == nil || !exp.Equal(sectionAt) {
+			t.Errorf("%s expiry = %v, want section expiry %v", field, exp, sectionAt)
+		}
+	}
+	if exp, _ := s.GetFieldExpiry("v1", "stripe", "test", "api_key"); exp != nil {
+		t.Errorf("other section expiry = %v, want none", exp)
+	}
+
+	expiring, err := s.ListExpiringFields("v1", sectionAt)
+	if err != nil {
+		t.Fatalf("ListExpiringFields: %v", err)
+	}
+	if len(expiring) != 2 || expiring[0].FieldName != "api_key" || expiring[1].FieldName != "webhook" || !expiring[0].ExpiresAt.Equal(sectionAt) {
+		t.Errorf("ListExpiringFields = %+v, want api_key and webhook at %v", expiring, sectionAt)
+	}
+	if expiring, _ := s.ListExpiringFields("v1", sectionAt.Add(-time.Hour)); len(expiring) != 0 {
+		t.Errorf("ListExpiringFields before any expiry = %+v, want none", expiring)
+	}
+
+	if ok, err := s.ClearExpiry("v1", "stripe", "", ""); err != nil || !ok {
+		t.Fatalf("ClearExpiry(section) = %v, %v", ok, err)
+	}
+	if ok, _ := s.ClearExpiry("v1", "stripe", "", ""); ok {
+		t.Error("expected a second ClearExpiry to report nothing removed")
+	}
+	if exp, _ := s.GetFieldExpiry("v1", "stripe", "", "api_key"); exp == nil || !exp.Equal(fieldAt) {
+		t.Errorf("field expiry after clearing the section = %v, want %v", exp, fieldAt)
+	}
+
+	// Expiries go with the fields they cover, into the trash and out of it.
+	s.SetExpiry("v1", "stripe", "test", "", sectionAt)
+	s.DeleteSection("v1", "stripe", "test")
+	if expiring, _ := s.ListExpiringFields("v1", sectionAt); len(expiring) != 0 {
+		t.Errorf("ListExpiringFields with the section in the trash = %+v, want none", expiring)
+	}
+	s.RestoreSection("v1", "stripe", "test")
+	if exp, _ := s.GetFieldExpiry("v1", "stripe", "test", "api_key"); exp == nil || !exp.Equal(sectionAt) {
+		t.Errorf("restored section expiry = %v, want %v", exp, sectionAt)
+	}
+	s.DeleteField("v1", "stripe", "", "api_key")
+	s.DeleteSection("v1", "stripe", "test")
+	s.PurgeSection("v1", "stripe", "test")
+	s.UpsertField("v1", "stripe", "", "api_key", "sk_live_2")
+	s.UpsertField("v1", "stripe", "test", "api_key", "sk_test_2")
+	for _, section := range []string{"", "test"} {
+		if exp, _ := s.GetFieldExpiry("v1", "stripe", section, "api_key"); exp != nil {
+			t.Errorf("recreated field in section %q inherited expiry %v", section, exp)
+		}
+	}
+
+	s.SetExpiry("v1", "stripe", "", "api_key", fieldAt)
+	if ok, err := s.DeleteVaultCascade("v1"); err != nil || !ok {
+		t.Fatalf("DeleteVaultCascade = %v, %v", ok, err)
+	}
+}
@@ -0,0 +1,170 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Field grants narrow or widen what an instance may read in a vault. Each
+// grant matches fields by glob patterns on item, section and field name and
+// either allows or denies them. For a given field the most specific matching
+// grant decides: patterns are compared item first, then section, then field,
+// a literal name beating a partial glob beating a bare "*". A deny wins a tie.
+// When no grant matches, the vault-wide grant decides: one in
+// vault_instance_access for the instance, or one in vault_app_access for the
+// dstack app its attestation proved. Existing whole-vault grants thus keep
+// working and a deny grant can carve fields out of them. Like vault-wide
+// grants, field grants are void while the vault or the instance is in the
+// trash.
+
+// ErrInvalidGrant is returned when a field grant has a malformed pattern or
+// an unknown effect.
+var ErrInvalidGrant = errors.New("invalid field grant")
+
+// ValidateFieldGrant checks the patterns and effect of g.
+func ValidateFieldGrant(g *FieldGrant) error {
+	if g.Effect != GrantAllow && g.Effect != GrantDeny {
+		return fmt.Errorf("%w: effect must be %q or %q", ErrInvalidGrant, GrantAllow, GrantDeny)
+	}
+	for _, p := range []struct{ what, pattern string }{
+		{"item", g.Item}, {"section", g.Section}, {"field", g.FieldName},
+	} {
+		if p.pattern == "" && p.what != "section" {
+			return fmt.Errorf("%w: %s pattern is required", ErrInvalidGrant, p.what)
+		}
+		if strings.Contains(p.pattern, "/") {
+			return fmt.Errorf("%w: %s pattern %q contains a slash", ErrInvalidGrant, p.what, p.pattern)
+		}
+		if _, err := path.Match(p.pattern, ""); err != nil {
+			return fmt.Errorf("%w: %s pattern %q is malformed", ErrInvalidGrant, p.what, p.pattern)
+		}
+	}
+	return nil
+}
+
+// patternRank orders patterns by how much they single out: a literal name,
+// then a partial glob, then "*".
+func patternRank(pattern string) int {
+	switch {
+	case pattern == "*":
+		return 0
+	case strings.ContainsAny(pattern, `*?[\`):
+		return 1
+	}
+	return 2
+}
+
+// specificity ranks g so that comparing item patterns first, then section,
+// then field is a plain integer comparison.
+func (g *FieldGrant) specificity() int {
+	return patternRank(g.Item)*9 + patternRank(g.Section)*3 + patternRank(g.FieldName)
+}
+
+// matches reports whether g covers the field.
+func (g *FieldGrant) matches(item, section, field string) bool {
+	for _, m := range [][2]string{{g.Item, item}, {g.Section, section}, {g.FieldName, field}} {
+		if ok, _ := path.Match(m[0], m[1]); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// decideFieldAccess applies grants, all for one vault and instance, to a
+// field; vaultWide is whether the instance holds a vault-wide grant.
+func decideFieldAccess(grants []FieldGrant, vaultWide bool, item, section, field string) bool {
+	best, allow := -1, vaultWide
+	for i := range grants {
+		g := &grants[i]
+		if !g.matches(item, section, field) {
+			continue
+		}
+		if rank := g.specificity(); rank > best || (rank == best && g.Effect == GrantDeny) {
+			best, allow = rank, g.Effect == GrantAllow
+		}
+	}
+	return allow
+}
+
+// PutFieldGrant creates a field grant or changes the effect of an existing
+// one with the same patterns.
+func (s *SQLStore) PutFieldGrant(g *FieldGrant) error {
+	if err := ValidateFieldGrant(g); err != nil {
+		return err
+	}
+	if _, err := s.db.Exec(
+		`INSERT INTO field_grants (vault_id, fid, item, section, field_name, effect)
+		 VALUES (?, ?, ?, ?, ?, ?)
+		 ON CONFLICT(vault_id, fid, item, section, field_name) DO UPDATE SET
+		   effect = excluded.effect`,
+		g.VaultID, g.FID, g.Item, g.Section, g.FieldName, g.Effect,
+	); err != nil {
+		return fmt.Errorf("put field grant: %w", err)
+	}
+	return nil
+}
+
+// DeleteFieldGrant removes the field grant with exactly these patterns.
+func (s *SQLStore) DeleteFieldGrant(vaultID, fid, item, section, field string) (bool, error) {
+	res, err := s.db.Exec(
+		`DELETE FROM field_grants WHERE vault_id = ? AND fid = ? AND item = ? AND section = ? AND field_name = ?`,
+		vaultID, fid, item, section, field,
+	)
+	if err != nil {
+		return false, fmt.Errorf("delete field grant: %w", err)
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// ListFieldGrants returns the field grants on a vault, for one instance or,
+// with an empty fid, for all of them. Nothing is listed while the vault is in
+// the trash, nor for instances in the trash.
+func (s *SQLStore) ListFieldGrants(vaultID, fid string) ([]FieldGrant, error) {
+	rows, err := s.db.Query(
+		`SELECT vault_id, fid, item, section, field_name, effect, created_at
+		 FROM field_grants
+		 WHERE vault_id = ? AND (fid = ? OR ? = '')
+		   AND `+liveVaultID+`
+		   AND fid IN (SELECT fid FROM tee_instances WHERE deleted_at IS NULL)
+		 ORDER BY fid, item, section, field_name`,
+		vaultID, fid, fid,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("list field grants: %w", err)
+	}
+	defer rows.Close()
+
+	var grants []FieldGrant
+	for rows.Next() {
+		var g FieldGrant
+		if err := rows.Scan(&g.VaultID, &g.FID, &g.Item, &g.Section, &g.FieldName, &g.Effect, &g.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan field grant: %w", err)
+		}
+		grants = append(grants, g)
+	}
+	return grants, rows.Err()
+}
+
+// HasFieldAccess checks if an instance may read a field, applying its field
+// grants on top of its vault-wide grant. appID is the dstack app ID verified
+// by the instance's attestation, or empty when none was; a grant to that app
+// counts as a vault-wide grant.
+func (s *SQLStore) HasFieldAccess(vaultID, fid, appID, item, section, field string) (bool, error) {
+	vaultWide, err := s.HasVaultAccess(vaultID, fid)
+	if err != nil {
+		return false, err
+	}
+	if !vaultWide && appID != "" {
+		if vaultWide, err = s.hasAppAccess(vaultID, fid, appID); err != nil {
+			return false, err
+		}
+	}
+	grants, err := s.ListFieldGrants(vaultID, fid)
+	if err != nil {
+		return false, err
+	}
+	return decideFieldAccess(grants, vaultWide, item, section, field), nil
+}
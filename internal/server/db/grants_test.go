@@ -0,0 +1,186 @@
+package db
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestFieldGrants(t *testing.T) {
+	s := newTestStore(t)
+	s.CreateVault(&Vault{ID: "v1", Name: "V1"})
+	s.RegisterInstance(&TEEInstance{FID: "f1", PublicKey: []byte("pk1")})
+	s.RegisterInstance(&TEEInstance{FID: "f2", PublicKey: []byte("pk2")})
+
+	put := func(fid, item, section, field, effect string) {
+		t.Helper()
+		if err := s.PutFieldGrant(&FieldGrant{VaultID: "v1", FID: fid, Item: item, Section: section, FieldName: field, Effect: effect}); err != nil {
+			t.Fatalf("PutFieldGrant(%s %s/%s/%s %s): %v", fid, item, section, field, effect, err)
+		}
+	}
+	check := func(fid, item, section, field string, want bool) {
+		t.Helper()
+		got, err := s.HasFieldAccess("v1", fid, "", item, section, field)
+		if err != nil {
+			t.Fatalf("HasFieldAccess: %v", err)
+		}
+		if got != want {
+			t.Errorf("HasFieldAccess(%s, %s/%s/%s) = %v, want %v", fid, item, section, field, got, want)
+		}
+	}
+
+	check("f1", "db", "", "password", false)
+
+	// Allow a whole section, then carve a field out of it.
+	put("f1", "db", "prod", "*", GrantAllow)
+	put("f1", "db", "prod", "root_*", GrantDeny)
+	check("f1", "db", "prod", "password", true)
+	check("f1", "db", "prod", "root_password", false)
+	check("f1", "db", "staging", "password", false)
+	check("f1", "db", "", "password", false)
+	check("f2", "db", "prod", "password", false)
+
+	// A literal field beats a glob; a literal item beats any pattern below it.
+	put("f1", "db", "prod", "root_token", GrantAllow)
+	check("f1", "db", "prod", "root_token", true)
+	put("f1", "d*", "*", "*", GrantDeny)
+	check("f1", "db", "prod", "password", true)
+	check("f1", "dns", "", "token", false)
+
+	put("f1", "api", "*", "key", GrantAllow)
+	put("f1", "api", "*", "ke?", GrantDeny)
+	check("f1", "api", "", "key", true)
+	put("f1", "api", "*", "*", GrantAllow)
+	put("f1", "api", "*", "k*", GrantDeny)
+	check("f1", "api", "", "kid", false)
+	check("f1", "api", "", "secret", true)
+
+	// Equally specific grants: deny wins.
+	put("f1", "api", "*", "k?d", GrantAllow)
+	check("f1", "api", "", "kid", false)
+
+	// Putting the same patterns again changes the effect.
+	put("f1", "db", "prod", "root_*", GrantAllow)
+	check("f1", "db", "prod", "root_password", true)
+
+	// Without a matching field grant the vault-wide grant decides, and field
+	// grants can take fields away from it.
+	s.GrantVaultAccess("v1", "f2", nil, nil)
+	check("f2", "db", "prod", "password", true)
+	put("f2", "db", "*", "*", GrantDeny)
+	check("f2", "db", "prod", "password", false)
+	check("f2", "api", "", "key", true)
+
+	grants, err := s.ListFieldGrants("v1", "f2")
+	if err != nil || len(grants) != 1 || grants[0].Item != "db" || grants[0].Effect != GrantDeny || grants[0].CreatedAt.IsZero() {
+		t.Errorf("ListFieldGrants(f2) = %+v, %v; want the db deny grant", grants, err)
+	}
+	if all, _ := s.ListFieldGrants("v1", ""); len(all) != 10 || all[0].FID != "f1" || all[len(all)-1].FID != "f2" {
+		t.Errorf("ListFieldGrants(all) = %+v, want 10 grants ordered by fid", all)
+	}
+
+	for _, bad := range []FieldGrant{
+		{VaultID: "v1", FID: "f1", Item: "db", FieldName: "*", Effect: "maybe"},
+		{VaultID: "v1", FID: "f1", Item: "", FieldName: "*", Effect: GrantAllow},
+		{VaultID: "v1", FID: "f1", Item: "db", FieldName: "", Effect: GrantAllow},
+		{VaultID: "v1", FID: "f1", Item: "db/prod", FieldName: "*", Effect: GrantAllow},
+		{VaultID: "v1", FID: "f1", Item: "db", FieldName: "[a-", Effect: GrantAllow},
+	} {
+		if err := s.PutFieldGrant(&bad); !errors.Is(err, ErrInvalidGrant) {
+			t.Errorf("PutFieldGrant(%+v) = %v, want ErrInvalidGrant", bad, err)
+		}
+	}
+
+	if ok, err := s.DeleteFieldGrant("v1", "f2", "db", "*", "*"); err != nil || !ok {
+		t.Fatalf("DeleteFieldGrant = %v, %v", ok, err)
+	}
+	if ok, _ := s.DeleteFieldGrant("v1", "f2", "db", "*", "*"); ok {
+		t.Error("expected a second DeleteFieldGrant to report nothing removed")
+	}
+	check("f2", "db", "prod", "password", true)
+
+	// Grants are void while the instance is in the trash and come back with it.
+	s.DeleteInstance("f1")
+	check("f1", "db", "prod", "password", false)
+	if grants, _ := s.ListFieldGrants("v1", ""); len(grants) != 0 {
+		t.Errorf("ListFieldGrants with f1 in the trash = %+v, want none", grants)
+	}
+	s.RestoreInstance("f1")
+	check("f1", "db", "prod", "password", true)
+
+	// Field grants keep a vault from being deleted without cascade, and are
+	// purged with it.
+	s.RevokeVaultAccess("v1", "f2")
+	s.DeleteInstance("f2")
+	s.PurgeInstance("f2")
+	if _, err := s.DeleteVault("v1"); !errors.Is(err, ErrVaultHasDependents) {
+		t.Errorf("DeleteVault with field grants = %v, want ErrVaultHasDependents", err)
+	}
+	s.DeleteVaultCascade("v1")
+	check("f1", "db", "prod", "password", false)
+	if ok, err := s.PurgeVault("v1"); err != nil || !ok {
+		t.Fatalf("PurgeVault = %v, %v", ok, err)
+	}
+	s.CreateVault(&Vault{ID: "v1", Name: "V1"})
+	check("f1", "db", "prod", "password", false)
+}
+
+func TestGrantWindows(t *testing.T) {
+	s := newTestStore(t)
+	s.CreateVault(&Vault{ID: "v1", Name: "V1"})
+	s.RegisterInstance(&TEEInstance{FID: "f1", PublicKey: []byte("pk1")})
+
+	has := func() bool {
+		t.Helper()
+		ok, err := s.HasVaultAccess("v1", "f1")
+		if err != nil {
+			t.Fatalf("HasVaultAccess: %v", err)
+		}
+		return ok
+	}
+	status := func() string {
+		t.Helper()
+		instances, err := s.ListVaultInstances("v1")
+		if err != nil || len(instances) != 1 {
+			t.Fatalf("ListVaultInstances = %+v, %v; want one instance", instances, err)
+		}
+		return instances[0].Grant.State(time.Now())
+	}
+
+	now := time.Now()
+	hourAgo, inAnHour := now.Add(-time.Hour), now.Add(time.Hour)
+
+	if err := s.GrantVaultAccess("v1", "f1", &inAnHour, nil); err != nil {
+		t.Fatalf("GrantVaultAccess: %v", err)
+	}
+	if has() || status() != GrantPending {
+		t.Errorf("grant starting in an hour: access %v, status %q", has(), status())
+	}
+
+	s.GrantVaultAccess("v1", "f1", &hourAgo, &inAnHour)
+	if !has() || status() != GrantActive {
+		t.Errorf("grant inside its window: access %v, status %q", has(), status())
+	}
+
+	// Expired grants stay listed but stop working, field grants included.
+	s.GrantVaultAccess("v1", "f1", nil, &hourAgo)
+	if has() || status() != GrantExpired {
+		t.Errorf("expired grant: access %v, status %q", has(), status())
+	}
+	if ok, _ := s.HasFieldAccess("v1", "f1", "", "db", "", "password"); ok {
+		t.Error("HasFieldAccess should not fall back to an expired vault grant")
+	}
+	instances, _ := s.ListVaultInstances("v1")
+	if exp := instances[0].Grant.ExpiresAt; exp == nil || !exp.Equal(hourAgo.Truncate(time.Second)) {
+		t.Errorf("ExpiresAt = %v, want %v", exp, hourAgo)
+	}
+
+	// Granting again replaces the window.
+	s.GrantVaultAccess("v1", "f1", nil, nil)
+	if !has() || status() != GrantActive {
+		t.Errorf("permanent grant: access %v, status %q", has(), status())
+	}
+	if instances, _ := s.ListVaultInstances("v1"); instances[0].Grant.ExpiresAt != nil {
+		t.Errorf("re-grant kept ExpiresAt %v", instances[0].Grant.ExpiresAt)
+	}
+}
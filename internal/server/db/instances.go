@@ -4,46 +4,78 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
-
-	"modernc.org/sqlite"
-	sqlite3 "modernc.org/sqlite/lib"
+	"time"
 )
 
 // Sentinel errors for RegisterInstance.
 var (
 	ErrInstanceDuplicateFID = errors.New("instance with this FID already exists")
 	ErrInstanceDuplicateKey = errors.New("instance with this public key already exists")
+	// ErrInstanceRetired is returned for the FID of a key an instance was
+	// re-keyed away from; the key must not come back as an instance.
+	ErrInstanceRetired = errors.New("FID belongs to a key replaced by a re-key")
 )
 
-// RegisterInstance inserts a new TEE instance.
-func (s *Store) RegisterInstance(inst *TEEInstance) error {
-	_, err := s.db.Exec(
+// RegisterInstance inserts a new TEE instance. A FID or public key held by an
+// instance in the trash is reported as ErrInTrash, and the FID of a replaced
+// key as ErrInstanceRetired.
+func (s *SQLStore) RegisterInstance(inst *TEEInstance) error {
+	if err := insertInstance(s.db, inst); err != nil {
+		return s.registerError(inst, err)
+	}
+	return nil
+}
+
+func insertInstance(e dbtx, inst *TEEInstance) error {
+	var retired int
+	if err := e.QueryRow(`SELECT COUNT(*) FROM instance_aliases WHERE alias_fid = ?`, inst.FID).Scan(&retired); err != nil {
+		return fmt.Errorf("check instance aliases: %w", err)
+	}
+	if retired > 0 {
+		return ErrInstanceRetired
+	}
+	_, err := e.Exec(
 		`INSERT INTO tee_instances (fid, label, public_key, dstack_app_id)
 		 VALUES (?, ?, ?, ?)`,
 		inst.FID, inst.Label, inst.PublicKey, inst.DstackAppID,
 	)
-	if err != nil {
-		var sqliteErr *sqlite.Error
-		if errors.As(err, &sqliteErr) {
-			switch sqliteErr.Code() {
-			case sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY:
-				return ErrInstanceDuplicateFID
-			case sqlite3.SQLITE_CONSTRAINT_UNIQUE:
-				return ErrInstanceDuplicateKey
-			}
+	return err
+}
+
+// registerError maps a failed instance insert to the sentinel errors of
+// RegisterInstance.
+func (s *SQLStore) registerError(inst *TEEInstance, err error) error {
+	if errors.Is(err, ErrInstanceRetired) {
+		return err
+	}
+	kind := s.dialect.constraint(err)
+	if kind == constraintPrimaryKey || kind == constraintUnique {
+		var fid string
+		if err := s.db.QueryRow(
+			`SELECT fid FROM tee_instances WHERE (fid = ? OR public_key = ?) AND deleted_at IS NOT NULL`,
+			inst.FID, inst.PublicKey,
+		).Scan(&fid); err == nil {
+			return fmt.Errorf("instance %q is %w", fid, ErrInTrash)
 		}
-		return fmt.Errorf("register instance: %w", err)
 	}
-	return nil
+	switch kind {
+	case constraintPrimaryKey:
+		return ErrInstanceDuplicateFID
+	case constraintUnique:
+		return ErrInstanceDuplicateKey
+	}
+	return fmt.Errorf("register instance: %w", err)
 }
 
-// GetInstance retrieves a TEE instance by FID.
-func (s *Store) GetInstance(fid string) (*TEEInstance, error) {
+// GetInstance retrieves a TEE instance by FID. Instances in the trash are not
+// returned.
+func (s *SQLStore) GetInstance(fid string) (*TEEInstance, error) {
 	inst := &TEEInstance{}
 	err := s.db.QueryRow(
-		`SELECT fid, label, public_key, dstack_app_id, created_at, last_used_at
-		 FROM tee_instances WHERE fid = ?`, fid,
-	).Scan(&inst.FID, &inst.Label, &inst.PublicKey, &inst.DstackAppID, &inst.CreatedAt, &inst.LastUsedAt)
+		`SELECT fid, label, public_key, dstack_app_id, created_at, last_used_at, status, status_reason, status_changed_at
+		 FROM tee_instances WHERE fid = ? AND deleted_at IS NULL`, fid,
+	).Scan(&inst.FID, &inst.Label, &inst.PublicKey, &inst.DstackAppID, &inst.CreatedAt, &inst.LastUsedAt,
+		&inst.Status, &inst.StatusReason, &inst.StatusChangedAt)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
@@ -53,11 +85,11 @@ func (s *Store) GetInstance(fid string) (*TEEInstance, error) {
 	return inst, nil
 }
 
-// ListInstances returns all registered TEE instances.
-func (s *Store) ListInstances() ([]TEEInstance, error) {
+// ListInstances returns all registered TEE instances outside the trash.
+func (s *SQLStore) ListInstances() ([]TEEInstance, error) {
 	rows, err := s.db.Query(
-		`SELECT fid, label, public_key, dstack_app_id, created_at, last_used_at
-		 FROM tee_instances ORDER BY created_at`,
+		`SELECT fid, label, public_key, dstack_app_id, created_at, last_used_at, status, status_reason, status_changed_at
+		 FROM tee_instances WHERE deleted_at IS NULL ORDER BY created_at`,
 	)
 	if err != nil {
 		return nil, fmt.Errorf("list instances: %w", err)
@@ -67,7 +99,8 @@ func (s *Store) ListInstances() ([]TEEInstance, error) {
 	var instances []TEEInstance
 	for rows.Next() {
 		var inst TEEInstance
-		if err := rows.Scan(&inst.FID, &inst.Label, &inst.PublicKey, &inst.DstackAppID, &inst.CreatedAt, &inst.LastUsedAt); err != nil {
+		if err := rows.Scan(&inst.FID, &inst.Label, &inst.PublicKey, &inst.DstackAppID, &inst.CreatedAt, &inst.LastUsedAt,
+			&inst.Status, &inst.StatusReason, &inst.StatusChangedAt); err != nil {
 			return nil, fmt.Errorf("scan instance: %w", err)
 		}
 		instances = append(instances, inst)
@@ -75,45 +108,23 @@ func (s *Store) ListInstances() ([]TEEInstance, error) {
 	return instances, rows.Err()
 }
 
-// DeleteInstance deletes a TEE instance and its junction/debug_policy entries.
-func (s *Store) DeleteInstance(fid string) (bool, error) {
-	tx, err := s.db.Begin()
-	if err != nil {
-		return false, fmt.Errorf("begin tx: %w", err)
-	}
-	defer tx.Rollback()
-
-	// Delete debug policies referencing this instance
-	if _, err := tx.Exec(`DELETE FROM debug_policies WHERE fid = ?`, fid); err != nil {
-		return false, fmt.Errorf("delete debug_policies for instance: %w", err)
-	}
-
-	// Delete junction entries
-	if _, err := tx.Exec(`DELETE FROM vault_instance_access WHERE fid = ?`, fid); err != nil {
-		return false, fmt.Errorf("delete vault_instance_access for instance: %w", err)
-	}
-
-	// Delete the instance itself
-	res, err := tx.Exec(`DELETE FROM tee_instances WHERE fid = ?`, fid)
+// DeleteInstance moves a TEE instance to the trash. Its grants and debug
+// policies stay, hidden, until it is restored or purged.
+func (s *SQLStore) DeleteInstance(fid string) (bool, error) {
+	res, err := s.db.Exec(
+		`UPDATE tee_instances SET deleted_at = CURRENT_TIMESTAMP WHERE fid = ? AND deleted_at IS NULL`, fid,
+	)
 	if err != nil {
 		return false, fmt.Errorf("delete instance: %w", err)
 	}
-
 	n, _ := res.RowsAffected()
-	if n == 0 {
-		return false, nil
-	}
-
-	if err := tx.Commit(); err != nil {
-		return false, fmt.Errorf("commit tx: %w", err)
-	}
-	return true, nil
+	return n > 0, nil
 }
 
 // UpdateInstance updates dstack_app_id and label for a TEE instance.
-func (s *Store) UpdateInstance(fid, dstackAppID, label string) (bool, error) {
+func (s *SQLStore) UpdateInstance(fid, dstackAppID, label string) (bool, error) {
 	res, err := s.db.Exec(
-		`UPDATE tee_instances SET dstack_app_id = ?, label = ? WHERE fid = ?`,
+		`UPDATE tee_instances SET dstack_app_id = ?, label = ? WHERE fid = ? AND deleted_at IS NULL`,
 		dstackAppID, label, fid,
 	)
 	if err != nil {
@@ -123,10 +134,24 @@ func (s *Store) UpdateInstance(fid, dstackAppID, label string) (bool, error) {
 	return n > 0, nil
 }
 
+// SetInstanceStatus sets the status of a TEE instance with the admin's reason.
+// Its grants, debug policies and audit history are kept whatever the status.
+func (s *SQLStore) SetInstanceStatus(fid, status, reason string) (bool, error) {
+	res, err := s.db.Exec(
+		`UPDATE tee_instances SET status = ?, status_reason = ?, status_changed_at = ? WHERE fid = ? AND deleted_at IS NULL`,
+		status, reason, s.dialect.timestamp(time.Now()), fid,
+	)
+	if err != nil {
+		return false, fmt.Errorf("set instance status: %w", err)
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
 // UpdateLastUsed updates the last_used_at timestamp for a TEE instance.
-func (s *Store) UpdateLastUsed(fid string) error {
+func (s *SQLStore) UpdateLastUsed(fid string) error {
 	_, err := s.db.Exec(
-		`UPDATE tee_instances SET last_used_at = CURRENT_TIMESTAMP WHERE fid = ?`, fid,
+		`UPDATE tee_instances SET last_used_at = CURRENT_TIMESTAMP WHERE fid = ? AND deleted_at IS NULL`, fid,
 	)
 	if err != nil {
 		return fmt.Errorf("update last used: %w", err)
@@ -134,11 +159,14 @@ func (s *Store) UpdateLastUsed(fid string) error {
 	return nil
 }
 
-// GrantVaultAccess inserts a vault↔instance junction entry.
-func (s *Store) GrantVaultAccess(vaultID, fid string) error {
+// GrantVaultAccess inserts a vault↔instance junction entry, valid from
+// notBefore until expiresAt when they are set. Granting again replaces the
+// window.
+func (s *SQLStore) GrantVaultAccess(vaultID, fid string, notBefore, expiresAt *time.Time) error {
 	_, err := s.db.Exec(
-		`INSERT OR IGNORE INTO vault_instance_access (vault_id, fid) VALUES (?, ?)`,
-		vaultID, fid,
+		`INSERT INTO vault_instance_access (vault_id, fid, not_before, expires_at) VALUES (?, ?, ?, ?)
+		 ON CONFLICT(vault_id, fid) DO UPDATE SET not_before = excluded.not_before, expires_at = excluded.expires_at`,
+		vaultID, fid, s.dialect.nullTimestamp(notBefore), s.dialect.nullTimestamp(expiresAt),
 	)
 	if err != nil {
 		return fmt.Errorf("grant vault access: %w", err)
@@ -147,7 +175,7 @@ func (s *Store) GrantVaultAccess(vaultID, fid string) error {
 }
 
 // RevokeVaultAccess deletes a vault↔instance junction entry.
-func (s *Store) RevokeVaultAccess(vaultID, fid string) (bool, error) {
+func (s *SQLStore) RevokeVaultAccess(vaultID, fid string) (bool, error) {
 	res, err := s.db.Exec(
 		`DELETE FROM vault_instance_access WHERE vault_id = ? AND fid = ?`,
 		vaultID, fid,
@@ -159,13 +187,14 @@ func (s *Store) RevokeVaultAccess(vaultID, fid string) (bool, error) {
 	return n > 0, nil
 }
 
-// ListInstanceVaults returns vaults accessible by an instance.
-func (s *Store) ListInstanceVaults(fid string) ([]Vault, error) {
+// ListInstanceVaults returns vaults accessible by an instance, leaving out
+// vaults in the trash.
+func (s *SQLStore) ListInstanceVaults(fid string) ([]Vault, error) {
 	rows, err := s.db.Query(
 		`SELECT v.id, v.name, v.created_at
 		 FROM vaults v
 		 INNER JOIN vault_instance_access a ON v.id = a.vault_id
-		 WHERE a.fid = ?
+		 WHERE a.fid = ? AND v.deleted_at IS NULL
 		 ORDER BY v.created_at`, fid,
 	)
 	if err != nil {
@@ -184,13 +213,16 @@ func (s *Store) ListInstanceVaults(fid string) ([]Vault, error) {
 	return vaults, rows.Err()
 }
 
-// ListVaultInstances returns instances with access to a vault.
-func (s *Store) ListVaultInstances(vaultID string) ([]TEEInstance, error) {
+// ListVaultInstances returns instances granted access to a vault with their
+// grants, leaving out instances in the trash. Grants outside their validity
+// window are listed too; see VaultAccess.State.
+func (s *SQLStore) ListVaultInstances(vaultID string) ([]VaultInstance, error) {
 	rows, err := s.db.Query(
-		`SELECT t.fid, t.label, t.public_key, t.dstack_app_id, t.created_at, t.last_used_at
+		`SELECT t.fid, t.label, t.public_key, t.dstack_app_id, t.created_at, t.last_used_at,
+		        t.status, t.status_reason, t.status_changed_at, a.not_before, a.expires_at, a.created_at
 		 FROM tee_instances t
 		 INNER JOIN vault_instance_access a ON t.fid = a.fid
-		 WHERE a.vault_id = ?
+		 WHERE a.vault_id = ? AND t.deleted_at IS NULL
 		 ORDER BY t.created_at`, vaultID,
 	)
 	if err != nil {
@@ -198,23 +230,35 @@ func (s *Store) ListVaultInstances(vaultID string) ([]TEEInstance, error) {
 	}
 	defer rows.Close()
 
-	var instances []TEEInstance
+	var instances []VaultInstance
 	for rows.Next() {
-		var inst TEEInstance
-		if err := rows.Scan(&inst.FID, &inst.Label, &inst.PublicKey, &inst.DstackAppID, &inst.CreatedAt, &inst.LastUsedAt); err != nil {
+		inst := VaultInstance{Grant: VaultAccess{VaultID: vaultID}}
+		if err := rows.Scan(&inst.FID, &inst.Label, &inst.PublicKey, &inst.DstackAppID, &inst.CreatedAt, &inst.LastUsedAt,
+			&inst.Status, &inst.StatusReason, &inst.StatusChangedAt, &inst.Grant.NotBefore, &inst.Grant.ExpiresAt, &inst.Grant.CreatedAt); err != nil {
 			return nil, fmt.Errorf("scan instance: %w", err)
 		}
+		inst.Grant.FID = inst.FID
 		instances = append(instances, inst)
 	}
 	return instances, rows.Err()
 }
 
-// HasVaultAccess checks if an instance has access to a vault.
-func (s *Store) HasVaultAccess(vaultID, fid string) (bool, error) {
+// inGrantWindow selects grant rows valid at the time bound to both of its
+// placeholders.
+const inGrantWindow = `(not_before IS NULL OR not_before <= ?) AND (expires_at IS NULL OR expires_at > ?)`
+
+// HasVaultAccess checks if an instance has access to a vault. Grants are void
+// while either side is in the trash and outside their validity window.
+func (s *SQLStore) HasVaultAccess(vaultID, fid string) (bool, error) {
+	now := s.dialect.timestamp(time.Now())
 	var count int
 	err := s.db.QueryRow(
-		`SELECT COUNT(*) FROM vault_instance_access WHERE vault_id = ? AND fid = ?`,
-		vaultID, fid,
+		`SELECT COUNT(*) FROM vault_instance_access
+		 WHERE vault_id = ? AND fid = ?
+		   AND `+inGrantWindow+`
+		   AND `+liveVaultID+`
+		   AND fid IN (SELECT fid FROM tee_instances WHERE deleted_at IS NULL)`,
+		vaultID, fid, now, now,
 	).Scan(&count)
 	if err != nil {
 		return false, fmt.Errorf("check vault access: %w", err)
@@ -0,0 +1,186 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// encryptedTables lists every table holding envelope-encrypted values. Each
+// has rowid, vault_id, item, section, field_name, wrapped_key and key_id
+// columns.
+var encryptedTables = []string{"vault_items", "vault_item_versions"}
+
+// encryptedKeyIDs selects the distinct key IDs referenced by any encrypted row.
+const encryptedKeyIDs = `SELECT key_id FROM vault_items UNION SELECT key_id FROM vault_item_versions`
+
+// ActiveMasterKeyID returns the ID of the master key used for new writes.
+func (s *SQLStore) ActiveMasterKeyID() string {
+	return s.keys.active.keyID
+}
+
+// ListMasterKeys returns every key in the keyring together with the number of
+// rows still wrapped by it.
+func (s *SQLStore) ListMasterKeys() ([]MasterKey, error) {
+	rows, err := s.db.Query(
+		`SELECT m.id, m.created_at, m.retired_at,
+		        (SELECT COUNT(*) FROM vault_items v WHERE v.key_id = m.id) +
+		        (SELECT COUNT(*) FROM vault_item_versions v WHERE v.key_id = m.id)
+		 FROM master_keys m ORDER BY m.created_at, m.id`,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("list master keys: %w", err)
+	}
+	defer rows.Close()
+
+	var keys []MasterKey
+	for rows.Next() {
+		var k MasterKey
+		var retiredAt sql.NullTime
+		if err := rows.Scan(&k.ID, &k.CreatedAt, &retiredAt, &k.Rows); err != nil {
+			return nil, fmt.Errorf("scan master key: %w", err)
+		}
+		if retiredAt.Valid {
+			k.RetiredAt = &retiredAt.Time
+		}
+		k.Active = k.ID == s.keys.active.keyID
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+// PendingRewrap returns the number of rows not yet wrapped by the active
+// master key.
+func (s *SQLStore) PendingRewrap() (int, error) {
+	total := 0
+	for _, table := range encryptedTables {
+		var count int
+		if err := s.db.QueryRow(
+			`SELECT COUNT(*) FROM `+table+` WHERE key_id <> ?`, s.keys.active.keyID,
+		).Scan(&count); err != nil {
+			return 0, fmt.Errorf("count pending rewrap in %s: %w", table, err)
+		}
+		total += count
+	}
+	return total, nil
+}
+
+// RewrapBatch re-wraps up to limit data keys that are still wrapped by a
+// previous master key so that they are wrapped by the active one. Only the
+// wrapped data key changes; the value ciphertext is left untouched. Each batch
+// commits on its own, so an interrupted rotation resumes where it stopped.
+// It returns the number of rows re-wrapped; 0 means rotation is complete.
+func (s *SQLStore) RewrapBatch(limit int) (int, error) {
+	if limit <= 0 {
+		return 0, fmt.Errorf("batch size must be positive, got %d", limit)
+	}
+	for _, table := range encryptedTables {
+		n, err := s.rewrapTable(table, limit)
+		if err != nil || n > 0 {
+			return n, err
+		}
+	}
+	return 0, nil
+}
+
+func (s *SQLStore) rewrapTable(table string, limit int) (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	type pending struct {
+		id                            int64
+		vaultID, item, section, field string
+		wrappedKey                    []byte
+		keyID                         string
+	}
+	rows, err := tx.Query(
+		`SELECT rowid, vault_id, item, section, field_name, wrapped_key, key_id
+		 FROM `+table+` WHERE key_id <> ? ORDER BY rowid LIMIT ?`,
+		s.keys.active.keyID, limit,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("select %s rows to rewrap: %w", table, err)
+	}
+	var batch []pending
+	for rows.Next() {
+		var p pending
+		if err := rows.Scan(&p.id, &p.vaultID, &p.item, &p.section, &p.field, &p.wrappedKey, &p.keyID); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("scan row to rewrap: %w", err)
+		}
+		batch = append(batch, p)
+	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return 0, err
+	}
+	rows.Close()
+
+	for _, p := range batch {
+		old, err := s.keys.lookup(p.keyID)
+		if err != nil {
+			return 0, err
+		}
+		aad := fieldAAD(p.vaultID, p.item, p.section, p.field)
+		dataKey, err := old.unwrap(aad, p.wrappedKey)
+		if err != nil {
+			return 0, fmt.Errorf("rewrap %s row %d: %w", table, p.id, err)
+		}
+		wk, err := s.keys.active.wrap(aad, dataKey)
+		if err != nil {
+			return 0, fmt.Errorf("rewrap %s row %d: %w", table, p.id, err)
+		}
+		if _, err := tx.Exec(
+			`UPDATE `+table+` SET wrapped_key = ?, key_id = ? WHERE rowid = ? AND key_id = ?`,
+			wk, s.keys.active.keyID, p.id, p.keyID,
+		); err != nil {
+			return 0, fmt.Errorf("update %s row %d: %w", table, p.id, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("commit tx: %w", err)
+	}
+	return len(batch), nil
+}
+
+// RetireMasterKeys marks every non-active key that no longer wraps any row as
+// retired and returns their IDs. Retired keys can be removed from the server
+// configuration.
+func (s *SQLStore) RetireMasterKeys() ([]string, error) {
+	rows, err := s.db.Query(
+		`SELECT id FROM master_keys
+		 WHERE id <> ? AND retired_at IS NULL
+		   AND id NOT IN (`+encryptedKeyIDs+`)
+		 ORDER BY id`,
+		s.keys.active.keyID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("select keys to retire: %w", err)
+	}
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("scan key id: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return nil, err
+	}
+	rows.Close()
+
+	for _, id := range ids {
+		if _, err := s.db.Exec(
+			`UPDATE master_keys SET retired_at = CURRENT_TIMESTAMP WHERE id = ?`, id,
+		); err != nil {
+			return nil, fmt.Errorf("retire master key %s: %w", id, err)
+		}
+	}
+	return ids, nil
+}
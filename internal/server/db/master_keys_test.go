@@ -0,0 +1,127 @@
+package db
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestRotateMasterKey(t *testing.T) {
+	openStore := newTestDatabase(t)
+	oldKey := testMasterKey
+	newKey := bytes.Repeat([]byte{0x24}, MasterKeyLen)
+
+	s, err := openStore(oldKey)
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+	s.CreateVault(&Vault{ID: "v1", Name: "V1"})
+	for i := 0; i < 5; i++ {
+		s.UpsertField("v1", "alice", "", fmt.Sprintf("f%d", i), fmt.Sprintf("value-%d", i))
+	}
+	s.Close()
+
+	s, err = openStore(newKey, oldKey)
+	if err != nil {
+		t.Fatalf("NewStore with previous key: %v", err)
+	}
+
+	// Old rows stay readable before rotation; new writes use the new key.
+	if val, err := s.GetFieldValue("v1", "alice", "", "f0"); err != nil || val != "value-0" {
+		t.Fatalf("GetFieldValue before rotation = %q, %v", val, err)
+	}
+	s.UpsertField("v1", "alice", "", "f0", "value-0")
+	// 4 current values plus the 5 original versions.
+	if pending, _ := s.PendingRewrap(); pending != 9 {
+		t.Fatalf("PendingRewrap = %d, want 9", pending)
+	}
+
+	// Interrupt after one batch, then resume from a fresh store.
+	if n, err := s.RewrapBatch(3); err != nil || n != 3 {
+		t.Fatalf("RewrapBatch = %d, %v; want 3", n, err)
+	}
+	s.Close()
+
+	s, err = openStore(newKey, oldKey)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	done := 0
+	for {
+		n, err := s.RewrapBatch(3)
+		if err != nil {
+			t.Fatalf("RewrapBatch: %v", err)
+		}
+		if n == 0 {
+			break
+		}
+		done += n
+	}
+	if done != 6 {
+		t.Fatalf("resumed rotation re-wrapped %d rows, want 6", done)
+	}
+
+	retired, err := s.RetireMasterKeys()
+	if err != nil {
+		t.Fatalf("RetireMasterKeys: %v", err)
+	}
+	if len(retired) != 1 || retired[0] != MasterKeyID(oldKey) {
+		t.Fatalf("retired = %v, want [%s]", retired, MasterKeyID(oldKey))
+	}
+
+	keys, err := s.ListMasterKeys()
+	if err != nil {
+		t.Fatalf("ListMasterKeys: %v", err)
+	}
+	if len(keys) != 2 {
+		t.Fatalf("expected 2 keys, got %d", len(keys))
+	}
+	for _, k := range keys {
+		switch k.ID {
+		case MasterKeyID(newKey):
+			if !k.Active || k.Rows != 11 || k.RetiredAt != nil {
+				t.Errorf("active key = %+v", k)
+			}
+		case MasterKeyID(oldKey):
+			if k.Active || k.Rows != 0 || k.RetiredAt == nil {
+				t.Errorf("retired key = %+v", k)
+			}
+		default:
+			t.Errorf("unexpected key %s", k.ID)
+		}
+	}
+	s.Close()
+
+	// The previous key is no longer needed.
+	s, err = openStore(newKey)
+	if err != nil {
+		t.Fatalf("NewStore without previous key: %v", err)
+	}
+	defer s.Close()
+	for i := 0; i < 5; i++ {
+		val, err := s.GetFieldValue("v1", "alice", "", fmt.Sprintf("f%d", i))
+		if err != nil || val != fmt.Sprintf("value-%d", i) {
+			t.Errorf("f%d = %q, %v", i, val, err)
+		}
+	}
+}
+
+func TestNewStore_RequiresPreviousKeyDuringRotation(t *testing.T) {
+	openStore := newTestDatabase(t)
+	s, err := openStore(testMasterKey)
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+	s.CreateVault(&Vault{ID: "v1", Name: "V1"})
+	s.UpsertField("v1", "alice", "", "token", "secret")
+	s.Close()
+
+	newKey := bytes.Repeat([]byte{0x24}, MasterKeyLen)
+	if _, err := openStore(newKey); !errors.Is(err, ErrMasterKeyMismatch) {
+		t.Fatalf("expected ErrMasterKeyMismatch, got: %v", err)
+	}
+	if _, err := openStore(newKey, []byte("short")); err == nil {
+		t.Fatal("expected error for short previous key")
+	}
+}
@@ -0,0 +1,217 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// Measurement policies pin the images allowed to fetch secrets: in strict
+// RA-TLS mode the handler checks the measurements of a verified quote
+// against the policy of the instance and of every vault it is granted. The
+// store only records the policies and finds those that apply.
+
+// measurementPolicyKey identifies a measurement policy.
+type measurementPolicyKey struct {
+	scope, target string
+}
+
+// PutMeasurementPolicy creates or replaces the measurement policy of a vault
+// or instance.
+func (s *SQLStore) PutMeasurementPolicy(p *MeasurementPolicy) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(
+		`INSERT INTO measurement_policies (scope, target) VALUES (?, ?)
+		 ON CONFLICT(scope, target) DO UPDATE SET updated_at = CURRENT_TIMESTAMP`,
+		p.Scope, p.Target,
+	); err != nil {
+		return fmt.Errorf("put measurement policy: %w", err)
+	}
+	if _, err := tx.Exec(`DELETE FROM measurement_policy_values WHERE scope = ? AND target = ?`, p.Scope, p.Target); err != nil {
+		return fmt.Errorf("clear measurement policy values: %w", err)
+	}
+	for name, values := range p.Allowed {
+		for _, value := range values {
+			if _, err := tx.Exec(
+				`INSERT INTO measurement_policy_values (scope, target, measurement, value) VALUES (?, ?, ?, ?)
+				 ON CONFLICT DO NOTHING`,
+				p.Scope, p.Target, name, value,
+			); err != nil {
+				return fmt.Errorf("add measurement policy value %s=%q: %w", name, value, err)
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit tx: %w", err)
+	}
+	return nil
+}
+
+// GetMeasurementPolicy retrieves the measurement policy of a vault or
+// instance.
+func (s *SQLStore) GetMeasurementPolicy(scope, target string) (*MeasurementPolicy, error) {
+	p := &MeasurementPolicy{}
+	err := s.db.QueryRow(
+		`SELECT scope, target, created_at, updated_at FROM measurement_policies WHERE scope = ? AND target = ?`,
+		scope, target,
+	).Scan(&p.Scope, &p.Target, &p.CreatedAt, &p.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get measurement policy: %w", err)
+	}
+	values, err := s.measurementValues(`scope = ? AND target = ?`, scope, target)
+	if err != nil {
+		return nil, err
+	}
+	p.Allowed = values[measurementPolicyKey{scope, target}]
+	return p, nil
+}
+
+// ListMeasurementPolicies returns all measurement policies, ordered by scope
+// and target.
+func (s *SQLStore) ListMeasurementPolicies() ([]MeasurementPolicy, error) {
+	return s.measurementPolicies(`1 = 1`)
+}
+
+// ApplicableMeasurementPolicies returns the measurement policies a quote of
+// instance fid, running dstack app appID, must satisfy: the instance's own
+// and those of the live vaults it holds a vault, field or app grant on,
+// whether or not the grant is in its validity window.
+func (s *SQLStore) ApplicableMeasurementPolicies(fid, appID string) ([]MeasurementPolicy, error) {
+	return s.measurementPolicies(
+		`(scope = ? AND target = ?)
+		 OR (scope = ? AND target IN (SELECT id FROM vaults WHERE deleted_at IS NULL) AND target IN (
+			SELECT vault_id FROM vault_instance_access WHERE fid = ?
+			UNION SELECT vault_id FROM field_grants WHERE fid = ? AND effect = ?
+			UNION SELECT vault_id FROM vault_app_access WHERE dstack_app_id = ?))`,
+		MeasurementScopeInstance, fid, MeasurementScopeVault, fid, fid, GrantAllow, appID,
+	)
+}
+
+// measurementPolicies returns the measurement policies matching where with
+// their values.
+func (s *SQLStore) measurementPolicies(where string, args ...any) ([]MeasurementPolicy, error) {
+	rows, err := s.db.Query(
+		`SELECT scope, target, created_at, updated_at FROM measurement_policies
+		 WHERE `+where+`
+		 ORDER BY scope, target`, args...,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("list measurement policies: %w", err)
+	}
+	defer rows.Close()
+
+	var policies []MeasurementPolicy
+	for rows.Next() {
+		var p MeasurementPolicy
+		if err := rows.Scan(&p.Scope, &p.Target, &p.CreatedAt, &p.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("scan measurement policy: %w", err)
+		}
+		policies = append(policies, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(policies) == 0 {
+		return nil, nil
+	}
+
+	values, err := s.measurementValues(`1 = 1`)
+	if err != nil {
+		return nil, err
+	}
+	for i := range policies {
+		policies[i].Allowed = values[measurementPolicyKey{policies[i].Scope, policies[i].Target}]
+	}
+	return policies, nil
+}
+
+// measurementValues returns the allowed values of the measurement policies
+// matching where.
+func (s *SQLStore) measurementValues(where string, args ...any) (map[measurementPolicyKey]map[string][]string, error) {
+	rows, err := s.db.Query(
+		`SELECT scope, target, measurement, value FROM measurement_policy_values
+		 WHERE `+where+`
+		 ORDER BY scope, target, measurement, value`, args...,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("list measurement policy values: %w", err)
+	}
+	defer rows.Close()
+
+	values := map[measurementPolicyKey]map[string][]string{}
+	for rows.Next() {
+		var key measurementPolicyKey
+		var name, value string
+		if err := rows.Scan(&key.scope, &key.target, &name, &value); err != nil {
+			return nil, fmt.Errorf("scan measurement policy value: %w", err)
+		}
+		if values[key] == nil {
+			values[key] = map[string][]string{}
+		}
+		values[key][name] = append(values[key][name], value)
+	}
+	return values, rows.Err()
+}
+
+// DeleteMeasurementPolicy removes the measurement policy of a vault or
+// instance.
+func (s *SQLStore) DeleteMeasurementPolicy(scope, target string) (bool, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return false, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	deleted, err := deleteMeasurementPolicy(tx, scope, target)
+	if err != nil {
+		return false, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("commit tx: %w", err)
+	}
+	return deleted, nil
+}
+
+// deleteMeasurementPolicy removes a measurement policy and its values.
+func deleteMeasurementPolicy(e dbtx, scope, target string) (bool, error) {
+	if _, err := e.Exec(`DELETE FROM measurement_policy_values WHERE scope = ? AND target = ?`, scope, target); err != nil {
+		return false, fmt.Errorf("delete measurement policy values: %w", err)
+	}
+	res, err := e.Exec(`DELETE FROM measurement_policies WHERE scope = ? AND target = ?`, scope, target)
+	if err != nil {
+		return false, fmt.Errorf("delete measurement policy: %w", err)
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// moveMeasurementPolicy moves a measurement policy and its values to a new
+// target. The policy row is copied first so the values never dangle.
+func moveMeasurementPolicy(e dbtx, scope, target, newTarget string) error {
+	if _, err := e.Exec(
+		`INSERT INTO measurement_policies (scope, target, created_at, updated_at)
+		 SELECT scope, ?, created_at, updated_at FROM measurement_policies WHERE scope = ? AND target = ?`,
+		newTarget, scope, target,
+	); err != nil {
+		return fmt.Errorf("copy measurement policy: %w", err)
+	}
+	if _, err := e.Exec(
+		`UPDATE measurement_policy_values SET target = ? WHERE scope = ? AND target = ?`,
+		newTarget, scope, target,
+	); err != nil {
+		return fmt.Errorf("move measurement policy values: %w", err)
+	}
+	if _, err := e.Exec(`DELETE FROM measurement_policies WHERE scope = ? AND target = ?`, scope, target); err != nil {
+		return fmt.Errorf("delete old measurement policy: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package db
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMeasurementPolicies(t *testing.T) {
+	s := newTestStore(t)
+	s.CreateVault(&Vault{ID: "v1", Name: "V1"})
+	s.RegisterInstance(&TEEInstance{FID: "f1", PublicKey: []byte("pk1"), DstackAppID: "app1"})
+
+	if p, err := s.GetMeasurementPolicy(MeasurementScopeVault, "v1"); err != nil || p != nil {
+		t.Fatalf("GetMeasurementPolicy before put = %+v, %v; want nil", p, err)
+	}
+	if err := s.PutMeasurementPolicy(&MeasurementPolicy{Scope: MeasurementScopeVault, Target: "v1", Allowed: map[string][]string{
+		MeasurementMRTD:  {"bb", "aa"},
+		MeasurementRTMR0: {"r0"},
+	}}); err != nil {
+		t.Fatalf("PutMeasurementPolicy: %v", err)
+	}
+	p, err := s.GetMeasurementPolicy(MeasurementScopeVault, "v1")
+	if err != nil || p == nil || !reflect.DeepEqual(p.Allowed, map[string][]string{MeasurementMRTD: {"aa", "bb"}, MeasurementRTMR0: {"r0"}}) {
+		t.Fatalf("GetMeasurementPolicy = %+v, %v; want mrtd aa/bb and rtmr0 r0", p, err)
+	}
+
+	// Putting again replaces the allowed values.
+	s.PutMeasurementPolicy(&MeasurementPolicy{Scope: MeasurementScopeVault, Target: "v1", Allowed: map[string][]string{MeasurementMRTD: {"cc"}}})
+	s.PutMeasurementPolicy(&MeasurementPolicy{Scope: MeasurementScopeInstance, Target: "f1", Allowed: map[string][]string{MeasurementRTMR3: {"r3"}}})
+	policies, err := s.ListMeasurementPolicies()
+	if err != nil || len(policies) != 2 || policies[0].Scope != MeasurementScopeInstance ||
+		!reflect.DeepEqual(policies[1].Allowed, map[string][]string{MeasurementMRTD: {"cc"}}) {
+		t.Fatalf("ListMeasurementPolicies = %+v, %v", policies, err)
+	}
+
+	if ok, err := s.DeleteMeasurementPolicy(MeasurementScopeVault, "v1"); err != nil || !ok {
+		t.Errorf("DeleteMeasurementPolicy = %v, %v", ok, err)
+	}
+	if ok, _ := s.DeleteMeasurementPolicy(MeasurementScopeVault, "v1"); ok {
+		t.Error("expected a second delete to report no policy")
+	}
+}
+
+func TestApplicableMeasurementPolicies(t *testing.T) {
+	s := newTestStore(t)
+	for _, id := range []string{"direct", "field", "app", "other"} {
+		s.CreateVault(&Vault{ID: id, Name: id})
+		s.PutMeasurementPolicy(&MeasurementPolicy{Scope: MeasurementScopeVault, Target: id, Allowed: map[string][]string{MeasurementMRTD: {id}}})
+	}
+	s.RegisterInstance(&TEEInstance{FID: "f1", PublicKey: []byte("pk1"), DstackAppID: "app1"})
+	s.RegisterInstance(&TEEInstance{FID: "f2", PublicKey: []byte("pk2"), DstackAppID: "app2"})
+	s.PutMeasurementPolicy(&MeasurementPolicy{Scope: MeasurementScopeInstance, Target: "f1", Allowed: map[string][]string{MeasurementRTMR3: {"r3"}}})
+	s.PutMeasurementPolicy(&MeasurementPolicy{Scope: MeasurementScopeInstance, Target: "f2", Allowed: map[string][]string{MeasurementRTMR3: {"x"}}})
+	s.GrantVaultAccess("direct", "f1", nil, nil)
+	s.PutFieldGrant(&FieldGrant{VaultID: "field", FID: "f1", Item: "db", FieldName: "*", Effect: GrantAllow})
+	s.PutFieldGrant(&FieldGrant{VaultID: "other", FID: "f1", Item: "db", FieldName: "*", Effect: GrantDeny})
+	s.GrantAppAccess("app", "app1", nil, nil)
+
+	targets := func() []string {
+		t.Helper()
+		policies, err := s.ApplicableMeasurementPolicies("f1", "app1")
+		if err != nil {
+			t.Fatalf("ApplicableMeasurementPolicies: %v", err)
+		}
+		var out []string
+		for _, p := range policies {
+			out = append(out, p.Scope+"/"+p.Target)
+		}
+		return out
+	}
+	if got, want := targets(), []string{"instance/f1", "vault/app", "vault/direct", "vault/field"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("applicable policies = %v, want %v", got, want)
+	}
+
+	// A vault in the trash cannot be read, so its policy does not apply.
+	s.DeleteVaultCascade("direct")
+	if got, want := targets(), []string{"instance/f1", "vault/app", "vault/field"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("applicable policies with a trashed vault = %v, want %v", got, want)
+	}
+
+	// Purging the vault or the instance drops its policy; a re-key moves it.
+	s.PurgeVault("direct")
+	if p, _ := s.GetMeasurementPolicy(MeasurementScopeVault, "direct"); p != nil {
+		t.Errorf("policy of a purged vault = %+v", p)
+	}
+	s.RekeyInstance("f1", "f3", []byte("pk3"))
+	if p, _ := s.GetMeasurementPolicy(MeasurementScopeInstance, "f3"); p == nil || !reflect.DeepEqual(p.Allowed[MeasurementRTMR3], []string{"r3"}) {
+		t.Errorf("policy after re-key = %+v, want it moved to f3", p)
+	}
+	s.DeleteInstance("f3")
+	s.PurgeInstance("f3")
+	if p, _ := s.GetMeasurementPolicy(MeasurementScopeInstance, "f3"); p != nil {
+		t.Errorf("policy of a purged instance = %+v", p)
+	}
+}
+
+func TestMeasurementPolicy_Mismatch(t *testing.T) {
+	p := &MeasurementPolicy{Allowed: map[string][]string{
+		MeasurementMRTD:  {"aa", "bb"},
+		MeasurementRTMR3: {"cc"},
+	}}
+	for _, tc := range []struct {
+		measured map[string]string
+		want     string
+	}{
+		{map[string]string{MeasurementMRTD: "bb", MeasurementRTMR3: "cc", MeasurementRTMR0: "anything"}, ""},
+		{map[string]string{MeasurementMRTD: "dd", MeasurementRTMR3: "cc"}, MeasurementMRTD},
+		{map[string]string{MeasurementMRTD: "aa"}, MeasurementRTMR3},
+	} {
+		if got := p.Mismatch(tc.measured); got != tc.want {
+			t.Errorf("Mismatch(%v) = %q, want %q", tc.measured, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,2207 @@
+package db
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryStore implements Store in process memory, for tests and throwaway
+// servers. Nothing is persisted or encrypted. It enforces the same uniqueness
+// and foreign key rules as SQLStore and returns the same sentinel errors.
+type MemoryStore struct {
+	mu        sync.RWMutex
+	seq       int64 // insertion order, used to break created_at ties
+	vaults    map[string]*memVault
+	fields    map[fieldKey]*memField
+	versions  map[fieldKey][]memVersion // oldest first
+	expiries  map[fieldKey]time.Time    // an empty field covers the section
+	instances map[string]*memInstance
+	access    map[accessKey]*VaultAccess
+	grants    map[grantKey]*FieldGrant
+	apps      map[appKey]*AppAccess
+	policies  map[accessKey]*DebugPolicy
+	enroll    map[string]*EnrollmentPolicy
+	measures  map[measurementPolicyKey]*MeasurementPolicy
+	tokens    map[string]*EnrollmentToken
+	aliases   map[string]*InstanceAlias // by alias FID
+	audit     []AuditEvent              // oldest first
+	auditSigs []AuditSignature
+}
+
+type fieldKey struct{ vaultID, item, section, field string }
+
+type accessKey struct{ vaultID, fid string }
+
+type grantKey struct{ vaultID, fid, item, section, field string }
+
+type appKey struct{ vaultID, appID string }
+
+// less orders field keys the way SQLStore sorts field rows.
+func (k fieldKey) less(o fieldKey) bool {
+	switch {
+	case k.vaultID != o.vaultID:
+		return k.vaultID < o.vaultID
+	case k.item != o.item:
+		return k.item < o.item
+	case k.section != o.section:
+		return k.section < o.section
+	}
+	return k.field < o.field
+}
+
+type memVault struct {
+	Vault
+	seq int64
+}
+
+type memField struct {
+	VaultItem
+	seq       int64
+	deletedAt *time.Time // set while the field's section is in the trash
+}
+
+type memVersion struct {
+	version   int
+	value     string
+	author    string
+	createdAt time.Time
+}
+
+type memInstance struct {
+	TEEInstance
+	seq int64
+}
+
+var _ Store = (*MemoryStore)(nil)
+
+// NewMemoryStore returns an empty in-memory store.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		vaults:    map[string]*memVault{},
+		fields:    map[fieldKey]*memField{},
+		versions:  map[fieldKey][]memVersion{},
+		expiries:  map[fieldKey]time.Time{},
+		instances: map[string]*memInstance{},
+		access:    map[accessKey]*VaultAccess{},
+		grants:    map[grantKey]*FieldGrant{},
+		apps:      map[appKey]*AppAccess{},
+		policies:  map[accessKey]*DebugPolicy{},
+		enroll:    map[string]*EnrollmentPolicy{},
+		measures:  map[measurementPolicyKey]*MeasurementPolicy{},
+		tokens:    map[string]*EnrollmentToken{},
+		aliases:   map[string]*InstanceAlias{},
+	}
+}
+
+// Close is a no-op; the data is dropped with the store.
+func (m *MemoryStore) Close() error {
+	return nil
+}
+
+// now returns the current time at the precision SQLite's CURRENT_TIMESTAMP
+// stores.
+func now() time.Time {
+	return time.Now().UTC().Truncate(time.Second)
+}
+
+func (m *MemoryStore) nextSeq() int64 {
+	m.seq++
+	return m.seq
+}
+
+// CreateVault inserts a new vault.
+func (m *MemoryStore) CreateVault(v *Vault) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if stored, ok := m.vaults[v.ID]; ok {
+		if stored.DeletedAt != nil {
+			return fmt.Errorf("vault %q is %w", v.ID, ErrInTrash)
+		}
+		return ErrVaultDuplicate
+	}
+	stored := *v
+	stored.CreatedAt = now()
+	stored.DeletedAt = nil
+	m.vaults[v.ID] = &memVault{Vault: stored, seq: m.nextSeq()}
+	return nil
+}
+
+// liveVault returns a vault that is not in the trash. The caller holds the
+// lock.
+func (m *MemoryStore) liveVault(id string) (*memVault, bool) {
+	v, ok := m.vaults[id]
+	if !ok || v.DeletedAt != nil {
+		return nil, false
+	}
+	return v, true
+}
+
+// GetVault retrieves a vault by ID. Vaults in the trash are not returned.
+func (m *MemoryStore) GetVault(id string) (*Vault, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	v, ok := m.liveVault(id)
+	if !ok {
+		return nil, nil
+	}
+	out := v.Vault
+	return &out, nil
+}
+
+// UpdateVault updates the name of a vault. Returns true if the vault exists.
+func (m *MemoryStore) UpdateVault(v *Vault) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.liveVault(v.ID)
+	if !ok {
+		return false, nil
+	}
+	stored.Name = v.Name
+	return true, nil
+}
+
+// ListVaults returns all vaults outside the trash ordered by creation time.
+func (m *MemoryStore) ListVaults() ([]Vault, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	sorted := make([]*memVault, 0, len(m.vaults))
+	for _, v := range m.vaults {
+		if v.DeletedAt == nil {
+			sorted = append(sorted, v)
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].seq < sorted[j].seq })
+
+	var vaults []Vault
+	for _, v := range sorted {
+		vaults = append(vaults, v.Vault)
+	}
+	return vaults, nil
+}
+
+// DeleteVault moves an empty vault to the trash. Returns
+// ErrVaultHasDependents if any field, grant or debug policy still references
+// it, including ones in the trash.
+func (m *MemoryStore) DeleteVault(id string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	v, ok := m.liveVault(id)
+	if !ok {
+		return false, nil
+	}
+	if m.vaultHasDependents(id) {
+		return false, ErrVaultHasDependents
+	}
+	ts := now()
+	v.DeletedAt = &ts
+	return true, nil
+}
+
+func (m *MemoryStore) vaultHasDependents(id string) bool {
+	for k := range m.fields {
+		if k.vaultID == id {
+			return true
+		}
+	}
+	for k := range m.versions {
+		if k.vaultID == id {
+			return true
+		}
+	}
+	for k := range m.expiries {
+		if k.vaultID == id {
+			return true
+		}
+	}
+	for k := range m.access {
+		if k.vaultID == id {
+			return true
+		}
+	}
+	for k := range m.grants {
+		if k.vaultID == id {
+			return true
+		}
+	}
+	for k := range m.apps {
+		if k.vaultID == id {
+			return true
+		}
+	}
+	for k := range m.policies {
+		if k.vaultID == id {
+			return true
+		}
+	}
+	for _, p := range m.enroll {
+		if slices.Contains(p.Vaults, id) {
+			return true
+		}
+	}
+	for _, t := range m.tokens {
+		if slices.Contains(t.Vaults, id) {
+			return true
+		}
+	}
+	return false
+}
+
+// DeleteVaultCascade moves a vault to the trash together with all its
+// fields, grants and debug policies.
+func (m *MemoryStore) DeleteVaultCascade(id string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	v, ok := m.liveVault(id)
+	if !ok {
+		return false, nil
+	}
+	ts := now()
+	v.DeletedAt = &ts
+	return true, nil
+}
+
+// SetVersionRetention sets how many versions are kept per field in a vault
+// (0 keeps all) and prunes existing history accordingly. Returns true if the
+// vault exists.
+func (m *MemoryStore) SetVersionRetention(vaultID string, keep int) (bool, error) {
+	if keep < 0 {
+		return false, fmt.Errorf("version retention must not be negative, got %d", keep)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	v, ok := m.liveVault(vaultID)
+	if !ok {
+		return false, nil
+	}
+	v.VersionRetention = keep
+	for k := range m.versions {
+		if k.vaultID == vaultID {
+			m.pruneFieldVersions(k)
+		}
+	}
+	return true, nil
+}
+
+// upsertField stores value as the field's next version and makes it current.
+// The caller holds the write lock and has checked that the vault exists.
+func (m *MemoryStore) upsertField(k fieldKey, value, author string) int {
+	version := 1
+	if history := m.versions[k]; len(history) > 0 {
+		version = history[len(history)-1].version + 1
+	}
+	ts := now()
+
+	f, ok := m.fields[k]
+	if !ok {
+		f = &memField{
+			VaultItem: VaultItem{
+				VaultID:   k.vaultID,
+				Item:      k.item,
+				Section:   k.section,
+				FieldName: k.field,
+				CreatedAt: ts,
+			},
+			seq: m.nextSeq(),
+		}
+		f.ID = f.seq
+		m.fields[k] = f
+	}
+	f.Value = value
+	f.Version = version
+	f.UpdatedAt = ts
+
+	m.versions[k] = append(m.versions[k], memVersion{version: version, value: value, author: author, createdAt: ts})
+	m.pruneFieldVersions(k)
+	return version
+}
+
+// pruneFieldVersions drops the oldest versions of a field beyond the vault's
+// retention limit. The current version is always kept.
+func (m *MemoryStore) pruneFieldVersions(k fieldKey) {
+	keep := m.vaults[k.vaultID].VersionRetention
+	history := m.versions[k]
+	if keep <= 0 || len(history) == 0 {
+		return
+	}
+	cutoff := history[len(history)-1].version - keep
+	i := 0
+	for i < len(history) && history[i].version <= cutoff {
+		i++
+	}
+	m.versions[k] = append([]memVersion(nil), history[i:]...)
+}
+
+// deleteFields permanently removes the live fields matching match, plus
+// their history and expiries, and reports whether any field was removed.
+// Section expiries go with the section's last field.
+func (m *MemoryStore) deleteFields(match func(fieldKey) bool) bool {
+	var deleted []fieldKey
+	for k := range m.fields {
+		if _, live := m.liveField(k); live && match(k) {
+			deleted = append(deleted, k)
+		}
+	}
+	m.dropFields(deleted)
+	return len(deleted) > 0
+}
+
+// dropFields removes fields with their history and expiries, and the expiry
+// of every section left empty.
+func (m *MemoryStore) dropFields(keys []fieldKey) {
+	for _, k := range keys {
+		delete(m.fields, k)
+		delete(m.versions, k)
+		delete(m.expiries, k)
+	}
+	for k := range m.expiries {
+		if k.field == "" && !m.sectionHasFields(k) {
+			delete(m.expiries, k)
+		}
+	}
+}
+
+// sectionHasFields reports whether the section of k holds any field, in the
+// trash or not.
+func (m *MemoryStore) sectionHasFields(k fieldKey) bool {
+	for other := range m.fields {
+		if other.vaultID == k.vaultID && other.item == k.item && other.section == k.section {
+			return true
+		}
+	}
+	return false
+}
+
+// liveField returns a field that is not in the trash, by itself or through
+// its vault. The caller holds the lock.
+func (m *MemoryStore) liveField(k fieldKey) (*memField, bool) {
+	f, ok := m.fields[k]
+	if !ok || f.deletedAt != nil {
+		return nil, false
+	}
+	if _, ok := m.liveVault(k.vaultID); !ok {
+		return nil, false
+	}
+	return f, true
+}
+
+// checkNotTrashed returns ErrInTrash if the vault or the section of k is in
+// the trash.
+func (m *MemoryStore) checkNotTrashed(k fieldKey) error {
+	if v, ok := m.vaults[k.vaultID]; ok && v.DeletedAt != nil {
+		return fmt.Errorf("vault %q is %w", k.vaultID, ErrInTrash)
+	}
+	for other, f := range m.fields {
+		if f.deletedAt != nil && other.vaultID == k.vaultID && other.item == k.item && other.section == k.section {
+			return fmt.Errorf("section %s is %w", sectionPath(k.vaultID, k.item, k.section), ErrInTrash)
+		}
+	}
+	return nil
+}
+
+// checkVault returns the foreign key error SQLStore reports when a field is
+// written to a vault that does not exist.
+func (m *MemoryStore) checkVault(vaultID string) error {
+	if _, ok := m.vaults[vaultID]; !ok {
+		return fmt.Errorf("vault %q does not exist", vaultID)
+	}
+	return nil
+}
+
+// UpsertField inserts or updates a single field in a section of a vault item.
+func (m *MemoryStore) UpsertField(vaultID, item, section, field, value string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.checkVault(vaultID); err != nil {
+		return fmt.Errorf("upsert field: %w", err)
+	}
+	k := fieldKey{vaultID, item, section, field}
+	if err := m.checkNotTrashed(k); err != nil {
+		return fmt.Errorf("upsert field: %w", err)
+	}
+	m.upsertField(k, value, "")
+	return nil
+}
+
+// SetItemFields replaces all fields of a section of an item. Other sections
+// of the item are left alone.
+func (m *MemoryStore) SetItemFields(vaultID, item, section string, fields map[string]string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.checkVault(vaultID); err != nil {
+		return fmt.Errorf("set item fields: %w", err)
+	}
+	if err := m.checkNotTrashed(fieldKey{vaultID, item, section, ""}); err != nil {
+		return fmt.Errorf("set item fields: %w", err)
+	}
+	m.deleteFields(func(k fieldKey) bool {
+		_, keep := fields[k.field]
+		return k.vaultID == vaultID && k.item == item && k.section == section && !keep
+	})
+	for name, value := range fields {
+		m.upsertField(fieldKey{vaultID, item, section, name}, value, "")
+	}
+	return nil
+}
+
+// GetItemFields returns all fields of a vault item across its sections,
+// ordered by section and field name. Fields in the trash are left out.
+func (m *MemoryStore) GetItemFields(vaultID, item string) ([]VaultItem, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var items []VaultItem
+	for k, f := range m.fields {
+		if _, live := m.liveField(k); live && k.vaultID == vaultID && k.item == item {
+			items = append(items, f.VaultItem)
+		}
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Section != items[j].Section {
+			return items[i].Section < items[j].Section
+		}
+		return items[i].FieldName < items[j].FieldName
+	})
+	return items, nil
+}
+
+// GetFieldValue returns the value of a single field. Fields in the trash are
+// reported as ErrFieldNotFound.
+func (m *MemoryStore) GetFieldValue(vaultID, item, section, field string) (string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	f, ok := m.liveField(fieldKey{vaultID, item, section, field})
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrFieldNotFound, fieldPath(vaultID, item, section, field))
+	}
+	return f.Value, nil
+}
+
+// ListItems returns distinct names of items with fields outside the trash.
+func (m *MemoryStore) ListItems(vaultID string) ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	seen := map[string]bool{}
+	var items []string
+	for k := range m.fields {
+		if _, live := m.liveField(k); live && k.vaultID == vaultID && !seen[k.item] {
+			seen[k.item] = true
+			items = append(items, k.item)
+		}
+	}
+	sort.Strings(items)
+	return items, nil
+}
+
+// MergeItemFields upserts provided fields and deletes specified keys without
+// touching other existing fields in the section.
+func (m *MemoryStore) MergeItemFields(vaultID, item, section string, upsert map[string]string, deleteKeys []string, author string) error {
+	return m.MergeVaultFields(vaultID, []ItemMerge{{Item: item, Section: section, Upsert: upsert, Delete: deleteKeys}}, author)
+}
+
+// MergeVaultFields applies the MergeItemFields changes for several sections
+// of a vault at once; nothing is changed if any of them fails.
+func (m *MemoryStore) MergeVaultFields(vaultID string, merges []ItemMerge, author string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, merge := range merges {
+		if len(merge.Upsert) > 0 {
+			if err := m.checkVault(vaultID); err != nil {
+				return fmt.Errorf("merge item fields: %w", err)
+			}
+			if err := m.checkNotTrashed(fieldKey{vaultID, merge.Item, merge.Section, ""}); err != nil {
+				return fmt.Errorf("merge item fields: %w", err)
+			}
+		}
+	}
+	for _, merge := range merges {
+		for _, key := range merge.Delete {
+			k := fieldKey{vaultID, merge.Item, merge.Section, key}
+			m.deleteFields(func(other fieldKey) bool { return other == k })
+		}
+		for name, value := range merge.Upsert {
+			m.upsertField(fieldKey{vaultID, merge.Item, merge.Section, name}, value, author)
+		}
+	}
+	return nil
+}
+
+// DeleteItem moves every section of an item to the trash. Returns true if
+// any field was moved.
+func (m *MemoryStore) DeleteItem(vaultID, item string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.trashFields(func(k fieldKey) bool {
+		return k.vaultID == vaultID && k.item == item
+	}), nil
+}
+
+// DeleteSection moves one section of an item to the trash. Returns true if
+// any field was moved.
+func (m *MemoryStore) DeleteSection(vaultID, item, section string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.trashFields(func(k fieldKey) bool {
+		return k.vaultID == vaultID && k.item == item && k.section == section
+	}), nil
+}
+
+// trashFields stamps the live fields matching match as deleted.
+func (m *MemoryStore) trashFields(match func(fieldKey) bool) bool {
+	ts := now()
+	trashed := false
+	for k := range m.fields {
+		if f, live := m.liveField(k); live && match(k) {
+			f.deletedAt = &ts
+			trashed = true
+		}
+	}
+	return trashed
+}
+
+// DeleteField permanently deletes a single field and its version history; it
+// does not go to the trash. Returns true if the field existed.
+func (m *MemoryStore) DeleteField(vaultID, item, section, field string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	k := fieldKey{vaultID, item, section, field}
+	return m.deleteFields(func(other fieldKey) bool { return other == k }), nil
+}
+
+// SetExpiry sets the expiry of a field, or of every field in a section when
+// field is empty. Returns false if no such field or section exists.
+func (m *MemoryStore) SetExpiry(vaultID, item, section, field string, expiresAt time.Time) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	k := fieldKey{vaultID, item, section, field}
+	if _, ok := m.liveField(k); !ok && (field != "" || !m.sectionHasLiveFields(k)) {
+		return false, nil
+	}
+	m.expiries[k] = expiresAt.UTC().Truncate(time.Second)
+	return true, nil
+}
+
+// sectionHasLiveFields reports whether the section of k holds any field
+// outside the trash.
+func (m *MemoryStore) sectionHasLiveFields(k fieldKey) bool {
+	for other := range m.fields {
+		if _, live := m.liveField(other); live && other.vaultID == k.vaultID && other.item == k.item && other.section == k.section {
+			return true
+		}
+	}
+	return false
+}
+
+// ClearExpiry removes the expiry set on a field, or on a section when field
+// is empty. Returns true if an expiry was removed.
+func (m *MemoryStore) ClearExpiry(vaultID, item, section, field string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	k := fieldKey{vaultID, item, section, field}
+	if _, ok := m.expiries[k]; !ok {
+		return false, nil
+	}
+	delete(m.expiries, k)
+	return true, nil
+}
+
+// GetFieldExpiry returns when a field expires, taking its section's expiry
+// into account, or nil if it never does or is in the trash.
+func (m *MemoryStore) GetFieldExpiry(vaultID, item, section, field string) (*time.Time, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	k := fieldKey{vaultID, item, section, field}
+	if m.historyTrashed(k) {
+		return nil, nil
+	}
+	t, ok := m.fieldExpiry(k)
+	if !ok {
+		return nil, nil
+	}
+	return &t, nil
+}
+
+// fieldExpiry returns the earlier of a field's own and its section's expiry.
+// The caller holds the lock.
+func (m *MemoryStore) fieldExpiry(k fieldKey) (time.Time, bool) {
+	t, ok := m.expiries[k]
+	if st, sok := m.expiries[fieldKey{k.vaultID, k.item, k.section, ""}]; sok && (!ok || st.Before(t)) {
+		t, ok = st, true
+	}
+	return t, ok
+}
+
+// ListExpiringFields returns the fields of a vault that expire at or before
+// the given time, including those already expired, soonest first.
+func (m *MemoryStore) ListExpiringFields(vaultID string, before time.Time) ([]FieldExpiry, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	earliest := map[fieldKey]time.Time{}
+	for k := range m.fields {
+		if _, live := m.liveField(k); !live || k.vaultID != vaultID {
+			continue
+		}
+		if t, ok := m.fieldExpiry(k); ok {
+			earliest[k] = t
+		}
+	}
+	return expiringFields(earliest, before), nil
+}
+
+// ListFieldVersions returns the retained versions of a field, newest first.
+// The history of fields in the trash is left out.
+func (m *MemoryStore) ListFieldVersions(vaultID, item, section, field string) ([]FieldVersion, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	k := fieldKey{vaultID, item, section, field}
+	if m.historyTrashed(k) {
+		return nil, nil
+	}
+	current := 0
+	if f, ok := m.fields[k]; ok {
+		current = f.Version
+	}
+	history := m.versions[k]
+
+	var versions []FieldVersion
+	for i := len(history) - 1; i >= 0; i-- {
+		v := history[i]
+		versions = append(versions, FieldVersion{
+			VaultID:   vaultID,
+			Item:      item,
+			Section:   section,
+			FieldName: field,
+			Version:   v.version,
+			Author:    v.author,
+			Current:   v.version == current,
+			CreatedAt: v.createdAt,
+		})
+	}
+	return versions, nil
+}
+
+// fieldVersion returns a retained version of a field. The caller holds the
+// lock.
+func (m *MemoryStore) fieldVersion(k fieldKey, version int) (memVersion, error) {
+	for _, v := range m.versions[k] {
+		if v.version == version {
+			return v, nil
+		}
+	}
+	return memVersion{}, fmt.Errorf("%w: %s@%d", ErrVersionNotFound, fieldPath(k.vaultID, k.item, k.section, k.field), version)
+}
+
+// historyTrashed reports whether the history of k is hidden because the field
+// or its vault is in the trash.
+func (m *MemoryStore) historyTrashed(k fieldKey) bool {
+	if f, ok := m.fields[k]; ok && f.deletedAt != nil {
+		return true
+	}
+	_, live := m.liveVault(k.vaultID)
+	return !live
+}
+
+// GetFieldVersionValue returns the value of a specific version of a field.
+// Versions of fields in the trash are reported as ErrVersionNotFound.
+func (m *MemoryStore) GetFieldVersionValue(vaultID, item, section, field string, version int) (string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	k := fieldKey{vaultID, item, section, field}
+	if m.historyTrashed(k) {
+		return "", fmt.Errorf("%w: %s@%d", ErrVersionNotFound, fieldPath(vaultID, item, section, field), version)
+	}
+	v, err := m.fieldVersion(k, version)
+	if err != nil {
+		return "", err
+	}
+	return v.value, nil
+}
+
+// RollbackField restores the value of an earlier version as a new version
+// attributed to author. Returns the new version number.
+func (m *MemoryStore) RollbackField(vaultID, item, section, field string, version int, author string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	k := fieldKey{vaultID, item, section, field}
+	v, err := m.fieldVersion(k, version)
+	if err != nil {
+		return 0, err
+	}
+	if err := m.checkNotTrashed(k); err != nil {
+		return 0, fmt.Errorf("rollback field: %w", err)
+	}
+	return m.upsertField(k, v.value, author), nil
+}
+
+// RegisterInstance inserts a new TEE instance. A FID or public key held by an
+// instance in the trash is reported as ErrInTrash.
+func (m *MemoryStore) RegisterInstance(inst *TEEInstance) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.registerInstance(inst)
+}
+
+// registerInstance inserts a new TEE instance. The caller holds the lock.
+func (m *MemoryStore) registerInstance(inst *TEEInstance) error {
+	if _, ok := m.aliases[inst.FID]; ok {
+		return ErrInstanceRetired
+	}
+	for _, other := range m.instances {
+		if other.DeletedAt != nil && (other.FID == inst.FID || bytes.Equal(other.PublicKey, inst.PublicKey)) {
+			return fmt.Errorf("instance %q is %w", other.FID, ErrInTrash)
+		}
+	}
+	if _, ok := m.instances[inst.FID]; ok {
+		return ErrInstanceDuplicateFID
+	}
+	for _, other := range m.instances {
+		if bytes.Equal(other.PublicKey, inst.PublicKey) {
+			return ErrInstanceDuplicateKey
+		}
+	}
+	stored := *inst
+	stored.PublicKey = bytes.Clone(inst.PublicKey)
+	stored.CreatedAt = now()
+	stored.LastUsedAt = nil
+	stored.Status = InstanceActive
+	stored.StatusReason = ""
+	stored.StatusChangedAt = nil
+	stored.DeletedAt = nil
+	m.instances[inst.FID] = &memInstance{TEEInstance: stored, seq: m.nextSeq()}
+	return nil
+}
+
+// instanceCopy returns a copy of a stored instance that callers may modify.
+func instanceCopy(inst *memInstance) TEEInstance {
+	out := inst.TEEInstance
+	out.PublicKey = bytes.Clone(inst.PublicKey)
+	if inst.LastUsedAt != nil {
+		t := *inst.LastUsedAt
+		out.LastUsedAt = &t
+	}
+	if inst.StatusChangedAt != nil {
+		t := *inst.StatusChangedAt
+		out.StatusChangedAt = &t
+	}
+	if inst.DeletedAt != nil {
+		t := *inst.DeletedAt
+		out.DeletedAt = &t
+	}
+	return out
+}
+
+// liveInstance returns an instance that is not in the trash. The caller holds
+// the lock.
+func (m *MemoryStore) liveInstance(fid string) (*memInstance, bool) {
+	inst, ok := m.instances[fid]
+	if !ok || inst.DeletedAt != nil {
+		return nil, false
+	}
+	return inst, true
+}
+
+// GetInstance retrieves a TEE instance by FID. Instances in the trash are not
+// returned.
+func (m *MemoryStore) GetInstance(fid string) (*TEEInstance, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	inst, ok := m.liveInstance(fid)
+	if !ok {
+		return nil, nil
+	}
+	out := instanceCopy(inst)
+	return &out, nil
+}
+
+// ListInstances returns all registered TEE instances outside the trash.
+func (m *MemoryStore) ListInstances() ([]TEEInstance, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.sortedInstances(func(inst *memInstance) bool { return inst.DeletedAt == nil }), nil
+}
+
+// sortedInstances returns copies of the instances matching match, ordered by
+// registration time. The caller holds the lock.
+func (m *MemoryStore) sortedInstances(match func(*memInstance) bool) []TEEInstance {
+	var sorted []*memInstance
+	for _, inst := range m.instances {
+		if match(inst) {
+			sorted = append(sorted, inst)
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].seq < sorted[j].seq })
+
+	var instances []TEEInstance
+	for _, inst := range sorted {
+		instances = append(instances, instanceCopy(inst))
+	}
+	return instances
+}
+
+// UpdateInstance updates dstack_app_id and label for a TEE instance.
+func (m *MemoryStore) UpdateInstance(fid, dstackAppID, label string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	inst, ok := m.liveInstance(fid)
+	if !ok {
+		return false, nil
+	}
+	inst.DstackAppID = dstackAppID
+	inst.Label = label
+	return true, nil
+}
+
+// SetInstanceStatus sets the status of a TEE instance with the admin's reason.
+func (m *MemoryStore) SetInstanceStatus(fid, status, reason string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	inst, ok := m.liveInstance(fid)
+	if !ok {
+		return false, nil
+	}
+	ts := now()
+	inst.Status, inst.StatusReason, inst.StatusChangedAt = status, reason, &ts
+	return true, nil
+}
+
+// UpdateLastUsed updates the last used timestamp for a TEE instance.
+func (m *MemoryStore) UpdateLastUsed(fid string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if inst, ok := m.liveInstance(fid); ok {
+		ts := now()
+		inst.LastUsedAt = &ts
+	}
+	return nil
+}
+
+// DeleteInstance moves a TEE instance to the trash. Its grants and debug
+// policies stay, hidden, until it is restored or purged.
+func (m *MemoryStore) DeleteInstance(fid string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	inst, ok := m.liveInstance(fid)
+	if !ok {
+		return false, nil
+	}
+	ts := now()
+	inst.DeletedAt = &ts
+	return true, nil
+}
+
+// RekeyInstance moves the instance fid to newFID and newPublicKey. It reports
+// false if there is no such instance outside the trash, and fails like
+// RegisterInstance if the new FID or key is taken.
+func (m *MemoryStore) RekeyInstance(fid, newFID string, newPublicKey []byte) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	old, ok := m.liveInstance(fid)
+	if !ok {
+		return false, nil
+	}
+	if err := m.registerInstance(&TEEInstance{FID: newFID, PublicKey: newPublicKey, DstackAppID: old.DstackAppID, Label: old.Label}); err != nil {
+		return false, err
+	}
+	moved := m.instances[newFID]
+	moved.CreatedAt = old.CreatedAt
+	moved.LastUsedAt = old.LastUsedAt
+	moved.Status, moved.StatusReason, moved.StatusChangedAt = old.Status, old.StatusReason, old.StatusChangedAt
+	moved.seq = old.seq
+	delete(m.instances, fid)
+
+	for k, a := range m.access {
+		if k.fid == fid {
+			delete(m.access, k)
+			a.FID = newFID
+			m.access[accessKey{k.vaultID, newFID}] = a
+		}
+	}
+	for k, g := range m.grants {
+		if k.fid == fid {
+			delete(m.grants, k)
+			g.FID = newFID
+			k.fid = newFID
+			m.grants[k] = g
+		}
+	}
+	for k, p := range m.policies {
+		if k.fid == fid {
+			delete(m.policies, k)
+			p.FID = newFID
+			m.policies[accessKey{k.vaultID, newFID}] = p
+		}
+	}
+	if p, ok := m.measures[measurementPolicyKey{MeasurementScopeInstance, fid}]; ok {
+		delete(m.measures, measurementPolicyKey{MeasurementScopeInstance, fid})
+		p.Target = newFID
+		m.measures[measurementPolicyKey{MeasurementScopeInstance, newFID}] = p
+	}
+	for _, a := range m.aliases {
+		if a.FID == fid {
+			a.FID = newFID
+		}
+	}
+	m.aliases[fid] = &InstanceAlias{AliasFID: fid, FID: newFID, CreatedAt: now()}
+	return true, nil
+}
+
+// ResolveInstanceAlias returns the current FID of the instance that was
+// re-keyed away from fid, or "" if fid is not an alias.
+func (m *MemoryStore) ResolveInstanceAlias(fid string) (string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if a, ok := m.aliases[fid]; ok {
+		return a.FID, nil
+	}
+	return "", nil
+}
+
+// ListInstanceAliases returns the previous FIDs of an instance, oldest first.
+func (m *MemoryStore) ListInstanceAliases(fid string) ([]InstanceAlias, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var aliases []InstanceAlias
+	for _, a := range m.aliases {
+		if a.FID == fid {
+			aliases = append(aliases, *a)
+		}
+	}
+	sortInstanceAliases(aliases)
+	return aliases, nil
+}
+
+// sortInstanceAliases orders aliases the way SQLStore lists them.
+func sortInstanceAliases(aliases []InstanceAlias) {
+	sort.Slice(aliases, func(i, j int) bool {
+		a, b := aliases[i], aliases[j]
+		return a.CreatedAt.Before(b.CreatedAt) || (a.CreatedAt.Equal(b.CreatedAt) && a.AliasFID < b.AliasFID)
+	})
+}
+
+// checkVaultInstance returns the foreign key error SQLStore reports when a
+// grant or policy references a missing vault or instance.
+func (m *MemoryStore) checkVaultInstance(vaultID, fid string) error {
+	if err := m.checkVault(vaultID); err != nil {
+		return err
+	}
+	if _, ok := m.instances[fid]; !ok {
+		return fmt.Errorf("instance %q does not exist", fid)
+	}
+	return nil
+}
+
+// GrantVaultAccess grants an instance access to a vault, valid from notBefore
+// until expiresAt when they are set. Granting again replaces the window.
+func (m *MemoryStore) GrantVaultAccess(vaultID, fid string, notBefore, expiresAt *time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.checkVaultInstance(vaultID, fid); err != nil {
+		return fmt.Errorf("grant vault access: %w", err)
+	}
+	k := accessKey{vaultID, fid}
+	a, ok := m.access[k]
+	if !ok {
+		a = &VaultAccess{VaultID: vaultID, FID: fid, CreatedAt: now()}
+		m.access[k] = a
+	}
+	a.NotBefore, a.ExpiresAt = optTime(notBefore), optTime(expiresAt)
+	return nil
+}
+
+// optTime returns a copy of an optional time at the precision the SQL stores
+// keep.
+func optTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	out := t.UTC().Truncate(time.Second)
+	return &out
+}
+
+// grantCopy returns a copy of a stored grant that callers may modify.
+func grantCopy(a *VaultAccess) VaultAccess {
+	out := *a
+	out.NotBefore, out.ExpiresAt = optTime(a.NotBefore), optTime(a.ExpiresAt)
+	return out
+}
+
+// activeGrant reports whether the instance holds a vault grant inside its
+// validity window.
+func (m *MemoryStore) activeGrant(vaultID, fid string) bool {
+	a, ok := m.access[accessKey{vaultID, fid}]
+	return ok && a.State(time.Now()) == GrantActive
+}
+
+// RevokeVaultAccess removes an instance's access to a vault.
+func (m *MemoryStore) RevokeVaultAccess(vaultID, fid string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	k := accessKey{vaultID, fid}
+	if _, ok := m.access[k]; !ok {
+		return false, nil
+	}
+	delete(m.access, k)
+	return true, nil
+}
+
+// HasVaultAccess checks if an instance has access to a vault. Grants are void
+// while either side is in the trash and outside their validity window.
+func (m *MemoryStore) HasVaultAccess(vaultID, fid string) (bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if _, ok := m.liveVault(vaultID); !ok {
+		return false, nil
+	}
+	if _, ok := m.liveInstance(fid); !ok {
+		return false, nil
+	}
+	return m.activeGrant(vaultID, fid), nil
+}
+
+// ListInstanceVaults returns vaults accessible by an instance, leaving out
+// vaults in the trash.
+func (m *MemoryStore) ListInstanceVaults(fid string) ([]Vault, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var sorted []*memVault
+	for k := range m.access {
+		if v, live := m.liveVault(k.vaultID); live && k.fid == fid {
+			sorted = append(sorted, v)
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].seq < sorted[j].seq })
+
+	var vaults []Vault
+	for _, v := range sorted {
+		vaults = append(vaults, v.Vault)
+	}
+	return vaults, nil
+}
+
+// ListVaultInstances returns instances granted access to a vault with their
+// grants, leaving out instances in the trash. Grants outside their validity
+// window are listed too; see VaultAccess.State.
+func (m *MemoryStore) ListVaultInstances(vaultID string) ([]VaultInstance, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var instances []VaultInstance
+	for _, inst := range m.sortedInstances(func(inst *memInstance) bool {
+		_, ok := m.access[accessKey{vaultID, inst.FID}]
+		return ok && inst.DeletedAt == nil
+	}) {
+		grant := grantCopy(m.access[accessKey{vaultID, inst.FID}])
+		instances = append(instances, VaultInstance{TEEInstance: inst, Grant: grant})
+	}
+	return instances, nil
+}
+
+// GrantAppAccess grants every attested instance of a dstack app access to a
+// vault, valid from notBefore until expiresAt when they are set. Granting
+// again replaces the window.
+func (m *MemoryStore) GrantAppAccess(vaultID, appID string, notBefore, expiresAt *time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.checkVault(vaultID); err != nil {
+		return fmt.Errorf("grant app access: %w", err)
+	}
+	k := appKey{vaultID, appID}
+	a, ok := m.apps[k]
+	if !ok {
+		a = &AppAccess{VaultID: vaultID, DstackAppID: appID, CreatedAt: now()}
+		m.apps[k] = a
+	}
+	a.NotBefore, a.ExpiresAt = optTime(notBefore), optTime(expiresAt)
+	return nil
+}
+
+// RevokeAppAccess removes a dstack app's access to a vault.
+func (m *MemoryStore) RevokeAppAccess(vaultID, appID string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	k := appKey{vaultID, appID}
+	if _, ok := m.apps[k]; !ok {
+		return false, nil
+	}
+	delete(m.apps, k)
+	return true, nil
+}
+
+// ListVaultApps returns the dstack apps granted access to a vault, including
+// grants outside their validity window. Nothing is listed while the vault is
+// in the trash.
+func (m *MemoryStore) ListVaultApps(vaultID string) ([]AppAccess, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if _, ok := m.liveVault(vaultID); !ok {
+		return nil, nil
+	}
+	var apps []AppAccess
+	for k, a := range m.apps {
+		if k.vaultID == vaultID {
+			apps = append(apps, appGrantCopy(a))
+		}
+	}
+	sortAppGrants(apps)
+	return apps, nil
+}
+
+// appGrantCopy returns a copy of a stored app grant that callers may modify.
+func appGrantCopy(a *AppAccess) AppAccess {
+	out := *a
+	out.NotBefore, out.ExpiresAt = optTime(a.NotBefore), optTime(a.ExpiresAt)
+	return out
+}
+
+// sortAppGrants orders app grants the way SQLStore lists them.
+func sortAppGrants(apps []AppAccess) {
+	sort.Slice(apps, func(i, j int) bool {
+		a, b := apps[i], apps[j]
+		return a.VaultID < b.VaultID || (a.VaultID == b.VaultID && a.DstackAppID < b.DstackAppID)
+	})
+}
+
+// PutEnrollmentPolicy creates or replaces the enrollment policy of a dstack
+// app. Every vault in p.Vaults must exist.
+func (m *MemoryStore) PutEnrollmentPolicy(p *EnrollmentPolicy) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, vaultID := range p.Vaults {
+		if err := m.checkVault(vaultID); err != nil {
+			return fmt.Errorf("add enrollment policy vault %q: %w", vaultID, err)
+		}
+	}
+	vaults := slices.Clone(p.Vaults)
+	slices.Sort(vaults)
+	vaults = slices.Compact(vaults)
+
+	ts := now()
+	stored, ok := m.enroll[p.DstackAppID]
+	if !ok {
+		stored = &EnrollmentPolicy{DstackAppID: p.DstackAppID, CreatedAt: ts}
+		m.enroll[p.DstackAppID] = stored
+	}
+	stored.Label, stored.Vaults, stored.UpdatedAt = p.Label, vaults, ts
+	return nil
+}
+
+// GetEnrollmentPolicy retrieves the enrollment policy of a dstack app. Vaults
+// in the trash are left out of the policy until they are restored.
+func (m *MemoryStore) GetEnrollmentPolicy(appID string) (*EnrollmentPolicy, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	p, ok := m.enroll[appID]
+	if !ok {
+		return nil, nil
+	}
+	out := m.enrollmentCopy(p)
+	return &out, nil
+}
+
+// ListEnrollmentPolicies returns all enrollment policies, ordered by app ID.
+func (m *MemoryStore) ListEnrollmentPolicies() ([]EnrollmentPolicy, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var policies []EnrollmentPolicy
+	for _, p := range m.enroll {
+		policies = append(policies, m.enrollmentCopy(p))
+	}
+	sortEnrollmentPolicies(policies)
+	return policies, nil
+}
+
+// enrollmentCopy returns a copy of a stored enrollment policy listing only
+// its live vaults. The caller holds the lock.
+func (m *MemoryStore) enrollmentCopy(p *EnrollmentPolicy) EnrollmentPolicy {
+	out := *p
+	out.Vaults = nil
+	for _, vaultID := range p.Vaults {
+		if _, ok := m.liveVault(vaultID); ok {
+			out.Vaults = append(out.Vaults, vaultID)
+		}
+	}
+	return out
+}
+
+// sortEnrollmentPolicies orders enrollment policies the way SQLStore lists
+// them.
+func sortEnrollmentPolicies(policies []EnrollmentPolicy) {
+	sort.Slice(policies, func(i, j int) bool { return policies[i].DstackAppID < policies[j].DstackAppID })
+}
+
+// DeleteEnrollmentPolicy removes the enrollment policy of a dstack app.
+// Instances it enrolled keep their registration and grants.
+func (m *MemoryStore) DeleteEnrollmentPolicy(appID string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.enroll[appID]; !ok {
+		return false, nil
+	}
+	delete(m.enroll, appID)
+	return true, nil
+}
+
+// EnrollInstance registers inst and grants it permanent access to vaultIDs in
+// one step. It fails like RegisterInstance if the FID or public key is taken.
+func (m *MemoryStore) EnrollInstance(inst *TEEInstance, vaultIDs []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, vaultID := range vaultIDs {
+		if err := m.checkVault(vaultID); err != nil {
+			return fmt.Errorf("grant vault %q: %w", vaultID, err)
+		}
+	}
+	if err := m.registerInstance(inst); err != nil {
+		return err
+	}
+	for _, vaultID := range vaultIDs {
+		k := accessKey{vaultID, inst.FID}
+		if _, ok := m.access[k]; !ok {
+			m.access[k] = &VaultAccess{VaultID: vaultID, FID: inst.FID, CreatedAt: now()}
+		}
+	}
+	return nil
+}
+
+// CreateEnrollmentToken stores a new enrollment token. Every vault in t.Vaults
+// must exist.
+func (m *MemoryStore) CreateEnrollmentToken(t *EnrollmentToken) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, vaultID := range t.Vaults {
+		if err := m.checkVault(vaultID); err != nil {
+			return fmt.Errorf("add enrollment token vault %q: %w", vaultID, err)
+		}
+	}
+	for _, other := range m.tokens {
+		if other.ID == t.ID || other.TokenHash == t.TokenHash {
+			return fmt.Errorf("create enrollment token: duplicate id or token hash")
+		}
+	}
+	stored := *t
+	stored.Vaults = slices.Clone(t.Vaults)
+	slices.Sort(stored.Vaults)
+	stored.Vaults = slices.Compact(stored.Vaults)
+	stored.Uses = 0
+	stored.ExpiresAt = t.ExpiresAt.UTC()
+	stored.CreatedAt = now()
+	m.tokens[t.ID] = &stored
+	return nil
+}
+
+// GetEnrollmentTokenByHash retrieves the enrollment token whose secret hashes
+// to tokenHash, whether or not it can still be redeemed.
+func (m *MemoryStore) GetEnrollmentTokenByHash(tokenHash string) (*EnrollmentToken, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, t := range m.tokens {
+		if t.TokenHash == tokenHash {
+			out := m.tokenCopy(t)
+			return &out, nil
+		}
+	}
+	return nil, nil
+}
+
+// ListEnrollmentTokens returns all enrollment tokens, oldest first, including
+// spent ones.
+func (m *MemoryStore) ListEnrollmentTokens() ([]EnrollmentToken, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var tokens []EnrollmentToken
+	for _, t := range m.tokens {
+		tokens = append(tokens, m.tokenCopy(t))
+	}
+	sortEnrollmentTokens(tokens)
+	return tokens, nil
+}
+
+// tokenCopy returns a copy of a stored enrollment token listing only its
+// live vaults. The caller holds the lock.
+func (m *MemoryStore) tokenCopy(t *EnrollmentToken) EnrollmentToken {
+	out := *t
+	out.Vaults = nil
+	for _, vaultID := range t.Vaults {
+		if _, ok := m.liveVault(vaultID); ok {
+			out.Vaults = append(out.Vaults, vaultID)
+		}
+	}
+	return out
+}
+
+// sortEnrollmentTokens orders enrollment tokens the way SQLStore lists them.
+func sortEnrollmentTokens(tokens []EnrollmentToken) {
+	sort.Slice(tokens, func(i, j int) bool {
+		a, b := tokens[i], tokens[j]
+		return a.CreatedAt.Before(b.CreatedAt) || (a.CreatedAt.Equal(b.CreatedAt) && a.ID < b.ID)
+	})
+}
+
+// DeleteEnrollmentToken revokes an enrollment token. Instances registered
+// with it keep their registration and grants.
+func (m *MemoryStore) DeleteEnrollmentToken(id string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.tokens[id]; !ok {
+		return false, nil
+	}
+	delete(m.tokens, id)
+	return true, nil
+}
+
+// RedeemEnrollmentToken uses up one use of the token, registers inst and
+// grants it permanent access to the token's live vaults. It returns
+// ErrEnrollmentTokenSpent if the token cannot be redeemed, and fails like
+// RegisterInstance, without using the token, if the FID or public key is
+// taken.
+func (m *MemoryStore) RedeemEnrollmentToken(id string, inst *TEEInstance) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t, ok := m.tokens[id]
+	if !ok || !t.Redeemable(time.Now()) {
+		return ErrEnrollmentTokenSpent
+	}
+	if err := m.registerInstance(inst); err != nil {
+		return err
+	}
+	t.Uses++
+	for _, vaultID := range m.tokenCopy(t).Vaults {
+		k := accessKey{vaultID, inst.FID}
+		if _, ok := m.access[k]; !ok {
+			m.access[k] = &VaultAccess{VaultID: vaultID, FID: inst.FID, CreatedAt: now()}
+		}
+	}
+	return nil
+}
+
+// PutFieldGrant creates a field grant or changes the effect of an existing
+// one with the same patterns.
+func (m *MemoryStore) PutFieldGrant(g *FieldGrant) error {
+	if err := ValidateFieldGrant(g); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.checkVaultInstance(g.VaultID, g.FID); err != nil {
+		return fmt.Errorf("put field grant: %w", err)
+	}
+	k := grantKey{g.VaultID, g.FID, g.Item, g.Section, g.FieldName}
+	if stored, ok := m.grants[k]; ok {
+		stored.Effect = g.Effect
+		return nil
+	}
+	stored := *g
+	stored.CreatedAt = now()
+	m.grants[k] = &stored
+	return nil
+}
+
+// DeleteFieldGrant removes the field grant with exactly these patterns.
+func (m *MemoryStore) DeleteFieldGrant(vaultID, fid, item, section, field string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	k := grantKey{vaultID, fid, item, section, field}
+	if _, ok := m.grants[k]; !ok {
+		return false, nil
+	}
+	delete(m.grants, k)
+	return true, nil
+}
+
+// ListFieldGrants returns the field grants on a vault, for one instance or,
+// with an empty fid, for all of them. Nothing is listed while the vault is in
+// the trash, nor for instances in the trash.
+func (m *MemoryStore) ListFieldGrants(vaultID, fid string) ([]FieldGrant, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.fieldGrants(vaultID, fid), nil
+}
+
+func (m *MemoryStore) fieldGrants(vaultID, fid string) []FieldGrant {
+	if _, ok := m.liveVault(vaultID); !ok {
+		return nil
+	}
+	var grants []FieldGrant
+	for k, g := range m.grants {
+		if _, live := m.liveInstance(k.fid); live && k.vaultID == vaultID && (fid == "" || k.fid == fid) {
+			grants = append(grants, *g)
+		}
+	}
+	sortFieldGrants(grants)
+	return grants
+}
+
+// sortFieldGrants orders grants the way SQLStore lists them.
+func sortFieldGrants(grants []FieldGrant) {
+	sort.Slice(grants, func(i, j int) bool {
+		a, b := grants[i], grants[j]
+		if a.VaultID != b.VaultID {
+			return a.VaultID < b.VaultID
+		}
+		if a.FID != b.FID {
+			return a.FID < b.FID
+		}
+		return fieldKey{"", a.Item, a.Section, a.FieldName}.less(fieldKey{"", b.Item, b.Section, b.FieldName})
+	})
+}
+
+// HasFieldAccess checks if an instance may read a field, applying its field
+// grants on top of its vault-wide grant. appID is the dstack app ID verified
+// by the instance's attestation, or empty when none was; a grant to that app
+// counts as a vault-wide grant.
+func (m *MemoryStore) HasFieldAccess(vaultID, fid, appID, item, section, field string) (bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if _, ok := m.liveVault(vaultID); !ok {
+		return false, nil
+	}
+	if _, ok := m.liveInstance(fid); !ok {
+		return false, nil
+	}
+	vaultWide := m.activeGrant(vaultID, fid)
+	if a, ok := m.apps[appKey{vaultID, appID}]; ok && appID != "" && a.State(time.Now()) == GrantActive {
+		vaultWide = true
+	}
+	return decideFieldAccess(m.fieldGrants(vaultID, fid), vaultWide, item, section, field), nil
+}
+
+// RecordAuditEvent appends an event to the audit log, setting its ID, time
+// (if unset) and chain hashes.
+func (m *MemoryStore) RecordAuditEvent(ev *AuditEvent) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if ev.Time.IsZero() {
+		ev.Time = now()
+	}
+	ev.Time = ev.Time.UTC().Truncate(time.Second)
+	ev.ID, ev.PrevHash = int64(len(m.audit))+1, ""
+	if len(m.audit) > 0 {
+		ev.PrevHash = m.audit[len(m.audit)-1].Hash
+	}
+	ev.Hash = AuditEventHash(ev)
+	stored := *ev
+	stored.Refs = append([]string(nil), ev.Refs...)
+	m.audit = append(m.audit, stored)
+	return nil
+}
+
+// ListAuditEvents returns the audit events matching q, newest first.
+func (m *MemoryStore) ListAuditEvents(q AuditQuery) ([]AuditEvent, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	// An instance's events include those recorded under the FIDs it was
+	// re-keyed away from.
+	var fids map[string]bool
+	if q.FID != "" {
+		fids = map[string]bool{q.FID: true}
+		for _, a := range m.aliases {
+			if a.FID == q.FID {
+				fids[a.AliasFID] = true
+			}
+		}
+		q.FID = ""
+	}
+
+	var out []AuditEvent
+	for i := len(m.audit) - 1; i >= 0; i-- {
+		if q.Limit > 0 && len(out) == q.Limit {
+			break
+		}
+		ev := m.audit[i]
+		if !auditMatches(&ev, q) || (fids != nil && !fids[ev.FID]) {
+			continue
+		}
+		ev.Refs = append([]string(nil), ev.Refs...)
+		out = append(out, ev)
+	}
+	return out, nil
+}
+
+// RecordAuditSignature stores a signed audit head. A head already signed is
+// left as it is.
+func (m *MemoryStore) RecordAuditSignature(sig *AuditSignature) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, s := range m.auditSigs {
+		if s.EventID == sig.EventID {
+			return nil
+		}
+	}
+	stored := *sig
+	stored.SignedAt = sig.SignedAt.UTC().Truncate(time.Second)
+	m.auditSigs = append(m.auditSigs, stored)
+	sort.Slice(m.auditSigs, func(i, j int) bool { return m.auditSigs[i].EventID < m.auditSigs[j].EventID })
+	return nil
+}
+
+// ListAuditSignatures returns every signed audit head, oldest first.
+func (m *MemoryStore) ListAuditSignatures() ([]AuditSignature, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return append([]AuditSignature(nil), m.auditSigs...), nil
+}
+
+// UpsertDebugPolicy inserts or updates a debug policy for a vault+instance pair.
+func (m *MemoryStore) UpsertDebugPolicy(vaultID, fid string, allow bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.checkVaultInstance(vaultID, fid); err != nil {
+		return fmt.Errorf("upsert debug policy: %w", err)
+	}
+	m.policies[accessKey{vaultID, fid}] = &DebugPolicy{
+		VaultID:   vaultID,
+		FID:       fid,
+		AllowRead: allow,
+		UpdatedAt: now(),
+	}
+	return nil
+}
+
+// GetDebugPolicy retrieves a debug policy. Returns nil if no policy exists.
+func (m *MemoryStore) GetDebugPolicy(vaultID, fid string) (*DebugPolicy, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	p, ok := m.policies[accessKey{vaultID, fid}]
+	if !ok {
+		return nil, nil
+	}
+	out := *p
+	return &out, nil
+}
+
+// PutMeasurementPolicy creates or replaces the measurement policy of a vault
+// or instance.
+func (m *MemoryStore) PutMeasurementPolicy(p *MeasurementPolicy) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ts := now()
+	key := measurementPolicyKey{p.Scope, p.Target}
+	stored, ok := m.measures[key]
+	if !ok {
+		stored = &MeasurementPolicy{Scope: p.Scope, Target: p.Target, CreatedAt: ts}
+		m.measures[key] = stored
+	}
+	stored.Allowed, stored.UpdatedAt = nil, ts
+	for name, values := range p.Allowed {
+		if len(values) == 0 {
+			continue
+		}
+		values = slices.Clone(values)
+		slices.Sort(values)
+		if stored.Allowed == nil {
+			stored.Allowed = map[string][]string{}
+		}
+		stored.Allowed[name] = slices.Compact(values)
+	}
+	return nil
+}
+
+// GetMeasurementPolicy retrieves the measurement policy of a vault or
+// instance.
+func (m *MemoryStore) GetMeasurementPolicy(scope, target string) (*MeasurementPolicy, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	p, ok := m.measures[measurementPolicyKey{scope, target}]
+	if !ok {
+		return nil, nil
+	}
+	out := measurementCopy(p)
+	return &out, nil
+}
+
+// ListMeasurementPolicies returns all measurement policies, ordered by scope
+// and target.
+func (m *MemoryStore) ListMeasurementPolicies() ([]MeasurementPolicy, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.measurementPolicies(func(*MeasurementPolicy) bool { return true }), nil
+}
+
+// ApplicableMeasurementPolicies returns the measurement policies a quote of
+// instance fid, running dstack app appID, must satisfy: the instance's own
+// and those of the live vaults it holds a vault, field or app grant on,
+// whether or not the grant is in its validity window.
+func (m *MemoryStore) ApplicableMeasurementPolicies(fid, appID string) ([]MeasurementPolicy, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.measurementPolicies(func(p *MeasurementPolicy) bool {
+		if p.Scope == MeasurementScopeInstance {
+			return p.Target == fid
+		}
+		if _, ok := m.liveVault(p.Target); !ok {
+			return false
+		}
+		if _, ok := m.access[accessKey{p.Target, fid}]; ok {
+			return true
+		}
+		if _, ok := m.apps[appKey{p.Target, appID}]; ok {
+			return true
+		}
+		for k, g := range m.grants {
+			if k.vaultID == p.Target && k.fid == fid && g.Effect == GrantAllow {
+				return true
+			}
+		}
+		return false
+	}), nil
+}
+
+// measurementPolicies returns copies of the measurement policies matching
+// match, in SQLStore order. The caller holds the lock.
+func (m *MemoryStore) measurementPolicies(match func(*MeasurementPolicy) bool) []MeasurementPolicy {
+	var policies []MeasurementPolicy
+	for _, p := range m.measures {
+		if match(p) {
+			policies = append(policies, measurementCopy(p))
+		}
+	}
+	sortMeasurementPolicies(policies)
+	return policies
+}
+
+// measurementCopy returns a deep copy of a measurement policy, leaving out
+// measurements without values as SQLStore does.
+func measurementCopy(p *MeasurementPolicy) MeasurementPolicy {
+	out := *p
+	out.Allowed = nil
+	for name, values := range p.Allowed {
+		if len(values) == 0 {
+			continue
+		}
+		if out.Allowed == nil {
+			out.Allowed = map[string][]string{}
+		}
+		out.Allowed[name] = slices.Clone(values)
+	}
+	return out
+}
+
+// sortMeasurementPolicies orders measurement policies the way SQLStore lists
+// them.
+func sortMeasurementPolicies(policies []MeasurementPolicy) {
+	sort.Slice(policies, func(i, j int) bool {
+		a, b := policies[i], policies[j]
+		return a.Scope < b.Scope || (a.Scope == b.Scope && a.Target < b.Target)
+	})
+}
+
+// DeleteMeasurementPolicy removes the measurement policy of a vault or
+// instance.
+func (m *MemoryStore) DeleteMeasurementPolicy(scope, target string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := measurementPolicyKey{scope, target}
+	if _, ok := m.measures[key]; !ok {
+		return false, nil
+	}
+	delete(m.measures, key)
+	return true, nil
+}
+
+// ListTrash returns everything in the trash, oldest first.
+func (m *MemoryStore) ListTrash() ([]TrashEntry, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var entries []TrashEntry
+	for _, v := range m.vaults {
+		if v.DeletedAt != nil {
+			entries = append(entries, TrashEntry{Kind: TrashVault, VaultID: v.ID, Name: v.Name, DeletedAt: *v.DeletedAt})
+		}
+	}
+	sections := map[fieldKey]*TrashEntry{}
+	for k, f := range m.fields {
+		if f.deletedAt == nil {
+			continue
+		}
+		sk := fieldKey{vaultID: k.vaultID, item: k.item, section: k.section}
+		if e, ok := sections[sk]; ok {
+			e.Fields++
+			continue
+		}
+		sections[sk] = &TrashEntry{Kind: TrashSection, VaultID: k.vaultID, Item: k.item, Section: k.section, Fields: 1, DeletedAt: *f.deletedAt}
+	}
+	for _, e := range sections {
+		entries = append(entries, *e)
+	}
+	for _, inst := range m.instances {
+		if inst.DeletedAt != nil {
+			entries = append(entries, TrashEntry{Kind: TrashInstance, FID: inst.FID, Name: inst.Label, DeletedAt: *inst.DeletedAt})
+		}
+	}
+	sortTrash(entries)
+	return entries, nil
+}
+
+// RestoreVault takes a vault out of the trash, together with its fields,
+// grants and policies. Sections trashed on their own stay in the trash.
+func (m *MemoryStore) RestoreVault(id string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	v, ok := m.vaults[id]
+	if !ok || v.DeletedAt == nil {
+		return false, nil
+	}
+	v.DeletedAt = nil
+	return true, nil
+}
+
+// RestoreItem takes every trashed section of an item out of the trash.
+func (m *MemoryStore) RestoreItem(vaultID, item string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.restoreFields(func(k fieldKey) bool {
+		return k.vaultID == vaultID && k.item == item
+	}), nil
+}
+
+// RestoreSection takes one section of an item out of the trash.
+func (m *MemoryStore) RestoreSection(vaultID, item, section string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.restoreFields(func(k fieldKey) bool {
+		return k.vaultID == vaultID && k.item == item && k.section == section
+	}), nil
+}
+
+func (m *MemoryStore) restoreFields(match func(fieldKey) bool) bool {
+	restored := false
+	for k, f := range m.fields {
+		if f.deletedAt != nil && match(k) {
+			f.deletedAt = nil
+			restored = true
+		}
+	}
+	return restored
+}
+
+// RestoreInstance takes an instance out of the trash, together with its
+// grants and policies.
+func (m *MemoryStore) RestoreInstance(fid string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	inst, ok := m.instances[fid]
+	if !ok || inst.DeletedAt == nil {
+		return false, nil
+	}
+	inst.DeletedAt = nil
+	return true, nil
+}
+
+// PurgeVault permanently deletes a trashed vault and everything in it.
+func (m *MemoryStore) PurgeVault(id string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	v, ok := m.vaults[id]
+	if !ok || v.DeletedAt == nil {
+		return false, nil
+	}
+	for k := range m.policies {
+		if k.vaultID == id {
+			delete(m.policies, k)
+		}
+	}
+	for k := range m.access {
+		if k.vaultID == id {
+			delete(m.access, k)
+		}
+	}
+	for k := range m.grants {
+		if k.vaultID == id {
+			delete(m.grants, k)
+		}
+	}
+	for k := range m.apps {
+		if k.vaultID == id {
+			delete(m.apps, k)
+		}
+	}
+	for _, p := range m.enroll {
+		p.Vaults = slices.DeleteFunc(p.Vaults, func(v string) bool { return v == id })
+	}
+	for _, t := range m.tokens {
+		t.Vaults = slices.DeleteFunc(t.Vaults, func(v string) bool { return v == id })
+	}
+	delete(m.measures, measurementPolicyKey{MeasurementScopeVault, id})
+	var keys []fieldKey
+	for k := range m.fields {
+		if k.vaultID == id {
+			keys = append(keys, k)
+		}
+	}
+	m.dropFields(keys)
+	for k := range m.versions {
+		if k.vaultID == id {
+			delete(m.versions, k)
+		}
+	}
+	for k := range m.expiries {
+		if k.vaultID == id {
+			delete(m.expiries, k)
+		}
+	}
+	delete(m.vaults, id)
+	return true, nil
+}
+
+// PurgeItem permanently deletes every trashed section of an item.
+func (m *MemoryStore) PurgeItem(vaultID, item string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.purgeFields(func(k fieldKey) bool {
+		return k.vaultID == vaultID && k.item == item
+	}), nil
+}
+
+// PurgeSection permanently deletes a trashed section of an item.
+func (m *MemoryStore) PurgeSection(vaultID, item, section string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.purgeFields(func(k fieldKey) bool {
+		return k.vaultID == vaultID && k.item == item && k.section == section
+	}), nil
+}
+
+func (m *MemoryStore) purgeFields(match func(fieldKey) bool) bool {
+	var keys []fieldKey
+	for k, f := range m.fields {
+		if f.deletedAt != nil && match(k) {
+			keys = append(keys, k)
+		}
+	}
+	m.dropFields(keys)
+	return len(keys) > 0
+}
+
+// PurgeInstance permanently deletes a trashed instance and its grants, debug
+// policies and measurement policy.
+func (m *MemoryStore) PurgeInstance(fid string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	inst, ok := m.instances[fid]
+	if !ok || inst.DeletedAt == nil {
+		return false, nil
+	}
+	for k := range m.policies {
+		if k.fid == fid {
+			delete(m.policies, k)
+		}
+	}
+	for k := range m.access {
+		if k.fid == fid {
+			delete(m.access, k)
+		}
+	}
+	for k := range m.grants {
+		if k.fid == fid {
+			delete(m.grants, k)
+		}
+	}
+	for k, a := range m.aliases {
+		if a.FID == fid {
+			delete(m.aliases, k)
+		}
+	}
+	delete(m.measures, measurementPolicyKey{MeasurementScopeInstance, fid})
+	delete(m.instances, fid)
+	return true, nil
+}
+
+// Snapshot copies the whole store under the read lock. The schema version is
+// reported as the latest, which the in-memory layout always matches.
+func (m *MemoryStore) Snapshot() (*Snapshot, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	snap := &Snapshot{SchemaVersion: LatestSchemaVersion()}
+
+	vaults := make([]*memVault, 0, len(m.vaults))
+	for _, v := range m.vaults {
+		vaults = append(vaults, v)
+	}
+	sort.Slice(vaults, func(i, j int) bool { return vaults[i].seq < vaults[j].seq })
+	for _, v := range vaults {
+		snap.Vaults = append(snap.Vaults, v.Vault)
+	}
+
+	for _, f := range m.fields {
+		snap.Fields = append(snap.Fields, SnapshotField{
+			VaultID:   f.VaultID,
+			Item:      f.Item,
+			Section:   f.Section,
+			FieldName: f.FieldName,
+			Version:   f.Version,
+			Value:     f.Value,
+			CreatedAt: f.CreatedAt,
+			UpdatedAt: f.UpdatedAt,
+			DeletedAt: f.deletedAt,
+		})
+	}
+	sort.Slice(snap.Fields, func(i, j int) bool {
+		a, b := snap.Fields[i], snap.Fields[j]
+		return fieldKey{a.VaultID, a.Item, a.Section, a.FieldName}.less(fieldKey{b.VaultID, b.Item, b.Section, b.FieldName})
+	})
+
+	for k, history := range m.versions {
+		for _, v := range history {
+			snap.Versions = append(snap.Versions, SnapshotVersion{
+				VaultID:   k.vaultID,
+				Item:      k.item,
+				Section:   k.section,
+				FieldName: k.field,
+				Version:   v.version,
+				Value:     v.value,
+				Author:    v.author,
+				CreatedAt: v.createdAt,
+			})
+		}
+	}
+	sort.Slice(snap.Versions, func(i, j int) bool {
+		a, b := snap.Versions[i], snap.Versions[j]
+		ka, kb := fieldKey{a.VaultID, a.Item, a.Section, a.FieldName}, fieldKey{b.VaultID, b.Item, b.Section, b.FieldName}
+		if ka != kb {
+			return ka.less(kb)
+		}
+		return a.Version < b.Version
+	})
+
+	for k, t := range m.expiries {
+		snap.Expiries = append(snap.Expiries, FieldExpiry{VaultID: k.vaultID, Item: k.item, Section: k.section, FieldName: k.field, ExpiresAt: t})
+	}
+	sort.Slice(snap.Expiries, func(i, j int) bool {
+		a, b := snap.Expiries[i], snap.Expiries[j]
+		return fieldKey{a.VaultID, a.Item, a.Section, a.FieldName}.less(fieldKey{b.VaultID, b.Item, b.Section, b.FieldName})
+	})
+
+	snap.Instances = m.sortedInstances(func(*memInstance) bool { return true })
+
+	for _, a := range m.access {
+		snap.Grants = append(snap.Grants, grantCopy(a))
+	}
+	sort.Slice(snap.Grants, func(i, j int) bool {
+		a, b := snap.Grants[i], snap.Grants[j]
+		return a.VaultID < b.VaultID || (a.VaultID == b.VaultID && a.FID < b.FID)
+	})
+
+	for _, g := range m.grants {
+		snap.FieldGrants = append(snap.FieldGrants, *g)
+	}
+	sortFieldGrants(snap.FieldGrants)
+
+	for _, a := range m.apps {
+		snap.AppGrants = append(snap.AppGrants, appGrantCopy(a))
+	}
+	sortAppGrants(snap.AppGrants)
+
+	for _, p := range m.policies {
+		snap.DebugPolicies = append(snap.DebugPolicies, *p)
+	}
+	sort.Slice(snap.DebugPolicies, func(i, j int) bool {
+		a, b := snap.DebugPolicies[i], snap.DebugPolicies[j]
+		return a.VaultID < b.VaultID || (a.VaultID == b.VaultID && a.FID < b.FID)
+	})
+
+	for _, p := range m.enroll {
+		stored := *p
+		stored.Vaults = slices.Clone(p.Vaults)
+		snap.EnrollmentPolicies = append(snap.EnrollmentPolicies, stored)
+	}
+	sortEnrollmentPolicies(snap.EnrollmentPolicies)
+
+	for _, t := range m.tokens {
+		stored := *t
+		stored.Vaults = slices.Clone(t.Vaults)
+		snap.EnrollmentTokens = append(snap.EnrollmentTokens, stored)
+	}
+	sortEnrollmentTokens(snap.EnrollmentTokens)
+
+	for _, a := range m.aliases {
+		snap.InstanceAliases = append(snap.InstanceAliases, *a)
+	}
+	sortInstanceAliases(snap.InstanceAliases)
+
+	snap.MeasurementPolicies = m.measurementPolicies(func(*MeasurementPolicy) bool { return true })
+
+	return snap, nil
+}
+
+// Restore replaces the entire contents of the store with snap.
+func (m *MemoryStore) Restore(snap *Snapshot) error {
+	if snap.SchemaVersion > LatestSchemaVersion() {
+		return fmt.Errorf("%w: snapshot schema version %d, latest known %d", ErrSchemaTooNew, snap.SchemaVersion, LatestSchemaVersion())
+	}
+	if err := snap.Validate(); err != nil {
+		return fmt.Errorf("invalid snapshot: %w", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.vaults = map[string]*memVault{}
+	m.fields = map[fieldKey]*memField{}
+	m.versions = map[fieldKey][]memVersion{}
+	m.expiries = map[fieldKey]time.Time{}
+	m.instances = map[string]*memInstance{}
+	m.access = map[accessKey]*VaultAccess{}
+	m.grants = map[grantKey]*FieldGrant{}
+	m.apps = map[appKey]*AppAccess{}
+	m.policies = map[accessKey]*DebugPolicy{}
+	m.enroll = map[string]*EnrollmentPolicy{}
+	m.tokens = map[string]*EnrollmentToken{}
+	m.aliases = map[string]*InstanceAlias{}
+	m.measures = map[measurementPolicyKey]*MeasurementPolicy{}
+
+	for _, v := range snap.Vaults {
+		m.vaults[v.ID] = &memVault{Vault: v, seq: m.nextSeq()}
+	}
+	for _, f := range snap.Fields {
+		stored := &memField{
+			VaultItem: VaultItem{
+				VaultID:   f.VaultID,
+				Item:      f.Item,
+				Section:   f.Section,
+				FieldName: f.FieldName,
+				Value:     f.Value,
+				Version:   f.Version,
+				CreatedAt: f.CreatedAt,
+				UpdatedAt: f.UpdatedAt,
+			},
+			seq:       m.nextSeq(),
+			deletedAt: f.DeletedAt,
+		}
+		stored.ID = stored.seq
+		m.fields[fieldKey{f.VaultID, f.Item, f.Section, f.FieldName}] = stored
+	}
+	for _, v := range snap.Versions {
+		k := fieldKey{v.VaultID, v.Item, v.Section, v.FieldName}
+		m.versions[k] = append(m.versions[k], memVersion{version: v.Version, value: v.Value, author: v.Author, createdAt: v.CreatedAt})
+	}
+	for k, history := range m.versions {
+		sort.Slice(history, func(i, j int) bool { return history[i].version < history[j].version })
+		m.versions[k] = history
+	}
+	for _, e := range snap.Expiries {
+		m.expiries[fieldKey{e.VaultID, e.Item, e.Section, e.FieldName}] = e.ExpiresAt
+	}
+	for _, inst := range snap.Instances {
+		stored := instanceCopy(&memInstance{TEEInstance: inst})
+		stored.Status = restoredStatus(inst.Status)
+		m.instances[inst.FID] = &memInstance{TEEInstance: stored, seq: m.nextSeq()}
+	}
+	for _, g := range snap.Grants {
+		stored := grantCopy(&g)
+		m.access[accessKey{g.VaultID, g.FID}] = &stored
+	}
+	for _, g := range snap.FieldGrants {
+		stored := g
+		m.grants[grantKey{g.VaultID, g.FID, g.Item, g.Section, g.FieldName}] = &stored
+	}
+	for _, g := range snap.AppGrants {
+		stored := appGrantCopy(&g)
+		m.apps[appKey{g.VaultID, g.DstackAppID}] = &stored
+	}
+	for _, p := range snap.DebugPolicies {
+		stored := p
+		m.policies[accessKey{p.VaultID, p.FID}] = &stored
+	}
+	for _, p := range snap.EnrollmentPolicies {
+		stored := p
+		stored.Vaults = slices.Clone(p.Vaults)
+		slices.Sort(stored.Vaults)
+		m.enroll[p.DstackAppID] = &stored
+	}
+	for _, t := range snap.EnrollmentTokens {
+		stored := t
+		stored.Vaults = slices.Clone(t.Vaults)
+		slices.Sort(stored.Vaults)
+		m.tokens[t.ID] = &stored
+	}
+	for _, a := range snap.InstanceAliases {
+		stored := a
+		m.aliases[a.AliasFID] = &stored
+	}
+	for _, p := range snap.MeasurementPolicies {
+		stored := measurementCopy(&p)
+		for _, values := range stored.Allowed {
+			slices.Sort(values)
+		}
+		m.measures[measurementPolicyKey{p.Scope, p.Target}] = &stored
+	}
+	return nil
+}
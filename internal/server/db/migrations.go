@@ -0,0 +1,923 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// The schema is changed by an ordered list of migrations. Applied versions
+// are recorded in schema_migrations; each step runs in its own transaction
+// together with its bookkeeping row, so a failed step leaves the database at
+// the previous version.
+
+// ErrSchemaTooNew is returned when the database has migrations applied that
+// this binary does not know about, i.e. it was last opened by a newer release.
+var ErrSchemaTooNew = errors.New("database schema is newer than this binary")
+
+type migration struct {
+	version int
+	name    string
+	up      func(s *SQLStore, tx *dialectTx) error
+	down    func(s *SQLStore, tx *dialectTx) error // nil if the step cannot be reverted
+}
+
+// migrations lists every schema change in order, as applied to SQLite. Append
+// new steps at the end and never renumber or edit released ones; every step
+// needs a counterpart with the same version and name in postgresMigrations.
+var migrations = []migration{
+	{version: 1, name: "baseline", up: (*SQLStore).migrateBaseline},
+	{version: 2, name: "item_sections", up: (*SQLStore).migrateItemSections, down: (*SQLStore).revertItemSections},
+	{version: 3, name: "field_expiries", up: (*SQLStore).migrateFieldExpiries, down: (*SQLStore).revertFieldExpiries},
+	{version: 4, name: "trash", up: (*SQLStore).migrateTrash, down: (*SQLStore).revertTrash},
+	{version: 5, name: "audit_events", up: (*SQLStore).migrateAuditEvents, down: (*SQLStore).revertAuditEvents},
+	{version: 6, name: "audit_chain", up: (*SQLStore).migrateAuditChain, down: (*SQLStore).revertAuditChain},
+	{version: 7, name: "field_grants", up: (*SQLStore).migrateFieldGrants, down: (*SQLStore).revertFieldGrants},
+	{version: 8, name: "grant_windows", up: (*SQLStore).migrateGrantWindows, down: (*SQLStore).revertGrantWindows},
+	{version: 9, name: "app_grants", up: (*SQLStore).migrateAppGrants, down: (*SQLStore).revertAppGrants},
+	{version: 10, name: "enrollment_policies", up: (*SQLStore).migrateEnrollmentPolicies, down: (*SQLStore).revertEnrollmentPolicies},
+	{version: 11, name: "enrollment_tokens", up: (*SQLStore).migrateEnrollmentTokens, down: (*SQLStore).revertEnrollmentTokens},
+	{version: 12, name: "instance_aliases", up: (*SQLStore).migrateInstanceAliases, down: (*SQLStore).revertInstanceAliases},
+	{version: 13, name: "instance_status", up: (*SQLStore).migrateInstanceStatus, down: (*SQLStore).revertInstanceStatus},
+	{version: 14, name: "measurement_policies", up: (*SQLStore).migrateMeasurementPolicies, down: (*SQLStore).revertMeasurementPolicies},
+}
+
+// LatestSchemaVersion returns the schema version this binary migrates to.
+func LatestSchemaVersion() int {
+	return migrations[len(migrations)-1].version
+}
+
+// SchemaVersion returns the highest migration version applied to the
+// database, or 0 for an empty database.
+func (s *SQLStore) SchemaVersion() (int, error) {
+	if err := s.ensureMigrationsTable(); err != nil {
+		return 0, err
+	}
+	var version int
+	if err := s.db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version); err != nil {
+		return 0, fmt.Errorf("read schema version: %w", err)
+	}
+	return version, nil
+}
+
+// MigrationStatus returns every known migration with its applied time, plus
+// any applied migration unknown to this binary (with an empty name).
+func (s *SQLStore) MigrationStatus() ([]SchemaMigration, error) {
+	if err := s.ensureMigrationsTable(); err != nil {
+		return nil, err
+	}
+	rows, err := s.db.Query(`SELECT version, name, applied_at FROM schema_migrations ORDER BY version`)
+	if err != nil {
+		return nil, fmt.Errorf("list schema migrations: %w", err)
+	}
+	defer rows.Close()
+
+	applied := map[int]time.Time{}
+	var unknown []SchemaMigration
+	for rows.Next() {
+		var m SchemaMigration
+		var at time.Time
+		if err := rows.Scan(&m.Version, &m.Name, &at); err != nil {
+			return nil, fmt.Errorf("scan schema migration: %w", err)
+		}
+		applied[m.Version] = at
+		if m.Version > LatestSchemaVersion() {
+			m.Name = ""
+			m.AppliedAt = &at
+			unknown = append(unknown, m)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var out []SchemaMigration
+	for _, m := range s.dialect.migrations {
+		sm := SchemaMigration{Version: m.version, Name: m.name, Reversible: m.down != nil}
+		if at, ok := applied[m.version]; ok {
+			sm.AppliedAt = &at
+		}
+		out = append(out, sm)
+	}
+	return append(out, unknown...), nil
+}
+
+// MigrateUp applies pending migrations up to and including target. It returns
+// the number of migrations applied.
+func (s *SQLStore) MigrateUp(target int) (int, error) {
+	current, err := s.checkedSchemaVersion()
+	if err != nil {
+		return 0, err
+	}
+	if target > LatestSchemaVersion() {
+		return 0, fmt.Errorf("unknown schema version %d (latest is %d)", target, LatestSchemaVersion())
+	}
+
+	n := 0
+	for _, m := range s.dialect.migrations {
+		if m.version <= current || m.version > target {
+			continue
+		}
+		if err := s.runMigration(m, true); err != nil {
+			return n, err
+		}
+		n++
+		if s.plaintextDropped {
+			s.plaintextDropped = false
+			if err := s.scrubFreePages(); err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// MigrateDown reverts applied migrations newer than target, newest first. It
+// returns the number of migrations reverted. Steps that cannot be reverted,
+// such as the baseline, stop the downgrade with an error.
+func (s *SQLStore) MigrateDown(target int) (int, error) {
+	current, err := s.checkedSchemaVersion()
+	if err != nil {
+		return 0, err
+	}
+	if target < 0 {
+		return 0, fmt.Errorf("schema version must not be negative, got %d", target)
+	}
+
+	n := 0
+	for i := len(s.dialect.migrations) - 1; i >= 0; i-- {
+		m := s.dialect.migrations[i]
+		if m.version > current || m.version <= target {
+			continue
+		}
+		if m.down == nil {
+			return n, fmt.Errorf("migration %d (%s) cannot be reverted", m.version, m.name)
+		}
+		if err := s.runMigration(m, false); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// checkedSchemaVersion returns the current schema version, or ErrSchemaTooNew
+// if a newer binary has migrated the database.
+func (s *SQLStore) checkedSchemaVersion() (int, error) {
+	current, err := s.SchemaVersion()
+	if err != nil {
+		return 0, err
+	}
+	if current > LatestSchemaVersion() {
+		return 0, fmt.Errorf("%w: database is at version %d, this binary supports up to %d",
+			ErrSchemaTooNew, current, LatestSchemaVersion())
+	}
+	return current, nil
+}
+
+// runMigration applies (up) or reverts (down) a single migration in one
+// transaction, started the way the dialect requires for schema changes and
+// checked by it before the transaction commits.
+func (s *SQLStore) runMigration(m migration, up bool) error {
+	action, step := "apply", m.up
+	if !up {
+		action, step = "revert", m.down
+	}
+
+	tx, release, err := s.dialect.beginMigration(s)
+	if err != nil {
+		return fmt.Errorf("begin migration %d: %w", m.version, err)
+	}
+	defer release()
+	defer tx.Rollback()
+
+	// Another server sharing the database may have run this step while we
+	// waited for the migration lock.
+	var applied int
+	if err := tx.QueryRow(
+		`SELECT COUNT(*) FROM schema_migrations WHERE version = ?`, m.version,
+	).Scan(&applied); err != nil {
+		return fmt.Errorf("check migration %d: %w", m.version, err)
+	}
+	if (applied > 0) == up {
+		return nil
+	}
+
+	if err := step(s, tx); err != nil {
+		return fmt.Errorf("%s migration %d (%s): %w", action, m.version, m.name, err)
+	}
+	if err := s.dialect.checkMigration(tx); err != nil {
+		return fmt.Errorf("%s migration %d (%s): %w", action, m.version, m.name, err)
+	}
+
+	if up {
+		_, err = tx.Exec(`INSERT INTO schema_migrations (version, name) VALUES (?, ?)`, m.version, m.name)
+	} else {
+		_, err = tx.Exec(`DELETE FROM schema_migrations WHERE version = ?`, m.version)
+	}
+	if err != nil {
+		return fmt.Errorf("record migration %d: %w", m.version, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit migration %d: %w", m.version, err)
+	}
+	return nil
+}
+
+// beginSQLiteMigration starts a migration transaction with foreign keys
+// disabled, as SQLite requires for table rebuilds. checkForeignKeys verifies
+// them before commit instead.
+func beginSQLiteMigration(s *SQLStore) (*dialectTx, func(), error) {
+	// Pin a single connection so PRAGMA foreign_keys=OFF and the transaction
+	// are guaranteed to execute on the same connection.
+	ctx := context.Background()
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("pin connection: %w", err)
+	}
+	if _, err := conn.ExecContext(ctx, "PRAGMA foreign_keys=OFF"); err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("disable foreign keys: %w", err)
+	}
+	release := func() {
+		conn.ExecContext(ctx, "PRAGMA foreign_keys=ON")
+		conn.Close()
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		release()
+		return nil, nil, err
+	}
+	return &dialectTx{Tx: tx, d: s.dialect}, release, nil
+}
+
+// checkForeignKeys fails if any row violates a foreign key constraint.
+func checkForeignKeys(tx *dialectTx) error {
+	rows, err := tx.Query(`PRAGMA foreign_key_check`)
+	if err != nil {
+		return fmt.Errorf("check foreign keys: %w", err)
+	}
+	defer rows.Close()
+	if rows.Next() {
+		var table, parent string
+		var rowid sql.NullInt64
+		var fkid int
+		if err := rows.Scan(&table, &rowid, &parent, &fkid); err != nil {
+			return fmt.Errorf("scan foreign key violation: %w", err)
+		}
+		return fmt.Errorf("foreign key violation: %s row %d references missing %s", table, rowid.Int64, parent)
+	}
+	return rows.Err()
+}
+
+// ensureMigrationsTable creates schema_migrations and records the versions
+// already present in databases created before migrations were tracked.
+func (s *SQLStore) ensureMigrationsTable() error {
+	if _, err := s.db.Exec(s.dialect.migrationsTable); err != nil {
+		return fmt.Errorf("create schema_migrations: %w", err)
+	}
+
+	var count int
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM schema_migrations`).Scan(&count); err != nil {
+		return fmt.Errorf("count schema migrations: %w", err)
+	}
+	if count > 0 || s.dialect.adoptUntracked == nil {
+		return nil
+	}
+	return s.dialect.adoptUntracked(s)
+}
+
+const sqliteMigrationsTable = `CREATE TABLE IF NOT EXISTS schema_migrations (
+	version INTEGER PRIMARY KEY,
+	name TEXT NOT NULL,
+	applied_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+)`
+
+// adoptUntrackedSQLite records the schema version of SQLite databases created
+// before migrations were tracked.
+func adoptUntrackedSQLite(s *SQLStore) error {
+	// Untracked databases are upgraded by the baseline step, which detects
+	// older layouts by itself. The one layout it cannot take is the sectioned
+	// item tables, which were released just before migrations were tracked.
+	sectioned, err := columnExists(s.db, "vault_items", "item")
+	if err != nil {
+		return err
+	}
+	if !sectioned {
+		return nil
+	}
+	for _, m := range migrations[:2] {
+		if _, err := s.db.Exec(
+			`INSERT INTO schema_migrations (version, name) VALUES (?, ?)`, m.version, m.name,
+		); err != nil {
+			return fmt.Errorf("record existing schema version: %w", err)
+		}
+	}
+	return nil
+}
+
+// vaultItemsColumns is the vault_items table definition. Fields are keyed by
+// item, section ("" for the item's default section) and field name.
+const vaultItemsColumns = `
+	rowid INTEGER PRIMARY KEY AUTOINCREMENT,
+	vault_id TEXT NOT NULL,
+	item TEXT NOT NULL,
+	section TEXT NOT NULL DEFAULT '',
+	field_name TEXT NOT NULL,
+	ciphertext BLOB NOT NULL,
+	wrapped_key BLOB NOT NULL,
+	key_id TEXT NOT NULL,
+	version INTEGER NOT NULL DEFAULT 1,
+	created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	UNIQUE(vault_id, item, section, field_name),
+	FOREIGN KEY (vault_id) REFERENCES vaults(id)
+`
+
+// vaultItemVersionsColumns is the vault_item_versions table definition.
+const vaultItemVersionsColumns = `
+	rowid INTEGER PRIMARY KEY AUTOINCREMENT,
+	vault_id TEXT NOT NULL,
+	item TEXT NOT NULL,
+	section TEXT NOT NULL DEFAULT '',
+	field_name TEXT NOT NULL,
+	version INTEGER NOT NULL,
+	ciphertext BLOB NOT NULL,
+	wrapped_key BLOB NOT NULL,
+	key_id TEXT NOT NULL,
+	author TEXT NOT NULL DEFAULT '',
+	created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	UNIQUE(vault_id, item, section, field_name, version),
+	FOREIGN KEY (vault_id) REFERENCES vaults(id)
+`
+
+const (
+	vaultItemsDataColumns        = "ciphertext, wrapped_key, key_id, version, created_at, updated_at"
+	vaultItemVersionsDataColumns = "version, ciphertext, wrapped_key, key_id, author, created_at"
+)
+
+// migrateItemSections splits the baseline field tables, which stored the item
+// name in a column called section and the field name in item_name, into
+// item/section/field_name. Every existing field moves to its item's default
+// section. The encryption AAD of the default section is unchanged, so values
+// are copied without re-encryption.
+func (s *SQLStore) migrateItemSections(tx *dialectTx) error {
+	if err := rebuildTable(tx, "vault_items", vaultItemsColumns,
+		"vault_id, item, section, field_name, "+vaultItemsDataColumns,
+		"vault_id, section, '', item_name, "+vaultItemsDataColumns); err != nil {
+		return err
+	}
+	return rebuildTable(tx, "vault_item_versions", vaultItemVersionsColumns,
+		"vault_id, item, section, field_name, "+vaultItemVersionsDataColumns,
+		"vault_id, section, '', item_name, "+vaultItemVersionsDataColumns)
+}
+
+// revertItemSections restores the baseline field tables. It refuses while any
+// field lives in a named section, since the baseline layout cannot hold it.
+func (s *SQLStore) revertItemSections(tx *dialectTx) error {
+	if err := checkNoNamedSections(tx); err != nil {
+		return err
+	}
+
+	if err := rebuildTable(tx, "vault_items", unsectionedItemsColumns,
+		"vault_id, section, item_name, "+vaultItemsDataColumns,
+		"vault_id, item, field_name, "+vaultItemsDataColumns); err != nil {
+		return err
+	}
+	return rebuildTable(tx, "vault_item_versions", unsectionedItemVersionsColumns,
+		"vault_id, section, item_name, "+vaultItemVersionsDataColumns,
+		"vault_id, item, field_name, "+vaultItemVersionsDataColumns)
+}
+
+// checkNoNamedSections fails if any current or historical field value lives
+// in a named section, which the baseline layout cannot hold.
+func checkNoNamedSections(tx *dialectTx) error {
+	var named int
+	if err := tx.QueryRow(
+		`SELECT (SELECT COUNT(*) FROM vault_items WHERE section <> '') +
+		        (SELECT COUNT(*) FROM vault_item_versions WHERE section <> '')`,
+	).Scan(&named); err != nil {
+		return fmt.Errorf("count sectioned fields: %w", err)
+	}
+	if named > 0 {
+		return fmt.Errorf("%d field values are stored in named sections; move or delete them first", named)
+	}
+	return nil
+}
+
+// migrateFieldExpiries adds the field_expiries table. A row with an empty
+// field_name sets the expiry of a whole section.
+func (s *SQLStore) migrateFieldExpiries(tx *dialectTx) error {
+	if _, err := tx.Exec(`CREATE TABLE IF NOT EXISTS field_expiries (
+		vault_id TEXT NOT NULL,
+		item TEXT NOT NULL,
+		section TEXT NOT NULL DEFAULT '',
+		field_name TEXT NOT NULL DEFAULT '',
+		expires_at DATETIME NOT NULL,
+		PRIMARY KEY (vault_id, item, section, field_name),
+		FOREIGN KEY (vault_id) REFERENCES vaults(id)
+	)`); err != nil {
+		return fmt.Errorf("create field_expiries: %w", err)
+	}
+	return nil
+}
+
+// revertFieldExpiries drops the field_expiries table; expiry dates are lost.
+func (s *SQLStore) revertFieldExpiries(tx *dialectTx) error {
+	if _, err := tx.Exec(`DROP TABLE field_expiries`); err != nil {
+		return fmt.Errorf("drop field_expiries: %w", err)
+	}
+	return nil
+}
+
+// trashTables are the tables whose rows are moved to the trash by a
+// deleted_at timestamp instead of being deleted outright.
+var trashTables = []string{"vaults", "vault_items", "tee_instances"}
+
+// migrateTrash adds the deleted_at column that marks trashed rows.
+func (s *SQLStore) migrateTrash(tx *dialectTx) error {
+	return addTrashColumns(s, tx, "DATETIME")
+}
+
+// addTrashColumns adds a nullable deleted_at column of the given type to
+// every trash table that lacks one.
+func addTrashColumns(s *SQLStore, tx *dialectTx, columnType string) error {
+	for _, table := range trashTables {
+		has, err := s.dialect.columnExists(tx, table, "deleted_at")
+		if err != nil {
+			return err
+		}
+		if has {
+			continue
+		}
+		if _, err := tx.Exec(`ALTER TABLE ` + table + ` ADD COLUMN deleted_at ` + columnType); err != nil {
+			return fmt.Errorf("add %s.deleted_at: %w", table, err)
+		}
+	}
+	return nil
+}
+
+// revertTrash drops the deleted_at columns. It refuses while anything is in
+// the trash, since the rows would come back to life.
+func (s *SQLStore) revertTrash(tx *dialectTx) error {
+	var trashed int
+	if err := tx.QueryRow(
+		`SELECT (SELECT COUNT(*) FROM vaults WHERE deleted_at IS NOT NULL) +
+		        (SELECT COUNT(*) FROM vault_items WHERE deleted_at IS NOT NULL) +
+		        (SELECT COUNT(*) FROM tee_instances WHERE deleted_at IS NOT NULL)`,
+	).Scan(&trashed); err != nil {
+		return fmt.Errorf("count trashed rows: %w", err)
+	}
+	if trashed > 0 {
+		return fmt.Errorf("%d rows are in the trash; restore or purge them first", trashed)
+	}
+	for _, table := range trashTables {
+		if _, err := tx.Exec(`ALTER TABLE ` + table + ` DROP COLUMN deleted_at`); err != nil {
+			return fmt.Errorf("drop %s.deleted_at: %w", table, err)
+		}
+	}
+	return nil
+}
+
+// migrateAuditEvents adds the audit_events table. Events keep the IDs of the
+// vaults and instances they refer to after those are purged, so there are no
+// foreign keys.
+func (s *SQLStore) migrateAuditEvents(tx *dialectTx) error {
+	return createAuditEvents(tx, "INTEGER PRIMARY KEY AUTOINCREMENT", "DATETIME")
+}
+
+// createAuditEvents creates the audit_events table with the given types for
+// its id and created_at columns.
+func createAuditEvents(tx *dialectTx, idType, timeType string) error {
+	if _, err := tx.Exec(`CREATE TABLE IF NOT EXISTS audit_events (
+		id ` + idType + `,
+		created_at ` + timeType + ` NOT NULL,
+		action TEXT NOT NULL,
+		outcome TEXT NOT NULL,
+		status INTEGER NOT NULL DEFAULT 0,
+		actor TEXT NOT NULL DEFAULT '',
+		fid TEXT NOT NULL DEFAULT '',
+		vault_id TEXT NOT NULL DEFAULT '',
+		target TEXT NOT NULL DEFAULT '',
+		refs TEXT NOT NULL DEFAULT '',
+		command TEXT NOT NULL DEFAULT '',
+		source_ip TEXT NOT NULL DEFAULT '',
+		app_id TEXT NOT NULL DEFAULT '',
+		error TEXT NOT NULL DEFAULT ''
+	)`); err != nil {
+		return fmt.Errorf("create audit_events: %w", err)
+	}
+	if _, err := tx.Exec(`CREATE INDEX IF NOT EXISTS idx_audit_events_created_at ON audit_events (created_at)`); err != nil {
+		return fmt.Errorf("create audit_events index: %w", err)
+	}
+	return nil
+}
+
+// revertAuditEvents drops the audit_events table; the audit log is lost.
+func (s *SQLStore) revertAuditEvents(tx *dialectTx) error {
+	if _, err := tx.Exec(`DROP TABLE audit_events`); err != nil {
+		return fmt.Errorf("drop audit_events: %w", err)
+	}
+	return nil
+}
+
+// migrateAuditChain adds the audit hash chain and signed heads.
+func (s *SQLStore) migrateAuditChain(tx *dialectTx) error {
+	return addAuditChain(s, tx, "INTEGER", "DATETIME")
+}
+
+// addAuditChain adds the prev_hash and hash columns to audit_events, chains
+// the events already recorded in ID order, and creates audit_signatures with
+// the given column types.
+func addAuditChain(s *SQLStore, tx *dialectTx, idType, timeType string) error {
+	for _, column := range []string{"prev_hash", "hash"} {
+		has, err := s.dialect.columnExists(tx, "audit_events", column)
+		if err != nil {
+			return err
+		}
+		if has {
+			continue
+		}
+		if _, err := tx.Exec(`ALTER TABLE audit_events ADD COLUMN ` + column + ` TEXT NOT NULL DEFAULT ''`); err != nil {
+			return fmt.Errorf("add audit_events.%s: %w", column, err)
+		}
+	}
+
+	rows, err := tx.Query(`SELECT ` + auditColumns + ` FROM audit_events ORDER BY id`)
+	if err != nil {
+		return fmt.Errorf("read audit events: %w", err)
+	}
+	events, err := scanAuditEvents(rows)
+	if err != nil {
+		return err
+	}
+	prev := ""
+	for i := range events {
+		ev := &events[i]
+		ev.PrevHash = prev
+		ev.Hash = AuditEventHash(ev)
+		if _, err := tx.Exec(`UPDATE audit_events SET prev_hash = ?, hash = ? WHERE id = ?`, ev.PrevHash, ev.Hash, ev.ID); err != nil {
+			return fmt.Errorf("chain audit event %d: %w", ev.ID, err)
+		}
+		prev = ev.Hash
+	}
+
+	if _, err := tx.Exec(`CREATE TABLE IF NOT EXISTS audit_signatures (
+		event_id ` + idType + ` PRIMARY KEY,
+		hash TEXT NOT NULL,
+		public_key TEXT NOT NULL,
+		signature TEXT NOT NULL,
+		signed_at ` + timeType + ` NOT NULL
+	)`); err != nil {
+		return fmt.Errorf("create audit_signatures: %w", err)
+	}
+	return nil
+}
+
+// revertAuditChain drops the signed heads and the chain hashes.
+func (s *SQLStore) revertAuditChain(tx *dialectTx) error {
+	if _, err := tx.Exec(`DROP TABLE audit_signatures`); err != nil {
+		return fmt.Errorf("drop audit_signatures: %w", err)
+	}
+	for _, column := range []string{"prev_hash", "hash"} {
+		if _, err := tx.Exec(`ALTER TABLE audit_events DROP COLUMN ` + column); err != nil {
+			return fmt.Errorf("drop audit_events.%s: %w", column, err)
+		}
+	}
+	return nil
+}
+
+// migrateFieldGrants adds the field_grants table.
+func (s *SQLStore) migrateFieldGrants(tx *dialectTx) error {
+	return createFieldGrants(tx, "DATETIME")
+}
+
+// createFieldGrants creates the field_grants table with the given type for
+// its created_at column. Item, section and field_name hold glob patterns.
+func createFieldGrants(tx *dialectTx, timeType string) error {
+	if _, err := tx.Exec(`CREATE TABLE IF NOT EXISTS field_grants (
+		vault_id TEXT NOT NULL REFERENCES vaults(id),
+		fid TEXT NOT NULL REFERENCES tee_instances(fid),
+		item TEXT NOT NULL,
+		section TEXT NOT NULL DEFAULT '',
+		field_name TEXT NOT NULL,
+		effect TEXT NOT NULL,
+		created_at ` + timeType + ` NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (vault_id, fid, item, section, field_name)
+	)`); err != nil {
+		return fmt.Errorf("create field_grants: %w", err)
+	}
+	return nil
+}
+
+// revertFieldGrants drops the field_grants table. It refuses while any deny
+// grant exists, since dropping it would widen access to the fields it covers.
+func (s *SQLStore) revertFieldGrants(tx *dialectTx) error {
+	var denies int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM field_grants WHERE effect = ?`, GrantDeny).Scan(&denies); err != nil {
+		return fmt.Errorf("count deny grants: %w", err)
+	}
+	if denies > 0 {
+		return fmt.Errorf("%d deny grants would be lost, widening access; delete them first", denies)
+	}
+	if _, err := tx.Exec(`DROP TABLE field_grants`); err != nil {
+		return fmt.Errorf("drop field_grants: %w", err)
+	}
+	return nil
+}
+
+// migrateGrantWindows adds the optional validity window of vault grants.
+func (s *SQLStore) migrateGrantWindows(tx *dialectTx) error {
+	return addGrantWindows(s, tx, "DATETIME")
+}
+
+// addGrantWindows adds the nullable not_before and expires_at columns of
+// vault_instance_access with the given type.
+func addGrantWindows(s *SQLStore, tx *dialectTx, timeType string) error {
+	for _, column := range []string{"not_before", "expires_at"} {
+		has, err := s.dialect.columnExists(tx, "vault_instance_access", column)
+		if err != nil {
+			return err
+		}
+		if has {
+			continue
+		}
+		if _, err := tx.Exec(`ALTER TABLE vault_instance_access ADD COLUMN ` + column + ` ` + timeType); err != nil {
+			return fmt.Errorf("add vault_instance_access.%s: %w", column, err)
+		}
+	}
+	return nil
+}
+
+// revertGrantWindows drops the validity window of vault grants. It refuses
+// while any grant has a window, since dropping it would make the grant
+// permanent.
+func (s *SQLStore) revertGrantWindows(tx *dialectTx) error {
+	var bounded int
+	if err := tx.QueryRow(
+		`SELECT COUNT(*) FROM vault_instance_access WHERE not_before IS NOT NULL OR expires_at IS NOT NULL`,
+	).Scan(&bounded); err != nil {
+		return fmt.Errorf("count time-bounded grants: %w", err)
+	}
+	if bounded > 0 {
+		return fmt.Errorf("%d time-bounded grants would become permanent; revoke them first", bounded)
+	}
+	for _, column := range []string{"not_before", "expires_at"} {
+		if _, err := tx.Exec(`ALTER TABLE vault_instance_access DROP COLUMN ` + column); err != nil {
+			return fmt.Errorf("drop vault_instance_access.%s: %w", column, err)
+		}
+	}
+	return nil
+}
+
+// migrateAppGrants adds the vault_app_access table.
+func (s *SQLStore) migrateAppGrants(tx *dialectTx) error {
+	return createAppGrants(tx, "DATETIME")
+}
+
+// createAppGrants creates the vault_app_access table with the given type for
+// its timestamp columns.
+func createAppGrants(tx *dialectTx, timeType string) error {
+	if _, err := tx.Exec(`CREATE TABLE IF NOT EXISTS vault_app_access (
+		vault_id TEXT NOT NULL REFERENCES vaults(id),
+		dstack_app_id TEXT NOT NULL,
+		not_before ` + timeType + `,
+		expires_at ` + timeType + `,
+		created_at ` + timeType + ` NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (vault_id, dstack_app_id)
+	)`); err != nil {
+		return fmt.Errorf("create vault_app_access: %w", err)
+	}
+	return nil
+}
+
+// revertAppGrants drops the vault_app_access table. Instances that relied on
+// an app grant lose access until they are granted by FID.
+func (s *SQLStore) revertAppGrants(tx *dialectTx) error {
+	if _, err := tx.Exec(`DROP TABLE vault_app_access`); err != nil {
+		return fmt.Errorf("drop vault_app_access: %w", err)
+	}
+	return nil
+}
+
+// migrateEnrollmentPolicies adds the enrollment policy tables.
+func (s *SQLStore) migrateEnrollmentPolicies(tx *dialectTx) error {
+	return createEnrollmentPolicies(tx, "DATETIME")
+}
+
+// createEnrollmentPolicies creates enrollment_policies and the vaults each
+// policy grants, with the given type for timestamp columns.
+func createEnrollmentPolicies(tx *dialectTx, timeType string) error {
+	if _, err := tx.Exec(`CREATE TABLE IF NOT EXISTS enrollment_policies (
+		dstack_app_id TEXT PRIMARY KEY,
+		label TEXT NOT NULL DEFAULT '',
+		created_at ` + timeType + ` NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		updated_at ` + timeType + ` NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`); err != nil {
+		return fmt.Errorf("create enrollment_policies: %w", err)
+	}
+	if _, err := tx.Exec(`CREATE TABLE IF NOT EXISTS enrollment_policy_vaults (
+		dstack_app_id TEXT NOT NULL REFERENCES enrollment_policies(dstack_app_id),
+		vault_id TEXT NOT NULL REFERENCES vaults(id),
+		PRIMARY KEY (dstack_app_id, vault_id)
+	)`); err != nil {
+		return fmt.Errorf("create enrollment_policy_vaults: %w", err)
+	}
+	return nil
+}
+
+// revertEnrollmentPolicies drops the enrollment policy tables. Instances
+// already enrolled keep their registration and grants.
+func (s *SQLStore) revertEnrollmentPolicies(tx *dialectTx) error {
+	for _, table := range []string{"enrollment_policy_vaults", "enrollment_policies"} {
+		if _, err := tx.Exec(`DROP TABLE ` + table); err != nil {
+			return fmt.Errorf("drop %s: %w", table, err)
+		}
+	}
+	return nil
+}
+
+// migrateEnrollmentTokens adds the enrollment token tables.
+func (s *SQLStore) migrateEnrollmentTokens(tx *dialectTx) error {
+	return createEnrollmentTokens(tx, "DATETIME")
+}
+
+// createEnrollmentTokens creates enrollment_tokens and the vaults each token
+// grants, with the given type for timestamp columns.
+func createEnrollmentTokens(tx *dialectTx, timeType string) error {
+	if _, err := tx.Exec(`CREATE TABLE IF NOT EXISTS enrollment_tokens (
+		id TEXT PRIMARY KEY,
+		token_hash TEXT NOT NULL UNIQUE,
+		dstack_app_id TEXT NOT NULL,
+		label TEXT NOT NULL DEFAULT '',
+		max_uses INTEGER NOT NULL DEFAULT 1,
+		uses INTEGER NOT NULL DEFAULT 0,
+		expires_at ` + timeType + ` NOT NULL,
+		created_at ` + timeType + ` NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`); err != nil {
+		return fmt.Errorf("create enrollment_tokens: %w", err)
+	}
+	if _, err := tx.Exec(`CREATE TABLE IF NOT EXISTS enrollment_token_vaults (
+		token_id TEXT NOT NULL REFERENCES enrollment_tokens(id),
+		vault_id TEXT NOT NULL REFERENCES vaults(id),
+		PRIMARY KEY (token_id, vault_id)
+	)`); err != nil {
+		return fmt.Errorf("create enrollment_token_vaults: %w", err)
+	}
+	return nil
+}
+
+// revertEnrollmentTokens drops the enrollment token tables. Instances already
+// registered with a token keep their registration and grants.
+func (s *SQLStore) revertEnrollmentTokens(tx *dialectTx) error {
+	for _, table := range []string{"enrollment_token_vaults", "enrollment_tokens"} {
+		if _, err := tx.Exec(`DROP TABLE ` + table); err != nil {
+			return fmt.Errorf("drop %s: %w", table, err)
+		}
+	}
+	return nil
+}
+
+// migrateInstanceAliases adds the instance_aliases table.
+func (s *SQLStore) migrateInstanceAliases(tx *dialectTx) error {
+	return createInstanceAliases(tx, "DATETIME")
+}
+
+// createInstanceAliases creates the instance_aliases table, which maps the
+// FIDs of replaced keys to the instance's current FID, with the given type
+// for its timestamp column.
+func createInstanceAliases(tx *dialectTx, timeType string) error {
+	if _, err := tx.Exec(`CREATE TABLE IF NOT EXISTS instance_aliases (
+		alias_fid TEXT PRIMARY KEY,
+		fid TEXT NOT NULL REFERENCES tee_instances(fid),
+		created_at ` + timeType + ` NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`); err != nil {
+		return fmt.Errorf("create instance_aliases: %w", err)
+	}
+	return nil
+}
+
+// revertInstanceAliases drops the instance_aliases table. Re-keyed instances
+// keep their new key, but lose the link to their old FIDs.
+func (s *SQLStore) revertInstanceAliases(tx *dialectTx) error {
+	if _, err := tx.Exec(`DROP TABLE instance_aliases`); err != nil {
+		return fmt.Errorf("drop instance_aliases: %w", err)
+	}
+	return nil
+}
+
+// migrateInstanceStatus adds the status of TEE instances.
+func (s *SQLStore) migrateInstanceStatus(tx *dialectTx) error {
+	return addInstanceStatus(s, tx, "DATETIME")
+}
+
+// addInstanceStatus adds the status, status_reason and status_changed_at
+// columns of tee_instances, with the given type for the timestamp. Existing
+// instances are active.
+func addInstanceStatus(s *SQLStore, tx *dialectTx, timeType string) error {
+	for _, c := range []struct{ name, def string }{
+		{"status", `TEXT NOT NULL DEFAULT 'active'`},
+		{"status_reason", `TEXT NOT NULL DEFAULT ''`},
+		{"status_changed_at", timeType},
+	} {
+		has, err := s.dialect.columnExists(tx, "tee_instances", c.name)
+		if err != nil {
+			return err
+		}
+		if has {
+			continue
+		}
+		if _, err := tx.Exec(`ALTER TABLE tee_instances ADD COLUMN ` + c.name + ` ` + c.def); err != nil {
+			return fmt.Errorf("add tee_instances.%s: %w", c.name, err)
+		}
+	}
+	return nil
+}
+
+// revertInstanceStatus drops the status of TEE instances. It refuses while
+// any instance is not active, since dropping the status would let it fetch
+// secrets again.
+func (s *SQLStore) revertInstanceStatus(tx *dialectTx) error {
+	var inactive int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM tee_instances WHERE status <> 'active'`).Scan(&inactive); err != nil {
+		return fmt.Errorf("count inactive instances: %w", err)
+	}
+	if inactive > 0 {
+		return fmt.Errorf("%d disabled or quarantined instances would become active; re-enable or delete them first", inactive)
+	}
+	for _, column := range []string{"status", "status_reason", "status_changed_at"} {
+		if _, err := tx.Exec(`ALTER TABLE tee_instances DROP COLUMN ` + column); err != nil {
+			return fmt.Errorf("drop tee_instances.%s: %w", column, err)
+		}
+	}
+	return nil
+}
+
+// migrateMeasurementPolicies adds the measurement policy tables.
+func (s *SQLStore) migrateMeasurementPolicies(tx *dialectTx) error {
+	return createMeasurementPolicies(tx, "DATETIME")
+}
+
+// createMeasurementPolicies creates measurement_policies and the values each
+// policy allows, with the given type for timestamp columns.
+func createMeasurementPolicies(tx *dialectTx, timeType string) error {
+	if _, err := tx.Exec(`CREATE TABLE IF NOT EXISTS measurement_policies (
+		scope TEXT NOT NULL,
+		target TEXT NOT NULL,
+		created_at ` + timeType + ` NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		updated_at ` + timeType + ` NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (scope, target)
+	)`); err != nil {
+		return fmt.Errorf("create measurement_policies: %w", err)
+	}
+	if _, err := tx.Exec(`CREATE TABLE IF NOT EXISTS measurement_policy_values (
+		scope TEXT NOT NULL,
+		target TEXT NOT NULL,
+		measurement TEXT NOT NULL,
+		value TEXT NOT NULL,
+		PRIMARY KEY (scope, target, measurement, value),
+		FOREIGN KEY (scope, target) REFERENCES measurement_policies(scope, target)
+	)`); err != nil {
+		return fmt.Errorf("create measurement_policy_values: %w", err)
+	}
+	return nil
+}
+
+// revertMeasurementPolicies drops the measurement policy tables. It refuses
+// while any policy exists, since dropping it would let other images fetch
+// the secrets it guards.
+func (s *SQLStore) revertMeasurementPolicies(tx *dialectTx) error {
+	var policies int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM measurement_policies`).Scan(&policies); err != nil {
+		return fmt.Errorf("count measurement policies: %w", err)
+	}
+	if policies > 0 {
+		return fmt.Errorf("%d measurement policies would be dropped; delete them first", policies)
+	}
+	for _, table := range []string{"measurement_policy_values", "measurement_policies"} {
+		if _, err := tx.Exec(`DROP TABLE ` + table); err != nil {
+			return fmt.Errorf("drop %s: %w", table, err)
+		}
+	}
+	return nil
+}
+
+// rebuildTable replaces table with a new definition, copying rows by
+// selecting selectCols from the old table into insertCols of the new one.
+func rebuildTable(tx *dialectTx, table, columns, insertCols, selectCols string) error {
+	for _, stmt := range []string{
+		`CREATE TABLE ` + table + `_new (` + columns + `)`,
+		`INSERT INTO ` + table + `_new (` + insertCols + `) SELECT ` + selectCols + ` FROM ` + table,
+		`DROP TABLE ` + table,
+		`ALTER TABLE ` + table + `_new RENAME TO ` + table,
+	} {
+		if _, err := tx.Exec(stmt); err != nil {
+			return fmt.Errorf("rebuild %s: %w", table, err)
+		}
+	}
+	return nil
+}
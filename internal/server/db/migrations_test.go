@@ -0,0 +1,248 @@
+package db
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMigrations_FreshDatabaseAtLatest(t *testing.T) {
+	s := newSQLTestStore(t)
+
+	version, err := s.SchemaVersion()
+	if err != nil {
+		t.Fatalf("SchemaVersion: %v", err)
+	}
+	if version != LatestSchemaVersion() {
+		t.Errorf("SchemaVersion = %d, want %d", version, LatestSchemaVersion())
+	}
+
+	status, err := s.MigrationStatus()
+	if err != nil {
+		t.Fatalf("MigrationStatus: %v", err)
+	}
+	if len(status) != len(s.dialect.migrations) {
+		t.Fatalf("expected %d migrations, got %+v", len(s.dialect.migrations), status)
+	}
+	for _, m := range status {
+		if m.AppliedAt == nil {
+			t.Errorf("migration %d (%s) not applied", m.Version, m.Name)
+		}
+	}
+
+	if n, err := s.MigrateUp(LatestSchemaVersion()); err != nil || n != 0 {
+		t.Errorf("MigrateUp on an up-to-date database = %d, %v", n, err)
+	}
+}
+
+func TestMigrations_DownAndUp(t *testing.T) {
+	s := newSQLTestStore(t)
+	s.CreateVault(&Vault{ID: "v1", Name: "V1"})
+	s.UpsertField("v1", "alice", "", "token", "secret")
+
+	n, err := s.MigrateDown(1)
+	if err != nil {
+		t.Fatalf("MigrateDown: %v", err)
+	}
+	if n != LatestSchemaVersion()-1 {
+		t.Errorf("reverted %d migrations, want %d", n, LatestSchemaVersion()-1)
+	}
+	if has, _ := s.dialect.columnExists(s.db, "vault_items", "item"); has {
+		t.Error("vault_items should be back to the baseline layout")
+	}
+
+	if _, err := s.MigrateUp(LatestSchemaVersion()); err != nil {
+		t.Fatalf("MigrateUp: %v", err)
+	}
+	val, err := s.GetFieldValue("v1", "alice", "", "token")
+	if err != nil || val != "secret" {
+		t.Errorf("GetFieldValue after down/up = %q, %v", val, err)
+	}
+
+	if _, err := s.MigrateDown(0); err == nil {
+		t.Error("expected the baseline migration to be irreversible")
+	}
+}
+
+func TestMigrations_DownRefusesNamedSections(t *testing.T) {
+	s := newSQLTestStore(t)
+	s.CreateVault(&Vault{ID: "v1", Name: "V1"})
+	s.UpsertField("v1", "alice", "oauth", "token", "secret")
+
+	if _, err := s.MigrateDown(1); err == nil {
+		t.Fatal("expected item_sections revert to fail while named sections exist")
+	}
+	// Later steps are reverted before item_sections refuses.
+	if version, _ := s.SchemaVersion(); version != 2 {
+		t.Errorf("failed revert changed the schema version to %d, want 2", version)
+	}
+	if _, err := s.MigrateUp(LatestSchemaVersion()); err != nil {
+		t.Fatalf("MigrateUp: %v", err)
+	}
+	if val, err := s.GetFieldValue("v1", "alice", "oauth", "token"); err != nil || val != "secret" {
+		t.Errorf("GetFieldValue after failed revert = %q, %v", val, err)
+	}
+}
+
+func TestMigrations_DownRefusesTrash(t *testing.T) {
+	s := newSQLTestStore(t)
+	s.CreateVault(&Vault{ID: "v1", Name: "V1"})
+	s.DeleteVault("v1")
+
+	if _, err := s.MigrateDown(3); err == nil {
+		t.Fatal("expected trash revert to fail while the trash is not empty")
+	}
+	// Store methods expect the latest schema; later steps were reverted.
+	if _, err := s.MigrateUp(LatestSchemaVersion()); err != nil {
+		t.Fatalf("MigrateUp: %v", err)
+	}
+	s.PurgeVault("v1")
+	if _, err := s.MigrateDown(3); err != nil {
+		t.Fatalf("MigrateDown with an empty trash: %v", err)
+	}
+	if has, _ := s.dialect.columnExists(s.db, "vaults", "deleted_at"); has {
+		t.Error("vaults.deleted_at should be dropped")
+	}
+}
+
+func TestMigrations_DownRefusesDenyGrants(t *testing.T) {
+	s := newSQLTestStore(t)
+	s.CreateVault(&Vault{ID: "v1", Name: "V1"})
+	s.RegisterInstance(&TEEInstance{FID: "f1", PublicKey: []byte("pk1")})
+	s.PutFieldGrant(&FieldGrant{VaultID: "v1", FID: "f1", Item: "db", FieldName: "*", Effect: GrantAllow})
+	s.PutFieldGrant(&FieldGrant{VaultID: "v1", FID: "f1", Item: "db", FieldName: "root", Effect: GrantDeny})
+
+	if _, err := s.MigrateDown(6); err == nil {
+		t.Fatal("expected field_grants revert to fail while deny grants exist")
+	}
+	s.DeleteFieldGrant("v1", "f1", "db", "", "root")
+	if _, err := s.MigrateDown(6); err != nil {
+		t.Fatalf("MigrateDown with only allow grants: %v", err)
+	}
+	if _, err := s.db.Exec(`SELECT COUNT(*) FROM field_grants`); err == nil {
+		t.Error("field_grants should be dropped")
+	}
+}
+
+func TestMigrations_DownRefusesGrantWindows(t *testing.T) {
+	s := newSQLTestStore(t)
+	s.CreateVault(&Vault{ID: "v1", Name: "V1"})
+	s.RegisterInstance(&TEEInstance{FID: "f1", PublicKey: []byte("pk1")})
+	expiresAt := time.Now().Add(time.Hour)
+	s.GrantVaultAccess("v1", "f1", nil, &expiresAt)
+
+	if _, err := s.MigrateDown(7); err == nil {
+		t.Fatal("expected grant_windows revert to fail while time-bounded grants exist")
+	}
+	s.GrantVaultAccess("v1", "f1", nil, nil)
+	if _, err := s.MigrateDown(7); err != nil {
+		t.Fatalf("MigrateDown with only permanent grants: %v", err)
+	}
+	if has, _ := s.dialect.columnExists(s.db, "vault_instance_access", "expires_at"); has {
+		t.Error("vault_instance_access.expires_at should be dropped")
+	}
+	var grants int
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM vault_instance_access`).Scan(&grants); err != nil || grants != 1 {
+		t.Errorf("grants after revert = %d, %v; want the permanent grant kept", grants, err)
+	}
+}
+
+func TestMigrations_DownRefusesInactiveInstances(t *testing.T) {
+	s := newSQLTestStore(t)
+	s.RegisterInstance(&TEEInstance{FID: "f1", PublicKey: []byte("pk1")})
+	s.SetInstanceStatus("f1", InstanceDisabled, "decommissioned")
+
+	if _, err := s.MigrateDown(12); err == nil {
+		t.Fatal("expected instance_status revert to fail while an instance is disabled")
+	}
+	s.SetInstanceStatus("f1", InstanceActive, "")
+	if _, err := s.MigrateDown(12); err != nil {
+		t.Fatalf("MigrateDown with only active instances: %v", err)
+	}
+	if has, _ := s.dialect.columnExists(s.db, "tee_instances", "status"); has {
+		t.Error("tee_instances.status should be dropped")
+	}
+}
+
+func TestMigrations_DownRefusesMeasurementPolicies(t *testing.T) {
+	s := newSQLTestStore(t)
+	s.CreateVault(&Vault{ID: "v1", Name: "V1"})
+	s.PutMeasurementPolicy(&MeasurementPolicy{Scope: MeasurementScopeVault, Target: "v1", Allowed: map[string][]string{MeasurementMRTD: {"aa"}}})
+
+	if _, err := s.MigrateDown(13); err == nil {
+		t.Fatal("expected measurement_policies revert to fail while a policy exists")
+	}
+	s.DeleteMeasurementPolicy(MeasurementScopeVault, "v1")
+	if _, err := s.MigrateDown(13); err != nil {
+		t.Fatalf("MigrateDown without policies: %v", err)
+	}
+	if _, err := s.db.Exec(`SELECT COUNT(*) FROM measurement_policies`); err == nil {
+		t.Error("measurement_policies should be dropped")
+	}
+}
+
+func TestNewStore_RejectsNewerSchema(t *testing.T) {
+	requireSQLite(t)
+	path := filepath.Join(t.TempDir(), "jingui.db")
+
+	s, err := NewStore(path, testMasterKey)
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+	if _, err := s.db.Exec(
+		`INSERT INTO schema_migrations (version, name) VALUES (?, 'from_the_future')`,
+		LatestSchemaVersion()+1,
+	); err != nil {
+		t.Fatalf("insert future migration: %v", err)
+	}
+	s.Close()
+
+	if _, err := NewStore(path, testMasterKey); !errors.Is(err, ErrSchemaTooNew) {
+		t.Fatalf("expected ErrSchemaTooNew, got %v", err)
+	}
+
+	s, err = OpenForMigration(path, testMasterKey)
+	if err != nil {
+		t.Fatalf("OpenForMigration: %v", err)
+	}
+	defer s.Close()
+	status, err := s.MigrationStatus()
+	if err != nil {
+		t.Fatalf("MigrationStatus: %v", err)
+	}
+	if last := status[len(status)-1]; last.Version != LatestSchemaVersion()+1 || last.Name != "" {
+		t.Errorf("expected unknown future migration in status, got %+v", last)
+	}
+	if _, err := s.MigrateDown(1); !errors.Is(err, ErrSchemaTooNew) {
+		t.Errorf("MigrateDown: expected ErrSchemaTooNew, got %v", err)
+	}
+}
+
+func TestMigrations_AdoptsUntrackedSectionedSchema(t *testing.T) {
+	requireSQLite(t)
+	path := filepath.Join(t.TempDir(), "jingui.db")
+
+	s, err := NewStore(path, testMasterKey)
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+	s.CreateVault(&Vault{ID: "v1", Name: "V1"})
+	s.UpsertField("v1", "alice", "oauth", "token", "secret")
+	if _, err := s.db.Exec(`DROP TABLE schema_migrations`); err != nil {
+		t.Fatalf("drop schema_migrations: %v", err)
+	}
+	s.Close()
+
+	s, err = NewStore(path, testMasterKey)
+	if err != nil {
+		t.Fatalf("reopen untracked database: %v", err)
+	}
+	defer s.Close()
+	if version, _ := s.SchemaVersion(); version != LatestSchemaVersion() {
+		t.Errorf("SchemaVersion = %d, want %d", version, LatestSchemaVersion())
+	}
+	if val, err := s.GetFieldValue("v1", "alice", "oauth", "token"); err != nil || val != "secret" {
+		t.Errorf("GetFieldValue = %q, %v", val, err)
+	}
+}
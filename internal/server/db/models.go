@@ -4,22 +4,61 @@ import "time"
 
 // Vault represents a secret vault (replaces the old App concept).
 type Vault struct {
-	ID        string    `json:"id"`
-	Name      string    `json:"name"`
-	CreatedAt time.Time `json:"created_at"`
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// VersionRetention is the number of versions kept per field; 0 keeps all.
+	VersionRetention int       `json:"version_retention"`
+	CreatedAt        time.Time `json:"created_at"`
+	// DeletedAt is set while the vault is in the trash.
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
 }
 
-// VaultItem represents a single field stored in a vault.
+// VaultItem represents a single field stored in a vault, addressed by item,
+// section ("" for the item's default section) and field name.
 type VaultItem struct {
 	ID        int64     `json:"id"`
 	VaultID   string    `json:"vault_id"`
-	ItemName  string    `json:"item_name"`
+	Item      string    `json:"item"`
 	Section   string    `json:"section"`
-	Value     string    `json:"-"`
+	FieldName string    `json:"field_name"`
+	Value     string    `json:"-"` // decrypted plaintext, never serialized
+	Version   int       `json:"version"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 }
 
+// ItemMerge is the set of changes MergeVaultFields applies to one section of
+// an item: fields to upsert and field names to delete.
+type ItemMerge struct {
+	Item    string
+	Section string
+	Upsert  map[string]string
+	Delete  []string
+}
+
+// FieldExpiry is the effective expiry of a vault field: the earlier of the
+// expiry set on the field and the one set on its section.
+type FieldExpiry struct {
+	VaultID   string    `json:"vault_id"`
+	Item      string    `json:"item"`
+	Section   string    `json:"section"`
+	FieldName string    `json:"field_name"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// FieldVersion is one historical value of a vault field. The value itself is
+// never exposed through the admin API.
+type FieldVersion struct {
+	VaultID   string    `json:"vault_id"`
+	Item      string    `json:"item"`
+	Section   string    `json:"section"`
+	FieldName string    `json:"field_name"`
+	Version   int       `json:"version"`
+	Author    string    `json:"author"`
+	Current   bool      `json:"current"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
 // TEEInstance represents a registered TEE instance with its public key.
 type TEEInstance struct {
 	FID         string     `json:"fid"`
@@ -28,6 +67,226 @@ type TEEInstance struct {
 	DstackAppID string     `json:"dstack_app_id"`
 	CreatedAt   time.Time  `json:"created_at"`
 	LastUsedAt  *time.Time `json:"last_used_at"`
+	// Status is one of the Instance* statuses; only active instances get
+	// challenges and secrets. StatusReason is the admin's note for the last
+	// change, made at StatusChangedAt.
+	Status          string     `json:"status"`
+	StatusReason    string     `json:"status_reason,omitempty"`
+	StatusChangedAt *time.Time `json:"status_changed_at,omitempty"`
+	// DeletedAt is set while the instance is in the trash.
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+}
+
+// Statuses of a TEEInstance. Disabled and quarantined instances keep their
+// grants and history but are refused challenges and secrets; quarantined
+// marks an instance suspected of being compromised.
+const (
+	InstanceActive      = "active"
+	InstanceDisabled    = "disabled"
+	InstanceQuarantined = "quarantined"
+)
+
+// ValidInstanceStatus reports whether status is one of the Instance* statuses.
+func ValidInstanceStatus(status string) bool {
+	switch status {
+	case InstanceActive, InstanceDisabled, InstanceQuarantined:
+		return true
+	}
+	return false
+}
+
+// Kinds of TrashEntry.
+const (
+	TrashVault    = "vault"
+	TrashSection  = "section"
+	TrashInstance = "instance"
+)
+
+// TrashEntry is a deleted vault, item section or instance that can still be
+// restored. Section entries leave Section empty for the item's default
+// section.
+type TrashEntry struct {
+	Kind      string    `json:"kind"`
+	VaultID   string    `json:"vault_id,omitempty"`
+	Item      string    `json:"item,omitempty"`
+	Section   string    `json:"section,omitempty"`
+	FID       string    `json:"fid,omitempty"`
+	Name      string    `json:"name,omitempty"`   // vault name or instance label
+	Fields    int       `json:"fields,omitempty"` // fields in a trashed section
+	DeletedAt time.Time `json:"deleted_at"`
+}
+
+// Outcomes of an AuditEvent.
+const (
+	AuditSuccess = "success"
+	AuditDenied  = "denied"
+	AuditError   = "error"
+)
+
+// AuditEvent records one secret fetch, failed challenge or admin write. It
+// never holds secret values: fetches list the references requested and
+// failures the error returned to the caller.
+type AuditEvent struct {
+	ID       int64     `json:"id"`
+	Time     time.Time `json:"time"`
+	Action   string    `json:"action"`
+	Outcome  string    `json:"outcome"`
+	Status   int       `json:"status"`
+	Actor    string    `json:"actor,omitempty"` // X-Jingui-Actor of admin requests
+	FID      string    `json:"fid,omitempty"`
+	VaultID  string    `json:"vault_id,omitempty"`
+	Target   string    `json:"target,omitempty"` // request path of admin writes
+	Refs     []string  `json:"refs,omitempty"`
+	Command  string    `json:"command,omitempty"` // X-Jingui-Command of fetches
+	SourceIP string    `json:"source_ip,omitempty"`
+	AppID    string    `json:"app_id,omitempty"` // attested dstack app ID
+	Error    string    `json:"error,omitempty"`
+	// PrevHash and Hash chain the event to the one before it; see
+	// AuditEventHash.
+	PrevHash string `json:"prev_hash"`
+	Hash     string `json:"hash"`
+}
+
+// AuditSignature is a signed head of the audit log: the hash of the event
+// with EventID, signed with the server's audit key. PublicKey and Signature
+// are hex-encoded Ed25519 values.
+type AuditSignature struct {
+	EventID   int64     `json:"event_id"`
+	Hash      string    `json:"hash"`
+	PublicKey string    `json:"public_key"`
+	Signature string    `json:"signature"`
+	SignedAt  time.Time `json:"signed_at"`
+}
+
+// AuditQuery selects audit events, newest first. Zero fields match every
+// event.
+type AuditQuery struct {
+	Action  string
+	Outcome string
+	Actor   string
+	// FID also matches the FIDs the instance was re-keyed away from.
+	FID     string
+	VaultID string
+	// Since and Until bound the event time; Since is inclusive, Until is not.
+	Since time.Time
+	Until time.Time
+	// BeforeID pages through older events: only IDs below it match.
+	BeforeID int64
+	// Limit caps the number of events returned; 0 returns all.
+	Limit int
+}
+
+// VaultAccess is a grant of vault access to a TEE instance. A grant with
+// NotBefore or ExpiresAt set only works from NotBefore and until ExpiresAt.
+type VaultAccess struct {
+	VaultID   string     `json:"vault_id"`
+	FID       string     `json:"fid"`
+	NotBefore *time.Time `json:"not_before,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// States of a VaultAccess grant.
+const (
+	GrantActive  = "active"
+	GrantPending = "pending"
+	GrantExpired = "expired"
+)
+
+// State reports whether the grant is pending, active or expired at t.
+func (a *VaultAccess) State(t time.Time) string {
+	return grantState(a.NotBefore, a.ExpiresAt, t)
+}
+
+func grantState(notBefore, expiresAt *time.Time, t time.Time) string {
+	switch {
+	case expiresAt != nil && !t.Before(*expiresAt):
+		return GrantExpired
+	case notBefore != nil && t.Before(*notBefore):
+		return GrantPending
+	}
+	return GrantActive
+}
+
+// AppAccess is a grant of vault access to every instance whose attestation
+// proves it runs DstackAppID. The validity window works as for VaultAccess.
+type AppAccess struct {
+	VaultID     string     `json:"vault_id"`
+	DstackAppID string     `json:"dstack_app_id"`
+	NotBefore   *time.Time `json:"not_before,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// State reports whether the grant is pending, active or expired at t.
+func (a *AppAccess) State(t time.Time) string {
+	return grantState(a.NotBefore, a.ExpiresAt, t)
+}
+
+// EnrollmentPolicy lets instances of a dstack app register themselves by
+// attestation, without the admin token. Enrolled instances are labelled from
+// the Label template and granted access to Vaults.
+type EnrollmentPolicy struct {
+	DstackAppID string    `json:"dstack_app_id"`
+	Label       string    `json:"label"`
+	Vaults      []string  `json:"vaults"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// EnrollmentToken lets whoever holds its secret register up to MaxUses
+// instances of a dstack app before ExpiresAt, without the admin token.
+// Registered instances are labelled from the Label template and granted
+// access to Vaults. Only the SHA-256 hash of the secret is stored.
+type EnrollmentToken struct {
+	ID          string    `json:"id"`
+	TokenHash   string    `json:"token_hash"`
+	DstackAppID string    `json:"dstack_app_id"`
+	Label       string    `json:"label"`
+	Vaults      []string  `json:"vaults"`
+	MaxUses     int       `json:"max_uses"`
+	Uses        int       `json:"uses"`
+	ExpiresAt   time.Time `json:"expires_at"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// Redeemable reports whether the token can still register an instance at t.
+func (e *EnrollmentToken) Redeemable(t time.Time) bool {
+	return e.Uses < e.MaxUses && e.ExpiresAt.After(t)
+}
+
+// InstanceAlias records that the key with FID AliasFID was replaced by a
+// re-key; the instance is now FID.
+type InstanceAlias struct {
+	AliasFID  string    `json:"alias_fid"`
+	FID       string    `json:"fid"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// VaultInstance is an instance holding a grant on a vault.
+type VaultInstance struct {
+	TEEInstance
+	Grant VaultAccess `json:"grant"`
+}
+
+// Effects of a FieldGrant.
+const (
+	GrantAllow = "allow"
+	GrantDeny  = "deny"
+)
+
+// FieldGrant allows or denies a TEE instance the fields of a vault matched by
+// Item, Section and FieldName, each a glob pattern (see path.Match). An empty
+// Section matches only an item's default section. The most specific matching
+// grant decides; without one, the vault-wide VaultAccess grant does.
+type FieldGrant struct {
+	VaultID   string    `json:"vault_id"`
+	FID       string    `json:"fid"`
+	Item      string    `json:"item"`
+	Section   string    `json:"section"`
+	FieldName string    `json:"field_name"`
+	Effect    string    `json:"effect"`
+	CreatedAt time.Time `json:"created_at"`
 }
 
 // DebugPolicy controls whether debug read is allowed for a vault+instance pair.
@@ -37,3 +296,90 @@ type DebugPolicy struct {
 	AllowRead bool      `json:"allow_read"`
 	UpdatedAt time.Time `json:"updated_at"`
 }
+
+// Scopes of a MeasurementPolicy.
+const (
+	MeasurementScopeVault    = "vault"
+	MeasurementScopeInstance = "instance"
+)
+
+// Measurements of a TDX quote a MeasurementPolicy can constrain.
+const (
+	MeasurementMRTD         = "mrtd"
+	MeasurementRTMR0        = "rtmr0"
+	MeasurementRTMR1        = "rtmr1"
+	MeasurementRTMR2        = "rtmr2"
+	MeasurementRTMR3        = "rtmr3"
+	MeasurementTCBSVN       = "tcb_svn"
+	MeasurementTDAttributes = "td_attributes"
+)
+
+// MeasurementNames lists the Measurement* names in the order they are checked.
+var MeasurementNames = []string{
+	MeasurementMRTD, MeasurementRTMR0, MeasurementRTMR1, MeasurementRTMR2,
+	MeasurementRTMR3, MeasurementTCBSVN, MeasurementTDAttributes,
+}
+
+// ValidMeasurement reports whether name is one of the Measurement* names.
+func ValidMeasurement(name string) bool {
+	for _, n := range MeasurementNames {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// MeasurementPolicy restricts which attested images may fetch secrets: those
+// of a vault (Scope vault, Target the vault ID) or those granted to an
+// instance (Scope instance, Target its FID). Allowed maps a measurement to
+// its acceptable lowercase hex values; measurements it does not list are not
+// checked.
+type MeasurementPolicy struct {
+	Scope     string              `json:"scope"`
+	Target    string              `json:"target"`
+	Allowed   map[string][]string `json:"allowed"`
+	CreatedAt time.Time           `json:"created_at"`
+	UpdatedAt time.Time           `json:"updated_at"`
+}
+
+// Mismatch returns the first measurement whose value in measured is not
+// allowed by the policy, or "" if measured satisfies it.
+func (p *MeasurementPolicy) Mismatch(measured map[string]string) string {
+	for _, name := range MeasurementNames {
+		allowed := p.Allowed[name]
+		if len(allowed) == 0 {
+			continue
+		}
+		ok := false
+		for _, v := range allowed {
+			if v == measured[name] {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return name
+		}
+	}
+	return ""
+}
+
+// MasterKey is an entry in the master key keyring. Only the key ID is stored;
+// key material is never persisted.
+type MasterKey struct {
+	ID        string     `json:"id"`
+	CreatedAt time.Time  `json:"created_at"`
+	RetiredAt *time.Time `json:"retired_at"`
+	Active    bool       `json:"active"`
+	Rows      int        `json:"rows"`
+}
+
+// SchemaMigration describes one schema migration and whether it is applied.
+// Name is empty for migrations applied by a newer binary.
+type SchemaMigration struct {
+	Version    int        `json:"version"`
+	Name       string     `json:"name"`
+	Reversible bool       `json:"reversible"`
+	AppliedAt  *time.Time `json:"applied_at"`
+}
@@ -0,0 +1,339 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+// postgresDialect runs SQLStore on PostgreSQL.
+var postgresDialect = &dialect{
+	name:            "postgres",
+	numbered:        true,
+	migrations:      postgresMigrations,
+	migrationsTable: postgresMigrationsTable,
+	beginMigration:  beginPostgresMigration,
+	checkMigration:  func(*dialectTx) error { return nil }, // constraints are never deferred
+	columnExists:    postgresColumnExists,
+	constraint:      postgresConstraint,
+	snapshotTx:      &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true},
+	timestamp:       func(t time.Time) any { return t },
+	lockAuditLog:    `LOCK TABLE audit_events IN EXCLUSIVE MODE`,
+}
+
+// postgresMigrations mirrors migrations step for step. PostgreSQL databases
+// start at the baseline, so there are no legacy layouts to upgrade.
+var postgresMigrations = []migration{
+	{version: 1, name: "baseline", up: (*SQLStore).migratePostgresBaseline},
+	{version: 2, name: "item_sections", up: (*SQLStore).migratePostgresItemSections, down: (*SQLStore).revertPostgresItemSections},
+	{version: 3, name: "field_expiries", up: (*SQLStore).migratePostgresFieldExpiries, down: (*SQLStore).revertFieldExpiries},
+	{version: 4, name: "trash", up: (*SQLStore).migratePostgresTrash, down: (*SQLStore).revertTrash},
+	{version: 5, name: "audit_events", up: (*SQLStore).migratePostgresAuditEvents, down: (*SQLStore).revertAuditEvents},
+	{version: 6, name: "audit_chain", up: (*SQLStore).migratePostgresAuditChain, down: (*SQLStore).revertAuditChain},
+	{version: 7, name: "field_grants", up: (*SQLStore).migratePostgresFieldGrants, down: (*SQLStore).revertFieldGrants},
+	{version: 8, name: "grant_windows", up: (*SQLStore).migratePostgresGrantWindows, down: (*SQLStore).revertGrantWindows},
+	{version: 9, name: "app_grants", up: (*SQLStore).migratePostgresAppGrants, down: (*SQLStore).revertAppGrants},
+	{version: 10, name: "enrollment_policies", up: (*SQLStore).migratePostgresEnrollmentPolicies, down: (*SQLStore).revertEnrollmentPolicies},
+	{version: 11, name: "enrollment_tokens", up: (*SQLStore).migratePostgresEnrollmentTokens, down: (*SQLStore).revertEnrollmentTokens},
+	{version: 12, name: "instance_aliases", up: (*SQLStore).migratePostgresInstanceAliases, down: (*SQLStore).revertInstanceAliases},
+	{version: 13, name: "instance_status", up: (*SQLStore).migratePostgresInstanceStatus, down: (*SQLStore).revertInstanceStatus},
+	{version: 14, name: "measurement_policies", up: (*SQLStore).migratePostgresMeasurementPolicies, down: (*SQLStore).revertMeasurementPolicies},
+}
+
+// migrationLockID is the advisory lock key serialising migrations between
+// servers that share a PostgreSQL database.
+const migrationLockID = 0x6a696e677569 // "jingui"
+
+// NewPostgresStore connects to the PostgreSQL database at dsn (a URL or
+// key=value connection string) and applies any pending schema migrations.
+// Keys are handled as in NewStore.
+func NewPostgresStore(dsn string, masterKey []byte, previousKeys ...[]byte) (*SQLStore, error) {
+	s, err := OpenPostgresForMigration(dsn, masterKey, previousKeys...)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.migrate(); err != nil {
+		s.Close()
+		return nil, fmt.Errorf("migrate: %w", err)
+	}
+	if err := s.checkMasterKey(); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// OpenPostgresForMigration is the PostgreSQL counterpart of OpenForMigration.
+func OpenPostgresForMigration(dsn string, masterKey []byte, previousKeys ...[]byte) (*SQLStore, error) {
+	keys, err := newKeyring(masterKey, previousKeys...)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("open database: %w", err)
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("connect to database: %w", err)
+	}
+
+	return &SQLStore{db: &dialectDB{DB: db, d: postgresDialect}, dialect: postgresDialect, keys: keys}, nil
+}
+
+// postgresConstraint maps PostgreSQL SQLSTATE codes to constraint kinds.
+// Primary keys are told apart from other unique constraints by the default
+// <table>_pkey constraint name.
+func postgresConstraint(err error) constraintKind {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return constraintNone
+	}
+	switch pgErr.Code {
+	case "23505": // unique_violation
+		if strings.HasSuffix(pgErr.ConstraintName, "_pkey") {
+			return constraintPrimaryKey
+		}
+		return constraintUnique
+	case "23503": // foreign_key_violation
+		return constraintForeignKey
+	}
+	return constraintNone
+}
+
+// beginPostgresMigration starts a migration transaction holding the
+// migration advisory lock until it ends. DDL is transactional in PostgreSQL,
+// so foreign keys stay enforced throughout.
+func beginPostgresMigration(s *SQLStore) (*dialectTx, func(), error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err := tx.Exec(`SELECT pg_advisory_xact_lock(?)`, migrationLockID); err != nil {
+		tx.Rollback()
+		return nil, nil, fmt.Errorf("acquire migration lock: %w", err)
+	}
+	return tx, func() {}, nil
+}
+
+// postgresColumnExists reports whether table in the current schema has a
+// column with the given name.
+func postgresColumnExists(db dbtx, table, column string) (bool, error) {
+	var count int
+	if err := db.QueryRow(
+		`SELECT COUNT(*) FROM information_schema.columns
+		 WHERE table_schema = current_schema() AND table_name = ? AND column_name = ?`,
+		table, column,
+	).Scan(&count); err != nil {
+		return false, fmt.Errorf("inspect %s columns: %w", table, err)
+	}
+	return count > 0, nil
+}
+
+const postgresMigrationsTable = `CREATE TABLE IF NOT EXISTS schema_migrations (
+	version INTEGER PRIMARY KEY,
+	name TEXT NOT NULL,
+	applied_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+)`
+
+// migratePostgresBaseline creates the baseline schema. The field tables use
+// the same unsectioned layout as SQLite's baseline: the item name lives in
+// section and the field name in item_name.
+func (s *SQLStore) migratePostgresBaseline(tx *dialectTx) error {
+	tables := []string{
+		`CREATE TABLE IF NOT EXISTS vaults (
+			id TEXT PRIMARY KEY,
+			name TEXT NOT NULL,
+			version_retention INTEGER NOT NULL DEFAULT 0,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS vault_items (
+			rowid BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+			vault_id TEXT NOT NULL REFERENCES vaults(id),
+			item_name TEXT NOT NULL,
+			section TEXT NOT NULL DEFAULT '',
+			ciphertext BYTEA NOT NULL,
+			wrapped_key BYTEA NOT NULL,
+			key_id TEXT NOT NULL,
+			version INTEGER NOT NULL DEFAULT 1,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			CONSTRAINT vault_items_field_key UNIQUE (vault_id, section, item_name)
+		)`,
+		`CREATE TABLE IF NOT EXISTS vault_item_versions (
+			rowid BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+			vault_id TEXT NOT NULL REFERENCES vaults(id),
+			section TEXT NOT NULL,
+			item_name TEXT NOT NULL,
+			version INTEGER NOT NULL,
+			ciphertext BYTEA NOT NULL,
+			wrapped_key BYTEA NOT NULL,
+			key_id TEXT NOT NULL,
+			author TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			CONSTRAINT vault_item_versions_field_key UNIQUE (vault_id, section, item_name, version)
+		)`,
+		`CREATE TABLE IF NOT EXISTS tee_instances (
+			fid TEXT PRIMARY KEY,
+			label TEXT NOT NULL DEFAULT '',
+			public_key BYTEA NOT NULL UNIQUE,
+			dstack_app_id TEXT NOT NULL,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			last_used_at TIMESTAMPTZ
+		)`,
+		`CREATE TABLE IF NOT EXISTS vault_instance_access (
+			vault_id TEXT NOT NULL REFERENCES vaults(id),
+			fid TEXT NOT NULL REFERENCES tee_instances(fid),
+			created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (vault_id, fid)
+		)`,
+		`CREATE TABLE IF NOT EXISTS debug_policies (
+			vault_id TEXT NOT NULL REFERENCES vaults(id),
+			fid TEXT NOT NULL REFERENCES tee_instances(fid),
+			allow_read INTEGER NOT NULL DEFAULT 1,
+			updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (vault_id, fid)
+		)`,
+		`CREATE TABLE IF NOT EXISTS master_keys (
+			id TEXT PRIMARY KEY,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			retired_at TIMESTAMPTZ
+		)`,
+	}
+
+	for _, t := range tables {
+		if _, err := tx.Exec(t); err != nil {
+			return fmt.Errorf("create table: %w", err)
+		}
+	}
+	return nil
+}
+
+// migratePostgresItemSections renames the baseline field columns to
+// item/field_name and adds the section column. PostgreSQL alters tables in
+// place, so no rebuild is needed.
+func (s *SQLStore) migratePostgresItemSections(tx *dialectTx) error {
+	for _, table := range encryptedTables {
+		for _, stmt := range []string{
+			`ALTER TABLE ` + table + ` RENAME COLUMN section TO item`,
+			`ALTER TABLE ` + table + ` RENAME COLUMN item_name TO field_name`,
+			`ALTER TABLE ` + table + ` ADD COLUMN section TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE ` + table + ` DROP CONSTRAINT ` + table + `_field_key`,
+			`ALTER TABLE ` + table + ` ADD CONSTRAINT ` + table + `_field_key UNIQUE (` + fieldKeyColumns(table, "item", "section", "field_name") + `)`,
+		} {
+			if _, err := tx.Exec(stmt); err != nil {
+				return fmt.Errorf("alter %s: %w", table, err)
+			}
+		}
+	}
+	return nil
+}
+
+// revertPostgresItemSections restores the baseline field columns. Like the
+// SQLite revert, it refuses while any field lives in a named section.
+func (s *SQLStore) revertPostgresItemSections(tx *dialectTx) error {
+	if err := checkNoNamedSections(tx); err != nil {
+		return err
+	}
+	for _, table := range encryptedTables {
+		for _, stmt := range []string{
+			`ALTER TABLE ` + table + ` DROP CONSTRAINT ` + table + `_field_key`,
+			`ALTER TABLE ` + table + ` DROP COLUMN section`,
+			`ALTER TABLE ` + table + ` RENAME COLUMN field_name TO item_name`,
+			`ALTER TABLE ` + table + ` RENAME COLUMN item TO section`,
+			`ALTER TABLE ` + table + ` ADD CONSTRAINT ` + table + `_field_key UNIQUE (` + fieldKeyColumns(table, "section", "item_name") + `)`,
+		} {
+			if _, err := tx.Exec(stmt); err != nil {
+				return fmt.Errorf("alter %s: %w", table, err)
+			}
+		}
+	}
+	return nil
+}
+
+// migratePostgresFieldExpiries adds the field_expiries table.
+func (s *SQLStore) migratePostgresFieldExpiries(tx *dialectTx) error {
+	if _, err := tx.Exec(`CREATE TABLE IF NOT EXISTS field_expiries (
+		vault_id TEXT NOT NULL REFERENCES vaults(id),
+		item TEXT NOT NULL,
+		section TEXT NOT NULL DEFAULT '',
+		field_name TEXT NOT NULL DEFAULT '',
+		expires_at TIMESTAMPTZ NOT NULL,
+		PRIMARY KEY (vault_id, item, section, field_name)
+	)`); err != nil {
+		return fmt.Errorf("create field_expiries: %w", err)
+	}
+	return nil
+}
+
+// migratePostgresTrash adds the deleted_at column that marks trashed rows.
+func (s *SQLStore) migratePostgresTrash(tx *dialectTx) error {
+	return addTrashColumns(s, tx, "TIMESTAMPTZ")
+}
+
+// migratePostgresAuditEvents adds the audit_events table.
+func (s *SQLStore) migratePostgresAuditEvents(tx *dialectTx) error {
+	return createAuditEvents(tx, "BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY", "TIMESTAMPTZ")
+}
+
+// migratePostgresAuditChain adds the audit hash chain and signed heads.
+func (s *SQLStore) migratePostgresAuditChain(tx *dialectTx) error {
+	return addAuditChain(s, tx, "BIGINT", "TIMESTAMPTZ")
+}
+
+// migratePostgresFieldGrants adds the field_grants table.
+func (s *SQLStore) migratePostgresFieldGrants(tx *dialectTx) error {
+	return createFieldGrants(tx, "TIMESTAMPTZ")
+}
+
+// migratePostgresGrantWindows adds the optional validity window of vault
+// grants.
+func (s *SQLStore) migratePostgresGrantWindows(tx *dialectTx) error {
+	return addGrantWindows(s, tx, "TIMESTAMPTZ")
+}
+
+// migratePostgresAppGrants adds the vault_app_access table.
+func (s *SQLStore) migratePostgresAppGrants(tx *dialectTx) error {
+	return createAppGrants(tx, "TIMESTAMPTZ")
+}
+
+// migratePostgresEnrollmentPolicies adds the enrollment policy tables.
+func (s *SQLStore) migratePostgresEnrollmentPolicies(tx *dialectTx) error {
+	return createEnrollmentPolicies(tx, "TIMESTAMPTZ")
+}
+
+// migratePostgresEnrollmentTokens adds the enrollment token tables.
+func (s *SQLStore) migratePostgresEnrollmentTokens(tx *dialectTx) error {
+	return createEnrollmentTokens(tx, "TIMESTAMPTZ")
+}
+
+// migratePostgresInstanceAliases adds the instance_aliases table.
+func (s *SQLStore) migratePostgresInstanceAliases(tx *dialectTx) error {
+	return createInstanceAliases(tx, "TIMESTAMPTZ")
+}
+
+// migratePostgresInstanceStatus adds the status of TEE instances.
+func (s *SQLStore) migratePostgresInstanceStatus(tx *dialectTx) error {
+	return addInstanceStatus(s, tx, "TIMESTAMPTZ")
+}
+
+// migratePostgresMeasurementPolicies adds the measurement policy tables.
+func (s *SQLStore) migratePostgresMeasurementPolicies(tx *dialectTx) error {
+	return createMeasurementPolicies(tx, "TIMESTAMPTZ")
+}
+
+// fieldKeyColumns lists the unique key columns of a field table: the vault,
+// the given coordinates and, for the history table, the version.
+func fieldKeyColumns(table string, coords ...string) string {
+	cols := append([]string{"vault_id"}, coords...)
+	if table == "vault_item_versions" {
+		cols = append(cols, "version")
+	}
+	return strings.Join(cols, ", ")
+}
@@ -0,0 +1,116 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Re-keying replaces the public key of an instance. The FID is derived from
+// the key, so the instance moves to a new FID: its row, grants, debug and
+// measurement policies follow, and an alias from the old FID keeps its audit
+// history attached. The old FID is retired and cannot be registered again.
+
+// fidTables lists the tables whose rows belong to an instance by FID, other
+// than tee_instances itself.
+var fidTables = []string{"vault_instance_access", "field_grants", "debug_policies"}
+
+// RekeyInstance moves the instance fid to newFID and newPublicKey in one
+// transaction. It reports false if there is no such instance outside the
+// trash, and fails like RegisterInstance if the new FID or key is taken.
+func (s *SQLStore) RekeyInstance(fid, newFID string, newPublicKey []byte) (bool, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return false, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	var (
+		inst       = TEEInstance{FID: newFID, PublicKey: newPublicKey}
+		createdAt  time.Time
+		lastUsedAt *time.Time
+	)
+	err = tx.QueryRow(
+		`SELECT label, dstack_app_id, created_at, last_used_at, status, status_reason, status_changed_at
+		 FROM tee_instances WHERE fid = ? AND deleted_at IS NULL`, fid,
+	).Scan(&inst.Label, &inst.DstackAppID, &createdAt, &lastUsedAt, &inst.Status, &inst.StatusReason, &inst.StatusChangedAt)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("get instance: %w", err)
+	}
+
+	if err := insertInstance(tx, &inst); err != nil {
+		// A failed statement aborts a PostgreSQL transaction, so the
+		// conflict is looked up outside it.
+		tx.Rollback()
+		return false, s.registerError(&inst, err)
+	}
+	if _, err := tx.Exec(
+		`UPDATE tee_instances SET created_at = ?, last_used_at = ?, status = ?, status_reason = ?, status_changed_at = ? WHERE fid = ?`,
+		s.dialect.timestamp(createdAt), s.dialect.nullTimestamp(lastUsedAt),
+		inst.Status, inst.StatusReason, s.dialect.nullTimestamp(inst.StatusChangedAt), newFID,
+	); err != nil {
+		return false, fmt.Errorf("copy instance state: %w", err)
+	}
+	for _, table := range fidTables {
+		if _, err := tx.Exec(`UPDATE `+table+` SET fid = ? WHERE fid = ?`, newFID, fid); err != nil {
+			return false, fmt.Errorf("move %s: %w", table, err)
+		}
+	}
+	if err := moveMeasurementPolicy(tx, MeasurementScopeInstance, fid, newFID); err != nil {
+		return false, err
+	}
+	// Earlier aliases follow the instance, so every old FID resolves in one
+	// step.
+	if _, err := tx.Exec(`UPDATE instance_aliases SET fid = ? WHERE fid = ?`, newFID, fid); err != nil {
+		return false, fmt.Errorf("move instance aliases: %w", err)
+	}
+	if _, err := tx.Exec(`INSERT INTO instance_aliases (alias_fid, fid) VALUES (?, ?)`, fid, newFID); err != nil {
+		return false, fmt.Errorf("add instance alias: %w", err)
+	}
+	if _, err := tx.Exec(`DELETE FROM tee_instances WHERE fid = ?`, fid); err != nil {
+		return false, fmt.Errorf("delete old instance: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("commit tx: %w", err)
+	}
+	return true, nil
+}
+
+// ResolveInstanceAlias returns the current FID of the instance that was
+// re-keyed away from fid, or "" if fid is not an alias.
+func (s *SQLStore) ResolveInstanceAlias(fid string) (string, error) {
+	var current string
+	err := s.db.QueryRow(`SELECT fid FROM instance_aliases WHERE alias_fid = ?`, fid).Scan(&current)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("resolve instance alias: %w", err)
+	}
+	return current, nil
+}
+
+// ListInstanceAliases returns the previous FIDs of an instance, oldest first.
+func (s *SQLStore) ListInstanceAliases(fid string) ([]InstanceAlias, error) {
+	rows, err := s.db.Query(
+		`SELECT alias_fid, fid, created_at FROM instance_aliases WHERE fid = ? ORDER BY created_at, alias_fid`, fid,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("list instance aliases: %w", err)
+	}
+	defer rows.Close()
+
+	var aliases []InstanceAlias
+	for rows.Next() {
+		var a InstanceAlias
+		if err := rows.Scan(&a.AliasFID, &a.FID, &a.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan instance alias: %w", err)
+		}
+		aliases = append(aliases, a)
+	}
+	return aliases, rows.Err()
+}
@@ -0,0 +1,111 @@
+package db
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRekeyInstance(t *testing.T) {
+	s := newTestStore(t)
+	s.CreateVault(&Vault{ID: "v1", Name: "V1"})
+	s.RegisterInstance(&TEEInstance{FID: "f1", PublicKey: []byte("pk1"), DstackAppID: "app1", Label: "web"})
+	s.GrantVaultAccess("v1", "f1", nil, nil)
+	if err := s.PutFieldGrant(&FieldGrant{VaultID: "v1", FID: "f1", Item: "db", FieldName: "*", Effect: GrantAllow}); err != nil {
+		t.Fatalf("PutFieldGrant: %v", err)
+	}
+	s.UpsertDebugPolicy("v1", "f1", true)
+	s.UpdateLastUsed("f1")
+	before, _ := s.GetInstance("f1")
+
+	if ok, err := s.RekeyInstance("missing", "f9", []byte("pk9")); err != nil || ok {
+		t.Errorf("RekeyInstance(missing) = %v, %v; want false", ok, err)
+	}
+
+	if ok, err := s.RekeyInstance("f1", "f2", []byte("pk2")); err != nil || !ok {
+		t.Fatalf("RekeyInstance = %v, %v", ok, err)
+	}
+	if inst, _ := s.GetInstance("f1"); inst != nil {
+		t.Errorf("old FID still resolves to %+v", inst)
+	}
+	after, _ := s.GetInstance("f2")
+	if after == nil || string(after.PublicKey) != "pk2" || after.Label != "web" || after.DstackAppID != "app1" ||
+		!after.CreatedAt.Equal(before.CreatedAt) || after.LastUsedAt == nil {
+		t.Fatalf("re-keyed instance = %+v, want web/app1 with pk2 and the old timestamps", after)
+	}
+	if ok, _ := s.HasVaultAccess("v1", "f2"); !ok {
+		t.Error("vault access did not move to the new FID")
+	}
+	if ok, _ := s.HasVaultAccess("v1", "f1"); ok {
+		t.Error("old FID kept vault access")
+	}
+	if ok, _ := s.HasFieldAccess("v1", "f2", "", "db", "", "password"); !ok {
+		t.Error("field grant did not move to the new FID")
+	}
+	if p, _ := s.GetDebugPolicy("v1", "f2"); p == nil || !p.AllowRead {
+		t.Errorf("debug policy = %+v, want allow_read for the new FID", p)
+	}
+
+	// A second rotation re-points the first alias.
+	if ok, err := s.RekeyInstance("f2", "f3", []byte("pk3")); err != nil || !ok {
+		t.Fatalf("second RekeyInstance = %v, %v", ok, err)
+	}
+	for _, fid := range []string{"f1", "f2"} {
+		if got, err := s.ResolveInstanceAlias(fid); err != nil || got != "f3" {
+			t.Errorf("ResolveInstanceAlias(%s) = %q, %v; want f3", fid, got, err)
+		}
+	}
+	if got, _ := s.ResolveInstanceAlias("f3"); got != "" {
+		t.Errorf("ResolveInstanceAlias(f3) = %q, want none", got)
+	}
+	if aliases, _ := s.ListInstanceAliases("f3"); len(aliases) != 2 || aliases[0].AliasFID != "f1" || aliases[1].AliasFID != "f2" {
+		t.Errorf("ListInstanceAliases = %+v, want f1 and f2", aliases)
+	}
+
+	// Retired keys and keys of other instances cannot be taken.
+	if err := s.RegisterInstance(&TEEInstance{FID: "f1", PublicKey: []byte("pk1")}); err != ErrInstanceRetired {
+		t.Errorf("registering a retired FID = %v, want ErrInstanceRetired", err)
+	}
+	if _, err := s.RekeyInstance("f3", "f2", []byte("pk2")); err != ErrInstanceRetired {
+		t.Errorf("re-keying to a retired FID = %v, want ErrInstanceRetired", err)
+	}
+	s.RegisterInstance(&TEEInstance{FID: "f8", PublicKey: []byte("pk8")})
+	if _, err := s.RekeyInstance("f3", "f9", []byte("pk8")); err != ErrInstanceDuplicateKey {
+		t.Errorf("re-keying to a taken key = %v, want ErrInstanceDuplicateKey", err)
+	}
+	if inst, _ := s.GetInstance("f3"); inst == nil {
+		t.Error("instance lost after a failed re-key")
+	}
+
+	// Purging the instance forgets its aliases.
+	s.DeleteInstance("f3")
+	if ok, err := s.PurgeInstance("f3"); err != nil || !ok {
+		t.Fatalf("PurgeInstance = %v, %v", ok, err)
+	}
+	if got, _ := s.ResolveInstanceAlias("f1"); got != "" {
+		t.Errorf("alias survived the purge: %q", got)
+	}
+	if err := s.RegisterInstance(&TEEInstance{FID: "f1", PublicKey: []byte("pk1")}); err != nil {
+		t.Errorf("RegisterInstance after purge: %v", err)
+	}
+}
+
+func TestRekeyInstance_AuditHistory(t *testing.T) {
+	s := newTestStore(t)
+	s.RegisterInstance(&TEEInstance{FID: "f1", PublicKey: []byte("pk1")})
+	base := time.Date(2030, 1, 1, 12, 0, 0, 0, time.UTC)
+	s.RecordAuditEvent(&AuditEvent{Time: base, Action: "secrets.fetch", Outcome: AuditSuccess, FID: "f1"})
+	s.RecordAuditEvent(&AuditEvent{Time: base, Action: "secrets.fetch", Outcome: AuditSuccess, FID: "other"})
+	s.RekeyInstance("f1", "f2", []byte("pk2"))
+	s.RecordAuditEvent(&AuditEvent{Time: base.Add(time.Minute), Action: "secrets.fetch", Outcome: AuditSuccess, FID: "f2"})
+
+	events, err := s.ListAuditEvents(AuditQuery{FID: "f2"})
+	if err != nil {
+		t.Fatalf("ListAuditEvents: %v", err)
+	}
+	if len(events) != 2 || events[0].FID != "f2" || events[1].FID != "f1" {
+		t.Errorf("history of f2 = %+v, want its events under f2 and f1", events)
+	}
+	if events, _ := s.ListAuditEvents(AuditQuery{FID: "f1"}); len(events) != 1 {
+		t.Errorf("history of f1 = %+v, want its own event only", events)
+	}
+}
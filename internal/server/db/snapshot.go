@@ -0,0 +1,710 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Snapshot is a consistent copy of everything a store holds, with field
+// values in plaintext. It is taken by Store.Snapshot for backups and loaded
+// with Store.Restore; callers must encrypt it before it leaves the process.
+type Snapshot struct {
+	// SchemaVersion is the schema version of the store the snapshot was
+	// taken from.
+	SchemaVersion int               `json:"schema_version"`
+	Vaults        []Vault           `json:"vaults"`
+	Fields        []SnapshotField   `json:"fields"`
+	Versions      []SnapshotVersion `json:"versions"`
+	// Expiries are the expiry dates as set; an empty FieldName is the
+	// expiry of a whole section.
+	Expiries      []FieldExpiry `json:"expiries"`
+	Instances     []TEEInstance `json:"instances"`
+	Grants        []VaultAccess `json:"grants"`
+	FieldGrants   []FieldGrant  `json:"field_grants"`
+	AppGrants     []AppAccess   `json:"app_grants"`
+	DebugPolicies []DebugPolicy `json:"debug_policies"`
+	// EnrollmentPolicies list their vaults including ones in the trash.
+	EnrollmentPolicies []EnrollmentPolicy `json:"enrollment_policies"`
+	// EnrollmentTokens carry only the hashes of their secrets, and list
+	// their vaults including ones in the trash.
+	EnrollmentTokens    []EnrollmentToken   `json:"enrollment_tokens"`
+	InstanceAliases     []InstanceAlias     `json:"instance_aliases"`
+	MeasurementPolicies []MeasurementPolicy `json:"measurement_policies"`
+}
+
+// SnapshotField is the current value of a vault field.
+type SnapshotField struct {
+	VaultID   string    `json:"vault_id"`
+	Item      string    `json:"item"`
+	Section   string    `json:"section"`
+	FieldName string    `json:"field_name"`
+	Version   int       `json:"version"`
+	Value     string    `json:"value"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// DeletedAt is set while the field's section is in the trash.
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+}
+
+// SnapshotVersion is a retained historical value of a vault field.
+type SnapshotVersion struct {
+	VaultID   string    `json:"vault_id"`
+	Item      string    `json:"item"`
+	Section   string    `json:"section"`
+	FieldName string    `json:"field_name"`
+	Version   int       `json:"version"`
+	Value     string    `json:"value"`
+	Author    string    `json:"author"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Validate checks that a snapshot is self-consistent: keys are unique and
+// every field, expiry, grant and policy refers to a vault and instance in the
+// snapshot, and field grants and measurement policies are well formed.
+// Restore only loads snapshots that pass.
+func (snap *Snapshot) Validate() error {
+	vaults := map[string]bool{}
+	for _, v := range snap.Vaults {
+		if vaults[v.ID] {
+			return fmt.Errorf("duplicate vault %q", v.ID)
+		}
+		vaults[v.ID] = true
+	}
+
+	fields := map[fieldKey]bool{}
+	for _, f := range snap.Fields {
+		k := fieldKey{f.VaultID, f.Item, f.Section, f.FieldName}
+		if !vaults[f.VaultID] {
+			return fmt.Errorf("field %s: vault %q does not exist", fieldPath(k.vaultID, k.item, k.section, k.field), f.VaultID)
+		}
+		if fields[k] {
+			return fmt.Errorf("duplicate field %s", fieldPath(k.vaultID, k.item, k.section, k.field))
+		}
+		fields[k] = true
+	}
+
+	type versionKey struct {
+		fieldKey
+		version int
+	}
+	versions := map[versionKey]bool{}
+	for _, v := range snap.Versions {
+		k := versionKey{fieldKey{v.VaultID, v.Item, v.Section, v.FieldName}, v.Version}
+		if !vaults[v.VaultID] {
+			return fmt.Errorf("version %s@%d: vault %q does not exist", fieldPath(k.vaultID, k.item, k.section, k.field), v.Version, v.VaultID)
+		}
+		if versions[k] {
+			return fmt.Errorf("duplicate version %s@%d", fieldPath(k.vaultID, k.item, k.section, k.field), v.Version)
+		}
+		versions[k] = true
+	}
+
+	expiries := map[fieldKey]bool{}
+	for _, e := range snap.Expiries {
+		k := fieldKey{e.VaultID, e.Item, e.Section, e.FieldName}
+		if !vaults[e.VaultID] {
+			return fmt.Errorf("expiry %s: vault %q does not exist", fieldPath(k.vaultID, k.item, k.section, k.field), e.VaultID)
+		}
+		if expiries[k] {
+			return fmt.Errorf("duplicate expiry %s", fieldPath(k.vaultID, k.item, k.section, k.field))
+		}
+		expiries[k] = true
+	}
+
+	instances := map[string]bool{}
+	for i, inst := range snap.Instances {
+		if instances[inst.FID] {
+			return fmt.Errorf("duplicate instance %q", inst.FID)
+		}
+		instances[inst.FID] = true
+		if inst.Status != "" && !ValidInstanceStatus(inst.Status) {
+			return fmt.Errorf("instance %q: unknown status %q", inst.FID, inst.Status)
+		}
+		for _, other := range snap.Instances[:i] {
+			if bytes.Equal(other.PublicKey, inst.PublicKey) {
+				return fmt.Errorf("instances %q and %q share a public key", other.FID, inst.FID)
+			}
+		}
+	}
+
+	aliases := map[string]bool{}
+	for _, a := range snap.InstanceAliases {
+		if !instances[a.FID] {
+			return fmt.Errorf("instance alias %q: instance %q does not exist", a.AliasFID, a.FID)
+		}
+		if instances[a.AliasFID] {
+			return fmt.Errorf("instance alias %q is also an instance", a.AliasFID)
+		}
+		if aliases[a.AliasFID] {
+			return fmt.Errorf("duplicate instance alias %q", a.AliasFID)
+		}
+		aliases[a.AliasFID] = true
+	}
+
+	check := func(kind, vaultID, fid string, seen map[accessKey]bool) error {
+		if !vaults[vaultID] {
+			return fmt.Errorf("%s %s/%s: vault %q does not exist", kind, vaultID, fid, vaultID)
+		}
+		if !instances[fid] {
+			return fmt.Errorf("%s %s/%s: instance %q does not exist", kind, vaultID, fid, fid)
+		}
+		k := accessKey{vaultID, fid}
+		if seen[k] {
+			return fmt.Errorf("duplicate %s %s/%s", kind, vaultID, fid)
+		}
+		seen[k] = true
+		return nil
+	}
+	grants := map[accessKey]bool{}
+	for _, g := range snap.Grants {
+		if err := check("grant", g.VaultID, g.FID, grants); err != nil {
+			return err
+		}
+	}
+	fieldGrants := map[grantKey]bool{}
+	for _, g := range snap.FieldGrants {
+		where := fmt.Sprintf("field grant %s for %s", fieldPath(g.VaultID, g.Item, g.Section, g.FieldName), g.FID)
+		if !vaults[g.VaultID] {
+			return fmt.Errorf("%s: vault %q does not exist", where, g.VaultID)
+		}
+		if !instances[g.FID] {
+			return fmt.Errorf("%s: instance %q does not exist", where, g.FID)
+		}
+		if err := ValidateFieldGrant(&g); err != nil {
+			return fmt.Errorf("%s: %w", where, err)
+		}
+		k := grantKey{g.VaultID, g.FID, g.Item, g.Section, g.FieldName}
+		if fieldGrants[k] {
+			return fmt.Errorf("duplicate %s", where)
+		}
+		fieldGrants[k] = true
+	}
+	appGrants := map[appKey]bool{}
+	for _, g := range snap.AppGrants {
+		if !vaults[g.VaultID] {
+			return fmt.Errorf("app grant %s/%s: vault %q does not exist", g.VaultID, g.DstackAppID, g.VaultID)
+		}
+		if g.DstackAppID == "" {
+			return fmt.Errorf("app grant on vault %q has no dstack_app_id", g.VaultID)
+		}
+		k := appKey{g.VaultID, g.DstackAppID}
+		if appGrants[k] {
+			return fmt.Errorf("duplicate app grant %s/%s", g.VaultID, g.DstackAppID)
+		}
+		appGrants[k] = true
+	}
+	policies := map[accessKey]bool{}
+	for _, p := range snap.DebugPolicies {
+		if err := check("debug policy", p.VaultID, p.FID, policies); err != nil {
+			return err
+		}
+	}
+	enrollments := map[string]bool{}
+	for _, p := range snap.EnrollmentPolicies {
+		if p.DstackAppID == "" {
+			return fmt.Errorf("enrollment policy has no dstack_app_id")
+		}
+		if enrollments[p.DstackAppID] {
+			return fmt.Errorf("duplicate enrollment policy %q", p.DstackAppID)
+		}
+		enrollments[p.DstackAppID] = true
+		seen := map[string]bool{}
+		for _, vaultID := range p.Vaults {
+			if !vaults[vaultID] {
+				return fmt.Errorf("enrollment policy %q: vault %q does not exist", p.DstackAppID, vaultID)
+			}
+			if seen[vaultID] {
+				return fmt.Errorf("enrollment policy %q: duplicate vault %q", p.DstackAppID, vaultID)
+			}
+			seen[vaultID] = true
+		}
+	}
+	tokens, hashes := map[string]bool{}, map[string]bool{}
+	for _, t := range snap.EnrollmentTokens {
+		if t.ID == "" || t.TokenHash == "" {
+			return fmt.Errorf("enrollment token has no id or token_hash")
+		}
+		if tokens[t.ID] || hashes[t.TokenHash] {
+			return fmt.Errorf("duplicate enrollment token %q", t.ID)
+		}
+		tokens[t.ID], hashes[t.TokenHash] = true, true
+		seen := map[string]bool{}
+		for _, vaultID := range t.Vaults {
+			if !vaults[vaultID] {
+				return fmt.Errorf("enrollment token %q: vault %q does not exist", t.ID, vaultID)
+			}
+			if seen[vaultID] {
+				return fmt.Errorf("enrollment token %q: duplicate vault %q", t.ID, vaultID)
+			}
+			seen[vaultID] = true
+		}
+	}
+	measurements := map[measurementPolicyKey]bool{}
+	for _, p := range snap.MeasurementPolicies {
+		where := fmt.Sprintf("measurement policy %s/%s", p.Scope, p.Target)
+		switch p.Scope {
+		case MeasurementScopeVault:
+			if !vaults[p.Target] {
+				return fmt.Errorf("%s: vault %q does not exist", where, p.Target)
+			}
+		case MeasurementScopeInstance:
+			if !instances[p.Target] {
+				return fmt.Errorf("%s: instance %q does not exist", where, p.Target)
+			}
+		default:
+			return fmt.Errorf("%s: unknown scope %q", where, p.Scope)
+		}
+		k := measurementPolicyKey{p.Scope, p.Target}
+		if measurements[k] {
+			return fmt.Errorf("duplicate %s", where)
+		}
+		measurements[k] = true
+		for name, values := range p.Allowed {
+			if !ValidMeasurement(name) {
+				return fmt.Errorf("%s: unknown measurement %q", where, name)
+			}
+			seen := map[string]bool{}
+			for _, v := range values {
+				if seen[v] {
+					return fmt.Errorf("%s: duplicate %s value %q", where, name, v)
+				}
+				seen[v] = true
+			}
+		}
+	}
+	return nil
+}
+
+// Snapshot reads the whole database in one transaction, decrypting every
+// field value.
+func (s *SQLStore) Snapshot() (*Snapshot, error) {
+	tx, err := s.db.BeginTx(context.Background(), s.dialect.snapshotTx)
+	if err != nil {
+		return nil, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	snap := &Snapshot{}
+	if err := tx.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&snap.SchemaVersion); err != nil {
+		return nil, fmt.Errorf("read schema version: %w", err)
+	}
+
+	queries := []struct {
+		what, query string
+		scan        func(*sql.Rows) error
+	}{
+		{"vaults", `SELECT id, name, version_retention, created_at, deleted_at FROM vaults ORDER BY created_at, id`,
+			func(rows *sql.Rows) error {
+				var v Vault
+				if err := rows.Scan(&v.ID, &v.Name, &v.VersionRetention, &v.CreatedAt, &v.DeletedAt); err != nil {
+					return err
+				}
+				snap.Vaults = append(snap.Vaults, v)
+				return nil
+			}},
+		{"fields", `SELECT vault_id, item, section, field_name, ciphertext, wrapped_key, key_id, version, created_at, updated_at, deleted_at
+		            FROM vault_items ORDER BY vault_id, item, section, field_name`,
+			func(rows *sql.Rows) error {
+				var f SnapshotField
+				var ct, wk []byte
+				var keyID string
+				if err := rows.Scan(&f.VaultID, &f.Item, &f.Section, &f.FieldName, &ct, &wk, &keyID, &f.Version, &f.CreatedAt, &f.UpdatedAt, &f.DeletedAt); err != nil {
+					return err
+				}
+				value, err := s.keys.open(keyID, fieldAAD(f.VaultID, f.Item, f.Section, f.FieldName), ct, wk)
+				if err != nil {
+					return fmt.Errorf("open %s: %w", fieldPath(f.VaultID, f.Item, f.Section, f.FieldName), err)
+				}
+				f.Value = value
+				snap.Fields = append(snap.Fields, f)
+				return nil
+			}},
+		{"versions", `SELECT vault_id, item, section, field_name, version, ciphertext, wrapped_key, key_id, author, created_at
+		              FROM vault_item_versions ORDER BY vault_id, item, section, field_name, version`,
+			func(rows *sql.Rows) error {
+				var v SnapshotVersion
+				var ct, wk []byte
+				var keyID string
+				if err := rows.Scan(&v.VaultID, &v.Item, &v.Section, &v.FieldName, &v.Version, &ct, &wk, &keyID, &v.Author, &v.CreatedAt); err != nil {
+					return err
+				}
+				value, err := s.keys.open(keyID, fieldAAD(v.VaultID, v.Item, v.Section, v.FieldName), ct, wk)
+				if err != nil {
+					return fmt.Errorf("open %s@%d: %w", fieldPath(v.VaultID, v.Item, v.Section, v.FieldName), v.Version, err)
+				}
+				v.Value = value
+				snap.Versions = append(snap.Versions, v)
+				return nil
+			}},
+		{"expiries", `SELECT vault_id, item, section, field_name, expires_at
+		              FROM field_expiries ORDER BY vault_id, item, section, field_name`,
+			func(rows *sql.Rows) error {
+				var e FieldExpiry
+				if err := rows.Scan(&e.VaultID, &e.Item, &e.Section, &e.FieldName, &e.ExpiresAt); err != nil {
+					return err
+				}
+				snap.Expiries = append(snap.Expiries, e)
+				return nil
+			}},
+		{"instances", `SELECT fid, label, public_key, dstack_app_id, created_at, last_used_at, status, status_reason, status_changed_at, deleted_at
+		               FROM tee_instances ORDER BY created_at, fid`,
+			func(rows *sql.Rows) error {
+				var inst TEEInstance
+				if err := rows.Scan(&inst.FID, &inst.Label, &inst.PublicKey, &inst.DstackAppID, &inst.CreatedAt, &inst.LastUsedAt,
+					&inst.Status, &inst.StatusReason, &inst.StatusChangedAt, &inst.DeletedAt); err != nil {
+					return err
+				}
+				snap.Instances = append(snap.Instances, inst)
+				return nil
+			}},
+		{"instance aliases", `SELECT alias_fid, fid, created_at FROM instance_aliases ORDER BY created_at, alias_fid`,
+			func(rows *sql.Rows) error {
+				var a InstanceAlias
+				if err := rows.Scan(&a.AliasFID, &a.FID, &a.CreatedAt); err != nil {
+					return err
+				}
+				snap.InstanceAliases = append(snap.InstanceAliases, a)
+				return nil
+			}},
+		{"grants", `SELECT vault_id, fid, not_before, expires_at, created_at FROM vault_instance_access ORDER BY vault_id, fid`,
+			func(rows *sql.Rows) error {
+				var g VaultAccess
+				if err := rows.Scan(&g.VaultID, &g.FID, &g.NotBefore, &g.ExpiresAt, &g.CreatedAt); err != nil {
+					return err
+				}
+				snap.Grants = append(snap.Grants, g)
+				return nil
+			}},
+		{"field grants", `SELECT vault_id, fid, item, section, field_name, effect, created_at
+		                  FROM field_grants ORDER BY vault_id, fid, item, section, field_name`,
+			func(rows *sql.Rows) error {
+				var g FieldGrant
+				if err := rows.Scan(&g.VaultID, &g.FID, &g.Item, &g.Section, &g.FieldName, &g.Effect, &g.CreatedAt); err != nil {
+					return err
+				}
+				snap.FieldGrants = append(snap.FieldGrants, g)
+				return nil
+			}},
+		{"app grants", `SELECT vault_id, dstack_app_id, not_before, expires_at, created_at FROM vault_app_access ORDER BY vault_id, dstack_app_id`,
+			func(rows *sql.Rows) error {
+				var g AppAccess
+				if err := rows.Scan(&g.VaultID, &g.DstackAppID, &g.NotBefore, &g.ExpiresAt, &g.CreatedAt); err != nil {
+					return err
+				}
+				snap.AppGrants = append(snap.AppGrants, g)
+				return nil
+			}},
+		{"debug policies", `SELECT vault_id, fid, allow_read, updated_at FROM debug_policies ORDER BY vault_id, fid`,
+			func(rows *sql.Rows) error {
+				var p DebugPolicy
+				var allowInt int
+				if err := rows.Scan(&p.VaultID, &p.FID, &allowInt, &p.UpdatedAt); err != nil {
+					return err
+				}
+				p.AllowRead = allowInt != 0
+				snap.DebugPolicies = append(snap.DebugPolicies, p)
+				return nil
+			}},
+		{"enrollment policies", `SELECT dstack_app_id, label, created_at, updated_at FROM enrollment_policies ORDER BY dstack_app_id`,
+			func(rows *sql.Rows) error {
+				var p EnrollmentPolicy
+				if err := rows.Scan(&p.DstackAppID, &p.Label, &p.CreatedAt, &p.UpdatedAt); err != nil {
+					return err
+				}
+				snap.EnrollmentPolicies = append(snap.EnrollmentPolicies, p)
+				return nil
+			}},
+		{"enrollment policy vaults", `SELECT dstack_app_id, vault_id FROM enrollment_policy_vaults ORDER BY dstack_app_id, vault_id`,
+			func(rows *sql.Rows) error {
+				var appID, vaultID string
+				if err := rows.Scan(&appID, &vaultID); err != nil {
+					return err
+				}
+				for i := range snap.EnrollmentPolicies {
+					if p := &snap.EnrollmentPolicies[i]; p.DstackAppID == appID {
+						p.Vaults = append(p.Vaults, vaultID)
+						return nil
+					}
+				}
+				return fmt.Errorf("vault %q of missing enrollment policy %q", vaultID, appID)
+			}},
+		{"enrollment tokens", `SELECT ` + enrollmentTokenColumns + ` FROM enrollment_tokens ORDER BY created_at, id`,
+			func(rows *sql.Rows) error {
+				var t EnrollmentToken
+				if err := scanEnrollmentToken(rows, &t); err != nil {
+					return err
+				}
+				snap.EnrollmentTokens = append(snap.EnrollmentTokens, t)
+				return nil
+			}},
+		{"enrollment token vaults", `SELECT token_id, vault_id FROM enrollment_token_vaults ORDER BY token_id, vault_id`,
+			func(rows *sql.Rows) error {
+				var id, vaultID string
+				if err := rows.Scan(&id, &vaultID); err != nil {
+					return err
+				}
+				for i := range snap.EnrollmentTokens {
+					if t := &snap.EnrollmentTokens[i]; t.ID == id {
+						t.Vaults = append(t.Vaults, vaultID)
+						return nil
+					}
+				}
+				return fmt.Errorf("vault %q of missing enrollment token %q", vaultID, id)
+			}},
+		{"measurement policies", `SELECT scope, target, created_at, updated_at FROM measurement_policies ORDER BY scope, target`,
+			func(rows *sql.Rows) error {
+				var p MeasurementPolicy
+				if err := rows.Scan(&p.Scope, &p.Target, &p.CreatedAt, &p.UpdatedAt); err != nil {
+					return err
+				}
+				snap.MeasurementPolicies = append(snap.MeasurementPolicies, p)
+				return nil
+			}},
+		{"measurement policy values", `SELECT scope, target, measurement, value FROM measurement_policy_values ORDER BY scope, target, measurement, value`,
+			func(rows *sql.Rows) error {
+				var scope, target, name, value string
+				if err := rows.Scan(&scope, &target, &name, &value); err != nil {
+					return err
+				}
+				for i := range snap.MeasurementPolicies {
+					if p := &snap.MeasurementPolicies[i]; p.Scope == scope && p.Target == target {
+						if p.Allowed == nil {
+							p.Allowed = map[string][]string{}
+						}
+						p.Allowed[name] = append(p.Allowed[name], value)
+						return nil
+					}
+				}
+				return fmt.Errorf("%s value of missing measurement policy %s/%s", name, scope, target)
+			}},
+	}
+	for _, q := range queries {
+		if err := scanAll(tx, q.query, q.scan); err != nil {
+			return nil, fmt.Errorf("snapshot %s: %w", q.what, err)
+		}
+	}
+
+	return snap, nil
+}
+
+// scanAll runs query and calls scan for every row.
+func scanAll(tx *dialectTx, query string, scan func(*sql.Rows) error) error {
+	rows, err := tx.Query(query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if err := scan(rows); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// snapshotTables lists the tables Restore replaces, children first.
+var snapshotTables = []string{
+	"measurement_policy_values", "measurement_policies", "debug_policies", "field_grants", "vault_app_access", "enrollment_policy_vaults", "enrollment_policies", "enrollment_token_vaults", "enrollment_tokens", "vault_instance_access", "field_expiries", "vault_item_versions", "vault_items", "instance_aliases", "tee_instances", "vaults",
+}
+
+// Restore replaces the entire contents of the database with snap in a single
+// transaction, so readers see either the old data or the restored data.
+// Values are re-encrypted under the active master key. The snapshot must not
+// come from a newer schema than this binary knows.
+func (s *SQLStore) Restore(snap *Snapshot) error {
+	if snap.SchemaVersion > LatestSchemaVersion() {
+		return fmt.Errorf("%w: snapshot schema version %d, latest known %d", ErrSchemaTooNew, snap.SchemaVersion, LatestSchemaVersion())
+	}
+	if err := snap.Validate(); err != nil {
+		return fmt.Errorf("invalid snapshot: %w", err)
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, table := range snapshotTables {
+		if _, err := tx.Exec(`DELETE FROM ` + table); err != nil {
+			return fmt.Errorf("clear %s: %w", table, err)
+		}
+	}
+
+	ts := s.dialect.timestamp
+	optTS := s.dialect.nullTimestamp
+	for _, v := range snap.Vaults {
+		if _, err := tx.Exec(
+			`INSERT INTO vaults (id, name, version_retention, created_at, deleted_at) VALUES (?, ?, ?, ?, ?)`,
+			v.ID, v.Name, v.VersionRetention, ts(v.CreatedAt), optTS(v.DeletedAt),
+		); err != nil {
+			return fmt.Errorf("restore vault %q: %w", v.ID, err)
+		}
+	}
+
+	for _, f := range snap.Fields {
+		ct, wk, err := s.keys.active.seal(fieldAAD(f.VaultID, f.Item, f.Section, f.FieldName), f.Value)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(
+			`INSERT INTO vault_items (vault_id, item, section, field_name, ciphertext, wrapped_key, key_id, version, created_at, updated_at, deleted_at)
+			 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			f.VaultID, f.Item, f.Section, f.FieldName, ct, wk, s.keys.active.keyID, f.Version, ts(f.CreatedAt), ts(f.UpdatedAt), optTS(f.DeletedAt),
+		); err != nil {
+			return fmt.Errorf("restore field %s: %w", fieldPath(f.VaultID, f.Item, f.Section, f.FieldName), err)
+		}
+	}
+
+	for _, v := range snap.Versions {
+		ct, wk, err := s.keys.active.seal(fieldAAD(v.VaultID, v.Item, v.Section, v.FieldName), v.Value)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(
+			`INSERT INTO vault_item_versions (vault_id, item, section, field_name, version, ciphertext, wrapped_key, key_id, author, created_at)
+			 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			v.VaultID, v.Item, v.Section, v.FieldName, v.Version, ct, wk, s.keys.active.keyID, v.Author, ts(v.CreatedAt),
+		); err != nil {
+			return fmt.Errorf("restore version %s@%d: %w", fieldPath(v.VaultID, v.Item, v.Section, v.FieldName), v.Version, err)
+		}
+	}
+
+	for _, e := range snap.Expiries {
+		if _, err := tx.Exec(
+			`INSERT INTO field_expiries (vault_id, item, section, field_name, expires_at) VALUES (?, ?, ?, ?, ?)`,
+			e.VaultID, e.Item, e.Section, e.FieldName, ts(e.ExpiresAt),
+		); err != nil {
+			return fmt.Errorf("restore expiry %s: %w", fieldPath(e.VaultID, e.Item, e.Section, e.FieldName), err)
+		}
+	}
+
+	for _, inst := range snap.Instances {
+		if _, err := tx.Exec(
+			`INSERT INTO tee_instances (fid, label, public_key, dstack_app_id, created_at, last_used_at, status, status_reason, status_changed_at, deleted_at)
+			 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			inst.FID, inst.Label, inst.PublicKey, inst.DstackAppID, ts(inst.CreatedAt), optTS(inst.LastUsedAt),
+			restoredStatus(inst.Status), inst.StatusReason, optTS(inst.StatusChangedAt), optTS(inst.DeletedAt),
+		); err != nil {
+			return fmt.Errorf("restore instance %q: %w", inst.FID, err)
+		}
+	}
+	for _, a := range snap.InstanceAliases {
+		if _, err := tx.Exec(
+			`INSERT INTO instance_aliases (alias_fid, fid, created_at) VALUES (?, ?, ?)`,
+			a.AliasFID, a.FID, ts(a.CreatedAt),
+		); err != nil {
+			return fmt.Errorf("restore instance alias %q: %w", a.AliasFID, err)
+		}
+	}
+
+	for _, g := range snap.Grants {
+		if _, err := tx.Exec(
+			`INSERT INTO vault_instance_access (vault_id, fid, not_before, expires_at, created_at) VALUES (?, ?, ?, ?, ?)`,
+			g.VaultID, g.FID, optTS(g.NotBefore), optTS(g.ExpiresAt), ts(g.CreatedAt),
+		); err != nil {
+			return fmt.Errorf("restore grant %s/%s: %w", g.VaultID, g.FID, err)
+		}
+	}
+
+	for _, g := range snap.FieldGrants {
+		if _, err := tx.Exec(
+			`INSERT INTO field_grants (vault_id, fid, item, section, field_name, effect, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			g.VaultID, g.FID, g.Item, g.Section, g.FieldName, g.Effect, ts(g.CreatedAt),
+		); err != nil {
+			return fmt.Errorf("restore field grant %s for %s: %w", fieldPath(g.VaultID, g.Item, g.Section, g.FieldName), g.FID, err)
+		}
+	}
+
+	for _, g := range snap.AppGrants {
+		if _, err := tx.Exec(
+			`INSERT INTO vault_app_access (vault_id, dstack_app_id, not_before, expires_at, created_at) VALUES (?, ?, ?, ?, ?)`,
+			g.VaultID, g.DstackAppID, optTS(g.NotBefore), optTS(g.ExpiresAt), ts(g.CreatedAt),
+		); err != nil {
+			return fmt.Errorf("restore app grant %s/%s: %w", g.VaultID, g.DstackAppID, err)
+		}
+	}
+
+	for _, p := range snap.DebugPolicies {
+		allowInt := 0
+		if p.AllowRead {
+			allowInt = 1
+		}
+		if _, err := tx.Exec(
+			`INSERT INTO debug_policies (vault_id, fid, allow_read, updated_at) VALUES (?, ?, ?, ?)`,
+			p.VaultID, p.FID, allowInt, ts(p.UpdatedAt),
+		); err != nil {
+			return fmt.Errorf("restore debug policy %s/%s: %w", p.VaultID, p.FID, err)
+		}
+	}
+
+	for _, p := range snap.EnrollmentPolicies {
+		if _, err := tx.Exec(
+			`INSERT INTO enrollment_policies (dstack_app_id, label, created_at, updated_at) VALUES (?, ?, ?, ?)`,
+			p.DstackAppID, p.Label, ts(p.CreatedAt), ts(p.UpdatedAt),
+		); err != nil {
+			return fmt.Errorf("restore enrollment policy %q: %w", p.DstackAppID, err)
+		}
+		for _, vaultID := range p.Vaults {
+			if _, err := tx.Exec(
+				`INSERT INTO enrollment_policy_vaults (dstack_app_id, vault_id) VALUES (?, ?)`,
+				p.DstackAppID, vaultID,
+			); err != nil {
+				return fmt.Errorf("restore enrollment policy %q vault %q: %w", p.DstackAppID, vaultID, err)
+			}
+		}
+	}
+
+	for _, t := range snap.EnrollmentTokens {
+		if _, err := tx.Exec(
+			`INSERT INTO enrollment_tokens (id, token_hash, dstack_app_id, label, max_uses, uses, expires_at, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			t.ID, t.TokenHash, t.DstackAppID, t.Label, t.MaxUses, t.Uses, ts(t.ExpiresAt), ts(t.CreatedAt),
+		); err != nil {
+			return fmt.Errorf("restore enrollment token %q: %w", t.ID, err)
+		}
+		for _, vaultID := range t.Vaults {
+			if _, err := tx.Exec(
+				`INSERT INTO enrollment_token_vaults (token_id, vault_id) VALUES (?, ?)`,
+				t.ID, vaultID,
+			); err != nil {
+				return fmt.Errorf("restore enrollment token %q vault %q: %w", t.ID, vaultID, err)
+			}
+		}
+	}
+
+	for _, p := range snap.MeasurementPolicies {
+		if _, err := tx.Exec(
+			`INSERT INTO measurement_policies (scope, target, created_at, updated_at) VALUES (?, ?, ?, ?)`,
+			p.Scope, p.Target, ts(p.CreatedAt), ts(p.UpdatedAt),
+		); err != nil {
+			return fmt.Errorf("restore measurement policy %s/%s: %w", p.Scope, p.Target, err)
+		}
+		for name, values := range p.Allowed {
+			for _, value := range values {
+				if _, err := tx.Exec(
+					`INSERT INTO measurement_policy_values (scope, target, measurement, value) VALUES (?, ?, ?, ?)`,
+					p.Scope, p.Target, name, value,
+				); err != nil {
+					return fmt.Errorf("restore measurement policy %s/%s %s value: %w", p.Scope, p.Target, name, err)
+				}
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit tx: %w", err)
+	}
+	return nil
+}
+
+// restoredStatus is the status to restore an instance with. Snapshots taken
+// before instance statuses existed have none; those instances were active.
+func restoredStatus(status string) string {
+	if status == "" {
+		return InstanceActive
+	}
+	return status
+}
@@ -0,0 +1,316 @@
+package db
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// populate fills a store with one record of every kind the snapshot covers.
+func populate(t *testing.T, s Store) {
+	t.Helper()
+	s.CreateVault(&Vault{ID: "v1", Name: "V1", VersionRetention: 3})
+	s.CreateVault(&Vault{ID: "v2", Name: "V2"})
+	s.MergeItemFields("v1", "alice", "", map[string]string{"token": "one"}, nil, "alice-admin")
+	s.MergeItemFields("v1", "alice", "", map[string]string{"token": "two"}, nil, "bob-admin")
+	s.UpsertField("v1", "alice", "prod", "password", "hunter2")
+	s.SetExpiry("v1", "alice", "prod", "", time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC))
+	s.RegisterInstance(&TEEInstance{FID: "fid1", PublicKey: []byte("pubkey-32-bytes-placeholder-0001"), DstackAppID: "app1", Label: "one"})
+	s.RegisterInstance(&TEEInstance{FID: "fid0", PublicKey: []byte("pubkey-32-bytes-placeholder-0000"), DstackAppID: "app2"})
+	if err := s.PutMeasurementPolicy(&MeasurementPolicy{Scope: MeasurementScopeInstance, Target: "fid0", Allowed: map[string][]string{MeasurementRTMR3: {"bb", "aa"}}}); err != nil {
+		t.Fatalf("populate: %v", err)
+	}
+	if _, err := s.RekeyInstance("fid0", "fid2", []byte("pubkey-32-bytes-placeholder-0002")); err != nil {
+		t.Fatalf("populate: %v", err)
+	}
+	s.UpdateLastUsed("fid1")
+	s.SetInstanceStatus("fid2", InstanceQuarantined, "suspicious fetches")
+	s.GrantVaultAccess("v1", "fid1", nil, nil)
+	grantEnd := time.Date(2030, 6, 7, 8, 9, 10, 0, time.UTC)
+	s.GrantVaultAccess("v2", "fid2", nil, &grantEnd)
+	if err := s.PutFieldGrant(&FieldGrant{VaultID: "v1", FID: "fid2", Item: "alice", Section: "prod", FieldName: "*", Effect: GrantAllow}); err != nil {
+		t.Fatalf("populate: %v", err)
+	}
+	if err := s.GrantAppAccess("v2", "app1", &grantEnd, nil); err != nil {
+		t.Fatalf("populate: %v", err)
+	}
+	if err := s.UpsertDebugPolicy("v1", "fid1", true); err != nil {
+		t.Fatalf("populate: %v", err)
+	}
+	if err := s.PutEnrollmentPolicy(&EnrollmentPolicy{DstackAppID: "app3", Label: "worker-{fid}", Vaults: []string{"v2", "v1"}}); err != nil {
+		t.Fatalf("populate: %v", err)
+	}
+	if err := s.CreateEnrollmentToken(&EnrollmentToken{ID: "tok1", TokenHash: "hash1", DstackAppID: "app4", Label: "ci", MaxUses: 3, ExpiresAt: time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC), Vaults: []string{"v1"}}); err != nil {
+		t.Fatalf("populate: %v", err)
+	}
+	if err := s.PutMeasurementPolicy(&MeasurementPolicy{Scope: MeasurementScopeVault, Target: "v1", Allowed: map[string][]string{MeasurementMRTD: {"m1"}, MeasurementTDAttributes: {"00"}}}); err != nil {
+		t.Fatalf("populate: %v", err)
+	}
+}
+
+// normalizeSnapshot puts every timestamp in UTC so snapshots taken from
+// different backends compare equal.
+func normalizeSnapshot(snap *Snapshot) *Snapshot {
+	out := *snap
+	utc := func(t time.Time) time.Time { return t.UTC() }
+	out.Vaults = append([]Vault(nil), snap.Vaults...)
+	for i := range out.Vaults {
+		out.Vaults[i].CreatedAt = utc(out.Vaults[i].CreatedAt)
+	}
+	out.Fields = append([]SnapshotField(nil), snap.Fields...)
+	for i := range out.Fields {
+		out.Fields[i].CreatedAt = utc(out.Fields[i].CreatedAt)
+		out.Fields[i].UpdatedAt = utc(out.Fields[i].UpdatedAt)
+	}
+	out.Versions = append([]SnapshotVersion(nil), snap.Versions...)
+	for i := range out.Versions {
+		out.Versions[i].CreatedAt = utc(out.Versions[i].CreatedAt)
+	}
+	out.Expiries = append([]FieldExpiry(nil), snap.Expiries...)
+	for i := range out.Expiries {
+		out.Expiries[i].ExpiresAt = utc(out.Expiries[i].ExpiresAt)
+	}
+	out.Instances = append([]TEEInstance(nil), snap.Instances...)
+	for i := range out.Instances {
+		out.Instances[i].CreatedAt = utc(out.Instances[i].CreatedAt)
+		if lu := out.Instances[i].LastUsedAt; lu != nil {
+			t := utc(*lu)
+			out.Instances[i].LastUsedAt = &t
+		}
+		if sc := out.Instances[i].StatusChangedAt; sc != nil {
+			t := utc(*sc)
+			out.Instances[i].StatusChangedAt = &t
+		}
+	}
+	out.Grants = append([]VaultAccess(nil), snap.Grants...)
+	for i := range out.Grants {
+		out.Grants[i].CreatedAt = utc(out.Grants[i].CreatedAt)
+		if exp := out.Grants[i].ExpiresAt; exp != nil {
+			t := utc(*exp)
+			out.Grants[i].ExpiresAt = &t
+		}
+	}
+	out.AppGrants = append([]AppAccess(nil), snap.AppGrants...)
+	for i := range out.AppGrants {
+		out.AppGrants[i].CreatedAt = utc(out.AppGrants[i].CreatedAt)
+		if nb := out.AppGrants[i].NotBefore; nb != nil {
+			t := utc(*nb)
+			out.AppGrants[i].NotBefore = &t
+		}
+	}
+	out.FieldGrants = append([]FieldGrant(nil), snap.FieldGrants...)
+	for i := range out.FieldGrants {
+		out.FieldGrants[i].CreatedAt = utc(out.FieldGrants[i].CreatedAt)
+	}
+	out.DebugPolicies = append([]DebugPolicy(nil), snap.DebugPolicies...)
+	for i := range out.DebugPolicies {
+		out.DebugPolicies[i].UpdatedAt = utc(out.DebugPolicies[i].UpdatedAt)
+	}
+	out.EnrollmentPolicies = append([]EnrollmentPolicy(nil), snap.EnrollmentPolicies...)
+	for i := range out.EnrollmentPolicies {
+		out.EnrollmentPolicies[i].CreatedAt = utc(out.EnrollmentPolicies[i].CreatedAt)
+		out.EnrollmentPolicies[i].UpdatedAt = utc(out.EnrollmentPolicies[i].UpdatedAt)
+	}
+	out.EnrollmentTokens = append([]EnrollmentToken(nil), snap.EnrollmentTokens...)
+	for i := range out.EnrollmentTokens {
+		out.EnrollmentTokens[i].ExpiresAt = utc(out.EnrollmentTokens[i].ExpiresAt)
+		out.EnrollmentTokens[i].CreatedAt = utc(out.EnrollmentTokens[i].CreatedAt)
+	}
+	out.InstanceAliases = append([]InstanceAlias(nil), snap.InstanceAliases...)
+	for i := range out.InstanceAliases {
+		out.InstanceAliases[i].CreatedAt = utc(out.InstanceAliases[i].CreatedAt)
+	}
+	out.MeasurementPolicies = append([]MeasurementPolicy(nil), snap.MeasurementPolicies...)
+	for i := range out.MeasurementPolicies {
+		out.MeasurementPolicies[i].CreatedAt = utc(out.MeasurementPolicies[i].CreatedAt)
+		out.MeasurementPolicies[i].UpdatedAt = utc(out.MeasurementPolicies[i].UpdatedAt)
+	}
+	return &out
+}
+
+func TestSnapshot(t *testing.T) {
+	s := newTestStore(t)
+	populate(t, s)
+
+	snap, err := s.Snapshot()
+	if err != nil {
+		t.Fatalf("Snapshot: %v", err)
+	}
+	if snap.SchemaVersion != LatestSchemaVersion() {
+		t.Errorf("schema version = %d, want %d", snap.SchemaVersion, LatestSchemaVersion())
+	}
+	if len(snap.Vaults) != 2 || len(snap.Fields) != 2 || len(snap.Versions) != 3 || len(snap.Expiries) != 1 ||
+		len(snap.Instances) != 2 || len(snap.Grants) != 2 || len(snap.FieldGrants) != 1 || len(snap.AppGrants) != 1 || len(snap.DebugPolicies) != 1 {
+		t.Fatalf("unexpected snapshot sizes: %d vaults, %d fields, %d versions, %d expiries, %d instances, %d grants, %d field grants, %d app grants, %d policies",
+			len(snap.Vaults), len(snap.Fields), len(snap.Versions), len(snap.Expiries), len(snap.Instances), len(snap.Grants), len(snap.FieldGrants), len(snap.AppGrants), len(snap.DebugPolicies))
+	}
+	for _, f := range snap.Fields {
+		if f.FieldName == "token" && (f.Value != "two" || f.Version != 2) {
+			t.Errorf("token field = %+v, want value two at version 2", f)
+		}
+	}
+	if v := snap.Versions[0]; v.FieldName != "token" || v.Value != "one" || v.Author != "alice-admin" {
+		t.Errorf("first version = %+v, want token@1 by alice-admin", v)
+	}
+	if snap.Instances[0].LastUsedAt == nil {
+		t.Error("expected last_used_at to be kept for fid1")
+	}
+	if inst := snap.Instances[1]; inst.Status != InstanceQuarantined || inst.StatusReason != "suspicious fetches" || inst.StatusChangedAt == nil {
+		t.Errorf("fid2 = %+v, want it quarantined", inst)
+	}
+	if len(snap.EnrollmentPolicies) != 1 || !reflect.DeepEqual(snap.EnrollmentPolicies[0].Vaults, []string{"v1", "v2"}) {
+		t.Errorf("enrollment policies = %+v, want app3 granting v1 and v2", snap.EnrollmentPolicies)
+	}
+	if len(snap.EnrollmentTokens) != 1 || snap.EnrollmentTokens[0].TokenHash != "hash1" || snap.EnrollmentTokens[0].MaxUses != 3 ||
+		!reflect.DeepEqual(snap.EnrollmentTokens[0].Vaults, []string{"v1"}) {
+		t.Errorf("enrollment tokens = %+v, want tok1 granting v1", snap.EnrollmentTokens)
+	}
+	if len(snap.InstanceAliases) != 1 || snap.InstanceAliases[0].AliasFID != "fid0" || snap.InstanceAliases[0].FID != "fid2" {
+		t.Errorf("instance aliases = %+v, want fid0 for fid2", snap.InstanceAliases)
+	}
+	if p := snap.MeasurementPolicies; len(p) != 2 || p[0].Target != "fid2" || !reflect.DeepEqual(p[0].Allowed[MeasurementRTMR3], []string{"aa", "bb"}) ||
+		p[1].Scope != MeasurementScopeVault || len(p[1].Allowed) != 2 {
+		t.Errorf("measurement policies = %+v, want fid2 moved by the re-key and v1", p)
+	}
+	if err := snap.Validate(); err != nil {
+		t.Errorf("Validate: %v", err)
+	}
+}
+
+func TestRestore(t *testing.T) {
+	src := newTestStore(t)
+	populate(t, src)
+	snap, err := src.Snapshot()
+	if err != nil {
+		t.Fatalf("Snapshot: %v", err)
+	}
+
+	// Restore into a store of the same kind and into the in-memory store,
+	// both holding data that must be replaced.
+	for name, dst := range map[string]Store{"same backend": newTestStore(t), "memory": NewMemoryStore()} {
+		t.Run(name, func(t *testing.T) {
+			dst.CreateVault(&Vault{ID: "stale", Name: "Stale"})
+			dst.UpsertField("stale", "bob", "", "token", "old")
+
+			if err := dst.Restore(snap); err != nil {
+				t.Fatalf("Restore: %v", err)
+			}
+			if v, _ := dst.GetVault("stale"); v != nil {
+				t.Error("expected existing vault to be replaced")
+			}
+			val, err := dst.GetFieldValue("v1", "alice", "prod", "password")
+			if err != nil || val != "hunter2" {
+				t.Errorf("GetFieldValue = %q, %v; want hunter2", val, err)
+			}
+			if val, _ := dst.GetFieldVersionValue("v1", "alice", "", "token", 1); val != "one" {
+				t.Errorf("version 1 = %q, want one", val)
+			}
+			if ok, _ := dst.HasVaultAccess("v2", "fid2"); !ok {
+				t.Error("expected grant v2/fid2 to be restored")
+			}
+			if ok, _ := dst.HasFieldAccess("v1", "fid2", "", "alice", "prod", "password"); !ok {
+				t.Error("expected field grant v1/alice/prod for fid2 to be restored")
+			}
+
+			got, err := dst.Snapshot()
+			if err != nil {
+				t.Fatalf("Snapshot after restore: %v", err)
+			}
+			got.SchemaVersion = snap.SchemaVersion
+			if want := normalizeSnapshot(snap); !reflect.DeepEqual(normalizeSnapshot(got), want) {
+				t.Errorf("restored snapshot differs:\n got %+v\nwant %+v", normalizeSnapshot(got), want)
+			}
+
+			// New writes continue the restored history.
+			if err := dst.MergeItemFields("v1", "alice", "", map[string]string{"token": "three"}, nil, ""); err != nil {
+				t.Fatalf("MergeItemFields: %v", err)
+			}
+			if versions, _ := dst.ListFieldVersions("v1", "alice", "", "token"); len(versions) != 3 || versions[0].Version != 3 {
+				t.Errorf("expected 3 versions ending at 3, got %+v", versions)
+			}
+		})
+	}
+}
+
+func TestRestore_Rejected(t *testing.T) {
+	s := newTestStore(t)
+	populate(t, s)
+
+	tooNew := &Snapshot{SchemaVersion: LatestSchemaVersion() + 1}
+	if err := s.Restore(tooNew); !errors.Is(err, ErrSchemaTooNew) {
+		t.Errorf("expected ErrSchemaTooNew, got %v", err)
+	}
+
+	dangling := &Snapshot{
+		SchemaVersion: LatestSchemaVersion(),
+		Vaults:        []Vault{{ID: "v1", Name: "V1"}},
+		Grants:        []VaultAccess{{VaultID: "v1", FID: "missing"}},
+	}
+	if err := s.Restore(dangling); err == nil {
+		t.Error("expected a grant to a missing instance to be rejected")
+	}
+
+	badGrant := &Snapshot{
+		SchemaVersion: LatestSchemaVersion(),
+		Vaults:        []Vault{{ID: "v1", Name: "V1"}},
+		Instances:     []TEEInstance{{FID: "fid1", PublicKey: []byte("pk")}},
+		FieldGrants:   []FieldGrant{{VaultID: "v1", FID: "fid1", Item: "[", FieldName: "*", Effect: GrantAllow}},
+	}
+	if err := s.Restore(badGrant); !errors.Is(err, ErrInvalidGrant) {
+		t.Errorf("expected a malformed field grant to be rejected, got %v", err)
+	}
+
+	danglingApp := &Snapshot{
+		SchemaVersion: LatestSchemaVersion(),
+		AppGrants:     []AppAccess{{VaultID: "missing", DstackAppID: "app1"}},
+	}
+	if err := s.Restore(danglingApp); err == nil {
+		t.Error("expected an app grant on a missing vault to be rejected")
+	}
+
+	danglingEnrollment := &Snapshot{
+		SchemaVersion:      LatestSchemaVersion(),
+		EnrollmentPolicies: []EnrollmentPolicy{{DstackAppID: "app1", Vaults: []string{"missing"}}},
+	}
+	if err := s.Restore(danglingEnrollment); err == nil {
+		t.Error("expected an enrollment policy granting a missing vault to be rejected")
+	}
+
+	danglingToken := &Snapshot{
+		SchemaVersion:    LatestSchemaVersion(),
+		EnrollmentTokens: []EnrollmentToken{{ID: "t1", TokenHash: "h1", Vaults: []string{"missing"}}},
+	}
+	if err := s.Restore(danglingToken); err == nil {
+		t.Error("expected an enrollment token granting a missing vault to be rejected")
+	}
+
+	badStatus := &Snapshot{
+		SchemaVersion: LatestSchemaVersion(),
+		Instances:     []TEEInstance{{FID: "fid1", PublicKey: []byte("pk"), Status: "paused"}},
+	}
+	if err := s.Restore(badStatus); err == nil {
+		t.Error("expected an unknown instance status to be rejected")
+	}
+
+	danglingAlias := &Snapshot{
+		SchemaVersion:   LatestSchemaVersion(),
+		InstanceAliases: []InstanceAlias{{AliasFID: "old", FID: "missing"}},
+	}
+	if err := s.Restore(danglingAlias); err == nil {
+		t.Error("expected an alias of a missing instance to be rejected")
+	}
+
+	badMeasurement := &Snapshot{
+		SchemaVersion:       LatestSchemaVersion(),
+		Vaults:              []Vault{{ID: "v1", Name: "V1"}},
+		MeasurementPolicies: []MeasurementPolicy{{Scope: MeasurementScopeVault, Target: "v1", Allowed: map[string][]string{"mr_seam": {"aa"}}}},
+	}
+	if err := s.Restore(badMeasurement); err == nil {
+		t.Error("expected an unknown measurement to be rejected")
+	}
+
+	if val, err := s.GetFieldValue("v1", "alice", "", "token"); err != nil || val != "two" {
+		t.Errorf("existing data changed after rejected restores: %q, %v", val, err)
+	}
+}
@@ -1,21 +1,63 @@
 package db
 
 import (
-	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"strings"
+	"time"
 
-	_ "modernc.org/sqlite"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
 )
 
-// Store wraps a SQLite database connection.
-type Store struct {
-	db *sql.DB
+// sqliteDialect runs SQLStore on SQLite.
+var sqliteDialect = &dialect{
+	name:            "sqlite",
+	migrations:      migrations,
+	migrationsTable: sqliteMigrationsTable,
+	beginMigration:  beginSQLiteMigration,
+	checkMigration:  checkForeignKeys,
+	adoptUntracked:  adoptUntrackedSQLite,
+	columnExists:    columnExists,
+	constraint:      sqliteConstraint,
+	// A deferred transaction in WAL mode reads from the snapshot taken by its
+	// first statement until it ends.
+	snapshotTx: nil,
+	timestamp:  sqliteTimestamp,
+	// The pool holds a single connection.
+	lockAuditLog: "",
 }
 
-// NewStore opens or creates a SQLite database and runs migrations.
-func NewStore(dbPath string) (*Store, error) {
+// NewStore opens or creates a SQLite database and applies any pending schema
+// migrations. New field values are encrypted at rest under masterKey;
+// previousKeys are only used to decrypt rows that have not yet been re-wrapped
+// after a key rotation. Every key must be MasterKeyLen bytes long.
+func NewStore(dbPath string, masterKey []byte, previousKeys ...[]byte) (*SQLStore, error) {
+	s, err := OpenForMigration(dbPath, masterKey, previousKeys...)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.migrate(); err != nil {
+		s.Close()
+		return nil, fmt.Errorf("migrate: %w", err)
+	}
+	if err := s.checkMasterKey(); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// OpenForMigration opens a SQLite database without applying migrations, for
+// inspecting or changing the schema version with MigrationStatus, MigrateUp
+// and MigrateDown. Other Store methods require an up-to-date schema.
+func OpenForMigration(dbPath string, masterKey []byte, previousKeys ...[]byte) (*SQLStore, error) {
+	keys, err := newKeyring(masterKey, previousKeys...)
+	if err != nil {
+		return nil, err
+	}
+
 	db, err := sql.Open("sqlite", dbPath)
 	if err != nil {
 		return nil, fmt.Errorf("open database: %w", err)
@@ -32,48 +74,62 @@ func NewStore(dbPath string) (*Store, error) {
 		return nil, fmt.Errorf("set WAL mode: %w", err)
 	}
 
+	// Wait for locks held by other processes (e.g. rotate-master-key running
+	// next to the server) instead of failing immediately with SQLITE_BUSY.
+	if _, err := db.Exec("PRAGMA busy_timeout=5000"); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("set busy timeout: %w", err)
+	}
+
 	// Enable foreign key enforcement (off by default in SQLite, per-connection)
 	if _, err := db.Exec("PRAGMA foreign_keys=ON"); err != nil {
 		db.Close()
 		return nil, fmt.Errorf("enable foreign keys: %w", err)
 	}
 
-	s := &Store{db: db}
-	if err := s.migrate(); err != nil {
-		db.Close()
-		return nil, fmt.Errorf("migrate: %w", err)
-	}
-	return s, nil
+	return &SQLStore{db: &dialectDB{DB: db, d: sqliteDialect}, dialect: sqliteDialect, keys: keys}, nil
 }
 
-// Close closes the database connection.
-func (s *Store) Close() error {
-	return s.db.Close()
+// sqliteTimestamp formats t the way CURRENT_TIMESTAMP stores it.
+func sqliteTimestamp(t time.Time) any {
+	return t.UTC().Format("2006-01-02 15:04:05")
 }
 
-func (s *Store) migrate() error {
+// sqliteConstraint maps SQLite extended result codes to constraint kinds.
+func sqliteConstraint(err error) constraintKind {
+	var sqliteErr *sqlite.Error
+	if !errors.As(err, &sqliteErr) {
+		return constraintNone
+	}
+	switch sqliteErr.Code() {
+	case sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY:
+		return constraintPrimaryKey
+	case sqlite3.SQLITE_CONSTRAINT_UNIQUE:
+		return constraintUnique
+	case sqlite3.SQLITE_CONSTRAINT_FOREIGNKEY:
+		return constraintForeignKey
+	}
+	return constraintNone
+}
+
+// migrateBaseline creates the schema as it was before versioned migrations
+// were introduced. Databases created by earlier releases are upgraded in
+// place: every step below detects whether it still has work to do.
+func (s *SQLStore) migrateBaseline(tx *dialectTx) error {
 	// Check if we need to upgrade from the old schema (v1).
-	if err := s.upgradeToSchemaV2(); err != nil {
+	if err := upgradeToSchemaV2(tx); err != nil {
 		return err
 	}
 
-	migrations := []string{
+	tables := []string{
 		`CREATE TABLE IF NOT EXISTS vaults (
 			id TEXT PRIMARY KEY,
 			name TEXT NOT NULL,
+			version_retention INTEGER NOT NULL DEFAULT 0,
 			created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
 		)`,
-		`CREATE TABLE IF NOT EXISTS vault_items (
-			rowid INTEGER PRIMARY KEY AUTOINCREMENT,
-			vault_id TEXT NOT NULL,
-			item_name TEXT NOT NULL,
-			section TEXT NOT NULL DEFAULT '',
-			value TEXT NOT NULL DEFAULT '',
-			created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
-			updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
-			UNIQUE(vault_id, section, item_name),
-			FOREIGN KEY (vault_id) REFERENCES vaults(id)
-		)`,
+		`CREATE TABLE IF NOT EXISTS vault_items (` + unsectionedItemsColumns + `)`,
+		`CREATE TABLE IF NOT EXISTS vault_item_versions (` + unsectionedItemVersionsColumns + `)`,
 		`CREATE TABLE IF NOT EXISTS tee_instances (
 			fid TEXT PRIMARY KEY,
 			label TEXT NOT NULL DEFAULT '',
@@ -99,22 +155,187 @@ func (s *Store) migrate() error {
 			FOREIGN KEY (vault_id) REFERENCES vaults(id),
 			FOREIGN KEY (fid) REFERENCES tee_instances(fid)
 		)`,
+		`CREATE TABLE IF NOT EXISTS master_keys (
+			id TEXT PRIMARY KEY,
+			created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			retired_at DATETIME
+		)`,
+	}
+
+	for _, t := range tables {
+		if _, err := tx.Exec(t); err != nil {
+			return fmt.Errorf("create table: %w", err)
+		}
 	}
 
-	for _, m := range migrations {
-		if _, err := s.db.Exec(m); err != nil {
-			return fmt.Errorf("exec migration: %w", err)
+	if err := s.upgradeToEncryptedValues(tx); err != nil {
+		return err
+	}
+	return upgradeToVersionedItems(tx)
+}
+
+// unsectionedItemsColumns and unsectionedItemVersionsColumns define the field
+// tables of the baseline schema, where the item name is stored in section and
+// the field name in item_name.
+const unsectionedItemsColumns = `
+	rowid INTEGER PRIMARY KEY AUTOINCREMENT,
+	vault_id TEXT NOT NULL,
+	item_name TEXT NOT NULL,
+	section TEXT NOT NULL DEFAULT '',
+	ciphertext BLOB NOT NULL,
+	wrapped_key BLOB NOT NULL,
+	key_id TEXT NOT NULL,
+	version INTEGER NOT NULL DEFAULT 1,
+	created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	UNIQUE(vault_id, section, item_name),
+	FOREIGN KEY (vault_id) REFERENCES vaults(id)
+`
+
+const unsectionedItemVersionsColumns = `
+	rowid INTEGER PRIMARY KEY AUTOINCREMENT,
+	vault_id TEXT NOT NULL,
+	section TEXT NOT NULL,
+	item_name TEXT NOT NULL,
+	version INTEGER NOT NULL,
+	ciphertext BLOB NOT NULL,
+	wrapped_key BLOB NOT NULL,
+	key_id TEXT NOT NULL,
+	author TEXT NOT NULL DEFAULT '',
+	created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	UNIQUE(vault_id, section, item_name, version),
+	FOREIGN KEY (vault_id) REFERENCES vaults(id)
+`
+
+// upgradeToVersionedItems adds the version columns to databases created
+// before field versioning and records every existing value as version 1.
+func upgradeToVersionedItems(tx *dialectTx) error {
+	for _, c := range []struct{ table, column, def string }{
+		{"vaults", "version_retention", "INTEGER NOT NULL DEFAULT 0"},
+		{"vault_items", "version", "INTEGER NOT NULL DEFAULT 1"},
+	} {
+		has, err := columnExists(tx, c.table, c.column)
+		if err != nil {
+			return err
+		}
+		if has {
+			continue
+		}
+		if _, err := tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", c.table, c.column, c.def)); err != nil {
+			return fmt.Errorf("add %s.%s: %w", c.table, c.column, err)
 		}
 	}
 
+	if _, err := tx.Exec(
+		`INSERT INTO vault_item_versions
+		   (vault_id, section, item_name, version, ciphertext, wrapped_key, key_id, created_at)
+		 SELECT i.vault_id, i.section, i.item_name, i.version, i.ciphertext, i.wrapped_key, i.key_id, i.updated_at
+		 FROM vault_items i
+		 WHERE NOT EXISTS (
+		   SELECT 1 FROM vault_item_versions v
+		   WHERE v.vault_id = i.vault_id AND v.section = i.section AND v.item_name = i.item_name
+		 )`,
+	); err != nil {
+		return fmt.Errorf("backfill vault_item_versions: %w", err)
+	}
 	return nil
 }
 
+// upgradeToEncryptedValues encrypts vault_items rows written by older
+// versions, which kept field values in a plaintext value column. Skips if the
+// value column does not exist. Such tables predate item sections, so the
+// section column still holds the item name and item_name the field name.
+func (s *SQLStore) upgradeToEncryptedValues(tx *dialectTx) error {
+	hasValue, err := columnExists(tx, "vault_items", "value")
+	if err != nil {
+		return err
+	}
+	if !hasValue {
+		return nil // fresh DB or already migrated
+	}
+
+	for _, col := range []string{"ciphertext BLOB", "wrapped_key BLOB", "key_id TEXT"} {
+		if _, err := tx.Exec(`ALTER TABLE vault_items ADD COLUMN ` + col); err != nil {
+			return fmt.Errorf("add vault_items column %q: %w", col, err)
+		}
+	}
+
+	type plainRow struct {
+		id                     int64
+		vaultID, section, name string
+		value                  string
+	}
+	rows, err := tx.Query(`SELECT rowid, vault_id, section, item_name, value FROM vault_items`)
+	if err != nil {
+		return fmt.Errorf("read plaintext values: %w", err)
+	}
+	var pending []plainRow
+	for rows.Next() {
+		var r plainRow
+		if err := rows.Scan(&r.id, &r.vaultID, &r.section, &r.name, &r.value); err != nil {
+			rows.Close()
+			return fmt.Errorf("scan plaintext value: %w", err)
+		}
+		pending = append(pending, r)
+	}
+	if err := rows.Close(); err != nil {
+		return fmt.Errorf("close plaintext rows: %w", err)
+	}
+
+	for _, r := range pending {
+		ct, wk, err := s.keys.active.seal(fieldAAD(r.vaultID, r.section, "", r.name), r.value)
+		if err != nil {
+			return fmt.Errorf("encrypt %s/%s/%s: %w", r.vaultID, r.section, r.name, err)
+		}
+		if _, err := tx.Exec(
+			`UPDATE vault_items SET ciphertext = ?, wrapped_key = ?, key_id = ? WHERE rowid = ?`,
+			ct, wk, s.keys.active.keyID, r.id,
+		); err != nil {
+			return fmt.Errorf("store encrypted value: %w", err)
+		}
+	}
+
+	if _, err := tx.Exec(`ALTER TABLE vault_items DROP COLUMN value`); err != nil {
+		return fmt.Errorf("drop plaintext value column: %w", err)
+	}
+	s.plaintextDropped = true
+	return nil
+}
+
+// scrubFreePages rewrites the database file and empties the WAL, so values
+// deleted by a migration do not linger in free pages or old WAL frames.
+// SQLite cannot VACUUM inside a transaction, so it runs once the migration
+// has committed.
+func (s *SQLStore) scrubFreePages() error {
+	if _, err := s.db.Exec(`VACUUM`); err != nil {
+		return fmt.Errorf("vacuum after dropping plaintext: %w", err)
+	}
+	var busy, logFrames, checkpointed int
+	if err := s.db.QueryRow(`PRAGMA wal_checkpoint(TRUNCATE)`).Scan(&busy, &logFrames, &checkpointed); err != nil {
+		return fmt.Errorf("checkpoint after dropping plaintext: %w", err)
+	}
+	if busy != 0 {
+		return errors.New("checkpoint after dropping plaintext: database is busy; the WAL may still hold plaintext values")
+	}
+	return nil
+}
+
+// columnExists reports whether table has a column with the given name.
+func columnExists(db dbtx, table, column string) (bool, error) {
+	var count int
+	if err := db.QueryRow(
+		`SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?`, table, column,
+	).Scan(&count); err != nil {
+		return false, fmt.Errorf("inspect %s columns: %w", table, err)
+	}
+	return count > 0, nil
+}
+
 // upgradeToSchemaV2 detects the old v1 schema (apps table) and migrates data
 // to the new vault-centric schema. Skips if the apps table does not exist.
-func (s *Store) upgradeToSchemaV2() error {
+func upgradeToSchemaV2(tx *dialectTx) error {
 	var count int
-	err := s.db.QueryRow(
+	err := tx.QueryRow(
 		`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'apps'`,
 	).Scan(&count)
 	if err != nil {
@@ -126,32 +347,12 @@ func (s *Store) upgradeToSchemaV2() error {
 
 	// Also handle even older schema (user_secrets)
 	var hasUserSecrets int
-	if err := s.db.QueryRow(
+	if err := tx.QueryRow(
 		`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'user_secrets'`,
 	).Scan(&hasUserSecrets); err != nil {
 		return fmt.Errorf("check user_secrets table existence: %w", err)
 	}
 
-	// Pin a single connection so PRAGMA foreign_keys=OFF and the transaction
-	// are guaranteed to execute on the same connection.
-	ctx := context.Background()
-	conn, err := s.db.Conn(ctx)
-	if err != nil {
-		return fmt.Errorf("pin connection for v2 migration: %w", err)
-	}
-	defer conn.Close()
-
-	if _, err := conn.ExecContext(ctx, "PRAGMA foreign_keys=OFF"); err != nil {
-		return fmt.Errorf("disable foreign keys for v2 migration: %w", err)
-	}
-	defer conn.ExecContext(ctx, "PRAGMA foreign_keys=ON")
-
-	tx, err := conn.BeginTx(ctx, nil)
-	if err != nil {
-		return fmt.Errorf("begin v2 migration: %w", err)
-	}
-	defer tx.Rollback()
-
 	// 1. Create new vaults table
 	if _, err := tx.Exec(`CREATE TABLE IF NOT EXISTS vaults (
 		id TEXT PRIMARY KEY,
@@ -264,9 +465,5 @@ func (s *Store) upgradeToSchemaV2() error {
 	// 8. Recreate vault_instance_access with correct FK to renamed tee_instances
 	// (SQLite FKs reference the table name at creation time, and the rename keeps them valid)
 
-	if err := tx.Commit(); err != nil {
-		return fmt.Errorf("commit v2 migration: %w", err)
-	}
-
 	return nil
 }
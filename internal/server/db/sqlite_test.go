@@ -1,12 +1,35 @@
 package db
 
 import (
+	"bytes"
 	"testing"
 )
 
-func newTestStore(t *testing.T) *Store {
+var testMasterKey = bytes.Repeat([]byte{0x42}, 32)
+
+// newTestStore returns an empty store on the backend under test.
+func newTestStore(t *testing.T) Store {
+	t.Helper()
+	if testBackend == backendMemory {
+		return NewMemoryStore()
+	}
+	return newSQLTestStore(t)
+}
+
+// newSQLTestStore returns an empty SQLStore, for tests that inspect the
+// database itself. They are skipped in the in-memory pass.
+func newSQLTestStore(t *testing.T) *SQLStore {
 	t.Helper()
-	s, err := NewStore(":memory:")
+	requireSQL(t)
+	if testBackend == backendPostgres {
+		s, err := newTestDatabase(t)(testMasterKey)
+		if err != nil {
+			t.Fatalf("NewPostgresStore: %v", err)
+		}
+		t.Cleanup(func() { s.Close() })
+		return s
+	}
+	s, err := NewStore(":memory:", testMasterKey)
 	if err != nil {
 		t.Fatalf("NewStore: %v", err)
 	}
@@ -118,7 +141,7 @@ func TestDeleteVault_ForeignKeyBlocks(t *testing.T) {
 	s := newTestStore(t)
 
 	s.CreateVault(&Vault{ID: "v1", Name: "V1"})
-	s.SetItemFields("v1", "item1", map[string]string{"key": "value"})
+	s.SetItemFields("v1", "item1", "", map[string]string{"key": "value"})
 
 	_, err := s.DeleteVault("v1")
 	if err == nil {
@@ -133,12 +156,12 @@ func TestDeleteVaultCascade(t *testing.T) {
 	s := newTestStore(t)
 
 	s.CreateVault(&Vault{ID: "v1", Name: "V1"})
-	s.SetItemFields("v1", "item1", map[string]string{"key": "value"})
+	s.SetItemFields("v1", "item1", "", map[string]string{"key": "value"})
 	s.RegisterInstance(&TEEInstance{
 		FID: "fid1", PublicKey: []byte("pubkey-32-bytes-placeholder-here"),
 		DstackAppID: "app1",
 	})
-	s.GrantVaultAccess("v1", "fid1")
+	s.GrantVaultAccess("v1", "fid1", nil, nil)
 	s.UpsertDebugPolicy("v1", "fid1", true)
 
 	deleted, err := s.DeleteVaultCascade("v1")
@@ -154,7 +177,7 @@ func TestDeleteVaultCascade(t *testing.T) {
 	if v != nil {
 		t.Fatal("vault should be deleted")
 	}
-	sections, _ := s.ListSections("v1")
+	sections, _ := s.ListItems("v1")
 	if len(sections) != 0 {
 		t.Fatal("vault items should be deleted")
 	}
@@ -184,7 +207,7 @@ func TestVaultItemFields(t *testing.T) {
 	s.CreateVault(&Vault{ID: "v1", Name: "V1"})
 
 	// Set fields for a section
-	err := s.SetItemFields("v1", "alice@gmail.com", map[string]string{
+	err := s.SetItemFields("v1", "alice@gmail.com", "", map[string]string{
 		"password":  "secret123",
 		"api_key":   "key-abc",
 	})
@@ -202,7 +225,7 @@ func TestVaultItemFields(t *testing.T) {
 	}
 
 	// Get single field value
-	val, err := s.GetFieldValue("v1", "alice@gmail.com", "password")
+	val, err := s.GetFieldValue("v1", "alice@gmail.com", "", "password")
 	if err != nil {
 		t.Fatalf("GetFieldValue: %v", err)
 	}
@@ -211,7 +234,7 @@ func TestVaultItemFields(t *testing.T) {
 	}
 
 	// List sections
-	sections, err := s.ListSections("v1")
+	sections, err := s.ListItems("v1")
 	if err != nil {
 		t.Fatalf("ListSections: %v", err)
 	}
@@ -220,7 +243,7 @@ func TestVaultItemFields(t *testing.T) {
 	}
 
 	// SetItemFields replaces existing
-	err = s.SetItemFields("v1", "alice@gmail.com", map[string]string{
+	err = s.SetItemFields("v1", "alice@gmail.com", "", map[string]string{
 		"password": "updated",
 	})
 	if err != nil {
@@ -235,7 +258,7 @@ func TestVaultItemFields(t *testing.T) {
 	}
 
 	// GetFieldValue for missing field
-	_, err = s.GetFieldValue("v1", "alice@gmail.com", "api_key")
+	_, err = s.GetFieldValue("v1", "alice@gmail.com", "", "api_key")
 	if err == nil {
 		t.Fatal("expected error for deleted field")
 	}
@@ -245,47 +268,47 @@ func TestUpsertField(t *testing.T) {
 	s := newTestStore(t)
 	s.CreateVault(&Vault{ID: "v1", Name: "V1"})
 
-	err := s.UpsertField("v1", "item1", "key1", "val1")
+	err := s.UpsertField("v1", "item1", "", "key1", "val1")
 	if err != nil {
 		t.Fatalf("UpsertField: %v", err)
 	}
 
-	val, _ := s.GetFieldValue("v1", "item1", "key1")
+	val, _ := s.GetFieldValue("v1", "item1", "", "key1")
 	if val != "val1" {
 		t.Errorf("expected val1, got %q", val)
 	}
 
 	// Upsert same key with new value
-	err = s.UpsertField("v1", "item1", "key1", "val2")
+	err = s.UpsertField("v1", "item1", "", "key1", "val2")
 	if err != nil {
 		t.Fatalf("UpsertField update: %v", err)
 	}
-	val, _ = s.GetFieldValue("v1", "item1", "key1")
+	val, _ = s.GetFieldValue("v1", "item1", "", "key1")
 	if val != "val2" {
 		t.Errorf("expected val2, got %q", val)
 	}
 }
 
-func TestDeleteSection(t *testing.T) {
+func TestDeleteItem(t *testing.T) {
 	s := newTestStore(t)
 	s.CreateVault(&Vault{ID: "v1", Name: "V1"})
-	s.SetItemFields("v1", "item1", map[string]string{"k": "v"})
+	s.SetItemFields("v1", "item1", "", map[string]string{"k": "v"})
 
-	deleted, err := s.DeleteSection("v1", "item1")
+	deleted, err := s.DeleteItem("v1", "item1")
 	if err != nil {
-		t.Fatalf("DeleteSection: %v", err)
+		t.Fatalf("DeleteItem: %v", err)
 	}
 	if !deleted {
 		t.Fatal("expected deleted=true")
 	}
 
-	sections, _ := s.ListSections("v1")
-	if len(sections) != 0 {
-		t.Fatal("section should be deleted")
+	items, _ := s.ListItems("v1")
+	if len(items) != 0 {
+		t.Fatal("item should be deleted")
 	}
 
 	// Nonexistent
-	deleted, _ = s.DeleteSection("v1", "nonexistent")
+	deleted, _ = s.DeleteItem("v1", "nonexistent")
 	if deleted {
 		t.Fatal("expected deleted=false")
 	}
@@ -294,9 +317,9 @@ func TestDeleteSection(t *testing.T) {
 func TestDeleteField(t *testing.T) {
 	s := newTestStore(t)
 	s.CreateVault(&Vault{ID: "v1", Name: "V1"})
-	s.SetItemFields("v1", "item1", map[string]string{"k1": "v1", "k2": "v2"})
+	s.SetItemFields("v1", "item1", "", map[string]string{"k1": "v1", "k2": "v2"})
 
-	deleted, err := s.DeleteField("v1", "item1", "k1")
+	deleted, err := s.DeleteField("v1", "item1", "", "k1")
 	if err != nil {
 		t.Fatalf("DeleteField: %v", err)
 	}
@@ -315,10 +338,10 @@ func TestMergeItemFields(t *testing.T) {
 	s.CreateVault(&Vault{ID: "v1", Name: "V1"})
 
 	// Seed two fields
-	s.SetItemFields("v1", "sec1", map[string]string{"a": "1", "b": "2", "c": "3"})
+	s.SetItemFields("v1", "sec1", "", map[string]string{"a": "1", "b": "2", "c": "3"})
 
 	// Merge: upsert a (update) + d (insert), delete c
-	err := s.MergeItemFields("v1", "sec1", map[string]string{"a": "10", "d": "4"}, []string{"c"})
+	err := s.MergeItemFields("v1", "sec1", "", map[string]string{"a": "10", "d": "4"}, []string{"c"}, "")
 	if err != nil {
 		t.Fatalf("MergeItemFields: %v", err)
 	}
@@ -326,7 +349,7 @@ func TestMergeItemFields(t *testing.T) {
 	items, _ := s.GetItemFields("v1", "sec1")
 	got := make(map[string]string, len(items))
 	for _, it := range items {
-		got[it.ItemName] = it.Value
+		got[it.FieldName] = it.Value
 	}
 
 	// a updated, b untouched, c deleted, d added
@@ -347,7 +370,7 @@ func TestMergeItemFields(t *testing.T) {
 	}
 
 	// Merge with only deletes
-	err = s.MergeItemFields("v1", "sec1", nil, []string{"b"})
+	err = s.MergeItemFields("v1", "sec1", "", nil, []string{"b"}, "")
 	if err != nil {
 		t.Fatalf("MergeItemFields delete-only: %v", err)
 	}
@@ -357,7 +380,7 @@ func TestMergeItemFields(t *testing.T) {
 	}
 
 	// Merge with only upserts
-	err = s.MergeItemFields("v1", "sec1", map[string]string{"e": "5"}, nil)
+	err = s.MergeItemFields("v1", "sec1", "", map[string]string{"e": "5"}, nil, "")
 	if err != nil {
 		t.Fatalf("MergeItemFields upsert-only: %v", err)
 	}
@@ -463,7 +486,7 @@ func TestDeleteInstance(t *testing.T) {
 	s.RegisterInstance(&TEEInstance{
 		FID: "fid1", PublicKey: []byte("pubkey-32-bytes-placeholder-here"), DstackAppID: "app1",
 	})
-	s.GrantVaultAccess("v1", "fid1")
+	s.GrantVaultAccess("v1", "fid1", nil, nil)
 	s.UpsertDebugPolicy("v1", "fid1", true)
 
 	deleted, err := s.DeleteInstance("fid1")
@@ -517,6 +540,45 @@ func TestUpdateInstance(t *testing.T) {
 	}
 }
 
+func TestSetInstanceStatus(t *testing.T) {
+	s := newTestStore(t)
+	s.CreateVault(&Vault{ID: "v1", Name: "V1"})
+	s.RegisterInstance(&TEEInstance{FID: "fid1", PublicKey: []byte("pk1"), DstackAppID: "app1"})
+	s.GrantVaultAccess("v1", "fid1", nil, nil)
+
+	if got, _ := s.GetInstance("fid1"); got.Status != InstanceActive || got.StatusChangedAt != nil {
+		t.Fatalf("new instance = %+v, want active", got)
+	}
+	if ok, err := s.SetInstanceStatus("fid1", InstanceQuarantined, "leaked key"); err != nil || !ok {
+		t.Fatalf("SetInstanceStatus = %v, %v", ok, err)
+	}
+	got, _ := s.GetInstance("fid1")
+	if got.Status != InstanceQuarantined || got.StatusReason != "leaked key" || got.StatusChangedAt == nil {
+		t.Errorf("quarantined instance = %+v", got)
+	}
+	if list, _ := s.ListVaultInstances("v1"); len(list) != 1 || list[0].Status != InstanceQuarantined {
+		t.Errorf("ListVaultInstances = %+v, want the quarantined instance", list)
+	}
+	// The grant is kept for when the instance is made active again.
+	if ok, _ := s.HasVaultAccess("v1", "fid1"); !ok {
+		t.Error("grant lost when quarantining")
+	}
+	if ok, _ := s.SetInstanceStatus("fid1", InstanceActive, ""); !ok {
+		t.Fatal("expected ok=true re-enabling")
+	}
+	if got, _ := s.GetInstance("fid1"); got.Status != InstanceActive || got.StatusReason != "" {
+		t.Errorf("re-enabled instance = %+v", got)
+	}
+
+	if ok, _ := s.SetInstanceStatus("nope", InstanceDisabled, "x"); ok {
+		t.Fatal("expected ok=false")
+	}
+	s.DeleteInstance("fid1")
+	if ok, _ := s.SetInstanceStatus("fid1", InstanceDisabled, "x"); ok {
+		t.Fatal("expected ok=false for a trashed instance")
+	}
+}
+
 func TestVaultInstanceAccess(t *testing.T) {
 	s := newTestStore(t)
 	s.CreateVault(&Vault{ID: "v1", Name: "V1"})
@@ -526,10 +588,10 @@ func TestVaultInstanceAccess(t *testing.T) {
 	})
 
 	// Grant access
-	if err := s.GrantVaultAccess("v1", "fid1"); err != nil {
+	if err := s.GrantVaultAccess("v1", "fid1", nil, nil); err != nil {
 		t.Fatalf("GrantVaultAccess: %v", err)
 	}
-	if err := s.GrantVaultAccess("v2", "fid1"); err != nil {
+	if err := s.GrantVaultAccess("v2", "fid1", nil, nil); err != nil {
 		t.Fatalf("GrantVaultAccess v2: %v", err)
 	}
 
@@ -579,7 +641,7 @@ func TestVaultInstanceAccess(t *testing.T) {
 	}
 
 	// Grant is idempotent (INSERT OR IGNORE)
-	if err := s.GrantVaultAccess("v2", "fid1"); err != nil {
+	if err := s.GrantVaultAccess("v2", "fid1", nil, nil); err != nil {
 		t.Fatalf("GrantVaultAccess idempotent: %v", err)
 	}
 }
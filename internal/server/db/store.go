@@ -0,0 +1,190 @@
+package db
+
+import (
+	"fmt"
+	"time"
+)
+
+// Store is the storage contract the HTTP handlers depend on. Get methods
+// return (nil, nil) when the record does not exist; methods returning a bool
+// report whether a row was affected.
+type Store interface {
+	// Vaults
+	CreateVault(v *Vault) error
+	GetVault(id string) (*Vault, error)
+	UpdateVault(v *Vault) (bool, error)
+	ListVaults() ([]Vault, error)
+	DeleteVault(id string) (bool, error)
+	DeleteVaultCascade(id string) (bool, error)
+	SetVersionRetention(vaultID string, keep int) (bool, error)
+
+	// Vault fields
+	UpsertField(vaultID, item, section, field, value string) error
+	SetItemFields(vaultID, item, section string, fields map[string]string) error
+	GetItemFields(vaultID, item string) ([]VaultItem, error)
+	GetFieldValue(vaultID, item, section, field string) (string, error)
+	ListItems(vaultID string) ([]string, error)
+	MergeItemFields(vaultID, item, section string, upsert map[string]string, deleteKeys []string, author string) error
+	MergeVaultFields(vaultID string, merges []ItemMerge, author string) error
+	DeleteItem(vaultID, item string) (bool, error)
+	DeleteSection(vaultID, item, section string) (bool, error)
+	DeleteField(vaultID, item, section, field string) (bool, error)
+
+	// Field expiry
+	SetExpiry(vaultID, item, section, field string, expiresAt time.Time) (bool, error)
+	ClearExpiry(vaultID, item, section, field string) (bool, error)
+	GetFieldExpiry(vaultID, item, section, field string) (*time.Time, error)
+	ListExpiringFields(vaultID string, before time.Time) ([]FieldExpiry, error)
+
+	// Field versions
+	ListFieldVersions(vaultID, item, section, field string) ([]FieldVersion, error)
+	GetFieldVersionValue(vaultID, item, section, field string, version int) (string, error)
+	RollbackField(vaultID, item, section, field string, version int, author string) (int, error)
+
+	// TEE instances
+	RegisterInstance(inst *TEEInstance) error
+	GetInstance(fid string) (*TEEInstance, error)
+	ListInstances() ([]TEEInstance, error)
+	UpdateInstance(fid, dstackAppID, label string) (bool, error)
+	UpdateLastUsed(fid string) error
+	SetInstanceStatus(fid, status, reason string) (bool, error)
+	DeleteInstance(fid string) (bool, error)
+	RekeyInstance(fid, newFID string, newPublicKey []byte) (bool, error)
+	ResolveInstanceAlias(fid string) (string, error)
+	ListInstanceAliases(fid string) ([]InstanceAlias, error)
+
+	// Vault ↔ instance access
+	GrantVaultAccess(vaultID, fid string, notBefore, expiresAt *time.Time) error
+	RevokeVaultAccess(vaultID, fid string) (bool, error)
+	HasVaultAccess(vaultID, fid string) (bool, error)
+	ListInstanceVaults(fid string) ([]Vault, error)
+	ListVaultInstances(vaultID string) ([]VaultInstance, error)
+
+	// Vault ↔ dstack app access
+	GrantAppAccess(vaultID, appID string, notBefore, expiresAt *time.Time) error
+	RevokeAppAccess(vaultID, appID string) (bool, error)
+	ListVaultApps(vaultID string) ([]AppAccess, error)
+
+	// Enrollment
+	PutEnrollmentPolicy(p *EnrollmentPolicy) error
+	GetEnrollmentPolicy(appID string) (*EnrollmentPolicy, error)
+	ListEnrollmentPolicies() ([]EnrollmentPolicy, error)
+	DeleteEnrollmentPolicy(appID string) (bool, error)
+	EnrollInstance(inst *TEEInstance, vaultIDs []string) error
+	CreateEnrollmentToken(t *EnrollmentToken) error
+	GetEnrollmentTokenByHash(tokenHash string) (*EnrollmentToken, error)
+	ListEnrollmentTokens() ([]EnrollmentToken, error)
+	DeleteEnrollmentToken(id string) (bool, error)
+	RedeemEnrollmentToken(id string, inst *TEEInstance) error
+
+	// Field grants
+	PutFieldGrant(g *FieldGrant) error
+	DeleteFieldGrant(vaultID, fid, item, section, field string) (bool, error)
+	ListFieldGrants(vaultID, fid string) ([]FieldGrant, error)
+	HasFieldAccess(vaultID, fid, appID, item, section, field string) (bool, error)
+
+	// Trash
+	ListTrash() ([]TrashEntry, error)
+	RestoreVault(id string) (bool, error)
+	RestoreItem(vaultID, item string) (bool, error)
+	RestoreSection(vaultID, item, section string) (bool, error)
+	RestoreInstance(fid string) (bool, error)
+	PurgeVault(id string) (bool, error)
+	PurgeItem(vaultID, item string) (bool, error)
+	PurgeSection(vaultID, item, section string) (bool, error)
+	PurgeInstance(fid string) (bool, error)
+
+	// Audit log
+	RecordAuditEvent(ev *AuditEvent) error
+	ListAuditEvents(q AuditQuery) ([]AuditEvent, error)
+	RecordAuditSignature(sig *AuditSignature) error
+	ListAuditSignatures() ([]AuditSignature, error)
+
+	// Debug policies
+	UpsertDebugPolicy(vaultID, fid string, allow bool) error
+	GetDebugPolicy(vaultID, fid string) (*DebugPolicy, error)
+
+	// Measurement policies
+	PutMeasurementPolicy(p *MeasurementPolicy) error
+	GetMeasurementPolicy(scope, target string) (*MeasurementPolicy, error)
+	ListMeasurementPolicies() ([]MeasurementPolicy, error)
+	DeleteMeasurementPolicy(scope, target string) (bool, error)
+	ApplicableMeasurementPolicies(fid, appID string) ([]MeasurementPolicy, error)
+
+	// Backup
+	Snapshot() (*Snapshot, error)
+	Restore(snap *Snapshot) error
+
+	Close() error
+}
+
+// SQLStore implements Store on a SQL database, either SQLite (NewStore) or
+// PostgreSQL (NewPostgresStore). Field values are envelope-encrypted before
+// they reach the database.
+type SQLStore struct {
+	db      *dialectDB
+	dialect *dialect
+	keys    *keyring
+
+	// plaintextDropped is set by a migration that deleted plaintext values,
+	// which MigrateUp then scrubs from the database file.
+	plaintextDropped bool
+}
+
+var _ Store = (*SQLStore)(nil)
+
+// Close closes the database connection.
+func (s *SQLStore) Close() error {
+	return s.db.Close()
+}
+
+// migrate brings the schema up to date and registers the configured master
+// keys. It refuses databases written by a newer binary.
+func (s *SQLStore) migrate() error {
+	if _, err := s.MigrateUp(LatestSchemaVersion()); err != nil {
+		return err
+	}
+	return s.registerMasterKeys()
+}
+
+// registerMasterKeys records the active key and any key referenced by
+// existing rows in the master_keys keyring table. Only key IDs are stored.
+func (s *SQLStore) registerMasterKeys() error {
+	// WHERE true keeps SQLite from reading ON CONFLICT as a join constraint.
+	if _, err := s.db.Exec(
+		`INSERT INTO master_keys (id) SELECT key_id FROM (` + encryptedKeyIDs + `) k WHERE true
+		 ON CONFLICT(id) DO NOTHING`,
+	); err != nil {
+		return fmt.Errorf("register existing master keys: %w", err)
+	}
+	if _, err := s.db.Exec(
+		`INSERT INTO master_keys (id) VALUES (?)
+		 ON CONFLICT(id) DO UPDATE SET retired_at = NULL`,
+		s.keys.active.keyID,
+	); err != nil {
+		return fmt.Errorf("register active master key: %w", err)
+	}
+	return nil
+}
+
+// checkMasterKey refuses to open a database containing values wrapped with a
+// master key that is not configured, so a misconfigured key fails at startup
+// rather than on the first secret fetch.
+func (s *SQLStore) checkMasterKey() error {
+	rows, err := s.db.Query(encryptedKeyIDs)
+	if err != nil {
+		return fmt.Errorf("check master key: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var keyID string
+		if err := rows.Scan(&keyID); err != nil {
+			return fmt.Errorf("scan key id: %w", err)
+		}
+		if _, err := s.keys.lookup(keyID); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
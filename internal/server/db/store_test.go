@@ -0,0 +1,155 @@
+package db
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// The package tests run once per store backend: SQLite, the in-memory store,
+// and PostgreSQL when JINGUI_TEST_DB_URL names a server. On PostgreSQL each
+// test gets a schema of its own, dropped when the test ends.
+const (
+	backendSQLite   = "sqlite"
+	backendMemory   = "memory"
+	backendPostgres = "postgres"
+)
+
+var (
+	testBackend     = backendSQLite
+	testPostgresURL string
+)
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	if code == 0 {
+		testBackend = backendMemory
+		fmt.Println("--- store backend: memory")
+		code = m.Run()
+	}
+	if dsn := os.Getenv("JINGUI_TEST_DB_URL"); dsn != "" && code == 0 {
+		testBackend, testPostgresURL = backendPostgres, dsn
+		fmt.Println("--- store backend: postgres")
+		code = m.Run()
+	}
+	os.Exit(code)
+}
+
+// requireSQLite skips tests that exercise SQLite-only behaviour, such as
+// upgrading database files written by old releases.
+func requireSQLite(t *testing.T) {
+	t.Helper()
+	if testBackend != backendSQLite {
+		t.Skip("SQLite only")
+	}
+}
+
+// requireSQL skips tests of SQLStore internals in the in-memory pass.
+func requireSQL(t *testing.T) {
+	t.Helper()
+	if testBackend == backendMemory {
+		t.Skip("SQL backends only")
+	}
+}
+
+// newTestDatabase returns a function that opens the same empty database on
+// every call, so tests can close and reopen a store with different keys.
+func newTestDatabase(t *testing.T) func(masterKey []byte, previousKeys ...[]byte) (*SQLStore, error) {
+	t.Helper()
+	requireSQL(t)
+	if testBackend == backendSQLite {
+		path := filepath.Join(t.TempDir(), "jingui.db")
+		return func(masterKey []byte, previousKeys ...[]byte) (*SQLStore, error) {
+			return NewStore(path, masterKey, previousKeys...)
+		}
+	}
+
+	admin, err := sql.Open("pgx", testPostgresURL)
+	if err != nil {
+		t.Fatalf("connect to test database: %v", err)
+	}
+	suffix := make([]byte, 6)
+	rand.Read(suffix)
+	schema := "jingui_test_" + hex.EncodeToString(suffix)
+	if _, err := admin.Exec(`CREATE SCHEMA ` + schema); err != nil {
+		t.Fatalf("create test schema: %v", err)
+	}
+	t.Cleanup(func() {
+		admin.Exec(`DROP SCHEMA ` + schema + ` CASCADE`)
+		admin.Close()
+	})
+
+	dsn := testPostgresURL + " search_path=" + schema
+	if u, err := url.Parse(testPostgresURL); err == nil && u.Scheme != "" {
+		q := u.Query()
+		q.Set("search_path", schema)
+		u.RawQuery = q.Encode()
+		dsn = u.String()
+	}
+	return func(masterKey []byte, previousKeys ...[]byte) (*SQLStore, error) {
+		return NewPostgresStore(dsn, masterKey, previousKeys...)
+	}
+}
+
+func TestPostgresMigrationsMirrorSQLite(t *testing.T) {
+	if len(postgresMigrations) != len(migrations) {
+		t.Fatalf("%d PostgreSQL migrations, %d SQLite migrations", len(postgresMigrations), len(migrations))
+	}
+	for i, m := range migrations {
+		pg := postgresMigrations[i]
+		if pg.version != m.version || pg.name != m.name || (pg.down == nil) != (m.down == nil) {
+			t.Errorf("PostgreSQL migration %d (%s) does not mirror SQLite migration %d (%s)",
+				pg.version, pg.name, m.version, m.name)
+		}
+	}
+}
+
+func TestRebind(t *testing.T) {
+	query := `SELECT * FROM t WHERE a = ? AND b <> '?' AND c IN (?, ?)`
+	if got := sqliteDialect.rebind(query); got != query {
+		t.Errorf("SQLite rebind changed the query: %s", got)
+	}
+	want := `SELECT * FROM t WHERE a = $1 AND b <> '?' AND c IN ($2, $3)`
+	if got := postgresDialect.rebind(query); got != want {
+		t.Errorf("PostgreSQL rebind = %s, want %s", got, want)
+	}
+	if strings.Contains(postgresDialect.rebind(`SELECT ''`), "$") {
+		t.Error("rebind touched a query without placeholders")
+	}
+}
+
+func TestForeignKeysEnforced(t *testing.T) {
+	s := newTestStore(t)
+	s.CreateVault(&Vault{ID: "v1", Name: "V1"})
+	s.RegisterInstance(&TEEInstance{
+		FID: "fid1", PublicKey: []byte("pubkey-32-bytes-placeholder-here"), DstackAppID: "app1",
+	})
+
+	if err := s.UpsertField("missing", "alice", "", "token", "x"); err == nil {
+		t.Error("expected UpsertField into a missing vault to fail")
+	}
+	if err := s.SetItemFields("missing", "alice", "", map[string]string{"token": "x"}); err == nil {
+		t.Error("expected SetItemFields into a missing vault to fail")
+	}
+	if err := s.GrantVaultAccess("v1", "missing", nil, nil); err == nil {
+		t.Error("expected GrantVaultAccess to a missing instance to fail")
+	}
+	if err := s.GrantVaultAccess("missing", "fid1", nil, nil); err == nil {
+		t.Error("expected GrantVaultAccess on a missing vault to fail")
+	}
+	if err := s.UpsertDebugPolicy("v1", "missing", true); err == nil {
+		t.Error("expected UpsertDebugPolicy for a missing instance to fail")
+	}
+
+	s.GrantVaultAccess("v1", "fid1", nil, nil)
+	if _, err := s.DeleteVault("v1"); !errors.Is(err, ErrVaultHasDependents) {
+		t.Errorf("expected ErrVaultHasDependents while a grant exists, got %v", err)
+	}
+}
@@ -0,0 +1,324 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Deleting a vault, an item section or an instance moves it to the trash: the
+// row is stamped with deleted_at and every query for live data skips it. A
+// trashed vault hides its fields, grants and policies without touching them;
+// a trashed instance hides its grants. Restore clears the stamp, purge deletes
+// the rows for good. Deleting a single field is not trashed.
+
+// ErrInTrash is returned when a write targets a vault, section or instance
+// that is in the trash. It has to be restored or purged first.
+var ErrInTrash = errors.New("in the trash")
+
+// liveVaultID selects rows whose vault is not in the trash.
+const liveVaultID = `vault_id IN (SELECT id FROM vaults WHERE deleted_at IS NULL)`
+
+// liveField selects vault_items rows that are not in the trash, by themselves
+// or through their vault.
+const liveField = `deleted_at IS NULL AND ` + liveVaultID
+
+// inTrashedSection selects rows of table that belong to a trashed section.
+func inTrashedSection(table string) string {
+	return `EXISTS (SELECT 1 FROM vault_items t
+	  WHERE t.vault_id = ` + table + `.vault_id AND t.item = ` + table + `.item
+	    AND t.section = ` + table + `.section AND t.deleted_at IS NOT NULL)`
+}
+
+// sectionPath formats section coordinates for error messages.
+func sectionPath(vaultID, item, section string) string {
+	if section == "" {
+		return vaultID + "/" + item
+	}
+	return vaultID + "/" + item + "/" + section
+}
+
+// checkNotTrashed returns ErrInTrash if the vault or the section a field is
+// written to is in the trash.
+func checkNotTrashed(e dbtx, vaultID, item, section string) error {
+	var vaults, fields int
+	if err := e.QueryRow(
+		`SELECT (SELECT COUNT(*) FROM vaults WHERE id = ? AND deleted_at IS NOT NULL),
+		        (SELECT COUNT(*) FROM vault_items WHERE vault_id = ? AND item = ? AND section = ? AND deleted_at IS NOT NULL)`,
+		vaultID, vaultID, item, section,
+	).Scan(&vaults, &fields); err != nil {
+		return fmt.Errorf("check trash: %w", err)
+	}
+	switch {
+	case vaults > 0:
+		return fmt.Errorf("vault %q is %w", vaultID, ErrInTrash)
+	case fields > 0:
+		return fmt.Errorf("section %s is %w", sectionPath(vaultID, item, section), ErrInTrash)
+	}
+	return nil
+}
+
+// ListTrash returns everything in the trash, oldest first.
+func (s *SQLStore) ListTrash() ([]TrashEntry, error) {
+	var entries []TrashEntry
+
+	rows, err := s.db.Query(`SELECT id, name, deleted_at FROM vaults WHERE deleted_at IS NOT NULL`)
+	if err != nil {
+		return nil, fmt.Errorf("list trashed vaults: %w", err)
+	}
+	for rows.Next() {
+		e := TrashEntry{Kind: TrashVault}
+		if err := rows.Scan(&e.VaultID, &e.Name, &e.DeletedAt); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("scan trashed vault: %w", err)
+		}
+		entries = append(entries, e)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+
+	// Sections are stored as their fields, all stamped at once.
+	rows, err = s.db.Query(`SELECT vault_id, item, section, deleted_at FROM vault_items WHERE deleted_at IS NOT NULL`)
+	if err != nil {
+		return nil, fmt.Errorf("list trashed sections: %w", err)
+	}
+	sections := map[fieldKey]*TrashEntry{}
+	for rows.Next() {
+		e := TrashEntry{Kind: TrashSection}
+		if err := rows.Scan(&e.VaultID, &e.Item, &e.Section, &e.DeletedAt); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("scan trashed section: %w", err)
+		}
+		k := fieldKey{vaultID: e.VaultID, item: e.Item, section: e.Section}
+		if prev, ok := sections[k]; ok {
+			prev.Fields++
+			continue
+		}
+		e.Fields = 1
+		sections[k] = &e
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	for _, e := range sections {
+		entries = append(entries, *e)
+	}
+
+	rows, err = s.db.Query(`SELECT fid, label, deleted_at FROM tee_instances WHERE deleted_at IS NOT NULL`)
+	if err != nil {
+		return nil, fmt.Errorf("list trashed instances: %w", err)
+	}
+	for rows.Next() {
+		e := TrashEntry{Kind: TrashInstance}
+		if err := rows.Scan(&e.FID, &e.Name, &e.DeletedAt); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("scan trashed instance: %w", err)
+		}
+		entries = append(entries, e)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+
+	sortTrash(entries)
+	return entries, nil
+}
+
+// sortTrash orders trash entries oldest first, then by kind and key.
+func sortTrash(entries []TrashEntry) {
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		switch {
+		case !a.DeletedAt.Equal(b.DeletedAt):
+			return a.DeletedAt.Before(b.DeletedAt)
+		case a.Kind != b.Kind:
+			return a.Kind < b.Kind
+		case a.FID != b.FID:
+			return a.FID < b.FID
+		}
+		return fieldKey{a.VaultID, a.Item, a.Section, ""}.less(fieldKey{b.VaultID, b.Item, b.Section, ""})
+	})
+}
+
+// RestoreVault takes a vault out of the trash, together with its fields,
+// grants and policies. Sections trashed on their own stay in the trash.
+// Returns false if the vault is not in the trash.
+func (s *SQLStore) RestoreVault(id string) (bool, error) {
+	res, err := s.db.Exec(`UPDATE vaults SET deleted_at = NULL WHERE id = ? AND deleted_at IS NOT NULL`, id)
+	if err != nil {
+		return false, fmt.Errorf("restore vault: %w", err)
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// RestoreItem takes every trashed section of an item out of the trash.
+// Returns false if none is in the trash.
+func (s *SQLStore) RestoreItem(vaultID, item string) (bool, error) {
+	return s.restoreFields(`vault_id = ? AND item = ?`, vaultID, item)
+}
+
+// RestoreSection takes one section of an item out of the trash. Returns false
+// if it is not in the trash.
+func (s *SQLStore) RestoreSection(vaultID, item, section string) (bool, error) {
+	return s.restoreFields(`vault_id = ? AND item = ? AND section = ?`, vaultID, item, section)
+}
+
+func (s *SQLStore) restoreFields(where string, args ...any) (bool, error) {
+	res, err := s.db.Exec(`UPDATE vault_items SET deleted_at = NULL WHERE deleted_at IS NOT NULL AND `+where, args...)
+	if err != nil {
+		return false, fmt.Errorf("restore fields: %w", err)
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// RestoreInstance takes an instance out of the trash, together with its
+// grants and policies. Returns false if it is not in the trash.
+func (s *SQLStore) RestoreInstance(fid string) (bool, error) {
+	res, err := s.db.Exec(`UPDATE tee_instances SET deleted_at = NULL WHERE fid = ? AND deleted_at IS NOT NULL`, fid)
+	if err != nil {
+		return false, fmt.Errorf("restore instance: %w", err)
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// PurgeVault permanently deletes a trashed vault and everything in it.
+// Returns false if the vault is not in the trash.
+func (s *SQLStore) PurgeVault(id string) (bool, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return false, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	var trashed int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM vaults WHERE id = ? AND deleted_at IS NOT NULL`, id).Scan(&trashed); err != nil {
+		return false, fmt.Errorf("check vault: %w", err)
+	}
+	if trashed == 0 {
+		return false, nil
+	}
+
+	for _, table := range []string{"debug_policies", "field_grants", "vault_app_access", "enrollment_policy_vaults", "enrollment_token_vaults", "vault_instance_access", "field_expiries", "vault_item_versions", "vault_items"} {
+		if _, err := tx.Exec(`DELETE FROM `+table+` WHERE vault_id = ?`, id); err != nil {
+			return false, fmt.Errorf("delete %s for vault: %w", table, err)
+		}
+	}
+	if _, err := deleteMeasurementPolicy(tx, MeasurementScopeVault, id); err != nil {
+		return false, err
+	}
+	if _, err := tx.Exec(`DELETE FROM vaults WHERE id = ?`, id); err != nil {
+		return false, fmt.Errorf("delete vault: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("commit tx: %w", err)
+	}
+	return true, nil
+}
+
+// PurgeItem permanently deletes every trashed section of an item. Returns
+// false if none is in the trash.
+func (s *SQLStore) PurgeItem(vaultID, item string) (bool, error) {
+	return s.purgeFields(`vault_id = ? AND item = ?`, vaultID, item)
+}
+
+// PurgeSection permanently deletes a trashed section of an item. Returns
+// false if it is not in the trash.
+func (s *SQLStore) PurgeSection(vaultID, item, section string) (bool, error) {
+	return s.purgeFields(`vault_id = ? AND item = ? AND section = ?`, vaultID, item, section)
+}
+
+// purgeFields deletes the trashed fields matching where, plus their history
+// and expiries, in one transaction.
+func (s *SQLStore) purgeFields(where string, args ...any) (bool, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return false, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, table := range []string{"field_expiries", "vault_item_versions"} {
+		if _, err := tx.Exec(`DELETE FROM `+table+` WHERE `+where+` AND `+inTrashedSection(table), args...); err != nil {
+			return false, fmt.Errorf("delete %s: %w", table, err)
+		}
+	}
+	res, err := tx.Exec(`DELETE FROM vault_items WHERE deleted_at IS NOT NULL AND `+where, args...)
+	if err != nil {
+		return false, fmt.Errorf("delete fields: %w", err)
+	}
+	n, _ := res.RowsAffected()
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("commit tx: %w", err)
+	}
+	return n > 0, nil
+}
+
+// PurgeInstance permanently deletes a trashed instance and its grants, debug
+// policies and measurement policy. Returns false if it is not in the trash.
+func (s *SQLStore) PurgeInstance(fid string) (bool, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return false, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	var trashed int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM tee_instances WHERE fid = ? AND deleted_at IS NOT NULL`, fid).Scan(&trashed); err != nil {
+		return false, fmt.Errorf("check instance: %w", err)
+	}
+	if trashed == 0 {
+		return false, nil
+	}
+
+	for _, table := range []string{"debug_policies", "field_grants", "vault_instance_access", "instance_aliases", "tee_instances"} {
+		if _, err := tx.Exec(`DELETE FROM `+table+` WHERE fid = ?`, fid); err != nil {
+			return false, fmt.Errorf("delete %s for instance: %w", table, err)
+		}
+	}
+	if _, err := deleteMeasurementPolicy(tx, MeasurementScopeInstance, fid); err != nil {
+		return false, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("commit tx: %w", err)
+	}
+	return true, nil
+}
+
+// PurgeTrash permanently deletes everything moved to the trash before the
+// given time and returns the number of entries purged.
+func PurgeTrash(store Store, before time.Time) (int, error) {
+	entries, err := store.ListTrash()
+	if err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for _, e := range entries {
+		if !e.DeletedAt.Before(before) {
+			continue
+		}
+		var ok bool
+		switch e.Kind {
+		case TrashVault:
+			ok, err = store.PurgeVault(e.VaultID)
+		case TrashSection:
+			ok, err = store.PurgeSection(e.VaultID, e.Item, e.Section)
+		case TrashInstance:
+			ok, err = store.PurgeInstance(e.FID)
+		}
+		if err != nil {
+			return purged, fmt.Errorf("purge %s: %w", e.Kind, err)
+		}
+		if ok {
+			purged++
+		}
+	}
+	return purged, nil
+}
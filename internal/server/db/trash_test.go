@@ -0,0 +1,265 @@
+package db
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTrash_Vault(t *testing.T) {
+	s := newTestStore(t)
+	s.CreateVault(&Vault{ID: "v1", Name: "V1"})
+	s.UpsertField("v1", "alice", "", "token", "secret")
+	s.RegisterInstance(&TEEInstance{FID: "fid1", PublicKey: []byte("pk1"), DstackAppID: "app1"})
+	s.GrantVaultAccess("v1", "fid1", nil, nil)
+
+	if ok, err := s.DeleteVaultCascade("v1"); err != nil || !ok {
+		t.Fatalf("DeleteVaultCascade = %v, %v", ok, err)
+	}
+	if ok, _ := s.DeleteVaultCascade("v1"); ok {
+		t.Error("expected a second delete to find no vault")
+	}
+
+	if v, _ := s.GetVault("v1"); v != nil {
+		t.Errorf("GetVault on a trashed vault = %+v, want nil", v)
+	}
+	if vaults, _ := s.ListVaults(); len(vaults) != 0 {
+		t.Errorf("ListVaults = %+v, want none", vaults)
+	}
+	if _, err := s.GetFieldValue("v1", "alice", "", "token"); !errors.Is(err, ErrFieldNotFound) {
+		t.Errorf("GetFieldValue in a trashed vault: expected ErrFieldNotFound, got %v", err)
+	}
+	if items, _ := s.ListItems("v1"); len(items) != 0 {
+		t.Errorf("ListItems = %v, want none", items)
+	}
+	if has, _ := s.HasVaultAccess("v1", "fid1"); has {
+		t.Error("grant on a trashed vault should not give access")
+	}
+	if vaults, _ := s.ListInstanceVaults("fid1"); len(vaults) != 0 {
+		t.Errorf("ListInstanceVaults = %+v, want none", vaults)
+	}
+	if err := s.CreateVault(&Vault{ID: "v1", Name: "again"}); !errors.Is(err, ErrInTrash) {
+		t.Errorf("CreateVault over a trashed vault: expected ErrInTrash, got %v", err)
+	}
+	if err := s.UpsertField("v1", "alice", "", "token", "new"); !errors.Is(err, ErrInTrash) {
+		t.Errorf("UpsertField into a trashed vault: expected ErrInTrash, got %v", err)
+	}
+
+	trash, err := s.ListTrash()
+	if err != nil {
+		t.Fatalf("ListTrash: %v", err)
+	}
+	if len(trash) != 1 || trash[0].Kind != TrashVault || trash[0].VaultID != "v1" || trash[0].Name != "V1" || trash[0].DeletedAt.IsZero() {
+		t.Fatalf("ListTrash = %+v, want vault v1", trash)
+	}
+
+	if ok, err := s.RestoreVault("v1"); err != nil || !ok {
+		t.Fatalf("RestoreVault = %v, %v", ok, err)
+	}
+	if ok, _ := s.RestoreVault("v1"); ok {
+		t.Error("expected RestoreVault of a live vault to report false")
+	}
+	if val, err := s.GetFieldValue("v1", "alice", "", "token"); err != nil || val != "secret" {
+		t.Errorf("GetFieldValue after restore = %q, %v", val, err)
+	}
+	if has, _ := s.HasVaultAccess("v1", "fid1"); !has {
+		t.Error("grant should come back with the vault")
+	}
+
+	if ok, _ := s.PurgeVault("v1"); ok {
+		t.Error("expected PurgeVault of a live vault to report false")
+	}
+	s.DeleteVaultCascade("v1")
+	if ok, err := s.PurgeVault("v1"); err != nil || !ok {
+		t.Fatalf("PurgeVault = %v, %v", ok, err)
+	}
+	if trash, _ := s.ListTrash(); len(trash) != 0 {
+		t.Errorf("ListTrash after purge = %+v, want empty", trash)
+	}
+	if err := s.CreateVault(&Vault{ID: "v1", Name: "fresh"}); err != nil {
+		t.Fatalf("CreateVault after purge: %v", err)
+	}
+	if _, err := s.GetFieldValue("v1", "alice", "", "token"); !errors.Is(err, ErrFieldNotFound) {
+		t.Errorf("purged field came back: %v", err)
+	}
+	if has, _ := s.HasVaultAccess("v1", "fid1"); has {
+		t.Error("purged grant came back")
+	}
+}
+
+func TestTrash_Section(t *testing.T) {
+	s := newTestStore(t)
+	s.CreateVault(&Vault{ID: "v1", Name: "V1"})
+	s.UpsertField("v1", "stripe", "", "api_key", "sk_live")
+	s.UpsertField("v1", "stripe", "test", "api_key", "sk_test_1")
+	s.UpsertField("v1", "stripe", "test", "api_key", "sk_test_2")
+	s.UpsertField("v1", "stripe", "test", "webhook", "whsec")
+
+	if ok, err := s.DeleteSection("v1", "stripe", "test"); err != nil || !ok {
+		t.Fatalf("DeleteSection = %v, %v", ok, err)
+	}
+	fields, _ := s.GetItemFields("v1", "stripe")
+	if len(fields) != 1 || fields[0].Section != "" {
+		t.Errorf("GetItemFields = %+v, want only the default section", fields)
+	}
+	if versions, _ := s.ListFieldVersions("v1", "stripe", "test", "api_key"); len(versions) != 0 {
+		t.Errorf("ListFieldVersions of a trashed field = %+v, want none", versions)
+	}
+	if _, err := s.GetFieldVersionValue("v1", "stripe", "test", "api_key", 1); !errors.Is(err, ErrVersionNotFound) {
+		t.Errorf("GetFieldVersionValue of a trashed field: expected ErrVersionNotFound, got %v", err)
+	}
+	if err := s.UpsertField("v1", "stripe", "test", "new", "x"); !errors.Is(err, ErrInTrash) {
+		t.Errorf("UpsertField into a trashed section: expected ErrInTrash, got %v", err)
+	}
+	if err := s.MergeItemFields("v1", "stripe", "test", map[string]string{"api_key": "x"}, nil, "bob"); !errors.Is(err, ErrInTrash) {
+		t.Errorf("MergeItemFields into a trashed section: expected ErrInTrash, got %v", err)
+	}
+	if _, err := s.RollbackField("v1", "stripe", "test", "api_key", 1, "bob"); !errors.Is(err, ErrInTrash) {
+		t.Errorf("RollbackField in a trashed section: expected ErrInTrash, got %v", err)
+	}
+	if ok, _ := s.DeleteField("v1", "stripe", "test", "api_key"); ok {
+		t.Error("DeleteField should not reach into the trash")
+	}
+
+	trash, _ := s.ListTrash()
+	if len(trash) != 1 || trash[0].Kind != TrashSection || trash[0].Item != "stripe" || trash[0].Section != "test" || trash[0].Fields != 2 {
+		t.Fatalf("ListTrash = %+v, want section stripe/test with 2 fields", trash)
+	}
+
+	if ok, err := s.RestoreSection("v1", "stripe", "test"); err != nil || !ok {
+		t.Fatalf("RestoreSection = %v, %v", ok, err)
+	}
+	if val, _ := s.GetFieldValue("v1", "stripe", "test", "api_key"); val != "sk_test_2" {
+		t.Errorf("restored value = %q", val)
+	}
+	if versions, _ := s.ListFieldVersions("v1", "stripe", "test", "api_key"); len(versions) != 2 {
+		t.Errorf("restored history = %+v, want 2 versions", versions)
+	}
+
+	// Deleting the item trashes every section; purging it frees the names.
+	if ok, err := s.DeleteItem("v1", "stripe"); err != nil || !ok {
+		t.Fatalf("DeleteItem = %v, %v", ok, err)
+	}
+	if trash, _ := s.ListTrash(); len(trash) != 2 {
+		t.Fatalf("ListTrash after DeleteItem = %+v, want 2 sections", trash)
+	}
+	if items, _ := s.ListItems("v1"); len(items) != 0 {
+		t.Errorf("ListItems = %v, want none", items)
+	}
+	if ok, err := s.PurgeItem("v1", "stripe"); err != nil || !ok {
+		t.Fatalf("PurgeItem = %v, %v", ok, err)
+	}
+	if err := s.UpsertField("v1", "stripe", "test", "api_key", "sk_test_3"); err != nil {
+		t.Fatalf("UpsertField after purge: %v", err)
+	}
+	if versions, _ := s.ListFieldVersions("v1", "stripe", "test", "api_key"); len(versions) != 1 || versions[0].Version != 1 {
+		t.Errorf("history after purge = %+v, want a fresh version 1", versions)
+	}
+}
+
+func TestTrash_Instance(t *testing.T) {
+	s := newTestStore(t)
+	s.CreateVault(&Vault{ID: "v1", Name: "V1"})
+	s.RegisterInstance(&TEEInstance{FID: "fid1", PublicKey: []byte("pk1"), DstackAppID: "app1", Label: "web"})
+	s.GrantVaultAccess("v1", "fid1", nil, nil)
+
+	if ok, err := s.DeleteInstance("fid1"); err != nil || !ok {
+		t.Fatalf("DeleteInstance = %v, %v", ok, err)
+	}
+	if inst, _ := s.GetInstance("fid1"); inst != nil {
+		t.Errorf("GetInstance on a trashed instance = %+v, want nil", inst)
+	}
+	if instances, _ := s.ListVaultInstances("v1"); len(instances) != 0 {
+		t.Errorf("ListVaultInstances = %+v, want none", instances)
+	}
+	if has, _ := s.HasVaultAccess("v1", "fid1"); has {
+		t.Error("trashed instance should not have access")
+	}
+	if err := s.RegisterInstance(&TEEInstance{FID: "fid2", PublicKey: []byte("pk1"), DstackAppID: "app1"}); !errors.Is(err, ErrInTrash) {
+		t.Errorf("RegisterInstance with a trashed key: expected ErrInTrash, got %v", err)
+	}
+
+	trash, _ := s.ListTrash()
+	if len(trash) != 1 || trash[0].Kind != TrashInstance || trash[0].FID != "fid1" || trash[0].Name != "web" {
+		t.Fatalf("ListTrash = %+v, want instance fid1", trash)
+	}
+
+	if ok, err := s.RestoreInstance("fid1"); err != nil || !ok {
+		t.Fatalf("RestoreInstance = %v, %v", ok, err)
+	}
+	if has, _ := s.HasVaultAccess("v1", "fid1"); !has {
+		t.Error("grant should come back with the instance")
+	}
+
+	s.DeleteInstance("fid1")
+	if ok, err := s.PurgeInstance("fid1"); err != nil || !ok {
+		t.Fatalf("PurgeInstance = %v, %v", ok, err)
+	}
+	if err := s.RegisterInstance(&TEEInstance{FID: "fid1", PublicKey: []byte("pk1"), DstackAppID: "app1"}); err != nil {
+		t.Fatalf("RegisterInstance after purge: %v", err)
+	}
+	if has, _ := s.HasVaultAccess("v1", "fid1"); has {
+		t.Error("purged grant came back")
+	}
+}
+
+func TestPurgeTrash(t *testing.T) {
+	s := newTestStore(t)
+	s.CreateVault(&Vault{ID: "v1", Name: "V1"})
+	s.CreateVault(&Vault{ID: "v2", Name: "V2"})
+	s.UpsertField("v1", "alice", "", "token", "a")
+	s.UpsertField("v1", "bob", "", "token", "b")
+	s.RegisterInstance(&TEEInstance{FID: "fid1", PublicKey: []byte("pk1"), DstackAppID: "app1"})
+	s.DeleteItem("v1", "alice")
+	s.DeleteVault("v2")
+	s.DeleteInstance("fid1")
+
+	if n, err := PurgeTrash(s, time.Now().Add(-time.Hour)); err != nil || n != 0 {
+		t.Errorf("PurgeTrash of nothing old enough = %d, %v", n, err)
+	}
+	if n, err := PurgeTrash(s, time.Now().Add(time.Hour)); err != nil || n != 3 {
+		t.Errorf("PurgeTrash = %d, %v; want 3", n, err)
+	}
+	if trash, _ := s.ListTrash(); len(trash) != 0 {
+		t.Errorf("ListTrash after purge = %+v, want empty", trash)
+	}
+	if val, _ := s.GetFieldValue("v1", "bob", "", "token"); val != "b" {
+		t.Errorf("live field touched by purge: %q", val)
+	}
+}
+
+func TestTrash_SnapshotRoundTrip(t *testing.T) {
+	s := newTestStore(t)
+	s.CreateVault(&Vault{ID: "v1", Name: "V1"})
+	s.CreateVault(&Vault{ID: "v2", Name: "V2"})
+	s.UpsertField("v1", "alice", "", "token", "a")
+	s.RegisterInstance(&TEEInstance{FID: "fid1", PublicKey: []byte("pk1"), DstackAppID: "app1"})
+	s.DeleteSection("v1", "alice", "")
+	s.DeleteVault("v2")
+	s.DeleteInstance("fid1")
+	want, _ := s.ListTrash()
+
+	snap, err := s.Snapshot()
+	if err != nil {
+		t.Fatalf("Snapshot: %v", err)
+	}
+	dst := newTestStore(t)
+	if err := dst.Restore(snap); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	got, _ := dst.ListTrash()
+	if len(got) != len(want) {
+		t.Fatalf("restored trash = %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i].Kind != want[i].Kind || !got[i].DeletedAt.Equal(want[i].DeletedAt) {
+			t.Errorf("restored trash entry %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+	if ok, err := dst.RestoreSection("v1", "alice", ""); err != nil || !ok {
+		t.Fatalf("RestoreSection after restore = %v, %v", ok, err)
+	}
+	if val, _ := dst.GetFieldValue("v1", "alice", "", "token"); val != "a" {
+		t.Errorf("restored value = %q", val)
+	}
+}
@@ -6,51 +6,176 @@ import (
 	"fmt"
 )
 
+// Vault fields are organised as item → section → field, mirroring secret
+// references: jingui://<vault>/<item>/<field> addresses a field in the item's
+// default section (""), and jingui://<vault>/<item>/<section>/<field> a field
+// in a named section.
+
 // ErrFieldNotFound is returned by GetFieldValue when the requested field does
 // not exist. Callers should use errors.Is to distinguish this from real DB
 // errors.
 var ErrFieldNotFound = errors.New("field not found")
 
-// UpsertField inserts or updates a single field in a vault item.
-func (s *Store) UpsertField(vaultID, section, itemName, value string) error {
-	_, err := s.db.Exec(
-		`INSERT INTO vault_items (vault_id, section, item_name, value)
-		 VALUES (?, ?, ?, ?)
-		 ON CONFLICT(vault_id, section, item_name) DO UPDATE SET
-		   value = excluded.value,
+// dbtx is satisfied by both *dialectDB and *dialectTx.
+type dbtx interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// fieldPath formats field coordinates for error messages.
+func fieldPath(vaultID, item, section, field string) string {
+	if section == "" {
+		return vaultID + "/" + item + "/" + field
+	}
+	return vaultID + "/" + item + "/" + section + "/" + field
+}
+
+// upsertField encrypts value, stores it as the field's next version, and
+// makes that version current. Versions beyond the vault's retention limit are
+// pruned. Returns the new version number, or ErrInTrash if the vault or the
+// section is in the trash.
+func (s *SQLStore) upsertField(e dbtx, vaultID, item, section, field, value, author string) (int, error) {
+	if err := checkNotTrashed(e, vaultID, item, section); err != nil {
+		return 0, err
+	}
+
+	ct, wk, err := s.keys.active.seal(fieldAAD(vaultID, item, section, field), value)
+	if err != nil {
+		return 0, err
+	}
+
+	var version int
+	if err := e.QueryRow(
+		`SELECT COALESCE(MAX(version), 0) + 1 FROM vault_item_versions
+		 WHERE vault_id = ? AND item = ? AND section = ? AND field_name = ?`,
+		vaultID, item, section, field,
+	).Scan(&version); err != nil {
+		return 0, fmt.Errorf("next version: %w", err)
+	}
+
+	if _, err := e.Exec(
+		`INSERT INTO vault_items (vault_id, item, section, field_name, ciphertext, wrapped_key, key_id, version)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		 ON CONFLICT(vault_id, item, section, field_name) DO UPDATE SET
+		   ciphertext = excluded.ciphertext,
+		   wrapped_key = excluded.wrapped_key,
+		   key_id = excluded.key_id,
+		   version = excluded.version,
 		   updated_at = CURRENT_TIMESTAMP`,
-		vaultID, section, itemName, value,
+		vaultID, item, section, field, ct, wk, s.keys.active.keyID, version,
+	); err != nil {
+		return 0, err
+	}
+
+	if _, err := e.Exec(
+		`INSERT INTO vault_item_versions (vault_id, item, section, field_name, version, ciphertext, wrapped_key, key_id, author)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		vaultID, item, section, field, version, ct, wk, s.keys.active.keyID, author,
+	); err != nil {
+		return 0, fmt.Errorf("record version: %w", err)
+	}
+
+	if err := pruneFieldVersions(e, vaultID, item, section, field); err != nil {
+		return 0, err
+	}
+	return version, nil
+}
+
+// deleteField removes a live field together with its version history and
+// expiry. Fields in the trash are left alone.
+func deleteField(e dbtx, vaultID, item, section, field string) (bool, error) {
+	res, err := e.Exec(
+		`DELETE FROM vault_items WHERE vault_id = ? AND item = ? AND section = ? AND field_name = ? AND `+liveField,
+		vaultID, item, section, field,
 	)
 	if err != nil {
+		return false, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return false, nil
+	}
+	if _, err := e.Exec(
+		`DELETE FROM field_expiries WHERE vault_id = ? AND item = ? AND section = ? AND field_name = ?`,
+		vaultID, item, section, field,
+	); err != nil {
+		return false, fmt.Errorf("delete expiry: %w", err)
+	}
+	if _, err := e.Exec(
+		`DELETE FROM vault_item_versions WHERE vault_id = ? AND item = ? AND section = ? AND field_name = ?`,
+		vaultID, item, section, field,
+	); err != nil {
+		return false, fmt.Errorf("delete versions: %w", err)
+	}
+	// A section expiry goes with the section's last field.
+	if _, err := e.Exec(
+		`DELETE FROM field_expiries WHERE vault_id = ? AND item = ? AND section = ? AND field_name = ''
+		   AND NOT EXISTS (SELECT 1 FROM vault_items WHERE vault_id = ? AND item = ? AND section = ?)`,
+		vaultID, item, section, vaultID, item, section,
+	); err != nil {
+		return false, fmt.Errorf("delete section expiry: %w", err)
+	}
+	return true, nil
+}
+
+// UpsertField inserts or updates a single field in a section of a vault item.
+func (s *SQLStore) UpsertField(vaultID, item, section, field, value string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := s.upsertField(tx, vaultID, item, section, field, value, ""); err != nil {
 		return fmt.Errorf("upsert field: %w", err)
 	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit tx: %w", err)
+	}
 	return nil
 }
 
-// SetItemFields batch upserts all fields for a section, replacing existing fields.
-// Fields not in the map are deleted.
-func (s *Store) SetItemFields(vaultID, section string, fields map[string]string) error {
+// SetItemFields batch upserts all fields for a section of an item, replacing
+// existing fields. Fields of that section not in the map are deleted; other
+// sections of the item are left alone.
+func (s *SQLStore) SetItemFields(vaultID, item, section string, fields map[string]string) error {
 	tx, err := s.db.Begin()
 	if err != nil {
 		return fmt.Errorf("begin tx: %w", err)
 	}
 	defer tx.Rollback()
 
-	// Delete existing fields for this vault+section
-	if _, err := tx.Exec(
-		`DELETE FROM vault_items WHERE vault_id = ? AND section = ?`,
-		vaultID, section,
-	); err != nil {
-		return fmt.Errorf("delete old fields: %w", err)
+	// Delete existing fields for this item+section that are not being set
+	rows, err := tx.Query(
+		`SELECT field_name FROM vault_items WHERE vault_id = ? AND item = ? AND section = ? AND deleted_at IS NULL`,
+		vaultID, item, section,
+	)
+	if err != nil {
+		return fmt.Errorf("list old fields: %w", err)
+	}
+	var stale []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return fmt.Errorf("scan old field: %w", err)
+		}
+		if _, keep := fields[name]; !keep {
+			stale = append(stale, name)
+		}
+	}
+	if err := rows.Close(); err != nil {
+		return fmt.Errorf("list old fields: %w", err)
+	}
+	for _, name := range stale {
+		if _, err := deleteField(tx, vaultID, item, section, name); err != nil {
+			return fmt.Errorf("delete old field %q: %w", name, err)
+		}
 	}
 
 	// Insert new fields
 	for name, value := range fields {
-		if _, err := tx.Exec(
-			`INSERT INTO vault_items (vault_id, section, item_name, value)
-			 VALUES (?, ?, ?, ?)`,
-			vaultID, section, name, value,
-		); err != nil {
+		if _, err := s.upsertField(tx, vaultID, item, section, name, value, ""); err != nil {
 			return fmt.Errorf("insert field %q: %w", name, err)
 		}
 	}
@@ -61,12 +186,14 @@ func (s *Store) SetItemFields(vaultID, section string, fields map[string]string)
 	return nil
 }
 
-// GetItemFields returns all fields for a vault+section.
-func (s *Store) GetItemFields(vaultID, section string) ([]VaultItem, error) {
+// GetItemFields returns all fields of a vault item across its sections,
+// ordered by section and field name. Fields in the trash are left out.
+func (s *SQLStore) GetItemFields(vaultID, item string) ([]VaultItem, error) {
 	rows, err := s.db.Query(
-		`SELECT rowid, vault_id, item_name, section, value, created_at, updated_at
-		 FROM vault_items WHERE vault_id = ? AND section = ? ORDER BY item_name`,
-		vaultID, section,
+		`SELECT rowid, vault_id, item, section, field_name, ciphertext, wrapped_key, key_id, version, created_at, updated_at
+		 FROM vault_items WHERE vault_id = ? AND item = ? AND `+liveField+`
+		 ORDER BY section, field_name`,
+		vaultID, item,
 	)
 	if err != nil {
 		return nil, fmt.Errorf("get item fields: %w", err)
@@ -76,80 +203,92 @@ func (s *Store) GetItemFields(vaultID, section string) ([]VaultItem, error) {
 	var items []VaultItem
 	for rows.Next() {
 		var vi VaultItem
-		if err := rows.Scan(&vi.ID, &vi.VaultID, &vi.ItemName, &vi.Section, &vi.Value, &vi.CreatedAt, &vi.UpdatedAt); err != nil {
+		var ct, wk []byte
+		var keyID string
+		if err := rows.Scan(&vi.ID, &vi.VaultID, &vi.Item, &vi.Section, &vi.FieldName, &ct, &wk, &keyID, &vi.Version, &vi.CreatedAt, &vi.UpdatedAt); err != nil {
 			return nil, fmt.Errorf("scan vault item: %w", err)
 		}
+		vi.Value, err = s.keys.open(keyID, fieldAAD(vi.VaultID, vi.Item, vi.Section, vi.FieldName), ct, wk)
+		if err != nil {
+			return nil, fmt.Errorf("open vault item %q: %w", fieldPath(vi.VaultID, vi.Item, vi.Section, vi.FieldName), err)
+		}
 		items = append(items, vi)
 	}
 	return items, rows.Err()
 }
 
-// GetFieldValue returns the value of a single field.
-func (s *Store) GetFieldValue(vaultID, section, itemName string) (string, error) {
-	var value string
+// GetFieldValue returns the value of a single field. Fields in the trash are
+// reported as ErrFieldNotFound.
+func (s *SQLStore) GetFieldValue(vaultID, item, section, field string) (string, error) {
+	var ct, wk []byte
+	var keyID string
 	err := s.db.QueryRow(
-		`SELECT value FROM vault_items WHERE vault_id = ? AND section = ? AND item_name = ?`,
-		vaultID, section, itemName,
-	).Scan(&value)
+		`SELECT ciphertext, wrapped_key, key_id FROM vault_items
+		 WHERE vault_id = ? AND item = ? AND section = ? AND field_name = ? AND `+liveField,
+		vaultID, item, section, field,
+	).Scan(&ct, &wk, &keyID)
 	if err == sql.ErrNoRows {
-		return "", fmt.Errorf("%w: %s/%s/%s", ErrFieldNotFound, vaultID, section, itemName)
+		return "", fmt.Errorf("%w: %s", ErrFieldNotFound, fieldPath(vaultID, item, section, field))
 	}
 	if err != nil {
 		return "", fmt.Errorf("get field value: %w", err)
 	}
+	value, err := s.keys.open(keyID, fieldAAD(vaultID, item, section, field), ct, wk)
+	if err != nil {
+		return "", fmt.Errorf("get field value: %w", err)
+	}
 	return value, nil
 }
 
-// ListSections returns distinct sections for a vault.
-func (s *Store) ListSections(vaultID string) ([]string, error) {
+// ListItems returns distinct names of items with fields outside the trash.
+func (s *SQLStore) ListItems(vaultID string) ([]string, error) {
 	rows, err := s.db.Query(
-		`SELECT DISTINCT section FROM vault_items WHERE vault_id = ? ORDER BY section`,
+		`SELECT DISTINCT item FROM vault_items WHERE vault_id = ? AND `+liveField+` ORDER BY item`,
 		vaultID,
 	)
 	if err != nil {
-		return nil, fmt.Errorf("list sections: %w", err)
+		return nil, fmt.Errorf("list items: %w", err)
 	}
 	defer rows.Close()
 
-	var sections []string
+	var items []string
 	for rows.Next() {
-		var section string
-		if err := rows.Scan(&section); err != nil {
-			return nil, fmt.Errorf("scan section: %w", err)
+		var item string
+		if err := rows.Scan(&item); err != nil {
+			return nil, fmt.Errorf("scan item: %w", err)
 		}
-		sections = append(sections, section)
+		items = append(items, item)
 	}
-	return sections, rows.Err()
+	return items, rows.Err()
 }
 
 // MergeItemFields upserts provided fields and deletes specified keys without
-// touching other existing fields in the section.
-func (s *Store) MergeItemFields(vaultID, section string, upsert map[string]string, deleteKeys []string) error {
+// touching other existing fields in the section. Each upserted field gets a
+// new version attributed to author.
+func (s *SQLStore) MergeItemFields(vaultID, item, section string, upsert map[string]string, deleteKeys []string, author string) error {
+	return s.MergeVaultFields(vaultID, []ItemMerge{{Item: item, Section: section, Upsert: upsert, Delete: deleteKeys}}, author)
+}
+
+// MergeVaultFields applies the MergeItemFields changes for several sections
+// of a vault in a single transaction, so either all of them land or none.
+func (s *SQLStore) MergeVaultFields(vaultID string, merges []ItemMerge, author string) error {
 	tx, err := s.db.Begin()
 	if err != nil {
 		return fmt.Errorf("begin tx: %w", err)
 	}
 	defer tx.Rollback()
 
-	for _, key := range deleteKeys {
-		if _, err := tx.Exec(
-			`DELETE FROM vault_items WHERE vault_id = ? AND section = ? AND item_name = ?`,
-			vaultID, section, key,
-		); err != nil {
-			return fmt.Errorf("delete key %q: %w", key, err)
+	for _, m := range merges {
+		for _, key := range m.Delete {
+			if _, err := deleteField(tx, vaultID, m.Item, m.Section, key); err != nil {
+				return fmt.Errorf("delete key %q: %w", key, err)
+			}
 		}
-	}
 
-	for name, value := range upsert {
-		if _, err := tx.Exec(
-			`INSERT INTO vault_items (vault_id, section, item_name, value)
-			 VALUES (?, ?, ?, ?)
-			 ON CONFLICT(vault_id, section, item_name) DO UPDATE SET
-			   value = excluded.value,
-			   updated_at = CURRENT_TIMESTAMP`,
-			vaultID, section, name, value,
-		); err != nil {
-			return fmt.Errorf("upsert field %q: %w", name, err)
+		for name, value := range m.Upsert {
+			if _, err := s.upsertField(tx, vaultID, m.Item, m.Section, name, value, author); err != nil {
+				return fmt.Errorf("upsert field %q: %w", name, err)
+			}
 		}
 	}
 
@@ -159,28 +298,45 @@ func (s *Store) MergeItemFields(vaultID, section string, upsert map[string]strin
 	return nil
 }
 
-// DeleteSection deletes all fields in a section. Returns true if any rows were deleted.
-func (s *Store) DeleteSection(vaultID, section string) (bool, error) {
-	res, err := s.db.Exec(
-		`DELETE FROM vault_items WHERE vault_id = ? AND section = ?`,
-		vaultID, section,
-	)
+// DeleteItem moves every section of an item to the trash. Returns true if
+// any field was moved.
+func (s *SQLStore) DeleteItem(vaultID, item string) (bool, error) {
+	return s.trashFields(`vault_id = ? AND item = ?`, vaultID, item)
+}
+
+// DeleteSection moves one section of an item to the trash. Returns true if
+// any field was moved.
+func (s *SQLStore) DeleteSection(vaultID, item, section string) (bool, error) {
+	return s.trashFields(`vault_id = ? AND item = ? AND section = ?`, vaultID, item, section)
+}
+
+// trashFields stamps the live fields matching where as deleted. Their
+// history and expiries are kept for a restore.
+func (s *SQLStore) trashFields(where string, args ...any) (bool, error) {
+	res, err := s.db.Exec(`UPDATE vault_items SET deleted_at = CURRENT_TIMESTAMP WHERE `+liveField+` AND `+where, args...)
 	if err != nil {
-		return false, fmt.Errorf("delete section: %w", err)
+		return false, fmt.Errorf("delete fields: %w", err)
 	}
 	n, _ := res.RowsAffected()
 	return n > 0, nil
 }
 
-// DeleteField deletes a single field. Returns true if a row was deleted.
-func (s *Store) DeleteField(vaultID, section, itemName string) (bool, error) {
-	res, err := s.db.Exec(
-		`DELETE FROM vault_items WHERE vault_id = ? AND section = ? AND item_name = ?`,
-		vaultID, section, itemName,
-	)
+// DeleteField permanently deletes a single field and its version history; it
+// does not go to the trash. Returns true if a row was deleted.
+func (s *SQLStore) DeleteField(vaultID, item, section, field string) (bool, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return false, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	deleted, err := deleteField(tx, vaultID, item, section, field)
 	if err != nil {
 		return false, fmt.Errorf("delete field: %w", err)
 	}
-	n, _ := res.RowsAffected()
-	return n > 0, nil
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("commit tx: %w", err)
+	}
+	return deleted, nil
 }
@@ -0,0 +1,186 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestItemSections_DistinctValues(t *testing.T) {
+	s := newTestStore(t)
+	s.CreateVault(&Vault{ID: "v1", Name: "V1"})
+
+	s.UpsertField("v1", "alice", "", "token", "default-token")
+	s.UpsertField("v1", "alice", "oauth", "token", "oauth-token")
+	s.UpsertField("v1", "alice", "other", "token", "other-token")
+
+	for section, want := range map[string]string{"": "default-token", "oauth": "oauth-token", "other": "other-token"} {
+		got, err := s.GetFieldValue("v1", "alice", section, "token")
+		if err != nil {
+			t.Fatalf("GetFieldValue(section=%q): %v", section, err)
+		}
+		if got != want {
+			t.Errorf("GetFieldValue(section=%q) = %q, want %q", section, got, want)
+		}
+	}
+	if _, err := s.GetFieldValue("v1", "alice", "missing", "token"); !errors.Is(err, ErrFieldNotFound) {
+		t.Errorf("expected ErrFieldNotFound for unknown section, got %v", err)
+	}
+
+	items, _ := s.ListItems("v1")
+	if len(items) != 1 || items[0] != "alice" {
+		t.Errorf("ListItems = %v, want [alice]", items)
+	}
+	fields, _ := s.GetItemFields("v1", "alice")
+	if len(fields) != 3 || fields[0].Section != "" || fields[1].Section != "oauth" || fields[2].Section != "other" {
+		t.Errorf("GetItemFields = %+v, want one field per section ordered by section", fields)
+	}
+
+	// Replacing one section leaves the others alone.
+	if err := s.SetItemFields("v1", "alice", "oauth", map[string]string{"refresh": "r"}); err != nil {
+		t.Fatalf("SetItemFields: %v", err)
+	}
+	if _, err := s.GetFieldValue("v1", "alice", "oauth", "token"); !errors.Is(err, ErrFieldNotFound) {
+		t.Errorf("expected oauth/token to be replaced, got %v", err)
+	}
+	if v, _ := s.GetFieldValue("v1", "alice", "other", "token"); v != "other-token" {
+		t.Errorf("other/token = %q, want other-token", v)
+	}
+
+	if deleted, err := s.DeleteSection("v1", "alice", "other"); err != nil || !deleted {
+		t.Fatalf("DeleteSection = %v, %v", deleted, err)
+	}
+	if v, _ := s.GetFieldValue("v1", "alice", "", "token"); v != "default-token" {
+		t.Errorf("default token = %q after deleting another section", v)
+	}
+}
+
+func TestUpgradeMovesFieldsToDefaultSection(t *testing.T) {
+	requireSQLite(t)
+	path := filepath.Join(t.TempDir(), "legacy.db")
+
+	legacy, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatalf("open legacy db: %v", err)
+	}
+	sl, _ := newSealer(testMasterKey)
+	ct, wk, err := sl.seal(fieldAAD("v1", "alice", "", "token"), "legacy-token")
+	if err != nil {
+		t.Fatalf("seal: %v", err)
+	}
+	for _, stmt := range []string{
+		`CREATE TABLE vaults (
+			id TEXT PRIMARY KEY,
+			name TEXT NOT NULL,
+			version_retention INTEGER NOT NULL DEFAULT 0,
+			created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE vault_items (
+			rowid INTEGER PRIMARY KEY AUTOINCREMENT,
+			vault_id TEXT NOT NULL,
+			item_name TEXT NOT NULL,
+			section TEXT NOT NULL DEFAULT '',
+			ciphertext BLOB NOT NULL,
+			wrapped_key BLOB NOT NULL,
+			key_id TEXT NOT NULL,
+			version INTEGER NOT NULL DEFAULT 1,
+			created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE(vault_id, section, item_name),
+			FOREIGN KEY (vault_id) REFERENCES vaults(id)
+		)`,
+		`CREATE TABLE vault_item_versions (
+			rowid INTEGER PRIMARY KEY AUTOINCREMENT,
+			vault_id TEXT NOT NULL,
+			section TEXT NOT NULL,
+			item_name TEXT NOT NULL,
+			version INTEGER NOT NULL,
+			ciphertext BLOB NOT NULL,
+			wrapped_key BLOB NOT NULL,
+			key_id TEXT NOT NULL,
+			author TEXT NOT NULL DEFAULT '',
+			created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE(vault_id, section, item_name, version),
+			FOREIGN KEY (vault_id) REFERENCES vaults(id)
+		)`,
+		`INSERT INTO vaults (id, name) VALUES ('v1', 'V1')`,
+	} {
+		if _, err := legacy.Exec(stmt); err != nil {
+			t.Fatalf("seed legacy db: %v", err)
+		}
+	}
+	for _, table := range []string{"vault_items", "vault_item_versions"} {
+		if _, err := legacy.Exec(
+			`INSERT INTO `+table+` (vault_id, section, item_name, version, ciphertext, wrapped_key, key_id)
+			 VALUES ('v1', 'alice', 'token', 2, ?, ?, ?)`,
+			ct, wk, sl.keyID,
+		); err != nil {
+			t.Fatalf("seed %s: %v", table, err)
+		}
+	}
+	legacy.Close()
+
+	s, err := NewStore(path, testMasterKey)
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+	defer s.Close()
+
+	val, err := s.GetFieldValue("v1", "alice", "", "token")
+	if err != nil {
+		t.Fatalf("GetFieldValue: %v", err)
+	}
+	if val != "legacy-token" {
+		t.Errorf("expected legacy-token, got %q", val)
+	}
+	if v, err := s.GetFieldVersionValue("v1", "alice", "", "token", 2); err != nil || v != "legacy-token" {
+		t.Errorf("GetFieldVersionValue = %q, %v", v, err)
+	}
+
+	// The next write continues the existing version sequence.
+	if err := s.UpsertField("v1", "alice", "", "token", "new-token"); err != nil {
+		t.Fatalf("UpsertField: %v", err)
+	}
+	versions, _ := s.ListFieldVersions("v1", "alice", "", "token")
+	if len(versions) != 2 || versions[0].Version != 3 {
+		t.Errorf("expected versions [3 2], got %+v", versions)
+	}
+}
+
+func TestMergeVaultFields(t *testing.T) {
+	s := newTestStore(t)
+	s.CreateVault(&Vault{ID: "v1", Name: "V1"})
+	s.UpsertField("v1", "alice", "", "stale", "x")
+
+	err := s.MergeVaultFields("v1", []ItemMerge{
+		{Item: "alice", Upsert: map[string]string{"token": "a"}, Delete: []string{"stale"}},
+		{Item: "alice", Section: "oauth", Upsert: map[string]string{"refresh": "r"}},
+		{Item: "bob", Upsert: map[string]string{"token": "b"}},
+	}, "importer")
+	if err != nil {
+		t.Fatalf("MergeVaultFields: %v", err)
+	}
+	for _, f := range []struct{ item, section, field, want string }{
+		{"alice", "", "token", "a"},
+		{"alice", "oauth", "refresh", "r"},
+		{"bob", "", "token", "b"},
+	} {
+		if got, err := s.GetFieldValue("v1", f.item, f.section, f.field); err != nil || got != f.want {
+			t.Errorf("%s/%s/%s = %q, %v; want %q", f.item, f.section, f.field, got, err, f.want)
+		}
+	}
+	if _, err := s.GetFieldValue("v1", "alice", "", "stale"); !errors.Is(err, ErrFieldNotFound) {
+		t.Errorf("expected stale to be deleted, got %v", err)
+	}
+	if versions, _ := s.ListFieldVersions("v1", "bob", "", "token"); len(versions) != 1 || versions[0].Author != "importer" {
+		t.Errorf("bob/token versions = %+v, want one by importer", versions)
+	}
+
+	if err := s.MergeVaultFields("missing", []ItemMerge{{Item: "alice", Upsert: map[string]string{"token": "a"}}}, ""); err == nil {
+		t.Error("expected merging into a missing vault to fail")
+	}
+	if items, _ := s.ListItems("missing"); len(items) != 0 {
+		t.Errorf("failed merge left items behind: %v", items)
+	}
+}
@@ -4,9 +4,6 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
-
-	"modernc.org/sqlite"
-	sqlite3 "modernc.org/sqlite/lib"
 )
 
 var (
@@ -14,15 +11,21 @@ var (
 	ErrVaultHasDependents = errors.New("vault has dependent records; delete them first or use ?cascade=true")
 )
 
-// CreateVault inserts a new vault.
-func (s *Store) CreateVault(v *Vault) error {
+// CreateVault inserts a new vault. An ID held by a vault in the trash is
+// reported as ErrInTrash rather than ErrVaultDuplicate.
+func (s *SQLStore) CreateVault(v *Vault) error {
 	_, err := s.db.Exec(
-		`INSERT INTO vaults (id, name) VALUES (?, ?)`,
-		v.ID, v.Name,
+		`INSERT INTO vaults (id, name, version_retention) VALUES (?, ?, ?)`,
+		v.ID, v.Name, v.VersionRetention,
 	)
 	if err != nil {
-		var sqliteErr *sqlite.Error
-		if errors.As(err, &sqliteErr) && sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY {
+		if s.dialect.constraint(err) == constraintPrimaryKey {
+			var trashed int
+			if err := s.db.QueryRow(
+				`SELECT COUNT(*) FROM vaults WHERE id = ? AND deleted_at IS NOT NULL`, v.ID,
+			).Scan(&trashed); err == nil && trashed > 0 {
+				return fmt.Errorf("vault %q is %w", v.ID, ErrInTrash)
+			}
 			return ErrVaultDuplicate
 		}
 		return fmt.Errorf("insert vault: %w", err)
@@ -30,12 +33,12 @@ func (s *Store) CreateVault(v *Vault) error {
 	return nil
 }
 
-// GetVault retrieves a vault by ID.
-func (s *Store) GetVault(id string) (*Vault, error) {
+// GetVault retrieves a vault by ID. Vaults in the trash are not returned.
+func (s *SQLStore) GetVault(id string) (*Vault, error) {
 	v := &Vault{}
 	err := s.db.QueryRow(
-		`SELECT id, name, created_at FROM vaults WHERE id = ?`, id,
-	).Scan(&v.ID, &v.Name, &v.CreatedAt)
+		`SELECT id, name, version_retention, created_at FROM vaults WHERE id = ? AND deleted_at IS NULL`, id,
+	).Scan(&v.ID, &v.Name, &v.VersionRetention, &v.CreatedAt)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
@@ -46,9 +49,9 @@ func (s *Store) GetVault(id string) (*Vault, error) {
 }
 
 // UpdateVault updates the name of a vault. Returns true if a row was updated.
-func (s *Store) UpdateVault(v *Vault) (bool, error) {
+func (s *SQLStore) UpdateVault(v *Vault) (bool, error) {
 	res, err := s.db.Exec(
-		`UPDATE vaults SET name = ? WHERE id = ?`,
+		`UPDATE vaults SET name = ? WHERE id = ? AND deleted_at IS NULL`,
 		v.Name, v.ID,
 	)
 	if err != nil {
@@ -58,10 +61,10 @@ func (s *Store) UpdateVault(v *Vault) (bool, error) {
 	return n > 0, nil
 }
 
-// ListVaults returns all vaults ordered by creation time.
-func (s *Store) ListVaults() ([]Vault, error) {
+// ListVaults returns all vaults outside the trash ordered by creation time.
+func (s *SQLStore) ListVaults() ([]Vault, error) {
 	rows, err := s.db.Query(
-		`SELECT id, name, created_at FROM vaults ORDER BY created_at`,
+		`SELECT id, name, version_retention, created_at FROM vaults WHERE deleted_at IS NULL ORDER BY created_at`,
 	)
 	if err != nil {
 		return nil, fmt.Errorf("list vaults: %w", err)
@@ -71,7 +74,7 @@ func (s *Store) ListVaults() ([]Vault, error) {
 	var vaults []Vault
 	for rows.Next() {
 		var v Vault
-		if err := rows.Scan(&v.ID, &v.Name, &v.CreatedAt); err != nil {
+		if err := rows.Scan(&v.ID, &v.Name, &v.VersionRetention, &v.CreatedAt); err != nil {
 			return nil, fmt.Errorf("scan vault: %w", err)
 		}
 		vaults = append(vaults, v)
@@ -79,56 +82,60 @@ func (s *Store) ListVaults() ([]Vault, error) {
 	return vaults, rows.Err()
 }
 
-// DeleteVault deletes a vault by ID. Returns ErrVaultHasDependents if FK constraints prevent deletion.
-func (s *Store) DeleteVault(id string) (bool, error) {
-	res, err := s.db.Exec(`DELETE FROM vaults WHERE id = ?`, id)
-	if err != nil {
-		var sqliteErr *sqlite.Error
-		if errors.As(err, &sqliteErr) && sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_FOREIGNKEY {
-			return false, ErrVaultHasDependents
-		}
-		return false, fmt.Errorf("delete vault: %w", err)
-	}
-	n, _ := res.RowsAffected()
-	return n > 0, nil
-}
-
-// DeleteVaultCascade deletes a vault and all dependent records in a transaction.
-func (s *Store) DeleteVaultCascade(id string) (bool, error) {
+// DeleteVault moves an empty vault to the trash. Returns ErrVaultHasDependents
+// if any field, grant or debug policy still references it, including ones in
+// the trash.
+func (s *SQLStore) DeleteVault(id string) (bool, error) {
 	tx, err := s.db.Begin()
 	if err != nil {
 		return false, fmt.Errorf("begin tx: %w", err)
 	}
 	defer tx.Rollback()
 
-	// Delete debug_policies referencing this vault
-	if _, err := tx.Exec(`DELETE FROM debug_policies WHERE vault_id = ?`, id); err != nil {
-		return false, fmt.Errorf("delete debug_policies for vault: %w", err)
+	var dependents int
+	if err := tx.QueryRow(
+		`SELECT (SELECT COUNT(*) FROM vault_items WHERE vault_id = ?) +
+		        (SELECT COUNT(*) FROM vault_item_versions WHERE vault_id = ?) +
+		        (SELECT COUNT(*) FROM field_expiries WHERE vault_id = ?) +
+		        (SELECT COUNT(*) FROM vault_instance_access WHERE vault_id = ?) +
+		        (SELECT COUNT(*) FROM field_grants WHERE vault_id = ?) +
+		        (SELECT COUNT(*) FROM vault_app_access WHERE vault_id = ?) +
+		        (SELECT COUNT(*) FROM enrollment_policy_vaults WHERE vault_id = ?) +
+		        (SELECT COUNT(*) FROM enrollment_token_vaults WHERE vault_id = ?) +
+		        (SELECT COUNT(*) FROM debug_policies WHERE vault_id = ?)`,
+		id, id, id, id, id, id, id, id, id,
+	).Scan(&dependents); err != nil {
+		return false, fmt.Errorf("count vault dependents: %w", err)
 	}
 
-	// Delete vault_instance_access entries
-	if _, err := tx.Exec(`DELETE FROM vault_instance_access WHERE vault_id = ?`, id); err != nil {
-		return false, fmt.Errorf("delete vault_instance_access for vault: %w", err)
+	deleted, err := trashVault(tx, id)
+	if err != nil || !deleted {
+		return false, err
+	}
+	if dependents > 0 {
+		return false, ErrVaultHasDependents
 	}
 
-	// Delete vault_items
-	if _, err := tx.Exec(`DELETE FROM vault_items WHERE vault_id = ?`, id); err != nil {
-		return false, fmt.Errorf("delete vault_items for vault: %w", err)
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("commit tx: %w", err)
 	}
+	return true, nil
+}
+
+// DeleteVaultCascade moves a vault to the trash together with all its fields,
+// grants and debug policies, which stay hidden until the vault is restored or
+// purged.
+func (s *SQLStore) DeleteVaultCascade(id string) (bool, error) {
+	return trashVault(s.db, id)
+}
 
-	// Delete the vault itself
-	res, err := tx.Exec(`DELETE FROM vaults WHERE id = ?`, id)
+// trashVault stamps a live vault as deleted. Returns false if there is no
+// such vault outside the trash.
+func trashVault(e dbtx, id string) (bool, error) {
+	res, err := e.Exec(`UPDATE vaults SET deleted_at = CURRENT_TIMESTAMP WHERE id = ? AND deleted_at IS NULL`, id)
 	if err != nil {
 		return false, fmt.Errorf("delete vault: %w", err)
 	}
-
 	n, _ := res.RowsAffected()
-	if n == 0 {
-		return false, nil
-	}
-
-	if err := tx.Commit(); err != nil {
-		return false, fmt.Errorf("commit tx: %w", err)
-	}
-	return true, nil
+	return n > 0, nil
 }
@@ -0,0 +1,170 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// ErrVersionNotFound is returned by GetFieldVersionValue and RollbackField
+// when the requested version does not exist (or was pruned by the vault's
+// retention limit).
+var ErrVersionNotFound = errors.New("version not found")
+
+// pruneFieldVersions deletes the oldest versions of a field beyond the vault's
+// retention limit. The current version is always kept.
+func pruneFieldVersions(e dbtx, vaultID, item, section, field string) error {
+	if _, err := e.Exec(
+		`DELETE FROM vault_item_versions
+		 WHERE vault_id = ? AND item = ? AND section = ? AND field_name = ?
+		   AND version <= (
+		     SELECT MAX(v.version) FROM vault_item_versions v
+		     WHERE v.vault_id = ? AND v.item = ? AND v.section = ? AND v.field_name = ?
+		   ) - (SELECT version_retention FROM vaults WHERE id = ?)
+		   AND (SELECT version_retention FROM vaults WHERE id = ?) > 0`,
+		vaultID, item, section, field,
+		vaultID, item, section, field,
+		vaultID, vaultID,
+	); err != nil {
+		return fmt.Errorf("prune versions: %w", err)
+	}
+	return nil
+}
+
+// ListFieldVersions returns the retained versions of a field, newest first.
+// The history of fields in the trash is left out.
+func (s *SQLStore) ListFieldVersions(vaultID, item, section, field string) ([]FieldVersion, error) {
+	rows, err := s.db.Query(
+		`SELECT v.vault_id, v.item, v.section, v.field_name, v.version, v.author, v.created_at,
+		        COALESCE(i.version = v.version, FALSE)
+		 FROM vault_item_versions v
+		 LEFT JOIN vault_items i
+		   ON i.vault_id = v.vault_id AND i.item = v.item AND i.section = v.section AND i.field_name = v.field_name
+		 WHERE v.vault_id = ? AND v.item = ? AND v.section = ? AND v.field_name = ?
+		   AND i.deleted_at IS NULL
+		   AND v.vault_id IN (SELECT id FROM vaults WHERE deleted_at IS NULL)
+		 ORDER BY v.version DESC`,
+		vaultID, item, section, field,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("list field versions: %w", err)
+	}
+	defer rows.Close()
+
+	var versions []FieldVersion
+	for rows.Next() {
+		var fv FieldVersion
+		if err := rows.Scan(&fv.VaultID, &fv.Item, &fv.Section, &fv.FieldName, &fv.Version, &fv.Author, &fv.CreatedAt, &fv.Current); err != nil {
+			return nil, fmt.Errorf("scan field version: %w", err)
+		}
+		versions = append(versions, fv)
+	}
+	return versions, rows.Err()
+}
+
+// GetFieldVersionValue returns the value of a specific version of a field.
+// Versions of fields in the trash are reported as ErrVersionNotFound.
+func (s *SQLStore) GetFieldVersionValue(vaultID, item, section, field string, version int) (string, error) {
+	var ct, wk []byte
+	var keyID string
+	err := s.db.QueryRow(
+		`SELECT ciphertext, wrapped_key, key_id FROM vault_item_versions
+		 WHERE vault_id = ? AND item = ? AND section = ? AND field_name = ? AND version = ?
+		   AND `+liveVaultID+` AND NOT `+inTrashedSection("vault_item_versions"),
+		vaultID, item, section, field, version,
+	).Scan(&ct, &wk, &keyID)
+	if err == sql.ErrNoRows {
+		return "", fmt.Errorf("%w: %s@%d", ErrVersionNotFound, fieldPath(vaultID, item, section, field), version)
+	}
+	if err != nil {
+		return "", fmt.Errorf("get field version: %w", err)
+	}
+	value, err := s.keys.open(keyID, fieldAAD(vaultID, item, section, field), ct, wk)
+	if err != nil {
+		return "", fmt.Errorf("get field version: %w", err)
+	}
+	return value, nil
+}
+
+// RollbackField restores the value of an earlier version. The rollback is
+// itself recorded as a new version attributed to author, so it can be undone
+// the same way. Returns the new version number.
+func (s *SQLStore) RollbackField(vaultID, item, section, field string, version int, author string) (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	var ct, wk []byte
+	var keyID string
+	err = tx.QueryRow(
+		`SELECT ciphertext, wrapped_key, key_id FROM vault_item_versions
+		 WHERE vault_id = ? AND item = ? AND section = ? AND field_name = ? AND version = ?`,
+		vaultID, item, section, field, version,
+	).Scan(&ct, &wk, &keyID)
+	if err == sql.ErrNoRows {
+		return 0, fmt.Errorf("%w: %s@%d", ErrVersionNotFound, fieldPath(vaultID, item, section, field), version)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("get field version: %w", err)
+	}
+	value, err := s.keys.open(keyID, fieldAAD(vaultID, item, section, field), ct, wk)
+	if err != nil {
+		return 0, fmt.Errorf("open field version: %w", err)
+	}
+
+	newVersion, err := s.upsertField(tx, vaultID, item, section, field, value, author)
+	if err != nil {
+		return 0, fmt.Errorf("rollback field: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("commit tx: %w", err)
+	}
+	return newVersion, nil
+}
+
+// SetVersionRetention sets how many versions are kept per field in a vault
+// (0 keeps all) and prunes existing history accordingly. Returns true if the
+// vault exists.
+func (s *SQLStore) SetVersionRetention(vaultID string, keep int) (bool, error) {
+	if keep < 0 {
+		return false, fmt.Errorf("version retention must not be negative, got %d", keep)
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return false, fmt.Errorf("begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`UPDATE vaults SET version_retention = ? WHERE id = ? AND deleted_at IS NULL`, keep, vaultID)
+	if err != nil {
+		return false, fmt.Errorf("update version retention: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return false, nil
+	}
+
+	if keep > 0 {
+		if _, err := tx.Exec(
+			`DELETE FROM vault_item_versions
+			 WHERE vault_id = ? AND version <= (
+			   SELECT MAX(v.version) FROM vault_item_versions v
+			   WHERE v.vault_id = vault_item_versions.vault_id
+			     AND v.item = vault_item_versions.item
+			     AND v.section = vault_item_versions.section
+			     AND v.field_name = vault_item_versions.field_name
+			 ) - ?`,
+			vaultID, keep,
+		); err != nil {
+			return false, fmt.Errorf("prune versions: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("commit tx: %w", err)
+	}
+	return true, nil
+}
@@ -0,0 +1,96 @@
+package db
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestFieldVersions(t *testing.T) {
+	s := newTestStore(t)
+	s.CreateVault(&Vault{ID: "v1", Name: "V1"})
+
+	for _, v := range []string{"one", "two", "three"} {
+		if err := s.MergeItemFields("v1", "alice", "", map[string]string{"token": v}, nil, "alice-admin"); err != nil {
+			t.Fatalf("MergeItemFields: %v", err)
+		}
+	}
+
+	versions, err := s.ListFieldVersions("v1", "alice", "", "token")
+	if err != nil {
+		t.Fatalf("ListFieldVersions: %v", err)
+	}
+	if len(versions) != 3 {
+		t.Fatalf("expected 3 versions, got %d", len(versions))
+	}
+	if versions[0].Version != 3 || !versions[0].Current || versions[2].Current {
+		t.Errorf("expected newest-first with v3 current, got %+v", versions)
+	}
+	if versions[0].Author != "alice-admin" {
+		t.Errorf("author = %q, want alice-admin", versions[0].Author)
+	}
+
+	newVersion, err := s.RollbackField("v1", "alice", "", "token", 1, "bob-admin")
+	if err != nil {
+		t.Fatalf("RollbackField: %v", err)
+	}
+	if newVersion != 4 {
+		t.Errorf("rollback version = %d, want 4", newVersion)
+	}
+	val, _ := s.GetFieldValue("v1", "alice", "", "token")
+	if val != "one" {
+		t.Errorf("value after rollback = %q, want one", val)
+	}
+
+	if _, err := s.RollbackField("v1", "alice", "", "token", 99, "bob-admin"); !errors.Is(err, ErrVersionNotFound) {
+		t.Errorf("expected ErrVersionNotFound, got %v", err)
+	}
+}
+
+func TestFieldVersions_Retention(t *testing.T) {
+	s := newTestStore(t)
+	s.CreateVault(&Vault{ID: "v1", Name: "V1", VersionRetention: 2})
+
+	for _, v := range []string{"a", "b", "c", "d"} {
+		s.UpsertField("v1", "alice", "", "token", v)
+	}
+	versions, _ := s.ListFieldVersions("v1", "alice", "", "token")
+	if len(versions) != 2 || versions[0].Version != 4 || versions[1].Version != 3 {
+		t.Fatalf("expected versions [4 3], got %+v", versions)
+	}
+	if _, err := s.RollbackField("v1", "alice", "", "token", 1, ""); !errors.Is(err, ErrVersionNotFound) {
+		t.Errorf("expected pruned version to be gone, got %v", err)
+	}
+
+	ok, err := s.SetVersionRetention("v1", 1)
+	if err != nil || !ok {
+		t.Fatalf("SetVersionRetention = %v, %v", ok, err)
+	}
+	versions, _ = s.ListFieldVersions("v1", "alice", "", "token")
+	if len(versions) != 1 || versions[0].Version != 4 {
+		t.Fatalf("expected versions [4], got %+v", versions)
+	}
+	v, _ := s.GetVault("v1")
+	if v.VersionRetention != 1 {
+		t.Errorf("VersionRetention = %d, want 1", v.VersionRetention)
+	}
+}
+
+func TestFieldVersions_DeletedWithField(t *testing.T) {
+	s := newTestStore(t)
+	s.CreateVault(&Vault{ID: "v1", Name: "V1"})
+	s.UpsertField("v1", "alice", "", "token", "a")
+	s.UpsertField("v1", "alice", "", "token", "b")
+
+	if _, err := s.DeleteField("v1", "alice", "", "token"); err != nil {
+		t.Fatalf("DeleteField: %v", err)
+	}
+	versions, _ := s.ListFieldVersions("v1", "alice", "", "token")
+	if len(versions) != 0 {
+		t.Fatalf("expected history to be deleted with the field, got %+v", versions)
+	}
+
+	// The vault has no dependents left, so a plain delete succeeds.
+	if ok, err := s.DeleteVault("v1"); err != nil || !ok {
+		t.Fatalf("DeleteVault = %v, %v", ok, err)
+	}
+}
@@ -0,0 +1,210 @@
+package eventsink
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aspect-build/jingui/internal/logx"
+)
+
+// DefaultBuffer is how many events each sink buffers when
+// JINGUI_EVENT_BUFFER is not set.
+const DefaultBuffer = 1000
+
+const (
+	// maxBatch caps how many buffered events are handed to Sink.Write at once.
+	maxBatch = 100
+	// publishWait is how long Publish waits for room in a full buffer before
+	// dropping the event.
+	publishWait = 100 * time.Millisecond
+)
+
+// Retry backoff bounds; variables so tests can shorten them.
+var (
+	retryMin = time.Second
+	retryMax = time.Minute
+)
+
+// Dispatcher fans events out to sinks. A nil *Dispatcher discards events, so
+// servers without sinks need no special casing.
+type Dispatcher struct {
+	mu     sync.RWMutex
+	closed bool
+	queues []*queue
+}
+
+type queue struct {
+	sink    Sink
+	events  chan Event
+	dropped atomic.Int64
+	stop    chan struct{} // closed to give up retrying on shutdown
+	done    chan struct{} // closed when run returns
+}
+
+// New opens a sink for each URL (see Open) and starts a dispatcher buffering
+// up to buffer events per sink. It returns nil when urls is empty.
+func New(urls []string, buffer int) (*Dispatcher, error) {
+	if len(urls) == 0 {
+		return nil, nil
+	}
+	var sinks []Sink
+	for _, raw := range urls {
+		sink, err := Open(raw)
+		if err != nil {
+			for _, s := range sinks {
+				s.Close()
+			}
+			return nil, err
+		}
+		sinks = append(sinks, sink)
+	}
+	return NewDispatcher(sinks, buffer), nil
+}
+
+// NewDispatcher starts delivering to sinks, buffering up to buffer events per
+// sink.
+func NewDispatcher(sinks []Sink, buffer int) *Dispatcher {
+	if buffer <= 0 {
+		buffer = DefaultBuffer
+	}
+	d := &Dispatcher{}
+	for _, sink := range sinks {
+		q := &queue{
+			sink:   sink,
+			events: make(chan Event, buffer),
+			stop:   make(chan struct{}),
+			done:   make(chan struct{}),
+		}
+		d.queues = append(d.queues, q)
+		go q.run()
+	}
+	return d
+}
+
+// Sinks returns the names of the sinks events are delivered to.
+func (d *Dispatcher) Sinks() []string {
+	if d == nil {
+		return nil
+	}
+	names := make([]string, len(d.queues))
+	for i, q := range d.queues {
+		names[i] = q.sink.Name()
+	}
+	return names
+}
+
+// Publish queues ev for every sink. When a sink's buffer is full it waits up
+// to publishWait, slowing the caller down, and then drops the event for that
+// sink.
+func (d *Dispatcher) Publish(ev Event) {
+	if d == nil {
+		return
+	}
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.closed {
+		return
+	}
+	for _, q := range d.queues {
+		select {
+		case q.events <- ev:
+			continue
+		default:
+		}
+		timer := time.NewTimer(publishWait)
+		select {
+		case q.events <- ev:
+		case <-timer.C:
+			if q.dropped.Add(1) == 1 {
+				logx.Warnf("event sink %s: buffer full, dropping events", q.sink.Name())
+			}
+		}
+		timer.Stop()
+	}
+}
+
+// Close stops accepting events and delivers the buffered ones until ctx is
+// done; whatever is left then is dropped. It closes every sink.
+func (d *Dispatcher) Close(ctx context.Context) error {
+	if d == nil {
+		return nil
+	}
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		return nil
+	}
+	d.closed = true
+	for _, q := range d.queues {
+		close(q.events)
+	}
+	d.mu.Unlock()
+
+	var errs []error
+	for _, q := range d.queues {
+		select {
+		case <-q.done:
+		case <-ctx.Done():
+			close(q.stop)
+			<-q.done
+		}
+		if err := q.sink.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (q *queue) run() {
+	defer close(q.done)
+	batch := make([]Event, 0, maxBatch+1)
+	for ev := range q.events {
+		batch = append(batch[:0], ev)
+	fill:
+		for len(batch) < maxBatch {
+			select {
+			case ev, ok := <-q.events:
+				if !ok {
+					break fill
+				}
+				batch = append(batch, ev)
+			default:
+				break fill
+			}
+		}
+		if n := q.dropped.Swap(0); n > 0 {
+			logx.Warnf("event sink %s: dropped %d events while its buffer was full", q.sink.Name(), n)
+			batch = append(batch, droppedEvent(q.sink.Name(), n))
+		}
+		q.deliver(batch)
+	}
+}
+
+// deliver writes batch, retrying with exponential backoff until it succeeds,
+// the sink rejects it or the dispatcher gives up on shutdown.
+func (q *queue) deliver(batch []Event) {
+	backoff := retryMin
+	for {
+		err := q.sink.Write(batch)
+		if err == nil {
+			return
+		}
+		if errors.Is(err, ErrRejected) {
+			logx.Errorf("event sink %s: dropping %d events: %v", q.sink.Name(), len(batch), err)
+			return
+		}
+		logx.Warnf("event sink %s: %v; retrying in %s", q.sink.Name(), err, backoff)
+		select {
+		case <-q.stop:
+			logx.Errorf("event sink %s: dropping %d undelivered events on shutdown", q.sink.Name(), len(batch)+len(q.events))
+			for range q.events {
+			}
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, retryMax)
+	}
+}
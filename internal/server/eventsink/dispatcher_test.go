@@ -0,0 +1,176 @@
+package eventsink
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aspect-build/jingui/internal/server/db"
+)
+
+// memSink records delivered events. Writes fail while fail is positive,
+// counting it down, and block while gate is non-nil and open.
+type memSink struct {
+	mu     sync.Mutex
+	events []Event
+	writes int
+	fail   int
+	err    error
+	gate   chan struct{}
+}
+
+func (s *memSink) Name() string { return "mem" }
+
+func (s *memSink) Write(events []Event) error {
+	if s.gate != nil {
+		<-s.gate
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.writes++
+	if s.fail > 0 {
+		s.fail--
+		return s.err
+	}
+	s.events = append(s.events, events...)
+	return nil
+}
+
+func (s *memSink) Close() error { return nil }
+
+func (s *memSink) actions() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []string
+	for _, ev := range s.events {
+		out = append(out, ev.Action)
+	}
+	return out
+}
+
+func shortRetry(t *testing.T) {
+	t.Helper()
+	oldMin, oldMax := retryMin, retryMax
+	retryMin, retryMax = time.Millisecond, 4*time.Millisecond
+	t.Cleanup(func() { retryMin, retryMax = oldMin, oldMax })
+}
+
+func closeDispatcher(t *testing.T, d *Dispatcher) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := d.Close(ctx); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+}
+
+func auditEvent(action string) Event {
+	return FromAudit(db.AuditEvent{Action: action, Outcome: db.AuditSuccess})
+}
+
+func TestDispatcher_DeliversInOrderAndRetries(t *testing.T) {
+	shortRetry(t)
+	sink := &memSink{fail: 2, err: errors.New("collector down")}
+	d := NewDispatcher([]Sink{sink}, 10)
+	for i := 0; i < 5; i++ {
+		d.Publish(auditEvent(fmt.Sprintf("a%d", i)))
+	}
+	closeDispatcher(t, d)
+
+	if got := fmt.Sprint(sink.actions()); got != "[a0 a1 a2 a3 a4]" {
+		t.Errorf("delivered %s, want a0..a4 in order", got)
+	}
+	if sink.writes < 3 {
+		t.Errorf("writes = %d, want the failed batch retried", sink.writes)
+	}
+	d.Publish(auditEvent("late")) // after Close: ignored, must not panic
+}
+
+func TestDispatcher_RejectedBatchIsDropped(t *testing.T) {
+	shortRetry(t)
+	sink := &memSink{fail: 1, err: fmt.Errorf("%w: 400 Bad Request", ErrRejected)}
+	d := NewDispatcher([]Sink{sink}, 10)
+	d.Publish(auditEvent("bad"))
+	closeDispatcher(t, d)
+	d2 := NewDispatcher([]Sink{sink}, 10)
+	d2.Publish(auditEvent("good"))
+	closeDispatcher(t, d2)
+
+	if got := fmt.Sprint(sink.actions()); got != "[good]" || sink.writes != 2 {
+		t.Errorf("delivered %s in %d writes, want the rejected batch dropped without retry", got, sink.writes)
+	}
+}
+
+func TestDispatcher_FullBufferDropsAndReports(t *testing.T) {
+	sink := &memSink{gate: make(chan struct{})}
+	d := NewDispatcher([]Sink{sink}, 2)
+
+	// The first event is taken by the blocked delivery goroutine; the next
+	// two fill the buffer and the rest are dropped after publishWait.
+	d.Publish(auditEvent("a0"))
+	time.Sleep(20 * time.Millisecond)
+	start := time.Now()
+	for i := 1; i < 5; i++ {
+		d.Publish(auditEvent(fmt.Sprintf("a%d", i)))
+	}
+	if waited := time.Since(start); waited < 2*publishWait {
+		t.Errorf("Publish returned after %s, want it to wait for room", waited)
+	}
+	close(sink.gate)
+	closeDispatcher(t, d)
+
+	got := sink.actions()
+	if fmt.Sprint(got) != "[a0 a1 a2 events.dropped]" {
+		t.Fatalf("delivered %v, want a0..a2 then a drop report", got)
+	}
+	if dropped := sink.events[3]; dropped.Kind != KindSystem || dropped.Details["count"] != "2" {
+		t.Errorf("drop report = %+v, want 2 dropped", dropped)
+	}
+}
+
+func TestDispatcher_CloseGivesUpOnDeadline(t *testing.T) {
+	shortRetry(t)
+	sink := &memSink{fail: 1 << 30, err: errors.New("collector down")}
+	d := NewDispatcher([]Sink{sink}, 10)
+	d.Publish(auditEvent("a0"))
+	d.Publish(auditEvent("a1"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := d.Close(ctx); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if waited := time.Since(start); waited > 2*time.Second {
+		t.Errorf("Close took %s, want it to stop at the deadline", waited)
+	}
+}
+
+func TestNilDispatcher(t *testing.T) {
+	var d *Dispatcher
+	d.Publish(auditEvent("a0"))
+	if d.Sinks() != nil || d.Close(context.Background()) != nil {
+		t.Error("nil dispatcher should do nothing")
+	}
+	if d, err := New(nil, 0); d != nil || err != nil {
+		t.Errorf("New(nil) = %v, %v; want nil, nil", d, err)
+	}
+}
+
+func TestOpen_Invalid(t *testing.T) {
+	for _, raw := range []string{
+		"kafka://broker:9092",
+		"syslog+udp:///no-host",
+		"syslog+tcp://collector?facility=mail",
+		"file://",
+		"file:///tmp/events.jsonl?max_bytes=0",
+		"https:///path",
+	} {
+		if _, err := Open(raw); err == nil {
+			t.Errorf("Open(%q) succeeded, want an error", raw)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+// Package eventsink forwards audit and security events to external
+// collectors: RFC 5424 syslog over UDP or TCP, rotating JSONL files and HTTP
+// endpoints.
+//
+// Each sink has its own bounded buffer and delivery goroutine, so a slow or
+// unreachable sink does not hold up the others. Failed batches are retried
+// with exponential backoff while new events queue behind them. When a buffer
+// is full, Publish waits briefly and then drops the event; the sink receives
+// an events.dropped event with the count once it catches up. Delivery is at
+// least once: a batch that failed part way is sent again in full.
+package eventsink
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aspect-build/jingui/internal/server/db"
+)
+
+// Event kinds.
+const (
+	// KindAudit events are audit log entries, with their ID and chain hash.
+	KindAudit = "audit"
+	// KindSecurity events are refused security checks that are not audit
+	// log entries of their own, such as RA-TLS verification failures.
+	KindSecurity = "security"
+	// KindSystem events report on the event pipeline itself.
+	KindSystem = "system"
+)
+
+// Event is one record forwarded to sinks. It carries the fields of an audit
+// event; ID, PrevHash and Hash are only set for KindAudit.
+type Event struct {
+	Kind string `json:"kind"`
+	db.AuditEvent
+	// Details holds extra context, e.g. the reason an attestation was
+	// refused.
+	Details map[string]string `json:"details,omitempty"`
+}
+
+// FromAudit wraps an audit log entry as an event.
+func FromAudit(ev db.AuditEvent) Event {
+	return Event{Kind: KindAudit, AuditEvent: ev}
+}
+
+// ErrRejected is wrapped by Sink.Write errors that retrying cannot fix, such
+// as an HTTP collector refusing the request; the batch is dropped.
+var ErrRejected = errors.New("events rejected")
+
+// Sink delivers batches of events to one destination.
+type Sink interface {
+	// Name identifies the sink in logs, without credentials.
+	Name() string
+	// Write delivers events in order. It is only called from the sink's
+	// delivery goroutine.
+	Write(events []Event) error
+	Close() error
+}
+
+// Open creates the sink described by rawURL:
+//
+//	syslog+udp://host[:port]?facility=local0   RFC 5424 over UDP (default port 514)
+//	syslog+tcp://host[:port]?facility=local0   RFC 5424 over TCP, octet-counted (default port 514)
+//	file:///path/events.jsonl?max_bytes=N&keep=N  JSONL file rotated at max_bytes
+//	http(s)://host/path                         NDJSON batches POSTed to the URL
+func Open(rawURL string) (Sink, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("event sink %q: %w", rawURL, err)
+	}
+	var sink Sink
+	switch strings.ToLower(u.Scheme) {
+	case "syslog+udp", "syslog+tcp":
+		sink, err = newSyslogSink(u)
+	case "file":
+		sink, err = newFileSink(u)
+	case "http", "https":
+		sink, err = newHTTPSink(u)
+	default:
+		err = fmt.Errorf("unsupported scheme %q (expected syslog+udp, syslog+tcp, file, http or https)", u.Scheme)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("event sink %s: %w", u.Redacted(), err)
+	}
+	return sink, nil
+}
+
+// severity is the syslog severity of an event.
+func (ev *Event) severity() int {
+	switch ev.Outcome {
+	case db.AuditSuccess:
+		return 6 // informational
+	case db.AuditDenied:
+		return 4 // warning
+	}
+	return 3 // error
+}
+
+// marshalLine encodes ev as one JSON line.
+func marshalLine(ev *Event) ([]byte, error) {
+	b, err := json.Marshal(ev)
+	if err != nil {
+		return nil, fmt.Errorf("encode event: %w", err)
+	}
+	return append(b, '\n'), nil
+}
+
+// droppedEvent reports n events a sink lost while its buffer was full.
+func droppedEvent(sink string, n int64) Event {
+	return Event{
+		Kind: KindSystem,
+		AuditEvent: db.AuditEvent{
+			Time:    time.Now().UTC(),
+			Action:  "events.dropped",
+			Outcome: db.AuditError,
+			Error:   fmt.Sprintf("%d events dropped while the buffer was full", n),
+		},
+		Details: map[string]string{"sink": sink, "count": fmt.Sprint(n)},
+	}
+}
@@ -0,0 +1,131 @@
+package eventsink
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+)
+
+const (
+	defaultFileMaxBytes = 100 << 20
+	defaultFileKeep     = 5
+)
+
+// fileSink appends events as JSON lines. When the file would grow past
+// maxBytes it is renamed to path.1, older files shift up to path.<keep>, and
+// a new file is started.
+type fileSink struct {
+	name     string
+	path     string
+	maxBytes int64
+	keep     int
+	f        *os.File
+	size     int64
+}
+
+func newFileSink(u *url.URL) (*fileSink, error) {
+	path := u.Path
+	if path == "" {
+		path = u.Opaque // file:events.jsonl
+	}
+	if path == "" {
+		return nil, fmt.Errorf("missing path")
+	}
+	s := &fileSink{name: "file:" + path, path: path, maxBytes: defaultFileMaxBytes, keep: defaultFileKeep}
+	q := u.Query()
+	if v := q.Get("max_bytes"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("max_bytes must be a positive number of bytes")
+		}
+		s.maxBytes = n
+	}
+	if v := q.Get("keep"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("keep must be a non-negative number of files")
+		}
+		s.keep = n
+	}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *fileSink) open() error {
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	s.f, s.size = f, info.Size()
+	return nil
+}
+
+func (s *fileSink) Name() string { return s.name }
+
+func (s *fileSink) Write(events []Event) error {
+	if s.f == nil {
+		if err := s.open(); err != nil {
+			return err
+		}
+	}
+	for i := range events {
+		line, err := marshalLine(&events[i])
+		if err != nil {
+			return err
+		}
+		if s.size > 0 && s.size+int64(len(line)) > s.maxBytes {
+			if err := s.rotate(); err != nil {
+				return err
+			}
+		}
+		n, err := s.f.Write(line)
+		s.size += int64(n)
+		if err != nil {
+			return fmt.Errorf("write %s: %w", s.path, err)
+		}
+	}
+	return nil
+}
+
+// rotate shifts path.N to path.N+1, drops the oldest file beyond keep and
+// starts a new file.
+func (s *fileSink) rotate() error {
+	if err := s.f.Close(); err != nil {
+		return fmt.Errorf("close %s: %w", s.path, err)
+	}
+	s.f = nil
+	if s.keep == 0 {
+		if err := os.Remove(s.path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("rotate %s: %w", s.path, err)
+		}
+	} else {
+		os.Remove(fmt.Sprintf("%s.%d", s.path, s.keep))
+		for i := s.keep - 1; i >= 1; i-- {
+			old := fmt.Sprintf("%s.%d", s.path, i)
+			if err := os.Rename(old, fmt.Sprintf("%s.%d", s.path, i+1)); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("rotate %s: %w", old, err)
+			}
+		}
+		if err := os.Rename(s.path, s.path+".1"); err != nil {
+			return fmt.Errorf("rotate %s: %w", s.path, err)
+		}
+	}
+	return s.open()
+}
+
+func (s *fileSink) Close() error {
+	if s.f == nil {
+		return nil
+	}
+	err := s.f.Close()
+	s.f = nil
+	return err
+}
@@ -0,0 +1,79 @@
+package eventsink
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// readJSONL returns the actions of the events in a JSONL file.
+func readJSONL(t *testing.T, path string) []string {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("open %s: %v", path, err)
+	}
+	defer f.Close()
+	var actions []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var ev Event
+		if err := json.Unmarshal(sc.Bytes(), &ev); err != nil {
+			t.Fatalf("%s: line %q: %v", path, sc.Text(), err)
+		}
+		actions = append(actions, ev.Action)
+	}
+	return actions
+}
+
+func TestFileSink_Rotates(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "events.jsonl")
+	// Room for two events per file; the action names are of equal length.
+	first := auditEvent("a0")
+	line, _ := marshalLine(&first)
+	maxBytes := 2 * len(line)
+
+	sink, err := Open(fmt.Sprintf("file://%s?max_bytes=%d&keep=2", path, maxBytes))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	for i := 0; i < 7; i++ {
+		if err := sink.Write([]Event{auditEvent(fmt.Sprintf("a%d", i))}); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	for file, want := range map[string]string{
+		path:        "[a6]",
+		path + ".1": "[a4 a5]",
+		path + ".2": "[a2 a3]",
+	} {
+		if got := fmt.Sprint(readJSONL(t, file)); got != want {
+			t.Errorf("%s = %s, want %s", filepath.Base(file), got, want)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("events.jsonl.3 should not exist beyond keep=2: %v", err)
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != 0o600 {
+		t.Errorf("file mode = %v, want 0600", info.Mode().Perm())
+	}
+
+	// Reopening appends to the current file.
+	sink, err = Open("file://" + path)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	sink.Write([]Event{auditEvent("a7")})
+	sink.Close()
+	if got := fmt.Sprint(readJSONL(t, path)); got != "[a6 a7]" {
+		t.Errorf("after reopening = %s, want [a6 a7]", got)
+	}
+}
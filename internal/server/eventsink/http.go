@@ -0,0 +1,66 @@
+package eventsink
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// httpTimeout bounds each POST to an HTTP collector.
+const httpTimeout = 10 * time.Second
+
+// httpSink POSTs each batch as newline-delimited JSON. Credentials in the
+// URL are sent as HTTP basic auth. 4xx responses other than 408 and 429 are
+// treated as rejections and not retried.
+type httpSink struct {
+	url    string
+	name   string
+	client *http.Client
+}
+
+func newHTTPSink(u *url.URL) (*httpSink, error) {
+	if u.Host == "" {
+		return nil, fmt.Errorf("missing host")
+	}
+	return &httpSink{url: u.String(), name: u.Redacted(), client: &http.Client{Timeout: httpTimeout}}, nil
+}
+
+func (s *httpSink) Name() string { return s.name }
+
+func (s *httpSink) Write(events []Event) error {
+	var body bytes.Buffer
+	for i := range events {
+		line, err := marshalLine(&events[i])
+		if err != nil {
+			return err
+		}
+		body.Write(line)
+	}
+	req, err := http.NewRequest(http.MethodPost, s.url, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("post: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+
+	switch {
+	case resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode < 500 && resp.StatusCode != http.StatusRequestTimeout && resp.StatusCode != http.StatusTooManyRequests:
+		return fmt.Errorf("%w: collector returned %s", ErrRejected, resp.Status)
+	}
+	return fmt.Errorf("collector returned %s", resp.Status)
+}
+
+func (s *httpSink) Close() error {
+	s.client.CloseIdleConnections()
+	return nil
+}
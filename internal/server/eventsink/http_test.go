@@ -0,0 +1,64 @@
+package eventsink
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHTTPSink(t *testing.T) {
+	var (
+		status = http.StatusOK
+		got    []string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "siem" || pass != "s3cret" {
+			t.Errorf("basic auth = %q, %q, %v", user, pass, ok)
+		}
+		if ct := r.Header.Get("Content-Type"); ct != "application/x-ndjson" {
+			t.Errorf("Content-Type = %q", ct)
+		}
+		sc := bufio.NewScanner(r.Body)
+		for sc.Scan() {
+			var ev Event
+			if err := json.Unmarshal(sc.Bytes(), &ev); err != nil {
+				t.Errorf("line %q: %v", sc.Text(), err)
+			}
+			got = append(got, ev.Action)
+		}
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	sink, err := Open(strings.Replace(srv.URL, "http://", "http://siem:s3cret@", 1) + "/ingest")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer sink.Close()
+	if strings.Contains(sink.Name(), "s3cret") {
+		t.Errorf("Name() = %q leaks the password", sink.Name())
+	}
+
+	if err := sink.Write([]Event{auditEvent("a0"), auditEvent("a1")}); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if strings.Join(got, ",") != "a0,a1" {
+		t.Errorf("collector received %v", got)
+	}
+
+	for code, rejected := range map[int]bool{
+		http.StatusBadRequest:         true,
+		http.StatusTooManyRequests:    false,
+		http.StatusServiceUnavailable: false,
+	} {
+		status = code
+		err := sink.Write([]Event{auditEvent("a2")})
+		if err == nil || errors.Is(err, ErrRejected) != rejected {
+			t.Errorf("status %d: Write = %v, want rejected=%v", code, err, rejected)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package eventsink
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	syslogAppName = "jingui-server"
+	// syslogTimeout bounds dialing the collector and each write.
+	syslogTimeout = 10 * time.Second
+)
+
+// syslogFacilities maps facility names accepted in ?facility= to codes.
+var syslogFacilities = map[string]int{
+	"user": 1, "daemon": 3, "auth": 4, "authpriv": 10,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// syslogSink sends RFC 5424 messages whose MSG is the event as JSON. Over
+// TCP, messages are framed by octet counting (RFC 6587).
+type syslogSink struct {
+	name     string
+	network  string
+	addr     string
+	facility int
+	hostname string
+	procID   string
+	conn     net.Conn
+}
+
+func newSyslogSink(u *url.URL) (*syslogSink, error) {
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("missing host")
+	}
+	port := u.Port()
+	if port == "" {
+		port = "514"
+	}
+	facility := syslogFacilities["local0"]
+	if v := u.Query().Get("facility"); v != "" {
+		f, ok := syslogFacilities[strings.ToLower(v)]
+		if !ok {
+			return nil, fmt.Errorf("unknown syslog facility %q", v)
+		}
+		facility = f
+	}
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "-"
+	}
+	return &syslogSink{
+		name:     u.Redacted(),
+		network:  strings.TrimPrefix(strings.ToLower(u.Scheme), "syslog+"),
+		addr:     net.JoinHostPort(u.Hostname(), port),
+		facility: facility,
+		hostname: hostname,
+		procID:   strconv.Itoa(os.Getpid()),
+	}, nil
+}
+
+func (s *syslogSink) Name() string { return s.name }
+
+func (s *syslogSink) Write(events []Event) error {
+	if s.conn == nil {
+		conn, err := net.DialTimeout(s.network, s.addr, syslogTimeout)
+		if err != nil {
+			return fmt.Errorf("dial: %w", err)
+		}
+		s.conn = conn
+	}
+	for i := range events {
+		msg, err := s.format(&events[i])
+		if err != nil {
+			return err
+		}
+		if s.network == "tcp" {
+			msg = append([]byte(strconv.Itoa(len(msg))+" "), msg...)
+		}
+		s.conn.SetWriteDeadline(time.Now().Add(syslogTimeout))
+		if _, err := s.conn.Write(msg); err != nil {
+			s.conn.Close()
+			s.conn = nil
+			return fmt.Errorf("write: %w", err)
+		}
+	}
+	return nil
+}
+
+// format renders ev as an RFC 5424 message:
+//
+//	<PRI>1 TIMESTAMP HOSTNAME APP-NAME PROCID MSGID - MSG
+func (s *syslogSink) format(ev *Event) ([]byte, error) {
+	line, err := marshalLine(ev)
+	if err != nil {
+		return nil, err
+	}
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "<%d>1 %s %s %s %s %s - ",
+		s.facility*8+ev.severity(),
+		ev.Time.UTC().Format("2006-01-02T15:04:05.000000Z07:00"),
+		s.hostname, syslogAppName, s.procID, syslogMsgID(ev.Action))
+	b.Write(bytes.TrimSuffix(line, []byte("\n")))
+	return b.Bytes(), nil
+}
+
+// syslogMsgID returns action as a MSGID: at most 32 printable ASCII
+// characters, or "-".
+func syslogMsgID(action string) string {
+	id := strings.Map(func(r rune) rune {
+		if r < '!' || r > '~' {
+			return '_'
+		}
+		return r
+	}, action)
+	if len(id) > 32 {
+		id = id[:32]
+	}
+	if id == "" {
+		return "-"
+	}
+	return id
+}
+
+func (s *syslogSink) Close() error {
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
@@ -0,0 +1,129 @@
+package eventsink
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aspect-build/jingui/internal/server/db"
+)
+
+var syslogTestEvent = Event{
+	Kind: KindSecurity,
+	AuditEvent: db.AuditEvent{
+		Time:    time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC),
+		Action:  "ratls.challenge",
+		Outcome: db.AuditDenied,
+		FID:     "fid1",
+		Error:   "verify failed",
+	},
+	Details: map[string]string{"err": "bad quote"},
+}
+
+// checkSyslogMessage checks the RFC 5424 header and JSON body of msg.
+func checkSyslogMessage(t *testing.T, msg, pri string) {
+	t.Helper()
+	parts := strings.SplitN(msg, " ", 8)
+	if len(parts) != 8 {
+		t.Fatalf("message %q does not have 8 parts", msg)
+	}
+	if parts[0] != pri+"1" || parts[1] != "2030-01-02T03:04:05.000000Z" || parts[3] != syslogAppName ||
+		parts[5] != "ratls.challenge" || parts[6] != "-" {
+		t.Errorf("header = %q", parts[:7])
+	}
+	var ev Event
+	if err := json.Unmarshal([]byte(parts[7]), &ev); err != nil {
+		t.Fatalf("MSG is not JSON: %v", err)
+	}
+	if ev.Kind != KindSecurity || ev.FID != "fid1" || ev.Details["err"] != "bad quote" {
+		t.Errorf("MSG = %+v", ev)
+	}
+}
+
+func TestSyslogSink_UDP(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer pc.Close()
+
+	sink, err := Open("syslog+udp://" + pc.LocalAddr().String() + "?facility=auth")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer sink.Close()
+	if err := sink.Write([]Event{syslogTestEvent}); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	buf := make([]byte, 64<<10)
+	pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	checkSyslogMessage(t, string(buf[:n]), "<36>") // auth (4) * 8 + warning (4)
+}
+
+func TestSyslogSink_TCP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+
+	sink, err := Open("syslog+tcp://" + ln.Addr().String())
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer sink.Close()
+	success := syslogTestEvent
+	success.Outcome = db.AuditSuccess
+	go sink.Write([]Event{syslogTestEvent, success})
+
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatalf("accept: %v", err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	r := bufio.NewReader(conn)
+	for _, pri := range []string{"<132>", "<134>"} { // local0 (16) * 8 + warning (4) / informational (6)
+		size, err := r.ReadString(' ')
+		if err != nil {
+			t.Fatalf("read frame length: %v", err)
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(size))
+		if err != nil {
+			t.Fatalf("frame length %q: %v", size, err)
+		}
+		msg := make([]byte, n)
+		if _, err := io.ReadFull(r, msg); err != nil {
+			t.Fatalf("read frame: %v", err)
+		}
+		checkSyslogMessage(t, string(msg), pri)
+	}
+}
+
+func TestSyslogSink_DialFailureIsRetryable(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	sink, err := Open("syslog+tcp://" + addr)
+	if err != nil {
+		t.Fatalf("Open should not dial: %v", err)
+	}
+	if err := sink.Write([]Event{syslogTestEvent}); err == nil || errors.Is(err, ErrRejected) {
+		t.Errorf("Write to a closed port = %v, want a retryable error", err)
+	}
+}
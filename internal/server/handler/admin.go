@@ -2,13 +2,27 @@ package handler
 
 import (
 	"encoding/hex"
+	"errors"
+	"io"
 	"log"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/aspect-build/jingui/internal/server/db"
 	"github.com/gin-gonic/gin"
 )
 
+// adminActor returns the operator name sent in the X-Jingui-Actor header, used
+// to attribute changes. The admin token is shared, so this is informational
+// rather than authenticated.
+func adminActor(c *gin.Context) string {
+	if actor := strings.TrimSpace(c.GetHeader("X-Jingui-Actor")); actor != "" {
+		return actor
+	}
+	return "admin"
+}
+
 // instanceView serializes TEE instances with hex-encoded public keys.
 type instanceView struct {
 	FID         string  `json:"fid"`
@@ -17,27 +31,39 @@ type instanceView struct {
 	Label       string  `json:"label"`
 	CreatedAt   string  `json:"created_at"`
 	LastUsedAt  *string `json:"last_used_at"`
+	// Status is "active", "disabled" or "quarantined".
+	Status          string  `json:"status"`
+	StatusReason    string  `json:"status_reason,omitempty"`
+	StatusChangedAt *string `json:"status_changed_at,omitempty"`
+	// PreviousFIDs lists the FIDs of keys the instance re-keyed away from.
+	PreviousFIDs []string `json:"previous_fids,omitempty"`
 }
 
 func newInstanceView(inst *db.TEEInstance) instanceView {
 	v := instanceView{
-		FID:         inst.FID,
-		PublicKey:   hex.EncodeToString(inst.PublicKey),
-		DstackAppID: inst.DstackAppID,
-		Label:       inst.Label,
-		CreatedAt:   inst.CreatedAt.Format("2006-01-02T15:04:05Z"),
+		FID:          inst.FID,
+		PublicKey:    hex.EncodeToString(inst.PublicKey),
+		DstackAppID:  inst.DstackAppID,
+		Label:        inst.Label,
+		CreatedAt:    inst.CreatedAt.Format("2006-01-02T15:04:05Z"),
+		Status:       inst.Status,
+		StatusReason: inst.StatusReason,
 	}
 	if inst.LastUsedAt != nil {
 		s := inst.LastUsedAt.Format("2006-01-02T15:04:05Z")
 		v.LastUsedAt = &s
 	}
+	if inst.StatusChangedAt != nil {
+		s := inst.StatusChangedAt.UTC().Format("2006-01-02T15:04:05Z")
+		v.StatusChangedAt = &s
+	}
 	return v
 }
 
 // --- Instances ---
 
 // HandleListInstances handles GET /v1/instances.
-func HandleListInstances(store *db.Store) gin.HandlerFunc {
+func HandleListInstances(store db.Store) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		instances, err := store.ListInstances()
 		if err != nil {
@@ -53,11 +79,18 @@ func HandleListInstances(store *db.Store) gin.HandlerFunc {
 	}
 }
 
-// HandleGetInstance handles GET /v1/instances/:fid.
-func HandleGetInstance(store *db.Store) gin.HandlerFunc {
+// HandleGetInstance handles GET /v1/instances/:fid. The FID of a key the
+// instance re-keyed away from also finds it.
+func HandleGetInstance(store db.Store) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		fid := c.Param("fid")
 		inst, err := store.GetInstance(fid)
+		if err == nil && inst == nil {
+			var current string
+			if current, err = store.ResolveInstanceAlias(fid); err == nil && current != "" {
+				inst, err = store.GetInstance(current)
+			}
+		}
 		if err != nil {
 			log.Printf("GetInstance(%q) error: %v", fid, err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve instance"})
@@ -67,12 +100,23 @@ func HandleGetInstance(store *db.Store) gin.HandlerFunc {
 			c.JSON(http.StatusNotFound, gin.H{"error": "instance not found"})
 			return
 		}
-		c.JSON(http.StatusOK, newInstanceView(inst))
+		aliases, err := store.ListInstanceAliases(inst.FID)
+		if err != nil {
+			log.Printf("ListInstanceAliases(%q) error: %v", inst.FID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve instance"})
+			return
+		}
+		v := newInstanceView(inst)
+		for _, a := range aliases {
+			v.PreviousFIDs = append(v.PreviousFIDs, a.AliasFID)
+		}
+		c.JSON(http.StatusOK, v)
 	}
 }
 
-// HandleDeleteInstance handles DELETE /v1/instances/:fid.
-func HandleDeleteInstance(store *db.Store) gin.HandlerFunc {
+// HandleDeleteInstance handles DELETE /v1/instances/:fid — move the instance
+// to the trash.
+func HandleDeleteInstance(store db.Store) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		fid := c.Param("fid")
 		deleted, err := store.DeleteInstance(fid)
@@ -90,48 +134,58 @@ func HandleDeleteInstance(store *db.Store) gin.HandlerFunc {
 }
 
 // --- Vault Items ---
+//
+// Item routes address an item by path and one of its sections by the optional
+// ?section= query parameter; omitting it selects the item's default section.
 
-// HandleListItems handles GET /v1/vaults/:id/items — list distinct sections.
-func HandleListItems(store *db.Store) gin.HandlerFunc {
+// HandleListItems handles GET /v1/vaults/:id/items — list distinct items.
+func HandleListItems(store db.Store) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		vaultID := c.Param("id")
-		sections, err := store.ListSections(vaultID)
+		items, err := store.ListItems(vaultID)
 		if err != nil {
-			log.Printf("ListSections(%q) error: %v", vaultID, err)
+			log.Printf("ListItems(%q) error: %v", vaultID, err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list items"})
 			return
 		}
-		if sections == nil {
-			sections = []string{}
+		if items == nil {
+			items = []string{}
 		}
-		c.JSON(http.StatusOK, sections)
+		c.JSON(http.StatusOK, items)
 	}
 }
 
-// HandleGetItem handles GET /v1/vaults/:id/items/:section — all fields for section.
-func HandleGetItem(store *db.Store) gin.HandlerFunc {
+// HandleGetItem handles GET /v1/vaults/:id/items/:item — field keys of one
+// section plus the names of all sections in the item.
+func HandleGetItem(store db.Store) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		vaultID := c.Param("id")
-		section := c.Param("section")
-		items, err := store.GetItemFields(vaultID, section)
+		item := c.Param("item")
+		section := c.Query("section")
+		fields, err := store.GetItemFields(vaultID, item)
 		if err != nil {
-			log.Printf("GetItemFields(%q, %q) error: %v", vaultID, section, err)
+			log.Printf("GetItemFields(%q, %q) error: %v", vaultID, item, err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get item"})
 			return
 		}
-		if items == nil {
-			items = []db.VaultItem{}
-		}
 
-		keys := make([]string, len(items))
-		for i, item := range items {
-			keys[i] = item.ItemName
+		keys := []string{}
+		sections := []string{}
+		for _, f := range fields {
+			if f.Section == section {
+				keys = append(keys, f.FieldName)
+			}
+			if len(sections) == 0 || sections[len(sections)-1] != f.Section {
+				sections = append(sections, f.Section)
+			}
 		}
 
 		c.JSON(http.StatusOK, gin.H{
 			"vault_id": vaultID,
+			"item":     item,
 			"section":  section,
 			"keys":     keys,
+			"sections": sections,
 		})
 	}
 }
@@ -141,11 +195,13 @@ type putItemRequest struct {
 	Delete []string          `json:"delete"`
 }
 
-// HandlePutItem handles PUT /v1/vaults/:id/items/:section — merge upsert/delete fields.
-func HandlePutItem(store *db.Store) gin.HandlerFunc {
+// HandlePutItem handles PUT /v1/vaults/:id/items/:item — merge upsert/delete
+// fields in one section.
+func HandlePutItem(store db.Store) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		vaultID := c.Param("id")
-		section := c.Param("section")
+		item := c.Param("item")
+		section := c.Query("section")
 
 		var req putItemRequest
 		if err := c.ShouldBindJSON(&req); err != nil {
@@ -157,8 +213,12 @@ func HandlePutItem(store *db.Store) gin.HandlerFunc {
 			return
 		}
 
-		if err := store.MergeItemFields(vaultID, section, req.Fields, req.Delete); err != nil {
-			log.Printf("MergeItemFields(%q, %q) error: %v", vaultID, section, err)
+		if err := store.MergeItemFields(vaultID, item, section, req.Fields, req.Delete, adminActor(c)); err != nil {
+			if errors.Is(err, db.ErrInTrash) {
+				respondInTrash(c, err)
+				return
+			}
+			log.Printf("MergeItemFields(%q, %q, %q) error: %v", vaultID, item, section, err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save item"})
 			return
 		}
@@ -167,30 +227,133 @@ func HandlePutItem(store *db.Store) gin.HandlerFunc {
 	}
 }
 
-// HandleDeleteItem handles DELETE /v1/vaults/:id/items/:section.
-func HandleDeleteItem(store *db.Store) gin.HandlerFunc {
+// HandleDeleteItem handles DELETE /v1/vaults/:id/items/:item — move the item
+// to the trash. Without a ?section= query the whole item is deleted; with
+// one, only that section.
+func HandleDeleteItem(store db.Store) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		vaultID := c.Param("id")
-		section := c.Param("section")
+		item := c.Param("item")
 
-		deleted, err := store.DeleteSection(vaultID, section)
+		var (
+			deleted bool
+			err     error
+		)
+		section, hasSection := c.GetQuery("section")
+		if hasSection {
+			deleted, err = store.DeleteSection(vaultID, item, section)
+		} else {
+			deleted, err = store.DeleteItem(vaultID, item)
+		}
 		if err != nil {
-			log.Printf("DeleteSection(%q, %q) error: %v", vaultID, section, err)
+			log.Printf("DeleteItem(%q, %q, %q) error: %v", vaultID, item, section, err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete item"})
 			return
 		}
 		if !deleted {
-			c.JSON(http.StatusNotFound, gin.H{"error": "item not found"})
+			if hasSection {
+				c.JSON(http.StatusNotFound, gin.H{"error": "section not found"})
+			} else {
+				c.JSON(http.StatusNotFound, gin.H{"error": "item not found"})
+			}
 			return
 		}
 		c.JSON(http.StatusOK, gin.H{"status": "deleted"})
 	}
 }
 
+// HandleListFieldVersions handles GET /v1/vaults/:id/items/:item/:field/versions.
+func HandleListFieldVersions(store db.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		vaultID := c.Param("id")
+		item := c.Param("item")
+		section := c.Query("section")
+		field := c.Param("field")
+
+		versions, err := store.ListFieldVersions(vaultID, item, section, field)
+		if err != nil {
+			log.Printf("ListFieldVersions(%q, %q, %q, %q) error: %v", vaultID, item, section, field, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list versions"})
+			return
+		}
+		if len(versions) == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "field not found"})
+			return
+		}
+		c.JSON(http.StatusOK, versions)
+	}
+}
+
+type rollbackFieldRequest struct {
+	Version int `json:"version" binding:"required,min=1"`
+}
+
+// HandleRollbackField handles POST /v1/vaults/:id/items/:item/:field/versions —
+// restore an earlier version as the new current version.
+func HandleRollbackField(store db.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		vaultID := c.Param("id")
+		item := c.Param("item")
+		section := c.Query("section")
+		field := c.Param("field")
+
+		var req rollbackFieldRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		version, err := store.RollbackField(vaultID, item, section, field, req.Version, adminActor(c))
+		if err != nil {
+			if errors.Is(err, db.ErrVersionNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "version not found"})
+				return
+			}
+			if errors.Is(err, db.ErrInTrash) {
+				respondInTrash(c, err)
+				return
+			}
+			log.Printf("RollbackField(%q, %q, %q, %q, %d) error: %v", vaultID, item, section, field, req.Version, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to roll back field"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "rolled_back", "version": version, "restored_from": req.Version})
+	}
+}
+
 // --- Vault ↔ Instance Access ---
 
-// HandleListVaultInstances handles GET /v1/vaults/:id/instances.
-func HandleListVaultInstances(store *db.Store) gin.HandlerFunc {
+// vaultInstanceView is an instance listed with its grant on a vault.
+// GrantStatus is one of db.GrantActive, db.GrantPending or db.GrantExpired.
+type vaultInstanceView struct {
+	instanceView
+	GrantedAt   string  `json:"granted_at"`
+	NotBefore   *string `json:"not_before,omitempty"`
+	ExpiresAt   *string `json:"expires_at,omitempty"`
+	GrantStatus string  `json:"grant_status"`
+}
+
+func newVaultInstanceView(inst *db.VaultInstance, now time.Time) vaultInstanceView {
+	v := vaultInstanceView{
+		instanceView: newInstanceView(&inst.TEEInstance),
+		GrantedAt:    inst.Grant.CreatedAt.Format("2006-01-02T15:04:05Z"),
+		GrantStatus:  inst.Grant.State(now),
+	}
+	v.NotBefore, v.ExpiresAt = optTimeString(inst.Grant.NotBefore), optTimeString(inst.Grant.ExpiresAt)
+	return v
+}
+
+func optTimeString(t *time.Time) *string {
+	if t == nil {
+		return nil
+	}
+	s := t.UTC().Format("2006-01-02T15:04:05Z")
+	return &s
+}
+
+// HandleListVaultInstances handles GET /v1/vaults/:id/instances. Grants that
+// are not yet valid or have expired are listed with their grant_status.
+func HandleListVaultInstances(store db.Store) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		vaultID := c.Param("id")
 		instances, err := store.ListVaultInstances(vaultID)
@@ -199,31 +362,78 @@ func HandleListVaultInstances(store *db.Store) gin.HandlerFunc {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list vault instances"})
 			return
 		}
-		views := make([]instanceView, len(instances))
+		now := time.Now()
+		views := make([]vaultInstanceView, len(instances))
 		for i := range instances {
-			views[i] = newInstanceView(&instances[i])
+			views[i] = newVaultInstanceView(&instances[i], now)
 		}
 		c.JSON(http.StatusOK, views)
 	}
 }
 
-// HandleGrantVaultAccess handles POST /v1/vaults/:id/instances/:fid.
-func HandleGrantVaultAccess(store *db.Store) gin.HandlerFunc {
+// grantWindowRequest bounds a vault or app grant in time. Both fields are
+// optional; the body may be omitted for a permanent grant.
+type grantWindowRequest struct {
+	NotBefore *time.Time `json:"not_before"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// bindGrantWindow reads an optional grantWindowRequest, answering 400 and
+// returning false when it is malformed or already over.
+func bindGrantWindow(c *gin.Context) (grantWindowRequest, bool) {
+	var req grantWindowRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "hint": "not_before and expires_at must be RFC 3339 timestamps"})
+		return req, false
+	}
+	if req.ExpiresAt != nil {
+		if req.NotBefore != nil && !req.ExpiresAt.After(*req.NotBefore) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "expires_at must be after not_before"})
+			return req, false
+		}
+		if !req.ExpiresAt.After(time.Now()) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "expires_at is in the past"})
+			return req, false
+		}
+	}
+	return req, true
+}
+
+// response returns the body answering a successful grant.
+func (req *grantWindowRequest) response() gin.H {
+	resp := gin.H{"status": "granted"}
+	if req.NotBefore != nil {
+		resp["not_before"] = req.NotBefore.UTC()
+	}
+	if req.ExpiresAt != nil {
+		resp["expires_at"] = req.ExpiresAt.UTC()
+	}
+	return resp
+}
+
+// HandleGrantVaultAccess handles POST /v1/vaults/:id/instances/:fid. Granting
+// again replaces the validity window of an existing grant.
+func HandleGrantVaultAccess(store db.Store) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		vaultID := c.Param("id")
 		fid := c.Param("fid")
 
-		if err := store.GrantVaultAccess(vaultID, fid); err != nil {
+		req, ok := bindGrantWindow(c)
+		if !ok {
+			return
+		}
+
+		if err := store.GrantVaultAccess(vaultID, fid, req.NotBefore, req.ExpiresAt); err != nil {
 			log.Printf("GrantVaultAccess(%q, %q) error: %v", vaultID, fid, err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to grant access"})
 			return
 		}
-		c.JSON(http.StatusOK, gin.H{"status": "granted"})
+		c.JSON(http.StatusOK, req.response())
 	}
 }
 
 // HandleRevokeVaultAccess handles DELETE /v1/vaults/:id/instances/:fid.
-func HandleRevokeVaultAccess(store *db.Store) gin.HandlerFunc {
+func HandleRevokeVaultAccess(store db.Store) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		vaultID := c.Param("id")
 		fid := c.Param("fid")
@@ -245,7 +455,7 @@ func HandleRevokeVaultAccess(store *db.Store) gin.HandlerFunc {
 // --- Debug Policy ---
 
 // HandleGetDebugPolicy handles GET /v1/debug-policy/:vault/:fid.
-func HandleGetDebugPolicy(store *db.Store) gin.HandlerFunc {
+func HandleGetDebugPolicy(store db.Store) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		vault := c.Param("vault")
 		fid := c.Param("fid")
@@ -268,7 +478,7 @@ type putDebugPolicyRequest struct {
 }
 
 // HandlePutDebugPolicy handles PUT /v1/debug-policy/:vault/:fid.
-func HandlePutDebugPolicy(store *db.Store) gin.HandlerFunc {
+func HandlePutDebugPolicy(store db.Store) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		vault := c.Param("vault")
 		fid := c.Param("fid")
@@ -0,0 +1,94 @@
+package handler
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/aspect-build/jingui/internal/server/db"
+	"github.com/gin-gonic/gin"
+)
+
+// appGrantView is a dstack app grant as listed by the admin API.
+// GrantStatus is one of db.GrantActive, db.GrantPending or db.GrantExpired.
+type appGrantView struct {
+	DstackAppID string  `json:"dstack_app_id"`
+	GrantedAt   string  `json:"granted_at"`
+	NotBefore   *string `json:"not_before,omitempty"`
+	ExpiresAt   *string `json:"expires_at,omitempty"`
+	GrantStatus string  `json:"grant_status"`
+}
+
+// HandleListVaultApps handles GET /v1/vaults/:id/apps — the dstack apps whose
+// attested instances may read the vault.
+func HandleListVaultApps(store db.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		vaultID := c.Param("id")
+		if !vaultExists(c, store, vaultID) {
+			return
+		}
+		apps, err := store.ListVaultApps(vaultID)
+		if err != nil {
+			log.Printf("ListVaultApps(%q) error: %v", vaultID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list vault apps"})
+			return
+		}
+		now := time.Now()
+		views := make([]appGrantView, len(apps))
+		for i := range apps {
+			a := &apps[i]
+			views[i] = appGrantView{
+				DstackAppID: a.DstackAppID,
+				GrantedAt:   a.CreatedAt.Format("2006-01-02T15:04:05Z"),
+				NotBefore:   optTimeString(a.NotBefore),
+				ExpiresAt:   optTimeString(a.ExpiresAt),
+				GrantStatus: a.State(now),
+			}
+		}
+		c.JSON(http.StatusOK, views)
+	}
+}
+
+// HandleGrantAppAccess handles POST /v1/vaults/:id/apps/:app_id — grant every
+// instance attested as the dstack app access to the vault. Like instance
+// grants it takes an optional {not_before, expires_at} window.
+func HandleGrantAppAccess(store db.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		vaultID := c.Param("id")
+		appID := c.Param("app_id")
+
+		req, ok := bindGrantWindow(c)
+		if !ok {
+			return
+		}
+		if !vaultExists(c, store, vaultID) {
+			return
+		}
+		if err := store.GrantAppAccess(vaultID, appID, req.NotBefore, req.ExpiresAt); err != nil {
+			log.Printf("GrantAppAccess(%q, %q) error: %v", vaultID, appID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to grant access"})
+			return
+		}
+		c.JSON(http.StatusOK, req.response())
+	}
+}
+
+// HandleRevokeAppAccess handles DELETE /v1/vaults/:id/apps/:app_id.
+func HandleRevokeAppAccess(store db.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		vaultID := c.Param("id")
+		appID := c.Param("app_id")
+
+		deleted, err := store.RevokeAppAccess(vaultID, appID)
+		if err != nil {
+			log.Printf("RevokeAppAccess(%q, %q) error: %v", vaultID, appID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke access"})
+			return
+		}
+		if !deleted {
+			c.JSON(http.StatusNotFound, gin.H{"error": "app grant not found"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "revoked"})
+	}
+}
@@ -0,0 +1,223 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/aspect-build/jingui/internal/refparser"
+	"github.com/aspect-build/jingui/internal/server/db"
+	"github.com/aspect-build/jingui/internal/server/eventsink"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// auditKey is the gin context key of the request's audit event.
+	auditKey = "jingui.audit"
+	// maxAuditErrorBody caps how much of an error response is kept to read
+	// its message.
+	maxAuditErrorBody = 4096
+
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+)
+
+// auditWriter keeps the body of error responses so the audit event can carry
+// the error message. Successful responses, which may hold secrets, are never
+// kept.
+type auditWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *auditWriter) Write(b []byte) (int, error) {
+	w.keep(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *auditWriter) WriteString(s string) (int, error) {
+	w.keep([]byte(s))
+	return w.ResponseWriter.WriteString(s)
+}
+
+func (w *auditWriter) keep(b []byte) {
+	if w.Status() >= http.StatusBadRequest && w.body.Len()+len(b) <= maxAuditErrorBody {
+		w.body.Write(b)
+	}
+}
+
+// AuditAdmin returns a middleware that records every admin request it wraps
+// in the audit log as action, attributed to the X-Jingui-Actor header, and
+// publishes it to sinks. The
+// vault and instance are taken from the :id, :vault and :fid route
+// parameters; handlers fill them in with auditEvent when they come from the
+// request body.
+func AuditAdmin(store db.Store, sinks *eventsink.Dispatcher, action string) gin.HandlerFunc {
+	return audit(store, sinks, action, false, func(c *gin.Context, ev *db.AuditEvent) {
+		ev.Actor = adminActor(c)
+		ev.Target = c.Request.URL.RequestURI()
+		ev.VaultID = c.Param("id")
+		if ev.VaultID == "" {
+			ev.VaultID = c.Param("vault")
+		}
+		ev.FID = c.Param("fid")
+	})
+}
+
+// AuditClient returns a middleware that records the TEE requests it wraps in
+// the audit log as action and publishes them to sinks. With failuresOnly,
+// successful requests are not recorded.
+func AuditClient(store db.Store, sinks *eventsink.Dispatcher, action string, failuresOnly bool) gin.HandlerFunc {
+	return audit(store, sinks, action, failuresOnly, func(*gin.Context, *db.AuditEvent) {})
+}
+
+func audit(store db.Store, sinks *eventsink.Dispatcher, action string, failuresOnly bool, init func(*gin.Context, *db.AuditEvent)) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ev := &db.AuditEvent{Action: action, SourceIP: c.ClientIP()}
+		init(c, ev)
+		c.Set(auditKey, ev)
+		w := &auditWriter{ResponseWriter: c.Writer}
+		c.Writer = w
+
+		c.Next()
+
+		ev.Status = w.Status()
+		ev.Outcome = auditOutcome(ev.Status)
+		if failuresOnly && ev.Outcome == db.AuditSuccess {
+			return
+		}
+		if ev.Status >= http.StatusBadRequest {
+			var body struct {
+				Error string `json:"error"`
+			}
+			if json.Unmarshal(w.body.Bytes(), &body) == nil {
+				ev.Error = body.Error
+			}
+		}
+		for _, e := range splitByVault(ev) {
+			if err := store.RecordAuditEvent(e); err != nil {
+				log.Printf("RecordAuditEvent(%q) error: %v", action, err)
+			}
+			// Published even if recording failed, so the event is not lost.
+			sinks.Publish(eventsink.FromAudit(*e))
+		}
+	}
+}
+
+// auditEvent returns the audit event of the request for the handler to add
+// details to. Requests without an audit middleware get a throwaway event.
+func auditEvent(c *gin.Context) *db.AuditEvent {
+	if v, ok := c.Get(auditKey); ok {
+		return v.(*db.AuditEvent)
+	}
+	return &db.AuditEvent{}
+}
+
+func auditOutcome(status int) string {
+	switch {
+	case status < http.StatusBadRequest:
+		return db.AuditSuccess
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return db.AuditDenied
+	}
+	return db.AuditError
+}
+
+// splitByVault turns a fetch event into one event per vault its references
+// name, so the log can be filtered by vault. References that do not parse
+// stay on an event without a vault.
+func splitByVault(ev *db.AuditEvent) []*db.AuditEvent {
+	if len(ev.Refs) == 0 || ev.VaultID != "" {
+		return []*db.AuditEvent{ev}
+	}
+	var (
+		order   []string
+		byVault = map[string]*db.AuditEvent{}
+	)
+	for _, raw := range ev.Refs {
+		vault := ""
+		if ref, err := refparser.Parse(raw); err == nil {
+			vault = ref.Vault
+		}
+		e, ok := byVault[vault]
+		if !ok {
+			cp := *ev
+			cp.VaultID, cp.Refs = vault, nil
+			e = &cp
+			byVault[vault] = e
+			order = append(order, vault)
+		}
+		e.Refs = append(e.Refs, raw)
+	}
+	out := make([]*db.AuditEvent, len(order))
+	for i, vault := range order {
+		out[i] = byVault[vault]
+	}
+	return out
+}
+
+// HandleListAudit handles GET /v1/audit — audit events newest first, filtered
+// by ?action=, ?outcome=, ?actor=, ?fid=, ?vault= and an RFC 3339 ?since= /
+// ?until= range. Pages hold ?limit= events (default 100); pass the returned
+// next_before as ?before= for the next page.
+func HandleListAudit(store db.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		q := db.AuditQuery{
+			Action:  c.Query("action"),
+			Outcome: c.Query("outcome"),
+			Actor:   c.Query("actor"),
+			FID:     c.Query("fid"),
+			VaultID: c.Query("vault"),
+			Limit:   defaultAuditLimit,
+		}
+		for _, p := range []struct {
+			name string
+			dst  *time.Time
+		}{{"since", &q.Since}, {"until", &q.Until}} {
+			raw, ok := c.GetQuery(p.name)
+			if !ok {
+				continue
+			}
+			t, err := time.Parse(time.RFC3339, raw)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": p.name + " must be an RFC 3339 timestamp"})
+				return
+			}
+			*p.dst = t
+		}
+		if raw, ok := c.GetQuery("before"); ok {
+			id, err := strconv.ParseInt(raw, 10, 64)
+			if err != nil || id <= 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "before must be a positive event id"})
+				return
+			}
+			q.BeforeID = id
+		}
+		if raw, ok := c.GetQuery("limit"); ok {
+			n, err := strconv.Atoi(raw)
+			if err != nil || n < 1 || n > maxAuditLimit {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and " + strconv.Itoa(maxAuditLimit)})
+				return
+			}
+			q.Limit = n
+		}
+
+		events, err := store.ListAuditEvents(q)
+		if err != nil {
+			log.Printf("ListAuditEvents error: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list audit events"})
+			return
+		}
+		if events == nil {
+			events = []db.AuditEvent{}
+		}
+		resp := gin.H{"events": events}
+		if len(events) == q.Limit {
+			resp["next_before"] = events[len(events)-1].ID
+		}
+		c.JSON(http.StatusOK, resp)
+	}
+}
@@ -0,0 +1,43 @@
+package handler
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/aspect-build/jingui/internal/server/backup"
+	"github.com/aspect-build/jingui/internal/server/db"
+	"github.com/gin-gonic/gin"
+)
+
+// HandleBackup handles GET /v1/backup. The backup is encrypted to the
+// server's configured backup public key rather than one chosen by the caller,
+// so the admin token alone is not enough to read secrets out of it.
+func HandleBackup(store db.Store, recipient *[32]byte) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if recipient == nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"error": "backups are disabled",
+				"hint":  "set JINGUI_BACKUP_PUBLIC_KEY on the server",
+			})
+			return
+		}
+
+		snap, err := store.Snapshot()
+		if err != nil {
+			log.Printf("Snapshot error: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to snapshot database"})
+			return
+		}
+		data, manifest, err := backup.Seal(snap, *recipient)
+		if err != nil {
+			log.Printf("Seal backup error: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to encrypt backup"})
+			return
+		}
+
+		name := "jingui-" + manifest.CreatedAt.Format("20060102-150405") + ".backup"
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+		c.Data(http.StatusOK, "application/octet-stream", data)
+	}
+}
@@ -0,0 +1,253 @@
+package handler
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/aspect-build/jingui/internal/attestation"
+	"github.com/aspect-build/jingui/internal/server/db"
+	"github.com/aspect-build/jingui/internal/server/eventsink"
+	"github.com/gin-gonic/gin"
+)
+
+// enrollmentPolicyView is an enrollment policy as listed by the admin API.
+type enrollmentPolicyView struct {
+	DstackAppID string   `json:"dstack_app_id"`
+	Label       string   `json:"label"`
+	Vaults      []string `json:"vaults"`
+	CreatedAt   string   `json:"created_at"`
+	UpdatedAt   string   `json:"updated_at"`
+}
+
+func newEnrollmentPolicyView(p *db.EnrollmentPolicy) enrollmentPolicyView {
+	vaults := p.Vaults
+	if vaults == nil {
+		vaults = []string{}
+	}
+	return enrollmentPolicyView{
+		DstackAppID: p.DstackAppID,
+		Label:       p.Label,
+		Vaults:      vaults,
+		CreatedAt:   p.CreatedAt.Format("2006-01-02T15:04:05Z"),
+		UpdatedAt:   p.UpdatedAt.Format("2006-01-02T15:04:05Z"),
+	}
+}
+
+// expandLabel fills in the placeholders of an instance label template:
+// {fid} and {instance_id}, the dstack instance ID the instance reports. The
+// instance ID is empty for instances registered with an enrollment token.
+func expandLabel(template, fid, instanceID string) string {
+	return strings.NewReplacer("{fid}", fid, "{instance_id}", instanceID).Replace(template)
+}
+
+// HandleListEnrollmentPolicies handles GET /v1/enrollment-policies.
+func HandleListEnrollmentPolicies(store db.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		policies, err := store.ListEnrollmentPolicies()
+		if err != nil {
+			log.Printf("ListEnrollmentPolicies() error: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list enrollment policies"})
+			return
+		}
+		views := make([]enrollmentPolicyView, len(policies))
+		for i := range policies {
+			views[i] = newEnrollmentPolicyView(&policies[i])
+		}
+		c.JSON(http.StatusOK, views)
+	}
+}
+
+type putEnrollmentPolicyRequest struct {
+	Label  string   `json:"label"`
+	Vaults []string `json:"vaults"`
+}
+
+// HandlePutEnrollmentPolicy handles PUT /v1/enrollment-policies/:app_id —
+// let attested instances of the dstack app enroll themselves, labelled from
+// the label template and granted the listed vaults.
+func HandlePutEnrollmentPolicy(store db.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		appID := c.Param("app_id")
+		var req putEnrollmentPolicyRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		for _, vaultID := range req.Vaults {
+			if !vaultExists(c, store, vaultID) {
+				return
+			}
+		}
+
+		p := &db.EnrollmentPolicy{DstackAppID: appID, Label: req.Label, Vaults: req.Vaults}
+		if err := store.PutEnrollmentPolicy(p); err != nil {
+			log.Printf("PutEnrollmentPolicy(%q) error: %v", appID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save enrollment policy"})
+			return
+		}
+		stored, err := store.GetEnrollmentPolicy(appID)
+		if err != nil || stored == nil {
+			log.Printf("GetEnrollmentPolicy(%q) error: %v", appID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve enrollment policy"})
+			return
+		}
+		c.JSON(http.StatusOK, newEnrollmentPolicyView(stored))
+	}
+}
+
+// HandleDeleteEnrollmentPolicy handles DELETE /v1/enrollment-policies/:app_id.
+// Instances already enrolled are kept.
+func HandleDeleteEnrollmentPolicy(store db.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		appID := c.Param("app_id")
+		deleted, err := store.DeleteEnrollmentPolicy(appID)
+		if err != nil {
+			log.Printf("DeleteEnrollmentPolicy(%q) error: %v", appID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete enrollment policy"})
+			return
+		}
+		if !deleted {
+			c.JSON(http.StatusNotFound, gin.H{"error": "enrollment policy not found"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "deleted"})
+	}
+}
+
+type enrollRequest struct {
+	PublicKey   string             `json:"public_key" binding:"required"`
+	Attestation attestation.Bundle `json:"attestation"`
+	// Signature is the base64 signature of attestation.EnrollmentDigest of
+	// the public key by the attested RA-TLS key.
+	Signature string `json:"signature" binding:"required"`
+}
+
+// HandleEnrollInstance handles POST /v1/enroll — an instance registers itself
+// without the admin token by proving, with RA-TLS attestation, that it runs a
+// dstack app with an enrollment policy. The attested measurements must be
+// allowed by the measurement policies that would apply to the instance.
+// Enrolling again with the same key is a no-op.
+func HandleEnrollInstance(store db.Store, verifier attestation.Verifier, sinks *eventsink.Dispatcher) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req enrollRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		pubKey, err := hex.DecodeString(req.PublicKey)
+		if err != nil || len(pubKey) != 32 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "public_key must be 64 hex characters (32 bytes)"})
+			return
+		}
+		sig, err := base64.StdEncoding.DecodeString(req.Signature)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "signature must be base64"})
+			return
+		}
+		h := sha1.Sum(pubKey)
+		fid := hex.EncodeToString(h[:])
+		ev := auditEvent(c)
+		ev.FID = fid
+		ev.AppID = req.Attestation.AppID
+
+		if verifier == nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "attestation verifier is not configured"})
+			return
+		}
+		identity, err := verifier.Verify(c.Request.Context(), req.Attestation)
+		if err != nil {
+			ratlsRejected(c, sinks, "enroll", fid, "verify failed", "err", err.Error())
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "attestation verification failed"})
+			return
+		}
+		if identity.AppID == "" {
+			ratlsRejected(c, sinks, "enroll", fid, "verified cert missing app_id extension")
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "attestation certificate does not contain app_id"})
+			return
+		}
+		if err := attestation.VerifyBinding(req.Attestation, attestation.EnrollmentDigest(pubKey), sig); err != nil {
+			ratlsRejected(c, sinks, "enroll", fid, "key binding failed", "err", err.Error())
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "public key is not bound to the attestation"})
+			return
+		}
+		ev.AppID = identity.AppID
+
+		policy, err := store.GetEnrollmentPolicy(identity.AppID)
+		if err != nil {
+			log.Printf("GetEnrollmentPolicy(%q) error: %v", identity.AppID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+			return
+		}
+		if policy == nil {
+			ratlsRejected(c, sinks, "enroll", fid, "no enrollment policy", "verified_app_id", identity.AppID)
+			c.JSON(http.StatusForbidden, gin.H{
+				"error": "dstack app is not allowed to enroll",
+				"hint":  "an admin must create an enrollment policy: PUT /v1/enrollment-policies/" + identity.AppID,
+			})
+			return
+		}
+
+		// The enrolled instance is held to the measurement policies it would
+		// be checked against on every challenge, including those of the
+		// vaults the enrollment policy is about to grant.
+		candidate := &db.TEEInstance{FID: fid, DstackAppID: identity.AppID}
+		if measurementRejected(c, store, sinks, "enroll", candidate, identity.Measurements) {
+			return
+		}
+		measured := measuredValues(identity.Measurements)
+		for _, vaultID := range policy.Vaults {
+			if vaultMeasurementRejected(c, store, sinks, "enroll", fid, vaultID, measured) {
+				return
+			}
+		}
+
+		existing, err := store.GetInstance(fid)
+		if err != nil {
+			log.Printf("GetInstance(%q) error: %v", fid, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+			return
+		}
+		if existing != nil {
+			if existing.DstackAppID != identity.AppID {
+				c.JSON(http.StatusConflict, gin.H{"error": "instance is registered to another dstack app"})
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{"fid": fid, "status": "already_registered", "dstack_app_id": existing.DstackAppID, "label": existing.Label})
+			return
+		}
+
+		inst := &db.TEEInstance{
+			FID:         fid,
+			PublicKey:   pubKey,
+			DstackAppID: identity.AppID,
+			Label:       expandLabel(policy.Label, fid, identity.InstanceID),
+		}
+		if err := store.EnrollInstance(inst, policy.Vaults); err != nil {
+			if errors.Is(err, db.ErrInTrash) {
+				respondInTrash(c, err)
+				return
+			}
+			switch err {
+			case db.ErrInstanceDuplicateFID, db.ErrInstanceDuplicateKey:
+				c.JSON(http.StatusConflict, gin.H{"error": "instance already registered"})
+			case db.ErrInstanceRetired:
+				c.JSON(http.StatusConflict, gin.H{"error": "public key was retired by a re-key"})
+			default:
+				log.Printf("EnrollInstance(%q) error: %v", fid, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+			}
+			return
+		}
+
+		vaults := policy.Vaults
+		if vaults == nil {
+			vaults = []string{}
+		}
+		c.JSON(http.StatusCreated, gin.H{"fid": fid, "status": "enrolled", "dstack_app_id": inst.DstackAppID, "label": inst.Label, "vaults": vaults})
+	}
+}
@@ -0,0 +1,185 @@
+package handler
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aspect-build/jingui/internal/attestation"
+	"github.com/aspect-build/jingui/internal/server/db"
+	"github.com/gin-gonic/gin"
+)
+
+// testBinding returns an attestation bundle with a self-signed ECDSA
+// certificate, standing in for a dstack RA-TLS one, and that key's base64
+// signature of the enrollment digest of pub.
+func testBinding(t *testing.T, pub []byte) (attestation.Bundle, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "jingui-enroll"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create certificate: %v", err)
+	}
+	sig, err := ecdsa.SignASN1(rand.Reader, key, attestation.EnrollmentDigest(pub))
+	if err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+	bundle := attestation.Bundle{
+		AppCert:  string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		AppID:    "app1",
+		Instance: "inst-7",
+	}
+	return bundle, base64.StdEncoding.EncodeToString(sig)
+}
+
+func enroll(r *gin.Engine, pub []byte, bundle attestation.Bundle, sig string) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(map[string]any{
+		"public_key":  hex.EncodeToString(pub),
+		"attestation": bundle,
+		"signature":   sig,
+	})
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/v1/enroll", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestEnrollInstance(t *testing.T) {
+	store := db.NewMemoryStore()
+	store.CreateVault(&db.Vault{ID: "v1", Name: "V1"})
+	verifier := testVerifier{identity: attestation.VerifiedIdentity{AppID: "app1", InstanceID: "inst-7"}}
+	r := gin.New()
+	r.POST("/v1/enroll", HandleEnrollInstance(store, verifier, nil))
+
+	pub := bytes.Repeat([]byte{7}, 32)
+	h := sha1.Sum(pub)
+	fid := hex.EncodeToString(h[:])
+	bundle, sig := testBinding(t, pub)
+
+	if w := enroll(r, pub, bundle, sig); w.Code != http.StatusForbidden {
+		t.Fatalf("enroll without a policy: status=%d body=%s", w.Code, w.Body.String())
+	}
+
+	store.PutEnrollmentPolicy(&db.EnrollmentPolicy{DstackAppID: "app1", Label: "worker-{instance_id}", Vaults: []string{"v1"}})
+
+	// The signature must be by the attested key and over this public key.
+	otherBundle, otherSig := testBinding(t, pub)
+	if w := enroll(r, pub, bundle, otherSig); w.Code != http.StatusUnauthorized {
+		t.Errorf("enroll with another key's signature: status=%d body=%s", w.Code, w.Body.String())
+	}
+	if w := enroll(r, bytes.Repeat([]byte{8}, 32), otherBundle, otherSig); w.Code != http.StatusUnauthorized {
+		t.Errorf("enroll with a signature over another public key: status=%d body=%s", w.Code, w.Body.String())
+	}
+
+	w := enroll(r, pub, bundle, sig)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("enroll: status=%d body=%s", w.Code, w.Body.String())
+	}
+	var resp map[string]any
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if resp["fid"] != fid || resp["label"] != "worker-inst-7" {
+		t.Errorf("enroll response = %v", resp)
+	}
+	inst, _ := store.GetInstance(fid)
+	if inst == nil || inst.DstackAppID != "app1" || inst.Label != "worker-inst-7" {
+		t.Fatalf("enrolled instance = %+v", inst)
+	}
+	if ok, _ := store.HasVaultAccess("v1", fid); !ok {
+		t.Error("expected the policy's vault to be granted")
+	}
+
+	// Enrolling again is a no-op.
+	if w := enroll(r, pub, bundle, sig); w.Code != http.StatusOK || !bytes.Contains(w.Body.Bytes(), []byte("already_registered")) {
+		t.Errorf("enroll again: status=%d body=%s", w.Code, w.Body.String())
+	}
+
+	// A key registered for another app is not taken over.
+	otherPub := bytes.Repeat([]byte{9}, 32)
+	oh := sha1.Sum(otherPub)
+	store.RegisterInstance(&db.TEEInstance{FID: hex.EncodeToString(oh[:]), PublicKey: otherPub, DstackAppID: "app2"})
+	appBundle, appSig := testBinding(t, otherPub)
+	if w := enroll(r, otherPub, appBundle, appSig); w.Code != http.StatusConflict {
+		t.Errorf("enroll a key of another app: status=%d body=%s", w.Code, w.Body.String())
+	}
+}
+
+func TestEnrollInstance_AttestationRejected(t *testing.T) {
+	store := db.NewMemoryStore()
+	store.PutEnrollmentPolicy(&db.EnrollmentPolicy{DstackAppID: "app1"})
+	pub := bytes.Repeat([]byte{7}, 32)
+	bundle, sig := testBinding(t, pub)
+
+	for name, verifier := range map[string]testVerifier{
+		"verify error": {err: errors.New("bad quote")},
+		"no app id":    {identity: attestation.VerifiedIdentity{}},
+		"other app":    {identity: attestation.VerifiedIdentity{AppID: "app2"}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			r := gin.New()
+			r.POST("/v1/enroll", HandleEnrollInstance(store, verifier, nil))
+			w := enroll(r, pub, bundle, sig)
+			if w.Code != http.StatusUnauthorized && w.Code != http.StatusForbidden {
+				t.Errorf("status=%d body=%s, want 401 or 403", w.Code, w.Body.String())
+			}
+		})
+	}
+	if insts, _ := store.ListInstances(); len(insts) != 0 {
+		t.Errorf("instances registered despite rejected attestation: %+v", insts)
+	}
+}
+
+func TestEnrollInstance_MeasurementPolicy(t *testing.T) {
+	store := db.NewMemoryStore()
+	store.CreateVault(&db.Vault{ID: "v1", Name: "V1"})
+	store.CreateVault(&db.Vault{ID: "v2", Name: "V2"})
+	store.PutEnrollmentPolicy(&db.EnrollmentPolicy{DstackAppID: "app1", Vaults: []string{"v1"}})
+	verifier := &testVerifier{identity: attestation.VerifiedIdentity{AppID: "app1", Measurements: attestation.Measurements{MRTD: "ff01"}}}
+	r := gin.New()
+	r.POST("/v1/enroll", HandleEnrollInstance(store, verifier, nil))
+	pub := bytes.Repeat([]byte{7}, 32)
+	bundle, sig := testBinding(t, pub)
+
+	// Both the vaults the policy grants and those granted to the app count.
+	store.PutMeasurementPolicy(&db.MeasurementPolicy{Scope: db.MeasurementScopeVault, Target: "v1", Allowed: map[string][]string{db.MeasurementMRTD: {"aa01"}}})
+	if w := enroll(r, pub, bundle, sig); w.Code != http.StatusForbidden || !bytes.Contains(w.Body.Bytes(), []byte("mrtd")) {
+		t.Errorf("enroll with an mrtd v1 does not allow: status=%d body=%s", w.Code, w.Body.String())
+	}
+	store.DeleteMeasurementPolicy(db.MeasurementScopeVault, "v1")
+	store.GrantAppAccess("v2", "app1", nil, nil)
+	store.PutMeasurementPolicy(&db.MeasurementPolicy{Scope: db.MeasurementScopeVault, Target: "v2", Allowed: map[string][]string{db.MeasurementMRTD: {"aa01"}}})
+	if w := enroll(r, pub, bundle, sig); w.Code != http.StatusForbidden {
+		t.Errorf("enroll with an mrtd v2 does not allow: status=%d body=%s", w.Code, w.Body.String())
+	}
+	if insts, _ := store.ListInstances(); len(insts) != 0 {
+		t.Errorf("instances enrolled despite rejected measurements: %+v", insts)
+	}
+
+	verifier.identity.Measurements.MRTD = "aa01"
+	if w := enroll(r, pub, bundle, sig); w.Code != http.StatusCreated {
+		t.Errorf("enroll with an allowed mrtd: status=%d body=%s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,116 @@
+package handler
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/aspect-build/jingui/internal/server/db"
+	"github.com/gin-gonic/gin"
+)
+
+// defaultExpiringWithinDays is the look-ahead of GET /v1/vaults/:id/expiring
+// when ?within_days= is not given.
+const defaultExpiringWithinDays = 30
+
+type putExpiryRequest struct {
+	ExpiresAt time.Time `json:"expires_at" binding:"required"`
+}
+
+// expiryView is a field expiry as listed by the admin API.
+type expiryView struct {
+	db.FieldExpiry
+	Expired bool `json:"expired"`
+}
+
+// HandlePutExpiry handles PUT /v1/vaults/:id/items/:item/expiry — set when a
+// field (?field=) or, without one, every field of a section stops being
+// served.
+func HandlePutExpiry(store db.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		vaultID := c.Param("id")
+		item := c.Param("item")
+		section := c.Query("section")
+		field := c.Query("field")
+
+		var req putExpiryRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "hint": "expires_at must be an RFC 3339 timestamp"})
+			return
+		}
+
+		ok, err := store.SetExpiry(vaultID, item, section, field, req.ExpiresAt)
+		if err != nil {
+			log.Printf("SetExpiry(%q, %q, %q, %q) error: %v", vaultID, item, section, field, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to set expiry"})
+			return
+		}
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": expiryTarget(field) + " not found"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "updated", "expires_at": req.ExpiresAt.UTC()})
+	}
+}
+
+// HandleDeleteExpiry handles DELETE /v1/vaults/:id/items/:item/expiry — clear
+// the expiry set on a field (?field=) or a section.
+func HandleDeleteExpiry(store db.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		vaultID := c.Param("id")
+		item := c.Param("item")
+		section := c.Query("section")
+		field := c.Query("field")
+
+		ok, err := store.ClearExpiry(vaultID, item, section, field)
+		if err != nil {
+			log.Printf("ClearExpiry(%q, %q, %q, %q) error: %v", vaultID, item, section, field, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to clear expiry"})
+			return
+		}
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": "no expiry set on this " + expiryTarget(field)})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "cleared"})
+	}
+}
+
+func expiryTarget(field string) string {
+	if field == "" {
+		return "section"
+	}
+	return "field"
+}
+
+// HandleListExpiring handles GET /v1/vaults/:id/expiring — fields that have
+// expired or expire within ?within_days= days (default 30), soonest first.
+func HandleListExpiring(store db.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		vaultID := c.Param("id")
+
+		days := defaultExpiringWithinDays
+		if raw, ok := c.GetQuery("within_days"); ok {
+			n, err := strconv.Atoi(raw)
+			if err != nil || n < 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "within_days must be a non-negative integer"})
+				return
+			}
+			days = n
+		}
+
+		now := time.Now()
+		expiring, err := store.ListExpiringFields(vaultID, now.AddDate(0, 0, days))
+		if err != nil {
+			log.Printf("ListExpiringFields(%q) error: %v", vaultID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list expiring fields"})
+			return
+		}
+		views := make([]expiryView, len(expiring))
+		for i, e := range expiring {
+			views[i] = expiryView{FieldExpiry: e, Expired: !e.ExpiresAt.After(now)}
+		}
+		c.JSON(http.StatusOK, views)
+	}
+}
@@ -0,0 +1,154 @@
+package handler
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/aspect-build/jingui/internal/server/db"
+	"github.com/gin-gonic/gin"
+)
+
+type putFieldGrantRequest struct {
+	FID     string `json:"fid" binding:"required"`
+	Item    string `json:"item" binding:"required"`
+	Section string `json:"section"`
+	Field   string `json:"field"`
+	Effect  string `json:"effect"`
+}
+
+// grantHint explains the pattern fields of a field grant.
+const grantHint = `item, section and field are glob patterns ("*", "db-*", "prod"); an empty section is the item's default section, "*" any section; field defaults to "*"; effect is "allow" (default) or "deny"`
+
+// HandleListFieldGrants handles GET /v1/vaults/:id/grants — list the field
+// grants on a vault, optionally for one instance (?fid=).
+func HandleListFieldGrants(store db.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		vaultID := c.Param("id")
+		fid := c.Query("fid")
+
+		if !vaultExists(c, store, vaultID) {
+			return
+		}
+		grants, err := store.ListFieldGrants(vaultID, fid)
+		if err != nil {
+			log.Printf("ListFieldGrants(%q, %q) error: %v", vaultID, fid, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list field grants"})
+			return
+		}
+		if grants == nil {
+			grants = []db.FieldGrant{}
+		}
+		c.JSON(http.StatusOK, grants)
+	}
+}
+
+// HandlePutFieldGrant handles PUT /v1/vaults/:id/grants — allow or deny an
+// instance the fields matched by a set of patterns. Putting the same
+// patterns again changes the effect.
+func HandlePutFieldGrant(store db.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		vaultID := c.Param("id")
+
+		var req putFieldGrantRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "hint": grantHint})
+			return
+		}
+		g := &db.FieldGrant{
+			VaultID:   vaultID,
+			FID:       req.FID,
+			Item:      req.Item,
+			Section:   req.Section,
+			FieldName: req.Field,
+			Effect:    req.Effect,
+		}
+		if g.FieldName == "" {
+			g.FieldName = "*"
+		}
+		if g.Effect == "" {
+			g.Effect = db.GrantAllow
+		}
+		if err := db.ValidateFieldGrant(g); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "hint": grantHint})
+			return
+		}
+
+		if !vaultExists(c, store, vaultID) {
+			return
+		}
+		inst, err := store.GetInstance(req.FID)
+		if err != nil {
+			log.Printf("GetInstance(%q) error: %v", req.FID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve instance"})
+			return
+		}
+		if inst == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "instance not found"})
+			return
+		}
+
+		if err := store.PutFieldGrant(g); err != nil {
+			if errors.Is(err, db.ErrInvalidGrant) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "hint": grantHint})
+				return
+			}
+			log.Printf("PutFieldGrant(%q, %q) error: %v", vaultID, req.FID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to put field grant"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"status":     "granted",
+			"fid":        g.FID,
+			"item":       g.Item,
+			"section":    g.Section,
+			"field_name": g.FieldName,
+			"effect":     g.Effect,
+		})
+	}
+}
+
+// HandleDeleteFieldGrant handles DELETE /v1/vaults/:id/grants — remove the
+// field grant of an instance (?fid=) with exactly the given patterns
+// (?item=, ?section=, ?field=, the last defaulting to "*").
+func HandleDeleteFieldGrant(store db.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		vaultID := c.Param("id")
+		fid := c.Query("fid")
+		item := c.Query("item")
+		section := c.Query("section")
+		field := c.DefaultQuery("field", "*")
+		if fid == "" || item == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "fid and item are required", "hint": grantHint})
+			return
+		}
+
+		deleted, err := store.DeleteFieldGrant(vaultID, fid, item, section, field)
+		if err != nil {
+			log.Printf("DeleteFieldGrant(%q, %q) error: %v", vaultID, fid, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete field grant"})
+			return
+		}
+		if !deleted {
+			c.JSON(http.StatusNotFound, gin.H{"error": "field grant not found"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "deleted"})
+	}
+}
+
+// vaultExists looks up a live vault, answering 404 or 500 itself when it
+// cannot be used.
+func vaultExists(c *gin.Context, store db.Store, vaultID string) bool {
+	v, err := store.GetVault(vaultID)
+	if err != nil {
+		log.Printf("GetVault(%q) error: %v", vaultID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve vault"})
+		return false
+	}
+	if v == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "vault not found"})
+		return false
+	}
+	return true
+}
@@ -0,0 +1,126 @@
+package handler
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/aspect-build/jingui/internal/importer"
+	"github.com/aspect-build/jingui/internal/server/db"
+	"github.com/gin-gonic/gin"
+)
+
+type importRequest struct {
+	Format     string `json:"format" binding:"required"`
+	Content    string `json:"content" binding:"required"`
+	Item       string `json:"item"`
+	Section    string `json:"section"`
+	OnConflict string `json:"on_conflict"`
+	DryRun     bool   `json:"dry_run"`
+}
+
+// HandleImport handles POST /v1/vaults/:id/import — bulk import of a dotenv,
+// JSON or 1Password export. The whole import is applied in one transaction,
+// or not at all: under the default "fail" conflict policy any field that
+// already holds a different value aborts it with 409 and the list of changes.
+// With dry_run the changes are reported without writing anything.
+func HandleImport(store db.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		vaultID := c.Param("id")
+
+		var req importRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if req.OnConflict == "" {
+			req.OnConflict = importer.ConflictFail
+		}
+
+		v, err := store.GetVault(vaultID)
+		if err != nil {
+			log.Printf("GetVault(%q) error: %v", vaultID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve vault"})
+			return
+		}
+		if v == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "vault not found"})
+			return
+		}
+
+		fields, err := importer.Parse(req.Format, []byte(req.Content), req.Item, req.Section)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if len(fields) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "nothing to import"})
+			return
+		}
+
+		current := map[importer.Key]string{}
+		seen := map[string]bool{}
+		for _, f := range fields {
+			if seen[f.Item] {
+				continue
+			}
+			seen[f.Item] = true
+			existing, err := store.GetItemFields(vaultID, f.Item)
+			if err != nil {
+				log.Printf("GetItemFields(%q, %q) error: %v", vaultID, f.Item, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to read existing fields"})
+				return
+			}
+			for _, e := range existing {
+				current[importer.Key{Item: e.Item, Section: e.Section, Name: e.FieldName}] = e.Value
+			}
+		}
+
+		changes, writes, err := importer.Plan(fields, current, req.OnConflict)
+		if err != nil {
+			if errors.Is(err, importer.ErrConflict) {
+				c.JSON(http.StatusConflict, gin.H{
+					"error":   err.Error(),
+					"hint":    `pass on_conflict "skip" or "overwrite"`,
+					"changes": changes,
+					"summary": importer.Summarize(changes),
+				})
+				return
+			}
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if req.DryRun {
+			c.JSON(http.StatusOK, gin.H{"dry_run": true, "changes": changes, "summary": importer.Summarize(changes)})
+			return
+		}
+		if err := store.MergeVaultFields(vaultID, importMerges(writes), adminActor(c)); err != nil {
+			if errors.Is(err, db.ErrInTrash) {
+				respondInTrash(c, err)
+				return
+			}
+			log.Printf("MergeVaultFields(%q) error: %v", vaultID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to import fields"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "imported", "changes": changes, "summary": importer.Summarize(changes)})
+	}
+}
+
+// importMerges groups imported fields by item and section.
+func importMerges(fields []importer.Field) []db.ItemMerge {
+	var merges []db.ItemMerge
+	index := map[[2]string]int{}
+	for _, f := range fields {
+		target := [2]string{f.Item, f.Section}
+		i, ok := index[target]
+		if !ok {
+			i = len(merges)
+			index[target] = i
+			merges = append(merges, db.ItemMerge{Item: f.Item, Section: f.Section, Upsert: map[string]string{}})
+		}
+		merges[i].Upsert[f.Name] = f.Value
+	}
+	return merges
+}
@@ -3,9 +3,11 @@ package handler
 import (
 	"crypto/sha1"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"strings"
 
 	"github.com/aspect-build/jingui/internal/server/db"
 	"github.com/gin-gonic/gin"
@@ -18,7 +20,7 @@ type registerInstanceRequest struct {
 }
 
 // HandleRegisterInstance handles POST /v1/instances.
-func HandleRegisterInstance(store *db.Store) gin.HandlerFunc {
+func HandleRegisterInstance(store db.Store) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var req registerInstanceRequest
 		if err := c.ShouldBindJSON(&req); err != nil {
@@ -35,6 +37,7 @@ func HandleRegisterInstance(store *db.Store) gin.HandlerFunc {
 		// Compute FID = hex(SHA1(pubkey_bytes))
 		h := sha1.Sum(pubKeyBytes)
 		fid := hex.EncodeToString(h[:])
+		auditEvent(c).FID = fid
 
 		inst := &db.TEEInstance{
 			FID:         fid,
@@ -44,11 +47,17 @@ func HandleRegisterInstance(store *db.Store) gin.HandlerFunc {
 		}
 
 		if err := store.RegisterInstance(inst); err != nil {
+			if errors.Is(err, db.ErrInTrash) {
+				respondInTrash(c, err)
+				return
+			}
 			switch err {
 			case db.ErrInstanceDuplicateFID:
 				c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("instance with FID %s already exists", fid)})
 			case db.ErrInstanceDuplicateKey:
 				c.JSON(http.StatusConflict, gin.H{"error": "another instance with this public key already exists"})
+			case db.ErrInstanceRetired:
+				c.JSON(http.StatusConflict, gin.H{"error": "public key was retired by a re-key", "hint": "register the instance's current key instead"})
 			default:
 				c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
 			}
@@ -65,7 +74,7 @@ type updateInstanceRequest struct {
 }
 
 // HandleUpdateInstance handles PUT /v1/instances/:fid.
-func HandleUpdateInstance(store *db.Store) gin.HandlerFunc {
+func HandleUpdateInstance(store db.Store) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		fid := c.Param("fid")
 		var req updateInstanceRequest
@@ -87,3 +96,52 @@ func HandleUpdateInstance(store *db.Store) gin.HandlerFunc {
 		c.JSON(http.StatusOK, gin.H{"status": "updated", "fid": fid})
 	}
 }
+
+type setInstanceStatusRequest struct {
+	Status string `json:"status" binding:"required"`
+	Reason string `json:"reason"`
+}
+
+// HandleSetInstanceStatus handles PUT /v1/instances/:fid/status — disable or
+// quarantine an instance, cutting it off from challenges and secrets while
+// keeping its grants and audit history, or make it active again. A reason is
+// required to take an instance out of service.
+func HandleSetInstanceStatus(store db.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		fid := c.Param("fid")
+		var req setInstanceStatusRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if !db.ValidInstanceStatus(req.Status) {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("unknown status %q", req.Status),
+				"hint":  "status must be active, disabled or quarantined",
+			})
+			return
+		}
+		if req.Status != db.InstanceActive && strings.TrimSpace(req.Reason) == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "reason is required to disable or quarantine an instance"})
+			return
+		}
+
+		updated, err := store.SetInstanceStatus(fid, req.Status, req.Reason)
+		if err != nil {
+			log.Printf("SetInstanceStatus(%q) error: %v", fid, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+			return
+		}
+		if !updated {
+			c.JSON(http.StatusNotFound, gin.H{"error": "instance not found"})
+			return
+		}
+		inst, err := store.GetInstance(fid)
+		if err != nil || inst == nil {
+			log.Printf("GetInstance(%q) error: %v", fid, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve instance"})
+			return
+		}
+		c.JSON(http.StatusOK, newInstanceView(inst))
+	}
+}
@@ -0,0 +1,70 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestSetInstanceStatus(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r, store, priv, fid := setupStrictFlow(t)
+	r.PUT("/v1/instances/:fid/status", HandleSetInstanceStatus(store))
+	setStatus := func(body map[string]any) *httptest.ResponseRecorder {
+		b, _ := json.Marshal(body)
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPut, "/v1/instances/"+fid+"/status", bytes.NewReader(b))
+		req.Header.Set("Content-Type", "application/json")
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := setStatus(map[string]any{"status": "paused", "reason": "x"}); w.Code != http.StatusBadRequest {
+		t.Errorf("unknown status: status=%d body=%s", w.Code, w.Body.String())
+	}
+	if w := setStatus(map[string]any{"status": "quarantined"}); w.Code != http.StatusBadRequest {
+		t.Errorf("quarantine without a reason: status=%d body=%s", w.Code, w.Body.String())
+	}
+
+	// A challenge issued before the quarantine cannot be used after it.
+	challengeID, response := strictChallenge(t, r, priv, fid)
+	w := setStatus(map[string]any{"status": "quarantined", "reason": "key found in a public repo"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("quarantine: status=%d body=%s", w.Code, w.Body.String())
+	}
+	var view instanceView
+	json.Unmarshal(w.Body.Bytes(), &view)
+	if view.Status != "quarantined" || view.StatusReason != "key found in a public repo" || view.StatusChangedAt == nil {
+		t.Errorf("quarantined instance = %+v", view)
+	}
+	w = postJSON(r, "/v1/secrets/fetch", map[string]any{
+		"fid":                fid,
+		"secret_references":  []string{"jingui://a1/u1/client_id"},
+		"challenge_id":       challengeID,
+		"challenge_response": response,
+	})
+	if w.Code != http.StatusForbidden || !bytes.Contains(w.Body.Bytes(), []byte("quarantined")) {
+		t.Errorf("fetch while quarantined: status=%d body=%s", w.Code, w.Body.String())
+	}
+	w = postJSON(r, "/v1/secrets/challenge", map[string]any{
+		"fid":                fid,
+		"client_attestation": map[string]any{"app_id": "a1", "app_cert": "dummy-cert"},
+	})
+	if w.Code != http.StatusForbidden {
+		t.Errorf("challenge while quarantined: status=%d body=%s", w.Code, w.Body.String())
+	}
+	if ok, _ := store.HasVaultAccess("a1", fid); !ok {
+		t.Error("grant lost when quarantining")
+	}
+
+	if w := setStatus(map[string]any{"status": "active"}); w.Code != http.StatusOK {
+		t.Fatalf("re-enable: status=%d body=%s", w.Code, w.Body.String())
+	}
+	if w := strictFetch(t, r, priv, fid, "jingui://a1/u1/client_id"); w.Code != http.StatusOK {
+		t.Errorf("fetch after re-enabling: status=%d body=%s", w.Code, w.Body.String())
+	}
+}
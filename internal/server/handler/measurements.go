@@ -0,0 +1,243 @@
+package handler
+
+import (
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/aspect-build/jingui/internal/attestation"
+	"github.com/aspect-build/jingui/internal/server/db"
+	"github.com/aspect-build/jingui/internal/server/eventsink"
+	"github.com/gin-gonic/gin"
+)
+
+// measurementPolicyView is a measurement policy as returned by the admin API.
+type measurementPolicyView struct {
+	Scope     string              `json:"scope"`
+	Target    string              `json:"target"`
+	Allowed   map[string][]string `json:"allowed"`
+	CreatedAt string              `json:"created_at"`
+	UpdatedAt string              `json:"updated_at"`
+}
+
+func newMeasurementPolicyView(p *db.MeasurementPolicy) measurementPolicyView {
+	allowed := p.Allowed
+	if allowed == nil {
+		allowed = map[string][]string{}
+	}
+	return measurementPolicyView{
+		Scope:     p.Scope,
+		Target:    p.Target,
+		Allowed:   allowed,
+		CreatedAt: p.CreatedAt.UTC().Format("2006-01-02T15:04:05Z"),
+		UpdatedAt: p.UpdatedAt.UTC().Format("2006-01-02T15:04:05Z"),
+	}
+}
+
+// measurementTarget returns the vault ID or FID a measurement policy route
+// is for, responding 404 if it does not exist.
+func measurementTarget(c *gin.Context, store db.Store, scope string) (string, bool) {
+	if scope == db.MeasurementScopeVault {
+		id := c.Param("id")
+		return id, vaultExists(c, store, id)
+	}
+	fid := c.Param("fid")
+	inst, err := store.GetInstance(fid)
+	if err != nil {
+		log.Printf("GetInstance(%q) error: %v", fid, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve instance"})
+		return "", false
+	}
+	if inst == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "instance not found"})
+		return "", false
+	}
+	return fid, true
+}
+
+// measuredValues maps verified measurements to the names policies use.
+func measuredValues(m attestation.Measurements) map[string]string {
+	return map[string]string{
+		db.MeasurementMRTD:         m.MRTD,
+		db.MeasurementRTMR0:        m.RTMR0,
+		db.MeasurementRTMR1:        m.RTMR1,
+		db.MeasurementRTMR2:        m.RTMR2,
+		db.MeasurementRTMR3:        m.RTMR3,
+		db.MeasurementTCBSVN:       m.TCBSVN,
+		db.MeasurementTDAttributes: m.TDAttributes,
+	}
+}
+
+// measurementRejected checks verified measurements against the measurement
+// policies of inst and of the vaults it is granted, refusing a quote that one
+// of them does not allow with 403 and publishing the attempt to sinks. It
+// reports whether it responded.
+func measurementRejected(c *gin.Context, store db.Store, sinks *eventsink.Dispatcher, step string, inst *db.TEEInstance, m attestation.Measurements) bool {
+	policies, err := store.ApplicableMeasurementPolicies(inst.FID, inst.DstackAppID)
+	if err != nil {
+		log.Printf("ApplicableMeasurementPolicies(%q) error: %v", inst.FID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+		return true
+	}
+	measured := measuredValues(m)
+	for i := range policies {
+		if measurementMismatch(c, sinks, step, inst.FID, &policies[i], measured) {
+			return true
+		}
+	}
+	return false
+}
+
+// vaultMeasurementRejected checks measured values against the measurement
+// policy of a vault, refusing them with 403 if it does not allow them, and
+// reports whether it responded.
+func vaultMeasurementRejected(c *gin.Context, store db.Store, sinks *eventsink.Dispatcher, step, fid, vaultID string, measured map[string]string) bool {
+	p, err := store.GetMeasurementPolicy(db.MeasurementScopeVault, vaultID)
+	if err != nil {
+		log.Printf("GetMeasurementPolicy(%q, %q) error: %v", db.MeasurementScopeVault, vaultID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to load measurement policy"})
+		return true
+	}
+	return p != nil && measurementMismatch(c, sinks, step, fid, p, measured)
+}
+
+// measurementMismatch refuses measured values that p does not allow with 403,
+// publishing the attempt to sinks, and reports whether it did.
+func measurementMismatch(c *gin.Context, sinks *eventsink.Dispatcher, step, fid string, p *db.MeasurementPolicy, measured map[string]string) bool {
+	name := p.Mismatch(measured)
+	if name == "" {
+		return false
+	}
+	ratlsRejected(c, sinks, step, fid, "measurement mismatch",
+		"policy", p.Scope+"/"+p.Target, "measurement", name, "value", measured[name])
+	c.JSON(http.StatusForbidden, gin.H{
+		"error": fmt.Sprintf("attested %s is not allowed by the measurement policy of %s %s", name, p.Scope, p.Target),
+		"hint":  "the instance must run an allowed image, or an admin must allow its measurements",
+	})
+	return true
+}
+
+// HandleListMeasurementPolicies handles GET /v1/measurement-policies.
+func HandleListMeasurementPolicies(store db.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		policies, err := store.ListMeasurementPolicies()
+		if err != nil {
+			log.Printf("ListMeasurementPolicies() error: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list measurement policies"})
+			return
+		}
+		views := make([]measurementPolicyView, len(policies))
+		for i := range policies {
+			views[i] = newMeasurementPolicyView(&policies[i])
+		}
+		c.JSON(http.StatusOK, views)
+	}
+}
+
+// HandleGetMeasurementPolicy handles GET /v1/vaults/:id/measurement-policy
+// and GET /v1/instances/:fid/measurement-policy.
+func HandleGetMeasurementPolicy(store db.Store, scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		target, ok := measurementTarget(c, store, scope)
+		if !ok {
+			return
+		}
+		p, err := store.GetMeasurementPolicy(scope, target)
+		if err != nil {
+			log.Printf("GetMeasurementPolicy(%q, %q) error: %v", scope, target, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve measurement policy"})
+			return
+		}
+		if p == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "measurement policy not found"})
+			return
+		}
+		c.JSON(http.StatusOK, newMeasurementPolicyView(p))
+	}
+}
+
+type putMeasurementPolicyRequest struct {
+	// Allowed maps a measurement name to its acceptable hex values.
+	Allowed map[string][]string `json:"allowed" binding:"required"`
+}
+
+// HandlePutMeasurementPolicy handles PUT /v1/vaults/:id/measurement-policy
+// and PUT /v1/instances/:fid/measurement-policy — in strict RA-TLS mode,
+// only instances whose verified quote has one of the allowed values of every
+// listed measurement are issued challenges.
+func HandlePutMeasurementPolicy(store db.Store, scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req putMeasurementPolicyRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		allowed := map[string][]string{}
+		for name, values := range req.Allowed {
+			if !db.ValidMeasurement(name) {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"error": fmt.Sprintf("unknown measurement %q", name),
+					"hint":  "measurements are " + strings.Join(db.MeasurementNames, ", "),
+				})
+				return
+			}
+			for _, v := range values {
+				v = strings.ToLower(strings.TrimSpace(v))
+				if b, err := hex.DecodeString(v); err != nil || len(b) == 0 {
+					c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%s value %q is not hex", name, v)})
+					return
+				}
+				allowed[name] = append(allowed[name], v)
+			}
+		}
+		if len(allowed) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "allowed must list at least one measurement value",
+				"hint":  "to stop checking measurements, delete the policy",
+			})
+			return
+		}
+		target, ok := measurementTarget(c, store, scope)
+		if !ok {
+			return
+		}
+
+		if err := store.PutMeasurementPolicy(&db.MeasurementPolicy{Scope: scope, Target: target, Allowed: allowed}); err != nil {
+			log.Printf("PutMeasurementPolicy(%q, %q) error: %v", scope, target, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to save measurement policy"})
+			return
+		}
+		stored, err := store.GetMeasurementPolicy(scope, target)
+		if err != nil || stored == nil {
+			log.Printf("GetMeasurementPolicy(%q, %q) error: %v", scope, target, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve measurement policy"})
+			return
+		}
+		c.JSON(http.StatusOK, newMeasurementPolicyView(stored))
+	}
+}
+
+// HandleDeleteMeasurementPolicy handles DELETE
+// /v1/vaults/:id/measurement-policy and DELETE
+// /v1/instances/:fid/measurement-policy.
+func HandleDeleteMeasurementPolicy(store db.Store, scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		target := c.Param("id")
+		if scope == db.MeasurementScopeInstance {
+			target = c.Param("fid")
+		}
+		deleted, err := store.DeleteMeasurementPolicy(scope, target)
+		if err != nil {
+			log.Printf("DeleteMeasurementPolicy(%q, %q) error: %v", scope, target, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete measurement policy"})
+			return
+		}
+		if !deleted {
+			c.JSON(http.StatusNotFound, gin.H{"error": "measurement policy not found"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "deleted"})
+	}
+}
@@ -0,0 +1,141 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aspect-build/jingui/internal/attestation"
+	"github.com/aspect-build/jingui/internal/server/db"
+	"github.com/gin-gonic/gin"
+)
+
+func TestMeasurementPolicy(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	_, store, priv, fid := setupStrictFlow(t)
+
+	// The instance attests a release image; the same app_id built in debug
+	// mode differs in td_attributes and RTMR3.
+	r := gin.New()
+	verifier := &testVerifier{identity: attestation.VerifiedIdentity{AppID: "a1", Measurements: attestation.Measurements{
+		MRTD: "aa01", RTMR3: "cc01", TDAttributes: "0000001000000000",
+	}}}
+	collector := fakeCollector{bundle: attestation.Bundle{AppID: "server-app", AppCert: "pem"}}
+	r.POST("/v1/secrets/challenge", HandleIssueChallenge(store, true, verifier, collector, nil))
+	r.POST("/v1/secrets/fetch", HandleFetchSecrets(store, true, nil))
+	r.PUT("/v1/vaults/:id/measurement-policy", HandlePutMeasurementPolicy(store, db.MeasurementScopeVault))
+	r.GET("/v1/vaults/:id/measurement-policy", HandleGetMeasurementPolicy(store, db.MeasurementScopeVault))
+	r.DELETE("/v1/vaults/:id/measurement-policy", HandleDeleteMeasurementPolicy(store, db.MeasurementScopeVault))
+	r.PUT("/v1/instances/:fid/measurement-policy", HandlePutMeasurementPolicy(store, db.MeasurementScopeInstance))
+	putPolicy := func(path string, allowed map[string][]string) *httptest.ResponseRecorder {
+		b, _ := json.Marshal(map[string]any{"allowed": allowed})
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPut, path, bytes.NewReader(b))
+		req.Header.Set("Content-Type", "application/json")
+		r.ServeHTTP(w, req)
+		return w
+	}
+	challenge := func() *httptest.ResponseRecorder {
+		return postJSON(r, "/v1/secrets/challenge", map[string]any{
+			"fid":                fid,
+			"client_attestation": map[string]any{"app_id": "a1", "app_cert": "dummy-cert"},
+		})
+	}
+
+	if w := putPolicy("/v1/vaults/a1/measurement-policy", map[string][]string{"mr_seam": {"aa"}}); w.Code != http.StatusBadRequest {
+		t.Errorf("unknown measurement: status=%d body=%s", w.Code, w.Body.String())
+	}
+	if w := putPolicy("/v1/vaults/a1/measurement-policy", map[string][]string{"mrtd": {"zz"}}); w.Code != http.StatusBadRequest {
+		t.Errorf("non-hex value: status=%d body=%s", w.Code, w.Body.String())
+	}
+	if w := putPolicy("/v1/vaults/a1/measurement-policy", map[string][]string{}); w.Code != http.StatusBadRequest {
+		t.Errorf("empty policy: status=%d body=%s", w.Code, w.Body.String())
+	}
+	if w := putPolicy("/v1/vaults/missing/measurement-policy", map[string][]string{"mrtd": {"aa01"}}); w.Code != http.StatusNotFound {
+		t.Errorf("policy on a missing vault: status=%d body=%s", w.Code, w.Body.String())
+	}
+
+	w := putPolicy("/v1/vaults/a1/measurement-policy", map[string][]string{"mrtd": {"AA01", "aa02"}, "td_attributes": {"0000001000000000"}})
+	if w.Code != http.StatusOK {
+		t.Fatalf("put vault policy: status=%d body=%s", w.Code, w.Body.String())
+	}
+	var view measurementPolicyView
+	json.Unmarshal(w.Body.Bytes(), &view)
+	if view.Scope != "vault" || view.Target != "a1" || len(view.Allowed["mrtd"]) != 2 || view.Allowed["mrtd"][0] != "aa01" {
+		t.Errorf("vault policy = %+v, want mrtd aa01/aa02 lowercased", view)
+	}
+	if w := strictFetch(t, r, priv, fid, "jingui://a1/u1/client_id"); w.Code != http.StatusOK {
+		t.Errorf("fetch with allowed measurements: status=%d body=%s", w.Code, w.Body.String())
+	}
+
+	// A debug-mode image of the same app is refused a challenge.
+	verifier.identity.Measurements.TDAttributes = "0100001000000000"
+	if w := challenge(); w.Code != http.StatusForbidden || !bytes.Contains(w.Body.Bytes(), []byte("td_attributes")) {
+		t.Errorf("challenge with debug td_attributes: status=%d body=%s", w.Code, w.Body.String())
+	}
+	verifier.identity.Measurements.TDAttributes = "0000001000000000"
+
+	// The instance's own policy applies as well.
+	if w := putPolicy("/v1/instances/"+fid+"/measurement-policy", map[string][]string{"rtmr3": {"cc02"}}); w.Code != http.StatusOK {
+		t.Fatalf("put instance policy: status=%d body=%s", w.Code, w.Body.String())
+	}
+	if w := challenge(); w.Code != http.StatusForbidden || !bytes.Contains(w.Body.Bytes(), []byte("rtmr3")) {
+		t.Errorf("challenge with another rtmr3: status=%d body=%s", w.Code, w.Body.String())
+	}
+	verifier.identity.Measurements.RTMR3 = "cc02"
+	if w := challenge(); w.Code != http.StatusOK {
+		t.Errorf("challenge with the allowed rtmr3: status=%d body=%s", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/v1/vaults/a1/measurement-policy", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("delete vault policy: status=%d body=%s", w.Code, w.Body.String())
+	}
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/vaults/a1/measurement-policy", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("get deleted policy: status=%d body=%s", w.Code, w.Body.String())
+	}
+}
+
+func TestMeasurementPolicy_GrantAfterChallenge(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	_, store, priv, fid := setupStrictFlow(t)
+	store.CreateVault(&db.Vault{ID: "v2", Name: "pinned"})
+	store.SetItemFields("v2", "u1", "", map[string]string{"token": "secret"})
+	store.PutMeasurementPolicy(&db.MeasurementPolicy{Scope: db.MeasurementScopeVault, Target: "v2", Allowed: map[string][]string{db.MeasurementMRTD: {"aa01"}}})
+
+	r := gin.New()
+	verifier := testVerifier{identity: attestation.VerifiedIdentity{AppID: "a1", Measurements: attestation.Measurements{MRTD: "ff01"}}}
+	collector := fakeCollector{bundle: attestation.Bundle{AppID: "server-app", AppCert: "pem"}}
+	r.POST("/v1/secrets/challenge", HandleIssueChallenge(store, true, verifier, collector, nil))
+	r.POST("/v1/secrets/fetch", HandleFetchSecrets(store, true, nil))
+	fetch := func(grant func()) *httptest.ResponseRecorder {
+		challengeID, response := strictChallenge(t, r, priv, fid)
+		grant()
+		return postJSON(r, "/v1/secrets/fetch", map[string]any{
+			"fid":                fid,
+			"secret_references":  []string{"jingui://v2/u1/token"},
+			"challenge_id":       challengeID,
+			"challenge_response": response,
+		})
+	}
+
+	// The challenge passes since v2 is not granted yet; the grant made
+	// before the fetch must not skip v2's policy.
+	if w := fetch(func() { store.GrantVaultAccess("v2", fid, nil, nil) }); w.Code != http.StatusForbidden || !bytes.Contains(w.Body.Bytes(), []byte("mrtd")) {
+		t.Errorf("fetch after a vault grant: status=%d body=%s", w.Code, w.Body.String())
+	}
+	store.RevokeVaultAccess("v2", fid)
+	if w := fetch(func() { store.GrantAppAccess("v2", "a1", nil, nil) }); w.Code != http.StatusForbidden {
+		t.Errorf("fetch after an app grant: status=%d body=%s", w.Code, w.Body.String())
+	}
+
+	store.PutMeasurementPolicy(&db.MeasurementPolicy{Scope: db.MeasurementScopeVault, Target: "v2", Allowed: map[string][]string{db.MeasurementMRTD: {"aa01", "ff01"}}})
+	if w := fetch(func() {}); w.Code != http.StatusOK {
+		t.Errorf("fetch with an allowed mrtd: status=%d body=%s", w.Code, w.Body.String())
+	}
+}
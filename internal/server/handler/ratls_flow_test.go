@@ -31,13 +31,9 @@ type fakeCollector struct{ bundle attestation.Bundle }
 
 func (f fakeCollector) Collect(_ context.Context) (attestation.Bundle, error) { return f.bundle, nil }
 
-func setupStrictFlow(t *testing.T) (*gin.Engine, [32]byte, string) {
+func setupStrictFlow(t *testing.T) (*gin.Engine, db.Store, [32]byte, string) {
 	t.Helper()
-	store, err := db.NewStore(":memory:")
-	if err != nil {
-		t.Fatalf("new store: %v", err)
-	}
-	t.Cleanup(func() { _ = store.Close() })
+	store := db.NewMemoryStore()
 
 	// Create vault
 	if err := store.CreateVault(&db.Vault{ID: "a1", Name: "app"}); err != nil {
@@ -45,7 +41,7 @@ func setupStrictFlow(t *testing.T) (*gin.Engine, [32]byte, string) {
 	}
 
 	// Store a field
-	if err := store.SetItemFields("a1", "u1", map[string]string{"client_id": "test-cid"}); err != nil {
+	if err := store.SetItemFields("a1", "u1", "", map[string]string{"client_id": "test-cid"}); err != nil {
 		t.Fatalf("set item fields: %v", err)
 	}
 
@@ -64,22 +60,42 @@ func setupStrictFlow(t *testing.T) (*gin.Engine, [32]byte, string) {
 	}
 
 	// Grant vault access
-	if err := store.GrantVaultAccess("a1", fid); err != nil {
+	if err := store.GrantVaultAccess("a1", fid, nil, nil); err != nil {
 		t.Fatalf("grant vault access: %v", err)
 	}
 
 	r := gin.New()
 	verifier := testVerifier{identity: attestation.VerifiedIdentity{AppID: "a1"}}
 	collector := fakeCollector{bundle: attestation.Bundle{AppID: "server-app", AppCert: "pem"}}
-	r.POST("/v1/secrets/challenge", HandleIssueChallenge(store, true, verifier, collector))
-	r.POST("/v1/secrets/fetch", HandleFetchSecrets(store, true))
+	r.POST("/v1/secrets/challenge", HandleIssueChallenge(store, true, verifier, collector, nil))
+	r.POST("/v1/secrets/fetch", HandleFetchSecrets(store, true, nil))
 
-	return r, priv, fid
+	return r, store, priv, fid
 }
 
-func TestStrictFlow_ChallengeThenFetchState(t *testing.T) {
-	gin.SetMode(gin.TestMode)
-	r, priv, fid := setupStrictFlow(t)
+// strictFetch runs the strict challenge for fid, attested as dstack app a1,
+// then fetches refs.
+func strictFetch(t *testing.T, r *gin.Engine, priv [32]byte, fid string, refs ...string) *httptest.ResponseRecorder {
+	t.Helper()
+	challengeID, response := strictChallenge(t, r, priv, fid)
+
+	fetchReq, _ := json.Marshal(map[string]any{
+		"fid":                fid,
+		"secret_references":  refs,
+		"challenge_id":       challengeID,
+		"challenge_response": response,
+	})
+	w2 := httptest.NewRecorder()
+	req2 := httptest.NewRequest(http.MethodPost, "/v1/secrets/fetch", bytes.NewReader(fetchReq))
+	req2.Header.Set("Content-Type", "application/json")
+	r.ServeHTTP(w2, req2)
+	return w2
+}
+
+// strictChallenge requests a challenge for fid with a client attestation and
+// returns its ID and the base64 answer decrypted with priv.
+func strictChallenge(t *testing.T, r *gin.Engine, priv [32]byte, fid string) (string, string) {
+	t.Helper()
 
 	chReq, _ := json.Marshal(map[string]any{
 		"fid": fid,
@@ -107,17 +123,14 @@ func TestStrictFlow_ChallengeThenFetchState(t *testing.T) {
 	if err != nil {
 		t.Fatalf("decrypt challenge: %v", err)
 	}
+	return challengeID, base64.StdEncoding.EncodeToString(plain)
+}
 
-	fetchReq, _ := json.Marshal(map[string]any{
-		"fid":                fid,
-		"secret_references":  []string{"jingui://a1/u1/client_id"},
-		"challenge_id":       challengeID,
-		"challenge_response": base64.StdEncoding.EncodeToString(plain),
-	})
-	w2 := httptest.NewRecorder()
-	req2 := httptest.NewRequest(http.MethodPost, "/v1/secrets/fetch", bytes.NewReader(fetchReq))
-	req2.Header.Set("Content-Type", "application/json")
-	r.ServeHTTP(w2, req2)
+func TestStrictFlow_ChallengeThenFetchState(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r, _, priv, fid := setupStrictFlow(t)
+
+	w2 := strictFetch(t, r, priv, fid, "jingui://a1/u1/client_id")
 	// With real data now, fetch should succeed
 	if w2.Code == http.StatusUnauthorized {
 		t.Fatalf("expected non-401 after RA-verified challenge, got body=%s", w2.Body.String())
@@ -126,3 +139,29 @@ func TestStrictFlow_ChallengeThenFetchState(t *testing.T) {
 		t.Fatalf("expected 200, got %d body=%s", w2.Code, w2.Body.String())
 	}
 }
+
+func TestStrictFlow_AppGrant(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r, store, priv, fid := setupStrictFlow(t)
+	store.RevokeVaultAccess("a1", fid)
+
+	if w := strictFetch(t, r, priv, fid, "jingui://a1/u1/client_id"); w.Code != http.StatusForbidden {
+		t.Fatalf("without any grant: expected 403, got %d body=%s", w.Code, w.Body.String())
+	}
+
+	if err := store.GrantAppAccess("a1", "other-app", nil, nil); err != nil {
+		t.Fatalf("GrantAppAccess: %v", err)
+	}
+	if w := strictFetch(t, r, priv, fid, "jingui://a1/u1/client_id"); w.Code != http.StatusForbidden {
+		t.Fatalf("grant to another app: expected 403, got %d body=%s", w.Code, w.Body.String())
+	}
+
+	// The instance is attested as app a1, so a grant to a1 covers it
+	// without a grant to its FID.
+	if err := store.GrantAppAccess("a1", "a1", nil, nil); err != nil {
+		t.Fatalf("GrantAppAccess: %v", err)
+	}
+	if w := strictFetch(t, r, priv, fid, "jingui://a1/u1/client_id"); w.Code != http.StatusOK {
+		t.Fatalf("grant to the attested app: expected 200, got %d body=%s", w.Code, w.Body.String())
+	}
+}
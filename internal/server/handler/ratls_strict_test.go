@@ -13,11 +13,7 @@ import (
 )
 
 func newStrictChallengeRouter(t *testing.T) *gin.Engine {
-	store, err := db.NewStore(":memory:")
-	if err != nil {
-		t.Fatalf("new store: %v", err)
-	}
-	t.Cleanup(func() { _ = store.Close() })
+	store := db.NewMemoryStore()
 
 	if err := store.CreateVault(&db.Vault{ID: "a1", Name: "app"}); err != nil {
 		t.Fatalf("create vault: %v", err)
@@ -25,12 +21,12 @@ func newStrictChallengeRouter(t *testing.T) *gin.Engine {
 	if err := store.RegisterInstance(&db.TEEInstance{FID: "f1", PublicKey: bytes.Repeat([]byte{2}, 32), DstackAppID: "a1"}); err != nil {
 		t.Fatalf("register instance: %v", err)
 	}
-	if err := store.GrantVaultAccess("a1", "f1"); err != nil {
+	if err := store.GrantVaultAccess("a1", "f1", nil, nil); err != nil {
 		t.Fatalf("grant vault access: %v", err)
 	}
 
 	r := gin.New()
-	r.POST("/v1/secrets/challenge", HandleIssueChallenge(store, true, attestation.NewRATLSVerifier(), attestation.NewDstackInfoCollector("")))
+	r.POST("/v1/secrets/challenge", HandleIssueChallenge(store, true, attestation.NewRATLSVerifier(), attestation.NewDstackInfoCollector(""), nil))
 	return r
 }
 
@@ -93,11 +89,7 @@ func TestIssueChallenge_StrictRejectsMismatchedClientAttestationAppID(t *testing
 func TestIssueChallenge_StrictRejectsEmptyVerifiedAppID(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 
-	store, err := db.NewStore(":memory:")
-	if err != nil {
-		t.Fatalf("new store: %v", err)
-	}
-	t.Cleanup(func() { _ = store.Close() })
+	store := db.NewMemoryStore()
 
 	if err := store.CreateVault(&db.Vault{ID: "a1", Name: "app"}); err != nil {
 		t.Fatalf("create vault: %v", err)
@@ -105,7 +97,7 @@ func TestIssueChallenge_StrictRejectsEmptyVerifiedAppID(t *testing.T) {
 	if err := store.RegisterInstance(&db.TEEInstance{FID: "f1", PublicKey: bytes.Repeat([]byte{2}, 32), DstackAppID: "a1"}); err != nil {
 		t.Fatalf("register instance: %v", err)
 	}
-	if err := store.GrantVaultAccess("a1", "f1"); err != nil {
+	if err := store.GrantVaultAccess("a1", "f1", nil, nil); err != nil {
 		t.Fatalf("grant vault access: %v", err)
 	}
 
@@ -113,7 +105,7 @@ func TestIssueChallenge_StrictRejectsEmptyVerifiedAppID(t *testing.T) {
 	verifier := testVerifier{identity: attestation.VerifiedIdentity{AppID: ""}}
 	collector := fakeCollector{bundle: attestation.Bundle{AppID: "server-app", AppCert: "pem"}}
 	r := gin.New()
-	r.POST("/v1/secrets/challenge", HandleIssueChallenge(store, true, verifier, collector))
+	r.POST("/v1/secrets/challenge", HandleIssueChallenge(store, true, verifier, collector, nil))
 
 	body, _ := json.Marshal(map[string]any{
 		"fid": "f1",
@@ -0,0 +1,104 @@
+package handler
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/aspect-build/jingui/internal/server/db"
+	"github.com/aspect-build/jingui/internal/server/eventsink"
+	"github.com/gin-gonic/gin"
+)
+
+type rekeyInstanceRequest struct {
+	FID               string `json:"fid" binding:"required"`
+	ChallengeID       string `json:"challenge_id" binding:"required"`
+	ChallengeResponse string `json:"challenge_response" binding:"required"`
+	NewPublicKey      string `json:"new_public_key" binding:"required"`
+}
+
+// HandleRekeyInstance handles POST /v1/rekey — an instance replaces its public
+// key. It proves possession of the current key by answering a challenge from
+// POST /v1/secrets/challenge, which in strict mode also checks its
+// attestation. The instance moves to the FID of the new key with its grants
+// and debug policies; the old FID becomes an alias and cannot fetch secrets
+// or be registered again.
+func HandleRekeyInstance(store db.Store, strict bool, sinks *eventsink.Dispatcher) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req rekeyInstanceRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		ev := auditEvent(c)
+		ev.FID = req.FID
+
+		newPubKey, err := hex.DecodeString(req.NewPublicKey)
+		if err != nil || len(newPubKey) != 32 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "new_public_key must be 64 hex characters (32 bytes)"})
+			return
+		}
+		challengeResponse, err := base64.StdEncoding.DecodeString(req.ChallengeResponse)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "challenge_response must be valid base64"})
+			return
+		}
+		challenge, err := fetchChallengeStore.consume(req.ChallengeID, req.FID, challengeResponse, strict, time.Now())
+		if err != nil {
+			ratlsRejected(c, sinks, "rekey", req.FID, "challenge verification failed", "challenge_id", req.ChallengeID, "err", err.Error())
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "challenge verification failed: " + err.Error()})
+			return
+		}
+		ev.AppID = challenge.AppID
+
+		inst, err := store.GetInstance(req.FID)
+		if err != nil {
+			log.Printf("GetInstance(%q) error: %v", req.FID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+			return
+		}
+		if inst == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "instance not found"})
+			return
+		}
+		if instanceInactive(c, sinks, "rekey", inst) {
+			return
+		}
+		if bytes.Equal(inst.PublicKey, newPubKey) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "new_public_key is the current key"})
+			return
+		}
+
+		h := sha1.Sum(newPubKey)
+		newFID := hex.EncodeToString(h[:])
+		ev.Target = "/v1/instances/" + newFID
+		ok, err := store.RekeyInstance(req.FID, newFID, newPubKey)
+		if err != nil {
+			if errors.Is(err, db.ErrInTrash) {
+				respondInTrash(c, err)
+				return
+			}
+			switch err {
+			case db.ErrInstanceDuplicateFID, db.ErrInstanceDuplicateKey:
+				c.JSON(http.StatusConflict, gin.H{"error": "another instance with this public key already exists"})
+			case db.ErrInstanceRetired:
+				c.JSON(http.StatusConflict, gin.H{"error": "new_public_key was retired by an earlier re-key"})
+			default:
+				log.Printf("RekeyInstance(%q) error: %v", req.FID, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+			}
+			return
+		}
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": "instance not found"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"status": "rekeyed", "fid": newFID, "previous_fid": req.FID})
+	}
+}
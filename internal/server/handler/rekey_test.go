@@ -0,0 +1,68 @@
+package handler
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/curve25519"
+)
+
+func TestRekeyInstance(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r, store, priv, fid := setupStrictFlow(t)
+	r.POST("/v1/rekey", HandleRekeyInstance(store, true, nil))
+	store.UpsertDebugPolicy("a1", fid, true)
+
+	var newPriv [32]byte
+	for i := range newPriv {
+		newPriv[i] = byte(i + 50)
+	}
+	newPub, err := curve25519.X25519(newPriv[:], curve25519.Basepoint)
+	if err != nil {
+		t.Fatalf("pub derive: %v", err)
+	}
+	h := sha1.Sum(newPub)
+	newFID := hex.EncodeToString(h[:])
+	rekey := func(challengeID, response string, pub []byte) int {
+		w := postJSON(r, "/v1/rekey", map[string]any{
+			"fid":                fid,
+			"challenge_id":       challengeID,
+			"challenge_response": response,
+			"new_public_key":     hex.EncodeToString(pub),
+		})
+		return w.Code
+	}
+
+	// The challenge must be answered with the current key.
+	challengeID, _ := strictChallenge(t, r, priv, fid)
+	if code := rekey(challengeID, "d3Jvbmc=", newPub); code != http.StatusUnauthorized {
+		t.Errorf("rekey with a wrong answer: status=%d, want 401", code)
+	}
+	oldInst, _ := store.GetInstance(fid)
+	challengeID, response := strictChallenge(t, r, priv, fid)
+	if code := rekey(challengeID, response, oldInst.PublicKey); code != http.StatusBadRequest {
+		t.Errorf("rekey to the current key: status=%d, want 400", code)
+	}
+
+	challengeID, response = strictChallenge(t, r, priv, fid)
+	if code := rekey(challengeID, response, newPub); code != http.StatusOK {
+		t.Fatalf("rekey: status=%d", code)
+	}
+	if inst, _ := store.GetInstance(newFID); inst == nil || inst.DstackAppID != "a1" {
+		t.Fatalf("re-keyed instance = %+v", inst)
+	}
+	if p, _ := store.GetDebugPolicy("a1", newFID); p == nil || !p.AllowRead {
+		t.Errorf("debug policy = %+v, want it moved to the new FID", p)
+	}
+
+	// The new key fetches; a challenge answered with the old one is useless.
+	if w := strictFetch(t, r, newPriv, newFID, "jingui://a1/u1/client_id"); w.Code != http.StatusOK {
+		t.Errorf("fetch with the new key: status=%d body=%s", w.Code, w.Body.String())
+	}
+	if code := rekey(challengeID, response, newPub); code != http.StatusUnauthorized {
+		t.Errorf("replayed rekey: status=%d, want 401", code)
+	}
+}
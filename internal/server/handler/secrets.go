@@ -17,6 +17,7 @@ import (
 	"github.com/aspect-build/jingui/internal/logx"
 	"github.com/aspect-build/jingui/internal/refparser"
 	"github.com/aspect-build/jingui/internal/server/db"
+	"github.com/aspect-build/jingui/internal/server/eventsink"
 	"github.com/gin-gonic/gin"
 )
 
@@ -50,6 +51,10 @@ type challengeEntry struct {
 	ExpiresAt  time.Time
 	RAVerified bool
 	StrictMode bool
+	AppID      string // verified client app ID, in strict mode
+
+	// Measurements are those of the verified client quote, in strict mode.
+	Measurements attestation.Measurements
 }
 
 type challengeStore struct {
@@ -61,7 +66,7 @@ var fetchChallengeStore = &challengeStore{
 	entries: make(map[string]challengeEntry),
 }
 
-func (s *challengeStore) issue(fid string, nonce []byte, raVerified bool, strictMode bool, now time.Time) (string, error) {
+func (s *challengeStore) issue(fid string, nonce []byte, raVerified bool, strictMode bool, appID string, m attestation.Measurements, now time.Time) (string, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -82,11 +87,16 @@ func (s *challengeStore) issue(fid string, nonce []byte, raVerified bool, strict
 		ExpiresAt:  now.Add(challengeTTL),
 		RAVerified: raVerified,
 		StrictMode: strictMode,
+		AppID:      appID,
+
+		Measurements: m,
 	}
 	return id, nil
 }
 
-func (s *challengeStore) consume(challengeID, fid string, response []byte, strictMode bool, now time.Time) error {
+// consume verifies and removes a challenge, returning it with the app ID and
+// measurements verified when it was issued.
+func (s *challengeStore) consume(challengeID, fid string, response []byte, strictMode bool, now time.Time) (challengeEntry, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -94,25 +104,25 @@ func (s *challengeStore) consume(challengeID, fid string, response []byte, stric
 
 	entry, ok := s.entries[challengeID]
 	if !ok {
-		return fmt.Errorf("challenge not found or expired")
+		return challengeEntry{}, fmt.Errorf("challenge not found or expired")
 	}
 	delete(s.entries, challengeID)
 
 	if entry.FID != fid {
-		return fmt.Errorf("challenge fid mismatch")
+		return challengeEntry{}, fmt.Errorf("challenge fid mismatch")
 	}
 	if strictMode {
 		if !entry.StrictMode {
-			return fmt.Errorf("challenge mode mismatch")
+			return challengeEntry{}, fmt.Errorf("challenge mode mismatch")
 		}
 		if !entry.RAVerified {
-			return fmt.Errorf("challenge is not RA-verified")
+			return challengeEntry{}, fmt.Errorf("challenge is not RA-verified")
 		}
 	}
 	if subtle.ConstantTimeCompare(entry.Nonce, response) != 1 {
-		return fmt.Errorf("invalid challenge response")
+		return challengeEntry{}, fmt.Errorf("invalid challenge response")
 	}
-	return nil
+	return entry, nil
 }
 
 func (s *challengeStore) gcLocked(now time.Time) {
@@ -123,14 +133,59 @@ func (s *challengeStore) gcLocked(now time.Time) {
 	}
 }
 
+// ratlsRejected logs a refused RA-TLS or challenge check and publishes it to
+// sinks as a security event. details are alternating keys and values.
+func ratlsRejected(c *gin.Context, sinks *eventsink.Dispatcher, step, fid, reason string, details ...string) {
+	ev := eventsink.Event{
+		Kind: eventsink.KindSecurity,
+		AuditEvent: db.AuditEvent{
+			Time:     time.Now().UTC(),
+			Action:   "ratls." + step,
+			Outcome:  db.AuditDenied,
+			FID:      fid,
+			SourceIP: c.ClientIP(),
+			Error:    reason,
+		},
+	}
+	var kv strings.Builder
+	for i := 0; i+1 < len(details); i += 2 {
+		if ev.Details == nil {
+			ev.Details = map[string]string{}
+		}
+		ev.Details[details[i]] = details[i+1]
+		fmt.Fprintf(&kv, " %s=%s", details[i], details[i+1])
+	}
+	logx.Warnf("ratls.server.%s rejected: %s fid=%s%s", step, reason, fid, kv.String())
+	sinks.Publish(ev)
+}
+
+// instanceInactive refuses a disabled or quarantined instance with 403,
+// publishing the attempt to sinks, and reports whether it did.
+func instanceInactive(c *gin.Context, sinks *eventsink.Dispatcher, step string, inst *db.TEEInstance) bool {
+	if inst.Status == db.InstanceActive {
+		return false
+	}
+	ratlsRejected(c, sinks, step, inst.FID, "instance "+inst.Status)
+	c.JSON(http.StatusForbidden, gin.H{
+		"error": "instance is " + inst.Status,
+		"hint":  "an admin must make it active again: PUT /v1/instances/" + inst.FID + "/status",
+	})
+	return true
+}
+
 // HandleIssueChallenge handles POST /v1/secrets/challenge.
-func HandleIssueChallenge(store *db.Store, strict bool, verifier attestation.Verifier, serverCollector attestation.Collector) gin.HandlerFunc {
+func HandleIssueChallenge(store db.Store, strict bool, verifier attestation.Verifier, serverCollector attestation.Collector, sinks *eventsink.Dispatcher) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var req issueChallengeRequest
 		if err := c.ShouldBindJSON(&req); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
+		ev := auditEvent(c)
+		ev.FID = req.FID
+		if req.ClientAttestation != nil {
+			ev.AppID = req.ClientAttestation.AppID
+		}
 
 		inst, err := store.GetInstance(req.FID)
 		if err != nil {
@@ -141,12 +196,19 @@ func HandleIssueChallenge(store *db.Store, strict bool, verifier attestation.Ver
 			c.JSON(http.StatusNotFound, gin.H{"error": "instance not found"})
 			return
 		}
+		if instanceInactive(c, sinks, "challenge", inst) {
+			return
+		}
 		if len(inst.PublicKey) != 32 {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "invalid instance public key length"})
 			return
 		}
 
-		var serverAtt *attestation.Bundle
+		var (
+			serverAtt     *attestation.Bundle
+			verifiedAppID string
+			measurements  attestation.Measurements
+		)
 		if strict {
 			logx.Debugf("ratls.server.challenge strict=true fid=%s dstack_app_id=%s", req.FID, inst.DstackAppID)
 			if verifier == nil {
@@ -154,42 +216,47 @@ func HandleIssueChallenge(store *db.Store, strict bool, verifier attestation.Ver
 				return
 			}
 			if req.ClientAttestation == nil {
-				logx.Warnf("ratls.server.challenge rejected: missing client_attestation fid=%s", req.FID)
+				ratlsRejected(c, sinks, "challenge", req.FID, "missing client_attestation")
 				c.JSON(http.StatusUnauthorized, gin.H{"error": "client_attestation is required in strict RA-TLS mode"})
 				return
 			}
 			if strings.TrimSpace(req.ClientAttestation.AppID) == "" {
-				logx.Warnf("ratls.server.challenge rejected: missing client_attestation.app_id fid=%s", req.FID)
+				ratlsRejected(c, sinks, "challenge", req.FID, "missing client_attestation.app_id")
 				c.JSON(http.StatusUnauthorized, gin.H{"error": "client_attestation.app_id is required in strict RA-TLS mode"})
 				return
 			}
 			if strings.TrimSpace(inst.DstackAppID) == "" {
-				logx.Warnf("ratls.server.challenge rejected: instance missing dstack_app_id fid=%s", req.FID)
+				ratlsRejected(c, sinks, "challenge", req.FID, "instance missing dstack_app_id")
 				c.JSON(http.StatusUnauthorized, gin.H{"error": "instance is missing dstack_app_id"})
 				return
 			}
 			if req.ClientAttestation.AppID != inst.DstackAppID {
-				logx.Warnf("ratls.server.challenge rejected: request app_id mismatch fid=%s attested_app_id=%s dstack_app_id=%s", req.FID, req.ClientAttestation.AppID, inst.DstackAppID)
+				ratlsRejected(c, sinks, "challenge", req.FID, "request app_id mismatch", "attested_app_id", req.ClientAttestation.AppID, "dstack_app_id", inst.DstackAppID)
 				c.JSON(http.StatusForbidden, gin.H{"error": "client attestation app_id mismatch"})
 				return
 			}
 
 			identity, err := verifier.Verify(c.Request.Context(), *req.ClientAttestation)
 			if err != nil {
-				logx.Warnf("ratls.server.challenge rejected: verify failed fid=%s err=%v", req.FID, err)
+				ratlsRejected(c, sinks, "challenge", req.FID, "verify failed", "err", err.Error())
 				c.JSON(http.StatusUnauthorized, gin.H{"error": "client attestation verification failed"})
 				return
 			}
 			if identity.AppID == "" {
-				logx.Warnf("ratls.server.challenge rejected: verified cert missing app_id extension fid=%s", req.FID)
+				ratlsRejected(c, sinks, "challenge", req.FID, "verified cert missing app_id extension")
 				c.JSON(http.StatusUnauthorized, gin.H{"error": "client attestation certificate does not contain app_id"})
 				return
 			}
 			if identity.AppID != inst.DstackAppID {
-				logx.Warnf("ratls.server.challenge rejected: verified app_id mismatch fid=%s verified_app_id=%s dstack_app_id=%s", req.FID, identity.AppID, inst.DstackAppID)
+				ratlsRejected(c, sinks, "challenge", req.FID, "verified app_id mismatch", "verified_app_id", identity.AppID, "dstack_app_id", inst.DstackAppID)
 				c.JSON(http.StatusForbidden, gin.H{"error": "client RA app_id mismatch"})
 				return
 			}
+			if measurementRejected(c, store, sinks, "challenge", inst, identity.Measurements) {
+				return
+			}
+			verifiedAppID = identity.AppID
+			measurements = identity.Measurements
 			logx.Debugf("ratls.server.challenge peer=client verified_app_id=%q instance_id=%q device_id=%q", identity.AppID, identity.InstanceID, identity.DeviceID)
 
 			if serverCollector == nil {
@@ -219,7 +286,7 @@ func HandleIssueChallenge(store *db.Store, strict bool, verifier attestation.Ver
 			return
 		}
 
-		challengeID, err := fetchChallengeStore.issue(req.FID, nonce, !strict || req.ClientAttestation != nil, strict, time.Now())
+		challengeID, err := fetchChallengeStore.issue(req.FID, nonce, !strict || req.ClientAttestation != nil, strict, verifiedAppID, measurements, time.Now())
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to issue challenge"})
 			return
@@ -234,7 +301,7 @@ func HandleIssueChallenge(store *db.Store, strict bool, verifier attestation.Ver
 }
 
 // HandleFetchSecrets handles POST /v1/secrets/fetch.
-func HandleFetchSecrets(store *db.Store, strict bool) gin.HandlerFunc {
+func HandleFetchSecrets(store db.Store, strict bool, sinks *eventsink.Dispatcher) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var req fetchSecretsRequest
 		if err := c.ShouldBindJSON(&req); err != nil {
@@ -242,16 +309,26 @@ func HandleFetchSecrets(store *db.Store, strict bool) gin.HandlerFunc {
 			return
 		}
 
+		// Recorded for the audit log; values are never added to it.
+		command := strings.ToLower(strings.TrimSpace(c.GetHeader("X-Jingui-Command")))
+		ev := auditEvent(c)
+		ev.FID = req.FID
+		ev.Refs = req.SecretReferences
+		ev.Command = command
+
 		challengeResponse, err := base64.StdEncoding.DecodeString(req.ChallengeResponse)
 		if err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "challenge_response must be valid base64"})
 			return
 		}
-		if err := fetchChallengeStore.consume(req.ChallengeID, req.FID, challengeResponse, strict, time.Now()); err != nil {
-			logx.Warnf("ratls.server.fetch rejected: challenge verification failed fid=%s challenge_id=%s err=%v", req.FID, req.ChallengeID, err)
+		challenge, err := fetchChallengeStore.consume(req.ChallengeID, req.FID, challengeResponse, strict, time.Now())
+		if err != nil {
+			ratlsRejected(c, sinks, "fetch", req.FID, "challenge verification failed", "challenge_id", req.ChallengeID, "err", err.Error())
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "challenge verification failed: " + err.Error()})
 			return
 		}
+		appID := challenge.AppID
+		ev.AppID = appID
 		if strict {
 			logx.Debugf("ratls.server.fetch strict challenge verification passed fid=%s challenge_id=%s", req.FID, req.ChallengeID)
 		}
@@ -266,6 +343,9 @@ func HandleFetchSecrets(store *db.Store, strict bool) gin.HandlerFunc {
 			c.JSON(http.StatusNotFound, gin.H{"error": "instance not found"})
 			return
 		}
+		if instanceInactive(c, sinks, "fetch", inst) {
+			return
+		}
 
 		// Update last used
 		_ = store.UpdateLastUsed(req.FID)
@@ -276,13 +356,14 @@ func HandleFetchSecrets(store *db.Store, strict bool) gin.HandlerFunc {
 
 		// Debug policy check: for "read" commands, check if any vault the instance
 		// has access to has a debug policy that disables read
-		command := strings.ToLower(strings.TrimSpace(c.GetHeader("X-Jingui-Command")))
 		if command == "read" {
 			// We'll check per-reference below; for the general check we need at least one ref
 			// Actually, we check per-vault in the loop below
 		}
 
 		secrets := make(map[string]string)
+		measured := measuredValues(challenge.Measurements)
+		measurementChecked := make(map[string]bool)
 
 		for _, refStr := range req.SecretReferences {
 			ref, err := refparser.Parse(refStr)
@@ -291,14 +372,28 @@ func HandleFetchSecrets(store *db.Store, strict bool) gin.HandlerFunc {
 				return
 			}
 
-			// Access control: check if instance has access to this vault
-			hasAccess, err := store.HasVaultAccess(ref.Vault, inst.FID)
+			// The vault's measurement policy is checked again here, since the
+			// grant serving this reference may postdate the challenge.
+			if strict && !measurementChecked[ref.Vault] {
+				if vaultMeasurementRejected(c, store, sinks, "fetch", inst.FID, ref.Vault, measured) {
+					return
+				}
+				measurementChecked[ref.Vault] = true
+			}
+
+			// Access control: the most specific field grant for this field
+			// decides, falling back to the instance's vault-wide grant or,
+			// with a verified app ID, a grant to its dstack app.
+			hasAccess, err := store.HasFieldAccess(ref.Vault, inst.FID, appID, ref.Item, ref.Section, ref.FieldName)
 			if err != nil {
 				c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
 				return
 			}
 			if !hasAccess {
-				c.JSON(http.StatusForbidden, gin.H{"error": "vault mismatch for reference: " + refStr})
+				c.JSON(http.StatusForbidden, gin.H{
+					"error": "access denied for reference: " + refStr,
+					"hint":  "grant the instance or its dstack app the vault, or a field grant covering this field",
+				})
 				return
 			}
 
@@ -315,15 +410,36 @@ func HandleFetchSecrets(store *db.Store, strict bool) gin.HandlerFunc {
 				}
 			}
 
-			// Map ref to DB: section=ref.Item, itemName=ref.FieldName
-			// For 4-segment refs: jingui://vault/item/section/field → section=item, itemName=field (section in ref becomes sub-group)
-			dbSection := ref.Item
-			dbItemName := ref.FieldName
+			// Expired secrets are refused outright, pinned versions included,
+			// so the caller sees why instead of a failure from upstream.
+			expiresAt, err := store.GetFieldExpiry(ref.Vault, ref.Item, ref.Section, ref.FieldName)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+				return
+			}
+			if expiresAt != nil && !expiresAt.After(time.Now()) {
+				c.JSON(http.StatusGone, gin.H{
+					"error":      "secret expired for reference: " + refStr,
+					"reference":  refStr,
+					"expired_at": expiresAt.UTC(),
+					"hint":       "rotate the secret, then clear or move its expiry date",
+				})
+				return
+			}
 
-			value, err := store.GetFieldValue(ref.Vault, dbSection, dbItemName)
+			// 3-segment refs address the item's default section (""),
+			// 4-segment refs the named section.
+			var value string
+			if ref.Version > 0 {
+				value, err = store.GetFieldVersionValue(ref.Vault, ref.Item, ref.Section, ref.FieldName, ref.Version)
+			} else {
+				value, err = store.GetFieldValue(ref.Vault, ref.Item, ref.Section, ref.FieldName)
+			}
 			if err != nil {
 				if errors.Is(err, db.ErrFieldNotFound) {
 					c.JSON(http.StatusNotFound, gin.H{"error": "field not found for reference: " + refStr})
+				} else if errors.Is(err, db.ErrVersionNotFound) {
+					c.JSON(http.StatusNotFound, gin.H{"error": "version not found for reference: " + refStr})
 				} else {
 					c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
 				}
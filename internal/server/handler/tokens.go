@@ -0,0 +1,277 @@
+package handler
+
+import (
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/aspect-build/jingui/internal/server/db"
+	"github.com/gin-gonic/gin"
+)
+
+// enrollmentTokenPrefix marks enrollment token secrets, so they are easy to
+// recognise in CI configuration and secret scanners.
+const enrollmentTokenPrefix = "jgt_"
+
+// hashEnrollmentToken returns the hash under which a token secret is stored.
+func hashEnrollmentToken(secret string) string {
+	h := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(h[:])
+}
+
+// enrollmentTokenView is an enrollment token as listed by the admin API. The
+// secret is only returned when the token is created.
+type enrollmentTokenView struct {
+	ID          string   `json:"id"`
+	Token       string   `json:"token,omitempty"`
+	DstackAppID string   `json:"dstack_app_id"`
+	Label       string   `json:"label"`
+	Vaults      []string `json:"vaults"`
+	MaxUses     int      `json:"max_uses"`
+	Uses        int      `json:"uses"`
+	ExpiresAt   string   `json:"expires_at"`
+	CreatedAt   string   `json:"created_at"`
+	// Status is "active", "used_up" or "expired".
+	Status string `json:"status"`
+}
+
+func newEnrollmentTokenView(t *db.EnrollmentToken, now time.Time) enrollmentTokenView {
+	vaults := t.Vaults
+	if vaults == nil {
+		vaults = []string{}
+	}
+	status := "active"
+	switch {
+	case t.Uses >= t.MaxUses:
+		status = "used_up"
+	case !t.ExpiresAt.After(now):
+		status = "expired"
+	}
+	return enrollmentTokenView{
+		ID:          t.ID,
+		DstackAppID: t.DstackAppID,
+		Label:       t.Label,
+		Vaults:      vaults,
+		MaxUses:     t.MaxUses,
+		Uses:        t.Uses,
+		ExpiresAt:   t.ExpiresAt.UTC().Format("2006-01-02T15:04:05Z"),
+		CreatedAt:   t.CreatedAt.UTC().Format("2006-01-02T15:04:05Z"),
+		Status:      status,
+	}
+}
+
+type createEnrollmentTokenRequest struct {
+	DstackAppID string    `json:"dstack_app_id" binding:"required"`
+	Label       string    `json:"label"`
+	Vaults      []string  `json:"vaults"`
+	MaxUses     int       `json:"max_uses"`
+	ExpiresAt   time.Time `json:"expires_at" binding:"required"`
+}
+
+// HandleCreateEnrollmentToken handles POST /v1/enrollment-tokens — mint a
+// token that registers up to max_uses (default 1) instances of a dstack app
+// before expires_at, labelled from the label template and granted the listed
+// vaults. The secret is in the response and cannot be retrieved again.
+func HandleCreateEnrollmentToken(store db.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req createEnrollmentTokenRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "hint": "expires_at must be an RFC 3339 timestamp"})
+			return
+		}
+		if req.MaxUses < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "max_uses must not be negative"})
+			return
+		}
+		if req.MaxUses == 0 {
+			req.MaxUses = 1
+		}
+		if !req.ExpiresAt.After(time.Now()) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "expires_at must be in the future"})
+			return
+		}
+		for _, vaultID := range req.Vaults {
+			if !vaultExists(c, store, vaultID) {
+				return
+			}
+		}
+
+		idBytes := make([]byte, 8)
+		secretBytes := make([]byte, 32)
+		if _, err := rand.Read(idBytes); err != nil {
+			log.Printf("generate enrollment token id error: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate token"})
+			return
+		}
+		if _, err := rand.Read(secretBytes); err != nil {
+			log.Printf("generate enrollment token secret error: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate token"})
+			return
+		}
+		secret := enrollmentTokenPrefix + base64.RawURLEncoding.EncodeToString(secretBytes)
+
+		t := &db.EnrollmentToken{
+			ID:          hex.EncodeToString(idBytes),
+			TokenHash:   hashEnrollmentToken(secret),
+			DstackAppID: req.DstackAppID,
+			Label:       req.Label,
+			Vaults:      req.Vaults,
+			MaxUses:     req.MaxUses,
+			ExpiresAt:   req.ExpiresAt.UTC(),
+		}
+		if err := store.CreateEnrollmentToken(t); err != nil {
+			log.Printf("CreateEnrollmentToken(%q) error: %v", req.DstackAppID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create enrollment token"})
+			return
+		}
+		stored, err := store.GetEnrollmentTokenByHash(t.TokenHash)
+		if err != nil || stored == nil {
+			log.Printf("GetEnrollmentTokenByHash(%q) error: %v", t.ID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve enrollment token"})
+			return
+		}
+		auditEvent(c).AppID = stored.DstackAppID
+
+		view := newEnrollmentTokenView(stored, time.Now())
+		view.Token = secret
+		c.JSON(http.StatusCreated, view)
+	}
+}
+
+// HandleListEnrollmentTokens handles GET /v1/enrollment-tokens.
+func HandleListEnrollmentTokens(store db.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tokens, err := store.ListEnrollmentTokens()
+		if err != nil {
+			log.Printf("ListEnrollmentTokens() error: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list enrollment tokens"})
+			return
+		}
+		now := time.Now()
+		views := make([]enrollmentTokenView, len(tokens))
+		for i := range tokens {
+			views[i] = newEnrollmentTokenView(&tokens[i], now)
+		}
+		c.JSON(http.StatusOK, views)
+	}
+}
+
+// HandleDeleteEnrollmentToken handles DELETE /v1/enrollment-tokens/:token_id.
+// Instances already registered with the token are kept.
+func HandleDeleteEnrollmentToken(store db.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("token_id")
+		deleted, err := store.DeleteEnrollmentToken(id)
+		if err != nil {
+			log.Printf("DeleteEnrollmentToken(%q) error: %v", id, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete enrollment token"})
+			return
+		}
+		if !deleted {
+			c.JSON(http.StatusNotFound, gin.H{"error": "enrollment token not found"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "deleted", "id": id})
+	}
+}
+
+type registerWithTokenRequest struct {
+	Token     string `json:"token" binding:"required"`
+	PublicKey string `json:"public_key" binding:"required"`
+	// DstackAppID, if set, must be the app the token is for.
+	DstackAppID string `json:"dstack_app_id"`
+}
+
+// HandleRegisterWithToken handles POST /v1/register — an instance registers
+// itself with an enrollment token instead of the admin token. Registering
+// again with the same key is a no-op and does not use up the token, but the
+// token must still be redeemable.
+func HandleRegisterWithToken(store db.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req registerWithTokenRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		pubKey, err := hex.DecodeString(req.PublicKey)
+		if err != nil || len(pubKey) != 32 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "public_key must be 64 hex characters (32 bytes)"})
+			return
+		}
+		h := sha1.Sum(pubKey)
+		fid := hex.EncodeToString(h[:])
+		ev := auditEvent(c)
+		ev.FID = fid
+
+		token, err := store.GetEnrollmentTokenByHash(hashEnrollmentToken(req.Token))
+		if err != nil {
+			log.Printf("GetEnrollmentTokenByHash() error: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+			return
+		}
+		// A spent token is refused like an unknown one, so it cannot be used
+		// to probe which keys are registered.
+		if token == nil || !token.Redeemable(time.Now()) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid enrollment token"})
+			return
+		}
+		ev.Target = "/v1/enrollment-tokens/" + token.ID
+		ev.AppID = token.DstackAppID
+		if req.DstackAppID != "" && req.DstackAppID != token.DstackAppID {
+			c.JSON(http.StatusForbidden, gin.H{"error": "enrollment token is for another dstack app"})
+			return
+		}
+
+		existing, err := store.GetInstance(fid)
+		if err != nil {
+			log.Printf("GetInstance(%q) error: %v", fid, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+			return
+		}
+		if existing != nil {
+			if existing.DstackAppID != token.DstackAppID {
+				c.JSON(http.StatusConflict, gin.H{"error": "instance is registered to another dstack app"})
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{"fid": fid, "status": "already_registered", "dstack_app_id": existing.DstackAppID, "label": existing.Label})
+			return
+		}
+
+		inst := &db.TEEInstance{
+			FID:         fid,
+			PublicKey:   pubKey,
+			DstackAppID: token.DstackAppID,
+			Label:       expandLabel(token.Label, fid, ""),
+		}
+		if err := store.RedeemEnrollmentToken(token.ID, inst); err != nil {
+			if errors.Is(err, db.ErrInTrash) {
+				respondInTrash(c, err)
+				return
+			}
+			switch err {
+			case db.ErrEnrollmentTokenSpent:
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "enrollment token is expired, used up or revoked"})
+			case db.ErrInstanceDuplicateFID, db.ErrInstanceDuplicateKey:
+				c.JSON(http.StatusConflict, gin.H{"error": "instance already registered"})
+			case db.ErrInstanceRetired:
+				c.JSON(http.StatusConflict, gin.H{"error": "public key was retired by a re-key"})
+			default:
+				log.Printf("RedeemEnrollmentToken(%q) error: %v", token.ID, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+			}
+			return
+		}
+
+		vaults := token.Vaults
+		if vaults == nil {
+			vaults = []string{}
+		}
+		c.JSON(http.StatusCreated, gin.H{"fid": fid, "status": "registered", "dstack_app_id": inst.DstackAppID, "label": inst.Label, "vaults": vaults})
+	}
+}
@@ -0,0 +1,149 @@
+package handler
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aspect-build/jingui/internal/server/db"
+	"github.com/gin-gonic/gin"
+)
+
+func postJSON(r *gin.Engine, path string, body any) *httptest.ResponseRecorder {
+	b, _ := json.Marshal(body)
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(b))
+	req.Header.Set("Content-Type", "application/json")
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestCreateEnrollmentToken(t *testing.T) {
+	store := db.NewMemoryStore()
+	store.CreateVault(&db.Vault{ID: "v1", Name: "V1"})
+	r := gin.New()
+	r.POST("/v1/enrollment-tokens", HandleCreateEnrollmentToken(store))
+	r.GET("/v1/enrollment-tokens", HandleListEnrollmentTokens(store))
+	expires := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+
+	for name, body := range map[string]map[string]any{
+		"past expiry":       {"dstack_app_id": "app1", "expires_at": "2020-01-01T00:00:00Z"},
+		"negative max uses": {"dstack_app_id": "app1", "expires_at": expires, "max_uses": -1},
+		"no app":            {"expires_at": expires},
+	} {
+		if w := postJSON(r, "/v1/enrollment-tokens", body); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: status=%d body=%s, want 400", name, w.Code, w.Body.String())
+		}
+	}
+	if w := postJSON(r, "/v1/enrollment-tokens", map[string]any{"dstack_app_id": "app1", "expires_at": expires, "vaults": []string{"missing"}}); w.Code != http.StatusNotFound {
+		t.Errorf("missing vault: status=%d body=%s, want 404", w.Code, w.Body.String())
+	}
+
+	w := postJSON(r, "/v1/enrollment-tokens", map[string]any{"dstack_app_id": "app1", "label": "ci-{fid}", "expires_at": expires, "vaults": []string{"v1"}})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create: status=%d body=%s", w.Code, w.Body.String())
+	}
+	var created enrollmentTokenView
+	json.Unmarshal(w.Body.Bytes(), &created)
+	if !strings.HasPrefix(created.Token, enrollmentTokenPrefix) || created.MaxUses != 1 || created.Status != "active" {
+		t.Errorf("created token = %+v, want a single-use active token", created)
+	}
+	if stored, _ := store.GetEnrollmentTokenByHash(hashEnrollmentToken(created.Token)); stored == nil || stored.ID != created.ID {
+		t.Errorf("token not stored under the hash of its secret: %+v", stored)
+	}
+
+	// The secret is never listed.
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/enrollment-tokens", nil))
+	if w.Code != http.StatusOK || strings.Contains(w.Body.String(), created.Token) || !strings.Contains(w.Body.String(), created.ID) {
+		t.Errorf("list: status=%d body=%s", w.Code, w.Body.String())
+	}
+}
+
+func TestRegisterWithToken(t *testing.T) {
+	store := db.NewMemoryStore()
+	store.CreateVault(&db.Vault{ID: "v1", Name: "V1"})
+	secret := enrollmentTokenPrefix + "secret"
+	store.CreateEnrollmentToken(&db.EnrollmentToken{
+		ID: "t1", TokenHash: hashEnrollmentToken(secret), DstackAppID: "app1", Label: "ci-{fid}",
+		MaxUses: 1, ExpiresAt: time.Now().Add(time.Hour), Vaults: []string{"v1"},
+	})
+	r := gin.New()
+	r.POST("/v1/register", HandleRegisterWithToken(store))
+
+	pub := bytes.Repeat([]byte{7}, 32)
+	h := sha1.Sum(pub)
+	fid := hex.EncodeToString(h[:])
+	register := func(token string, pub []byte, appID string) *httptest.ResponseRecorder {
+		return postJSON(r, "/v1/register", map[string]any{"token": token, "public_key": hex.EncodeToString(pub), "dstack_app_id": appID})
+	}
+
+	if w := register("jgt_wrong", pub, ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("unknown token: status=%d body=%s", w.Code, w.Body.String())
+	}
+	if w := register(secret, pub, "app2"); w.Code != http.StatusForbidden {
+		t.Errorf("token for another app: status=%d body=%s", w.Code, w.Body.String())
+	}
+
+	w := register(secret, pub, "app1")
+	if w.Code != http.StatusCreated {
+		t.Fatalf("register: status=%d body=%s", w.Code, w.Body.String())
+	}
+	inst, _ := store.GetInstance(fid)
+	if inst == nil || inst.DstackAppID != "app1" || inst.Label != "ci-"+fid {
+		t.Fatalf("registered instance = %+v", inst)
+	}
+	if ok, _ := store.HasVaultAccess("v1", fid); !ok {
+		t.Error("expected the token's vault to be granted")
+	}
+
+	// The token is used up, so it cannot register another key or tell
+	// whether this one is registered.
+	if w := register(secret, bytes.Repeat([]byte{8}, 32), ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("used-up token: status=%d body=%s", w.Code, w.Body.String())
+	}
+	if w := register(secret, pub, ""); w.Code != http.StatusUnauthorized || bytes.Contains(w.Body.Bytes(), []byte("already_registered")) {
+		t.Errorf("used-up token with a registered key: status=%d body=%s", w.Code, w.Body.String())
+	}
+}
+
+func TestRegisterWithToken_AlreadyRegistered(t *testing.T) {
+	store := db.NewMemoryStore()
+	multi, expired := enrollmentTokenPrefix+"multi", enrollmentTokenPrefix+"expired"
+	store.CreateEnrollmentToken(&db.EnrollmentToken{
+		ID: "t1", TokenHash: hashEnrollmentToken(multi), DstackAppID: "app1", MaxUses: 5, ExpiresAt: time.Now().Add(time.Hour),
+	})
+	store.CreateEnrollmentToken(&db.EnrollmentToken{
+		ID: "t2", TokenHash: hashEnrollmentToken(expired), DstackAppID: "app1", MaxUses: 5, ExpiresAt: time.Now().Add(-time.Minute),
+	})
+	r := gin.New()
+	r.POST("/v1/register", HandleRegisterWithToken(store))
+	pub := bytes.Repeat([]byte{7}, 32)
+	h := sha1.Sum(pub)
+	store.RegisterInstance(&db.TEEInstance{FID: hex.EncodeToString(h[:]), PublicKey: pub, DstackAppID: "app1", Label: "secret-label"})
+	register := func(token string) *httptest.ResponseRecorder {
+		return postJSON(r, "/v1/register", map[string]any{"token": token, "public_key": hex.EncodeToString(pub)})
+	}
+
+	// Registering again with a live token is a no-op and does not use it.
+	if w := register(multi); w.Code != http.StatusOK || !bytes.Contains(w.Body.Bytes(), []byte("already_registered")) {
+		t.Errorf("live token: status=%d body=%s", w.Code, w.Body.String())
+	}
+	if tok, _ := store.GetEnrollmentTokenByHash(hashEnrollmentToken(multi)); tok == nil || tok.Uses != 0 {
+		t.Errorf("token after a no-op registration = %+v, want 0 uses", tok)
+	}
+
+	// An expired or revoked token does not reveal the instance.
+	store.DeleteEnrollmentToken("t1")
+	for name, token := range map[string]string{"revoked": multi, "expired": expired} {
+		if w := register(token); w.Code != http.StatusUnauthorized || bytes.Contains(w.Body.Bytes(), []byte("secret-label")) {
+			t.Errorf("%s token with a registered key: status=%d body=%s", name, w.Code, w.Body.String())
+		}
+	}
+}
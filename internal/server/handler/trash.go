@@ -0,0 +1,184 @@
+package handler
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/aspect-build/jingui/internal/server/db"
+	"github.com/gin-gonic/gin"
+)
+
+// trashView is a trash entry as listed by the admin API.
+type trashView struct {
+	db.TrashEntry
+	PurgeAt time.Time `json:"purge_at"`
+}
+
+// respondInTrash reports a write that targets a vault, section or instance
+// still in the trash.
+func respondInTrash(c *gin.Context, err error) {
+	c.JSON(http.StatusConflict, gin.H{
+		"error": err.Error(),
+		"hint":  "restore it from the trash or purge it first; see GET /v1/trash",
+	})
+}
+
+// HandleListTrash handles GET /v1/trash — deleted vaults, sections and
+// instances with the time each one is purged after the retention period.
+func HandleListTrash(store db.Store, retention time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		entries, err := store.ListTrash()
+		if err != nil {
+			log.Printf("ListTrash error: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list trash"})
+			return
+		}
+		views := make([]trashView, len(entries))
+		for i, e := range entries {
+			views[i] = trashView{TrashEntry: e, PurgeAt: e.DeletedAt.Add(retention)}
+		}
+		c.JSON(http.StatusOK, views)
+	}
+}
+
+// HandleRestoreVault handles POST /v1/trash/vaults/:id/restore.
+func HandleRestoreVault(store db.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+		restored, err := store.RestoreVault(id)
+		if err != nil {
+			log.Printf("RestoreVault(%q) error: %v", id, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to restore vault"})
+			return
+		}
+		if !restored {
+			c.JSON(http.StatusNotFound, gin.H{"error": "vault not in trash"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "restored", "id": id})
+	}
+}
+
+// HandlePurgeVault handles DELETE /v1/trash/vaults/:id — delete a trashed
+// vault and everything in it for good.
+func HandlePurgeVault(store db.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+		purged, err := store.PurgeVault(id)
+		if err != nil {
+			log.Printf("PurgeVault(%q) error: %v", id, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to purge vault"})
+			return
+		}
+		if !purged {
+			c.JSON(http.StatusNotFound, gin.H{"error": "vault not in trash"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "purged", "id": id})
+	}
+}
+
+// HandleRestoreItem handles POST /v1/trash/vaults/:id/items/:item/restore.
+// Without a ?section= query every trashed section of the item is restored.
+func HandleRestoreItem(store db.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		vaultID := c.Param("id")
+		item := c.Param("item")
+
+		var (
+			restored bool
+			err      error
+		)
+		section, hasSection := c.GetQuery("section")
+		if hasSection {
+			restored, err = store.RestoreSection(vaultID, item, section)
+		} else {
+			restored, err = store.RestoreItem(vaultID, item)
+		}
+		if err != nil {
+			log.Printf("RestoreItem(%q, %q, %q) error: %v", vaultID, item, section, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to restore item"})
+			return
+		}
+		if !restored {
+			c.JSON(http.StatusNotFound, gin.H{"error": trashTarget(hasSection) + " not in trash"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "restored"})
+	}
+}
+
+// HandlePurgeItem handles DELETE /v1/trash/vaults/:id/items/:item. Without a
+// ?section= query every trashed section of the item is purged.
+func HandlePurgeItem(store db.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		vaultID := c.Param("id")
+		item := c.Param("item")
+
+		var (
+			purged bool
+			err    error
+		)
+		section, hasSection := c.GetQuery("section")
+		if hasSection {
+			purged, err = store.PurgeSection(vaultID, item, section)
+		} else {
+			purged, err = store.PurgeItem(vaultID, item)
+		}
+		if err != nil {
+			log.Printf("PurgeItem(%q, %q, %q) error: %v", vaultID, item, section, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to purge item"})
+			return
+		}
+		if !purged {
+			c.JSON(http.StatusNotFound, gin.H{"error": trashTarget(hasSection) + " not in trash"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "purged"})
+	}
+}
+
+func trashTarget(hasSection bool) string {
+	if hasSection {
+		return "section"
+	}
+	return "item"
+}
+
+// HandleRestoreInstance handles POST /v1/trash/instances/:fid/restore.
+func HandleRestoreInstance(store db.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		fid := c.Param("fid")
+		restored, err := store.RestoreInstance(fid)
+		if err != nil {
+			log.Printf("RestoreInstance(%q) error: %v", fid, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to restore instance"})
+			return
+		}
+		if !restored {
+			c.JSON(http.StatusNotFound, gin.H{"error": "instance not in trash"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "restored", "fid": fid})
+	}
+}
+
+// HandlePurgeInstance handles DELETE /v1/trash/instances/:fid — delete a
+// trashed instance and its grants for good.
+func HandlePurgeInstance(store db.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		fid := c.Param("fid")
+		purged, err := store.PurgeInstance(fid)
+		if err != nil {
+			log.Printf("PurgeInstance(%q) error: %v", fid, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to purge instance"})
+			return
+		}
+		if !purged {
+			c.JSON(http.StatusNotFound, gin.H{"error": "instance not in trash"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "purged", "fid": fid})
+	}
+}
@@ -10,25 +10,32 @@ import (
 )
 
 type createVaultRequest struct {
-	ID   string `json:"id" binding:"required"`
-	Name string `json:"name" binding:"required"`
+	ID               string `json:"id" binding:"required"`
+	Name             string `json:"name" binding:"required"`
+	VersionRetention int    `json:"version_retention" binding:"min=0"`
 }
 
 // HandleCreateVault handles POST /v1/vaults.
-func HandleCreateVault(store *db.Store) gin.HandlerFunc {
+func HandleCreateVault(store db.Store) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var req createVaultRequest
 		if err := c.ShouldBindJSON(&req); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
+		auditEvent(c).VaultID = req.ID
 
 		v := &db.Vault{
-			ID:   req.ID,
-			Name: req.Name,
+			ID:               req.ID,
+			Name:             req.Name,
+			VersionRetention: req.VersionRetention,
 		}
 
 		if err := store.CreateVault(v); err != nil {
+			if errors.Is(err, db.ErrInTrash) {
+				respondInTrash(c, err)
+				return
+			}
 			if err == db.ErrVaultDuplicate {
 				c.JSON(http.StatusConflict, gin.H{
 					"error": "vault already exists",
@@ -46,7 +53,7 @@ func HandleCreateVault(store *db.Store) gin.HandlerFunc {
 }
 
 // HandleGetVault handles GET /v1/vaults/:id.
-func HandleGetVault(store *db.Store) gin.HandlerFunc {
+func HandleGetVault(store db.Store) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		id := c.Param("id")
 		v, err := store.GetVault(id)
@@ -64,11 +71,12 @@ func HandleGetVault(store *db.Store) gin.HandlerFunc {
 }
 
 type updateVaultRequest struct {
-	Name string `json:"name" binding:"required"`
+	Name             string `json:"name" binding:"required"`
+	VersionRetention *int   `json:"version_retention" binding:"omitempty,min=0"`
 }
 
 // HandleUpdateVault handles PUT /v1/vaults/:id.
-func HandleUpdateVault(store *db.Store) gin.HandlerFunc {
+func HandleUpdateVault(store db.Store) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		id := c.Param("id")
 		var req updateVaultRequest
@@ -87,12 +95,19 @@ func HandleUpdateVault(store *db.Store) gin.HandlerFunc {
 			c.JSON(http.StatusNotFound, gin.H{"error": "vault not found"})
 			return
 		}
+		if req.VersionRetention != nil {
+			if _, err := store.SetVersionRetention(id, *req.VersionRetention); err != nil {
+				log.Printf("SetVersionRetention(%q) error: %v", id, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update vault"})
+				return
+			}
+		}
 		c.JSON(http.StatusOK, gin.H{"id": id, "status": "updated"})
 	}
 }
 
 // HandleListVaults handles GET /v1/vaults.
-func HandleListVaults(store *db.Store) gin.HandlerFunc {
+func HandleListVaults(store db.Store) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		vaults, err := store.ListVaults()
 		if err != nil {
@@ -107,8 +122,9 @@ func HandleListVaults(store *db.Store) gin.HandlerFunc {
 	}
 }
 
-// HandleDeleteVault handles DELETE /v1/vaults/:id.
-func HandleDeleteVault(store *db.Store) gin.HandlerFunc {
+// HandleDeleteVault handles DELETE /v1/vaults/:id — move the vault to the
+// trash. Without ?cascade=true only an empty vault can be deleted.
+func HandleDeleteVault(store db.Store) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		id := c.Param("id")
 		cascade := c.Query("cascade") == "true"
@@ -0,0 +1,158 @@
+package server
+
+import (
+	"context"
+	"crypto/hkdf"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/aspect-build/jingui/internal/attestation"
+	"github.com/aspect-build/jingui/internal/server/db"
+)
+
+// KeyProvider supplies the at-rest master key used to wrap field data keys.
+type KeyProvider interface {
+	// Name identifies the provider in logs, e.g. "env:JINGUI_MASTER_KEY".
+	Name() string
+	// MasterKey returns a db.MasterKeyLen-byte key. It must return the same
+	// key every time for the same configuration.
+	MasterKey(ctx context.Context) ([]byte, error)
+}
+
+// EnvKeyProvider reads a hex-encoded master key from an environment variable.
+type EnvKeyProvider struct {
+	Var string
+}
+
+func (p *EnvKeyProvider) Name() string { return "env:" + p.Var }
+
+func (p *EnvKeyProvider) MasterKey(_ context.Context) ([]byte, error) {
+	raw := strings.TrimSpace(os.Getenv(p.Var))
+	if raw == "" {
+		return nil, fmt.Errorf("%s is empty", p.Var)
+	}
+	return decodeMasterKey(p.Var, raw)
+}
+
+// FileKeyProvider reads a hex-encoded master key from a file, e.g. a mounted
+// secret.
+type FileKeyProvider struct {
+	Path string
+}
+
+func (p *FileKeyProvider) Name() string { return "file:" + p.Path }
+
+func (p *FileKeyProvider) MasterKey(_ context.Context) ([]byte, error) {
+	data, err := os.ReadFile(p.Path)
+	if err != nil {
+		return nil, fmt.Errorf("read master key file: %w", err)
+	}
+	return decodeMasterKey(p.Path, strings.TrimSpace(string(data)))
+}
+
+// dstackKeyPurpose is passed to GetKey so the derived key cannot collide with
+// keys derived for other purposes on the same path.
+const dstackKeyPurpose = "jingui-master-key"
+
+// DstackKeyProvider derives the master key from the dstack guest agent when
+// jingui-server itself runs inside a CVM. The key never exists outside the
+// TEE: it is re-derived on every start and is bound to the server's app
+// identity, so a copied database cannot be decrypted elsewhere.
+type DstackKeyProvider struct {
+	Deriver attestation.KeyDeriver
+	Path    string
+}
+
+func (p *DstackKeyProvider) Name() string { return "dstack:" + p.Path }
+
+func (p *DstackKeyProvider) MasterKey(ctx context.Context) ([]byte, error) {
+	secret, err := p.Deriver.DeriveKey(ctx, p.Path, dstackKeyPurpose)
+	if err != nil {
+		return nil, err
+	}
+	// GetKey returns a secp256k1 private key; stretch it into an AES key
+	// rather than using it directly.
+	key, err := hkdf.Key(sha256.New, secret, nil, "jingui master key", db.MasterKeyLen)
+	if err != nil {
+		return nil, fmt.Errorf("derive master key: %w", err)
+	}
+	return key, nil
+}
+
+// loadKeyProvider selects the master key provider from JINGUI_MASTER_KEY_PROVIDER.
+// When unset, the provider is inferred from which of JINGUI_MASTER_KEY and
+// JINGUI_MASTER_KEY_FILE is set.
+func loadKeyProvider() (KeyProvider, error) {
+	envSet := os.Getenv("JINGUI_MASTER_KEY") != ""
+	filePath := os.Getenv("JINGUI_MASTER_KEY_FILE")
+
+	kind := strings.TrimSpace(strings.ToLower(os.Getenv("JINGUI_MASTER_KEY_PROVIDER")))
+	if kind == "" {
+		switch {
+		case envSet && filePath != "":
+			return nil, fmt.Errorf("set only one of JINGUI_MASTER_KEY and JINGUI_MASTER_KEY_FILE")
+		case filePath != "":
+			kind = "file"
+		case envSet:
+			kind = "env"
+		default:
+			return nil, fmt.Errorf("JINGUI_MASTER_KEY, JINGUI_MASTER_KEY_FILE or JINGUI_MASTER_KEY_PROVIDER is required")
+		}
+	}
+
+	switch kind {
+	case "env":
+		if !envSet {
+			return nil, fmt.Errorf("JINGUI_MASTER_KEY is required when JINGUI_MASTER_KEY_PROVIDER=env")
+		}
+		return &EnvKeyProvider{Var: "JINGUI_MASTER_KEY"}, nil
+	case "file":
+		if filePath == "" {
+			return nil, fmt.Errorf("JINGUI_MASTER_KEY_FILE is required when JINGUI_MASTER_KEY_PROVIDER=file")
+		}
+		return &FileKeyProvider{Path: filePath}, nil
+	case "dstack":
+		// Refuse a plaintext key next to a sealed one so an operator cannot
+		// believe the key is sealed while it also sits in the environment.
+		if envSet || filePath != "" {
+			return nil, fmt.Errorf("JINGUI_MASTER_KEY and JINGUI_MASTER_KEY_FILE must not be set when JINGUI_MASTER_KEY_PROVIDER=dstack")
+		}
+		path := os.Getenv("JINGUI_MASTER_KEY_DSTACK_PATH")
+		if path == "" {
+			path = "jingui-server/master-key"
+		}
+		return &DstackKeyProvider{Deriver: attestation.NewDstackKeyDeriver(""), Path: path}, nil
+	default:
+		return nil, fmt.Errorf("JINGUI_MASTER_KEY_PROVIDER must be one of env/file/dstack")
+	}
+}
+
+// loadPreviousMasterKeys reads the comma-separated list of retired master
+// keys from JINGUI_MASTER_KEY_PREVIOUS. They are only needed while a key
+// rotation is in progress.
+func loadPreviousMasterKeys() ([][]byte, error) {
+	var keys [][]byte
+	for _, raw := range strings.Split(os.Getenv("JINGUI_MASTER_KEY_PREVIOUS"), ",") {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+		key, err := decodeMasterKey("JINGUI_MASTER_KEY_PREVIOUS", raw)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func decodeMasterKey(source, raw string) ([]byte, error) {
+	key, err := hex.DecodeString(raw)
+	if err != nil || len(key) != db.MasterKeyLen {
+		return nil, fmt.Errorf("%s must contain %d hex characters (%d bytes)", source, db.MasterKeyLen*2, db.MasterKeyLen)
+	}
+	return key, nil
+}
@@ -0,0 +1,161 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aspect-build/jingui/internal/attestation"
+)
+
+// startFakeDstackAgent serves GetKey on a unix socket and returns the socket
+// path. The returned key depends on path and purpose, like the real agent.
+func startFakeDstackAgent(t *testing.T) string {
+	t.Helper()
+	// Unix socket paths are limited to ~100 bytes; t.TempDir() can exceed that.
+	dir, err := os.MkdirTemp("", "dstack")
+	if err != nil {
+		t.Fatalf("mkdir temp: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	sock := filepath.Join(dir, "dstack.sock")
+
+	ln, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/GetKey", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Path    string `json:"path"`
+			Purpose string `json:"purpose"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		key := bytes.Repeat([]byte{byte(len(req.Path) + len(req.Purpose))}, 32)
+		json.NewEncoder(w).Encode(map[string]any{
+			"key":             hex.EncodeToString(key),
+			"signature_chain": []string{},
+		})
+	})
+	srv := &http.Server{Handler: mux}
+	go srv.Serve(ln)
+	t.Cleanup(func() { srv.Close() })
+	return sock
+}
+
+func TestDstackKeyProvider(t *testing.T) {
+	sock := startFakeDstackAgent(t)
+	ctx := context.Background()
+
+	p := &DstackKeyProvider{Deriver: attestation.NewDstackKeyDeriver(sock), Path: "jingui-server/master-key"}
+	key, err := p.MasterKey(ctx)
+	if err != nil {
+		t.Fatalf("MasterKey: %v", err)
+	}
+	if len(key) != 32 {
+		t.Fatalf("key length = %d, want 32", len(key))
+	}
+
+	again, err := p.MasterKey(ctx)
+	if err != nil {
+		t.Fatalf("MasterKey: %v", err)
+	}
+	if !bytes.Equal(key, again) {
+		t.Fatal("derived key is not stable across calls")
+	}
+
+	other := &DstackKeyProvider{Deriver: attestation.NewDstackKeyDeriver(sock), Path: "other"}
+	otherKey, err := other.MasterKey(ctx)
+	if err != nil {
+		t.Fatalf("MasterKey: %v", err)
+	}
+	if bytes.Equal(key, otherKey) {
+		t.Fatal("different paths must derive different keys")
+	}
+}
+
+func TestDstackKeyProvider_AgentUnavailable(t *testing.T) {
+	p := &DstackKeyProvider{
+		Deriver: attestation.NewDstackKeyDeriver(filepath.Join(t.TempDir(), "missing.sock")),
+		Path:    "jingui-server/master-key",
+	}
+	if _, err := p.MasterKey(context.Background()); err == nil {
+		t.Fatal("expected error when the agent is unreachable")
+	}
+}
+
+func TestLoadKeyProvider(t *testing.T) {
+	keyHex := strings.Repeat("ab", 32)
+	keyFile := filepath.Join(t.TempDir(), "master.key")
+	os.WriteFile(keyFile, []byte(keyHex+"\n"), 0o600)
+
+	tests := []struct {
+		name     string
+		env      map[string]string
+		wantName string
+		wantErr  bool
+	}{
+		{name: "env inferred", env: map[string]string{"JINGUI_MASTER_KEY": keyHex}, wantName: "env:JINGUI_MASTER_KEY"},
+		{name: "file inferred", env: map[string]string{"JINGUI_MASTER_KEY_FILE": keyFile}, wantName: "file:" + keyFile},
+		{name: "both set", env: map[string]string{"JINGUI_MASTER_KEY": keyHex, "JINGUI_MASTER_KEY_FILE": keyFile}, wantErr: true},
+		{name: "none set", env: map[string]string{}, wantErr: true},
+		{name: "dstack", env: map[string]string{"JINGUI_MASTER_KEY_PROVIDER": "dstack"}, wantName: "dstack:jingui-server/master-key"},
+		{name: "dstack custom path", env: map[string]string{"JINGUI_MASTER_KEY_PROVIDER": "dstack", "JINGUI_MASTER_KEY_DSTACK_PATH": "prod"}, wantName: "dstack:prod"},
+		{name: "dstack with plaintext key", env: map[string]string{"JINGUI_MASTER_KEY_PROVIDER": "dstack", "JINGUI_MASTER_KEY": keyHex}, wantErr: true},
+		{name: "explicit file missing path", env: map[string]string{"JINGUI_MASTER_KEY_PROVIDER": "file"}, wantErr: true},
+		{name: "unknown", env: map[string]string{"JINGUI_MASTER_KEY_PROVIDER": "vault"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, k := range []string{"JINGUI_MASTER_KEY", "JINGUI_MASTER_KEY_FILE", "JINGUI_MASTER_KEY_PROVIDER", "JINGUI_MASTER_KEY_DSTACK_PATH"} {
+				t.Setenv(k, tt.env[k])
+			}
+			p, err := loadKeyProvider()
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got provider %s", p.Name())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("loadKeyProvider: %v", err)
+			}
+			if p.Name() != tt.wantName {
+				t.Errorf("Name() = %q, want %q", p.Name(), tt.wantName)
+			}
+		})
+	}
+}
+
+func TestFileAndEnvKeyProviders(t *testing.T) {
+	keyHex := strings.Repeat("ab", 32)
+	want, _ := hex.DecodeString(keyHex)
+
+	t.Setenv("JINGUI_TEST_MASTER_KEY", keyHex)
+	got, err := (&EnvKeyProvider{Var: "JINGUI_TEST_MASTER_KEY"}).MasterKey(context.Background())
+	if err != nil || !bytes.Equal(got, want) {
+		t.Fatalf("EnvKeyProvider = %x, %v", got, err)
+	}
+
+	path := filepath.Join(t.TempDir(), "master.key")
+	os.WriteFile(path, []byte(keyHex+"\n"), 0o600)
+	got, err = (&FileKeyProvider{Path: path}).MasterKey(context.Background())
+	if err != nil || !bytes.Equal(got, want) {
+		t.Fatalf("FileKeyProvider = %x, %v", got, err)
+	}
+
+	os.WriteFile(path, []byte("not-hex"), 0o600)
+	if _, err := (&FileKeyProvider{Path: path}).MasterKey(context.Background()); err == nil {
+		t.Fatal("expected error for malformed key file")
+	}
+}
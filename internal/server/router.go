@@ -3,12 +3,14 @@ package server
 import (
 	"github.com/aspect-build/jingui/internal/attestation"
 	"github.com/aspect-build/jingui/internal/server/db"
+	"github.com/aspect-build/jingui/internal/server/eventsink"
 	"github.com/aspect-build/jingui/internal/server/handler"
 	"github.com/gin-gonic/gin"
 )
 
-// NewRouter creates and configures the Gin router with all routes.
-func NewRouter(store *db.Store, cfg *Config) *gin.Engine {
+// NewRouter creates and configures the Gin router with all routes. Audit and
+// security events are published to sinks, which may be nil.
+func NewRouter(store db.Store, cfg *Config, sinks *eventsink.Dispatcher) *gin.Engine {
 	r := gin.Default()
 
 	if len(cfg.CORSOrigins) > 0 {
@@ -21,46 +23,104 @@ func NewRouter(store *db.Store, cfg *Config) *gin.Engine {
 	r.StaticFile("/openapi.json", "docs/openapi.json")
 
 	admin := AdminAuth(cfg.AdminToken)
+	audit := func(action string) gin.HandlerFunc { return handler.AuditAdmin(store, sinks, action) }
 	verifier := attestation.NewRATLSVerifier()
 	collector := attestation.NewDstackInfoCollector("")
 
 	v1 := r.Group("/v1")
 	{
 		// Vaults
-		v1.POST("/vaults", admin, handler.HandleCreateVault(store))
+		v1.POST("/vaults", admin, audit("vault.create"), handler.HandleCreateVault(store))
 		v1.GET("/vaults", admin, handler.HandleListVaults(store))
 		v1.GET("/vaults/:id", admin, handler.HandleGetVault(store))
-		v1.PUT("/vaults/:id", admin, handler.HandleUpdateVault(store))
-		v1.DELETE("/vaults/:id", admin, handler.HandleDeleteVault(store))
+		v1.PUT("/vaults/:id", admin, audit("vault.update"), handler.HandleUpdateVault(store))
+		v1.DELETE("/vaults/:id", admin, audit("vault.delete"), handler.HandleDeleteVault(store))
 
 		// Vault items
 		v1.GET("/vaults/:id/items", admin, handler.HandleListItems(store))
-		v1.GET("/vaults/:id/items/:section", admin, handler.HandleGetItem(store))
-		v1.PUT("/vaults/:id/items/:section", admin, handler.HandlePutItem(store))
-		v1.DELETE("/vaults/:id/items/:section", admin, handler.HandleDeleteItem(store))
+		v1.GET("/vaults/:id/items/:item", admin, handler.HandleGetItem(store))
+		v1.PUT("/vaults/:id/items/:item", admin, audit("item.put"), handler.HandlePutItem(store))
+		v1.DELETE("/vaults/:id/items/:item", admin, audit("item.delete"), handler.HandleDeleteItem(store))
+		v1.GET("/vaults/:id/items/:item/:field/versions", admin, handler.HandleListFieldVersions(store))
+		v1.POST("/vaults/:id/items/:item/:field/versions", admin, audit("field.rollback"), handler.HandleRollbackField(store))
+		v1.PUT("/vaults/:id/items/:item/expiry", admin, audit("expiry.set"), handler.HandlePutExpiry(store))
+		v1.DELETE("/vaults/:id/items/:item/expiry", admin, audit("expiry.clear"), handler.HandleDeleteExpiry(store))
+		v1.GET("/vaults/:id/expiring", admin, handler.HandleListExpiring(store))
+		v1.POST("/vaults/:id/import", admin, audit("vault.import"), handler.HandleImport(store))
 
 		// Vault ↔ Instance access
 		v1.GET("/vaults/:id/instances", admin, handler.HandleListVaultInstances(store))
-		v1.POST("/vaults/:id/instances/:fid", admin, handler.HandleGrantVaultAccess(store))
-		v1.DELETE("/vaults/:id/instances/:fid", admin, handler.HandleRevokeVaultAccess(store))
+		v1.POST("/vaults/:id/instances/:fid", admin, audit("access.grant"), handler.HandleGrantVaultAccess(store))
+		v1.DELETE("/vaults/:id/instances/:fid", admin, audit("access.revoke"), handler.HandleRevokeVaultAccess(store))
+		v1.GET("/vaults/:id/apps", admin, handler.HandleListVaultApps(store))
+		v1.POST("/vaults/:id/apps/:app_id", admin, audit("app_access.grant"), handler.HandleGrantAppAccess(store))
+		v1.DELETE("/vaults/:id/apps/:app_id", admin, audit("app_access.revoke"), handler.HandleRevokeAppAccess(store))
+		v1.GET("/vaults/:id/grants", admin, handler.HandleListFieldGrants(store))
+		v1.PUT("/vaults/:id/grants", admin, audit("grant.put"), handler.HandlePutFieldGrant(store))
+		v1.DELETE("/vaults/:id/grants", admin, audit("grant.delete"), handler.HandleDeleteFieldGrant(store))
 
 		// Instances
-		v1.POST("/instances", admin, handler.HandleRegisterInstance(store))
+		v1.POST("/instances", admin, audit("instance.register"), handler.HandleRegisterInstance(store))
 		v1.GET("/instances", admin, handler.HandleListInstances(store))
 		v1.GET("/instances/:fid", admin, handler.HandleGetInstance(store))
-		v1.PUT("/instances/:fid", admin, handler.HandleUpdateInstance(store))
-		v1.DELETE("/instances/:fid", admin, handler.HandleDeleteInstance(store))
+		v1.PUT("/instances/:fid", admin, audit("instance.update"), handler.HandleUpdateInstance(store))
+		v1.DELETE("/instances/:fid", admin, audit("instance.delete"), handler.HandleDeleteInstance(store))
+		v1.PUT("/instances/:fid/status", admin, audit("instance.status"), handler.HandleSetInstanceStatus(store))
+
+		// Enrollment policies
+		v1.GET("/enrollment-policies", admin, handler.HandleListEnrollmentPolicies(store))
+		v1.PUT("/enrollment-policies/:app_id", admin, audit("enrollment_policy.put"), handler.HandlePutEnrollmentPolicy(store))
+		v1.DELETE("/enrollment-policies/:app_id", admin, audit("enrollment_policy.delete"), handler.HandleDeleteEnrollmentPolicy(store))
+
+		// Enrollment tokens
+		v1.POST("/enrollment-tokens", admin, audit("enrollment_token.create"), handler.HandleCreateEnrollmentToken(store))
+		v1.GET("/enrollment-tokens", admin, handler.HandleListEnrollmentTokens(store))
+		v1.DELETE("/enrollment-tokens/:token_id", admin, audit("enrollment_token.delete"), handler.HandleDeleteEnrollmentToken(store))
+
+		// Measurement policies
+		v1.GET("/measurement-policies", admin, handler.HandleListMeasurementPolicies(store))
+		v1.GET("/vaults/:id/measurement-policy", admin, handler.HandleGetMeasurementPolicy(store, db.MeasurementScopeVault))
+		v1.PUT("/vaults/:id/measurement-policy", admin, audit("measurement_policy.put"), handler.HandlePutMeasurementPolicy(store, db.MeasurementScopeVault))
+		v1.DELETE("/vaults/:id/measurement-policy", admin, audit("measurement_policy.delete"), handler.HandleDeleteMeasurementPolicy(store, db.MeasurementScopeVault))
+		v1.GET("/instances/:fid/measurement-policy", admin, handler.HandleGetMeasurementPolicy(store, db.MeasurementScopeInstance))
+		v1.PUT("/instances/:fid/measurement-policy", admin, audit("measurement_policy.put"), handler.HandlePutMeasurementPolicy(store, db.MeasurementScopeInstance))
+		v1.DELETE("/instances/:fid/measurement-policy", admin, audit("measurement_policy.delete"), handler.HandleDeleteMeasurementPolicy(store, db.MeasurementScopeInstance))
 
 		// Debug policy
 		v1.GET("/debug-policy/:vault/:fid", admin, handler.HandleGetDebugPolicy(store))
-		v1.PUT("/debug-policy/:vault/:fid", admin, handler.HandlePutDebugPolicy(store))
+		v1.PUT("/debug-policy/:vault/:fid", admin, audit("debug_policy.set"), handler.HandlePutDebugPolicy(store))
+
+		// Trash
+		v1.GET("/trash", admin, handler.HandleListTrash(store, cfg.TrashRetention))
+		v1.POST("/trash/vaults/:id/restore", admin, audit("trash.restore_vault"), handler.HandleRestoreVault(store))
+		v1.DELETE("/trash/vaults/:id", admin, audit("trash.purge_vault"), handler.HandlePurgeVault(store))
+		v1.POST("/trash/vaults/:id/items/:item/restore", admin, audit("trash.restore_item"), handler.HandleRestoreItem(store))
+		v1.DELETE("/trash/vaults/:id/items/:item", admin, audit("trash.purge_item"), handler.HandlePurgeItem(store))
+		v1.POST("/trash/instances/:fid/restore", admin, audit("trash.restore_instance"), handler.HandleRestoreInstance(store))
+		v1.DELETE("/trash/instances/:fid", admin, audit("trash.purge_instance"), handler.HandlePurgeInstance(store))
+
+		// Audit log
+		v1.GET("/audit", admin, handler.HandleListAudit(store))
 
-		// Client proof-of-possession challenge (no admin auth).
-		v1.POST("/secrets/challenge", handler.HandleIssueChallenge(store, cfg.RATLSStrict, verifier, collector))
+		// Backup
+		v1.GET("/backup", admin, audit("backup.download"), handler.HandleBackup(store, cfg.BackupPublicKey))
+
+		// Attested self-enrollment (no admin auth).
+		v1.POST("/enroll", handler.AuditClient(store, sinks, "instance.enroll", false), handler.HandleEnrollInstance(store, verifier, sinks))
+
+		// Registration with an enrollment token (no admin auth).
+		v1.POST("/register", handler.AuditClient(store, sinks, "instance.register_token", false), handler.HandleRegisterWithToken(store))
+
+		// Client proof-of-possession challenge (no admin auth). Only failed
+		// challenges are audited; the fetch that follows records the rest.
+		v1.POST("/secrets/challenge", handler.AuditClient(store, sinks, "secrets.challenge", true), handler.HandleIssueChallenge(store, cfg.RATLSStrict, verifier, collector, sinks))
 
 		// Secret fetch — requires proof-of-possession challenge response, then returns
 		// payload encrypted to the registered TEE public key.
-		v1.POST("/secrets/fetch", handler.HandleFetchSecrets(store, cfg.RATLSStrict))
+		v1.POST("/secrets/fetch", handler.AuditClient(store, sinks, "secrets.fetch", false), handler.HandleFetchSecrets(store, cfg.RATLSStrict, sinks))
+
+		// Key rotation — answers a challenge with the current key (no admin auth).
+		v1.POST("/rekey", handler.AuditClient(store, sinks, "instance.rekey", false), handler.HandleRekeyInstance(store, cfg.RATLSStrict, sinks))
 	}
 
 	return r
@@ -0,0 +1,36 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/aspect-build/jingui/internal/logx"
+	"github.com/aspect-build/jingui/internal/server/db"
+)
+
+// DefaultTrashRetention is how long deleted vaults, sections and instances
+// stay restorable when JINGUI_TRASH_RETENTION is not set.
+const DefaultTrashRetention = 30 * 24 * time.Hour
+
+// trashPurgeInterval is how often PurgeTrashLoop looks for expired trash.
+const trashPurgeInterval = time.Hour
+
+// PurgeTrashLoop permanently deletes trash older than retention, once at
+// startup and then every trashPurgeInterval, until ctx is done.
+func PurgeTrashLoop(ctx context.Context, store db.Store, retention time.Duration) {
+	ticker := time.NewTicker(trashPurgeInterval)
+	defer ticker.Stop()
+	for {
+		if n, err := db.PurgeTrash(store, time.Now().Add(-retention)); err != nil {
+			logx.Errorf("purge trash: %v", err)
+		} else if n > 0 {
+			logx.Infof("purged %d trash entries older than %s", n, retention)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}